@@ -14,11 +14,80 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
 	"github.com/containerd/containerd/runtime/v2/shim"
 
+	"github.com/google/gvisor-containerd-shim/pkg/v1/admin"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/proc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/utils"
 	runsc "github.com/google/gvisor-containerd-shim/pkg/v2"
+	"github.com/google/gvisor-containerd-shim/pkg/v2/options"
+	"github.com/google/gvisor-containerd-shim/pkg/version"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "collect-debug-logs" {
+		if err := collectDebugLogs(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "containerd-shim-runsc-v1: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == admin.Command {
+		if err := admin.Run(context.Background(), "containerd-shim-runsc-v1", os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "containerd-shim-runsc-v1: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "version" {
+		data, err := json.MarshalIndent(version.Get(), "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "containerd-shim-runsc-v1: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s\n", data)
+		return
+	}
+	os.Args = cleanupArgs(os.Args)
 	shim.Run("io.containerd.runsc.v1", runsc.New)
 }
+
+// cleanupArgs turns the -cleanup flag into the delete command, which cleans
+// up after a shim that is gone, e.g.
+// `containerd-shim-runsc-v1 -namespace <ns> -id <id> -bundle <bundle> -cleanup`.
+func cleanupArgs(args []string) []string {
+	for i, arg := range args[1:] {
+		if arg == "-cleanup" || arg == "--cleanup" {
+			out := append([]string{}, args[:i+1]...)
+			return append(append(out, args[i+2:]...), "delete")
+		}
+	}
+	return args
+}
+
+// collectDebugLogs writes a tar.gz of the runsc debug logs of a container to
+// stdout, e.g. `containerd-shim-runsc-v1 collect-debug-logs -id <id>`.
+func collectDebugLogs(args []string) error {
+	fs := flag.NewFlagSet("collect-debug-logs", flag.ExitOnError)
+	id := fs.String("id", "", "id of the container")
+	namespace := fs.String("namespace", "", "containerd namespace of the container")
+	config := fs.String("config", filepath.Join(proc.RunscRoot, "config.toml"), "path to the runsc shim config file")
+	fs.Parse(args)
+	if *id == "" {
+		return fmt.Errorf("container id must be provided with -id")
+	}
+	var opts options.Options
+	if _, err := toml.DecodeFile(*config, &opts); err != nil {
+		return fmt.Errorf("decode config file %q: %v", *config, err)
+	}
+	flags, _ := opts.RunscFlags()
+	return utils.CollectDebugLogs(os.Stdout, *namespace, *id, flags)
+}
@@ -16,9 +16,15 @@ limitations under the License.
 
 package main
 
-import "github.com/BurntSushi/toml"
+import (
+	"github.com/BurntSushi/toml"
 
-// config is the configuration for gvisor containerd shim.
+	"github.com/google/gvisor-containerd-shim/pkg/v1/utils"
+)
+
+// config is the configuration for gvisor containerd shim. The shim reloads
+// it on SIGHUP, applying the changes of the settings that can change while
+// the container runs, see reloadableSettings.
 type config struct {
 	// RuncShim is the shim binary path for standard containerd-shim for runc.
 	// When the runtime is `runc`, gvisor containerd shim will exec current
@@ -27,8 +33,279 @@ type config struct {
 	// based on runtime.
 	RuncShim string `toml:"runc_shim"`
 	// RunscConfig is configuration for runsc. The key value will be converted
-	// to runsc flags --key=value directly.
+	// to runsc flags --key=value directly. In the debug-log and user-log
+	// paths, %ID%, %NAMESPACE% and %DATE% are replaced with the container
+	// id, its namespace and the date it is created on.
 	RunscConfig map[string]string `toml:"runsc_config"`
+	// UncheckedRunscFlags are runsc_config flags passed without validation,
+	// e.g. flags of a runsc release newer than the shim. Other flags that
+	// aren't known runsc flags with valid values are logged as warnings.
+	UncheckedRunscFlags []string `toml:"unchecked_runsc_flags"`
+	// LogLevel is the level of the shim logs, e.g. "info". Defaults to
+	// "info", or "debug" with the -debug flag, which takes precedence.
+	LogLevel string `toml:"log_level"`
+	// StatsInterval is the interval at which sandbox resource usage is
+	// sampled, e.g. "10s". A negative interval disables sampling.
+	StatsInterval utils.Duration `toml:"stats_interval"`
+	// StatsStream reads the resource usage from a long running
+	// `runsc events` child, restarted if it dies, instead of running runsc
+	// for every sample. The stream also reports OOMs as TaskOOM events.
+	StatsStream bool `toml:"stats_stream"`
+	// MemoryThreshold is the fraction of the sandbox memory limit above
+	// which a memory threshold event is published. Defaults to 0.9.
+	MemoryThreshold float64 `toml:"memory_threshold"`
+	// WatchdogMemoryLimit is a hard cap in bytes on the memory usage of the
+	// host cgroup of the sandbox. A sandbox above the cap for longer than
+	// WatchdogGrace is killed and exits with status 250. Zero disables it.
+	WatchdogMemoryLimit uint64 `toml:"watchdog_memory_limit"`
+	// WatchdogPidsLimit is a hard cap on the number of host tasks of the
+	// sandbox, enforced like WatchdogMemoryLimit. Zero disables it.
+	WatchdogPidsLimit uint64 `toml:"watchdog_pids_limit"`
+	// WatchdogGrace is how long a watchdog cap may be exceeded before the
+	// sandbox is killed, e.g. "1m". Defaults to 30s. The caps are checked
+	// when resource usage is sampled.
+	WatchdogGrace utils.Duration `toml:"watchdog_grace"`
+	// SyscallWarningsInterval is how often the runsc debug logs of a sandbox
+	// are scanned for the syscalls the application attempted that gVisor
+	// doesn't implement, e.g. "1m". Those found are published in an
+	// unsupported syscalls event. It requires debug-log in the runsc
+	// config. Zero, the default, disables it.
+	SyscallWarningsInterval utils.Duration `toml:"syscall_warnings_interval"`
+	// SocketType is the type of the shim socket when containerd does not pass
+	// one, either "abstract" (the default) or "filesystem".
+	SocketType string `toml:"socket_type"`
+	// SocketTypes overrides SocketType for the namespaces it contains.
+	SocketTypes map[string]string `toml:"socket_types"`
+	// DisableAbstractSocket refuses to serve on an abstract socket, which
+	// any process of the network namespace of the shim can connect to. The
+	// default socket type becomes "filesystem".
+	DisableAbstractSocket bool `toml:"disable_abstract_socket"`
+	// SocketMode is the permission of a filesystem shim socket, in octal,
+	// e.g. "0660". Defaults to "0600".
+	SocketMode string `toml:"socket_mode"`
+	// SocketGroup is the group, by name or gid, owning a filesystem shim
+	// socket. Defaults to the group of the shim.
+	SocketGroup string `toml:"socket_group"`
+	// PeerUID is the uid allowed to connect to the shim socket, -1 for
+	// any. Defaults to the uid of the containerd process that started the
+	// shim. The -peer-uid flag takes precedence.
+	PeerUID *int `toml:"peer_uid"`
+	// PeerGID is the gid allowed to connect to the shim socket, like
+	// PeerUID.
+	PeerGID *int `toml:"peer_gid"`
+	// DebugSocketDir enables pprof and trace endpoints on a unix socket
+	// named <namespace>-<id>.sock in this directory.
+	DebugSocketDir string `toml:"debug_socket_dir"`
+	// SentryMetrics serves the metrics exported by the gVisor sentry of
+	// the sandbox at /metrics on the debug socket, in the Prometheus text
+	// format and labelled with the container id. Requires debug_socket_dir.
+	SentryMetrics bool `toml:"sentry_metrics"`
+	// EventsSocketDir streams the events of the shim, including internal
+	// lifecycle events such as io closed and gofer exits, as JSON lines on a
+	// unix socket named <namespace>-<id>.sock in this directory.
+	EventsSocketDir string `toml:"events_socket_dir"`
+	// EventsWebhook also posts the events published to containerd, as JSON
+	// objects with the fields of the events_socket_dir lines, to a local
+	// endpoint: an http URL, or a unix socket as unix:///path. Events are
+	// dropped when the endpoint falls behind.
+	EventsWebhook string `toml:"events_webhook"`
+	// OTLPEndpoint exports traces of the shim requests and runsc commands
+	// to an OpenTelemetry collector with OTLP over HTTP, e.g.
+	// "http://localhost:4318". When empty the standard
+	// OTEL_EXPORTER_OTLP_ENDPOINT variables are used, if set.
+	OTLPEndpoint string `toml:"otlp_endpoint"`
+	// SignalMap translates signals before they are sent to the sandbox,
+	// e.g. {"SIGPWR" = "SIGTERM"}. Signals mapped to "reject" are refused.
+	SignalMap map[string]string `toml:"signal_map"`
+	// EventQueueSize bounds the number of events waiting to be published
+	// to containerd. Zero, the default, leaves the queue unbounded.
+	EventQueueSize int `toml:"event_queue_size"`
+	// EventOverflowPolicy is applied when the event queue is full, one of
+	// "block", "drop-oldest" (the default) and "drop-with-metric".
+	EventOverflowPolicy string `toml:"event_overflow_policy"`
+	// WorkRoot is the root of per container work directories, laid out as
+	// <work_root>/<namespace>/<id> and removed on Delete. When empty the
+	// work directory provided by containerd is used.
+	WorkRoot string `toml:"work_root"`
+	// RunHooks runs the prestart, createRuntime, poststart and poststop
+	// hooks of the OCI spec on the host, as gVisor doesn't run them. Hooks
+	// run with the privileges of the shim, so this is off by default.
+	RunHooks bool `toml:"run_hooks"`
+	// ChownHelper is the path, inside the container, of the chown binary
+	// run as root before the entrypoint to give the volumes listed in the
+	// dev.gvisor.chown-volumes annotation to the user of the container,
+	// e.g. "/bin/chown". Empty, the default, ignores the annotation.
+	ChownHelper string `toml:"chown_helper"`
+	// RuncBinary is the runc binary containers annotated with
+	// dev.gvisor.untrusted=false are run with, outside of a sandbox, so
+	// that trusted pods can share the runtime handler of untrusted ones,
+	// e.g. "/usr/bin/runc". Empty, the default, runs them in a sandbox like
+	// the others.
+	RuncBinary string `toml:"runc_binary"`
+	// OOMScoreOffset is added to the oomScoreAdj of the spec of each
+	// container to get the OOM score adjustment of its runsc sandbox and
+	// gofer processes, so that the OOM killer of the node kills the right
+	// sandbox. Zero, the default, applies the oomScoreAdj of the spec as is.
+	OOMScoreOffset int `toml:"oom_score_offset"`
+	// UserLogToStdout forwards the user log of a sandbox, e.g. the kernel
+	// messages of the application, to the stdout of its container when no
+	// user-log path is configured. Containers with a terminal are not
+	// forwarded.
+	UserLogToStdout bool `toml:"user_log_to_stdout"`
+	// HoldNamespaces keeps the namespaces a container joins by path, such
+	// as the network namespace prepared by CNI, open until the container is
+	// deleted, so that they can't be torn down while runsc still uses them.
+	// The namespaces are checked to exist and be of the right type either
+	// way.
+	HoldNamespaces bool `toml:"hold_namespaces"`
+	// CheckpointCompression compresses the image files of checkpoints,
+	// "none" (the default), "gzip" or "zstd", which requires the zstd
+	// binary. Checkpoints are stored with a manifest of checksums and the
+	// runsc version, and can be restored on another node running the same
+	// runsc release.
+	CheckpointCompression string `toml:"checkpoint_compression"`
+	// PerfProfile is the performance profile of sandboxes, a named set of
+	// runsc flags taking precedence over runsc_config: "throughput" for
+	// services moving a lot of data, "latency" for short round trips,
+	// "dense" for nodes packed with small pods, or one of perf_profiles.
+	// Pods select another with the dev.gvisor.perf-profile annotation.
+	// Unset applies no profile.
+	PerfProfile string `toml:"perf_profile"`
+	// PerfProfiles are additional profiles, as tables of runsc flags,
+	// e.g. [perf_profiles.mine]. They replace the built-in profiles of the
+	// same name.
+	PerfProfiles map[string]map[string]string `toml:"perf_profiles"`
+	// FileAccess is the file access of the root filesystem, "exclusive" or
+	// "shared". Pods override it with the dev.gvisor.file-access annotation.
+	FileAccess string `toml:"file_access"`
+	// FileAccessMounts is the file access of mounts such as emptyDir and
+	// other shared volumes, "exclusive" or "shared". Pods override it with
+	// the dev.gvisor.file-access-mounts annotation.
+	FileAccessMounts string `toml:"file_access_mounts"`
+	// DirectFS lets the sentry access the container filesystem directly
+	// instead of through the gofer. Unset keeps the runsc default. Pods
+	// override it with the dev.gvisor.directfs annotation.
+	DirectFS *bool `toml:"directfs"`
+	// Lisafs selects the lisafs protocol between the sentry and the gofer.
+	// Unset keeps the runsc default. Pods override it with the
+	// dev.gvisor.lisafs annotation.
+	Lisafs *bool `toml:"lisafs"`
+	// Strict refuses to create containers when the runtime binary doesn't
+	// identify itself as runsc in its --version output. Otherwise a
+	// mismatch is only logged and published as an event.
+	Strict bool `toml:"strict"`
+	// StrictSeccomp refuses to create containers whose seccomp profile uses
+	// actions or fields gVisor doesn't implement. Otherwise the profile is
+	// rewritten into its gVisor equivalent and the changes are published.
+	StrictSeccomp bool `toml:"strict_seccomp"`
+	// KeepArtifacts keeps the work directory contents, console sockets and
+	// per container runsc logs of deleted containers for postmortem
+	// debugging. By default they are removed on Delete.
+	KeepArtifacts bool `toml:"keep_artifacts"`
+	// CollectCrashLogs copies the runsc debug log showing a crash of the
+	// sandbox into the work directory, where it is kept when the container
+	// is deleted. Requires runsc debug logs, see debug-log in runsc_config.
+	CollectCrashLogs bool `toml:"collect_crash_logs"`
+	// MountWorkers bounds the rootfs mounts done concurrently. Defaults to 4.
+	MountWorkers int `toml:"mount_workers"`
+	// UnmountRetries is the number of times a busy rootfs unmount is
+	// retried, e.g. while the gofer still holds files open. Defaults to 50.
+	UnmountRetries int `toml:"unmount_retries"`
+	// UnmountBackoff is the delay between unmount retries, e.g. "100ms".
+	// Defaults to 50ms.
+	UnmountBackoff utils.Duration `toml:"unmount_backoff"`
+	// LogMounts logs the rootfs mounts and unmounts with their options,
+	// e.g. to debug the overlay options of a snapshotter.
+	LogMounts bool `toml:"log_mounts"`
+	// MountWatchdogInterval is how often the mounts left under the bundle
+	// of a deleted container are retried, e.g. "5m", backing off up to
+	// 30m. Leaked mounts are looked for on delete. Defaults to 1m.
+	MountWatchdogInterval utils.Duration `toml:"mount_watchdog_interval"`
+	// CreateTimeout bounds runsc create, e.g. "2m". A container whose
+	// creation times out is force deleted and Create fails with
+	// DeadlineExceeded. Defaults to 5m.
+	CreateTimeout utils.Duration `toml:"create_timeout"`
+	// StartTimeout bounds runsc start. A container whose start times out is
+	// killed and Start fails with DeadlineExceeded. Defaults to 5m.
+	StartTimeout utils.Duration `toml:"start_timeout"`
+	// ExecTimeout bounds runsc exec. An exec process whose start times out
+	// is killed and Start fails with DeadlineExceeded. Defaults to 5m.
+	ExecTimeout utils.Duration `toml:"exec_timeout"`
+	// DeleteTimeout bounds runsc delete, e.g. "30s". A container whose
+	// delete fails or times out, e.g. on a wedged sentry, is force deleted:
+	// runsc delete --force kills the sandbox, its gofers are killed and its
+	// rootfs is lazily unmounted. Defaults to 60s.
+	DeleteTimeout utils.Duration `toml:"delete_timeout"`
+	// IOTimeout bounds how long containerd is given to open its end of the
+	// output fifos of a process, e.g. "10s". Create and Exec fail with
+	// FailedPrecondition when it doesn't. Defaults to 30s.
+	IOTimeout utils.Duration `toml:"io_timeout"`
+	// RunscConcurrency bounds the number of runsc create, start, restore,
+	// checkpoint and delete commands run at once by all the shims of a
+	// namespace, so that pod churn doesn't thrash the node. Commands wait
+	// for their turn. Unbounded by default.
+	RunscConcurrency int `toml:"runsc_concurrency"`
+	// RunscRate bounds the number of those commands started per second by
+	// all the shims of a namespace, e.g. 5. Unbounded by default.
+	RunscRate float64 `toml:"runsc_rate"`
+	// LineBufferStderr copies the stderr of processes in whole lines: a
+	// partial line is held until its newline, or for at most 1s, so that
+	// the lines of containers sharing a log pipeline aren't chopped, e.g.
+	// JSON log entries.
+	LineBufferStderr bool `toml:"line_buffer_stderr"`
+	// TerminalStdinEOF is what is done when the client closes the stdin of
+	// a process with a terminal: "keep-open" leaves the terminal as is, as
+	// docker attach does, "close" writes the end of file character (^D) to
+	// the terminal once the input reached it, and "hangup" also sends
+	// SIGHUP to the process. Defaults to keep-open.
+	TerminalStdinEOF string `toml:"terminal_stdin_eof"`
+	// PauseOptimization lets the sandbox act as the pause process of a
+	// pod: the workload of the pause container, detected by its /pause
+	// command or the dev.gvisor.pause-container annotation, is not run,
+	// which saves memory per pod.
+	PauseOptimization bool `toml:"pause_optimization"`
+	// ShmSize is the size of the /dev/shm tmpfs of containers, e.g. "64m",
+	// mounted in the memory of the sandbox in place of the /dev/shm of the
+	// spec. Containers override it with the dev.gvisor.shm-size
+	// annotation. Empty keeps the /dev/shm of the spec.
+	ShmSize string `toml:"shm_size"`
+	// TeardownPolicy is how the container is stopped when the shim receives
+	// SIGTERM or SIGINT: "kill" kills it right away, "wait" gives it
+	// TeardownTimeout to exit first. Defaults to "kill".
+	TeardownPolicy string `toml:"teardown_policy"`
+	// TeardownTimeout is how long the "wait" teardown policy waits for the
+	// container to exit before killing it, e.g. "30s".
+	TeardownTimeout utils.Duration `toml:"teardown_timeout"`
+	// NamespaceRuntimes overrides the runsc binary and root directory of
+	// the containers of a namespace, e.g.
+	// [namespace_runtimes.canary] binary = "/usr/local/bin/runsc-canary".
+	NamespaceRuntimes map[string]utils.Runtime `toml:"namespace_runtimes"`
+	// HandlerRuntimes overrides the runsc binary and root directory of the
+	// pods of a runtime handler, the handler of their Kubernetes
+	// RuntimeClass. Handler overrides take precedence over namespace ones.
+	HandlerRuntimes map[string]utils.Runtime `toml:"handler_runtimes"`
+	// Monitor selects how the exits of runsc processes are detected:
+	// "pidfd" waits for them through pidfds, which requires Linux 5.4,
+	// "reaper" relies on the SIGCHLD reaper of the shim, and "auto" (the
+	// default) uses pidfds when the kernel supports them.
+	Monitor string `toml:"monitor"`
+	// ShimCgroupParent is the cgroup v1 path, e.g. "/gvisor-shims", under
+	// which the shim moves itself into a cgroup of its own, shim-<pid>, to
+	// cap its memory and cpu. Sandboxes without a cgroups path in their
+	// spec stay in the cgroup of the shim.
+	ShimCgroupParent string `toml:"shim_cgroup_parent"`
+	// ShimMemoryLimit caps the memory of the shim cgroup in bytes. Requires
+	// ShimCgroupParent.
+	ShimMemoryLimit int64 `toml:"shim_memory_limit"`
+	// ShimCPUs caps the cpu time of the shim cgroup in cpus, e.g. 0.5.
+	// Requires ShimCgroupParent.
+	ShimCPUs float64 `toml:"shim_cpus"`
+	// ShimNoFile is the RLIMIT_NOFILE of the shim. Every process of the
+	// shim holds up to three fifos and a console, so it should cover the
+	// processes expected in its containers. The shim refuses to serve
+	// containers when the limit can't be set. Zero keeps the inherited
+	// limit.
+	ShimNoFile uint64 `toml:"shim_nofile"`
 }
 
 // loadConfig load gvisor containerd shim config from config file.
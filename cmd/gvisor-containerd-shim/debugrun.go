@@ -0,0 +1,168 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/containerd/containerd/namespaces"
+	rproc "github.com/containerd/containerd/runtime/proc"
+	"github.com/opencontainers/runc/libcontainer/system"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+
+	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
+	runscproc "github.com/google/gvisor-containerd-shim/pkg/v1/proc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/shim"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/utils"
+)
+
+// debugRunCommand runs a bundle in the foreground without containerd.
+const debugRunCommand = "debug-run"
+
+// debugNamespace is the namespace used by debug-run when none is given.
+const debugNamespace = "debug"
+
+// debugRun runs the container in bundle in the foreground through the same
+// process plumbing as the shim service, forwarding SIGINT and SIGTERM to the
+// container. It returns the exit status of the container.
+//
+// Usage: gvisor-containerd-shim [-config path] [-namespace ns] debug-run <bundle> [id]
+func debugRun(args []string) (int, error) {
+	if len(args) < 1 {
+		return 0, errors.Errorf("usage: %s <bundle> [id]", debugRunCommand)
+	}
+	bundle, err := filepath.Abs(args[0])
+	if err != nil {
+		return 0, err
+	}
+	id := filepath.Base(bundle)
+	if len(args) > 1 {
+		id = args[1]
+	}
+	ns := namespaceFlag
+	if ns == "" {
+		ns = debugNamespace
+	}
+	c, err := loadConfig(shimConfigFlag)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, errors.Wrap(err, "failed to load shim config")
+	}
+	spec, err := utils.ReadSpec(bundle)
+	if err != nil {
+		return 0, errors.Wrap(err, "read oci spec")
+	}
+
+	// Children are reaped from the start, as runsc create waits on the
+	// monitor before the container is running.
+	reap := make(chan os.Signal, 32)
+	signal.Notify(reap, unix.SIGCHLD)
+	runsc.Monitor = runscproc.DefaultReaper
+	if err := system.SetSubreaper(1); err != nil {
+		return 0, err
+	}
+	go func() {
+		for range reap {
+			if err := runscproc.DefaultReaper.Reap(); err != nil {
+				logrus.WithError(err).Error("reap exit status")
+			}
+		}
+	}()
+	signals := make(chan os.Signal, 32)
+	signal.Notify(signals, unix.SIGINT, unix.SIGTERM)
+
+	// The work directory holds the runsc logs and an empty rootfs, so that
+	// Delete doesn't unmount anything from the bundle.
+	work, err := ioutil.TempDir("", "gvisor-debug-run")
+	if err != nil {
+		return 0, err
+	}
+	rootfs := filepath.Join(work, "rootfs")
+	if err := os.Mkdir(rootfs, 0755); err != nil {
+		os.RemoveAll(work)
+		return 0, err
+	}
+	root := runtimeRootFlag
+	if filepath.Base(root) != "runsc" {
+		root = runscproc.RunscRoot
+	}
+	platform, err := shim.NewPlatform()
+	if err != nil {
+		os.RemoveAll(work)
+		return 0, err
+	}
+	defer platform.Close()
+	p := runscproc.New(id, runscproc.NewRunsc(root, work, ns, "", c.RunscConfig), rproc.Stdio{
+		Stdout: "/dev/stdout",
+		Stderr: "/dev/stderr",
+	})
+	p.Bundle = bundle
+	p.Rootfs = rootfs
+	p.WorkDir = work
+	p.CleanupWorkDir = true
+	p.Sandbox = utils.IsSandbox(spec)
+	p.SandboxID = utils.SandboxID(spec)
+	p.Monitor = runscproc.DefaultReaper
+	p.Platform = platform
+	p.RunHooks = c.RunHooks
+	p.Exits = runscproc.NewExits()
+	exits := p.Exits.Subscribe()
+
+	ctx := namespaces.WithNamespace(context.Background(), ns)
+	logger := logrus.WithFields(logrus.Fields{
+		"id":     id,
+		"bundle": bundle,
+	})
+	if err := p.Create(ctx, &runscproc.CreateConfig{
+		ID:     id,
+		Bundle: bundle,
+		Stdout: "/dev/stdout",
+		Stderr: "/dev/stderr",
+	}); err != nil {
+		os.RemoveAll(work)
+		return 0, errors.Wrap(err, "create")
+	}
+	defer func() {
+		if err := p.Delete(ctx); err != nil {
+			logger.WithError(err).Error("failed to delete container")
+		}
+	}()
+	if err := p.Start(ctx); err != nil {
+		return 0, errors.Wrap(err, "start")
+	}
+	logger.WithField("pid", p.Pid()).Debug("container started")
+	for {
+		select {
+		case s := <-signals:
+			if err := p.Kill(ctx, uint32(s.(syscall.Signal)), false); err != nil {
+				logger.WithError(err).Warnf("failed to forward %s", s)
+			}
+		case e := <-exits:
+			if e.ID != id {
+				continue
+			}
+			p.SetExited(e.Status)
+			return e.Status, nil
+		}
+	}
+}
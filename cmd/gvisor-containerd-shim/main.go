@@ -20,35 +20,48 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
-	"net"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"runtime"
 	"runtime/debug"
-	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/containerd/containerd/events"
+	"github.com/containerd/containerd/log"
 	"github.com/containerd/containerd/namespaces"
 	"github.com/containerd/containerd/runtime/v1/linux/proc"
-	containerdshim "github.com/containerd/containerd/runtime/v1/shim"
 	shimapi "github.com/containerd/containerd/runtime/v1/shim/v1"
 	"github.com/containerd/ttrpc"
 	"github.com/containerd/typeurl"
-	ptypes "github.com/gogo/protobuf/types"
 	"github.com/opencontainers/runc/libcontainer/system"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sys/unix"
 
+	"github.com/google/gvisor-containerd-shim/pkg/failpoint"
 	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
+	"github.com/google/gvisor-containerd-shim/pkg/tracing"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/admin"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/checkpoint"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/compat"
+	shimdebug "github.com/google/gvisor-containerd-shim/pkg/v1/debug"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/eventq"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/limits"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/localevents"
+	runscproc "github.com/google/gvisor-containerd-shim/pkg/v1/proc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/rpclog"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runscapi"
 	"github.com/google/gvisor-containerd-shim/pkg/v1/shim"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/stats"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/utils"
+	"github.com/google/gvisor-containerd-shim/pkg/version"
 )
 
 var (
@@ -60,6 +73,11 @@ var (
 	runtimeRootFlag      string
 	containerdBinaryFlag string
 	shimConfigFlag       string
+	collectDebugLogsFlag string
+	peerUIDFlag          int
+	peerGIDFlag          int
+	socketTypeFlag       string
+	dumpStacksFlag       bool
 )
 
 // ShimConfigPath is the default shim config file path.
@@ -78,10 +96,48 @@ func init() {
 	// The daemon invokes `containerd-shim -containerd-binary ...` with its own os.Executable() path.
 	flag.StringVar(&containerdBinaryFlag, "containerd-binary", "containerd", "path to containerd binary (used for `containerd publish`)")
 	flag.StringVar(&shimConfigFlag, "config", ShimConfigPath, "path to the shim configuration file")
+	flag.IntVar(&peerUIDFlag, "peer-uid", -1, "uid allowed to connect to the shim socket, -1 defers to peer_uid in the config, then to the uid of the containerd process that started the shim")
+	flag.IntVar(&peerGIDFlag, "peer-gid", -1, "gid allowed to connect to the shim socket, -1 defers to peer_gid in the config, then to the gid of the containerd process that started the shim")
+	flag.StringVar(&socketTypeFlag, "socket-type", "", "type of the shim socket, either abstract or filesystem; overrides the config")
+	flag.BoolVar(&dumpStacksFlag, "dump-stacks", true, "log goroutine stacks on SIGUSR1")
+	flag.StringVar(&collectDebugLogsFlag, "collect-debug-logs", "", "write a tar.gz of the runsc debug logs of the given container id to stdout and exit")
 	flag.Parse()
 }
 
 func main() {
+	if flag.Arg(0) == versionCommand {
+		if err := printVersion(); err != nil {
+			fmt.Fprintf(os.Stderr, "gvisor-containerd-shim: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if flag.Arg(0) == admin.Command {
+		if err := admin.Run(context.Background(), "gvisor-containerd-shim", flag.Args()[1:], os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "gvisor-containerd-shim: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if flag.Arg(0) == debugRunCommand {
+		if debugFlag {
+			logrus.SetLevel(logrus.DebugLevel)
+		}
+		status, err := debugRun(flag.Args()[1:])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "gvisor-containerd-shim: %s\n", err)
+			os.Exit(1)
+		}
+		os.Exit(status)
+	}
+	if collectDebugLogsFlag != "" {
+		if err := collectDebugLogs(collectDebugLogsFlag); err != nil {
+			fmt.Fprintf(os.Stderr, "gvisor-containerd-shim: %s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// This is a hack. Exec current process to run standard containerd-shim
 	// if runtime root is not `runsc`. We don't need this for shim v2 api.
 	if filepath.Base(runtimeRootFlag) != "runsc" {
@@ -115,6 +171,18 @@ func main() {
 	}
 }
 
+// versionCommand prints the build information of the shim as JSON.
+const versionCommand = "version"
+
+func printVersion() error {
+	data, err := json.MarshalIndent(version.Get(), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Printf("%s\n", data)
+	return err
+}
+
 // executeRuncShim execs current process to a containerd-shim process and
 // retains all flags and envs.
 func executeRuncShim() error {
@@ -137,6 +205,15 @@ func executeRuncShim() error {
 	return nil
 }
 
+// collectDebugLogs writes the runsc debug logs of the container id to stdout.
+func collectDebugLogs(id string) error {
+	c, err := loadConfig(shimConfigFlag)
+	if err != nil {
+		return errors.Wrap(err, "failed to load shim config")
+	}
+	return utils.CollectDebugLogs(os.Stdout, namespaceFlag, id, c.RunscConfig)
+}
+
 func executeShim() error {
 	// start handling signals as soon as possible so that things are properly reaped
 	// or if runtime exits before we hit the handler
@@ -145,38 +222,218 @@ func executeShim() error {
 		return err
 	}
 	dump := make(chan os.Signal, 32)
-	signal.Notify(dump, syscall.SIGUSR1)
+	if dumpStacksFlag {
+		signal.Notify(dump, syscall.SIGUSR1)
+	}
+	// SIGQUIT dumps the recent rpcs instead of killing the shim.
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGQUIT)
 
 	path, err := os.Getwd()
 	if err != nil {
 		return err
 	}
-	server, err := ttrpc.NewServer(ttrpc.WithServerHandshaker(ttrpc.UnixSocketRequireSameUser()))
-	if err != nil {
-		return errors.Wrap(err, "failed creating server")
-	}
 	c, err := loadConfig(shimConfigFlag)
 	if err != nil && !os.IsNotExist(err) {
 		return errors.Wrap(err, "failed to load shim config")
 	}
+	if err := setLogLevel(c); err != nil {
+		return errors.Wrap(err, "invalid shim config")
+	}
+	server, err := newServer(c)
+	if err != nil {
+		return errors.Wrap(err, "failed creating server")
+	}
+	// The limits are applied before the shim opens any fifo, so that a
+	// limit too low fails the shim here instead of a later Create.
+	shimLimits, err := limits.Config{
+		CgroupParent: c.ShimCgroupParent,
+		MemoryLimit:  c.ShimMemoryLimit,
+		CPUs:         c.ShimCPUs,
+		NoFile:       c.ShimNoFile,
+	}.Apply()
+	if err != nil {
+		return errors.Wrap(err, "failed to apply shim limits")
+	}
+	defer shimLimits.Release()
+	signalMap, err := runscproc.ParseSignalMap(c.SignalMap)
+	if err != nil {
+		return errors.Wrap(err, "invalid signal_map in shim config")
+	}
+	queue := eventq.Config{
+		Size:   c.EventQueueSize,
+		Policy: eventq.Policy(c.EventOverflowPolicy),
+	}
+	if err := queue.Validate(); err != nil {
+		return errors.Wrap(err, "invalid event queue in shim config")
+	}
+	perfProfile := utils.PerfProfile{
+		Default:  c.PerfProfile,
+		Profiles: c.PerfProfiles,
+	}
+	if err := perfProfile.Validate(); err != nil {
+		return errors.Wrap(err, "invalid perf_profile in shim config")
+	}
+	if err := perfProfile.CheckFlags(c.UncheckedRunscFlags); err != nil {
+		logrus.WithError(err).Warn("perf_profiles in shim config may be invalid")
+	}
+	fileAccess := utils.FileAccess{
+		Root:     c.FileAccess,
+		Mounts:   c.FileAccessMounts,
+		DirectFS: c.DirectFS,
+		Lisafs:   c.Lisafs,
+	}
+	if err := fileAccess.Validate(); err != nil {
+		return errors.Wrap(err, "invalid file access in shim config")
+	}
+	compression := checkpoint.Compression(c.CheckpointCompression)
+	if compression != "" {
+		if err := compression.Validate(); err != nil {
+			return errors.Wrap(err, "invalid checkpoint_compression in shim config")
+		}
+	}
+	terminalStdinEOF := runscproc.TerminalStdinEOF(c.TerminalStdinEOF)
+	if err := terminalStdinEOF.Validate(); err != nil {
+		return errors.Wrap(err, "invalid terminal_stdin_eof in shim config")
+	}
+	teardown := shim.TeardownConfig{
+		Policy:  shim.TeardownPolicy(c.TeardownPolicy),
+		Timeout: c.TeardownTimeout.Duration,
+	}
+	if err := teardown.Validate(); err != nil {
+		return errors.Wrap(err, "invalid teardown in shim config")
+	}
+	runscLimits := runsc.Limits{
+		Concurrency: c.RunscConcurrency,
+		Rate:        c.RunscRate,
+	}
+	if err := runscLimits.Validate(); err != nil {
+		return errors.Wrap(err, "invalid runsc limits in shim config")
+	}
+	var shmSize int64
+	if c.ShmSize != "" {
+		if shmSize, err = compat.ParseShmSize(c.ShmSize); err != nil {
+			return errors.Wrap(err, "invalid shm_size in shim config")
+		}
+	}
+	monitor, err := runscproc.SelectMonitor(c.Monitor, runscproc.DefaultReaper)
+	if err != nil {
+		return errors.Wrap(err, "invalid monitor in shim config")
+	}
+	runsc.Monitor = monitor
+	if err := tracing.Configure(tracing.Config{Endpoint: c.OTLPEndpoint}); err != nil {
+		return errors.Wrap(err, "invalid otlp_endpoint in shim config")
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		tracing.Flush(ctx)
+	}()
+	var localEvents *localevents.Server
+	if c.EventsSocketDir != "" {
+		es, err := localevents.NewServer(localevents.SocketPath(c.EventsSocketDir, namespaceFlag, filepath.Base(path)))
+		if err != nil {
+			logrus.WithError(err).Warn("failed to start events server")
+		} else {
+			es.Serve(context.Background())
+			defer es.Close()
+			localEvents = es
+		}
+	}
+	var webhook *localevents.Webhook
+	if c.EventsWebhook != "" {
+		if webhook, err = localevents.NewWebhook(c.EventsWebhook); err != nil {
+			return errors.Wrap(err, "invalid events_webhook in shim config")
+		}
+		defer webhook.Close()
+	}
 	sv, err := shim.NewService(
 		shim.Config{
-			Path:        path,
-			Namespace:   namespaceFlag,
-			WorkDir:     workdirFlag,
-			RuntimeRoot: runtimeRootFlag,
-			RunscConfig: c.RunscConfig,
+			Path:                path,
+			Namespace:           namespaceFlag,
+			WorkDir:             workdirFlag,
+			WorkRoot:            c.WorkRoot,
+			RuntimeRoot:         runtimeRootFlag,
+			RunscConfig:         c.RunscConfig,
+			UncheckedRunscFlags: c.UncheckedRunscFlags,
+			Stats: stats.Config{
+				Interval:        c.StatsInterval.Duration,
+				MemoryThreshold: c.MemoryThreshold,
+				Stream:          c.StatsStream,
+				Watchdog: stats.WatchdogConfig{
+					MemoryLimit: c.WatchdogMemoryLimit,
+					PidsLimit:   c.WatchdogPidsLimit,
+					Grace:       c.WatchdogGrace.Duration,
+				},
+			},
+			SyscallWarningsInterval: c.SyscallWarningsInterval.Duration,
+			Signals:                 signalMap,
+			Events:                  queue,
+			RunHooks:                c.RunHooks,
+			ChownHelper:             c.ChownHelper,
+			RuncBinary:              c.RuncBinary,
+			OOMScoreOffset:          c.OOMScoreOffset,
+			UserLogToStdout:         c.UserLogToStdout,
+			HoldNamespaces:          c.HoldNamespaces,
+			CheckpointCompression:   compression,
+			PerfProfile:             perfProfile,
+			FileAccess:              fileAccess,
+			Strict:                  c.Strict,
+			StrictSeccomp:           c.StrictSeccomp,
+			KeepArtifacts:           c.KeepArtifacts,
+			CollectCrashLogs:        c.CollectCrashLogs,
+			LineBufferStderr:        c.LineBufferStderr,
+			TerminalStdinEOF:        terminalStdinEOF,
+			PauseOptimization:       c.PauseOptimization,
+			ShmSize:                 shmSize,
+			Limits:                  shimLimits,
+			Mounts: runscproc.MountConfig{
+				Workers:          c.MountWorkers,
+				UnmountRetries:   c.UnmountRetries,
+				UnmountBackoff:   c.UnmountBackoff.Duration,
+				LogMounts:        c.LogMounts,
+				WatchdogInterval: c.MountWatchdogInterval.Duration,
+			},
+			Teardown: teardown,
+			Timeouts: runscproc.Timeouts{
+				Create: c.CreateTimeout.Duration,
+				Start:  c.StartTimeout.Duration,
+				Exec:   c.ExecTimeout.Duration,
+				Delete: c.DeleteTimeout.Duration,
+				IO:     c.IOTimeout.Duration,
+			},
+			RunscLimits: runscLimits,
+			Runtimes: utils.Runtimes{
+				Namespaces: c.NamespaceRuntimes,
+				Handlers:   c.HandlerRuntimes,
+			},
+			Monitor:     monitor,
+			LocalEvents: localEvents,
+			Webhook:     webhook,
 		},
 		&remoteEventsPublisher{address: addressFlag},
 	)
 	if err != nil {
 		return err
 	}
+	rpcs := rpclog.New(rpclog.DefaultSize, workdirFlag)
 	logrus.Debug("registering ttrpc server")
-	shimapi.RegisterShimService(server, sv)
+	shimapi.RegisterShimService(server, shim.Audit(sv.RecordRPCs(rpcs)))
+	runscapi.Register(server, runscapi.Audit(sv))
 
 	socket := socketFlag
-	if err := serve(server, socket); err != nil {
+	typ, err := socketType(c, namespaceFlag)
+	if err != nil {
+		return err
+	}
+	perms, err := socketPermissions(c)
+	if err != nil {
+		return err
+	}
+	if typ == socketTypeAbstract && (c.SocketMode != "" || c.SocketGroup != "") {
+		logrus.Warn("socket_mode and socket_group don't apply to abstract sockets, set socket_type to \"filesystem\"")
+	}
+	if err := serve(server, socket, typ, perms, c.DisableAbstractSocket); err != nil {
 		return err
 	}
 	logger := logrus.WithFields(logrus.Fields{
@@ -184,53 +441,72 @@ func executeShim() error {
 		"path":      path,
 		"namespace": namespaceFlag,
 	})
+	if c.DebugSocketDir != "" {
+		ds, err := shimdebug.NewServer(shimdebug.SocketPath(c.DebugSocketDir, namespaceFlag, filepath.Base(path)))
+		if err != nil {
+			logger.WithError(err).Warn("failed to start debug server")
+		} else {
+			ds.Handle("/debug/io", shimdebug.JSONHandler(func() interface{} {
+				return sv.IOStats()
+			}))
+			ds.HandleDiagnostics(sv)
+			ds.HandleSandbox(sv)
+			ds.Handle("/debug/runsc-config", sv.RunscConfigHandler())
+			ds.Handle("/debug/leaked-mounts", shimdebug.JSONHandler(func() interface{} {
+				return sv.LeakedMounts()
+			}))
+			ds.Handle("/debug/runsc-queue", shimdebug.JSONHandler(func() interface{} {
+				return sv.RunscQueue()
+			}))
+			ds.Handle("/debug/latency", shimdebug.JSONHandler(func() interface{} {
+				return sv.StartLatency()
+			}))
+			ds.Handle("/debug/version", shimdebug.JSONHandler(func() interface{} {
+				return version.Get()
+			}))
+			ds.Handle("/debug/rpcs", shimdebug.JSONHandler(func() interface{} {
+				return rpcs.Calls()
+			}))
+			if c.SentryMetrics {
+				ds.Handle("/metrics", sv.SentryMetricsHandler())
+			}
+			if failpoint.Enabled {
+				ds.Handle("/debug/failpoints/", failpoint.Handler())
+			}
+			ds.Serve(log.WithLogger(context.Background(), logger))
+			defer ds.Close()
+		}
+	}
 	go func() {
 		for range dump {
 			dumpStacks(logger)
 		}
 	}()
-	return handleSignals(logger, signals, server, sv)
-}
-
-// serve serves the ttrpc API over a unix socket at the provided path
-// this function does not block
-func serve(server *ttrpc.Server, path string) error {
-	var (
-		l   net.Listener
-		err error
-	)
-	if path == "" {
-		l, err = net.FileListener(os.NewFile(3, "socket"))
-		path = "[inherited from parent]"
-	} else {
-		if len(path) > 106 {
-			return errors.Errorf("%q: unix socket path too long (> 106)", path)
-		}
-		l, err = net.Listen("unix", "\x00"+path)
-	}
-	if err != nil {
-		return err
-	}
-	logrus.WithField("socket", path).Debug("serving api on unix socket")
 	go func() {
-		defer l.Close()
-		if err := server.Serve(context.Background(), l); err != nil &&
-			!strings.Contains(err.Error(), "use of closed network connection") {
-			logrus.WithError(err).Fatal("gvisor-containerd-shim: ttrpc server failure")
+		for range quit {
+			if path, err := rpcs.Dump(workdirFlag); err != nil {
+				logger.WithError(err).Error("failed to dump rpcs")
+			} else {
+				logger.Infof("dumped recent rpcs to %s", path)
+			}
 		}
 	}()
-	return nil
+	r := &reloader{
+		path:    shimConfigFlag,
+		current: c,
+		sv:      sv,
+	}
+	return handleSignals(logger, signals, server, sv, r)
 }
 
 // setupSignals creates a new signal handler for all signals and sets the shim as a
 // sub-reaper so that the container processes are reparented
 func setupSignals() (chan os.Signal, error) {
 	signals := make(chan os.Signal, 32)
-	signal.Notify(signals, unix.SIGTERM, unix.SIGINT, unix.SIGCHLD, unix.SIGPIPE)
+	signal.Notify(signals, unix.SIGTERM, unix.SIGINT, unix.SIGCHLD, unix.SIGPIPE, unix.SIGHUP)
 	// make sure runc is setup to use the monitor
 	// for waiting on processes
-	// TODO(random-liu): Move shim/reaper.go to a separate package.
-	runsc.Monitor = containerdshim.Default
+	runsc.Monitor = runscproc.DefaultReaper
 	// set the shim as the subreaper for all orphaned processes created by the container
 	if err := system.SetSubreaper(1); err != nil {
 		return nil, err
@@ -238,20 +514,20 @@ func setupSignals() (chan os.Signal, error) {
 	return signals, nil
 }
 
-func handleSignals(logger *logrus.Entry, signals chan os.Signal, server *ttrpc.Server, sv *shim.Service) error {
+func handleSignals(logger *logrus.Entry, signals chan os.Signal, server *ttrpc.Server, sv *shim.Service, r *reloader) error {
 	var (
 		termOnce sync.Once
-		done     = make(chan struct{})
+		done     = make(chan error, 1)
 	)
 
 	for {
 		select {
-		case <-done:
-			return nil
+		case err := <-done:
+			return err
 		case s := <-signals:
 			switch s {
 			case unix.SIGCHLD:
-				if err := containerdshim.Reap(); err != nil {
+				if err := runscproc.DefaultReaper.Reap(); err != nil {
 					logger.WithError(err).Error("reap exit status")
 				}
 			case unix.SIGTERM, unix.SIGINT:
@@ -260,14 +536,17 @@ func handleSignals(logger *logrus.Entry, signals chan os.Signal, server *ttrpc.S
 					if err := server.Shutdown(ctx); err != nil {
 						logger.WithError(err).Error("failed to shutdown server")
 					}
-					// Ensure our child is dead if any
-					sv.Kill(ctx, &shimapi.KillRequest{
-						Signal: uint32(syscall.SIGKILL),
-						All:    true,
-					})
-					sv.Delete(context.Background(), &ptypes.Empty{})
-					close(done)
+					// Keep reaping while the container is torn down so that
+					// its exit is seen and published.
+					if err := sv.Teardown(ctx); err != nil {
+						logger.WithError(err).Error("failed to tear down shim")
+						done <- errors.Wrap(err, "teardown")
+						return
+					}
+					done <- nil
 				})
+			case unix.SIGHUP:
+				r.reload(logger)
 			case unix.SIGPIPE:
 			}
 		}
@@ -305,11 +584,11 @@ func (l *remoteEventsPublisher) Publish(ctx context.Context, topic string, event
 	}
 	cmd := exec.CommandContext(ctx, containerdBinaryFlag, "--address", l.address, "publish", "--topic", topic, "--namespace", ns)
 	cmd.Stdin = bytes.NewReader(data)
-	c, err := containerdshim.Default.Start(cmd)
+	c, err := runscproc.DefaultReaper.Start(cmd)
 	if err != nil {
 		return err
 	}
-	status, err := containerdshim.Default.Wait(cmd, c)
+	status, err := runscproc.DefaultReaper.Wait(cmd, c)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,192 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/shim"
+)
+
+// reloadableSettings are the settings of the config applied when the shim
+// receives SIGHUP, besides the runsc flags of shim.ReloadableRunscFlags.
+// Changing any other setting, such as the platform or network of runsc,
+// requires a new shim, and the reload is rejected.
+var reloadableSettings = map[string]bool{
+	"log_level":        true,
+	"stats_interval":   true,
+	"teardown_policy":  true,
+	"teardown_timeout": true,
+}
+
+// setLogLevel sets the level of the shim logs from the config, unless the
+// -debug flag is set.
+func setLogLevel(c *config) error {
+	if debugFlag {
+		logrus.SetLevel(logrus.DebugLevel)
+		return nil
+	}
+	level := logrus.InfoLevel
+	if c.LogLevel != "" {
+		var err error
+		if level, err = logrus.ParseLevel(c.LogLevel); err != nil {
+			return errors.Wrap(err, "invalid log_level")
+		}
+	}
+	logrus.SetLevel(level)
+	return nil
+}
+
+// reloader reloads the config of the shim.
+type reloader struct {
+	path    string
+	current *config
+	sv      *shim.Service
+}
+
+// reload reads the config again and applies the settings that changed to
+// the shim. A config that changes settings that can't be reloaded, or that
+// is invalid, is rejected and the shim keeps its current settings.
+func (r *reloader) reload(logger *logrus.Entry) {
+	c, err := loadConfig(r.path)
+	if err != nil {
+		logger.WithError(err).Error("failed to reload shim config")
+		return
+	}
+	changed, immutable := configDiff(r.current, c)
+	if len(changed) == 0 {
+		logger.Info("shim config unchanged")
+		return
+	}
+	if len(immutable) > 0 {
+		logger.WithField("diff", changed).Errorf("rejecting shim config reload, %s can't change while the shim runs", strings.Join(immutable, ", "))
+		return
+	}
+	if err := runsc.ValidateConfig(c.RunscConfig, c.UncheckedRunscFlags); err != nil {
+		logger.WithError(err).Warn("runsc config may be invalid")
+	}
+	if err := r.apply(c); err != nil {
+		logger.WithField("diff", changed).WithError(err).Error("rejecting shim config reload")
+		return
+	}
+	r.current = c
+	logger.WithField("diff", changed).Info("reloaded shim config")
+}
+
+func (r *reloader) apply(c *config) error {
+	if c.LogLevel != "" {
+		if _, err := logrus.ParseLevel(c.LogLevel); err != nil {
+			return errors.Wrap(err, "invalid log_level")
+		}
+	}
+	flags := make(map[string]string)
+	for _, k := range shim.ReloadableRunscFlags {
+		if v, ok := c.RunscConfig[k]; ok {
+			flags[k] = v
+		}
+	}
+	if err := r.sv.Reload(shim.Reloadable{
+		RunscDebugFlags: flags,
+		StatsInterval:   c.StatsInterval.Duration,
+		Teardown: shim.TeardownConfig{
+			Policy:  shim.TeardownPolicy(c.TeardownPolicy),
+			Timeout: c.TeardownTimeout.Duration,
+		},
+	}); err != nil {
+		return err
+	}
+	return setLogLevel(c)
+}
+
+// configDiff returns the settings that differ between old and new, as
+// "key: old -> new", and the keys of the ones that can't be reloaded. The
+// runsc flags are compared one by one.
+func configDiff(old, new *config) (changed, immutable []string) {
+	ov, nv := reflect.ValueOf(*old), reflect.ValueOf(*new)
+	t := ov.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := t.Field(i).Tag.Get("toml")
+		if key == "runsc_config" {
+			continue
+		}
+		o, n := ov.Field(i).Interface(), nv.Field(i).Interface()
+		if reflect.DeepEqual(o, n) {
+			continue
+		}
+		changed = append(changed, fmt.Sprintf("%s: %s -> %s", key, formatSetting(o), formatSetting(n)))
+		if !reloadableSettings[key] {
+			immutable = append(immutable, key)
+		}
+	}
+	reloadable := make(map[string]bool)
+	for _, k := range shim.ReloadableRunscFlags {
+		reloadable[k] = true
+	}
+	for _, k := range runscFlagKeys(old.RunscConfig, new.RunscConfig) {
+		o, ook := old.RunscConfig[k]
+		n, nok := new.RunscConfig[k]
+		if o == n && ook == nok {
+			continue
+		}
+		key := "runsc_config." + k
+		changed = append(changed, fmt.Sprintf("%s: %s -> %s", key, formatFlag(o, ook), formatFlag(n, nok)))
+		if !reloadable[k] {
+			immutable = append(immutable, key)
+		}
+	}
+	return changed, immutable
+}
+
+func runscFlagKeys(a, b map[string]string) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, m := range []map[string]string{a, b} {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatSetting(v interface{}) string {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return "unset"
+		}
+		v = rv.Elem().Interface()
+	}
+	return fmt.Sprintf("%q", fmt.Sprint(v))
+}
+
+func formatFlag(v string, ok bool) string {
+	if !ok {
+		return "unset"
+	}
+	return fmt.Sprintf("%q", v)
+}
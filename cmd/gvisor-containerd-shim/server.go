@@ -0,0 +1,277 @@
+/*
+Copyright The containerd Authors.
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/containerd/ttrpc"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// newServer returns a ttrpc server that only accepts connections from the
+// peer credentials given by flags or the config, or from containerd's
+// credentials.
+func newServer(c *config) (*ttrpc.Server, error) {
+	uid, gid := peerCredentials(c)
+	logrus.WithFields(logrus.Fields{
+		"uid": uid,
+		"gid": gid,
+	}).Debug("requiring peer credentials on shim socket")
+	return ttrpc.NewServer(ttrpc.WithServerHandshaker(ttrpc.UnixSocketRequireUidGid(uid, gid)))
+}
+
+// peerCredentials returns the uid and gid allowed to connect to the shim, -1
+// allowing any. Flags take precedence over the config. Unset ones default to
+// the credentials of the parent process, which is the containerd daemon that
+// started the shim, and to our own credentials if the parent can't be
+// inspected.
+func peerCredentials(c *config) (int, int) {
+	uid, gid := peerUIDFlag, peerGIDFlag
+	if uid == -1 && c.PeerUID != nil {
+		uid = *c.PeerUID
+	}
+	if gid == -1 && c.PeerGID != nil {
+		gid = *c.PeerGID
+	}
+	puid, pgid := os.Geteuid(), os.Getegid()
+	if fi, err := os.Stat(fmt.Sprintf("/proc/%d", os.Getppid())); err == nil {
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+			puid, pgid = int(st.Uid), int(st.Gid)
+		}
+	}
+	if uid == -1 && c.PeerUID == nil {
+		uid = puid
+	}
+	if gid == -1 && c.PeerGID == nil {
+		gid = pgid
+	}
+	return uid, gid
+}
+
+// socketPerms are the permissions of a filesystem shim socket.
+type socketPerms struct {
+	mode os.FileMode
+	// gid is the group owning the socket, -1 to keep the group of the shim.
+	gid int
+}
+
+// defaultSocketMode is the mode of a filesystem shim socket.
+const defaultSocketMode = 0600
+
+// socketPermissions returns the permissions of a filesystem shim socket set
+// in the config.
+func socketPermissions(c *config) (socketPerms, error) {
+	p := socketPerms{mode: defaultSocketMode, gid: -1}
+	if c.SocketMode != "" {
+		m, err := strconv.ParseUint(c.SocketMode, 8, 32)
+		if err != nil || m&^0777 != 0 {
+			return p, errors.Errorf("invalid socket_mode %q, expected an octal permission such as \"0660\"", c.SocketMode)
+		}
+		p.mode = os.FileMode(m)
+	}
+	if c.SocketGroup != "" {
+		gid, err := lookupGroup(c.SocketGroup)
+		if err != nil {
+			return p, err
+		}
+		p.gid = gid
+	}
+	return p, nil
+}
+
+// lookupGroup returns the gid of group, given by name or gid.
+func lookupGroup(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid socket_group %q", group)
+	}
+	return strconv.Atoi(g.Gid)
+}
+
+const (
+	// socketTypeAbstract serves the API on an abstract unix socket.
+	socketTypeAbstract = "abstract"
+	// socketTypeFilesystem serves the API on a unix socket bound to a
+	// filesystem path.
+	socketTypeFilesystem = "filesystem"
+)
+
+// listenFdsStart is the first file descriptor passed by socket activation.
+const listenFdsStart = 3
+
+// socketType returns the socket type to use for the namespace. The flag takes
+// precedence over the per-namespace and the default config. Abstract sockets
+// are refused when disabled in the config.
+func socketType(c *config, namespace string) (string, error) {
+	t := socketTypeFlag
+	if t == "" {
+		t = c.SocketTypes[namespace]
+	}
+	if t == "" {
+		t = c.SocketType
+	}
+	switch t {
+	case "":
+		if c.DisableAbstractSocket {
+			return socketTypeFilesystem, nil
+		}
+		return socketTypeAbstract, nil
+	case socketTypeAbstract:
+		if c.DisableAbstractSocket {
+			return "", errors.Errorf("socket type %q is disabled by disable_abstract_socket", t)
+		}
+		return t, nil
+	case socketTypeFilesystem:
+		return t, nil
+	}
+	return "", errors.Errorf("unknown socket type %q", t)
+}
+
+// serve serves the ttrpc API over a unix socket at the provided path
+// this function does not block
+func serve(server *ttrpc.Server, path, typ string, perms socketPerms, noAbstract bool) error {
+	l, path, err := listen(path, typ, perms)
+	if err != nil {
+		return err
+	}
+	if noAbstract && strings.HasPrefix(l.Addr().String(), "@") {
+		l.Close()
+		return errors.Errorf("refusing to serve on abstract socket %q passed by the parent, abstract sockets are disabled", l.Addr())
+	}
+	logrus.WithFields(logrus.Fields{
+		"socket": path,
+		"type":   typ,
+	}).Debug("serving api on unix socket")
+	go func() {
+		defer l.Close()
+		if err := server.Serve(context.Background(), l); err != nil &&
+			!strings.Contains(err.Error(), "use of closed network connection") {
+			logrus.WithError(err).Fatal("gvisor-containerd-shim: ttrpc server failure")
+		}
+	}()
+	return nil
+}
+
+// listen returns the listener for the shim API. A socket passed through
+// socket activation is preferred, then a socket inherited as fd 3 when no path
+// is given, and otherwise a new socket of the given type is bound to path.
+func listen(path, typ string, perms socketPerms) (net.Listener, string, error) {
+	if l, err := activationListener(); l != nil || err != nil {
+		return l, "[socket activation]", err
+	}
+	if path == "" {
+		l, err := net.FileListener(os.NewFile(listenFdsStart, "socket"))
+		return l, "[inherited from parent]", err
+	}
+	if len(path) > 106 {
+		return nil, path, errors.Errorf("%q: unix socket path too long (> 106)", path)
+	}
+	if typ == socketTypeFilesystem {
+		l, err := listenFilesystem(path, perms)
+		return l, path, err
+	}
+	l, err := net.Listen("unix", "\x00"+path)
+	if err != nil && isAddrInUse(err) {
+		return nil, path, errors.Errorf("abstract socket %q is already in use", path)
+	}
+	return l, path, err
+}
+
+// activationListener returns the listener passed by LISTEN_FDS style socket
+// activation, or nil if the shim was not socket activated. The activation
+// environment is cleared so that it is not inherited by runsc.
+func activationListener() (net.Listener, error) {
+	fds := os.Getenv("LISTEN_FDS")
+	if fds == "" {
+		return nil, nil
+	}
+	pid := os.Getenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid LISTEN_FDS %q", fds)
+	}
+	if n != 1 {
+		return nil, errors.Errorf("expected a single socket from socket activation, got %d", n)
+	}
+	unix.CloseOnExec(listenFdsStart)
+	return net.FileListener(os.NewFile(listenFdsStart, "socket"))
+}
+
+// listenFilesystem binds a unix socket to path. A socket left behind by a
+// shim that is no longer running is removed, while a socket that still
+// accepts connections is reported as a collision. The socket is given perms
+// before it is served.
+func listenFilesystem(path string, perms socketPerms) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if _, err := os.Lstat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, errors.Errorf("socket %q is already in use", path)
+		}
+		logrus.WithField("socket", path).Warn("removing stale shim socket")
+		if err := os.Remove(path); err != nil {
+			return nil, errors.Wrap(err, "remove stale socket")
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if perms.gid != -1 {
+		if err := os.Lchown(path, -1, perms.gid); err != nil {
+			l.Close()
+			return nil, errors.Wrap(err, "set socket group")
+		}
+	}
+	if err := os.Chmod(path, perms.mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func isAddrInUse(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok {
+		if sysErr, ok := opErr.Err.(*os.SyscallError); ok {
+			return sysErr.Err == syscall.EADDRINUSE
+		}
+	}
+	return false
+}
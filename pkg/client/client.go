@@ -0,0 +1,125 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package client is a Go client of the shims, for node agents and tests
+// that talk to a shim without containerd.
+//
+// The task API of containerd-shim-runsc-v1 is reached through its bundle,
+// where the shim writes its socket address, e.g.
+//
+//	c, err := client.DialBundle(ctx, bundle)
+//	if err != nil {
+//		return err
+//	}
+//	defer c.Close()
+//	state, err := c.Task().State(ctx, &task.StateRequest{ID: id})
+//
+// and the v1 API of gvisor-containerd-shim through its socket with Dial and
+// Shim. The runsc specific RPCs of both shims are reached with Runsc, on a
+// client from DialRunscBundle for containerd-shim-runsc-v1. Errors of the
+// RPCs are translated to errdefs errors.
+//
+// NewDebugClient and Subscribe reach the debug and events sockets enabled
+// in the shim options.
+package client
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	shimapi "github.com/containerd/containerd/runtime/v1/shim/v1"
+	taskAPI "github.com/containerd/containerd/runtime/v2/task"
+	"github.com/containerd/ttrpc"
+	"github.com/pkg/errors"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runscapi"
+)
+
+// addressFile is the file of the bundle the v2 shim writes its socket
+// address to.
+const addressFile = "address"
+
+// Client is a connection to the ttrpc socket of a shim.
+type Client struct {
+	conn net.Conn
+	rpc  *ttrpc.Client
+}
+
+// Dial connects to the shim socket at address. The address is a filesystem
+// path, or an abstract socket name, with or without a leading "@", as
+// containerd passes it to the shims. A "unix://" prefix is ignored.
+func Dial(ctx context.Context, address string) (*Client, error) {
+	address = strings.TrimPrefix(address, "unix://")
+	if !strings.HasPrefix(address, "@") && !strings.HasPrefix(address, "\x00") {
+		if _, err := os.Stat(address); err != nil {
+			// containerd names abstract sockets like paths.
+			address = "@" + address
+		}
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to dial shim socket %q", address)
+	}
+	return &Client{
+		conn: conn,
+		rpc:  ttrpc.NewClient(conn),
+	}, nil
+}
+
+// DialBundle connects to the v2 shim of the container in bundle.
+func DialBundle(ctx context.Context, bundle string) (*Client, error) {
+	data, err := ioutil.ReadFile(filepath.Join(bundle, addressFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read shim address")
+	}
+	return Dial(ctx, strings.TrimSpace(string(data)))
+}
+
+// DialRunscBundle connects to the runsc service of the v2 shim of the
+// container in bundle.
+func DialRunscBundle(ctx context.Context, bundle string) (*Client, error) {
+	data, err := ioutil.ReadFile(filepath.Join(bundle, addressFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read shim address")
+	}
+	return Dial(ctx, strings.TrimSpace(string(data))+runscapi.SocketSuffix)
+}
+
+// Task returns the task API of a v2 shim.
+func (c *Client) Task() *TaskClient {
+	return &TaskClient{client: taskAPI.NewTaskClient(c.rpc)}
+}
+
+// Shim returns the API of a v1 shim.
+func (c *Client) Shim() *ShimClient {
+	return &ShimClient{client: shimapi.NewShimClient(c.rpc)}
+}
+
+// Runsc returns the runsc service of a shim: on the shim socket of a v1
+// shim, and dialed with DialRunscBundle for a v2 shim.
+func (c *Client) Runsc() *RunscClient {
+	return &RunscClient{client: runscapi.NewClient(c.rpc)}
+}
+
+// Close closes the connection.
+func (c *Client) Close() error {
+	return c.rpc.Close()
+}
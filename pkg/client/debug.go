@@ -0,0 +1,182 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/pkg/errors"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/debug"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+	"github.com/google/gvisor-containerd-shim/pkg/version"
+)
+
+// DebugClient calls the endpoints of the debug socket of a shim.
+type DebugClient struct {
+	path string
+	http *http.Client
+}
+
+// DebugSocket returns the path of the debug socket of the shim of the
+// container id, with debug_socket_dir set to dir.
+func DebugSocket(dir, namespace, id string) string {
+	return debug.SocketPath(dir, namespace, id)
+}
+
+// NewDebugClient returns a client of the debug socket at path.
+func NewDebugClient(path string) *DebugClient {
+	return &DebugClient{
+		path: path,
+		http: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", path)
+				},
+			},
+		},
+	}
+}
+
+// IOStats returns the io byte counters of the processes of the shim.
+func (c *DebugClient) IOStats(ctx context.Context) ([]runsctypes.IOStats, error) {
+	var stats []runsctypes.IOStats
+	return stats, c.get(ctx, "/debug/io", &stats)
+}
+
+// LeakedMounts returns the mounts left under the bundle of the deleted
+// container of the shim, and counts of the leaks.
+func (c *DebugClient) LeakedMounts(ctx context.Context) (*runsctypes.LeakedMounts, error) {
+	var m runsctypes.LeakedMounts
+	if err := c.get(ctx, "/debug/leaked-mounts", &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// StartLatency returns the phases of the creation and start of the
+// container.
+func (c *DebugClient) StartLatency(ctx context.Context) (*runsctypes.StartLatency, error) {
+	var l runsctypes.StartLatency
+	if err := c.get(ctx, "/debug/latency", &l); err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// Version returns the build information of the shim.
+func (c *DebugClient) Version(ctx context.Context) (*version.Info, error) {
+	var v version.Info
+	if err := c.get(ctx, "/debug/version", &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// SandboxStatus returns the status of the sandbox of the shim of a pause
+// container.
+func (c *DebugClient) SandboxStatus(ctx context.Context) (*runsctypes.SandboxStatus, error) {
+	var s runsctypes.SandboxStatus
+	if err := c.get(ctx, "/debug/sandbox", &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Diagnostics returns what debugging tools need to attach to the sandbox of
+// the shim.
+func (c *DebugClient) Diagnostics(ctx context.Context) (*runsctypes.Diagnostics, error) {
+	var d runsctypes.Diagnostics
+	if err := c.get(ctx, "/debug/diagnostics", &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// RunscConfig returns the runsc configuration the container of the shim was
+// created with, next to the flags configured for the shim.
+func (c *DebugClient) RunscConfig(ctx context.Context) (*runsctypes.RunscConfig, error) {
+	var rc runsctypes.RunscConfig
+	if err := c.get(ctx, "/debug/runsc-config", &rc); err != nil {
+		return nil, err
+	}
+	return &rc, nil
+}
+
+// Goroutines returns the stacks of the goroutines of the shim.
+func (c *DebugClient) Goroutines(ctx context.Context) ([]byte, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/debug/pprof/goroutine?debug=2")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (c *DebugClient) get(ctx context.Context, path string, v interface{}) error {
+	resp, err := c.do(ctx, http.MethodGet, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return errors.Wrapf(err, "failed to decode %s", path)
+	}
+	return nil
+}
+
+// do sends a request to the shim, and translates the status of failed
+// requests to errdefs errors.
+func (c *DebugClient) do(ctx context.Context, method, path string) (*http.Response, error) {
+	req, err := http.NewRequest(method, "http://shim"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	return nil, statusError(resp, method, path)
+}
+
+// statusError returns the error of a failed response, closing its body.
+func statusError(resp *http.Response, method, path string) error {
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	msg := strings.TrimSpace(string(body))
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return errors.Wrap(errdefs.ErrNotFound, msg)
+	case http.StatusBadRequest:
+		return errors.Wrap(errdefs.ErrInvalidArgument, msg)
+	case http.StatusConflict:
+		return errors.Wrap(errdefs.ErrFailedPrecondition, msg)
+	case http.StatusMethodNotAllowed:
+		return errors.Wrap(errdefs.ErrNotImplemented, msg)
+	}
+	return errors.Errorf("%s %s: %s: %s", method, path, resp.Status, msg)
+}
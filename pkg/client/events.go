@@ -0,0 +1,86 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/localevents"
+)
+
+// EventsSocket returns the path of the local events socket of the shim of
+// the container id, with local_events_dir set to dir.
+func EventsSocket(dir, namespace, id string) string {
+	return localevents.SocketPath(dir, namespace, id)
+}
+
+// Event is an event streamed by the local events socket of a shim. Event
+// is decoded according to Type, e.g. into a runsctypes.IOClosed.
+type Event struct {
+	Timestamp time.Time       `json:"timestamp"`
+	Topic     string          `json:"topic"`
+	Type      string          `json:"type"`
+	Event     json.RawMessage `json:"event"`
+}
+
+// Subscribe streams the events published by the shim listening on the
+// local events socket at path, until ctx is done or the shim closes the
+// socket. The events channel is closed then, after the error, if any, was
+// sent on the errors channel.
+func Subscribe(ctx context.Context, path string) (<-chan *Event, <-chan error, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to connect to events socket %s", path)
+	}
+	var (
+		events = make(chan *Event)
+		errs   = make(chan error, 1)
+	)
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+	go func() {
+		defer close(events)
+		defer conn.Close()
+		s := bufio.NewScanner(conn)
+		s.Buffer(nil, 1<<20)
+		for s.Scan() {
+			var e Event
+			if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+				errs <- errors.Wrap(err, "failed to decode event")
+				return
+			}
+			select {
+			case events <- &e:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := s.Err(); err != nil && ctx.Err() == nil {
+			errs <- err
+		}
+	}()
+	return events, errs, nil
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/pkg/errors"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runscapi"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+	"github.com/google/gvisor-containerd-shim/pkg/version"
+)
+
+// RunscClient is the runsc service of a shim, with the runsc specific RPCs.
+type RunscClient struct {
+	client *runscapi.Client
+}
+
+// Version calls the Version RPC.
+func (c *RunscClient) Version(ctx context.Context) (*version.Info, error) {
+	resp, err := c.client.Version(ctx)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// State returns the runsc specific state of the process execID, or of the
+// container for an empty execID.
+func (c *RunscClient) State(ctx context.Context, execID string) (*runsctypes.State, error) {
+	resp, err := c.client.State(ctx, &runsctypes.StateRequest{ExecID: execID})
+	return resp, errdefs.FromGRPC(err)
+}
+
+// Pids lists the processes of the container with their runsc details, and
+// with their threads if threads is set.
+func (c *RunscClient) Pids(ctx context.Context, threads bool) (*runsctypes.ProcessList, error) {
+	resp, err := c.client.Pids(ctx, &runsctypes.PidsRequest{Threads: threads})
+	return resp, errdefs.FromGRPC(err)
+}
+
+// Config returns the runsc configuration the container was created with.
+func (c *RunscClient) Config(ctx context.Context) (*runsctypes.RunscConfig, error) {
+	resp, err := c.client.Config(ctx)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// WaitAsync requests a wait result event carrying token once the process
+// execID, the container itself if empty, exits.
+func (c *RunscClient) WaitAsync(ctx context.Context, execID, token string) error {
+	return errdefs.FromGRPC(c.client.WaitAsync(ctx, &runsctypes.WaitAsyncRequest{ExecID: execID, Token: token}))
+}
+
+// StopSandbox stops the sandbox of the shim of a pause container, giving the
+// pause container timeout to exit after SIGTERM, zero to kill everything in
+// the sandbox right away.
+func (c *RunscClient) StopSandbox(ctx context.Context, timeout time.Duration) error {
+	return errdefs.FromGRPC(c.client.StopSandbox(ctx, &runsctypes.StopSandboxRequest{Timeout: timeout}))
+}
+
+// SignalSentry sends a debug signal, SIGUSR1 or SIGUSR2, to the sentry of
+// the sandbox of the shim. The runsc config must set the signal with
+// trace-signal or panic-signal.
+func (c *RunscClient) SignalSentry(ctx context.Context, sig syscall.Signal) error {
+	return errdefs.FromGRPC(c.client.SignalSentry(ctx, &runsctypes.SignalSentryRequest{Signal: uint32(sig)}))
+}
+
+// PortForward opens a connection to port of the container of the shim,
+// through the network stack of its sandbox.
+func (c *RunscClient) PortForward(ctx context.Context, port int) (*net.UnixConn, error) {
+	pf, err := c.client.PortForward(ctx, &runsctypes.PortForwardRequest{Port: port})
+	if err != nil {
+		return nil, errdefs.FromGRPC(err)
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", pf.Address)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to port forward")
+	}
+	return conn.(*net.UnixConn), nil
+}
+
+// Quiesce freezes the container for a backup of its volumes, until
+// Unquiesce or until timeout expires, zero for the default of the shim.
+func (c *RunscClient) Quiesce(ctx context.Context, timeout time.Duration) (*runsctypes.Quiesced, error) {
+	resp, err := c.client.Quiesce(ctx, &runsctypes.QuiesceRequest{Timeout: timeout})
+	return resp, errdefs.FromGRPC(err)
+}
+
+// Unquiesce resumes the container frozen by Quiesce.
+func (c *RunscClient) Unquiesce(ctx context.Context) error {
+	return errdefs.FromGRPC(c.client.Unquiesce(ctx))
+}
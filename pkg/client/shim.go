@@ -0,0 +1,128 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/errdefs"
+	shimapi "github.com/containerd/containerd/runtime/v1/shim/v1"
+	ptypes "github.com/gogo/protobuf/types"
+)
+
+// ShimClient is the API of a v1 shim.
+type ShimClient struct {
+	client shimapi.ShimService
+}
+
+var _ shimapi.ShimService = &ShimClient{}
+
+// State calls the State RPC.
+func (c *ShimClient) State(ctx context.Context, req *shimapi.StateRequest) (*shimapi.StateResponse, error) {
+	resp, err := c.client.State(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// Create calls the Create RPC.
+func (c *ShimClient) Create(ctx context.Context, req *shimapi.CreateTaskRequest) (*shimapi.CreateTaskResponse, error) {
+	resp, err := c.client.Create(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// Start calls the Start RPC.
+func (c *ShimClient) Start(ctx context.Context, req *shimapi.StartRequest) (*shimapi.StartResponse, error) {
+	resp, err := c.client.Start(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// Delete calls the Delete RPC.
+func (c *ShimClient) Delete(ctx context.Context, req *ptypes.Empty) (*shimapi.DeleteResponse, error) {
+	resp, err := c.client.Delete(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// DeleteProcess calls the DeleteProcess RPC.
+func (c *ShimClient) DeleteProcess(ctx context.Context, req *shimapi.DeleteProcessRequest) (*shimapi.DeleteResponse, error) {
+	resp, err := c.client.DeleteProcess(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// ListPids calls the ListPids RPC.
+func (c *ShimClient) ListPids(ctx context.Context, req *shimapi.ListPidsRequest) (*shimapi.ListPidsResponse, error) {
+	resp, err := c.client.ListPids(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// Pause calls the Pause RPC.
+func (c *ShimClient) Pause(ctx context.Context, req *ptypes.Empty) (*ptypes.Empty, error) {
+	resp, err := c.client.Pause(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// Resume calls the Resume RPC.
+func (c *ShimClient) Resume(ctx context.Context, req *ptypes.Empty) (*ptypes.Empty, error) {
+	resp, err := c.client.Resume(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// Checkpoint calls the Checkpoint RPC.
+func (c *ShimClient) Checkpoint(ctx context.Context, req *shimapi.CheckpointTaskRequest) (*ptypes.Empty, error) {
+	resp, err := c.client.Checkpoint(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// Kill calls the Kill RPC.
+func (c *ShimClient) Kill(ctx context.Context, req *shimapi.KillRequest) (*ptypes.Empty, error) {
+	resp, err := c.client.Kill(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// Exec calls the Exec RPC.
+func (c *ShimClient) Exec(ctx context.Context, req *shimapi.ExecProcessRequest) (*ptypes.Empty, error) {
+	resp, err := c.client.Exec(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// ResizePty calls the ResizePty RPC.
+func (c *ShimClient) ResizePty(ctx context.Context, req *shimapi.ResizePtyRequest) (*ptypes.Empty, error) {
+	resp, err := c.client.ResizePty(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// CloseIO calls the CloseIO RPC.
+func (c *ShimClient) CloseIO(ctx context.Context, req *shimapi.CloseIORequest) (*ptypes.Empty, error) {
+	resp, err := c.client.CloseIO(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// ShimInfo calls the ShimInfo RPC.
+func (c *ShimClient) ShimInfo(ctx context.Context, req *ptypes.Empty) (*shimapi.ShimInfoResponse, error) {
+	resp, err := c.client.ShimInfo(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// Update calls the Update RPC.
+func (c *ShimClient) Update(ctx context.Context, req *shimapi.UpdateTaskRequest) (*ptypes.Empty, error) {
+	resp, err := c.client.Update(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// Wait calls the Wait RPC.
+func (c *ShimClient) Wait(ctx context.Context, req *shimapi.WaitRequest) (*shimapi.WaitResponse, error) {
+	resp, err := c.client.Wait(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
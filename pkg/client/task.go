@@ -0,0 +1,134 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/errdefs"
+	taskAPI "github.com/containerd/containerd/runtime/v2/task"
+	ptypes "github.com/gogo/protobuf/types"
+)
+
+// TaskClient is the task API of a v2 shim.
+type TaskClient struct {
+	client taskAPI.TaskService
+}
+
+var _ taskAPI.TaskService = &TaskClient{}
+
+// State calls the State RPC.
+func (c *TaskClient) State(ctx context.Context, req *taskAPI.StateRequest) (*taskAPI.StateResponse, error) {
+	resp, err := c.client.State(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// Create calls the Create RPC.
+func (c *TaskClient) Create(ctx context.Context, req *taskAPI.CreateTaskRequest) (*taskAPI.CreateTaskResponse, error) {
+	resp, err := c.client.Create(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// Start calls the Start RPC.
+func (c *TaskClient) Start(ctx context.Context, req *taskAPI.StartRequest) (*taskAPI.StartResponse, error) {
+	resp, err := c.client.Start(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// Delete calls the Delete RPC.
+func (c *TaskClient) Delete(ctx context.Context, req *taskAPI.DeleteRequest) (*taskAPI.DeleteResponse, error) {
+	resp, err := c.client.Delete(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// Pids calls the Pids RPC.
+func (c *TaskClient) Pids(ctx context.Context, req *taskAPI.PidsRequest) (*taskAPI.PidsResponse, error) {
+	resp, err := c.client.Pids(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// Pause calls the Pause RPC.
+func (c *TaskClient) Pause(ctx context.Context, req *taskAPI.PauseRequest) (*ptypes.Empty, error) {
+	resp, err := c.client.Pause(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// Resume calls the Resume RPC.
+func (c *TaskClient) Resume(ctx context.Context, req *taskAPI.ResumeRequest) (*ptypes.Empty, error) {
+	resp, err := c.client.Resume(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// Checkpoint calls the Checkpoint RPC.
+func (c *TaskClient) Checkpoint(ctx context.Context, req *taskAPI.CheckpointTaskRequest) (*ptypes.Empty, error) {
+	resp, err := c.client.Checkpoint(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// Kill calls the Kill RPC.
+func (c *TaskClient) Kill(ctx context.Context, req *taskAPI.KillRequest) (*ptypes.Empty, error) {
+	resp, err := c.client.Kill(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// Exec calls the Exec RPC.
+func (c *TaskClient) Exec(ctx context.Context, req *taskAPI.ExecProcessRequest) (*ptypes.Empty, error) {
+	resp, err := c.client.Exec(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// ResizePty calls the ResizePty RPC.
+func (c *TaskClient) ResizePty(ctx context.Context, req *taskAPI.ResizePtyRequest) (*ptypes.Empty, error) {
+	resp, err := c.client.ResizePty(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// CloseIO calls the CloseIO RPC.
+func (c *TaskClient) CloseIO(ctx context.Context, req *taskAPI.CloseIORequest) (*ptypes.Empty, error) {
+	resp, err := c.client.CloseIO(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// Update calls the Update RPC.
+func (c *TaskClient) Update(ctx context.Context, req *taskAPI.UpdateTaskRequest) (*ptypes.Empty, error) {
+	resp, err := c.client.Update(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// Wait calls the Wait RPC.
+func (c *TaskClient) Wait(ctx context.Context, req *taskAPI.WaitRequest) (*taskAPI.WaitResponse, error) {
+	resp, err := c.client.Wait(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// Stats calls the Stats RPC.
+func (c *TaskClient) Stats(ctx context.Context, req *taskAPI.StatsRequest) (*taskAPI.StatsResponse, error) {
+	resp, err := c.client.Stats(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// Connect calls the Connect RPC.
+func (c *TaskClient) Connect(ctx context.Context, req *taskAPI.ConnectRequest) (*taskAPI.ConnectResponse, error) {
+	resp, err := c.client.Connect(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
+
+// Shutdown calls the Shutdown RPC.
+func (c *TaskClient) Shutdown(ctx context.Context, req *taskAPI.ShutdownRequest) (*ptypes.Empty, error) {
+	resp, err := c.client.Shutdown(ctx, req)
+	return resp, errdefs.FromGRPC(err)
+}
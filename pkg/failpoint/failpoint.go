@@ -0,0 +1,52 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package failpoint injects faults into the shim to exercise its error
+// paths in integration and chaos tests.
+//
+// Failpoints are only compiled in with the failpoints build tag, e.g.
+//
+//	go build -tags failpoints ./cmd/...
+//
+// and are otherwise no-ops. They are armed with the GVISOR_SHIM_FAILPOINTS
+// environment variable, a ;-separated list of <name>=<action>, e.g.
+//
+//	GVISOR_SHIM_FAILPOINTS='mount-rootfs=error;runsc-timeout:start=1*error'
+//
+// or at runtime through /debug/failpoints on the debug socket: GET lists
+// the armed failpoints, PUT /debug/failpoints/<name> with the action as body
+// arms one and DELETE disarms it.
+//
+// Actions are "error" or "error(<message>)" to fail, "sleep(<duration>)" to
+// delay, "panic" and "off". An "<n>*" prefix limits the action to the next
+// n evaluations of the failpoint.
+package failpoint
+
+// EnvVar is the environment variable arming failpoints on startup.
+const EnvVar = "GVISOR_SHIM_FAILPOINTS"
+
+// Failpoints of the shim.
+const (
+	// MountRootfs fails mounting a rootfs component.
+	MountRootfs = "mount-rootfs"
+	// RunscTimeout times out a runsc command. It is suffixed with the
+	// command, e.g. runsc-timeout:create.
+	RunscTimeout = "runsc-timeout:"
+	// PublishEvent fails publishing an event to containerd.
+	PublishEvent = "publish-event"
+	// GoferDeath kills the gofers of a sandbox once it started.
+	GoferDeath = "gofer-death"
+)
@@ -0,0 +1,37 @@
+//go:build !failpoints
+// +build !failpoints
+
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package failpoint
+
+import "net/http"
+
+// Enabled is whether failpoints are compiled in.
+const Enabled = false
+
+// Inject evaluates the named failpoint. Without the failpoints build tag it
+// always returns nil.
+func Inject(name string) error {
+	return nil
+}
+
+// Handler serves the failpoints on the debug socket. Without the failpoints
+// build tag there is nothing to serve.
+func Handler() http.Handler {
+	return http.NotFoundHandler()
+}
@@ -0,0 +1,189 @@
+//go:build failpoints
+// +build failpoints
+
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package failpoint
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Enabled is whether failpoints are compiled in.
+const Enabled = true
+
+// action is what an armed failpoint does when evaluated.
+type action struct {
+	// spec is the action as armed, for listing.
+	spec string
+	kind string
+	arg  string
+	// count is the number of evaluations left, or -1 for unlimited.
+	count int
+}
+
+var (
+	mu     sync.Mutex
+	points = make(map[string]*action)
+)
+
+func init() {
+	v := os.Getenv(EnvVar)
+	if v == "" {
+		return
+	}
+	for _, p := range strings.Split(v, ";") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 {
+			logrus.Warnf("ignoring failpoint %q without action", p)
+			continue
+		}
+		if err := Set(kv[0], kv[1]); err != nil {
+			logrus.WithError(err).Warnf("ignoring failpoint %q", p)
+		}
+	}
+}
+
+// Set arms the named failpoint with spec, or disarms it if spec is "off".
+func Set(name, spec string) error {
+	a, err := parse(spec)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if a.kind == "off" {
+		delete(points, name)
+		return nil
+	}
+	points[name] = a
+	return nil
+}
+
+// List returns the armed failpoints and their actions.
+func List() map[string]string {
+	mu.Lock()
+	defer mu.Unlock()
+	out := make(map[string]string, len(points))
+	for name, a := range points {
+		out[name] = a.spec
+	}
+	return out
+}
+
+func parse(spec string) (*action, error) {
+	a := &action{spec: spec, count: -1}
+	rest := strings.TrimSpace(spec)
+	if i := strings.Index(rest, "*"); i > 0 && !strings.Contains(rest[:i], "(") {
+		n, err := strconv.Atoi(rest[:i])
+		if err != nil || n <= 0 {
+			return nil, errors.Errorf("invalid failpoint count in %q", spec)
+		}
+		a.count = n
+		rest = rest[i+1:]
+	}
+	a.kind = rest
+	if i := strings.Index(rest, "("); i >= 0 {
+		if !strings.HasSuffix(rest, ")") {
+			return nil, errors.Errorf("invalid failpoint action %q", spec)
+		}
+		a.kind, a.arg = rest[:i], rest[i+1:len(rest)-1]
+	}
+	switch a.kind {
+	case "off", "panic":
+	case "error":
+		if a.arg == "" {
+			a.arg = "injected failure"
+		}
+	case "sleep":
+		if _, err := time.ParseDuration(a.arg); err != nil {
+			return nil, errors.Wrapf(err, "invalid failpoint action %q", spec)
+		}
+	default:
+		return nil, errors.Errorf("unknown failpoint action %q", spec)
+	}
+	return a, nil
+}
+
+// Inject evaluates the named failpoint. It returns an error if the
+// failpoint is armed to fail, and nil otherwise.
+func Inject(name string) error {
+	mu.Lock()
+	a, ok := points[name]
+	if ok && a.count > 0 {
+		if a.count--; a.count == 0 {
+			delete(points, name)
+		}
+	}
+	mu.Unlock()
+	if !ok {
+		return nil
+	}
+	logrus.WithField("action", a.spec).Warnf("failpoint %s triggered", name)
+	switch a.kind {
+	case "error":
+		return errors.Errorf("failpoint %s: %s", name, a.arg)
+	case "sleep":
+		d, _ := time.ParseDuration(a.arg)
+		time.Sleep(d)
+	case "panic":
+		panic("failpoint " + name)
+	}
+	return nil
+}
+
+// Handler serves the failpoints on the debug socket under
+// /debug/failpoints.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/debug/failpoints"), "/")
+		switch {
+		case r.Method == http.MethodGet && name == "":
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(List())
+		case r.Method == http.MethodPut && name != "":
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := Set(name, string(body)); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodDelete && name != "":
+			Set(name, "off")
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "unsupported failpoint request", http.StatusMethodNotAllowed)
+		}
+	})
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runsc
+
+import (
+	"context"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/google/gvisor-containerd-shim/pkg/failpoint"
+	"github.com/google/gvisor-containerd-shim/pkg/tracing"
+)
+
+// DefaultRetries is the number of times idempotent commands are retried
+// when no retry count is configured.
+const DefaultRetries = 2
+
+// retryBackoff is the initial delay between retries of idempotent commands.
+const retryBackoff = 100 * time.Millisecond
+
+// outputWaitDelay bounds how long the output of a command is read after it
+// exited or was killed.
+const outputWaitDelay = 5 * time.Second
+
+// DefaultTimeouts are the per-command timeouts used when a command has no
+// timeout configured in Runsc.Timeouts. Commands missing from the map, such
+// as create, start and exec, are only bounded by FallbackTimeouts.
+var DefaultTimeouts = map[string]time.Duration{
+	"list":           10 * time.Second,
+	"state":          10 * time.Second,
+	"ps":             10 * time.Second,
+	"kill":           10 * time.Second,
+	"delete":         60 * time.Second,
+	"stats":          10 * time.Second,
+	"version":        10 * time.Second,
+	"flags":          10 * time.Second,
+	"pause":          10 * time.Second,
+	"resume":         10 * time.Second,
+	"export-metrics": 10 * time.Second,
+}
+
+// FallbackTimeouts bound the commands without a timeout when the context
+// they are run with has no deadline either, so that a command hung on a
+// broken sandbox isn't left running once the client gave up on it. Long
+// running commands, such as wait and port-forward, are missing and end with
+// their context only.
+var FallbackTimeouts = map[string]time.Duration{
+	"create":     5 * time.Minute,
+	"start":      5 * time.Minute,
+	"exec":       5 * time.Minute,
+	"checkpoint": 15 * time.Minute,
+	"restore":    15 * time.Minute,
+}
+
+// idempotent commands may be retried safely after a failure.
+var idempotent = map[string]bool{
+	"list":           true,
+	"state":          true,
+	"ps":             true,
+	"stats":          true,
+	"version":        true,
+	"flags":          true,
+	"export-metrics": true,
+}
+
+// timeout returns the timeout for the named command run with ctx. The
+// deadline of ctx applies too, whichever comes first.
+func (r *Runsc) timeout(ctx context.Context, name string) time.Duration {
+	if t, ok := r.Timeouts[name]; ok {
+		return t
+	}
+	if t, ok := DefaultTimeouts[name]; ok {
+		return t
+	}
+	if _, ok := ctx.Deadline(); ok {
+		return 0
+	}
+	return FallbackTimeouts[name]
+}
+
+func (r *Runsc) retries(name string) int {
+	if !idempotent[name] {
+		return 0
+	}
+	if r.Retries < 0 {
+		return 0
+	}
+	if r.Retries == 0 {
+		return DefaultRetries
+	}
+	return r.Retries
+}
+
+// run calls fn with a context bounded by the timeout of the named command,
+// retrying idempotent commands that fail for reasons other than a missing
+// container. Durations are logged at debug level.
+func (r *Runsc) run(ctx context.Context, name string, fn func(context.Context) error) error {
+	var (
+		err     error
+		backoff = retryBackoff
+		retries = r.retries(name)
+	)
+	for attempt := 0; ; attempt++ {
+		err = r.runOnce(ctx, name, fn)
+		if err == nil || IsNotFound(err) || attempt >= retries || ctx.Err() != nil {
+			return err
+		}
+		log.G(ctx).WithError(err).WithField("command", name).Debug("retrying runsc command")
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (r *Runsc) runOnce(ctx context.Context, name string, fn func(context.Context) error) error {
+	ctx, span := tracing.Start(ctx, "runsc "+name, tracing.Attr("runsc.command", name))
+	var queued time.Duration
+	if r.Limiter != nil && limited[name] {
+		release, q, err := r.Limiter.acquire(ctx, name)
+		if err != nil {
+			err = errors.Wrapf(err, "runsc %s: waiting for the runsc limits", name)
+			span.End(err)
+			return err
+		}
+		defer release()
+		queued = q
+	}
+	var cancel context.CancelFunc
+	cctx := ctx
+	t := r.timeout(ctx, name)
+	if t > 0 {
+		cctx, cancel = context.WithTimeout(ctx, t)
+		defer cancel()
+	}
+	start := time.Now()
+	var err error
+	if ferr := failpoint.Inject(failpoint.RunscTimeout + name); ferr != nil {
+		err = &Error{
+			Command: name,
+			Status:  -1,
+			Err:     errors.Wrapf(ferr, "runsc %s timed out after %v", name, t),
+			kind:    ErrTimeout,
+		}
+	} else {
+		err = fn(cctx)
+	}
+	if err != nil && ctx.Err() == nil && cctx.Err() == context.DeadlineExceeded {
+		err = &Error{
+			Command: name,
+			Status:  -1,
+			Err:     errors.Wrapf(err, "runsc %s timed out after %v", name, t),
+			kind:    ErrTimeout,
+		}
+	}
+	if err != nil && ctx.Err() != nil {
+		// The command was killed with the context of the caller, e.g. once
+		// the client of the request went away.
+		err = errors.Wrapf(err, "runsc %s interrupted: %v", name, ctx.Err())
+	}
+	entry := log.G(ctx).WithFields(logrus.Fields{
+		"command":  name,
+		"duration": time.Since(start),
+	})
+	if queued > 0 {
+		entry = entry.WithField("queued", queued)
+	}
+	if err != nil {
+		entry = entry.WithError(err)
+	}
+	entry.Debug("runsc command completed")
+	span.End(err)
+	return err
+}
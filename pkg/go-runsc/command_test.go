@@ -0,0 +1,196 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runsc_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
+	"github.com/google/gvisor-containerd-shim/pkg/test"
+)
+
+// fakeRunsc is the fake runsc of the test harness, run through a wrapper
+// counting its invocations.
+type fakeRunsc struct {
+	dir string
+	// command is the path of the wrapper.
+	command string
+	// root is the runsc root of the containers.
+	root string
+}
+
+func newFakeRunsc(t *testing.T) (*fakeRunsc, func()) {
+	dir, err := ioutil.TempDir("", "runsc-command-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin, err := test.BuildFakeRunsc(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	f := &fakeRunsc{
+		dir:     dir,
+		command: filepath.Join(dir, "runsc-wrapper"),
+		root:    filepath.Join(dir, "root"),
+	}
+	wrapper := fmt.Sprintf("#!/bin/sh\necho \"$@\" >> %s\nexec %s \"$@\"\n", filepath.Join(dir, "calls"), bin)
+	if err := ioutil.WriteFile(f.command, []byte(wrapper), 0755); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return f, func() { os.RemoveAll(dir) }
+}
+
+// runsc returns a client of the fake runsc with the retries and timeouts.
+func (f *fakeRunsc) runsc(retries int, timeouts map[string]time.Duration) *runsc.Runsc {
+	return &runsc.Runsc{
+		Command:  f.command,
+		Root:     f.root,
+		Retries:  retries,
+		Timeouts: timeouts,
+	}
+}
+
+// container writes the state of a container, wedged if wedged is set, and
+// returns its id. An empty state is invalid JSON.
+func (f *fakeRunsc) container(t *testing.T, id, state string, wedged bool) string {
+	dir := filepath.Join(f.root, id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "state.json"), []byte(state), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if wedged {
+		if err := ioutil.WriteFile(filepath.Join(dir, "wedged"), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return id
+}
+
+// calls returns the number of times the command was run.
+func (f *fakeRunsc) calls(t *testing.T, command string) int {
+	data, err := ioutil.ReadFile(filepath.Join(f.dir, "calls"))
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		for _, arg := range strings.Fields(line) {
+			if !strings.HasPrefix(arg, "-") {
+				if arg == command {
+					n++
+				}
+				break
+			}
+		}
+	}
+	return n
+}
+
+func TestCommandRetries(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		retries  int
+		state    string
+		notFound bool
+		calls    int
+	}{
+		{name: "success", state: `{"id": "c1", "status": "stopped"}`, calls: 1},
+		{name: "default retries", calls: 1 + runsc.DefaultRetries},
+		{name: "configured retries", retries: 1, calls: 2},
+		{name: "retries disabled", retries: -1, calls: 1},
+		{name: "missing container", notFound: true, calls: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f, cleanup := newFakeRunsc(t)
+			defer cleanup()
+			if !tc.notFound {
+				f.container(t, "c1", tc.state, false)
+			}
+			_, err := f.runsc(tc.retries, nil).State(context.Background(), "c1")
+			switch {
+			case tc.state != "":
+				if err != nil {
+					t.Fatal(err)
+				}
+			case tc.notFound:
+				if !runsc.IsNotFound(err) {
+					t.Fatalf("got %v, want a not found error", err)
+				}
+			case err == nil:
+				t.Fatal("state of a corrupt container succeeded")
+			}
+			if got := f.calls(t, "state"); got != tc.calls {
+				t.Errorf("runsc state run %d times, want %d", got, tc.calls)
+			}
+		})
+	}
+}
+
+func TestCommandTimeout(t *testing.T) {
+	f, cleanup := newFakeRunsc(t)
+	defer cleanup()
+	id := f.container(t, "c1", `{"id": "c1", "status": "stopped"}`, true)
+	r := f.runsc(0, map[string]time.Duration{"kill": 200 * time.Millisecond})
+
+	start := time.Now()
+	err := r.Kill(context.Background(), id, 9, nil)
+	if !runsc.IsTimeout(err) {
+		t.Fatalf("got %v, want a timeout error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("timed out kill returned after %v", elapsed)
+	}
+	if rerr, ok := err.(*runsc.Error); !ok || rerr.Command != "kill" {
+		t.Errorf("got %#v, want a runsc error of the kill command", err)
+	}
+	// kill isn't idempotent, it is never retried.
+	if got := f.calls(t, "kill"); got != 1 {
+		t.Errorf("runsc kill run %d times, want 1", got)
+	}
+}
+
+func TestCommandInterrupted(t *testing.T) {
+	f, cleanup := newFakeRunsc(t)
+	defer cleanup()
+	id := f.container(t, "c1", `{"id": "c1", "status": "stopped"}`, true)
+	r := f.runsc(0, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	err := r.Kill(ctx, id, 9, nil)
+	if err == nil {
+		t.Fatal("kill of a wedged container succeeded")
+	}
+	// The deadline of the caller isn't a timeout of the command.
+	if runsc.IsTimeout(err) {
+		t.Errorf("got %v, want the command interrupted by the caller", err)
+	}
+	if !strings.Contains(err.Error(), "interrupted") {
+		t.Errorf("error %q doesn't report the interruption", err)
+	}
+}
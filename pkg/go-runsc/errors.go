@@ -0,0 +1,209 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runsc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrContainerNotFound is returned when runsc reports that the container
+	// does not exist.
+	ErrContainerNotFound = errors.New("container not found")
+	// ErrGoferUnreachable is returned when the sandbox lost its connection to
+	// the gofer.
+	ErrGoferUnreachable = errors.New("gofer unreachable")
+	// ErrOOM is returned when runsc or the sandbox ran out of memory.
+	ErrOOM = errors.New("out of memory")
+	// ErrTimeout is returned when a runsc command does not complete within
+	// its configured timeout.
+	ErrTimeout = errors.New("command timed out")
+	// ErrNotRunsc is returned when the runtime binary does not identify
+	// itself as runsc.
+	ErrNotRunsc = errors.New("runtime binary is not runsc")
+	// ErrAlreadyExists is returned when runsc reports that the container
+	// already exists.
+	ErrAlreadyExists = errors.New("container already exists")
+	// ErrInvalidArgument is returned when runsc rejects its flags or the
+	// bundle spec.
+	ErrInvalidArgument = errors.New("invalid argument")
+	// ErrPermissionDenied is returned when runsc lacks the privileges to
+	// perform the command.
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrResourceExhausted is returned when the host ran out of a resource
+	// other than memory, such as disk space or file descriptors.
+	ErrResourceExhausted = errors.New("resource exhausted")
+)
+
+// exitUsage is the exit status of runsc when it is invoked with invalid
+// flags or arguments.
+const exitUsage = 2
+
+// errorPatterns maps runsc output to typed errors. The first match wins.
+var errorPatterns = []struct {
+	kind     error
+	patterns []string
+}{
+	{
+		kind:     ErrContainerNotFound,
+		patterns: []string{"does not exist", "container not found", "no such container"},
+	},
+	{
+		kind: ErrGoferUnreachable,
+		patterns: []string{
+			"gofer: connection refused",
+			"gofer connection",
+			"connecting to gofer",
+			"gofer is unreachable",
+			"gofer terminated",
+		},
+	},
+	{
+		kind:     ErrOOM,
+		patterns: []string{"out of memory", "cannot allocate memory", "oom-kill", "oom killed"},
+	},
+	{
+		kind:     ErrAlreadyExists,
+		patterns: []string{"already exists"},
+	},
+	{
+		kind: ErrResourceExhausted,
+		patterns: []string{
+			"no space left on device",
+			"too many open files",
+			"disk quota exceeded",
+			"resource temporarily unavailable",
+		},
+	},
+	{
+		kind:     ErrPermissionDenied,
+		patterns: []string{"permission denied", "operation not permitted"},
+	},
+	{
+		kind: ErrInvalidArgument,
+		patterns: []string{
+			"flag provided but not defined",
+			"invalid flag",
+			"reading spec",
+			"validating spec",
+			"invalid spec",
+		},
+	},
+}
+
+// Error is returned when a runsc command fails. It carries the command name,
+// its exit status and its output so that callers can inspect the failure.
+type Error struct {
+	// Command is the runsc subcommand, e.g. "create".
+	Command string
+	// Status is the exit status of runsc, or -1 if it did not exit.
+	Status int
+	// Output is the combined output of the command.
+	Output string
+	// Err is the underlying error.
+	Err error
+
+	kind error
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	if e.Output == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.Err, e.Output)
+}
+
+// Cause returns the typed error classifying the failure, or the underlying
+// error if the failure could not be classified.
+func (e *Error) Cause() error {
+	if e.kind != nil {
+		return e.kind
+	}
+	return e.Err
+}
+
+// Unwrap returns the same error as Cause.
+func (e *Error) Unwrap() error {
+	return e.Cause()
+}
+
+// newError returns a classified Error for a failed runsc command.
+func newError(command string, status int, output []byte, err error) *Error {
+	e := &Error{
+		Command: command,
+		Status:  status,
+		Output:  strings.TrimSpace(string(output)),
+		Err:     err,
+	}
+	e.kind = Classify(e.Output)
+	if e.kind == nil && status == exitUsage {
+		e.kind = ErrInvalidArgument
+	}
+	return e
+}
+
+// Classify returns the typed error matching a runsc error message, or nil if
+// the message is not recognized. It is used on the errors runsc writes to
+// its log file, which are not part of the command output.
+func Classify(output string) error {
+	output = strings.ToLower(output)
+	for _, p := range errorPatterns {
+		for _, pattern := range p.patterns {
+			if strings.Contains(output, pattern) {
+				return p.kind
+			}
+		}
+	}
+	return nil
+}
+
+// IsNotFound returns true if the error is caused by a missing container.
+func IsNotFound(err error) bool {
+	return errors.Cause(err) == ErrContainerNotFound
+}
+
+// IsGoferUnreachable returns true if the error is caused by the sandbox
+// losing its gofer.
+func IsGoferUnreachable(err error) bool {
+	return errors.Cause(err) == ErrGoferUnreachable
+}
+
+// IsOOM returns true if the error is caused by memory exhaustion.
+func IsOOM(err error) bool {
+	return errors.Cause(err) == ErrOOM
+}
+
+// IsTimeout returns true if the error is caused by a command timeout.
+func IsTimeout(err error) bool {
+	return errors.Cause(err) == ErrTimeout
+}
+
+// IsAlreadyExists returns true if the error is caused by an existing
+// container.
+func IsAlreadyExists(err error) bool {
+	return errors.Cause(err) == ErrAlreadyExists
+}
+
+// IsNotRunsc returns true if the error is caused by a runtime binary that is
+// not runsc.
+func IsNotRunsc(err error) bool {
+	return errors.Cause(err) == ErrNotRunsc
+}
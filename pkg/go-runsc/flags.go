@@ -0,0 +1,187 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runsc
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// flagKind is the format of the value of a runsc flag.
+type flagKind int
+
+const (
+	stringFlag flagKind = iota
+	boolFlag
+	intFlag
+)
+
+// flagSpec describes the value of a runsc flag.
+type flagSpec struct {
+	kind flagKind
+	// values are the accepted values of string flags, any value when empty.
+	values []string
+}
+
+var (
+	anyString = flagSpec{kind: stringFlag}
+	anyBool   = flagSpec{kind: boolFlag}
+	anyInt    = flagSpec{kind: intFlag}
+)
+
+func oneOf(values ...string) flagSpec {
+	return flagSpec{kind: stringFlag, values: values}
+}
+
+// globalFlags are the runsc global flags that may be set in the runsc
+// config, maintained from the flags of runsc releases. Flags set by the
+// shim itself, such as --root and --log, are not listed. Flags added by
+// newer releases can be passed unchecked until they are listed here.
+var globalFlags = map[string]flagSpec{
+	// Logging and debugging.
+	"debug":             anyBool,
+	"debug-log":         anyString,
+	"debug-log-format":  oneOf("text", "json", "json-k8s"),
+	"debug-to-user-log": anyBool,
+	"panic-log":         anyString,
+	"coverage-report":   anyString,
+	"user-log":          anyString,
+	"log-packets":       anyBool,
+	"pcap-log":          anyString,
+	"alsologtostderr":   anyBool,
+	"strace":            anyBool,
+	"strace-syscalls":   anyString,
+	"strace-log-size":   anyInt,
+	"strace-event":      anyBool,
+	"panic-signal":      anyInt,
+	"trace-signal":      anyInt,
+	"watchdog-action":   oneOf("log", "panic"),
+	"profile":           anyBool,
+	"profile-block":     anyString,
+	"profile-cpu":       anyString,
+	"profile-heap":      anyString,
+	"profile-mutex":     anyString,
+	"trace":             anyString,
+	"metric-server":     anyString,
+	"ref-leak-mode":     oneOf("disabled", "log-names", "log-traces"),
+
+	// Platform.
+	"platform":             oneOf("ptrace", "kvm", "systrap"),
+	"platform-device-path": anyString,
+
+	// Filesystem.
+	"file-access":        oneOf("exclusive", "shared"),
+	"file-access-mounts": oneOf("exclusive", "shared"),
+	"overlay":            anyBool,
+	"overlay2":           anyString,
+	"fsgofer-host-uds":   anyBool,
+	"host-uds":           oneOf("none", "open", "create", "all"),
+	"host-fifo":          oneOf("none", "open"),
+	"vfs2":               anyBool,
+	"fuse":               anyBool,
+	"lisafs":             anyBool,
+	"directfs":           anyBool,
+	"dcache":             anyInt,
+	"iouring":            anyBool,
+
+	// Networking.
+	"network":                     oneOf("sandbox", "host", "none"),
+	"net-raw":                     anyBool,
+	"gso":                         anyBool,
+	"software-gso":                anyBool,
+	"tx-checksum-offload":         anyBool,
+	"rx-checksum-offload":         anyBool,
+	"qdisc":                       oneOf("none", "fifo"),
+	"num-network-channels":        anyInt,
+	"buffer-pooling":              anyBool,
+	"allow-packet-endpoint-write": anyBool,
+	"net-disconnect-ok":           anyBool,
+	"reproduce-nat":               anyBool,
+	"reproduce-nftables":          anyBool,
+	"EXPERIMENTAL-afxdp":          anyBool,
+
+	// Resources and devices.
+	"cgroupfs":           anyBool,
+	"ignore-cgroups":     anyBool,
+	"systemd-cgroup":     anyBool,
+	"cpu-num-from-quota": anyBool,
+	"nvproxy":            anyBool,
+	"nvproxy-docker":     anyBool,
+	"tpuproxy":           anyBool,
+
+	// Security.
+	"rootless":                anyBool,
+	"TESTONLY-unsafe-nonroot": anyBool,
+	"oci-seccomp":             anyBool,
+	"allow-flag-override":     anyBool,
+	"enable-core-tags":        anyBool,
+}
+
+// ValidateConfig checks that the runsc flags of config are known global
+// flags with values of the right format, so that a typo is reported before
+// the start of the sandbox fails. The table lags behind runsc releases, so
+// callers only warn about the error and leave it to runsc to reject the
+// flag. Flags in unchecked are accepted as is, e.g. flags of a newer runsc.
+// Values may use the %ID% and %COMMAND% placeholders of the log paths.
+func ValidateConfig(config map[string]string, unchecked []string) error {
+	skip := make(map[string]bool, len(unchecked))
+	for _, f := range unchecked {
+		skip[f] = true
+	}
+	keys := make([]string, 0, len(config))
+	for k := range config {
+		keys = append(keys, k)
+	}
+	// Report the same flag first on every run.
+	sort.Strings(keys)
+	for _, k := range keys {
+		if skip[k] {
+			continue
+		}
+		spec, ok := globalFlags[k]
+		if !ok {
+			return errors.Wrapf(ErrInvalidArgument, "unknown runsc flag %q", k)
+		}
+		if err := spec.check(config[k]); err != nil {
+			return errors.Wrapf(ErrInvalidArgument, "invalid value %q of runsc flag %q: %v", config[k], k, err)
+		}
+	}
+	return nil
+}
+
+func (s flagSpec) check(v string) error {
+	switch s.kind {
+	case boolFlag:
+		_, err := strconv.ParseBool(v)
+		return err
+	case intFlag:
+		_, err := strconv.ParseInt(v, 0, 64)
+		return err
+	}
+	if len(s.values) == 0 {
+		return nil
+	}
+	for _, allowed := range s.values {
+		if v == allowed {
+			return nil
+		}
+	}
+	return errors.Errorf("must be one of %s", strings.Join(s.values, ", "))
+}
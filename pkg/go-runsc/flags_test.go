@@ -0,0 +1,99 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runsc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestValidateConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		config    map[string]string
+		unchecked []string
+		// flag is the flag the error names, empty for valid configs.
+		flag string
+	}{
+		{name: "empty"},
+		{
+			name: "valid values",
+			config: map[string]string{
+				"debug":                "true",
+				"debug-log":            "/var/log/runsc/%ID%/log-%COMMAND%.txt",
+				"platform":             "kvm",
+				"panic-signal":         "0x1f",
+				"num-network-channels": "4",
+				"overlay2":             "root:memory",
+			},
+		},
+		{
+			name:   "unknown flag",
+			config: map[string]string{"platfrom": "kvm"},
+			flag:   "platfrom",
+		},
+		{
+			name:   "invalid bool",
+			config: map[string]string{"debug": "yes"},
+			flag:   "debug",
+		},
+		{
+			name:   "invalid int",
+			config: map[string]string{"num-network-channels": "four"},
+			flag:   "num-network-channels",
+		},
+		{
+			name:   "value not in the set",
+			config: map[string]string{"network": "bridge"},
+			flag:   "network",
+		},
+		{
+			name:   "first invalid flag in order",
+			config: map[string]string{"platform": "vm", "debug": "yes"},
+			flag:   "debug",
+		},
+		{
+			name:      "unchecked flags",
+			config:    map[string]string{"new-flag": "x", "platform": "vm"},
+			unchecked: []string{"new-flag", "platform"},
+		},
+		{
+			name:      "unchecked flags only skip themselves",
+			config:    map[string]string{"new-flag": "x", "network": "bridge"},
+			unchecked: []string{"new-flag"},
+			flag:      "network",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateConfig(tc.config, tc.unchecked)
+			if tc.flag == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if errors.Cause(err) != ErrInvalidArgument {
+				t.Fatalf("got %v, want an invalid argument error", err)
+			}
+			if !strings.Contains(err.Error(), `"`+tc.flag+`"`) {
+				t.Errorf("error %q doesn't name flag %s", err, tc.flag)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runsc
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// limited are the commands bounded by a Limiter: those spawning or tearing
+// down sandboxes. Commands that last as long as a container or an exec
+// process, such as wait or exec, would hold their slot for too long.
+var limited = map[string]bool{
+	"create":     true,
+	"start":      true,
+	"restore":    true,
+	"checkpoint": true,
+	"delete":     true,
+}
+
+// slotPollInterval is how often the slots of a Limiter are retried while
+// they are all taken.
+const slotPollInterval = 50 * time.Millisecond
+
+// Limits bound the runsc commands run by all the shims of a namespace.
+type Limits struct {
+	// Concurrency is the number of commands run at once. Zero leaves it
+	// unbounded.
+	Concurrency int
+	// Rate is the number of commands started per second. Zero leaves it
+	// unbounded.
+	Rate float64
+}
+
+// Validate checks the limits.
+func (l Limits) Validate() error {
+	if l.Concurrency < 0 {
+		return errors.Errorf("invalid runsc concurrency %d", l.Concurrency)
+	}
+	if l.Rate < 0 {
+		return errors.Errorf("invalid runsc rate %v", l.Rate)
+	}
+	return nil
+}
+
+// Enabled returns whether any limit is set.
+func (l Limits) Enabled() bool {
+	return l.Concurrency > 0 || l.Rate > 0
+}
+
+// Apply bounds the commands of r, if any limit is set.
+func (l Limits) Apply(r *Runsc) {
+	if l.Enabled() {
+		r.Limiter = NewLimiter(LimiterDir(r.Root), l)
+	}
+}
+
+// QueueStat is the time the commands of a kind waited for a Limiter.
+type QueueStat struct {
+	Command string        `json:"command"`
+	Count   uint64        `json:"count"`
+	Total   time.Duration `json:"total"`
+	Max     time.Duration `json:"max"`
+}
+
+// Limiter bounds the concurrency and the rate of runsc commands across
+// processes: the shims of a namespace share its directory, where each
+// concurrency slot is a file locked by the command holding it and the
+// schedule of the rate limit is a file updated under lock.
+type Limiter struct {
+	dir    string
+	limits Limits
+
+	mu    sync.Mutex
+	stats map[string]*QueueStat
+}
+
+// NewLimiter returns a Limiter sharing its state in dir with the other
+// limiters of dir.
+func NewLimiter(dir string, limits Limits) *Limiter {
+	return &Limiter{
+		dir:    dir,
+		limits: limits,
+		stats:  make(map[string]*QueueStat),
+	}
+}
+
+// LimiterDir is the directory the limiters of the commands run with root
+// share. Limits are per namespace, like root, but their state is kept out
+// of root where runsc keeps its containers.
+func LimiterDir(root string) string {
+	return filepath.Join(filepath.Dir(root), ".limits", filepath.Base(root))
+}
+
+// Stats returns the time commands waited for the limiter, by command, none
+// if l is nil.
+func (l *Limiter) Stats() []QueueStat {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats := make([]QueueStat, 0, len(l.stats))
+	for _, s := range l.stats {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Command < stats[j].Command })
+	return stats
+}
+
+// acquire waits for the command name to be allowed to run. The returned
+// function releases its concurrency slot.
+func (l *Limiter) acquire(ctx context.Context, name string) (func(), time.Duration, error) {
+	start := time.Now()
+	if err := os.MkdirAll(l.dir, 0700); err != nil {
+		return nil, 0, errors.Wrap(err, "failed to create runsc limiter directory")
+	}
+	if l.limits.Rate > 0 {
+		if err := l.waitRate(ctx); err != nil {
+			return nil, 0, err
+		}
+	}
+	release := func() {}
+	if l.limits.Concurrency > 0 {
+		slot, err := l.waitSlot(ctx)
+		if err != nil {
+			return nil, 0, err
+		}
+		release = func() { slot.Close() }
+	}
+	queued := time.Since(start)
+	l.record(name, queued)
+	return release, queued, nil
+}
+
+func (l *Limiter) record(name string, queued time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s, ok := l.stats[name]
+	if !ok {
+		s = &QueueStat{Command: name}
+		l.stats[name] = s
+	}
+	s.Count++
+	s.Total += queued
+	if queued > s.Max {
+		s.Max = queued
+	}
+}
+
+// waitRate waits for the next start time of the rate limit. The schedule
+// file holds the earliest time the next command may start, in unix
+// nanoseconds, and each command reserves its start time by pushing it one
+// interval further.
+func (l *Limiter) waitRate(ctx context.Context) error {
+	f, err := os.OpenFile(filepath.Join(l.dir, "rate"), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to open runsc rate schedule")
+	}
+	defer f.Close()
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX); err != nil {
+		return errors.Wrap(err, "failed to lock runsc rate schedule")
+	}
+	var buf [8]byte
+	next := time.Now()
+	if n, _ := f.ReadAt(buf[:], 0); n == len(buf) {
+		if t := time.Unix(0, int64(binary.LittleEndian.Uint64(buf[:]))); t.After(next) {
+			next = t
+		}
+	}
+	interval := time.Duration(float64(time.Second) / l.limits.Rate)
+	binary.LittleEndian.PutUint64(buf[:], uint64(next.Add(interval).UnixNano()))
+	_, err = f.WriteAt(buf[:], 0)
+	unix.Flock(int(f.Fd()), unix.LOCK_UN)
+	if err != nil {
+		return errors.Wrap(err, "failed to update runsc rate schedule")
+	}
+	wait := time.Until(next)
+	if wait <= 0 {
+		return nil
+	}
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// waitSlot locks one of the concurrency slot files, waiting for one to be
+// released if they are all locked. Locks are released by the kernel when
+// their holder dies, so slots aren't leaked by crashed shims.
+func (l *Limiter) waitSlot(ctx context.Context) (*os.File, error) {
+	for {
+		for i := 0; i < l.limits.Concurrency; i++ {
+			f, err := os.OpenFile(filepath.Join(l.dir, fmt.Sprintf("slot-%d", i)), os.O_RDONLY|os.O_CREATE, 0600)
+			if err != nil {
+				return nil, errors.Wrap(err, "failed to open runsc concurrency slot")
+			}
+			if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err == nil {
+				return f, nil
+			}
+			f.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(slotPollInterval):
+		}
+	}
+}
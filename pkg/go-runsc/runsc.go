@@ -23,17 +23,24 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	runc "github.com/containerd/go-runc"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
 )
 
+// Monitor starts and waits for the commands of the clients without a
+// monitor of their own.
 var Monitor runc.ProcessMonitor = runc.Monitor
 
 // DefaultCommand is the default command for Runsc
@@ -47,13 +54,57 @@ type Runsc struct {
 	Root         string
 	Log          string
 	LogFormat    runc.Format
-	Config       map[string]string
+	// Config are the runsc flags, --key=value. Once the client is in use,
+	// they are only replaced through SetConfig.
+	Config map[string]string
+	// Timeouts overrides DefaultTimeouts and FallbackTimeouts per runsc
+	// subcommand. A zero duration disables the timeout for that command,
+	// which then only ends with the context it is run with.
+	Timeouts map[string]time.Duration
+	// Retries is the number of times idempotent commands are retried.
+	// Zero selects DefaultRetries, a negative value disables retries.
+	Retries int
+	// Limiter bounds the create, start, restore, checkpoint and delete
+	// commands of all the shims of the namespace. Nil leaves them
+	// unbounded.
+	Limiter *Limiter
+	// Monitor starts and waits for the runsc commands of the client. Nil
+	// selects the package Monitor.
+	Monitor runc.ProcessMonitor
+
+	configMu sync.RWMutex
+}
+
+// monitor returns the monitor of the commands of the client.
+func (r *Runsc) monitor() runc.ProcessMonitor {
+	if r.Monitor != nil {
+		return r.Monitor
+	}
+	return Monitor
+}
+
+// Flags returns the runsc flags of the client.
+func (r *Runsc) Flags() map[string]string {
+	r.configMu.RLock()
+	defer r.configMu.RUnlock()
+	return r.Config
+}
+
+// SetConfig replaces the runsc flags of the commands run from now on. The
+// client keeps config, which must not be modified afterwards.
+func (r *Runsc) SetConfig(config map[string]string) {
+	r.configMu.Lock()
+	defer r.configMu.Unlock()
+	r.Config = config
 }
 
 // List returns all containers created inside the provided runsc root directory
-func (r *Runsc) List(context context.Context) ([]*runc.Container, error) {
-	data, err := cmdOutput(r.command(context, "list", "--format=json"), false)
-	if err != nil {
+func (r *Runsc) List(ctx context.Context) ([]*runc.Container, error) {
+	var data []byte
+	if err := r.run(ctx, "list", func(ctx context.Context) (err error) {
+		data, err = r.cmdOutput(r.command(ctx, "list", "--format=json"), false)
+		return err
+	}); err != nil {
 		return nil, err
 	}
 	var out []*runc.Container
@@ -64,10 +115,13 @@ func (r *Runsc) List(context context.Context) ([]*runc.Container, error) {
 }
 
 // State returns the state for the container provided by id
-func (r *Runsc) State(context context.Context, id string) (*runc.Container, error) {
-	data, err := cmdOutput(r.command(context, "state", id), true)
-	if err != nil {
-		return nil, fmt.Errorf("%s: %s", err, data)
+func (r *Runsc) State(ctx context.Context, id string) (*runc.Container, error) {
+	var data []byte
+	if err := r.run(ctx, "state", func(ctx context.Context) (err error) {
+		data, err = r.cmdOutput(r.command(ctx, "state", id), true)
+		return err
+	}); err != nil {
+		return nil, err
 	}
 	var c runc.Container
 	if err := json.Unmarshal(data, &c); err != nil {
@@ -102,79 +156,48 @@ func (o *CreateOpts) args() (out []string, err error) {
 	return out, nil
 }
 
-// Create creates a new container and returns its pid if it was created successfully
-func (r *Runsc) Create(context context.Context, id, bundle string, opts *CreateOpts) error {
+func createArgs(id, bundle string, opts *CreateOpts) ([]string, error) {
 	args := []string{"create", "--bundle", bundle}
 	if opts != nil {
 		oargs, err := opts.args()
 		if err != nil {
-			return err
+			return nil, err
 		}
 		args = append(args, oargs...)
 	}
-	cmd := r.command(context, append(args, id)...)
-	if opts != nil && opts.IO != nil {
-		opts.Set(cmd)
-	}
+	return append(args, id), nil
+}
 
-	if cmd.Stdout == nil && cmd.Stderr == nil {
-		data, err := cmdOutput(cmd, true)
-		if err != nil {
-			return fmt.Errorf("%s: %s", err, data)
-		}
-		return nil
-	}
-	ec, err := Monitor.Start(cmd)
+// Create creates a new container and returns its pid if it was created successfully
+func (r *Runsc) Create(ctx context.Context, id, bundle string, opts *CreateOpts) error {
+	args, err := createArgs(id, bundle, opts)
 	if err != nil {
 		return err
 	}
-	if opts != nil && opts.IO != nil {
-		if c, ok := opts.IO.(runc.StartCloser); ok {
-			if err := c.CloseAfterStart(); err != nil {
-				return err
-			}
+	return r.run(ctx, "create", func(ctx context.Context) error {
+		cmd := r.command(ctx, args...)
+		var cio runc.IO
+		if opts != nil {
+			cio = opts.IO
 		}
-	}
-	status, err := Monitor.Wait(cmd, ec)
-	if err == nil && status != 0 {
-		err = fmt.Errorf("%s did not terminate sucessfully", cmd.Args[0])
-	}
-
-	return err
+		return r.runWithIO(cmd, "create", cio)
+	})
 }
 
-// Start will start an already created container
-func (r *Runsc) Start(context context.Context, id string, cio runc.IO) error {
-	cmd := r.command(context, "start", id)
-	if cio != nil {
-		cio.Set(cmd)
-	}
-
-	if cmd.Stdout == nil && cmd.Stderr == nil {
-		data, err := cmdOutput(cmd, true)
-		if err != nil {
-			return fmt.Errorf("%s: %s", err, data)
-		}
-		return nil
-	}
-
-	ec, err := Monitor.Start(cmd)
+// CreateCommand returns the command line Create runs, without running it.
+func (r *Runsc) CreateCommand(id, bundle string, opts *CreateOpts) ([]string, error) {
+	args, err := createArgs(id, bundle, opts)
 	if err != nil {
-		return err
-	}
-	if cio != nil {
-		if c, ok := cio.(runc.StartCloser); ok {
-			if err := c.CloseAfterStart(); err != nil {
-				return err
-			}
-		}
-	}
-	status, err := Monitor.Wait(cmd, ec)
-	if err == nil && status != 0 {
-		err = fmt.Errorf("%s did not terminate sucessfully", cmd.Args[0])
+		return nil, err
 	}
+	return r.command(context.Background(), args...).Args, nil
+}
 
-	return err
+// Start will start an already created container
+func (r *Runsc) Start(ctx context.Context, id string, cio runc.IO) error {
+	return r.run(ctx, "start", func(ctx context.Context) error {
+		return r.runWithIO(r.command(ctx, "start", id), "start", cio)
+	})
 }
 
 type waitResult struct {
@@ -184,10 +207,13 @@ type waitResult struct {
 
 // Wait will wait for a running container, and return its exit status.
 // TODO(random-liu): Add exec process support.
-func (r *Runsc) Wait(context context.Context, id string) (int, error) {
-	data, err := cmdOutput(r.command(context, "wait", id), true)
-	if err != nil {
-		return 0, fmt.Errorf("%s: %s", err, data)
+func (r *Runsc) Wait(ctx context.Context, id string) (int, error) {
+	var data []byte
+	if err := r.run(ctx, "wait", func(ctx context.Context) (err error) {
+		data, err = r.cmdOutput(r.command(ctx, "wait", id), true)
+		return err
+	}); err != nil {
+		return 0, err
 	}
 	var res waitResult
 	if err := json.Unmarshal(data, &res); err != nil {
@@ -230,7 +256,7 @@ func (o *ExecOpts) args() (out []string, err error) {
 
 // Exec executres and additional process inside the container based on a full
 // OCI Process specification
-func (r *Runsc) Exec(context context.Context, id string, spec specs.Process, opts *ExecOpts) error {
+func (r *Runsc) Exec(ctx context.Context, id string, spec specs.Process, opts *ExecOpts) error {
 	f, err := ioutil.TempFile(os.Getenv("XDG_RUNTIME_DIR"), "runsc-process")
 	if err != nil {
 		return err
@@ -249,38 +275,19 @@ func (r *Runsc) Exec(context context.Context, id string, spec specs.Process, opt
 		}
 		args = append(args, oargs...)
 	}
-	cmd := r.command(context, append(args, id)...)
-	if opts != nil && opts.IO != nil {
-		opts.Set(cmd)
-	}
-	if cmd.Stdout == nil && cmd.Stderr == nil {
-		data, err := cmdOutput(cmd, true)
-		if err != nil {
-			return fmt.Errorf("%s: %s", err, data)
-		}
-		return nil
-	}
-	ec, err := Monitor.Start(cmd)
-	if err != nil {
-		return err
-	}
-	if opts != nil && opts.IO != nil {
-		if c, ok := opts.IO.(runc.StartCloser); ok {
-			if err := c.CloseAfterStart(); err != nil {
-				return err
-			}
+	return r.run(ctx, "exec", func(ctx context.Context) error {
+		cmd := r.command(ctx, append(args, id)...)
+		var cio runc.IO
+		if opts != nil {
+			cio = opts.IO
 		}
-	}
-	status, err := Monitor.Wait(cmd, ec)
-	if err == nil && status != 0 {
-		err = fmt.Errorf("%s did not terminate sucessfully", cmd.Args[0])
-	}
-	return err
+		return r.runWithIO(cmd, "exec", cio)
+	})
 }
 
 // Run runs the create, start, delete lifecycle of the container
 // and returns its exit status after it has exited
-func (r *Runsc) Run(context context.Context, id, bundle string, opts *CreateOpts) (int, error) {
+func (r *Runsc) Run(ctx context.Context, id, bundle string, opts *CreateOpts) (int, error) {
 	args := []string{"run", "--bundle", bundle}
 	if opts != nil {
 		oargs, err := opts.args()
@@ -289,15 +296,15 @@ func (r *Runsc) Run(context context.Context, id, bundle string, opts *CreateOpts
 		}
 		args = append(args, oargs...)
 	}
-	cmd := r.command(context, append(args, id)...)
+	cmd := r.command(ctx, append(args, id)...)
 	if opts != nil && opts.IO != nil {
 		opts.Set(cmd)
 	}
-	ec, err := Monitor.Start(cmd)
+	ec, err := r.monitor().Start(cmd)
 	if err != nil {
 		return -1, err
 	}
-	return Monitor.Wait(cmd, ec)
+	return r.monitor().Wait(cmd, ec)
 }
 
 type DeleteOpts struct {
@@ -312,12 +319,61 @@ func (o *DeleteOpts) args() (out []string) {
 }
 
 // Delete deletes the container
-func (r *Runsc) Delete(context context.Context, id string, opts *DeleteOpts) error {
+func (r *Runsc) Delete(ctx context.Context, id string, opts *DeleteOpts) error {
 	args := []string{"delete"}
 	if opts != nil {
 		args = append(args, opts.args()...)
 	}
-	return r.runOrError(r.command(context, append(args, id)...))
+	return r.run(ctx, "delete", func(ctx context.Context) error {
+		return r.runOrError(r.command(ctx, append(args, id)...))
+	})
+}
+
+// CheckpointOpts specifies options for checkpointing a container
+type CheckpointOpts struct {
+	// ImagePath is the directory runsc writes the image files to.
+	ImagePath string
+	// LeaveRunning keeps the container running after the checkpoint.
+	LeaveRunning bool
+}
+
+func (o *CheckpointOpts) args() (out []string) {
+	out = append(out, "--image-path", o.ImagePath)
+	if o.LeaveRunning {
+		out = append(out, "--leave-running")
+	}
+	return out
+}
+
+// Checkpoint saves the state of the container to an image. Unless
+// LeaveRunning is set, the container stops once its state is saved.
+func (r *Runsc) Checkpoint(ctx context.Context, id string, opts *CheckpointOpts) error {
+	args := append([]string{"checkpoint"}, opts.args()...)
+	return r.run(ctx, "checkpoint", func(ctx context.Context) error {
+		return r.runOrError(r.command(ctx, append(args, id)...))
+	})
+}
+
+// RestoreOpts specifies options for restoring a container. The container
+// is created by Restore, with the options of CreateOpts.
+type RestoreOpts struct {
+	CreateOpts
+	// ImagePath is the directory of the image files written by Checkpoint.
+	ImagePath string
+}
+
+// Restore creates a container and starts it from the state saved in an
+// image, instead of running its entrypoint.
+func (r *Runsc) Restore(ctx context.Context, id, bundle string, opts *RestoreOpts) error {
+	oargs, err := opts.CreateOpts.args()
+	if err != nil {
+		return err
+	}
+	args := append([]string{"restore", "--detach", "--bundle", bundle, "--image-path", opts.ImagePath}, oargs...)
+	args = append(args, id)
+	return r.run(ctx, "restore", func(ctx context.Context) error {
+		return r.runWithIO(r.command(ctx, args...), "restore", opts.IO)
+	})
 }
 
 // KillOpts specifies options for killing a container and its processes
@@ -337,46 +393,77 @@ func (o *KillOpts) args() (out []string) {
 }
 
 // Kill sends the specified signal to the container
-func (r *Runsc) Kill(context context.Context, id string, sig int, opts *KillOpts) error {
+func (r *Runsc) Kill(ctx context.Context, id string, sig int, opts *KillOpts) error {
 	args := []string{
 		"kill",
 	}
 	if opts != nil {
 		args = append(args, opts.args()...)
 	}
-	return r.runOrError(r.command(context, append(args, id, strconv.Itoa(sig))...))
+	return r.run(ctx, "kill", func(ctx context.Context) error {
+		return r.runOrError(r.command(ctx, append(args, id, strconv.Itoa(sig))...))
+	})
 }
 
-// Stats return the stats for a container like cpu, memory, and io
-func (r *Runsc) Stats(context context.Context, id string) (*runc.Stats, error) {
-	cmd := r.command(context, "events", "--stats", id)
-	rd, err := cmd.StdoutPipe()
-	if err != nil {
-		return nil, err
-	}
-	ec, err := Monitor.Start(cmd)
-	if err != nil {
+// Pause freezes all the processes of a container.
+func (r *Runsc) Pause(ctx context.Context, id string) error {
+	return r.run(ctx, "pause", func(ctx context.Context) error {
+		return r.runOrError(r.command(ctx, "pause", id))
+	})
+}
+
+// Resume unfreezes the processes of a paused container.
+func (r *Runsc) Resume(ctx context.Context, id string) error {
+	return r.run(ctx, "resume", func(ctx context.Context) error {
+		return r.runOrError(r.command(ctx, "resume", id))
+	})
+}
+
+// ExportMetrics returns the metrics of the sentry of the sandbox of a
+// container, in the Prometheus text exposition format.
+func (r *Runsc) ExportMetrics(ctx context.Context, id string) ([]byte, error) {
+	var data []byte
+	if err := r.run(ctx, "export-metrics", func(ctx context.Context) (err error) {
+		data, err = r.cmdOutput(r.command(ctx, "export-metrics", id), false)
+		return err
+	}); err != nil {
 		return nil, err
 	}
-	defer func() {
-		rd.Close()
-		Monitor.Wait(cmd, ec)
-	}()
+	return data, nil
+}
+
+// Stats return the stats for a container like cpu, memory, and io
+func (r *Runsc) Stats(ctx context.Context, id string) (*runc.Stats, error) {
 	var e runc.Event
-	if err := json.NewDecoder(rd).Decode(&e); err != nil {
+	if err := r.run(ctx, "stats", func(ctx context.Context) error {
+		cmd := r.command(ctx, "events", "--stats", id)
+		rd, err := cmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		ec, err := r.monitor().Start(cmd)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			rd.Close()
+			r.monitor().Wait(cmd, ec)
+		}()
+		return json.NewDecoder(rd).Decode(&e)
+	}); err != nil {
 		return nil, err
 	}
 	return e.Stats, nil
 }
 
 // Events returns an event stream from runsc for a container with stats and OOM notifications
-func (r *Runsc) Events(context context.Context, id string, interval time.Duration) (chan *runc.Event, error) {
-	cmd := r.command(context, "events", fmt.Sprintf("--interval=%ds", int(interval.Seconds())), id)
+func (r *Runsc) Events(ctx context.Context, id string, interval time.Duration) (chan *runc.Event, error) {
+	cmd := r.command(ctx, "events", fmt.Sprintf("--interval=%ds", int(interval.Seconds())), id)
 	rd, err := cmd.StdoutPipe()
 	if err != nil {
 		return nil, err
 	}
-	ec, err := Monitor.Start(cmd)
+	ec, err := r.monitor().Start(cmd)
 	if err != nil {
 		rd.Close()
 		return nil, err
@@ -389,7 +476,7 @@ func (r *Runsc) Events(context context.Context, id string, interval time.Duratio
 		defer func() {
 			close(c)
 			rd.Close()
-			Monitor.Wait(cmd, ec)
+			r.monitor().Wait(cmd, ec)
 		}()
 		for {
 			var e runc.Event
@@ -397,10 +484,13 @@ func (r *Runsc) Events(context context.Context, id string, interval time.Duratio
 				if err == io.EOF {
 					return
 				}
-				e = runc.Event{
+				// The decoder can't resync after invalid output, so the
+				// stream ends with the error.
+				c <- &runc.Event{
 					Type: "error",
 					Err:  err,
 				}
+				return
 			}
 			c <- &e
 		}
@@ -409,10 +499,13 @@ func (r *Runsc) Events(context context.Context, id string, interval time.Duratio
 }
 
 // Ps lists all the processes inside the container returning their pids
-func (r *Runsc) Ps(context context.Context, id string) ([]int, error) {
-	data, err := cmdOutput(r.command(context, "ps", "--format", "json", id), true)
-	if err != nil {
-		return nil, fmt.Errorf("%s: %s", err, data)
+func (r *Runsc) Ps(ctx context.Context, id string) ([]int, error) {
+	var data []byte
+	if err := r.run(ctx, "ps", func(ctx context.Context) (err error) {
+		data, err = r.cmdOutput(r.command(ctx, "ps", "--format", "json", id), true)
+		return err
+	}); err != nil {
+		return nil, err
 	}
 	var pids []int
 	if err := json.Unmarshal(data, &pids); err != nil {
@@ -421,11 +514,167 @@ func (r *Runsc) Ps(context context.Context, id string) ([]int, error) {
 	return pids, nil
 }
 
-// Top lists all the processes inside the container returning the full ps data
-func (r *Runsc) Top(context context.Context, id string) (*runc.TopResults, error) {
-	data, err := cmdOutput(r.command(context, "ps", "--format", "table", id), true)
+// Version returns the version reported by the runsc binary, e.g.
+// "release-20190304.1". ErrNotRunsc is returned if the binary doesn't
+// identify itself as runsc.
+func (r *Runsc) Version(ctx context.Context) (string, error) {
+	command := r.Command
+	if command == "" {
+		command = DefaultCommand
+	}
+	var data []byte
+	if err := r.run(ctx, "version", func(ctx context.Context) (err error) {
+		data, err = r.cmdOutput(exec.CommandContext(ctx, command, "--version"), true)
+		return err
+	}); err != nil {
+		return "", err
+	}
+	line := strings.SplitN(strings.TrimSpace(string(data)), "\n", 2)[0]
+	if !strings.HasPrefix(line, "runsc version") {
+		return "", errors.Wrapf(ErrNotRunsc, "%s reported %q", command, line)
+	}
+	return strings.TrimSpace(strings.TrimPrefix(line, "runsc version")), nil
+}
+
+// Commands returns the subcommands listed by the help of the runsc binary,
+// so that optional features can be detected. The commands are shared
+// between callers and must not be modified.
+func (r *Runsc) Commands(ctx context.Context) (map[string]bool, error) {
+	return r.probe(ctx, "help", func(line string) string {
+		// Subcommands are listed indented under their group.
+		if !strings.HasPrefix(line, "\t") {
+			return ""
+		}
+		if fields := strings.Fields(line); len(fields) > 0 {
+			return fields[0]
+		}
+		return ""
+	})
+}
+
+// GlobalFlags returns the global flags listed by runsc flags, so that
+// optional flags can be detected. The flags are shared between callers and
+// must not be modified.
+func (r *Runsc) GlobalFlags(ctx context.Context) (map[string]bool, error) {
+	return r.probe(ctx, "flags", func(line string) string {
+		// Flags are listed as "  -name value", followed by their
+		// indented usage.
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "-") {
+			return ""
+		}
+		if fields := strings.Fields(line); len(fields) > 0 {
+			return strings.TrimLeft(fields[0], "-")
+		}
+		return ""
+	})
+}
+
+// probed caches the output of the probes of the runsc binaries by
+// subcommand, binary path and modification time, so that a binary is only
+// probed once until it is upgraded.
+var probed = struct {
+	sync.Mutex
+	names map[string]map[string]bool
+}{names: make(map[string]map[string]bool)}
+
+// probe returns the names parse finds in the lines of the output of the
+// runsc subcommand, cached in probed.
+func (r *Runsc) probe(ctx context.Context, subcommand string, parse func(line string) string) (map[string]bool, error) {
+	command := r.Command
+	if command == "" {
+		command = DefaultCommand
+	}
+	var key string
+	if path, err := exec.LookPath(command); err == nil {
+		if st, err := os.Stat(path); err == nil {
+			key = fmt.Sprintf("%s %s@%d", subcommand, path, st.ModTime().UnixNano())
+		}
+	}
+	probed.Lock()
+	names, ok := probed.names[key]
+	probed.Unlock()
+	if ok && key != "" {
+		return names, nil
+	}
+	var data []byte
+	if err := r.run(ctx, subcommand, func(ctx context.Context) (err error) {
+		data, err = r.cmdOutput(exec.CommandContext(ctx, command, subcommand), true)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	names = make(map[string]bool)
+	for _, line := range strings.Split(string(data), "\n") {
+		if name := parse(line); name != "" {
+			names[name] = true
+		}
+	}
+	if key != "" {
+		probed.Lock()
+		probed.names[key] = names
+		probed.Unlock()
+	}
+	return names, nil
+}
+
+// PortForward connects to port inside the network stack of the sandbox of
+// the container with runsc port-forward -stream: runsc connects to a unix
+// socket of the caller and hands the connection to the sandbox, which
+// forwards it to the port. The returned connection is the accepted end.
+func (r *Runsc) PortForward(ctx context.Context, id string, port int) (net.Conn, error) {
+	dir, err := ioutil.TempDir("", "runsc-port-forward-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: filepath.Join(dir, "stream"), Net: "unix"})
 	if err != nil {
-		return nil, fmt.Errorf("%s: %s", err, data)
+		return nil, err
+	}
+	defer l.Close()
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	accepted := make(chan result, 1)
+	go func() {
+		conn, err := l.Accept()
+		accepted <- result{conn, err}
+	}()
+	exited := make(chan error, 1)
+	go func() {
+		exited <- r.run(ctx, "port-forward", func(ctx context.Context) error {
+			return r.runOrError(r.command(ctx, "port-forward", "--stream", l.Addr().String(), id, strconv.Itoa(port)))
+		})
+	}()
+	select {
+	case res := <-accepted:
+		return res.conn, res.err
+	case err := <-exited:
+		if err == nil {
+			// runsc connected before exiting.
+			res := <-accepted
+			return res.conn, res.err
+		}
+		l.Close()
+		if res := <-accepted; res.conn != nil {
+			res.conn.Close()
+		}
+		return nil, err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Top lists all the processes inside the container returning the full ps data
+func (r *Runsc) Top(ctx context.Context, id string) (*runc.TopResults, error) {
+	var data []byte
+	if err := r.run(ctx, "ps", func(ctx context.Context) (err error) {
+		data, err = r.cmdOutput(r.command(ctx, "ps", "--format", "table", id), true)
+		return err
+	}); err != nil {
+		return nil, err
 	}
 
 	topResults, err := runc.ParsePSOutput(data)
@@ -435,7 +684,7 @@ func (r *Runsc) Top(context context.Context, id string) (*runc.TopResults, error
 	return topResults, nil
 }
 
-func (r *Runsc) args() []string {
+func (r *Runsc) args(command string) []string {
 	var args []string
 	if r.Root != "" {
 		args = append(args, fmt.Sprintf("--root=%s", r.Root))
@@ -446,7 +695,18 @@ func (r *Runsc) args() []string {
 	if r.LogFormat != "" {
 		args = append(args, fmt.Sprintf("--log-format=%s", r.LogFormat))
 	}
-	for k, v := range r.Config {
+	config := r.Flags()
+	keys := make([]string, 0, len(config))
+	for k := range config {
+		keys = append(keys, k)
+	}
+	// Sort the flags so that command lines are reproducible.
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := config[k]
+		if k == "debug-log" {
+			v = strings.Replace(v, "%COMMAND%", command, -1)
+		}
 		args = append(args, fmt.Sprintf("--%s=%s", k, v))
 	}
 	return args
@@ -458,29 +718,55 @@ func (r *Runsc) args() []string {
 // <stderr>
 func (r *Runsc) runOrError(cmd *exec.Cmd) error {
 	if cmd.Stdout != nil || cmd.Stderr != nil {
-		ec, err := Monitor.Start(cmd)
+		ec, err := r.monitor().Start(cmd)
 		if err != nil {
 			return err
 		}
-		status, err := Monitor.Wait(cmd, ec)
+		status, err := r.monitor().Wait(cmd, ec)
 		if err == nil && status != 0 {
-			err = fmt.Errorf("%s did not terminate sucessfully", cmd.Args[0])
+			err = newError(commandName(cmd), status, nil, fmt.Errorf("%s did not terminate sucessfully", cmd.Args[0]))
 		}
 		return err
 	}
-	data, err := cmdOutput(cmd, true)
+	_, err := r.cmdOutput(cmd, true)
+	return err
+}
+
+// runWithIO runs a command that may have its stdio wired to the container
+// IO. If no IO is provided the output of the command is captured into the
+// returned error.
+func (r *Runsc) runWithIO(cmd *exec.Cmd, name string, cio runc.IO) error {
+	if cio != nil {
+		cio.Set(cmd)
+	}
+	if cmd.Stdout == nil && cmd.Stderr == nil {
+		_, err := r.cmdOutput(cmd, true)
+		return err
+	}
+	ec, err := r.monitor().Start(cmd)
 	if err != nil {
-		return fmt.Errorf("%s: %s", err, data)
+		return err
 	}
-	return nil
+	if cio != nil {
+		if c, ok := cio.(runc.StartCloser); ok {
+			if err := c.CloseAfterStart(); err != nil {
+				return err
+			}
+		}
+	}
+	status, err := r.monitor().Wait(cmd, ec)
+	if err == nil && status != 0 {
+		err = newError(name, status, nil, fmt.Errorf("%s did not terminate sucessfully", cmd.Args[0]))
+	}
+	return err
 }
 
-func (r *Runsc) command(context context.Context, args ...string) *exec.Cmd {
+func (r *Runsc) command(ctx context.Context, args ...string) *exec.Cmd {
 	command := r.Command
 	if command == "" {
 		command = DefaultCommand
 	}
-	cmd := exec.CommandContext(context, command, append(r.args(), args...)...)
+	cmd := exec.CommandContext(ctx, command, append(r.args(args[0]), args...)...)
 	cmd.SysProcAttr = &syscall.SysProcAttr{
 		Setpgid: r.Setpgid,
 	}
@@ -491,23 +777,58 @@ func (r *Runsc) command(context context.Context, args ...string) *exec.Cmd {
 	return cmd
 }
 
-func cmdOutput(cmd *exec.Cmd, combined bool) ([]byte, error) {
+func (r *Runsc) cmdOutput(cmd *exec.Cmd, combined bool) ([]byte, error) {
 	b := getBuf()
 	defer putBuf(b)
 
-	cmd.Stdout = b
+	// The output is read from a pipe of our own rather than by os/exec, so
+	// that reading it can be given up once the command exited: processes
+	// runsc left behind, e.g. when it was killed on a timeout, may hold the
+	// pipe open forever.
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer pr.Close()
+	copied := make(chan struct{})
+	go func() {
+		io.Copy(b, pr)
+		close(copied)
+	}()
+	cmd.Stdout = pw
 	if combined {
-		cmd.Stderr = b
+		cmd.Stderr = pw
 	}
-	ec, err := Monitor.Start(cmd)
+	ec, err := r.monitor().Start(cmd)
+	pw.Close()
 	if err != nil {
+		pr.Close()
+		<-copied
 		return nil, err
 	}
 
-	status, err := Monitor.Wait(cmd, ec)
+	status, err := r.monitor().Wait(cmd, ec)
+	select {
+	case <-copied:
+	case <-time.After(outputWaitDelay):
+		pr.Close()
+		<-copied
+	}
+	// Copy the output, the buffer is returned to the pool.
+	data := append([]byte(nil), b.Bytes()...)
 	if err == nil && status != 0 {
-		err = fmt.Errorf("%s did not terminate sucessfully", cmd.Args[0])
+		err = newError(commandName(cmd), status, data, fmt.Errorf("%s did not terminate sucessfully", cmd.Args[0]))
 	}
 
-	return b.Bytes(), err
+	return data, err
+}
+
+// commandName returns the runsc subcommand of cmd, skipping global flags.
+func commandName(cmd *exec.Cmd) string {
+	for _, arg := range cmd.Args[1:] {
+		if !strings.HasPrefix(arg, "-") {
+			return arg
+		}
+	}
+	return ""
 }
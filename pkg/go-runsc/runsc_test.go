@@ -0,0 +1,59 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runsc
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+
+	runc "github.com/containerd/go-runc"
+)
+
+// countingMonitor counts the commands it starts.
+type countingMonitor struct {
+	started int
+}
+
+func (m *countingMonitor) Start(cmd *exec.Cmd) (chan runc.Exit, error) {
+	m.started++
+	return runc.Monitor.Start(cmd)
+}
+
+func (m *countingMonitor) Wait(cmd *exec.Cmd, ec chan runc.Exit) (int, error) {
+	return runc.Monitor.Wait(cmd, ec)
+}
+
+func TestClientMonitor(t *testing.T) {
+	global := &countingMonitor{}
+	defer func(m runc.ProcessMonitor) { Monitor = m }(Monitor)
+	Monitor = global
+
+	own := &countingMonitor{}
+	r := &Runsc{Command: "echo", Monitor: own}
+	// echo isn't runsc, only the command run matters.
+	r.Version(context.Background())
+	if own.started != 1 || global.started != 0 {
+		t.Errorf("client with a monitor: started %d with its monitor and %d with the package one, want 1 and 0", own.started, global.started)
+	}
+
+	r = &Runsc{Command: "echo"}
+	r.Version(context.Background())
+	if global.started != 1 {
+		t.Errorf("client without a monitor: started %d with the package monitor, want 1", global.started)
+	}
+}
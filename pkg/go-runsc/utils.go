@@ -19,8 +19,11 @@ package runsc
 
 import (
 	"bytes"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
+	"time"
 )
 
 var bytesBufferPool = sync.Pool{
@@ -38,19 +41,98 @@ func putBuf(b *bytes.Buffer) {
 	bytesBufferPool.Put(b)
 }
 
-// FormatLogPath parses runsc config, and fill in %ID% in the log path.
-// * For debug-log, it fills in the id in place;
-// * For user-log, it fills in the id, returns the user log path, and deletes
-// the `user-log` entry from the config, because it will only be added to runsc
-// calls that create a new sandbox.
-func FormatLogPath(id string, config map[string]string) string {
+// FormatLogPath parses runsc config, and fills in the variables of the log
+// paths: %ID% with the container id, %NAMESPACE% with the containerd
+// namespace and %DATE% with the current date, as YYYYMMDD in UTC.
+// * For debug-log, it fills in the variables in place. If the path is a
+// directory (ends with "/"), each runsc command of the container logs to its
+// own file under <dir>/<id>/. %COMMAND% is filled in when the command is run;
+// * For user-log, it fills in the variables, returns the user log path, and
+// deletes the `user-log` entry from the config, because it will only be added
+// to runsc calls that create a new sandbox.
+func FormatLogPath(namespace, id string, config map[string]string) string {
+	return formatLogPath(namespace, id, time.Now().UTC().Format("20060102"), config)
+}
+
+// formatLogPath is FormatLogPath filling in %DATE% with date.
+func formatLogPath(namespace, id, date string, config map[string]string) string {
+	vars := strings.NewReplacer(
+		"%ID%", id,
+		"%NAMESPACE%", namespace,
+		"%DATE%", date,
+	)
 	if path, ok := config["debug-log"]; ok {
-		config["debug-log"] = strings.Replace(path, "%ID%", id, -1)
+		if strings.HasSuffix(path, "/") {
+			path = filepath.Join(path, "%ID%", "runsc.%COMMAND%.log")
+		}
+		config["debug-log"] = vars.Replace(path)
 	}
 	var userLog string
 	if path, ok := config["user-log"]; ok {
-		userLog = strings.Replace(path, "%ID%", id, -1)
+		userLog = vars.Replace(path)
 		delete(config, "user-log")
 	}
 	return userLog
 }
+
+// logVar matches the variables runsc fills in the debug log path, such as
+// %COMMAND% and %TIMESTAMP%.
+var logVar = regexp.MustCompile(`%[A-Z]+%`)
+
+// globEscaper escapes the glob metacharacters of a path.
+var globEscaper = strings.NewReplacer(`*`, `\*`, `?`, `\?`, `[`, `\[`, `\`, `\\`)
+
+// DebugLogFiles returns the files matching the debug log path of config,
+// with the variables runsc fills in matching anything. config must have been
+// formatted by FormatLogPath. Other files of the debug log directory are
+// left out.
+func DebugLogFiles(config map[string]string) []string {
+	path, ok := config["debug-log"]
+	if !ok || path == "" {
+		return nil
+	}
+	var pattern strings.Builder
+	i := 0
+	for _, m := range logVar.FindAllStringIndex(path, -1) {
+		pattern.WriteString(globEscaper.Replace(path[i:m[0]]))
+		pattern.WriteString("*")
+		i = m[1]
+	}
+	pattern.WriteString(globEscaper.Replace(path[i:]))
+	files, err := filepath.Glob(pattern.String())
+	if err != nil {
+		return nil
+	}
+	return files
+}
+
+// ContainerDebugLogs returns the debug logs of the container id of
+// namespace, given the runsc config it was created with before
+// FormatLogPath, and the directory they are under. %DATE% matches any date,
+// as the container may have been created on another day than the logs are
+// collected on. dir is empty if debug logging is not configured.
+func ContainerDebugLogs(namespace, id string, config map[string]string) (dir string, files []string) {
+	c := map[string]string{}
+	if path, ok := config["debug-log"]; ok {
+		c["debug-log"] = path
+	}
+	formatLogPath(namespace, id, "%DATE%", c)
+	dir = DebugLogDir(c)
+	for logVar.MatchString(dir) {
+		dir = filepath.Dir(dir)
+	}
+	return dir, DebugLogFiles(c)
+}
+
+// DebugLogDir returns the directory runsc debug logs are written to, or an
+// empty string if debug logging is not configured.
+func DebugLogDir(config map[string]string) string {
+	path, ok := config["debug-log"]
+	if !ok || path == "" {
+		return ""
+	}
+	if strings.HasSuffix(path, "/") {
+		return filepath.Clean(path)
+	}
+	return filepath.Dir(path)
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package test
+
+import (
+	"fmt"
+	"testing"
+
+	shimapi "github.com/containerd/containerd/runtime/v1/shim/v1"
+	"golang.org/x/sys/unix"
+)
+
+// BenchmarkExecChurn runs exec processes that exit right away, one at a
+// time, from Exec to DeleteProcess, next to idle exec processes that were
+// added but not started, such as those of a stuck client. The results of
+// the last run are kept in testdata/bench.txt, refresh them with make bench
+// and compare runs with benchstat.
+func BenchmarkExecChurn(b *testing.B) {
+	for _, idle := range []int{0, 100, 1000} {
+		name := fmt.Sprintf("idle=%d", idle)
+		b.Run(name, func(b *testing.B) {
+			benchExecChurn(b, idle)
+		})
+	}
+}
+
+func benchExecChurn(b *testing.B, idle int) {
+	h, cleanup := newHarness(b)
+	defer cleanup()
+	startContainer(b, h, "bench")
+	defer h.Delete()
+	defer h.Kill(uint32(unix.SIGKILL), true)
+
+	for i := 0; i < idle; i++ {
+		if err := h.Exec(fmt.Sprintf("idle-%d", i), "sleep"); err != nil {
+			b.Fatal(err)
+		}
+	}
+	ctx := h.Context()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		id := fmt.Sprintf("exec-%d", i)
+		if err := h.Exec(id, "exit", "0"); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := h.Start(id); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := h.Service.Wait(ctx, &shimapi.WaitRequest{ID: id}); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := h.Service.DeleteProcess(ctx, &shimapi.DeleteProcessRequest{ID: id}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,714 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command fakerunsc is a stand-in for runsc used by the test harness. It
+// implements the subset of the runsc command line used by the shim, backing
+// each container with a host process that runs until it is signaled.
+//
+// Container state is kept in <root>/<id>/state.json. Exec processes whose
+// first argument is "exit" exit immediately with the status given as second
+// argument, and sync exits with 0; all other processes run until they are
+// killed. Creating <root>/<id>/wedged simulates a wedged sentry: kill and
+// delete hang unless forced. port-forward echoes the forwarded connection.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// state mirrors the JSON written by runsc state.
+type state struct {
+	ID          string            `json:"id"`
+	Pid         int               `json:"pid"`
+	Status      string            `json:"status"`
+	Bundle      string            `json:"bundle"`
+	Rootfs      string            `json:"rootfs"`
+	Created     time.Time         `json:"created"`
+	Annotations map[string]string `json:"annotations"`
+	// Execs are the pids of the exec processes of the container.
+	Execs []int `json:"execs,omitempty"`
+}
+
+type runtime struct {
+	root string
+}
+
+func main() {
+	args := os.Args[1:]
+	r := &runtime{root: "/run/containerd/runsc"}
+	// Global flags precede the command, e.g. --root=/run/runsc create.
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		if args[0] == "--version" || args[0] == "-version" {
+			fmt.Println("runsc version fake")
+			fmt.Println("spec: 1.0.1")
+			return
+		}
+		if v := strings.TrimPrefix(args[0], "--root="); v != args[0] {
+			r.root = v
+		}
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		fatalf("no command given")
+	}
+	command, args := args[0], args[1:]
+	if command == "process" {
+		if err := process(args); err != nil {
+			fatalf("%v", err)
+		}
+		return
+	}
+	if command == "echo" {
+		if err := echo(); err != nil {
+			fatalf("%v", err)
+		}
+		return
+	}
+	flags, args := parseFlags(args)
+	var err error
+	switch command {
+	case "create":
+		err = r.create(flags, args)
+	case "start":
+		err = r.start(args)
+	case "state":
+		err = r.state(args)
+	case "list":
+		err = r.list()
+	case "kill":
+		err = r.kill(flags, args)
+	case "delete":
+		err = r.delete(flags, args)
+	case "wait":
+		err = r.wait(args)
+	case "exec":
+		err = r.exec(flags, args)
+	case "ps":
+		err = r.ps(flags, args)
+	case "events":
+		err = r.events(flags, args)
+	case "checkpoint":
+		err = r.checkpoint(flags, args)
+	case "restore":
+		err = r.restore(flags, args)
+	case "help":
+		help()
+	case "flags":
+		globalFlags()
+	case "export-metrics":
+		err = r.exportMetrics(args)
+	case "pause":
+		err = r.pause("pause", args, "running", "paused", syscall.SIGSTOP)
+	case "resume":
+		err = r.pause("resume", args, "paused", "running", syscall.SIGCONT)
+	case "port-forward":
+		err = r.portForward(flags, args)
+	default:
+		err = fmt.Errorf("unknown command %q", command)
+	}
+	if err != nil {
+		fatalf("%v", err)
+	}
+}
+
+// help lists the supported commands the way runsc does.
+func help() {
+	fmt.Println("Usage: runsc <flags> <subcommand> <subcommand args>")
+	fmt.Println()
+	fmt.Println("Subcommands:")
+	for _, c := range []string{"checkpoint", "create", "delete", "events", "exec", "export-metrics", "flags", "kill", "list", "pause", "port-forward", "ps", "restore", "resume", "start", "state", "wait"} {
+		fmt.Printf("\t%s\n", c)
+	}
+}
+
+// globalFlags lists the global flags the way runsc flags does. Any flag is
+// accepted, only the ones of old releases are listed.
+func globalFlags() {
+	for _, f := range []string{"debug", "debug-log", "network", "platform", "root"} {
+		fmt.Printf("  -%s\n    \t%s flag\n", f, f)
+	}
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
+
+// parseFlags splits the command flags from the positional arguments.
+func parseFlags(args []string) (map[string]string, []string) {
+	flags := make(map[string]string)
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if !strings.HasPrefix(a, "-") {
+			rest = append(rest, a)
+			continue
+		}
+		name := strings.TrimLeft(a, "-")
+		if kv := strings.SplitN(name, "=", 2); len(kv) == 2 {
+			flags[kv[0]] = kv[1]
+			continue
+		}
+		switch name {
+		case "all", "force", "detach", "stats", "leave-running":
+			flags[name] = "true"
+		default:
+			if i+1 < len(args) {
+				flags[name] = args[i+1]
+				i++
+			}
+		}
+	}
+	return flags, rest
+}
+
+func (r *runtime) dir(id string) string {
+	return filepath.Join(r.root, id)
+}
+
+// hangIfWedged blocks forever if the container was marked wedged.
+func (r *runtime) hangIfWedged(id string) {
+	if _, err := os.Stat(filepath.Join(r.dir(id), "wedged")); err == nil {
+		for {
+			time.Sleep(time.Hour)
+		}
+	}
+}
+
+func (r *runtime) load(id string) (*state, error) {
+	data, err := ioutil.ReadFile(filepath.Join(r.dir(id), "state.json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("container %q does not exist", id)
+		}
+		return nil, err
+	}
+	var s state
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	if s.Status != "created" && s.Status != "running" && s.Status != "paused" {
+		return &s, nil
+	}
+	if !alive(s.Pid) {
+		s.Status = "stopped"
+	}
+	return &s, nil
+}
+
+func (r *runtime) save(s *state) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	// Replace the state atomically, as it is read by concurrent commands
+	// such as wait.
+	path := filepath.Join(r.dir(s.ID), "state.json")
+	tmp := fmt.Sprintf("%s.%d", path, os.Getpid())
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func alive(pid int) bool {
+	if pid <= 0 || syscall.Kill(pid, 0) != nil {
+		return false
+	}
+	// Zombies are reported as dead.
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(data))
+	return len(fields) < 3 || fields[2] != "Z"
+}
+
+func id(args []string) (string, error) {
+	if len(args) == 0 {
+		return "", fmt.Errorf("container id is required")
+	}
+	return args[0], nil
+}
+
+// spawn starts a detached process that runs until signaled, or exits with
+// the given status when args is ["exit", status]. The exit status of init
+// processes is recorded for wait.
+func (r *runtime) spawn(id string, init bool, args []string) (int, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	exitFile := "-"
+	if init {
+		exitFile = filepath.Join(r.dir(id), "exit")
+	}
+	cmd := exec.Command(self, append([]string{"process", exitFile}, args...)...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	pid := cmd.Process.Pid
+	cmd.Process.Release()
+	return pid, nil
+}
+
+func writePid(path string, pid int) error {
+	if path == "" {
+		return nil
+	}
+	return ioutil.WriteFile(path, []byte(strconv.Itoa(pid)), 0644)
+}
+
+func (r *runtime) create(flags map[string]string, args []string) error {
+	id, err := id(args)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(r.dir(id)); err == nil {
+		return fmt.Errorf("container %q already exists", id)
+	}
+	if flags["console-socket"] != "" {
+		return fmt.Errorf("terminals are not supported")
+	}
+	if err := os.MkdirAll(r.dir(id), 0700); err != nil {
+		return err
+	}
+	pid, err := r.spawn(id, true, nil)
+	if err != nil {
+		return err
+	}
+	s := &state{
+		ID:      id,
+		Pid:     pid,
+		Status:  "created",
+		Bundle:  flags["bundle"],
+		Rootfs:  filepath.Join(flags["bundle"], "rootfs"),
+		Created: time.Now(),
+	}
+	if err := r.save(s); err != nil {
+		return err
+	}
+	return writePid(flags["pid-file"], pid)
+}
+
+func (r *runtime) start(args []string) error {
+	id, err := id(args)
+	if err != nil {
+		return err
+	}
+	s, err := r.load(id)
+	if err != nil {
+		return err
+	}
+	if s.Status != "created" {
+		return fmt.Errorf("cannot start container in %s state", s.Status)
+	}
+	s.Status = "running"
+	return r.save(s)
+}
+
+// exportMetrics prints sentry metrics of the sandbox the way runsc
+// export-metrics does.
+func (r *runtime) exportMetrics(args []string) error {
+	id, err := id(args)
+	if err != nil {
+		return err
+	}
+	s, err := r.load(id)
+	if err != nil {
+		return err
+	}
+	fmt.Println("# HELP runsc_fs_opens Number of file opens.")
+	fmt.Println("# TYPE runsc_fs_opens counter")
+	fmt.Printf("runsc_fs_opens{sandbox=%q} %d\n", s.ID, 42)
+	fmt.Println("# HELP runsc_meta_up Whether the sandbox is up.")
+	fmt.Println("# TYPE runsc_meta_up gauge")
+	fmt.Println("runsc_meta_up 1")
+	return nil
+}
+
+// pause runs the pause and resume commands: it moves the container from the
+// from to the to state, stopping or continuing its processes with sig.
+func (r *runtime) pause(command string, args []string, from, to string, sig syscall.Signal) error {
+	id, err := id(args)
+	if err != nil {
+		return err
+	}
+	s, err := r.load(id)
+	if err != nil {
+		return err
+	}
+	if s.Status != from {
+		return fmt.Errorf("cannot %s container in %s state", command, s.Status)
+	}
+	for _, pid := range append([]int{s.Pid}, s.Execs...) {
+		if alive(pid) {
+			syscall.Kill(pid, sig)
+		}
+	}
+	s.Status = to
+	return r.save(s)
+}
+
+// portForward connects to the unix socket given with --stream, and hands
+// the connection to a process echoing it, as if a server listening on the
+// port inside the sandbox did.
+func (r *runtime) portForward(flags map[string]string, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("container id and port are required")
+	}
+	if flags["stream"] == "" {
+		return fmt.Errorf("only stream mode is supported")
+	}
+	s, err := r.load(args[0])
+	if err != nil {
+		return err
+	}
+	if s.Status != "running" {
+		return fmt.Errorf("cannot forward port of container in %s state", s.Status)
+	}
+	conn, err := net.Dial("unix", flags["stream"])
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	f, err := conn.(*net.UnixConn).File()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(self, "echo")
+	cmd.ExtraFiles = []*os.File{f}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// echo copies the connection on fd 3 back to it.
+func echo() error {
+	f := os.NewFile(3, "conn")
+	defer f.Close()
+	_, err := io.Copy(f, f)
+	return err
+}
+
+// checkpointImage is the image file written by checkpoint, holding the
+// state of the container.
+const checkpointImage = "checkpoint.img"
+
+// checkpoint writes the state of the container to the image path and, unless
+// --leave-running is given, kills the container like runsc stops it.
+func (r *runtime) checkpoint(flags map[string]string, args []string) error {
+	id, err := id(args)
+	if err != nil {
+		return err
+	}
+	s, err := r.load(id)
+	if err != nil {
+		return err
+	}
+	if s.Status != "running" {
+		return fmt.Errorf("cannot checkpoint container in %s state", s.Status)
+	}
+	dir := flags["image-path"]
+	if dir == "" {
+		return fmt.Errorf("--image-path is required")
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, checkpointImage), data, 0600); err != nil {
+		return err
+	}
+	if flags["leave-running"] != "true" {
+		syscall.Kill(s.Pid, syscall.SIGKILL)
+	}
+	return nil
+}
+
+// restore creates and starts a container, like create and start, once it
+// finds the image written by checkpoint.
+func (r *runtime) restore(flags map[string]string, args []string) error {
+	if _, err := os.Stat(filepath.Join(flags["image-path"], checkpointImage)); err != nil {
+		return fmt.Errorf("invalid image path: %v", err)
+	}
+	if err := r.create(flags, args); err != nil {
+		return err
+	}
+	return r.start(args)
+}
+
+func (r *runtime) state(args []string) error {
+	id, err := id(args)
+	if err != nil {
+		return err
+	}
+	s, err := r.load(id)
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(os.Stdout).Encode(s)
+}
+
+func (r *runtime) list() error {
+	entries, err := ioutil.ReadDir(r.root)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	out := []*state{}
+	for _, e := range entries {
+		if s, err := r.load(e.Name()); err == nil {
+			out = append(out, s)
+		}
+	}
+	return json.NewEncoder(os.Stdout).Encode(out)
+}
+
+func (r *runtime) kill(flags map[string]string, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("container id and signal are required")
+	}
+	s, err := r.load(args[0])
+	if err != nil {
+		return err
+	}
+	r.hangIfWedged(args[0])
+	sig, err := strconv.Atoi(args[1])
+	if err != nil {
+		return err
+	}
+	var pids []int
+	switch {
+	case flags["pid"] != "":
+		pid, err := strconv.Atoi(flags["pid"])
+		if err != nil {
+			return err
+		}
+		pids = []int{pid}
+	case flags["all"] == "true":
+		pids = append([]int{s.Pid}, s.Execs...)
+	default:
+		if s.Status != "running" && s.Status != "paused" {
+			return fmt.Errorf("cannot signal container in %s state", s.Status)
+		}
+		pids = []int{s.Pid}
+	}
+	for _, pid := range pids {
+		if !alive(pid) {
+			continue
+		}
+		if sig == int(syscall.SIGKILL) && pid == s.Pid {
+			// SIGKILL can't be observed by the process, record it here.
+			ioutil.WriteFile(filepath.Join(r.dir(s.ID), "exit"), []byte(strconv.Itoa(128+sig)), 0644)
+		}
+		if err := syscall.Kill(pid, syscall.Signal(sig)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *runtime) delete(flags map[string]string, args []string) error {
+	id, err := id(args)
+	if err != nil {
+		return err
+	}
+	s, err := r.load(id)
+	if err != nil {
+		return err
+	}
+	if flags["force"] != "true" {
+		r.hangIfWedged(id)
+	}
+	if (s.Status == "running" || s.Status == "paused") && flags["force"] != "true" {
+		return fmt.Errorf("cannot delete container in %s state", s.Status)
+	}
+	for _, pid := range append([]int{s.Pid}, s.Execs...) {
+		if alive(pid) {
+			syscall.Kill(pid, syscall.SIGKILL)
+		}
+	}
+	return os.RemoveAll(r.dir(id))
+}
+
+func (r *runtime) wait(args []string) error {
+	id, err := id(args)
+	if err != nil {
+		return err
+	}
+	s, err := r.load(id)
+	if err != nil {
+		return err
+	}
+	for alive(s.Pid) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	status := 0
+	if data, err := ioutil.ReadFile(filepath.Join(r.dir(id), "exit")); err == nil {
+		status, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+	}
+	return json.NewEncoder(os.Stdout).Encode(map[string]interface{}{
+		"id":         id,
+		"exitStatus": status,
+	})
+}
+
+func (r *runtime) exec(flags map[string]string, args []string) error {
+	id, err := id(args)
+	if err != nil {
+		return err
+	}
+	s, err := r.load(id)
+	if err != nil {
+		return err
+	}
+	// Like runsc, processes can be executed in created containers.
+	if s.Status != "running" && s.Status != "created" {
+		return fmt.Errorf("cannot exec in container in %s state", s.Status)
+	}
+	if flags["console-socket"] != "" {
+		return fmt.Errorf("terminals are not supported")
+	}
+	var spec struct {
+		Args []string `json:"args"`
+	}
+	data, err := ioutil.ReadFile(flags["process"])
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return err
+	}
+	pid, err := r.spawn(id, false, spec.Args)
+	if err != nil {
+		return err
+	}
+	s.Execs = append(s.Execs, pid)
+	if err := r.save(s); err != nil {
+		return err
+	}
+	if err := writePid(flags["pid-file"], pid); err != nil {
+		return err
+	}
+	return writePid(flags["internal-pid-file"], pid)
+}
+
+func (r *runtime) ps(flags map[string]string, args []string) error {
+	id, err := id(args)
+	if err != nil {
+		return err
+	}
+	s, err := r.load(id)
+	if err != nil {
+		return err
+	}
+	var pids []int
+	for _, pid := range append([]int{s.Pid}, s.Execs...) {
+		if alive(pid) {
+			pids = append(pids, pid)
+		}
+	}
+	if flags["format"] == "json" {
+		if pids == nil {
+			pids = []int{}
+		}
+		return json.NewEncoder(os.Stdout).Encode(pids)
+	}
+	fmt.Println("UID       PID       PPID      C         STIME     TTY       TIME      CMD")
+	for _, pid := range pids {
+		fmt.Printf("0         %-9d 0         0         00:00     ?         00:00:00  fake\n", pid)
+	}
+	return nil
+}
+
+// events reports the stats of the container once, or with --interval every
+// interval until the container is deleted.
+func (r *runtime) events(flags map[string]string, args []string) error {
+	id, err := id(args)
+	if err != nil {
+		return err
+	}
+	if _, err := r.load(id); err != nil {
+		return err
+	}
+	var interval time.Duration
+	if v, ok := flags["interval"]; ok {
+		if interval, err = time.ParseDuration(v); err != nil {
+			return err
+		}
+	}
+	enc := json.NewEncoder(os.Stdout)
+	for {
+		if err := enc.Encode(map[string]interface{}{
+			"type": "stats",
+			"id":   id,
+			"data": map[string]interface{}{},
+		}); err != nil || interval == 0 {
+			return err
+		}
+		time.Sleep(interval)
+		if _, err := r.load(id); err != nil {
+			return nil
+		}
+	}
+}
+
+// process is the body of container and exec processes. args[0] is the file
+// recording the exit status, or "-" for exec processes.
+func process(args []string) error {
+	if len(args) >= 3 && args[1] == "exit" {
+		status, err := strconv.Atoi(args[2])
+		if err != nil {
+			return err
+		}
+		os.Exit(status)
+	}
+	if len(args) >= 2 && args[1] == "sync" {
+		return nil
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals)
+	for s := range signals {
+		sig, ok := s.(syscall.Signal)
+		if !ok || sig == syscall.SIGCHLD || sig == syscall.SIGWINCH || sig == syscall.SIGURG || sig == syscall.SIGCONT {
+			continue
+		}
+		if args[0] != "-" {
+			ioutil.WriteFile(args[0], []byte(strconv.Itoa(128+int(sig))), 0644)
+		}
+		os.Exit(128 + int(sig))
+	}
+	return nil
+}
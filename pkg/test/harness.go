@@ -0,0 +1,270 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package test provides a harness running the v1 shim service against a
+// fake runsc binary, so that Create, Exec, Kill and Delete flows can be
+// exercised end to end without gVisor.
+//
+// The fake runsc is built from the fakerunsc package with the go tool, so
+// the harness requires a Go toolchain at runtime.
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/events"
+	"github.com/containerd/containerd/namespaces"
+	shimapi "github.com/containerd/containerd/runtime/v1/shim/v1"
+	ptypes "github.com/gogo/protobuf/types"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/proc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/shim"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/stats"
+)
+
+// Namespace is the containerd namespace used by the harness.
+const Namespace = "test"
+
+// fakeRunscPackage is the import path of the fake runsc command.
+const fakeRunscPackage = "github.com/google/gvisor-containerd-shim/pkg/test/fakerunsc"
+
+// processTypeURL is the type url of OCI process specs used by containerd.
+const processTypeURL = "types.containerd.io/opencontainers/runtime-spec/1/Process"
+
+// reaper is started once per process, as the shim reaper is global.
+var reaper sync.Once
+
+// BuildFakeRunsc builds the fake runsc binary into dir and returns its path.
+func BuildFakeRunsc(dir string) (string, error) {
+	path := filepath.Join(dir, "runsc")
+	out, err := exec.Command("go", "build", "-o", path, fakeRunscPackage).CombinedOutput()
+	if err != nil {
+		return "", errors.Wrapf(err, "build fake runsc: %s", out)
+	}
+	return path, nil
+}
+
+// Event is an event published by the service.
+type Event struct {
+	Topic string
+	Event events.Event
+}
+
+// Publisher records the events published by the service.
+type Publisher struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+// Publish implements events.Publisher.
+func (p *Publisher) Publish(ctx context.Context, topic string, event events.Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, Event{Topic: topic, Event: event})
+	return nil
+}
+
+// Events returns the events published so far.
+func (p *Publisher) Events() []Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Event(nil), p.events...)
+}
+
+// WaitFor waits until an event with the topic is published.
+func (p *Publisher) WaitFor(topic string, timeout time.Duration) (events.Event, error) {
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); {
+		for _, e := range p.Events() {
+			if e.Topic == topic {
+				return e.Event, nil
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil, errors.Errorf("timed out waiting for %s event", topic)
+}
+
+// Harness runs a shim service against the fake runsc in a directory.
+type Harness struct {
+	// Dir is the root directory of the harness.
+	Dir string
+	// Runsc is the path of the fake runsc binary.
+	Runsc string
+	// Service is the shim service under test.
+	Service *shim.Service
+	// Events records the events published by Service.
+	Events *Publisher
+}
+
+// New builds the fake runsc and starts a shim service in dir. The fake runsc
+// is put first in the PATH, so that it stands in for runsc also where the
+// shim runs it before a container is created, and the calling process
+// becomes a subreaper so that exits of exec processes are observed.
+func New(dir string) (*Harness, error) {
+	return NewWithConfig(dir, shim.Config{})
+}
+
+// NewWithConfig is New with the shim service configured by config, e.g. with
+// its signal map. The paths, namespace and stats of config are set by the
+// harness.
+func NewWithConfig(dir string, config shim.Config) (*Harness, error) {
+	bin, err := BuildFakeRunsc(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH")); err != nil {
+		return nil, err
+	}
+	if err := startReaper(); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, "shim")
+	if err := os.MkdirAll(filepath.Join(path, "rootfs"), 0755); err != nil {
+		return nil, err
+	}
+	publisher := &Publisher{}
+	config.Path = path
+	config.Namespace = Namespace
+	config.WorkDir = filepath.Join(dir, "work")
+	config.RuntimeRoot = filepath.Join(dir, "root")
+	config.Stats = stats.Config{
+		Interval: -1,
+	}
+	s, err := shim.NewService(config, publisher)
+	if err != nil {
+		return nil, err
+	}
+	return &Harness{
+		Dir:     dir,
+		Runsc:   bin,
+		Service: s,
+		Events:  publisher,
+	}, nil
+}
+
+func startReaper() error {
+	var err error
+	reaper.Do(func() {
+		runsc.Monitor = proc.DefaultReaper
+		if err = unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0); err != nil {
+			return
+		}
+		signals := make(chan os.Signal, 32)
+		signal.Notify(signals, unix.SIGCHLD)
+		go func() {
+			for range signals {
+				proc.DefaultReaper.Reap()
+			}
+		}()
+	})
+	return err
+}
+
+// Context returns a context in the harness namespace.
+func (h *Harness) Context() context.Context {
+	return namespaces.WithNamespace(context.Background(), Namespace)
+}
+
+// NewBundle writes an OCI bundle for a container running args.
+func (h *Harness) NewBundle(id string, args ...string) (string, error) {
+	bundle := filepath.Join(h.Dir, "bundles", id)
+	if err := os.MkdirAll(filepath.Join(bundle, "rootfs"), 0755); err != nil {
+		return "", err
+	}
+	spec := &specs.Spec{
+		Version: specs.Version,
+		Process: &specs.Process{
+			Args: args,
+			Cwd:  "/",
+		},
+		Root: &specs.Root{
+			Path: "rootfs",
+		},
+	}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(filepath.Join(bundle, "config.json"), data, 0644); err != nil {
+		return "", err
+	}
+	return bundle, nil
+}
+
+// Create creates a container running args with the fake runsc.
+func (h *Harness) Create(id string, args ...string) (*shimapi.CreateTaskResponse, error) {
+	bundle, err := h.NewBundle(id, args...)
+	if err != nil {
+		return nil, err
+	}
+	return h.Service.Create(h.Context(), &shimapi.CreateTaskRequest{
+		ID:      id,
+		Bundle:  bundle,
+		Runtime: h.Runsc,
+	})
+}
+
+// Start starts the process with the id, the container itself if id is the
+// container id.
+func (h *Harness) Start(id string) (*shimapi.StartResponse, error) {
+	return h.Service.Start(h.Context(), &shimapi.StartRequest{ID: id})
+}
+
+// Exec adds an exec process running args. Exec processes given the args
+// "exit", "<status>" exit immediately with that status.
+func (h *Harness) Exec(id string, args ...string) error {
+	data, err := json.Marshal(&specs.Process{
+		Args: args,
+		Cwd:  "/",
+	})
+	if err != nil {
+		return err
+	}
+	_, err = h.Service.Exec(h.Context(), &shimapi.ExecProcessRequest{
+		ID: id,
+		Spec: &ptypes.Any{
+			TypeUrl: processTypeURL,
+			Value:   data,
+		},
+	})
+	return err
+}
+
+// Kill sends the signal to the container.
+func (h *Harness) Kill(signal uint32, all bool) error {
+	_, err := h.Service.Kill(h.Context(), &shimapi.KillRequest{
+		Signal: signal,
+		All:    all,
+	})
+	return err
+}
+
+// Delete deletes the container.
+func (h *Harness) Delete() (*shimapi.DeleteResponse, error) {
+	return h.Service.Delete(h.Context(), &ptypes.Empty{})
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	eventstypes "github.com/containerd/containerd/api/events"
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/containerd/runtime"
+	shimapi "github.com/containerd/containerd/runtime/v1/shim/v1"
+	"golang.org/x/sys/unix"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// newHarness starts a harness in a temporary directory, removed by the
+// returned cleanup.
+func newHarness(tb testing.TB) (*Harness, func()) {
+	dir, err := ioutil.TempDir("", "harness-test-")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	h, err := New(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		tb.Fatal(err)
+	}
+	return h, func() { os.RemoveAll(dir) }
+}
+
+// startContainer creates and starts a container running until killed.
+func startContainer(tb testing.TB, h *Harness, id string) {
+	if _, err := h.Create(id, "sleep"); err != nil {
+		tb.Fatal(err)
+	}
+	if _, err := h.Start(id); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func TestCreateStartKillDelete(t *testing.T) {
+	h, cleanup := newHarness(t)
+	defer cleanup()
+
+	if _, err := h.Create("c1", "sleep"); err != nil {
+		t.Fatal(err)
+	}
+	ctx := h.Context()
+	st, err := h.Service.State(ctx, &shimapi.StateRequest{ID: "c1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Status != task.StatusCreated {
+		t.Fatalf("status after create: got %v, want %v", st.Status, task.StatusCreated)
+	}
+
+	start, err := h.Start("c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start.Pid == 0 {
+		t.Fatal("start returned no pid")
+	}
+	if st, err = h.Service.State(ctx, &shimapi.StateRequest{ID: "c1"}); err != nil {
+		t.Fatal(err)
+	}
+	if st.Status != task.StatusRunning {
+		t.Fatalf("status after start: got %v, want %v", st.Status, task.StatusRunning)
+	}
+
+	if err := h.Kill(uint32(unix.SIGKILL), false); err != nil {
+		t.Fatal(err)
+	}
+	e, err := h.Events.WaitFor(runtime.TaskExitEventTopic, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exit := e.(*eventstypes.TaskExit); exit.Pid != start.Pid {
+		t.Errorf("exit pid: got %d, want %d", exit.Pid, start.Pid)
+	}
+
+	d, err := h.Delete()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.ExitStatus != 128+uint32(unix.SIGKILL) {
+		t.Errorf("deleted exit status: got %d, want %d", d.ExitStatus, 128+uint32(unix.SIGKILL))
+	}
+	e, err = h.Events.WaitFor(runsctypes.DeleteDetailsEventTopic, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if details := e.(*runsctypes.DeleteDetails); details.ContainerID != "c1" {
+		t.Errorf("delete details container: got %q, want c1", details.ContainerID)
+	}
+}
+
+func TestExec(t *testing.T) {
+	h, cleanup := newHarness(t)
+	defer cleanup()
+	startContainer(t, h, "c1")
+	defer h.Delete()
+	defer h.Kill(uint32(unix.SIGKILL), true)
+
+	if err := h.Exec("e1", "exit", "3"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Start("e1"); err != nil {
+		t.Fatal(err)
+	}
+	ctx := h.Context()
+	w, err := h.Service.Wait(ctx, &shimapi.WaitRequest{ID: "e1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.ExitStatus != 3 {
+		t.Errorf("exit status: got %d, want 3", w.ExitStatus)
+	}
+	d, err := h.Service.DeleteProcess(ctx, &shimapi.DeleteProcessRequest{ID: "e1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.ExitStatus != 3 {
+		t.Errorf("deleted exit status: got %d, want 3", d.ExitStatus)
+	}
+	if _, err := h.Service.State(ctx, &shimapi.StateRequest{ID: "e1"}); err == nil {
+		t.Error("deleted exec process still has a state")
+	}
+}
+
+func TestKillAllExecs(t *testing.T) {
+	h, cleanup := newHarness(t)
+	defer cleanup()
+	startContainer(t, h, "c1")
+	defer h.Delete()
+
+	if err := h.Exec("e1", "sleep"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Start("e1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Kill(uint32(unix.SIGKILL), true); err != nil {
+		t.Fatal(err)
+	}
+	ctx := h.Context()
+	for _, id := range []string{"c1", "e1"} {
+		w, err := h.Service.Wait(ctx, &shimapi.WaitRequest{ID: id})
+		if err != nil {
+			t.Fatalf("wait %s: %v", id, err)
+		}
+		if w.ExitStatus != 128+uint32(unix.SIGKILL) {
+			t.Errorf("%s exit status: got %d, want %d", id, w.ExitStatus, 128+uint32(unix.SIGKILL))
+		}
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package test
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	shimapi "github.com/containerd/containerd/runtime/v1/shim/v1"
+	"golang.org/x/sys/unix"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/proc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/shim"
+)
+
+// newSignalHarness starts a harness whose shim translates signals with m.
+func newSignalHarness(t *testing.T, m map[string]string) (*Harness, func()) {
+	signals, err := proc.ParseSignalMap(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "harness-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewWithConfig(dir, shim.Config{Signals: signals})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return h, func() { os.RemoveAll(dir) }
+}
+
+// waitExit waits for the process id and checks it was killed by sig.
+func waitExit(t *testing.T, h *Harness, id string, sig syscall.Signal) {
+	w, err := h.Service.Wait(h.Context(), &shimapi.WaitRequest{ID: id})
+	if err != nil {
+		t.Fatalf("wait %s: %v", id, err)
+	}
+	if want := 128 + uint32(sig); w.ExitStatus != want {
+		t.Errorf("%s exit status: got %d, want %d", id, w.ExitStatus, want)
+	}
+}
+
+func TestKillRejectsSignals(t *testing.T) {
+	h, cleanup := newSignalHarness(t, map[string]string{"SIGWINCH": "reject"})
+	defer cleanup()
+	startContainer(t, h, "c1")
+	defer h.Delete()
+	defer h.Kill(uint32(unix.SIGKILL), true)
+
+	for _, tc := range []struct {
+		name   string
+		signal uint32
+		all    bool
+	}{
+		{name: "zero", signal: 0},
+		{name: "beyond the sandbox signals", signal: 65},
+		{name: "rejected by the signal map", signal: uint32(unix.SIGWINCH)},
+		{name: "rejected by the signal map with all", signal: uint32(unix.SIGWINCH), all: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := h.Kill(tc.signal, tc.all)
+			if !errdefs.IsInvalidArgument(errdefs.FromGRPC(err)) {
+				t.Fatalf("got %v, want an invalid argument error", err)
+			}
+		})
+	}
+	st, err := h.Service.State(h.Context(), &shimapi.StateRequest{ID: "c1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.ExitedAt.Unix() > 0 {
+		t.Error("container exited on rejected signals")
+	}
+}
+
+func TestKillTranslatesSignals(t *testing.T) {
+	h, cleanup := newSignalHarness(t, map[string]string{"SIGPWR": "SIGTERM"})
+	defer cleanup()
+	startContainer(t, h, "c1")
+	defer h.Delete()
+
+	if err := h.Kill(uint32(unix.SIGPWR), false); err != nil {
+		t.Fatal(err)
+	}
+	waitExit(t, h, "c1", unix.SIGTERM)
+}
+
+func TestKillAllTranslatesSignals(t *testing.T) {
+	h, cleanup := newSignalHarness(t, map[string]string{"SIGPWR": "SIGTERM"})
+	defer cleanup()
+	startContainer(t, h, "c1")
+	defer h.Delete()
+
+	if err := h.Exec("e1", "sleep"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Start("e1"); err != nil {
+		t.Fatal(err)
+	}
+	// runsc kill --all signals the exec processes of the sandbox too.
+	if err := h.Kill(uint32(unix.SIGPWR), true); err != nil {
+		t.Fatal(err)
+	}
+	waitExit(t, h, "c1", unix.SIGTERM)
+	waitExit(t, h, "e1", unix.SIGTERM)
+}
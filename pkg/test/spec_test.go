@@ -0,0 +1,252 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	shimapi "github.com/containerd/containerd/runtime/v1/shim/v1"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/proc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// traceSeccomp is a seccomp profile the shim translates for gVisor.
+var traceSeccomp = &specs.LinuxSeccomp{
+	DefaultAction: "SCMP_ACT_TRACE",
+}
+
+// newSpecBundle writes the bundle of a container running sleep, with its
+// spec changed by edit.
+func newSpecBundle(t *testing.T, h *Harness, id string, edit func(*specs.Spec)) string {
+	bundle, err := h.NewBundle(id, "sleep")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(bundle, "config.json")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spec specs.Spec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		t.Fatal(err)
+	}
+	edit(&spec)
+	if data, err = json.Marshal(&spec); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return bundle
+}
+
+func readSpecFile(t *testing.T, bundle string) []byte {
+	data, err := ioutil.ReadFile(filepath.Join(bundle, "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestCreateTranslatesSeccomp(t *testing.T) {
+	h, cleanup := newHarness(t)
+	defer cleanup()
+
+	bundle := newSpecBundle(t, h, "c1", func(spec *specs.Spec) {
+		spec.Linux = &specs.Linux{Seccomp: traceSeccomp}
+	})
+	if _, err := h.Service.Create(h.Context(), &shimapi.CreateTaskRequest{
+		ID:      "c1",
+		Bundle:  bundle,
+		Runtime: h.Runsc,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var spec specs.Spec
+	if err := json.Unmarshal(readSpecFile(t, bundle), &spec); err != nil {
+		t.Fatal(err)
+	}
+	if got := spec.Linux.Seccomp.DefaultAction; got != specs.ActErrno {
+		t.Errorf("seccomp default action: got %q, want %q", got, specs.ActErrno)
+	}
+	if _, err := h.Events.WaitFor(runsctypes.SeccompRewrittenEventTopic, time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCreateValidatesSpec(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		edit  func(*specs.Spec)
+		field string
+	}{
+		{
+			// Warnings are logged, the container is created.
+			name: "ignored features",
+			edit: func(spec *specs.Spec) {
+				spec.Process.ApparmorProfile = "runtime/default"
+				spec.Linux = &specs.Linux{MountLabel: "system_u"}
+			},
+		},
+		{
+			name: "unsupported device",
+			edit: func(spec *specs.Spec) {
+				spec.Linux = &specs.Linux{Devices: []specs.LinuxDevice{{Path: "/dev/sda", Type: "b", Major: 8}}}
+			},
+			field: "linux.devices[0]",
+		},
+		{
+			name: "unsupported char device",
+			edit: func(spec *specs.Spec) {
+				spec.Linux = &specs.Linux{Devices: []specs.LinuxDevice{{Path: "/dev/mem", Type: "c", Major: 1, Minor: 1}}}
+			},
+			field: "linux.devices[0]",
+		},
+		{
+			name: "invalid mount hint",
+			edit: func(spec *specs.Spec) {
+				spec.Annotations = map[string]string{"dev.gvisor.spec.mount.data.share": "host"}
+			},
+			field: "dev.gvisor.spec.mount.data.share",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h, cleanup := newHarness(t)
+			defer cleanup()
+
+			bundle := newSpecBundle(t, h, "c1", tc.edit)
+			_, err := h.Service.Create(h.Context(), &shimapi.CreateTaskRequest{
+				ID:      "c1",
+				Bundle:  bundle,
+				Runtime: h.Runsc,
+			})
+			if tc.field == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				h.Delete()
+				return
+			}
+			if !errdefs.IsInvalidArgument(errdefs.FromGRPC(err)) {
+				t.Fatalf("got %v, want an invalid argument error", err)
+			}
+			if !strings.Contains(err.Error(), tc.field) {
+				t.Errorf("error %q doesn't name %s", err, tc.field)
+			}
+			if _, err := h.Service.State(h.Context(), &shimapi.StateRequest{ID: "c1"}); err == nil {
+				t.Error("rejected container was created")
+			}
+		})
+	}
+}
+
+func TestCreateFailureKeepsSpec(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		terminal bool
+		linux    *specs.Linux
+		invalid  bool
+	}{
+		{
+			name: "incompatible spec",
+			linux: &specs.Linux{
+				Seccomp: traceSeccomp,
+				Devices: []specs.LinuxDevice{{Path: "/dev/sda", Type: "b", Major: 8}},
+			},
+			invalid: true,
+		},
+		{
+			// The fake runsc fails to create containers with a terminal.
+			name:     "runsc create failure",
+			terminal: true,
+			linux:    &specs.Linux{Seccomp: traceSeccomp},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h, cleanup := newHarness(t)
+			defer cleanup()
+
+			bundle := newSpecBundle(t, h, "c1", func(spec *specs.Spec) {
+				spec.Linux = tc.linux
+			})
+			before := readSpecFile(t, bundle)
+			_, err := h.Service.Create(h.Context(), &shimapi.CreateTaskRequest{
+				ID:       "c1",
+				Bundle:   bundle,
+				Runtime:  h.Runsc,
+				Terminal: tc.terminal,
+			})
+			if err == nil {
+				t.Fatal("create succeeded")
+			}
+			if tc.invalid && !errdefs.IsInvalidArgument(errdefs.FromGRPC(err)) {
+				t.Errorf("got %v, want an invalid argument error", err)
+			}
+			if after := readSpecFile(t, bundle); !bytes.Equal(after, before) {
+				t.Errorf("spec changed by failed create:\n%s", after)
+			}
+			for _, e := range h.Events.Events() {
+				if tc.invalid && e.Topic == runsctypes.SeccompRewrittenEventTopic {
+					t.Error("seccomp rewrite published for a rejected spec")
+				}
+			}
+		})
+	}
+}
+
+func TestDryRunKeepsSpec(t *testing.T) {
+	h, cleanup := newHarness(t)
+	defer cleanup()
+
+	bundle := newSpecBundle(t, h, "c1", func(spec *specs.Spec) {
+		spec.Annotations = map[string]string{proc.DryRunAnnotation: "true"}
+		spec.Linux = &specs.Linux{Seccomp: traceSeccomp}
+	})
+	before := readSpecFile(t, bundle)
+	_, err := h.Service.Create(h.Context(), &shimapi.CreateTaskRequest{
+		ID:      "c1",
+		Bundle:  bundle,
+		Runtime: h.Runsc,
+	})
+	if !errdefs.IsFailedPrecondition(errdefs.FromGRPC(err)) {
+		t.Fatalf("got %v, want the failed precondition of a dry run", err)
+	}
+	if after := readSpecFile(t, bundle); !bytes.Equal(after, before) {
+		t.Errorf("spec changed by dry run:\n%s", after)
+	}
+	e, err := h.Events.WaitFor(runsctypes.DryRunEventTopic, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var spec specs.Spec
+	if err := json.Unmarshal([]byte(e.(*runsctypes.DryRun).Spec), &spec); err != nil {
+		t.Fatal(err)
+	}
+	if got := spec.Linux.Seccomp.DefaultAction; got != specs.ActErrno {
+		t.Errorf("dry run seccomp default action: got %q, want %q", got, specs.ActErrno)
+	}
+}
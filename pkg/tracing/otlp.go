@@ -0,0 +1,301 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// defaultServiceName is the service.name resource attribute of the
+	// spans when OTEL_SERVICE_NAME is not set.
+	defaultServiceName = "gvisor-containerd-shim"
+	// tracesPath is the OTLP/HTTP path of traces, appended to endpoints
+	// that are not specific to traces.
+	tracesPath = "/v1/traces"
+	// queueSize bounds the spans waiting for export. Spans are dropped
+	// when the collector can't keep up.
+	queueSize = 2048
+	// batchSize is the most spans exported in a request.
+	batchSize = 512
+	// exportInterval is how often queued spans are exported.
+	exportInterval = 5 * time.Second
+	// exportTimeout bounds a request to the collector.
+	exportTimeout = 10 * time.Second
+)
+
+// Config configures the export of spans.
+type Config struct {
+	// Endpoint is the base URL of the OTLP/HTTP collector, e.g.
+	// "http://localhost:4318", to which /v1/traces is appended. When empty
+	// the OTEL_EXPORTER_OTLP_TRACES_ENDPOINT and
+	// OTEL_EXPORTER_OTLP_ENDPOINT variables are used, and tracing stays
+	// disabled if neither is set.
+	Endpoint string
+}
+
+// tracesURL returns the URL spans are posted to, empty when tracing is
+// disabled.
+func (c Config) tracesURL() (string, error) {
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		if v := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); v != "" {
+			return v, checkURL(v)
+		}
+		endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	if endpoint == "" {
+		return "", nil
+	}
+	u := strings.TrimSuffix(endpoint, "/") + tracesPath
+	return u, checkURL(u)
+}
+
+func checkURL(v string) error {
+	u, err := url.Parse(v)
+	if err != nil {
+		return errors.Wrapf(err, "invalid OTLP endpoint %q", v)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.Errorf("invalid OTLP endpoint %q: only http and https are supported", v)
+	}
+	return nil
+}
+
+// Configure enables the export of spans as configured, replacing any
+// previous configuration. Tracing stays disabled when no endpoint is
+// configured.
+func Configure(c Config) error {
+	u, err := c.tracesURL()
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	old := exporter
+	exporter = nil
+	if u != "" {
+		exporter = newExporter(u)
+	}
+	mu.Unlock()
+	if old != nil {
+		old.shutdown(context.Background())
+	}
+	return nil
+}
+
+// Flush exports the ended spans and disables tracing, waiting until ctx is
+// done at most.
+func Flush(ctx context.Context) {
+	mu.Lock()
+	e := exporter
+	exporter = nil
+	mu.Unlock()
+	if e != nil {
+		e.shutdown(ctx)
+	}
+}
+
+// otlpExporter posts batches of spans to a collector in the OTLP/HTTP JSON
+// encoding.
+type otlpExporter struct {
+	url     string
+	service string
+	client  *http.Client
+	spans   chan *Span
+	done    chan struct{}
+}
+
+func newExporter(u string) *otlpExporter {
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = defaultServiceName
+	}
+	e := &otlpExporter{
+		url:     u,
+		service: service,
+		client:  &http.Client{Timeout: exportTimeout},
+		spans:   make(chan *Span, queueSize),
+		done:    make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+func (e *otlpExporter) queue(s *Span) {
+	select {
+	case e.spans <- s:
+	default:
+		logrus.Debugf("dropped span %q, trace export queue is full", s.name)
+	}
+}
+
+func (e *otlpExporter) run() {
+	defer close(e.done)
+	t := time.NewTicker(exportInterval)
+	defer t.Stop()
+	var batch []*Span
+	for {
+		select {
+		case s, ok := <-e.spans:
+			if !ok {
+				e.export(batch)
+				return
+			}
+			if batch = append(batch, s); len(batch) >= batchSize {
+				e.export(batch)
+				batch = nil
+			}
+		case <-t.C:
+			e.export(batch)
+			batch = nil
+		}
+	}
+}
+
+// shutdown exports the queued spans. Spans ended afterwards are dropped.
+func (e *otlpExporter) shutdown(ctx context.Context) {
+	close(e.spans)
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+	}
+}
+
+func (e *otlpExporter) export(spans []*Span) {
+	if len(spans) == 0 {
+		return
+	}
+	data, err := json.Marshal(e.request(spans))
+	if err != nil {
+		logrus.WithError(err).Warn("failed to marshal spans")
+		return
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		logrus.WithError(err).Warnf("failed to export %d spans", len(spans))
+		return
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		logrus.Warnf("failed to export %d spans: collector returned %s", len(spans), resp.Status)
+	}
+}
+
+// The OTLP/HTTP JSON encoding of the ExportTraceServiceRequest message.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+	otlpAttribute struct {
+		Key   string    `json:"key"`
+		Value otlpValue `json:"value"`
+	}
+	otlpValue struct {
+		StringValue string `json:"stringValue"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+)
+
+const (
+	// spanKindInternal is SPAN_KIND_INTERNAL.
+	spanKindInternal = 1
+	// statusCodeError is STATUS_CODE_ERROR.
+	statusCodeError = 2
+)
+
+func (e *otlpExporter) request(spans []*Span) *otlpRequest {
+	out := make([]otlpSpan, 0, len(spans))
+	for _, s := range spans {
+		s.mu.Lock()
+		o := otlpSpan{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parent != ([8]byte{}) {
+			o.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		for _, a := range s.attrs {
+			o.Attributes = append(o.Attributes, otlpAttribute{Key: a.Key, Value: otlpValue{StringValue: a.Value}})
+		}
+		if s.err != nil {
+			o.Status = otlpStatus{Code: statusCodeError, Message: s.err.Error()}
+		}
+		s.mu.Unlock()
+		out = append(out, o)
+	}
+	return &otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{
+				Attributes: []otlpAttribute{
+					{Key: "service.name", Value: otlpValue{StringValue: e.service}},
+					{Key: "process.pid", Value: otlpValue{StringValue: strconv.Itoa(os.Getpid())}},
+				},
+			},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "github.com/google/gvisor-containerd-shim"},
+				Spans: out,
+			}},
+		}},
+	}
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing records spans of the shim operations and runsc commands,
+// and exports them to an OpenTelemetry collector with OTLP over HTTP, so
+// that container startup latency can be broken down, e.g. into rootfs
+// mounts and runsc create.
+//
+// Tracing is disabled until Configure is called with an endpoint, either
+// from the shim config or from the standard OTEL_EXPORTER_OTLP_ENDPOINT and
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT variables. Spans started while it is
+// disabled are nil and cost nothing.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+const (
+	// ContainerIDKey is the attribute of the container id.
+	ContainerIDKey = "container.id"
+	// NamespaceKey is the attribute of the containerd namespace.
+	NamespaceKey = "containerd.namespace"
+)
+
+// Attribute is a key value pair describing a span.
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// Attr returns an attribute.
+func Attr(key, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Span is a timed operation. Its methods may be called on a nil span.
+type Span struct {
+	traceID [16]byte
+	spanID  [8]byte
+	parent  [8]byte
+	name    string
+	start   time.Time
+	end     time.Time
+
+	mu    sync.Mutex
+	attrs []Attribute
+	err   error
+}
+
+type spanKey struct{}
+
+var (
+	mu       sync.Mutex
+	exporter *otlpExporter
+)
+
+// Start starts a span named name, child of the span of ctx if any, and
+// returns a context carrying it. Spans inherit the attributes of their
+// parent, such as the container id.
+func Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, *Span) {
+	mu.Lock()
+	enabled := exporter != nil
+	mu.Unlock()
+	if !enabled {
+		return ctx, nil
+	}
+	s := &Span{
+		name:  name,
+		start: time.Now(),
+	}
+	if p := FromContext(ctx); p != nil {
+		s.traceID = p.traceID
+		s.parent = p.spanID
+		p.mu.Lock()
+		s.attrs = append(s.attrs, p.attrs...)
+		p.mu.Unlock()
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	s.SetAttributes(attrs...)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// FromContext returns the span of ctx, nil if none.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// SetAttributes sets attributes of the span, replacing attributes with the
+// same keys.
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+next:
+	for _, a := range attrs {
+		for i := range s.attrs {
+			if s.attrs[i].Key == a.Key {
+				s.attrs[i] = a
+				continue next
+			}
+		}
+		s.attrs = append(s.attrs, a)
+	}
+}
+
+// End ends the span, marking it failed if err is not nil, and queues it
+// for export.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.end = time.Now()
+	s.err = err
+	s.mu.Unlock()
+	mu.Lock()
+	defer mu.Unlock()
+	if exporter != nil {
+		exporter.queue(s)
+	}
+}
+
+// TraceID returns the hex encoded trace id of the span, empty for a nil
+// span.
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.traceID[:])
+}
@@ -0,0 +1,250 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package admin inspects and repairs the state the shims leave on a node:
+// it lists the sandboxes under the runsc root with the shim, bundle and work
+// directory of each, flags inconsistencies such as sandboxes whose shim
+// died, and force cleans a sandbox. It backs the admin subcommand of the
+// shim binaries, for recovering broken nodes.
+package admin
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	runc "github.com/containerd/go-runc"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/proc"
+)
+
+// addressFile is the file of the bundle the v2 shim writes its socket
+// address to.
+const addressFile = "address"
+
+// Sandbox is a container known to runsc, with the shim state around it.
+type Sandbox struct {
+	Namespace string `json:"namespace"`
+	ID        string `json:"id"`
+	Status    string `json:"status"`
+	Pid       int    `json:"pid"`
+	Bundle    string `json:"bundle"`
+	// ShimPid is the pid of the shim owning the container, zero if the
+	// bundle doesn't record it, and ShimAlive whether it still runs.
+	ShimPid   int  `json:"shim_pid,omitempty"`
+	ShimAlive bool `json:"shim_alive"`
+	// ShimSocket is the ttrpc socket of the shim, empty if unknown.
+	ShimSocket string `json:"shim_socket,omitempty"`
+	WorkDir    string `json:"work_dir,omitempty"`
+	// Problems are the inconsistencies found in the state of the
+	// container.
+	Problems []string `json:"problems,omitempty"`
+}
+
+// Admin inspects the sandboxes under a runsc root.
+type Admin struct {
+	// Root is the runsc root directory, with a directory per namespace.
+	Root string
+	// Runtime is the runsc binary, runsc.DefaultCommand if empty.
+	Runtime string
+}
+
+// runsc returns the runsc client of namespace.
+func (a *Admin) runsc(namespace string) *runsc.Runsc {
+	return &runsc.Runsc{
+		Command: a.Runtime,
+		Root:    filepath.Join(a.Root, namespace),
+	}
+}
+
+// Namespaces returns the namespaces with a runsc root.
+func (a *Admin) Namespaces() ([]string, error) {
+	entries, err := ioutil.ReadDir(a.Root)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read runsc root %s", a.Root)
+	}
+	var namespaces []string
+	for _, e := range entries {
+		// Dot directories, such as the runsc limits, aren't namespaces.
+		if e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+			namespaces = append(namespaces, e.Name())
+		}
+	}
+	return namespaces, nil
+}
+
+// List returns the sandboxes of namespace, or of all namespaces if it is
+// empty, sorted by namespace and id.
+func (a *Admin) List(ctx context.Context, namespace string) ([]*Sandbox, error) {
+	namespaces := []string{namespace}
+	if namespace == "" {
+		var err error
+		if namespaces, err = a.Namespaces(); err != nil {
+			return nil, err
+		}
+	}
+	var sandboxes []*Sandbox
+	for _, ns := range namespaces {
+		containers, err := a.runsc(ns).List(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list the containers of namespace %s", ns)
+		}
+		for _, c := range containers {
+			sandboxes = append(sandboxes, inspect(ns, c))
+		}
+	}
+	sort.Slice(sandboxes, func(i, j int) bool {
+		if sandboxes[i].Namespace != sandboxes[j].Namespace {
+			return sandboxes[i].Namespace < sandboxes[j].Namespace
+		}
+		return sandboxes[i].ID < sandboxes[j].ID
+	})
+	return sandboxes, nil
+}
+
+// Get returns the sandbox id of namespace.
+func (a *Admin) Get(ctx context.Context, namespace, id string) (*Sandbox, error) {
+	c, err := a.runsc(namespace).State(ctx, id)
+	if err != nil {
+		if runsc.IsNotFound(err) {
+			return nil, errors.Wrapf(errdefs.ErrNotFound, "container %s in namespace %s", id, namespace)
+		}
+		return nil, err
+	}
+	return inspect(namespace, c), nil
+}
+
+// Clean force deletes the sandbox id of namespace, unmounts its rootfs and
+// removes its work directory. Sandboxes whose shim still runs are only
+// cleaned with force, as the shim would then lose its container.
+func (a *Admin) Clean(ctx context.Context, namespace, id string, force bool) error {
+	r := a.runsc(namespace)
+	c, err := r.State(ctx, id)
+	if err != nil {
+		if runsc.IsNotFound(err) {
+			return errors.Wrapf(errdefs.ErrNotFound, "container %s in namespace %s", id, namespace)
+		}
+		return err
+	}
+	s := inspect(namespace, c)
+	if s.ShimAlive && !force {
+		return errors.Wrapf(errdefs.ErrFailedPrecondition, "shim %d still owns container %s, stop it or clean with force", s.ShimPid, id)
+	}
+	if err := proc.ForceDelete(ctx, r, c); err != nil {
+		return errors.Wrapf(err, "failed to delete container %s", id)
+	}
+	if c.Bundle != "" {
+		if err := os.Remove(filepath.Join(c.Bundle, proc.InitPidFile)); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "failed to remove the pid file of container %s", id)
+		}
+	}
+	return nil
+}
+
+// inspect returns the sandbox of the container c and its problems.
+func inspect(namespace string, c *runc.Container) *Sandbox {
+	s := &Sandbox{
+		Namespace: namespace,
+		ID:        c.ID,
+		Status:    c.Status,
+		Pid:       c.Pid,
+		Bundle:    c.Bundle,
+	}
+	if c.Bundle == "" {
+		s.problem("runsc doesn't record the bundle")
+		return s
+	}
+	if _, err := os.Stat(c.Bundle); err != nil {
+		s.problem("bundle %s is gone", c.Bundle)
+		return s
+	}
+	if _, err := os.Stat(filepath.Join(c.Bundle, "config.json")); err != nil {
+		s.problem("bundle has no config.json")
+	}
+	if pid, err := runc.ReadPidFile(filepath.Join(c.Bundle, proc.ShimPidFile)); err == nil {
+		s.ShimPid = pid
+		s.ShimAlive = alive(pid)
+		if !s.ShimAlive {
+			s.problem("shim %d is dead", pid)
+		}
+	} else {
+		s.problem("bundle has no %s, the shim is unknown", proc.ShimPidFile)
+	}
+	s.ShimSocket = shimSocket(c.Bundle, s.ShimPid, s.ShimAlive)
+	switch c.Status {
+	case "running", "paused":
+		if c.Pid > 0 && !alive(c.Pid) {
+			s.problem("sandbox process %d is dead", c.Pid)
+		}
+	case "stopped":
+		if !s.ShimAlive {
+			s.problem("stopped container was never deleted")
+		}
+	}
+	if work, err := proc.WorkDir(c.Bundle); err != nil {
+		s.problem("unreadable work link: %v", err)
+	} else if work != "" {
+		s.WorkDir = work
+		if _, err := os.Stat(work); err != nil {
+			s.problem("work dir %s is gone", work)
+		}
+	}
+	return s
+}
+
+func (s *Sandbox) problem(format string, args ...interface{}) {
+	s.Problems = append(s.Problems, fmt.Sprintf(format, args...))
+}
+
+func alive(pid int) bool {
+	return unix.Kill(pid, 0) != unix.ESRCH
+}
+
+// shimSocket returns the socket of the shim: the address the v2 shim writes
+// in the bundle, or the -socket flag of the v1 shim.
+func shimSocket(bundle string, pid int, running bool) string {
+	if data, err := ioutil.ReadFile(filepath.Join(bundle, addressFile)); err == nil {
+		return strings.TrimSpace(string(data))
+	}
+	if !running {
+		return ""
+	}
+	cmdline, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "cmdline"))
+	if err != nil {
+		return ""
+	}
+	args := bytes.Split(cmdline, []byte{0})
+	for i, arg := range args {
+		a := string(arg)
+		switch {
+		case (a == "-socket" || a == "--socket") && i+1 < len(args):
+			return string(args[i+1])
+		case strings.HasPrefix(a, "-socket="), strings.HasPrefix(a, "--socket="):
+			return a[strings.Index(a, "=")+1:]
+		}
+	}
+	return ""
+}
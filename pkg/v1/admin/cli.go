@@ -0,0 +1,135 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/proc"
+)
+
+// Command is the name of the admin subcommand of the shim binaries.
+const Command = "admin"
+
+const usage = `usage: %s admin [flags] <command> [id]
+
+Commands:
+  list         list the sandboxes with their shim, bundle and work dir
+  check        list the sandboxes with inconsistent state, failing if any
+  clean <id>   force delete a sandbox, unmount its rootfs and remove its
+               work dir; requires -namespace, and -force if its shim runs
+
+Flags:
+`
+
+// Run runs the admin subcommand of the shim binary named name with args,
+// writing its output to w.
+func Run(ctx context.Context, name string, args []string, w io.Writer) error {
+	fs := flag.NewFlagSet(Command, flag.ContinueOnError)
+	fs.SetOutput(w)
+	fs.Usage = func() {
+		fmt.Fprintf(w, usage, name)
+		fs.PrintDefaults()
+	}
+	a := &Admin{}
+	fs.StringVar(&a.Root, "root", proc.RunscRoot, "runsc root directory")
+	fs.StringVar(&a.Runtime, "runtime", "", "path to the runsc binary")
+	namespace := fs.String("namespace", "", "namespace of the sandboxes, all if empty")
+	asJSON := fs.Bool("json", false, "print the sandboxes as JSON")
+	force := fs.Bool("force", false, "clean sandboxes whose shim still runs")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	switch fs.Arg(0) {
+	case "list", "check":
+		sandboxes, err := a.List(ctx, *namespace)
+		if err != nil {
+			return err
+		}
+		if fs.Arg(0) == "check" {
+			sandboxes = withProblems(sandboxes)
+		}
+		if err := printSandboxes(w, sandboxes, *asJSON); err != nil {
+			return err
+		}
+		if fs.Arg(0) == "check" && len(sandboxes) > 0 {
+			return errors.Errorf("%d sandboxes with problems", len(sandboxes))
+		}
+		return nil
+	case "clean":
+		if *namespace == "" || fs.Arg(1) == "" {
+			return errors.New("clean requires -namespace and a container id")
+		}
+		if err := a.Clean(ctx, *namespace, fs.Arg(1), *force); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "cleaned %s/%s\n", *namespace, fs.Arg(1))
+		return nil
+	default:
+		fs.Usage()
+		return errors.Errorf("unknown admin command %q", fs.Arg(0))
+	}
+}
+
+func withProblems(sandboxes []*Sandbox) []*Sandbox {
+	var out []*Sandbox
+	for _, s := range sandboxes {
+		if len(s.Problems) > 0 {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func printSandboxes(w io.Writer, sandboxes []*Sandbox, asJSON bool) error {
+	if asJSON {
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		if sandboxes == nil {
+			sandboxes = []*Sandbox{}
+		}
+		return e.Encode(sandboxes)
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAMESPACE\tID\tSTATUS\tPID\tSHIM\tSOCKET\tBUNDLE\tWORK DIR\tPROBLEMS")
+	for _, s := range sandboxes {
+		shim := "-"
+		if s.ShimPid != 0 {
+			shim = fmt.Sprint(s.ShimPid)
+			if !s.ShimAlive {
+				shim += " (dead)"
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n", s.Namespace, s.ID, s.Status, s.Pid, shim, dash(s.ShimSocket), dash(s.Bundle), dash(s.WorkDir), dash(strings.Join(s.Problems, "; ")))
+	}
+	return tw.Flush()
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
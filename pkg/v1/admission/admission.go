@@ -0,0 +1,148 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package admission runs the admission hooks compiled into the shim before
+// containers are created and processes are executed in them. Hooks enforce
+// the policies of a distribution, e.g. forbidding privileged containers or
+// requiring a seccomp profile, and may change the runsc flags of a sandbox
+// before it is launched.
+//
+// Hooks register themselves from the init function of their package, which
+// a distribution links into its shim binary with a blank import:
+//
+//	import _ "example.com/shim/policy"
+package admission
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/gogo/protobuf/types"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+)
+
+// CreateRequest is a container about to be created.
+type CreateRequest struct {
+	Namespace string
+	ID        string
+	Bundle    string
+	// Spec is the OCI spec of the container. Hooks must not change it.
+	Spec *specs.Spec
+	// RunscFlags are the runsc flags the container is created with. Hooks
+	// may change them.
+	RunscFlags map[string]string
+}
+
+// ExecRequest is a process about to be executed in a container.
+type ExecRequest struct {
+	Namespace   string
+	ContainerID string
+	ExecID      string
+	// Process is the spec of the process. Hooks must not change it.
+	Process *specs.Process
+}
+
+// Hook admits or denies containers and exec processes. A hook denies a
+// request by returning an error.
+type Hook interface {
+	AdmitCreate(ctx context.Context, r *CreateRequest) error
+	AdmitExec(ctx context.Context, r *ExecRequest) error
+}
+
+var (
+	mu    sync.Mutex
+	hooks = make(map[string]Hook)
+)
+
+// Register registers a hook under name. Registering two hooks under the
+// same name panics.
+func Register(name string, h Hook) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := hooks[name]; ok {
+		panic("admission hook " + name + " is already registered")
+	}
+	hooks[name] = h
+}
+
+// Hooks returns the names of the registered hooks, in the order they run.
+func Hooks() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	names := make([]string, 0, len(hooks))
+	for name := range hooks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Enabled returns whether hooks are registered.
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return len(hooks) > 0
+}
+
+// ExecProcess decodes the process spec of an exec request.
+func ExecProcess(spec *types.Any) (*specs.Process, error) {
+	var p specs.Process
+	if spec == nil {
+		return nil, errors.Wrap(errdefs.ErrInvalidArgument, "exec spec not provided")
+	}
+	if err := json.Unmarshal(spec.Value, &p); err != nil {
+		return nil, errors.Wrap(errdefs.ErrInvalidArgument, err.Error())
+	}
+	return &p, nil
+}
+
+func hook(name string) Hook {
+	mu.Lock()
+	defer mu.Unlock()
+	return hooks[name]
+}
+
+// AdmitCreate runs the hooks on a container in name order, and returns the
+// first denial as a failed precondition. The runsc flags of r are replaced
+// by a copy before the hooks run, so that shared flags aren't changed.
+func AdmitCreate(ctx context.Context, r *CreateRequest) error {
+	flags := make(map[string]string, len(r.RunscFlags))
+	for k, v := range r.RunscFlags {
+		flags[k] = v
+	}
+	r.RunscFlags = flags
+	for _, name := range Hooks() {
+		if err := hook(name).AdmitCreate(ctx, r); err != nil {
+			return errors.Wrapf(errdefs.ErrFailedPrecondition, "container denied by admission hook %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// AdmitExec runs the hooks on an exec process in name order, and returns
+// the first denial as a failed precondition.
+func AdmitExec(ctx context.Context, r *ExecRequest) error {
+	for _, name := range Hooks() {
+		if err := hook(name).AdmitExec(ctx, r); err != nil {
+			return errors.Wrapf(errdefs.ErrFailedPrecondition, "process denied by admission hook %q: %v", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package audit guards the RPC surface of the shims: the ids and paths of
+// requests are validated before the services act on them, and each call
+// changing the state of a container is recorded in the shim log. The
+// services trust containerd, but the ids end up in paths under the runsc
+// root and the paths are handed to runsc, so malformed values are rejected
+// at the boundary rather than wherever they would first do harm.
+package audit
+
+import (
+	"context"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/containerd/namespaces"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// maxIDLength is the longest id accepted, that of containerd.
+const maxIDLength = 76
+
+// idPattern matches the ids accepted by containerd: alphanumeric runs
+// separated by single dots, underscores or dashes.
+var idPattern = regexp.MustCompile(`^[A-Za-z0-9]+(?:[._-][A-Za-z0-9]+)*$`)
+
+// CheckID checks the id of a container or process given as kind.
+func CheckID(kind, id string) error {
+	if id == "" {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "%s id must not be empty", kind)
+	}
+	if len(id) > maxIDLength {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "%s id %q is longer than %d characters", kind, id, maxIDLength)
+	}
+	if !idPattern.MatchString(id) {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "%s id %q must be alphanumeric runs separated by '.', '_' or '-'", kind, id)
+	}
+	return nil
+}
+
+// CheckExecID checks the id of a process of a request, empty for the init
+// process.
+func CheckExecID(id string) error {
+	if id == "" {
+		return nil
+	}
+	return CheckID("exec", id)
+}
+
+// CheckPath checks a path given as kind: it must be absolute and must not
+// climb out of a directory with "..".
+func CheckPath(kind, path string) error {
+	if path == "" {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "%s path must not be empty", kind)
+	}
+	if strings.ContainsRune(path, 0) {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "%s path %q contains a NUL byte", kind, path)
+	}
+	if !filepath.IsAbs(path) {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "%s path %q must be absolute", kind, path)
+	}
+	for _, e := range strings.Split(path, "/") {
+		if e == ".." {
+			return errors.Wrapf(errdefs.ErrInvalidArgument, "%s path %q must not contain \"..\"", kind, path)
+		}
+	}
+	return nil
+}
+
+// Record writes the audit record of a call of method changing the state of
+// a container, with fields describing the request and the result of the
+// call, nil if it succeeded. It is meant to be deferred with the error
+// returned by the call.
+func Record(ctx context.Context, method string, fields logrus.Fields, err *error) {
+	entry := log.G(ctx).WithFields(fields).WithField("audit", method)
+	if ns, ok := namespaces.Namespace(ctx); ok {
+		entry = entry.WithField("namespace", ns)
+	}
+	if *err != nil {
+		entry.WithError(*err).Warn("audit: call failed")
+		return
+	}
+	entry.Info("audit: call succeeded")
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+)
+
+func TestCheckID(t *testing.T) {
+	for _, tc := range []struct {
+		id    string
+		valid bool
+	}{
+		{id: "c1", valid: true},
+		{id: "0123abc", valid: true},
+		{id: "a.b_c-d", valid: true},
+		{id: strings.Repeat("a", maxIDLength), valid: true},
+		{id: ""},
+		{id: strings.Repeat("a", maxIDLength+1)},
+		{id: "-a"},
+		{id: "a-"},
+		{id: "a--b"},
+		{id: "a..b"},
+		{id: ".."},
+		{id: "a/b"},
+		{id: "a b"},
+		{id: "a\x00b"},
+		{id: "é"},
+	} {
+		err := CheckID("container", tc.id)
+		if tc.valid && err != nil {
+			t.Errorf("CheckID(%q): %v", tc.id, err)
+		}
+		if !tc.valid && !errdefs.IsInvalidArgument(err) {
+			t.Errorf("CheckID(%q): got %v, want an invalid argument error", tc.id, err)
+		}
+	}
+}
+
+func TestCheckExecID(t *testing.T) {
+	if err := CheckExecID(""); err != nil {
+		t.Errorf("CheckExecID of the init process: %v", err)
+	}
+	if err := CheckExecID("exec-1"); err != nil {
+		t.Errorf("CheckExecID(%q): %v", "exec-1", err)
+	}
+	if err := CheckExecID("../exec"); !errdefs.IsInvalidArgument(err) {
+		t.Errorf("CheckExecID(%q): got %v, want an invalid argument error", "../exec", err)
+	}
+}
+
+func TestCheckPath(t *testing.T) {
+	for _, tc := range []struct {
+		path  string
+		valid bool
+	}{
+		{path: "/", valid: true},
+		{path: "/run/containerd/io.containerd.runtime.v1.linux/k8s.io/c1", valid: true},
+		{path: "/a/..b/c..", valid: true},
+		{path: "/a/./b", valid: true},
+		{path: ""},
+		{path: "a/b"},
+		{path: "./a"},
+		{path: "/a/../b"},
+		{path: "/a/.."},
+		{path: "/a\x00b"},
+	} {
+		err := CheckPath("bundle", tc.path)
+		if tc.valid && err != nil {
+			t.Errorf("CheckPath(%q): %v", tc.path, err)
+		}
+		if !tc.valid && !errdefs.IsInvalidArgument(err) {
+			t.Errorf("CheckPath(%q): got %v, want an invalid argument error", tc.path, err)
+		}
+	}
+}
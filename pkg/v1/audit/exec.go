@@ -0,0 +1,82 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	// Registers the type of the OCI processes sent by containerd.
+	_ "github.com/containerd/containerd/runtime"
+	"github.com/containerd/typeurl"
+	"github.com/gogo/protobuf/types"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// MaxExecSpecSize is the largest spec of an Exec request accepted, well over
+// the args and environment the kernel takes.
+const MaxExecSpecSize = 4 << 20
+
+// CheckExecSpec checks the spec of an Exec request before it is decoded by
+// the services: it must be an OCI process or a runsctypes.ExecSpec of at
+// most MaxExecSpecSize bytes, with args and an absolute working directory.
+func CheckExecSpec(spec *types.Any) error {
+	if spec == nil {
+		return errors.Wrap(errdefs.ErrInvalidArgument, "exec spec must not be empty")
+	}
+	if len(spec.Value) > MaxExecSpecSize {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "exec spec of %d bytes is larger than %d bytes", len(spec.Value), MaxExecSpecSize)
+	}
+	if !typeurl.Is(spec, &specs.Process{}) && !typeurl.Is(spec, &runsctypes.ExecSpec{}) {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "exec spec type %q is not an OCI process", spec.TypeUrl)
+	}
+	// The process fields are inlined in ExecSpec, either type decodes.
+	var p runsctypes.ExecSpec
+	if err := json.Unmarshal(spec.Value, &p); err != nil {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "invalid exec spec: %v", err)
+	}
+	if len(p.Args) == 0 {
+		return errors.Wrap(errdefs.ErrInvalidArgument, "exec spec must have args")
+	}
+	for _, arg := range p.Args {
+		if strings.ContainsRune(arg, 0) {
+			return errors.Wrapf(errdefs.ErrInvalidArgument, "exec arg %q contains a NUL byte", arg)
+		}
+	}
+	for _, env := range p.Env {
+		if strings.ContainsRune(env, 0) || !strings.Contains(env, "=") {
+			return errors.Wrapf(errdefs.ErrInvalidArgument, "exec env %q must be KEY=VALUE", env)
+		}
+	}
+	if strings.ContainsRune(p.Cwd, 0) || !filepath.IsAbs(p.Cwd) {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "exec cwd %q must be absolute", p.Cwd)
+	}
+	if p.MaxRuntime < 0 {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "invalid exec max runtime %v", p.MaxRuntime)
+	}
+	for _, m := range p.Mounts {
+		if strings.ContainsRune(m.Destination, 0) || !filepath.IsAbs(m.Destination) {
+			return errors.Wrapf(errdefs.ErrInvalidArgument, "exec mount destination %q must be absolute", m.Destination)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/typeurl"
+	"github.com/gogo/protobuf/types"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+func marshalAny(t *testing.T, v interface{}) *types.Any {
+	any, err := typeurl.MarshalAny(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return any
+}
+
+func TestCheckExecSpec(t *testing.T) {
+	process := func(edit func(*specs.Process)) *specs.Process {
+		p := &specs.Process{
+			Args: []string{"sh", "-c", "true"},
+			Env:  []string{"PATH=/bin", "EMPTY="},
+			Cwd:  "/",
+		}
+		if edit != nil {
+			edit(p)
+		}
+		return p
+	}
+	processAny := marshalAny(t, process(nil))
+	for _, tc := range []struct {
+		name  string
+		spec  *types.Any
+		valid bool
+	}{
+		{
+			name:  "process",
+			spec:  processAny,
+			valid: true,
+		},
+		{
+			name: "exec spec",
+			spec: marshalAny(t, &runsctypes.ExecSpec{
+				Process:    *process(nil),
+				MaxRuntime: time.Minute,
+				Mounts:     []specs.Mount{{Destination: "/data", Source: "/host/data"}},
+			}),
+			valid: true,
+		},
+		{
+			name: "no spec",
+		},
+		{
+			name: "too large",
+			spec: &types.Any{TypeUrl: processAny.TypeUrl, Value: make([]byte, MaxExecSpecSize+1)},
+		},
+		{
+			name: "unknown type",
+			spec: &types.Any{TypeUrl: "example.com/Process", Value: processAny.Value},
+		},
+		{
+			name: "invalid json",
+			spec: &types.Any{TypeUrl: processAny.TypeUrl, Value: []byte("{")},
+		},
+		{
+			name: "no args",
+			spec: marshalAny(t, process(func(p *specs.Process) { p.Args = nil })),
+		},
+		{
+			name: "NUL in args",
+			spec: marshalAny(t, process(func(p *specs.Process) { p.Args = []string{"sh", "a\x00b"} })),
+		},
+		{
+			name: "NUL in env",
+			spec: marshalAny(t, process(func(p *specs.Process) { p.Env = []string{"A=a\x00b"} })),
+		},
+		{
+			name: "env without value",
+			spec: marshalAny(t, process(func(p *specs.Process) { p.Env = []string{"PATH"} })),
+		},
+		{
+			name: "no cwd",
+			spec: marshalAny(t, process(func(p *specs.Process) { p.Cwd = "" })),
+		},
+		{
+			name: "relative cwd",
+			spec: marshalAny(t, process(func(p *specs.Process) { p.Cwd = "tmp" })),
+		},
+		{
+			name: "NUL in cwd",
+			spec: marshalAny(t, process(func(p *specs.Process) { p.Cwd = "/tmp\x00" })),
+		},
+		{
+			name: "negative max runtime",
+			spec: marshalAny(t, &runsctypes.ExecSpec{Process: *process(nil), MaxRuntime: -time.Second}),
+		},
+		{
+			name: "relative mount destination",
+			spec: marshalAny(t, &runsctypes.ExecSpec{
+				Process: *process(nil),
+				Mounts:  []specs.Mount{{Destination: "data", Source: "/host/data"}},
+			}),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := CheckExecSpec(tc.spec)
+			if tc.valid && err != nil {
+				t.Fatal(err)
+			}
+			if !tc.valid && !errdefs.IsInvalidArgument(err) {
+				t.Fatalf("got %v, want an invalid argument error", err)
+			}
+		})
+	}
+}
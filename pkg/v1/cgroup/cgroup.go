@@ -0,0 +1,112 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cgroup tells which cgroup hierarchy the host runs and manages
+// cgroups of the unified (v2) hierarchy, which the vendored cgroups package
+// predates. Sandbox cgroups are created by runsc, except on unified hosts
+// where the shim creates them; the shim reads their metrics, places its own
+// process and reports the mode in use.
+package cgroup
+
+import (
+	"strings"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// Root is where the cgroup hierarchies are mounted.
+var Root = "/sys/fs/cgroup"
+
+// Mode is the cgroup hierarchy setup of the host.
+type Mode string
+
+const (
+	// Legacy hosts only mount the v1 hierarchies.
+	Legacy Mode = "v1"
+	// Hybrid hosts mount the v1 hierarchies, and the unified hierarchy
+	// without controllers under unified/.
+	Hybrid Mode = "hybrid"
+	// Unified hosts only mount the v2 hierarchy.
+	Unified Mode = "v2"
+)
+
+// DetectMode returns the cgroup mode of the host from the filesystem mounted
+// at Root.
+func DetectMode() Mode {
+	var st unix.Statfs_t
+	if err := unix.Statfs(Root, &st); err != nil {
+		return Legacy
+	}
+	if st.Type == unix.CGROUP2_SUPER_MAGIC {
+		return Unified
+	}
+	if err := unix.Statfs(Root+"/unified", &st); err == nil && st.Type == unix.CGROUP2_SUPER_MAGIC {
+		return Hybrid
+	}
+	return Legacy
+}
+
+// Driver is how the cgroups of a container are named and created.
+type Driver string
+
+const (
+	// Cgroupfs drivers use cgroupsPath as a path in the hierarchy.
+	Cgroupfs Driver = "cgroupfs"
+	// Systemd drivers use cgroupsPath as "slice:prefix:name", the unit the
+	// container is placed in.
+	Systemd Driver = "systemd"
+)
+
+// SpecDriver returns the driver the cgroupsPath of spec is meant for.
+func SpecDriver(spec *specs.Spec) Driver {
+	if spec.Linux == nil {
+		return Cgroupfs
+	}
+	return PathDriver(spec.Linux.CgroupsPath)
+}
+
+// PathDriver returns the driver cgroupsPath is meant for. Systemd paths are
+// the only ones with a colon and without a leading slash, e.g.
+// "kubepods-besteffort.slice:cri-containerd:<id>".
+func PathDriver(cgroupsPath string) Driver {
+	if !strings.HasPrefix(cgroupsPath, "/") && strings.Count(cgroupsPath, ":") == 2 {
+		return Systemd
+	}
+	return Cgroupfs
+}
+
+// SystemdFlag is the runsc flag making runsc create the cgroups of the
+// sandbox through systemd.
+const SystemdFlag = "systemd-cgroup"
+
+// Configure returns the runsc flags of config with the systemd cgroup flag
+// set when the cgroupsPath of spec is a systemd one, unless it was set
+// explicitly.
+func Configure(config map[string]string, spec *specs.Spec) map[string]string {
+	if SpecDriver(spec) != Systemd {
+		return config
+	}
+	if _, ok := config[SystemdFlag]; ok {
+		return config
+	}
+	flags := make(map[string]string, len(config)+1)
+	for k, v := range config {
+		flags[k] = v
+	}
+	flags[SystemdFlag] = "true"
+	return flags
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cgroup
+
+import (
+	"path"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	systemdDbus "github.com/coreos/go-systemd/dbus"
+	"github.com/godbus/dbus"
+	"github.com/pkg/errors"
+)
+
+// defaultSlice is the slice of systemd paths that don't name one.
+const defaultSlice = "system.slice"
+
+// Scope is the transient systemd scope of a sandbox, for runsc releases that
+// can't create it themselves.
+type Scope struct {
+	// Slice is the slice the scope is in, e.g. "kubepods-besteffort.slice".
+	Slice string
+	// Unit is the name of the scope, e.g. "cri-containerd-<id>.scope".
+	Unit string
+}
+
+// ParseSystemdPath returns the scope named by the systemd cgroupsPath
+// "slice:prefix:name".
+func ParseSystemdPath(cgroupsPath string) (*Scope, error) {
+	if PathDriver(cgroupsPath) != Systemd {
+		return nil, errors.Wrapf(errdefs.ErrInvalidArgument, "%q is not a systemd cgroups path", cgroupsPath)
+	}
+	parts := strings.Split(cgroupsPath, ":")
+	slice, prefix, name := parts[0], parts[1], parts[2]
+	if slice == "" {
+		slice = defaultSlice
+	}
+	if !strings.HasSuffix(slice, ".slice") || name == "" {
+		return nil, errors.Wrapf(errdefs.ErrInvalidArgument, "invalid systemd cgroups path %q", cgroupsPath)
+	}
+	unit := name
+	if prefix != "" {
+		unit = prefix + "-" + name
+	}
+	return &Scope{Slice: slice, Unit: unit + ".scope"}, nil
+}
+
+// Path returns the path of the cgroup of the scope in the hierarchy, where
+// systemd nests slices by the dashes of their names, e.g.
+// "/kubepods.slice/kubepods-besteffort.slice/cri-containerd-<id>.scope".
+func (s *Scope) Path() string {
+	p := "/"
+	name := strings.TrimSuffix(s.Slice, ".slice")
+	if name != "-" {
+		var prefix string
+		for _, part := range strings.Split(name, "-") {
+			prefix += part
+			p = path.Join(p, prefix+".slice")
+			prefix += "-"
+		}
+	}
+	return path.Join(p, s.Unit)
+}
+
+// Start creates the scope with pids through the systemd dbus API. The
+// cgroup is delegated, so systemd leaves its controllers to runsc.
+func (s *Scope) Start(pids []int) error {
+	conn, err := systemdDbus.New()
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to systemd")
+	}
+	defer conn.Close()
+	upids := make([]uint32, 0, len(pids))
+	for _, pid := range pids {
+		upids = append(upids, uint32(pid))
+	}
+	properties := []systemdDbus.Property{
+		systemdDbus.PropDescription("gVisor sandbox " + s.Unit),
+		systemdDbus.PropSlice(s.Slice),
+		systemdDbus.PropPids(upids...),
+		newProperty("DefaultDependencies", false),
+		newProperty("Delegate", true),
+		newProperty("MemoryAccounting", true),
+		newProperty("CPUAccounting", true),
+		newProperty("TasksAccounting", true),
+	}
+	ch := make(chan string, 1)
+	if _, err := conn.StartTransientUnit(s.Unit, "replace", properties, ch); err != nil {
+		return errors.Wrapf(err, "failed to start scope %s", s.Unit)
+	}
+	if result := <-ch; result != "done" {
+		return errors.Errorf("failed to start scope %s: job %s", s.Unit, result)
+	}
+	return nil
+}
+
+// Stop stops the scope, killing the processes left in it.
+func (s *Scope) Stop() error {
+	conn, err := systemdDbus.New()
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to systemd")
+	}
+	defer conn.Close()
+	ch := make(chan string, 1)
+	if _, err := conn.StopUnit(s.Unit, "replace", ch); err != nil {
+		if dbusErr, ok := err.(dbus.Error); ok && dbusErr.Name == "org.freedesktop.systemd1.NoSuchUnit" {
+			return nil
+		}
+		return errors.Wrapf(err, "failed to stop scope %s", s.Unit)
+	}
+	<-ch
+	return nil
+}
+
+func newProperty(name string, value interface{}) systemdDbus.Property {
+	return systemdDbus.Property{
+		Name:  name,
+		Value: dbus.MakeVariant(value),
+	}
+}
@@ -0,0 +1,366 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cgroup
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/cgroups"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+)
+
+// defaultCPUPeriod is the cpu.max period when the spec sets none.
+const defaultCPUPeriod = 100000
+
+// Resources translates the resources of a spec, expressed for the v1
+// controllers, to the files of the v2 controllers, e.g. memory.max,
+// cpu.max and pids.max, following runc.
+func Resources(r *specs.LinuxResources) map[string]string {
+	files := make(map[string]string)
+	if r == nil {
+		return files
+	}
+	if m := r.Memory; m != nil {
+		if m.Limit != nil {
+			files["memory.max"] = limit(*m.Limit)
+		}
+		if m.Reservation != nil && *m.Reservation > 0 {
+			files["memory.low"] = strconv.FormatInt(*m.Reservation, 10)
+		}
+		// v1 limits memory and swap together, v2 limits swap alone.
+		if m.Swap != nil {
+			switch {
+			case *m.Swap < 0:
+				files["memory.swap.max"] = "max"
+			case m.Limit != nil && *m.Limit > 0 && *m.Swap >= *m.Limit:
+				files["memory.swap.max"] = strconv.FormatInt(*m.Swap-*m.Limit, 10)
+			}
+		}
+	}
+	if c := r.CPU; c != nil {
+		if c.Quota != nil || c.Period != nil {
+			quota := "max"
+			if c.Quota != nil && *c.Quota > 0 {
+				quota = strconv.FormatInt(*c.Quota, 10)
+			}
+			period := uint64(defaultCPUPeriod)
+			if c.Period != nil && *c.Period != 0 {
+				period = *c.Period
+			}
+			files["cpu.max"] = fmt.Sprintf("%s %d", quota, period)
+		}
+		if c.Shares != nil && *c.Shares != 0 {
+			files["cpu.weight"] = strconv.FormatUint(sharesToWeight(*c.Shares), 10)
+		}
+		if c.Cpus != "" {
+			files["cpuset.cpus"] = c.Cpus
+		}
+		if c.Mems != "" {
+			files["cpuset.mems"] = c.Mems
+		}
+	}
+	if p := r.Pids; p != nil {
+		files["pids.max"] = limit(p.Limit)
+	}
+	return files
+}
+
+// limit formats a v1 limit, where zero and negative values are unlimited.
+func limit(v int64) string {
+	if v <= 0 {
+		return "max"
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+// sharesToWeight maps cpu.shares, [2, 262144], to cpu.weight, [1, 10000].
+func sharesToWeight(shares uint64) uint64 {
+	if shares < 2 {
+		shares = 2
+	}
+	if shares > 262144 {
+		shares = 262144
+	}
+	return 1 + ((shares-2)*9999)/262142
+}
+
+// controllers returns the controllers the files belong to, sorted.
+func controllers(files map[string]string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for f := range files {
+		c := strings.SplitN(f, ".", 2)[0]
+		if !seen[c] {
+			seen[c] = true
+			out = append(out, c)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// V2 is a cgroup of the unified hierarchy.
+type V2 struct {
+	// path is the path of the cgroup in the hierarchy, e.g. "/shims/shim-1".
+	path string
+}
+
+// NewV2 creates the cgroup at path in the unified hierarchy, enabling the
+// controllers of resources in its ancestors, and sets resources.
+func NewV2(path string, resources *specs.LinuxResources) (*V2, error) {
+	if !filepath.IsAbs(path) {
+		return nil, errors.Errorf("cgroup path %q must be absolute", path)
+	}
+	files := Resources(resources)
+	if err := enableControllers(path, controllers(files)); err != nil {
+		return nil, err
+	}
+	c := &V2{path: path}
+	if err := os.MkdirAll(c.dir(), 0755); err != nil {
+		return nil, errors.Wrapf(err, "failed to create cgroup %s", path)
+	}
+	if err := c.write(files); err != nil {
+		c.Delete()
+		return nil, err
+	}
+	return c, nil
+}
+
+// LoadV2 returns the existing cgroup at path in the unified hierarchy.
+func LoadV2(path string) (*V2, error) {
+	c := &V2{path: path}
+	if _, err := os.Stat(c.dir()); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// PidPath returns the path of the cgroup of pid in the unified hierarchy.
+func PidPath(pid int) (string, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return "", err
+	}
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		if strings.HasPrefix(s.Text(), "0::") {
+			return strings.TrimPrefix(s.Text(), "0::"), nil
+		}
+	}
+	return "", errors.Errorf("process %d is not in the unified hierarchy", pid)
+}
+
+// Path returns the path of the cgroup in the hierarchy.
+func (c *V2) Path() string {
+	return c.path
+}
+
+func (c *V2) dir() string {
+	return filepath.Join(Root, c.path)
+}
+
+// Add moves pid to the cgroup.
+func (c *V2) Add(pid int) error {
+	return c.write(map[string]string{"cgroup.procs": strconv.Itoa(pid)})
+}
+
+// Set updates the resources of the cgroup.
+func (c *V2) Set(resources *specs.LinuxResources) error {
+	return c.write(Resources(resources))
+}
+
+// Delete removes the cgroup, which must have no processes left.
+func (c *V2) Delete() error {
+	if err := os.Remove(c.dir()); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to remove cgroup %s", c.path)
+	}
+	return nil
+}
+
+func (c *V2) write(files map[string]string) error {
+	for name, v := range files {
+		if err := ioutil.WriteFile(filepath.Join(c.dir(), name), []byte(v), 0); err != nil {
+			return errors.Wrapf(err, "failed to set %s of cgroup %s to %q", name, c.path, v)
+		}
+	}
+	return nil
+}
+
+// enableControllers enables controllers in the subtree_control of the
+// ancestors of path, so that its files exist.
+func enableControllers(path string, controllers []string) error {
+	if len(controllers) == 0 {
+		return nil
+	}
+	var enable []string
+	for _, c := range controllers {
+		enable = append(enable, "+"+c)
+	}
+	dir := Root
+	for _, elem := range strings.Split(strings.Trim(path, "/"), "/") {
+		if err := ioutil.WriteFile(filepath.Join(dir, "cgroup.subtree_control"), []byte(strings.Join(enable, " ")), 0); err != nil {
+			return errors.Wrapf(err, "failed to enable controllers %s in cgroup %s", strings.Join(controllers, ","), strings.TrimPrefix(dir, Root))
+		}
+		dir = filepath.Join(dir, elem)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return errors.Wrapf(err, "failed to create cgroup %s", strings.TrimPrefix(dir, Root))
+		}
+	}
+	return nil
+}
+
+// Stat returns the metrics of the cgroup, in the form of the v1 metrics
+// that containerd expects. Files of controllers that aren't enabled are
+// reported to the error handlers, e.g. cgroups.IgnoreNotExist.
+func (c *V2) Stat(handlers ...cgroups.ErrorHandler) (*cgroups.Metrics, error) {
+	handle := func(err error) error {
+		for _, h := range handlers {
+			if err = h(err); err == nil {
+				return nil
+			}
+		}
+		return err
+	}
+	m := &cgroups.Metrics{}
+	if err := c.statPids(m); err != nil {
+		if err := handle(err); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.statCPU(m); err != nil {
+		if err := handle(err); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.statMemory(m); err != nil {
+		if err := handle(err); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (c *V2) statPids(m *cgroups.Metrics) error {
+	current, err := c.readUint("pids.current")
+	if err != nil {
+		return err
+	}
+	max, err := c.readUint("pids.max")
+	if err != nil {
+		return err
+	}
+	m.Pids = &cgroups.PidsStat{Current: current, Limit: max}
+	return nil
+}
+
+func (c *V2) statCPU(m *cgroups.Metrics) error {
+	kv, err := c.readKeyValues("cpu.stat")
+	if err != nil {
+		return err
+	}
+	// cpu.stat is in microseconds, the v1 metrics in nanoseconds.
+	m.CPU = &cgroups.CPUStat{
+		Usage: &cgroups.CPUUsage{
+			Total:  kv["usage_usec"] * 1000,
+			User:   kv["user_usec"] * 1000,
+			Kernel: kv["system_usec"] * 1000,
+		},
+		Throttling: &cgroups.Throttle{
+			Periods:          kv["nr_periods"],
+			ThrottledPeriods: kv["nr_throttled"],
+			ThrottledTime:    kv["throttled_usec"] * 1000,
+		},
+	}
+	return nil
+}
+
+func (c *V2) statMemory(m *cgroups.Metrics) error {
+	usage, err := c.readUint("memory.current")
+	if err != nil {
+		return err
+	}
+	max, err := c.readUint("memory.max")
+	if err != nil {
+		return err
+	}
+	kv, err := c.readKeyValues("memory.stat")
+	if err != nil {
+		return err
+	}
+	m.Memory = &cgroups.MemoryStat{
+		Cache:        kv["file"],
+		RSS:          kv["anon"],
+		MappedFile:   kv["file_mapped"],
+		Dirty:        kv["file_dirty"],
+		Writeback:    kv["file_writeback"],
+		PgFault:      kv["pgfault"],
+		PgMajFault:   kv["pgmajfault"],
+		InactiveAnon: kv["inactive_anon"],
+		ActiveAnon:   kv["active_anon"],
+		InactiveFile: kv["inactive_file"],
+		ActiveFile:   kv["active_file"],
+		Unevictable:  kv["unevictable"],
+		Usage:        &cgroups.MemoryEntry{Usage: usage, Limit: max},
+	}
+	if swap, err := c.readUint("memory.swap.current"); err == nil {
+		swapMax, _ := c.readUint("memory.swap.max")
+		m.Memory.Swap = &cgroups.MemoryEntry{Usage: swap, Limit: swapMax}
+	}
+	return nil
+}
+
+// readUint reads a single value file, where "max" is reported as the
+// largest value like the v1 unlimited limits.
+func (c *V2) readUint(name string) (uint64, error) {
+	data, err := ioutil.ReadFile(filepath.Join(c.dir(), name))
+	if err != nil {
+		return 0, err
+	}
+	v := strings.TrimSpace(string(data))
+	if v == "max" {
+		return ^uint64(0), nil
+	}
+	return strconv.ParseUint(v, 10, 64)
+}
+
+// readKeyValues reads a flat keyed file such as cpu.stat.
+func (c *V2) readKeyValues(name string) (map[string]uint64, error) {
+	data, err := ioutil.ReadFile(filepath.Join(c.dir(), name))
+	if err != nil {
+		return nil, err
+	}
+	kv := make(map[string]uint64)
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if v, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			kv[fields[0]] = v
+		}
+	}
+	return kv, nil
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package checkpoint stores the checkpoints of runsc in a layout that can be
+// copied to another node and restored there:
+//
+//	<dir>/manifest.json	the image files, their checksums and the runsc
+//				release that took the checkpoint
+//	<dir>/image/		the image files written by runsc checkpoint,
+//				compressed
+//
+// runsc only restores images taken by the same release, which Unpack checks
+// against the manifest before it decompresses the image.
+package checkpoint
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/pkg/errors"
+)
+
+const (
+	// ManifestFile is the name of the manifest in a checkpoint.
+	ManifestFile = "manifest.json"
+	// ImageDir is the directory of the image files in a checkpoint.
+	ImageDir = "image"
+	// FormatVersion is the version of the layout written by Pack.
+	FormatVersion = 1
+)
+
+// File is an image file of a checkpoint.
+type File struct {
+	// Name is the path of the file written by runsc, relative to the image
+	// directory. The stored file has the extension of the compression.
+	Name string `json:"name"`
+	// Size is the uncompressed size of the file.
+	Size int64 `json:"size"`
+	// SHA256 is the digest of the stored, compressed, file.
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest describes a checkpoint.
+type Manifest struct {
+	Version     int       `json:"version"`
+	ContainerID string    `json:"container_id"`
+	CreatedAt   time.Time `json:"created_at"`
+	// Runsc is the version of the runsc that took the checkpoint, as
+	// reported by runsc --version.
+	Runsc string `json:"runsc"`
+	// Shim is the version of the shim that took the checkpoint.
+	Shim        string      `json:"shim"`
+	Compression Compression `json:"compression"`
+	Files       []File      `json:"files"`
+}
+
+// Pack stores the image written by runsc in raw as a checkpoint in dir,
+// compressed with m.Compression, and writes the manifest m completed with
+// the files of the image.
+func Pack(raw, dir string, m Manifest) (*Manifest, error) {
+	if m.Compression == "" {
+		m.Compression = None
+	}
+	if err := m.Compression.Validate(); err != nil {
+		return nil, err
+	}
+	m.Version = FormatVersion
+	m.Files = nil
+	image := filepath.Join(dir, ImageDir)
+	if err := os.MkdirAll(image, 0700); err != nil {
+		return nil, errors.Wrap(err, "create checkpoint image directory")
+	}
+	err := filepath.Walk(raw, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return err
+		}
+		name, err := filepath.Rel(raw, path)
+		if err != nil {
+			return err
+		}
+		f, err := packFile(path, filepath.Join(image, name+m.Compression.ext()), m.Compression)
+		if err != nil {
+			return errors.Wrapf(err, "failed to store image file %s", name)
+		}
+		f.Name = filepath.ToSlash(name)
+		m.Files = append(m.Files, *f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Files) == 0 {
+		return nil, errors.Errorf("runsc wrote no image files to %s", raw)
+	}
+	data, err := json.MarshalIndent(&m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	// The manifest is written last: a checkpoint without one is incomplete.
+	if err := ioutil.WriteFile(filepath.Join(dir, ManifestFile), data, 0600); err != nil {
+		return nil, errors.Wrap(err, "write checkpoint manifest")
+	}
+	return &m, nil
+}
+
+func packFile(src, dst string, c Compression) (*File, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return nil, err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+	h := sha256.New()
+	n, err := compress(c, io.MultiWriter(out, h), in)
+	if err != nil {
+		return nil, err
+	}
+	if err := out.Close(); err != nil {
+		return nil, err
+	}
+	return &File{
+		Size:   n,
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// ReadManifest reads the manifest of the checkpoint in dir.
+func ReadManifest(dir string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.Wrapf(errdefs.ErrInvalidArgument, "%s has no checkpoint manifest", dir)
+		}
+		return nil, errors.Wrap(err, "read checkpoint manifest")
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, errors.Wrapf(errdefs.ErrInvalidArgument, "invalid checkpoint manifest: %v", err)
+	}
+	if m.Version < 1 || m.Version > FormatVersion {
+		return nil, errors.Wrapf(errdefs.ErrInvalidArgument, "unsupported checkpoint version %d", m.Version)
+	}
+	if err := m.Compression.Validate(); err != nil {
+		return nil, errors.Wrap(errdefs.ErrInvalidArgument, err.Error())
+	}
+	for _, f := range m.Files {
+		if name := filepath.Clean(filepath.FromSlash(f.Name)); filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return nil, errors.Wrapf(errdefs.ErrInvalidArgument, "checkpoint file %q is outside of the image", f.Name)
+		}
+	}
+	return &m, nil
+}
+
+// CheckRunsc checks that the runsc of version, as reported by runsc
+// --version, can restore the checkpoint.
+func (m *Manifest) CheckRunsc(version string) error {
+	if m.Runsc != version {
+		return errors.Wrapf(errdefs.ErrFailedPrecondition, "checkpoint was taken by runsc %q and can't be restored by runsc %q", m.Runsc, version)
+	}
+	return nil
+}
+
+// Unpack checks that the checkpoint in dir can be restored by the runsc of
+// version and decompresses its image into raw, verifying the checksums of
+// the image files.
+func Unpack(dir, raw, version string) (*Manifest, error) {
+	m, err := ReadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.CheckRunsc(version); err != nil {
+		return nil, err
+	}
+	for _, f := range m.Files {
+		name := filepath.FromSlash(f.Name)
+		src := filepath.Join(dir, ImageDir, name+m.Compression.ext())
+		if err := unpackFile(src, filepath.Join(raw, name), m.Compression, f); err != nil {
+			return nil, errors.Wrapf(err, "failed to restore image file %s", f.Name)
+		}
+	}
+	return m, nil
+}
+
+func unpackFile(src, dst string, c Compression, f File) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	// The file is checked before it is decompressed, so that a corrupted
+	// image is reported as such whatever its compression.
+	h := sha256.New()
+	if _, err := io.Copy(h, in); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != f.SHA256 {
+		return errors.Wrapf(errdefs.ErrFailedPrecondition, "checksum mismatch, expected sha256 %s, got %s", f.SHA256, sum)
+	}
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	n, err := decompress(c, out, in)
+	if err != nil {
+		return err
+	}
+	if n != f.Size {
+		return errors.Wrapf(errdefs.ErrFailedPrecondition, "size mismatch, expected %d bytes, got %d", f.Size, n)
+	}
+	return out.Close()
+}
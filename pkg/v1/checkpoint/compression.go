@@ -0,0 +1,141 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package checkpoint
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Compression is the compression of the image files of a checkpoint.
+type Compression string
+
+const (
+	// None stores the image files as written by runsc.
+	None Compression = "none"
+	// Gzip compresses the image files with gzip.
+	Gzip Compression = "gzip"
+	// Zstd compresses the image files with zstd, which is faster than gzip
+	// at a similar ratio. It requires the zstd binary in PATH on the nodes
+	// taking and restoring the checkpoint.
+	Zstd Compression = "zstd"
+)
+
+// zstdBinary is the binary zstd images are compressed with.
+const zstdBinary = "zstd"
+
+// Validate checks that the compression is known and can be used.
+func (c Compression) Validate() error {
+	switch c {
+	case None, Gzip:
+		return nil
+	case Zstd:
+		if _, err := exec.LookPath(zstdBinary); err != nil {
+			return errors.Wrap(err, "zstd checkpoint compression requires the zstd binary")
+		}
+		return nil
+	}
+	return errors.Errorf("unknown checkpoint compression %q, expected none, gzip or zstd", c)
+}
+
+// ext is the extension of the stored image files.
+func (c Compression) ext() string {
+	switch c {
+	case Gzip:
+		return ".gz"
+	case Zstd:
+		return ".zst"
+	}
+	return ""
+}
+
+// compress copies src to dst compressed with c, and returns the number of
+// bytes read from src.
+func compress(c Compression, dst io.Writer, src io.Reader) (int64, error) {
+	switch c {
+	case Gzip:
+		w := gzip.NewWriter(dst)
+		n, err := io.Copy(w, src)
+		if err != nil {
+			return n, err
+		}
+		return n, w.Close()
+	case Zstd:
+		r := &countingReader{r: src}
+		err := runZstd(dst, r, "-q", "-c")
+		return r.n, err
+	}
+	return io.Copy(dst, src)
+}
+
+// decompress copies src to dst decompressed with c, and returns the number
+// of bytes written to dst.
+func decompress(c Compression, dst io.Writer, src io.Reader) (int64, error) {
+	switch c {
+	case Gzip:
+		r, err := gzip.NewReader(src)
+		if err != nil {
+			return 0, err
+		}
+		defer r.Close()
+		return io.Copy(dst, r)
+	case Zstd:
+		w := &countingWriter{w: dst}
+		err := runZstd(w, src, "-q", "-d", "-c")
+		return w.n, err
+	}
+	return io.Copy(dst, src)
+}
+
+func runZstd(dst io.Writer, src io.Reader, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command(zstdBinary, args...)
+	cmd.Stdin = src
+	cmd.Stdout = dst
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(err, "zstd: %s", strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
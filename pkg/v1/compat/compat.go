@@ -0,0 +1,272 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package compat checks OCI specs for features gVisor does not support.
+package compat
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/devices"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/utils"
+)
+
+// Severity of an incompatibility.
+type Severity int
+
+const (
+	// Warning is used for features that are ignored by gVisor.
+	Warning Severity = iota
+	// Error is used for features that prevent the container from running
+	// as specified.
+	Error
+)
+
+func (s Severity) String() string {
+	if s == Error {
+		return "error"
+	}
+	return "warning"
+}
+
+// Incompatibility is a spec feature that gVisor does not support.
+type Incompatibility struct {
+	// Field is the path of the spec field, e.g. "linux.devices[0]".
+	Field    string
+	Message  string
+	Severity Severity
+}
+
+func (i Incompatibility) String() string {
+	return fmt.Sprintf("%s: %s", i.Field, i.Message)
+}
+
+// Report lists the incompatibilities found in a spec.
+type Report struct {
+	Incompatibilities []Incompatibility
+}
+
+func (r *Report) add(sev Severity, field, format string, args ...interface{}) {
+	r.Incompatibilities = append(r.Incompatibilities, Incompatibility{
+		Field:    field,
+		Message:  fmt.Sprintf(format, args...),
+		Severity: sev,
+	})
+}
+
+// Filter returns the incompatibilities of the given severity.
+func (r *Report) Filter(sev Severity) []Incompatibility {
+	var out []Incompatibility
+	for _, i := range r.Incompatibilities {
+		if i.Severity == sev {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// Err returns an InvalidArgument error listing all incompatibilities of
+// Error severity, or nil if there are none.
+func (r *Report) Err() error {
+	errs := r.Filter(Error)
+	if len(errs) == 0 {
+		return nil
+	}
+	msgs := make([]string, 0, len(errs))
+	for _, i := range errs {
+		msgs = append(msgs, i.String())
+	}
+	return errors.Wrapf(errdefs.ErrInvalidArgument, "spec is not compatible with gVisor: %s", strings.Join(msgs, "; "))
+}
+
+// Validate checks the spec, logs warnings and returns the error of the
+// report, if any.
+func Validate(ctx context.Context, spec *specs.Spec) error {
+	r := Check(spec)
+	for _, i := range r.Filter(Warning) {
+		log.G(ctx).WithField("field", i.Field).Warn(i.Message)
+	}
+	return r.Err()
+}
+
+// Check returns the incompatibilities of the spec with gVisor.
+func Check(spec *specs.Spec) *Report {
+	r := &Report{}
+	if p := spec.Process; p != nil {
+		if p.ApparmorProfile != "" {
+			r.add(Warning, "process.apparmorProfile", "AppArmor profile %q is not applied inside the sandbox", p.ApparmorProfile)
+		}
+		if p.SelinuxLabel != "" {
+			r.add(Warning, "process.selinuxLabel", "SELinux label %q is not applied inside the sandbox", p.SelinuxLabel)
+		}
+	}
+	if l := spec.Linux; l != nil {
+		if l.MountLabel != "" {
+			r.add(Warning, "linux.mountLabel", "SELinux mount label %q is not applied inside the sandbox", l.MountLabel)
+		}
+		checkDevices(r, l.Devices)
+		if l.Resources != nil {
+			checkDeviceCgroup(r, l.Resources.Devices)
+		}
+		checkSeccomp(r, l.Seccomp)
+	}
+	checkMountHints(r, spec.Annotations)
+	return r
+}
+
+type device struct {
+	major, minor int64
+}
+
+// supportedDevices are the character devices the sentry implements.
+var supportedDevices = map[device]string{
+	{1, 3}:    "/dev/null",
+	{1, 5}:    "/dev/zero",
+	{1, 7}:    "/dev/full",
+	{1, 8}:    "/dev/random",
+	{1, 9}:    "/dev/urandom",
+	{5, 0}:    "/dev/tty",
+	{5, 1}:    "/dev/console",
+	{5, 2}:    "/dev/ptmx",
+	{10, 200}: "/dev/net/tun",
+	{10, 229}: "/dev/fuse",
+}
+
+// ptsMajor is the major number of pseudo terminal slaves.
+const ptsMajor = 136
+
+func supported(major, minor int64) bool {
+	if major == ptsMajor {
+		return true
+	}
+	// Devices of a passthrough class are checked on the host by the devices
+	// package.
+	if devices.Lookup(major, minor) != nil {
+		return true
+	}
+	_, ok := supportedDevices[device{major, minor}]
+	return ok
+}
+
+func checkDevices(r *Report, devices []specs.LinuxDevice) {
+	for i, d := range devices {
+		field := fmt.Sprintf("linux.devices[%d]", i)
+		if d.Type != "c" && d.Type != "u" {
+			r.add(Error, field, "device %q of type %q is not supported", d.Path, d.Type)
+			continue
+		}
+		if !supported(d.Major, d.Minor) {
+			r.add(Error, field, "device %q (%d:%d) is not supported", d.Path, d.Major, d.Minor)
+		}
+	}
+}
+
+func checkDeviceCgroup(r *Report, rules []specs.LinuxDeviceCgroup) {
+	for i, d := range rules {
+		if !d.Allow {
+			continue
+		}
+		field := fmt.Sprintf("linux.resources.devices[%d]", i)
+		if d.Major == nil || d.Minor == nil || *d.Minor < 0 {
+			if d.Major == nil || *d.Major != ptsMajor {
+				r.add(Warning, field, "wildcard device rule is not enforced inside the sandbox")
+			}
+			continue
+		}
+		if !supported(*d.Major, *d.Minor) {
+			r.add(Warning, field, "device rule for %d:%d has no effect, the device is not supported", *d.Major, *d.Minor)
+		}
+	}
+}
+
+// supportedSeccompActions are the seccomp actions the sentry implements.
+var supportedSeccompActions = map[specs.LinuxSeccompAction]bool{
+	specs.ActKill:           true,
+	specs.ActTrap:           true,
+	specs.ActErrno:          true,
+	specs.ActAllow:          true,
+	"SCMP_ACT_KILL_PROCESS": true,
+	"SCMP_ACT_KILL_THREAD":  true,
+	"SCMP_ACT_LOG":          true,
+}
+
+func checkSeccomp(r *Report, s *specs.LinuxSeccomp) {
+	if s == nil {
+		return
+	}
+	if !supportedSeccompActions[s.DefaultAction] {
+		r.add(Error, "linux.seccomp.defaultAction", "seccomp action %q is not supported", s.DefaultAction)
+	}
+	for i, sc := range s.Syscalls {
+		if !supportedSeccompActions[sc.Action] {
+			r.add(Error, fmt.Sprintf("linux.seccomp.syscalls[%d]", i), "seccomp action %q for %s is not supported", sc.Action, strings.Join(sc.Names, ","))
+		}
+	}
+}
+
+// mountHintValues are the values accepted by the mount hint fields. A nil
+// list accepts any value.
+var mountHintValues = map[string][]string{
+	"source":  nil,
+	"options": nil,
+	"type":    {"bind", "tmpfs"},
+	"share":   {"container", "pod", "shared"},
+}
+
+func checkMountHints(r *Report, annotations map[string]string) {
+	var keys []string
+	for k := range annotations {
+		if strings.HasPrefix(k, utils.MountHintPrefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := annotations[k]
+		field := fmt.Sprintf("annotations[%q]", k)
+		hint := strings.TrimPrefix(k, utils.MountHintPrefix)
+		i := strings.LastIndex(hint, ".")
+		if i <= 0 {
+			r.add(Error, field, "mount hint has no volume name")
+			continue
+		}
+		values, ok := mountHintValues[hint[i+1:]]
+		if !ok {
+			r.add(Warning, field, "unknown mount hint %q is ignored", hint[i+1:])
+			continue
+		}
+		if values != nil && !contains(values, v) {
+			r.add(Error, field, "invalid mount hint value %q, expected one of %s", v, strings.Join(values, ", "))
+		}
+	}
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
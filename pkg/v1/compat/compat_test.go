@@ -0,0 +1,163 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compat
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/utils"
+)
+
+func int64p(v int64) *int64 {
+	return &v
+}
+
+// fields returns the fields of the incompatibilities of r with severity sev.
+func fields(r *Report, sev Severity) []string {
+	var out []string
+	for _, i := range r.Filter(sev) {
+		out = append(out, i.Field)
+	}
+	return out
+}
+
+func TestCheck(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		spec     specs.Spec
+		errors   []string
+		warnings []string
+	}{
+		{
+			name: "compatible",
+			spec: specs.Spec{
+				Process: &specs.Process{Args: []string{"sh"}},
+				Linux: &specs.Linux{
+					Devices: []specs.LinuxDevice{
+						{Path: "/dev/null", Type: "c", Major: 1, Minor: 3},
+						{Path: "/dev/pts/0", Type: "c", Major: ptsMajor, Minor: 0},
+					},
+					Seccomp: &specs.LinuxSeccomp{
+						DefaultAction: specs.ActErrno,
+						Syscalls:      []specs.LinuxSyscall{{Names: []string{"ptrace"}, Action: "SCMP_ACT_KILL_PROCESS"}},
+					},
+				},
+				Annotations: map[string]string{
+					utils.MountHintPrefix + "data.type":  "tmpfs",
+					utils.MountHintPrefix + "data.share": "pod",
+				},
+			},
+		},
+		{
+			name: "security labels",
+			spec: specs.Spec{
+				Process: &specs.Process{ApparmorProfile: "runtime/default", SelinuxLabel: "system_u"},
+				Linux:   &specs.Linux{MountLabel: "system_u"},
+			},
+			warnings: []string{"process.apparmorProfile", "process.selinuxLabel", "linux.mountLabel"},
+		},
+		{
+			name: "devices",
+			spec: specs.Spec{
+				Linux: &specs.Linux{
+					Devices: []specs.LinuxDevice{
+						{Path: "/dev/null", Type: "c", Major: 1, Minor: 3},
+						{Path: "/dev/sda", Type: "b", Major: 8},
+						{Path: "/dev/kmsg", Type: "c", Major: 1, Minor: 11},
+					},
+				},
+			},
+			errors: []string{"linux.devices[1]", "linux.devices[2]"},
+		},
+		{
+			name: "device cgroup",
+			spec: specs.Spec{
+				Linux: &specs.Linux{
+					Resources: &specs.LinuxResources{
+						Devices: []specs.LinuxDeviceCgroup{
+							{Allow: false},
+							{Allow: true},
+							{Allow: true, Major: int64p(ptsMajor)},
+							{Allow: true, Major: int64p(1), Minor: int64p(3)},
+							{Allow: true, Major: int64p(1), Minor: int64p(11)},
+						},
+					},
+				},
+			},
+			warnings: []string{"linux.resources.devices[1]", "linux.resources.devices[4]"},
+		},
+		{
+			name: "seccomp actions",
+			spec: specs.Spec{
+				Linux: &specs.Linux{
+					Seccomp: &specs.LinuxSeccomp{
+						DefaultAction: "SCMP_ACT_TRACE",
+						Syscalls: []specs.LinuxSyscall{
+							{Names: []string{"read"}, Action: specs.ActAllow},
+							{Names: []string{"ptrace"}, Action: "SCMP_ACT_NOTIFY"},
+						},
+					},
+				},
+			},
+			errors: []string{"linux.seccomp.defaultAction", "linux.seccomp.syscalls[1]"},
+		},
+		{
+			name: "mount hints",
+			spec: specs.Spec{
+				Annotations: map[string]string{
+					utils.MountHintPrefix + "a.type":    "overlay",
+					utils.MountHintPrefix + "b.share":   "host",
+					utils.MountHintPrefix + "c.source":  "/data",
+					utils.MountHintPrefix + "d.unknown": "x",
+					utils.MountHintPrefix + "type":      "bind",
+				},
+			},
+			errors:   []string{`annotations["dev.gvisor.spec.mount.a.type"]`, `annotations["dev.gvisor.spec.mount.b.share"]`, `annotations["dev.gvisor.spec.mount.type"]`},
+			warnings: []string{`annotations["dev.gvisor.spec.mount.d.unknown"]`},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := Check(&tc.spec)
+			if got := fields(r, Error); !reflect.DeepEqual(got, tc.errors) {
+				t.Errorf("errors: got %q, want %q", got, tc.errors)
+			}
+			if got := fields(r, Warning); !reflect.DeepEqual(got, tc.warnings) {
+				t.Errorf("warnings: got %q, want %q", got, tc.warnings)
+			}
+			err := r.Err()
+			if len(tc.errors) == 0 {
+				if err != nil {
+					t.Errorf("got %v, want no error", err)
+				}
+				return
+			}
+			if !errdefs.IsInvalidArgument(err) {
+				t.Fatalf("got %v, want an invalid argument error", err)
+			}
+			for _, field := range tc.errors {
+				if !strings.Contains(err.Error(), field) {
+					t.Errorf("error %q doesn't name %s", err, field)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compat
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+)
+
+// ReadRawSpec decodes the spec of bundle as raw JSON, so that fields unknown
+// to the vendored spec package are preserved when it is written back.
+func ReadRawSpec(bundle string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(filepath.Join(bundle, "config.json"))
+	if err != nil {
+		return nil, err
+	}
+	var spec map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&spec); err != nil {
+		return nil, errors.Wrap(err, "failed to decode spec")
+	}
+	return spec, nil
+}
+
+// WriteRawSpec atomically replaces the spec of bundle.
+func WriteRawSpec(bundle string, spec map[string]interface{}) error {
+	out, err := json.Marshal(spec)
+	if err != nil {
+		return err
+	}
+	return writeSpecFile(bundle, out)
+}
+
+// ParseRawSpec returns the JSON of spec and the spec it decodes to, to
+// check the changes made to spec before they are written to the bundle.
+func ParseRawSpec(spec map[string]interface{}) ([]byte, *specs.Spec, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	var s specs.Spec
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to decode spec")
+	}
+	return data, &s, nil
+}
+
+// RewriteSpec atomically replaces the spec of bundle with data. It returns
+// a func putting the original spec back, for when the container ends up not
+// being created.
+func RewriteSpec(bundle string, data []byte) (func() error, error) {
+	orig, err := ioutil.ReadFile(filepath.Join(bundle, "config.json"))
+	if err != nil {
+		return nil, err
+	}
+	if err := writeSpecFile(bundle, data); err != nil {
+		return nil, err
+	}
+	return func() error {
+		return writeSpecFile(bundle, orig)
+	}, nil
+}
+
+func writeSpecFile(bundle string, data []byte) error {
+	path := filepath.Join(bundle, "config.json")
+	st, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, st.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
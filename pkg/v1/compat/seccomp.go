@@ -0,0 +1,93 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compat
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// seccompRewrites are the seccomp actions the sentry doesn't implement but
+// that have an equivalent it does. Without a tracer or a listener the kernel
+// fails the syscall with ENOSYS, which SCMP_ACT_ERRNO reproduces.
+var seccompRewrites = map[string]string{
+	"SCMP_ACT_TRACE":  "SCMP_ACT_ERRNO",
+	"SCMP_ACT_NOTIFY": "SCMP_ACT_ERRNO",
+}
+
+// seccompIgnored are the seccomp fields the sentry doesn't apply.
+var seccompIgnored = []string{"flags", "listenerPath", "listenerMetadata"}
+
+// TranslateSeccomp rewrites the seccomp profile of spec, as read by
+// ReadRawSpec, into one the sentry implements: actions it lacks are replaced
+// by their equivalent and fields it ignores are removed. It returns the
+// changes made, or in strict mode a FailedPrecondition error listing them.
+//
+// spec is only changed in memory, the caller checks it before writing it to
+// the bundle.
+func TranslateSeccomp(spec map[string]interface{}, strict bool) ([]string, error) {
+	linux, _ := spec["linux"].(map[string]interface{})
+	seccomp, _ := linux["seccomp"].(map[string]interface{})
+	if seccomp == nil {
+		return nil, nil
+	}
+	changes := translateSeccomp(seccomp)
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	if strict {
+		return nil, errors.Wrapf(errdefs.ErrFailedPrecondition, "seccomp profile is not supported by gVisor: %s", strings.Join(changes, "; "))
+	}
+	return changes, nil
+}
+
+func translateSeccomp(seccomp map[string]interface{}) []string {
+	var changes []string
+	if to, ok := seccompRewrites[str(seccomp["defaultAction"])]; ok {
+		changes = append(changes, fmt.Sprintf("linux.seccomp.defaultAction: %s rewritten to %s", seccomp["defaultAction"], to))
+		seccomp["defaultAction"] = to
+		seccomp["defaultErrnoRet"] = int(unix.ENOSYS)
+	}
+	syscalls, _ := seccomp["syscalls"].([]interface{})
+	for i, s := range syscalls {
+		sc, _ := s.(map[string]interface{})
+		if sc == nil {
+			continue
+		}
+		if to, ok := seccompRewrites[str(sc["action"])]; ok {
+			changes = append(changes, fmt.Sprintf("linux.seccomp.syscalls[%d]: %s rewritten to %s", i, sc["action"], to))
+			sc["action"] = to
+			sc["errnoRet"] = int(unix.ENOSYS)
+		}
+	}
+	for _, field := range seccompIgnored {
+		if _, ok := seccomp[field]; ok {
+			changes = append(changes, fmt.Sprintf("linux.seccomp.%s: removed, not applied by gVisor", field))
+			delete(seccomp, field)
+		}
+	}
+	return changes
+}
+
+func str(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compat
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	units "github.com/docker/go-units"
+	"github.com/pkg/errors"
+)
+
+// ShmSizeAnnotation is the size of the /dev/shm tmpfs of the container,
+// e.g. "64m", like the --shm-size of docker run. It overrides the shm_size
+// option of the shim.
+const ShmSizeAnnotation = "dev.gvisor.shm-size"
+
+// ShmPath is where the shared memory of a container is mounted.
+const ShmPath = "/dev/shm"
+
+// shmOptions are the options of the /dev/shm tmpfs made for a size, those
+// containerd mounts /dev/shm with.
+var shmOptions = []string{"nosuid", "noexec", "nodev", "mode=1777"}
+
+// tmpfsOptions are the tmpfs options the sentry implements. Others, such
+// as nr_inodes or huge, fail the mount in the sandbox.
+var tmpfsOptions = []string{"mode", "uid", "gid", "size"}
+
+// mountOptions are the generic mount options runsc applies or ignores.
+var mountOptions = []string{
+	"defaults", "ro", "rw", "suid", "nosuid", "dev", "nodev", "exec", "noexec",
+	"atime", "noatime", "diratime", "nodiratime", "relatime", "norelatime",
+	"strictatime", "nostrictatime", "sync", "async", "dirsync",
+	"private", "rprivate", "shared", "rshared", "slave", "rslave",
+	"unbindable", "runbindable",
+}
+
+// ParseShmSize parses a /dev/shm size, in bytes or with a unit, e.g. "64m".
+func ParseShmSize(v string) (int64, error) {
+	size, err := units.RAMInBytes(v)
+	if err != nil || size <= 0 {
+		return 0, errors.Wrapf(errdefs.ErrInvalidArgument, "invalid shm size %q", v)
+	}
+	return size, nil
+}
+
+// ConfigureShm sizes the /dev/shm of spec, as read by ReadRawSpec, as set
+// by the ShmSizeAnnotation or else by size, zero for none. The /dev/shm
+// mount, usually a bind of a tmpfs of the host, is replaced by a tmpfs of
+// that size, which the sentry mounts in the memory of the sandbox. The
+// options of a /dev/shm tmpfs are checked against those the sentry
+// implements. It returns the effective size in bytes, zero when /dev/shm
+// isn't a sized tmpfs, and whether spec was changed. spec is only changed
+// in memory, the caller writes it to the bundle.
+func ConfigureShm(spec map[string]interface{}, size int64) (int64, bool, error) {
+	annotations, _ := spec["annotations"].(map[string]interface{})
+	if v, ok := annotations[ShmSizeAnnotation]; ok {
+		var err error
+		if size, err = ParseShmSize(str(v)); err != nil {
+			return 0, false, errors.Wrapf(err, "%s annotation", ShmSizeAnnotation)
+		}
+	}
+	mounts, _ := spec["mounts"].([]interface{})
+	idx := -1
+	for i, m := range mounts {
+		if mm, ok := m.(map[string]interface{}); ok && path.Clean(str(mm["destination"])) == ShmPath {
+			idx = i
+		}
+	}
+	if size > 0 {
+		size = roundToPage(size)
+		shm := map[string]interface{}{
+			"destination": ShmPath,
+			"type":        "tmpfs",
+			"source":      "shm",
+		}
+		options := shmOptions
+		if idx != -1 {
+			if m := mounts[idx].(map[string]interface{}); str(m["type"]) == "tmpfs" {
+				shm, options = m, stringList(m["options"])
+			}
+		}
+		shm["options"] = withSize(options, size)
+		if idx == -1 {
+			idx = len(mounts)
+			mounts = append(mounts, shm)
+		} else {
+			mounts[idx] = shm
+		}
+		spec["mounts"] = mounts
+	}
+	if idx == -1 {
+		return 0, false, nil
+	}
+	m := mounts[idx].(map[string]interface{})
+	if str(m["type"]) != "tmpfs" {
+		return 0, false, nil
+	}
+	effective, err := checkTmpfsOptions(stringList(m["options"]))
+	if err != nil {
+		return 0, false, err
+	}
+	return effective, size > 0, nil
+}
+
+// checkTmpfsOptions checks the options of the /dev/shm tmpfs and returns
+// the size they set, zero if none.
+func checkTmpfsOptions(options []string) (int64, error) {
+	var size int64
+	for _, o := range options {
+		kv := strings.SplitN(o, "=", 2)
+		if len(kv) == 1 {
+			if !contains(mountOptions, o) {
+				return 0, errors.Wrapf(errdefs.ErrInvalidArgument, "%s mount option %q is not supported by gVisor", ShmPath, o)
+			}
+			continue
+		}
+		if !contains(tmpfsOptions, kv[0]) {
+			return 0, errors.Wrapf(errdefs.ErrInvalidArgument, "%s tmpfs option %q is not supported by gVisor", ShmPath, kv[0])
+		}
+		if kv[0] == "size" {
+			var err error
+			if size, err = parseTmpfsSize(kv[1]); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return size, nil
+}
+
+// parseTmpfsSize parses the size option of a tmpfs, in bytes or with a
+// k, m or g suffix. Sizes relative to the memory of the host, e.g. "50%",
+// are not supported by the sentry.
+func parseTmpfsSize(v string) (int64, error) {
+	n, shift := v, uint(0)
+	if l := len(n); l > 0 {
+		switch n[l-1] {
+		case 'k', 'K':
+			shift = 10
+		case 'm', 'M':
+			shift = 20
+		case 'g', 'G':
+			shift = 30
+		}
+		if shift != 0 {
+			n = n[:l-1]
+		}
+	}
+	size, err := strconv.ParseInt(n, 10, 64)
+	if err != nil || size <= 0 || size > (1<<63-1)>>shift {
+		return 0, errors.Wrapf(errdefs.ErrInvalidArgument, "%s tmpfs size %q is not supported by gVisor", ShmPath, v)
+	}
+	return roundToPage(size << shift), nil
+}
+
+// withSize returns options with their size option set to size.
+func withSize(options []string, size int64) []interface{} {
+	out := make([]interface{}, 0, len(options)+1)
+	for _, o := range options {
+		if !strings.HasPrefix(o, "size=") {
+			out = append(out, o)
+		}
+	}
+	return append(out, fmt.Sprintf("size=%d", size))
+}
+
+// roundToPage rounds size up to a multiple of the page size, the size the
+// tmpfs ends up with.
+func roundToPage(size int64) int64 {
+	page := int64(os.Getpagesize())
+	return (size + page - 1) / page * page
+}
+
+func stringList(v interface{}) []string {
+	l, _ := v.([]interface{})
+	out := make([]string, 0, len(l))
+	for _, s := range l {
+		out = append(out, str(s))
+	}
+	return out
+}
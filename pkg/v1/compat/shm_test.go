@@ -0,0 +1,165 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package compat
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+)
+
+// rawSpec decodes data as ReadRawSpec does.
+func rawSpec(t *testing.T, data string) map[string]interface{} {
+	var spec map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader([]byte(data)))
+	dec.UseNumber()
+	if err := dec.Decode(&spec); err != nil {
+		t.Fatal(err)
+	}
+	return spec
+}
+
+func TestConfigureShm(t *testing.T) {
+	page := int64(os.Getpagesize())
+	for _, tc := range []struct {
+		name    string
+		spec    string
+		size    int64
+		want    int64
+		changed bool
+		options []string
+		invalid bool
+	}{
+		{
+			name: "no shm",
+			spec: `{"mounts": [{"destination": "/tmp", "type": "tmpfs"}]}`,
+		},
+		{
+			name: "host shm",
+			spec: `{"mounts": [{"destination": "/dev/shm", "type": "bind", "source": "/run/shm"}]}`,
+		},
+		{
+			name:    "shm tmpfs",
+			spec:    `{"mounts": [{"destination": "/dev/shm", "type": "tmpfs", "options": ["nosuid", "size=65536k"]}]}`,
+			want:    64 << 20,
+			options: []string{"nosuid", "size=65536k"},
+		},
+		{
+			name:    "sized",
+			spec:    `{}`,
+			size:    64 << 20,
+			want:    64 << 20,
+			changed: true,
+			options: []string{"nosuid", "noexec", "nodev", "mode=1777", "size=67108864"},
+		},
+		{
+			name:    "sized host shm",
+			spec:    `{"mounts": [{"destination": "/dev/shm/", "type": "bind", "source": "/run/shm"}]}`,
+			size:    1,
+			want:    page,
+			changed: true,
+			options: []string{"nosuid", "noexec", "nodev", "mode=1777", "size=" + itoa(page)},
+		},
+		{
+			name:    "sized tmpfs",
+			spec:    `{"mounts": [{"destination": "/dev/shm", "type": "tmpfs", "options": ["ro", "size=1g"]}]}`,
+			size:    64 << 20,
+			want:    64 << 20,
+			changed: true,
+			options: []string{"ro", "size=67108864"},
+		},
+		{
+			name:    "annotation",
+			spec:    `{"annotations": {"dev.gvisor.shm-size": "128m"}}`,
+			size:    64 << 20,
+			want:    128 << 20,
+			changed: true,
+			options: []string{"nosuid", "noexec", "nodev", "mode=1777", "size=134217728"},
+		},
+		{
+			name:    "invalid annotation",
+			spec:    `{"annotations": {"dev.gvisor.shm-size": "lots"}}`,
+			invalid: true,
+		},
+		{
+			name:    "relative size",
+			spec:    `{"mounts": [{"destination": "/dev/shm", "type": "tmpfs", "options": ["size=50%"]}]}`,
+			invalid: true,
+		},
+		{
+			name:    "unsupported tmpfs option",
+			spec:    `{"mounts": [{"destination": "/dev/shm", "type": "tmpfs", "options": ["nr_inodes=1k"]}]}`,
+			size:    64 << 20,
+			invalid: true,
+		},
+		{
+			name:    "unsupported mount option",
+			spec:    `{"mounts": [{"destination": "/dev/shm", "type": "tmpfs", "options": ["lazytime"]}]}`,
+			invalid: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			spec := rawSpec(t, tc.spec)
+			got, changed, err := ConfigureShm(spec, tc.size)
+			if tc.invalid {
+				if !errdefs.IsInvalidArgument(err) {
+					t.Fatalf("got %v, want an invalid argument error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want || changed != tc.changed {
+				t.Errorf("got size %d, changed %v, want %d, %v", got, changed, tc.want, tc.changed)
+			}
+			if !changed {
+				if want := rawSpec(t, tc.spec); !reflect.DeepEqual(spec, want) {
+					t.Errorf("spec changed: %v", spec)
+				}
+			}
+			if tc.options == nil {
+				return
+			}
+			mounts, _ := spec["mounts"].([]interface{})
+			var shm map[string]interface{}
+			for _, m := range mounts {
+				if m := m.(map[string]interface{}); str(m["destination"]) == ShmPath || str(m["destination"]) == ShmPath+"/" {
+					shm = m
+				}
+			}
+			if shm == nil {
+				t.Fatalf("no %s mount in %v", ShmPath, mounts)
+			}
+			if typ := str(shm["type"]); typ != "tmpfs" {
+				t.Errorf("%s mount type: got %q, want tmpfs", ShmPath, typ)
+			}
+			if options := stringList(shm["options"]); !reflect.DeepEqual(options, tc.options) {
+				t.Errorf("%s options: got %q, want %q", ShmPath, options, tc.options)
+			}
+		})
+	}
+}
+
+func itoa(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
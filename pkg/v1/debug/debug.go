@@ -0,0 +1,151 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package debug serves profiling endpoints of the shim on a unix socket.
+//
+// The socket exposes the net/http/pprof handlers under /debug/pprof/,
+// including runtime traces at /debug/pprof/trace, e.g.
+//
+//	curl --unix-socket <socket> http://shim/debug/pprof/goroutine?debug=2
+//
+// Shims register their own endpoints with Handle, such as the per process
+// io byte counters at /debug/io.
+package debug
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/status"
+)
+
+// SocketPath returns the path of the debug socket of a shim in dir.
+func SocketPath(dir, namespace, id string) string {
+	return filepath.Join(dir, namespace+"-"+id+".sock")
+}
+
+// Server serves the debug endpoints.
+type Server struct {
+	path     string
+	mux      *http.ServeMux
+	server   *http.Server
+	listener net.Listener
+}
+
+// NewServer listens on a unix socket at path, replacing any stale socket.
+// The socket is only accessible by its owner.
+func NewServer(path string) (*Server, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, errors.Wrap(err, "create debug socket directory")
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "remove stale debug socket")
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, errors.Wrap(err, "listen on debug socket")
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	mux := Handler()
+	return &Server{
+		path:     path,
+		mux:      mux,
+		server:   &http.Server{Handler: mux},
+		listener: l,
+	}, nil
+}
+
+// Handler returns the handler of the debug endpoints.
+func Handler() *http.ServeMux {
+	m := http.NewServeMux()
+	m.HandleFunc("/debug/pprof/", pprof.Index)
+	m.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	m.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	m.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	m.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return m
+}
+
+// Handle registers an additional endpoint.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// JSONHandler serves the value returned by f as JSON.
+func JSONHandler(f func() interface{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(f()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// Error replies to the request with err and the status DebugClient maps
+// back to it.
+func Error(w http.ResponseWriter, err error) {
+	http.Error(w, err.Error(), errorStatus(err))
+}
+
+// errorStatus returns the HTTP status of err, an errdefs error or one
+// converted to gRPC by the services.
+func errorStatus(err error) int {
+	if _, ok := status.FromError(errors.Cause(err)); ok {
+		err = errdefs.FromGRPC(err)
+	}
+	switch {
+	case errdefs.IsNotFound(err):
+		return http.StatusNotFound
+	case errdefs.IsInvalidArgument(err):
+		return http.StatusBadRequest
+	case errdefs.IsFailedPrecondition(err):
+		return http.StatusConflict
+	case errdefs.IsNotImplemented(err):
+		return http.StatusMethodNotAllowed
+	}
+	return http.StatusInternalServerError
+}
+
+// Serve serves the debug endpoints in the background until Close is called.
+func (s *Server) Serve(ctx context.Context) {
+	log.G(ctx).WithField("socket", s.path).Debug("serving debug endpoints")
+	go func() {
+		if err := s.server.Serve(s.listener); err != nil &&
+			err != http.ErrServerClosed &&
+			!strings.Contains(err.Error(), "use of closed network connection") {
+			log.G(ctx).WithError(err).Error("debug server failure")
+		}
+	}()
+}
+
+// Close stops the server and removes its socket.
+func (s *Server) Close() error {
+	err := s.server.Close()
+	os.Remove(s.path)
+	return err
+}
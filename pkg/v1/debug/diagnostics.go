@@ -0,0 +1,48 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debug
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// Diagnoser is implemented by the shims to report on the sandbox of their
+// container.
+type Diagnoser interface {
+	// Diagnostics returns what debugging tools need to attach to the
+	// sandbox.
+	Diagnostics(ctx context.Context) (*runsctypes.Diagnostics, error)
+}
+
+// HandleDiagnostics registers the diagnostics endpoint of d, GET
+// /debug/diagnostics. The sentry is signaled with the SignalSentry RPC of
+// the runsc service.
+func (s *Server) HandleDiagnostics(d Diagnoser) {
+	s.Handle("/debug/diagnostics", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		diag, err := d.Diagnostics(r.Context())
+		if err != nil {
+			Error(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(diag)
+	}))
+}
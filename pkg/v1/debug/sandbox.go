@@ -0,0 +1,47 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debug
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// Sandboxer is implemented by the shims to model the pod sandbox the way
+// the containerd sandbox API does: the pause container is the sandbox, with
+// its own lifecycle, and the application containers of the pod are created
+// in it by runsc. The vendored containerd has no sandbox service, so the
+// status of the sandbox is served on the debug socket, and it is stopped
+// with the StopSandbox RPC of the runsc service.
+type Sandboxer interface {
+	// SandboxStatus returns the status of the sandbox.
+	SandboxStatus(ctx context.Context) (*runsctypes.SandboxStatus, error)
+}
+
+// HandleSandbox registers the sandbox endpoint of sb, GET /debug/sandbox.
+func (s *Server) HandleSandbox(sb Sandboxer) {
+	s.Handle("/debug/sandbox", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, err := sb.SandboxStatus(r.Context())
+		if err != nil {
+			Error(w, err)
+			return
+		}
+		JSONHandler(func() interface{} { return status }).ServeHTTP(w, r)
+	}))
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package devices configures the host devices passed through to a sandbox.
+//
+// Devices are requested by class, either explicitly with the
+// dev.gvisor.spec.devices annotation, e.g. "fuse,nvidia", or implicitly by
+// the device entries of the spec. Each class enables the runsc flags the
+// sentry needs to implement it and requires its host devices to exist.
+package devices
+
+import (
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+)
+
+// Annotation lists the device classes requested by a container, separated
+// by commas.
+const Annotation = "dev.gvisor.spec.devices"
+
+// Class is a kind of device the sentry can pass through.
+type Class struct {
+	// Name is the name of the class used in the annotation.
+	Name string
+	// Flags are the runsc flags enabling the class.
+	Flags map[string]string
+	// HostPaths are the host devices the class requires.
+	HostPaths []string
+	// Majors are the device numbers of spec devices of the class.
+	Majors []int64
+	// Minors, if set, restrict Majors to these minor numbers.
+	Minors []int64
+}
+
+// Classes are the supported device classes.
+var Classes = map[string]*Class{
+	"fuse": {
+		Name:      "fuse",
+		Flags:     map[string]string{"fuse": "true"},
+		HostPaths: []string{"/dev/fuse"},
+		Majors:    []int64{10},
+		Minors:    []int64{229},
+	},
+	"nvidia": {
+		Name:      "nvidia",
+		Flags:     map[string]string{"nvproxy": "true"},
+		HostPaths: []string{"/dev/nvidiactl", "/dev/nvidia-uvm"},
+		Majors:    []int64{195},
+	},
+}
+
+// Lookup returns the class of a spec device, or nil if the device doesn't
+// belong to a class.
+func Lookup(major, minor int64) *Class {
+	for _, c := range Classes {
+		if c.matches(major, minor) {
+			return c
+		}
+	}
+	return nil
+}
+
+func (c *Class) matches(major, minor int64) bool {
+	if !contains(c.Majors, major) {
+		return false
+	}
+	return len(c.Minors) == 0 || contains(c.Minors, minor)
+}
+
+func contains(values []int64, v int64) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Requested returns the device classes requested by the spec, sorted by
+// name. Unknown classes in the annotation are an InvalidArgument error.
+func Requested(spec *specs.Spec) ([]*Class, error) {
+	names := make(map[string]bool)
+	if v := spec.Annotations[Annotation]; v != "" {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if _, ok := Classes[name]; !ok {
+				return nil, errors.Wrapf(errdefs.ErrInvalidArgument, "unsupported device class %q in %s", name, Annotation)
+			}
+			names[name] = true
+		}
+	}
+	if spec.Linux != nil {
+		for _, d := range spec.Linux.Devices {
+			if c := Lookup(d.Major, d.Minor); c != nil {
+				names[c.Name] = true
+			}
+		}
+	}
+	var classes []*Class
+	for name := range names {
+		classes = append(classes, Classes[name])
+	}
+	sort.Slice(classes, func(i, j int) bool {
+		return classes[i].Name < classes[j].Name
+	})
+	return classes, nil
+}
+
+// Available checks that the host devices of the class exist. It returns a
+// FailedPrecondition error naming the missing device otherwise.
+func (c *Class) Available() error {
+	for _, path := range c.HostPaths {
+		st, err := os.Stat(path)
+		if err != nil {
+			return errors.Wrapf(errdefs.ErrFailedPrecondition, "device class %q requires host device %s: %v", c.Name, path, err)
+		}
+		if st.Mode()&os.ModeCharDevice == 0 {
+			return errors.Wrapf(errdefs.ErrFailedPrecondition, "device class %q requires host device %s, which is not a character device", c.Name, path)
+		}
+	}
+	return nil
+}
+
+// Configure returns the runsc flags of config with the flags of the device
+// classes requested by spec, after checking that their host devices exist.
+// Flags only take effect when the sandbox is created, so they are added
+// for sandbox containers only.
+func Configure(config map[string]string, spec *specs.Spec, sandbox bool) (map[string]string, error) {
+	classes, err := Requested(spec)
+	if err != nil {
+		return nil, err
+	}
+	if len(classes) == 0 {
+		return config, nil
+	}
+	for _, c := range classes {
+		if err := c.Available(); err != nil {
+			return nil, err
+		}
+	}
+	if !sandbox {
+		return config, nil
+	}
+	flags := make(map[string]string, len(config))
+	for k, v := range config {
+		flags[k] = v
+	}
+	for _, c := range classes {
+		for k, v := range c.Flags {
+			flags[k] = v
+		}
+	}
+	return flags, nil
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package eventq implements the queue of events waiting to be published to
+// containerd. Pushing never blocks unless the queue is bounded with the
+// block policy, so exit handling doesn't stall behind a slow containerd.
+package eventq
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Policy is the behavior of a bounded queue when it is full.
+type Policy string
+
+const (
+	// PolicyBlock blocks Push until there is room in the queue.
+	PolicyBlock Policy = "block"
+	// PolicyDropOldest discards the oldest queued event.
+	PolicyDropOldest Policy = "drop-oldest"
+	// PolicyDropWithMetric discards the pushed event and counts it.
+	PolicyDropWithMetric Policy = "drop-with-metric"
+)
+
+// Config configures a queue.
+type Config struct {
+	// Size bounds the number of queued events. Zero means unbounded.
+	Size int
+	// Policy is applied when a bounded queue is full. It defaults to
+	// PolicyDropOldest.
+	Policy Policy
+}
+
+// Validate checks the config.
+func (c Config) Validate() error {
+	if c.Size < 0 {
+		return errors.Errorf("invalid event queue size %d", c.Size)
+	}
+	switch c.Policy {
+	case "", PolicyBlock, PolicyDropOldest, PolicyDropWithMetric:
+		return nil
+	}
+	return errors.Errorf("unknown event overflow policy %q", c.Policy)
+}
+
+// Queue is a FIFO queue of events.
+type Queue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	items   []interface{}
+	config  Config
+	dropped uint64
+	closed  bool
+}
+
+// New returns a queue with the config.
+func New(config Config) *Queue {
+	q := &Queue{config: config}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// SetConfig replaces the config of the queue. Already queued events are kept
+// even if they exceed the new size.
+func (q *Queue) SetConfig(config Config) {
+	q.mu.Lock()
+	q.config = config
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// Push queues the event, applying the overflow policy when the queue is
+// full. It returns false if the event was dropped.
+func (q *Queue) Push(e interface{}) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for !q.closed && q.full() {
+		switch q.config.Policy {
+		case PolicyBlock:
+			q.cond.Wait()
+			continue
+		case PolicyDropWithMetric:
+			q.dropped++
+			return false
+		default:
+			q.items[0] = nil
+			q.items = q.items[1:]
+			q.dropped++
+		}
+	}
+	if q.closed {
+		return false
+	}
+	q.items = append(q.items, e)
+	q.cond.Broadcast()
+	return true
+}
+
+func (q *Queue) full() bool {
+	return q.config.Size > 0 && len(q.items) >= q.config.Size
+}
+
+// Pop removes and returns the oldest event, blocking until one is queued.
+// It returns false once the queue is closed and drained.
+func (q *Queue) Pop() (interface{}, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.items) == 0 {
+		if q.closed {
+			return nil, false
+		}
+		q.cond.Wait()
+	}
+	e := q.items[0]
+	q.items[0] = nil
+	q.items = q.items[1:]
+	q.cond.Broadcast()
+	return e, true
+}
+
+// Len returns the number of queued events.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// Dropped returns the number of events dropped by the overflow policy.
+func (q *Queue) Dropped() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dropped
+}
+
+// Close stops accepting events. Queued events can still be popped.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package eventq
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// popAll pops the events queued in q, which must be closed.
+func popAll(q *Queue) []interface{} {
+	var events []interface{}
+	for {
+		e, ok := q.Pop()
+		if !ok {
+			return events
+		}
+		events = append(events, e)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		config Config
+		valid  bool
+	}{
+		{config: Config{}, valid: true},
+		{config: Config{Size: 10, Policy: PolicyBlock}, valid: true},
+		{config: Config{Size: 10, Policy: PolicyDropOldest}, valid: true},
+		{config: Config{Size: 10, Policy: PolicyDropWithMetric}, valid: true},
+		{config: Config{Size: -1}},
+		{config: Config{Size: 10, Policy: "drop-newest"}},
+	} {
+		if err := tc.config.Validate(); (err == nil) != tc.valid {
+			t.Errorf("Validate(%+v): got %v, want valid %v", tc.config, err, tc.valid)
+		}
+	}
+}
+
+func TestOrder(t *testing.T) {
+	q := New(Config{Size: 4, Policy: PolicyBlock})
+	const n = 1000
+	go func() {
+		for i := 0; i < n; i++ {
+			q.Push(i)
+		}
+		q.Close()
+	}()
+	events := popAll(q)
+	if len(events) != n {
+		t.Fatalf("popped %d events, want %d", len(events), n)
+	}
+	for i, e := range events {
+		if e != i {
+			t.Fatalf("event %d: got %v, want events in the order they were pushed", i, e)
+		}
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		config  Config
+		want    []interface{}
+		dropped uint64
+	}{
+		{
+			name:   "unbounded",
+			config: Config{},
+			want:   []interface{}{1, 2, 3, 4, 5},
+		},
+		{
+			name:    "drop oldest by default",
+			config:  Config{Size: 3},
+			want:    []interface{}{3, 4, 5},
+			dropped: 2,
+		},
+		{
+			name:    "drop oldest",
+			config:  Config{Size: 3, Policy: PolicyDropOldest},
+			want:    []interface{}{3, 4, 5},
+			dropped: 2,
+		},
+		{
+			name:    "drop with metric",
+			config:  Config{Size: 3, Policy: PolicyDropWithMetric},
+			want:    []interface{}{1, 2, 3},
+			dropped: 2,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			q := New(tc.config)
+			for i := 1; i <= 5; i++ {
+				kept := q.Push(i)
+				if want := tc.config.Policy != PolicyDropWithMetric || i <= tc.config.Size; kept != want {
+					t.Errorf("Push(%d): got %v, want %v", i, kept, want)
+				}
+			}
+			if got := q.Dropped(); got != tc.dropped {
+				t.Errorf("dropped: got %d, want %d", got, tc.dropped)
+			}
+			q.Close()
+			if got := popAll(q); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("events: got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBlock(t *testing.T) {
+	q := New(Config{Size: 1, Policy: PolicyBlock})
+	q.Push(1)
+	pushed := make(chan bool)
+	go func() { pushed <- q.Push(2) }()
+	select {
+	case <-pushed:
+		t.Fatal("Push didn't block on a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if e, _ := q.Pop(); e != 1 {
+		t.Fatalf("Pop: got %v, want 1", e)
+	}
+	if !<-pushed {
+		t.Fatal("blocked event was dropped")
+	}
+	if e, _ := q.Pop(); e != 2 {
+		t.Fatalf("Pop: got %v, want 2", e)
+	}
+	if got := q.Dropped(); got != 0 {
+		t.Errorf("dropped: got %d, want 0", got)
+	}
+}
+
+func TestSetConfig(t *testing.T) {
+	q := New(Config{Size: 1, Policy: PolicyBlock})
+	q.Push(1)
+	pushed := make(chan bool)
+	go func() { pushed <- q.Push(2) }()
+	// Growing the queue releases the blocked Push.
+	q.SetConfig(Config{Size: 2, Policy: PolicyBlock})
+	if !<-pushed {
+		t.Fatal("blocked event was dropped")
+	}
+	// Shrinking it keeps the queued events.
+	q.SetConfig(Config{Size: 1, Policy: PolicyDropWithMetric})
+	if q.Push(3) {
+		t.Error("Push on a full queue kept the event")
+	}
+	q.Close()
+	if got, want := popAll(q), []interface{}{1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("events: got %v, want %v", got, want)
+	}
+}
+
+func TestClose(t *testing.T) {
+	t.Run("drains queued events", func(t *testing.T) {
+		q := New(Config{})
+		q.Push(1)
+		q.Push(2)
+		q.Close()
+		if q.Push(3) {
+			t.Error("Push after Close kept the event")
+		}
+		if got, want := popAll(q), []interface{}{1, 2}; !reflect.DeepEqual(got, want) {
+			t.Errorf("events: got %v, want %v", got, want)
+		}
+		if got := q.Len(); got != 0 {
+			t.Errorf("len: got %d, want 0", got)
+		}
+	})
+	t.Run("releases Pop", func(t *testing.T) {
+		q := New(Config{})
+		popped := make(chan bool)
+		go func() {
+			_, ok := q.Pop()
+			popped <- ok
+		}()
+		q.Close()
+		if <-popped {
+			t.Error("Pop on a closed empty queue returned an event")
+		}
+	})
+	t.Run("releases blocked Push", func(t *testing.T) {
+		q := New(Config{Size: 1, Policy: PolicyBlock})
+		q.Push(1)
+		pushed := make(chan bool)
+		go func() { pushed <- q.Push(2) }()
+		q.Close()
+		if <-pushed {
+			t.Error("Push blocked until Close kept the event")
+		}
+		if got, want := popAll(q), []interface{}{1}; !reflect.DeepEqual(got, want) {
+			t.Errorf("events: got %v, want %v", got, want)
+		}
+	})
+}
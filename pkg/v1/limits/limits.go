@@ -0,0 +1,265 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package limits confines the shim process itself, so that a leaking shim
+// can't take down the node: the shim is moved into a cgroup of its own with
+// memory and cpu caps, and its open file limit is raised for the fifos of
+// the processes it serves. The processes the shim starts inherit its
+// cgroup, so the long-lived ones, such as sandboxes without a cgroup of
+// their own, are moved back out with Exempt. Both the v1 and the unified
+// cgroup hierarchies are supported.
+package limits
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/cgroups"
+	"github.com/containerd/containerd/log"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/cgroup"
+)
+
+// cpuPeriod is the cfs period the cpu quota of the shim is set over.
+const cpuPeriod = 100000
+
+// Config are the limits of the shim.
+type Config struct {
+	// CgroupParent is the cgroup path the shim creates its own cgroup in,
+	// e.g. "/gvisor-shims". Empty leaves the shim in the cgroup it was
+	// started in.
+	CgroupParent string
+	// MemoryLimit caps the memory of the shim cgroup in bytes. Zero leaves
+	// it unlimited.
+	MemoryLimit int64
+	// CPUs caps the cpu time of the shim cgroup, in cpus, e.g. 0.5. Zero
+	// leaves it unlimited.
+	CPUs float64
+	// NoFile is the RLIMIT_NOFILE of the shim. Zero keeps the inherited
+	// limit.
+	NoFile uint64
+}
+
+// Validate checks the limits.
+func (c Config) Validate() error {
+	if c.MemoryLimit < 0 {
+		return errors.Errorf("invalid memory limit %d", c.MemoryLimit)
+	}
+	if c.CPUs < 0 {
+		return errors.Errorf("invalid cpus %v", c.CPUs)
+	}
+	if c.CgroupParent == "" && (c.MemoryLimit != 0 || c.CPUs != 0) {
+		return errors.New("memory and cpu limits require a cgroup parent")
+	}
+	if c.CgroupParent != "" && !filepath.IsAbs(c.CgroupParent) {
+		return errors.Errorf("cgroup parent %q must be an absolute path", c.CgroupParent)
+	}
+	return nil
+}
+
+// Limits are the limits applied to the shim.
+type Limits struct {
+	hierarchy cgroups.Hierarchy
+	parent    string
+	cgroup    cgroups.Cgroup
+	// originV1 is the cgroup the shim was started in on v1 hosts.
+	originV1 cgroups.Cgroup
+
+	// v2 is the shim cgroup on unified hosts, and origin the cgroup the
+	// shim was started in. The parent of v2 has controllers enabled, so it
+	// can't hold processes and the shim moves back to origin instead.
+	v2     *cgroup.V2
+	origin string
+}
+
+// Apply applies the limits to the shim. It fails when RLIMIT_NOFILE can't
+// be raised to NoFile, so that a shim that would run out of fds doesn't
+// start.
+func (c Config) Apply() (*Limits, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	if c.NoFile != 0 {
+		if err := setNoFile(c.NoFile); err != nil {
+			return nil, err
+		}
+	}
+	l := &Limits{}
+	if c.CgroupParent == "" {
+		return l, nil
+	}
+	resources := &specs.LinuxResources{}
+	if c.MemoryLimit != 0 {
+		resources.Memory = &specs.LinuxMemory{Limit: &c.MemoryLimit}
+	}
+	if c.CPUs != 0 {
+		quota := int64(c.CPUs * cpuPeriod)
+		period := uint64(cpuPeriod)
+		resources.CPU = &specs.LinuxCPU{Quota: &quota, Period: &period}
+	}
+	path := filepath.Join(c.CgroupParent, fmt.Sprintf("shim-%d", os.Getpid()))
+	if cgroup.DetectMode() == cgroup.Unified {
+		return l, l.applyV2(path, resources)
+	}
+	l.hierarchy = subsystems
+	l.parent = c.CgroupParent
+	origin, err := cgroups.Load(l.hierarchy, cgroups.PidPath(os.Getpid()))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load the cgroup of the shim")
+	}
+	cg, err := cgroups.New(l.hierarchy, cgroups.StaticPath(path), resources)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create shim cgroup %s", path)
+	}
+	if err := cg.Add(cgroups.Process{Pid: os.Getpid()}); err != nil {
+		cg.Delete()
+		return nil, errors.Wrapf(err, "failed to move shim to cgroup %s", path)
+	}
+	l.cgroup = cg
+	l.originV1 = origin
+	return l, nil
+}
+
+// applyV2 moves the shim to a cgroup of the unified hierarchy at path.
+func (l *Limits) applyV2(path string, resources *specs.LinuxResources) error {
+	origin, err := cgroup.PidPath(os.Getpid())
+	if err != nil {
+		return err
+	}
+	cg, err := cgroup.NewV2(path, resources)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create shim cgroup %s", path)
+	}
+	if err := cg.Add(os.Getpid()); err != nil {
+		cg.Delete()
+		return errors.Wrapf(err, "failed to move shim to cgroup %s", path)
+	}
+	l.v2 = cg
+	l.origin = origin
+	return nil
+}
+
+// Exempt moves processes started by the shim out of its cgroup, back to the
+// cgroup the shim was started in, so that its caps only apply to the shim.
+// It does nothing when the shim has no cgroup of its own.
+func (l *Limits) Exempt(pids ...int) error {
+	if l == nil {
+		return nil
+	}
+	if l.v2 != nil {
+		origin, err := cgroup.LoadV2(l.origin)
+		if err != nil {
+			return err
+		}
+		for _, pid := range pids {
+			if err := origin.Add(pid); err != nil {
+				return errors.Wrapf(err, "failed to move %d out of the shim cgroup", pid)
+			}
+		}
+		return nil
+	}
+	if l.cgroup == nil {
+		return nil
+	}
+	for _, pid := range pids {
+		if err := l.originV1.Add(cgroups.Process{Pid: pid}); err != nil {
+			return errors.Wrapf(err, "failed to move %d out of the shim cgroup", pid)
+		}
+	}
+	return nil
+}
+
+// Release moves the shim back to the cgroup parent and removes its cgroup.
+// The cgroup is kept while processes started by the shim that weren't
+// exempted are still in it.
+func (l *Limits) Release() {
+	if l == nil {
+		return
+	}
+	if l.v2 != nil {
+		l.releaseV2()
+		return
+	}
+	if l.cgroup == nil {
+		return
+	}
+	parent, err := cgroups.Load(l.hierarchy, cgroups.StaticPath(l.parent))
+	if err == nil {
+		err = parent.Add(cgroups.Process{Pid: os.Getpid()})
+	}
+	if err == nil {
+		err = l.cgroup.Delete()
+	}
+	if err != nil {
+		log.L.WithError(err).Warn("failed to remove shim cgroup")
+	}
+	l.cgroup = nil
+}
+
+func (l *Limits) releaseV2() {
+	origin, err := cgroup.LoadV2(l.origin)
+	if err == nil {
+		err = origin.Add(os.Getpid())
+	}
+	if err == nil {
+		err = l.v2.Delete()
+	}
+	if err != nil {
+		log.L.WithError(err).Warn("failed to remove shim cgroup")
+	}
+	l.v2 = nil
+}
+
+// subsystems is the hierarchy of the shim cgroup: only the subsystems its
+// limits are set in.
+func subsystems() ([]cgroups.Subsystem, error) {
+	all, err := cgroups.V1()
+	if err != nil {
+		return nil, err
+	}
+	var subs []cgroups.Subsystem
+	for _, s := range all {
+		switch s.Name() {
+		case cgroups.Memory, cgroups.Cpu:
+			subs = append(subs, s)
+		}
+	}
+	if len(subs) == 0 {
+		return nil, errors.New("no memory or cpu cgroup v1 hierarchy mounted")
+	}
+	return subs, nil
+}
+
+// setNoFile sets the soft RLIMIT_NOFILE of the shim to n, raising the hard
+// limit if needed.
+func setNoFile(n uint64) error {
+	var cur unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &cur); err != nil {
+		return errors.Wrap(err, "failed to get RLIMIT_NOFILE")
+	}
+	max := cur.Max
+	if n > max {
+		max = n
+	}
+	if err := unix.Setrlimit(unix.RLIMIT_NOFILE, &unix.Rlimit{Cur: n, Max: max}); err != nil {
+		return errors.Wrapf(err, "failed to set RLIMIT_NOFILE to %d (soft limit %d, hard limit %d); the shim could run out of fds for its fifos", n, cur.Cur, cur.Max)
+	}
+	return nil
+}
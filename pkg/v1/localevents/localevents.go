@@ -0,0 +1,204 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package localevents streams the events of a shim to local subscribers on
+// a unix socket, so that node agents can watch a single sandbox instead of
+// all the events of containerd.
+//
+// Each connection receives the events published after it was accepted, one
+// JSON object per line, e.g.
+//
+//	socat - UNIX-CONNECT:<socket>
+//
+// Besides the events published to containerd, the stream carries internal
+// lifecycle events of the shim, such as io closed and gofer exits.
+//
+// A Webhook posts the events published to containerd to a local HTTP
+// endpoint instead, for agents that would rather be called than connect.
+package localevents
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	"github.com/pkg/errors"
+)
+
+// closeTimeout bounds flushing the queued events to subscribers on Close.
+const closeTimeout = time.Second
+
+// subscriberBuffer is the number of events queued for a subscriber. Events
+// are dropped for subscribers that fall further behind, so that they can't
+// slow the shim down.
+const subscriberBuffer = 256
+
+// SocketPath returns the path of the events socket of a shim in dir.
+func SocketPath(dir, namespace, id string) string {
+	return filepath.Join(dir, namespace+"-"+id+".sock")
+}
+
+// Envelope is a streamed event.
+type Envelope struct {
+	Timestamp time.Time   `json:"timestamp"`
+	Topic     string      `json:"topic"`
+	Type      string      `json:"type"`
+	Event     interface{} `json:"event"`
+}
+
+// marshal returns the envelope of the event.
+func marshal(topic string, e interface{}) ([]byte, error) {
+	return json.Marshal(&Envelope{
+		Timestamp: time.Now(),
+		Topic:     topic,
+		Type:      strings.TrimPrefix(fmt.Sprintf("%T", e), "*"),
+		Event:     e,
+	})
+}
+
+// Server streams events to the connections of its socket.
+type Server struct {
+	path     string
+	listener net.Listener
+
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+	closed      bool
+}
+
+type subscriber struct {
+	conn    net.Conn
+	ch      chan []byte
+	dropped uint64
+}
+
+// NewServer listens on a unix socket at path, replacing any stale socket.
+// The socket is only accessible by its owner.
+func NewServer(path string) (*Server, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, errors.Wrap(err, "create events socket directory")
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "remove stale events socket")
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, errors.Wrap(err, "listen on events socket")
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return &Server{
+		path:        path,
+		listener:    l,
+		subscribers: make(map[*subscriber]struct{}),
+	}, nil
+}
+
+// Serve accepts subscribers in the background until Close is called.
+func (s *Server) Serve(ctx context.Context) {
+	log.G(ctx).WithField("socket", s.path).Debug("serving local events")
+	go func() {
+		for {
+			conn, err := s.listener.Accept()
+			if err != nil {
+				if !strings.Contains(err.Error(), "use of closed network connection") {
+					log.G(ctx).WithError(err).Error("events server failure")
+				}
+				return
+			}
+			s.subscribe(ctx, conn)
+		}
+	}()
+}
+
+func (s *Server) subscribe(ctx context.Context, conn net.Conn) {
+	sub := &subscriber{conn: conn, ch: make(chan []byte, subscriberBuffer)}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.subscribers[sub] = struct{}{}
+	s.mu.Unlock()
+	go func() {
+		defer s.unsubscribe(sub)
+		for line := range sub.ch {
+			if _, err := conn.Write(line); err != nil {
+				log.G(ctx).WithError(err).Debug("local events subscriber left")
+				return
+			}
+		}
+	}()
+}
+
+func (s *Server) unsubscribe(sub *subscriber) {
+	s.mu.Lock()
+	if _, ok := s.subscribers[sub]; ok {
+		delete(s.subscribers, sub)
+		close(sub.ch)
+	}
+	s.mu.Unlock()
+	sub.conn.Close()
+}
+
+// Publish sends the event to the subscribers. It never blocks.
+func (s *Server) Publish(topic string, e interface{}) {
+	if s == nil {
+		return
+	}
+	line, err := marshal(topic, e)
+	if err != nil {
+		log.L.WithError(err).Warnf("failed to marshal %T event", e)
+		return
+	}
+	line = append(line, '\n')
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sub := range s.subscribers {
+		select {
+		case sub.ch <- line:
+		default:
+			sub.dropped++
+			log.L.WithField("dropped", sub.dropped).Warnf("dropped %T event, local events subscriber is too slow", e)
+		}
+	}
+}
+
+// Close disconnects the subscribers once their queued events are sent, and
+// removes the socket.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for sub := range s.subscribers {
+		delete(s.subscribers, sub)
+		close(sub.ch)
+		sub.conn.SetWriteDeadline(time.Now().Add(closeTimeout))
+	}
+	s.mu.Unlock()
+	err := s.listener.Close()
+	os.Remove(s.path)
+	return err
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package localevents
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	"github.com/pkg/errors"
+)
+
+// webhookTimeout bounds each POST to a webhook.
+const webhookTimeout = 5 * time.Second
+
+// Webhook posts events to a local HTTP endpoint, one JSON Envelope per
+// request, in the order they are published. Events are queued and dropped
+// when the endpoint falls behind, so that it can't slow the shim down.
+type Webhook struct {
+	url    string
+	client *http.Client
+	ch     chan []byte
+	done   chan struct{}
+
+	mu      sync.Mutex
+	closed  bool
+	dropped uint64
+}
+
+// NewWebhook returns a webhook posting to target, either an http URL or
+// the path of a unix socket as unix:///path, posted to at /.
+func NewWebhook(target string) (*Webhook, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid events webhook %q", target)
+	}
+	client := &http.Client{Timeout: webhookTimeout}
+	switch u.Scheme {
+	case "http", "https":
+	case "unix":
+		if u.Path == "" {
+			return nil, errors.Errorf("invalid events webhook %q, expected unix:///path", target)
+		}
+		socket := u.Path
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+		target = "http://localhost/"
+	default:
+		return nil, errors.Errorf("invalid events webhook %q, expected an http or unix URL", target)
+	}
+	w := &Webhook{
+		url:    target,
+		client: client,
+		ch:     make(chan []byte, subscriberBuffer),
+		done:   make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+// Publish queues the event for the webhook. It never blocks.
+func (w *Webhook) Publish(topic string, e interface{}) {
+	if w == nil {
+		return
+	}
+	body, err := marshal(topic, e)
+	if err != nil {
+		log.L.WithError(err).Warnf("failed to marshal %T event", e)
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	select {
+	case w.ch <- body:
+	default:
+		w.dropped++
+		log.L.WithField("dropped", w.dropped).Warnf("dropped %T event, events webhook is too slow", e)
+	}
+}
+
+func (w *Webhook) run() {
+	defer close(w.done)
+	for body := range w.ch {
+		if err := w.post(body); err != nil {
+			log.L.WithError(err).Warn("failed to post event to webhook")
+		}
+	}
+}
+
+func (w *Webhook) post(body []byte) error {
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Close stops accepting events, and waits for the queued events to be
+// posted for at most a second.
+func (w *Webhook) Close() error {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.ch)
+	}
+	w.mu.Unlock()
+	select {
+	case <-w.done:
+		return nil
+	case <-time.After(closeTimeout):
+		return errors.New("timed out posting the queued events to the webhook")
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package platform
+
+import (
+	"sync"
+
+	"github.com/containerd/console"
+	"golang.org/x/sys/unix"
+)
+
+// master is the console returned by CopyConsole. It holds the pty master
+// as a raw fd, since the console of the console package is an os.File,
+// whose Fd method puts the fd back in blocking mode, which would block the
+// poller. Its Read and Write methods don't block.
+type master struct {
+	name     string
+	original *unix.Termios
+
+	mu sync.Mutex
+	fd int
+}
+
+var _ console.Console = &master{}
+
+func newMaster(fd int, name string) *master {
+	m := &master{fd: fd, name: name}
+	m.original, _ = unix.IoctlGetTermios(fd, unix.TCGETS)
+	return m
+}
+
+func (m *master) Read(b []byte) (int, error) {
+	return unix.Read(m.rawFd(), b)
+}
+
+func (m *master) Write(b []byte) (int, error) {
+	return unix.Write(m.rawFd(), b)
+}
+
+func (m *master) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fd < 0 {
+		return nil
+	}
+	err := unix.Close(m.fd)
+	m.fd = -1
+	return err
+}
+
+func (m *master) Resize(ws console.WinSize) error {
+	return unix.IoctlSetWinsize(m.rawFd(), unix.TIOCSWINSZ, &unix.Winsize{
+		Row: ws.Height,
+		Col: ws.Width,
+	})
+}
+
+func (m *master) ResizeFrom(c console.Console) error {
+	ws, err := c.Size()
+	if err != nil {
+		return err
+	}
+	return m.Resize(ws)
+}
+
+func (m *master) SetRaw() error {
+	t, err := unix.IoctlGetTermios(m.rawFd(), unix.TCGETS)
+	if err != nil {
+		return err
+	}
+	t.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	t.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	t.Cflag &^= unix.CSIZE | unix.PARENB
+	t.Cflag |= unix.CS8
+	t.Cc[unix.VMIN] = 1
+	t.Cc[unix.VTIME] = 0
+	return unix.IoctlSetTermios(m.rawFd(), unix.TCSETS, t)
+}
+
+func (m *master) DisableEcho() error {
+	t, err := unix.IoctlGetTermios(m.rawFd(), unix.TCGETS)
+	if err != nil {
+		return err
+	}
+	t.Lflag &^= unix.ECHO
+	return unix.IoctlSetTermios(m.rawFd(), unix.TCSETS, t)
+}
+
+func (m *master) Reset() error {
+	if m.original == nil {
+		return nil
+	}
+	return unix.IoctlSetTermios(m.rawFd(), unix.TCSETS, m.original)
+}
+
+func (m *master) Size() (console.WinSize, error) {
+	ws, err := unix.IoctlGetWinsize(m.rawFd(), unix.TIOCGWINSZ)
+	if err != nil {
+		return console.WinSize{}, err
+	}
+	return console.WinSize{Height: ws.Row, Width: ws.Col}, nil
+}
+
+func (m *master) Fd() uintptr {
+	return uintptr(m.rawFd())
+}
+
+func (m *master) Name() string {
+	return m.name
+}
+
+// rawFd returns the fd of the master, -1 once closed.
+func (m *master) rawFd() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.fd
+}
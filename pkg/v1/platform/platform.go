@@ -0,0 +1,429 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package platform implements the console handling of the shims. The io
+// of all the consoles of a shim is copied by a single goroutine polling an
+// epoll fd, instead of goroutines blocked on each stream, so that the cost
+// of a terminal doesn't grow with the number of containers of the shim.
+package platform
+
+import (
+	"context"
+	"sync"
+
+	"github.com/containerd/console"
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/containerd/runtime/proc"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// bufferSize is the size of the buffer reads are done into. It is
+	// shared by all the streams since a single goroutine copies them.
+	bufferSize = 32 << 10
+	// maxEvents is the most epoll events handled per wait.
+	maxEvents = 128
+)
+
+// stream copies src to dst. While dst can't take the data read from src,
+// the data is kept in pending and src is not polled.
+type stream struct {
+	src, dst int
+	pending  []byte
+	// draining is set once the console is shut down: src is read until it
+	// has no more data, then the stream is done.
+	draining bool
+	// last is the last byte read from src.
+	last byte
+	// done is called once the stream is done.
+	done func()
+}
+
+// consoleIO are the streams of a console: the output of the console copied
+// to the stdout fifo, and the stdin fifo copied to the console if any.
+type consoleIO struct {
+	console *master
+	out     *stream
+	in      *stream
+	wg      *sync.WaitGroup
+}
+
+// Platform copies the io of consoles with a single poller.
+type Platform struct {
+	epfd int
+	// wake is the pipe written to stop the poller.
+	wake [2]int
+	buf  []byte
+
+	mu       sync.Mutex
+	closed   bool
+	streams  map[int]*stream
+	consoles map[*master]*consoleIO
+}
+
+var _ proc.Platform = &Platform{}
+
+// New returns a platform polling its own epoll fd.
+func New() (*Platform, error) {
+	epfd, err := unix.EpollCreate1(unix.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create epoll fd")
+	}
+	p := &Platform{
+		epfd:     epfd,
+		buf:      make([]byte, bufferSize),
+		streams:  make(map[int]*stream),
+		consoles: make(map[*master]*consoleIO),
+	}
+	if err := unix.Pipe2(p.wake[:], unix.O_NONBLOCK|unix.O_CLOEXEC); err != nil {
+		unix.Close(epfd)
+		return nil, errors.Wrap(err, "failed to create wake pipe")
+	}
+	if err := p.poll(p.wake[0], unix.EPOLLIN); err != nil {
+		p.closeFds()
+		return nil, err
+	}
+	go p.run()
+	return p, nil
+}
+
+// CopyConsole copies the output of the console to the stdout fifo, and the
+// stdin fifo to the console. stderr is not used, terminals have no separate
+// error stream. The console is replaced by the returned one, which must be
+// passed to ShutdownConsole.
+func (p *Platform) CopyConsole(ctx context.Context, cons console.Console, stdin, stdout, stderr string, wg, cwg *sync.WaitGroup) (console.Console, error) {
+	// The fifos are opened read-write so that opening them never blocks,
+	// and the stream doesn't end when containerd reopens them.
+	outFd, err := unix.Open(stdout, unix.O_RDWR|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %s", stdout)
+	}
+	fd, err := unix.FcntlInt(cons.Fd(), unix.F_DUPFD_CLOEXEC, 0)
+	if err != nil {
+		unix.Close(outFd)
+		return nil, errors.Wrap(err, "failed to dup console")
+	}
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		unix.Close(outFd)
+		return nil, errors.Wrap(err, "failed to make console non-blocking")
+	}
+	m := newMaster(fd, cons.Name())
+	cons.Close()
+	c := &consoleIO{
+		console: m,
+		wg:      wg,
+	}
+	c.out = &stream{src: fd, dst: outFd, done: func() { p.closeConsole(c) }}
+	if stdin != "" {
+		inFd, err := unix.Open(stdin, unix.O_RDWR|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+		if err != nil {
+			m.Close()
+			unix.Close(outFd)
+			return nil, errors.Wrapf(err, "failed to open %s", stdin)
+		}
+		// The console is polled for reading by the output stream, so the
+		// input stream writes to a dup of it to be polled separately.
+		consFd, err := unix.FcntlInt(uintptr(fd), unix.F_DUPFD_CLOEXEC, 0)
+		if err != nil {
+			m.Close()
+			unix.Close(inFd)
+			unix.Close(outFd)
+			return nil, errors.Wrap(err, "failed to dup console")
+		}
+		c.in = &stream{src: inFd, dst: consFd}
+		c.in.done = func() { p.closeStream(c.in) }
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		c.closeFifos()
+		m.Close()
+		return nil, errors.New("platform is closed")
+	}
+	wg.Add(1)
+	p.consoles[m] = c
+	for _, s := range []*stream{c.out, c.in} {
+		if s == nil {
+			continue
+		}
+		p.streams[s.src] = s
+		p.streams[s.dst] = s
+		if err := p.poll(s.src, unix.EPOLLIN); err != nil {
+			p.closeConsole(c)
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// ShutdownConsole copies the output left in the console, then closes it
+// and its fifos.
+func (p *Platform) ShutdownConsole(ctx context.Context, cons console.Console) error {
+	m, ok := cons.(*master)
+	if !ok {
+		return errors.Errorf("expected a console of the platform, got %#v", cons)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c := p.consoles[m]
+	if c == nil {
+		// The console was closed when its last user went away.
+		return nil
+	}
+	c.out.draining = true
+	if len(c.out.pending) == 0 {
+		p.drain(c.out)
+	}
+	return nil
+}
+
+// CloseConsoleStdin stops copying the stdin fifo to cons once the input
+// already written to the fifo reached the console, which is when the
+// returned channel is closed. With eof set, the end of file character of
+// the terminal is then written to the console, so that the process reads
+// the end of its input as if a user typed it. The input stays open
+// otherwise, and the process doesn't notice the client went away.
+func (p *Platform) CloseConsoleStdin(ctx context.Context, cons console.Console, eof bool) (<-chan struct{}, error) {
+	m, ok := cons.(*master)
+	if !ok {
+		return nil, errors.Errorf("expected a console of the platform, got %#v", cons)
+	}
+	closed := make(chan struct{})
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c := p.consoles[m]
+	if c == nil || c.in == nil || p.streams[c.in.src] != c.in {
+		close(closed)
+		return closed, nil
+	}
+	s := c.in
+	s.done = func() {
+		if p.streams[s.src] != s {
+			return
+		}
+		if s.draining && eof && p.consoles[m] == c {
+			writeEOF(ctx, s.dst, s.last)
+		}
+		p.closeStream(s)
+		close(closed)
+	}
+	s.draining = true
+	if len(s.pending) == 0 {
+		p.drain(s)
+	}
+	return closed, nil
+}
+
+// writeEOF writes the end of file character of the terminal of fd. In
+// canonical mode, it only ends the input at the start of a line, a line in
+// progress is submitted by a first one. In raw mode, the character is read
+// by the process as is, which shells and editors handle as the end of
+// input.
+func writeEOF(ctx context.Context, fd int, last byte) {
+	eof := []byte{0x04}
+	t, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err == nil {
+		if c := t.Cc[unix.VEOF]; c != 0 {
+			eof[0] = c
+		}
+		if t.Lflag&unix.ICANON != 0 && last != 0 && last != '\n' && last != t.Cc[unix.VEOL] && last != eof[0] {
+			eof = append(eof, eof[0])
+		}
+	}
+	if _, err := unix.Write(fd, eof); err != nil {
+		log.G(ctx).WithError(err).Warn("failed to write the end of stdin to the console")
+	}
+}
+
+// Close stops the poller. Consoles still copied are closed.
+func (p *Platform) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	unix.Write(p.wake[1], []byte{0})
+	return nil
+}
+
+func (p *Platform) run() {
+	events := make([]unix.EpollEvent, maxEvents)
+	for {
+		n, err := unix.EpollWait(p.epfd, events, -1)
+		if err != nil {
+			if err == unix.EINTR {
+				continue
+			}
+			log.L.WithError(err).Error("console poller failed")
+			p.Close()
+		}
+		p.mu.Lock()
+		if p.closed {
+			for _, c := range p.consoles {
+				p.closeConsole(c)
+			}
+			p.closeFds()
+			p.mu.Unlock()
+			return
+		}
+		for _, e := range events[:n] {
+			fd := int(e.Fd)
+			// The stream may have been closed, and its fd reused, since
+			// the wait returned. Spurious events are harmless since the
+			// fds are non-blocking.
+			s := p.streams[fd]
+			if s == nil {
+				continue
+			}
+			switch fd {
+			case s.src:
+				p.read(s)
+			case s.dst:
+				p.flush(s)
+			}
+		}
+		p.mu.Unlock()
+	}
+}
+
+// read copies the data available in the source of s, and reports whether
+// it may have more.
+func (p *Platform) read(s *stream) bool {
+	n, err := unix.Read(s.src, p.buf)
+	if err == unix.EAGAIN || err == unix.EINTR {
+		return false
+	}
+	if n <= 0 {
+		// The console returns EIO once its last user is gone.
+		s.done()
+		return false
+	}
+	s.last = p.buf[n-1]
+	written, err := unix.Write(s.dst, p.buf[:n])
+	if err == unix.EAGAIN {
+		written, err = 0, nil
+	}
+	if err != nil {
+		s.done()
+		return false
+	}
+	if written < n {
+		s.pending = append(s.pending[:0], p.buf[written:n]...)
+		p.unpoll(s.src)
+		if err := p.poll(s.dst, unix.EPOLLOUT); err != nil {
+			s.done()
+		}
+		return false
+	}
+	return true
+}
+
+// flush writes the pending data of s, and resumes polling its source once
+// it is written.
+func (p *Platform) flush(s *stream) {
+	n, err := unix.Write(s.dst, s.pending)
+	if err == unix.EAGAIN {
+		return
+	}
+	if err != nil {
+		s.done()
+		return
+	}
+	if s.pending = s.pending[n:]; len(s.pending) > 0 {
+		return
+	}
+	s.pending = nil
+	p.unpoll(s.dst)
+	if s.draining {
+		p.drain(s)
+		return
+	}
+	if err := p.poll(s.src, unix.EPOLLIN); err != nil {
+		s.done()
+	}
+}
+
+// drain copies the data left in the source of a draining stream, and ends
+// it once the source has no more.
+func (p *Platform) drain(s *stream) {
+	for p.read(s) {
+	}
+	if p.streams[s.src] == s && len(s.pending) == 0 {
+		s.done()
+	}
+}
+
+func (p *Platform) poll(fd int, events uint32) error {
+	e := unix.EpollEvent{Events: events, Fd: int32(fd)}
+	if err := unix.EpollCtl(p.epfd, unix.EPOLL_CTL_ADD, fd, &e); err != nil {
+		return errors.Wrapf(err, "failed to poll fd %d", fd)
+	}
+	return nil
+}
+
+func (p *Platform) unpoll(fd int) {
+	unix.EpollCtl(p.epfd, unix.EPOLL_CTL_DEL, fd, nil)
+}
+
+// closeStream stops polling s. Its fds are closed, except the console
+// itself which is closed with closeConsole.
+func (p *Platform) closeStream(s *stream) {
+	if p.streams[s.src] != s {
+		return
+	}
+	p.unpoll(s.src)
+	p.unpoll(s.dst)
+	delete(p.streams, s.src)
+	delete(p.streams, s.dst)
+	s.pending = nil
+}
+
+// closeConsole closes the streams and fds of c, and releases the waiters
+// of its output.
+func (p *Platform) closeConsole(c *consoleIO) {
+	if p.consoles[c.console] != c {
+		return
+	}
+	delete(p.consoles, c.console)
+	p.closeStream(c.out)
+	if c.in != nil {
+		c.in.done()
+	}
+	c.closeFifos()
+	if err := c.console.Close(); err != nil {
+		log.L.WithError(err).Debug("failed to close console")
+	}
+	c.wg.Done()
+}
+
+// closeFifos closes the fds opened for c.
+func (c *consoleIO) closeFifos() {
+	unix.Close(c.out.dst)
+	if c.in != nil {
+		unix.Close(c.in.src)
+		unix.Close(c.in.dst)
+	}
+}
+
+func (p *Platform) closeFds() {
+	unix.Close(p.wake[0])
+	unix.Close(p.wake[1])
+	unix.Close(p.epfd)
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/log"
+	runc "github.com/containerd/go-runc"
+
+	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/utils"
+)
+
+// maxSocketPath is the longest path of a unix socket.
+const maxSocketPath = 107
+
+// newConsoleSocket creates the console socket of a process in dir, so that a
+// socket left behind by a crashed shim is removed with the work directory.
+// A temporary directory is used when dir is empty or too deep for a socket.
+func newConsoleSocket(dir, name string) (*runc.Socket, error) {
+	path := filepath.Join(dir, name+".pty.sock")
+	if dir == "" || len(path) > maxSocketPath {
+		return runc.NewTempConsoleSocket()
+	}
+	os.Remove(path)
+	return runc.NewConsoleSocket(path)
+}
+
+// removeArtifacts removes the files the container leaves behind once it is
+// deleted: the work directory, or its contents when the directory is owned
+// by containerd, and the runsc debug and user logs when they are specific
+// to the container. Logs shared between containers and collected crash
+// logs are kept. It returns the removed paths.
+func (p *Init) removeArtifacts(ctx context.Context) []string {
+	var paths []string
+	remove := func(path string) {
+		if size, err := utils.DiskUsage(path); err == nil {
+			log.G(ctx).WithField("bytes", size).Debugf("Removing %q of container %q", path, p.id)
+		}
+		if err := os.RemoveAll(path); err != nil {
+			log.G(ctx).WithError(err).Warnf("Failed to remove %q", path)
+			return
+		}
+		paths = append(paths, path)
+	}
+	if p.WorkDir != "" {
+		// Collected crash logs are kept, and with them the work directory.
+		_, err := os.Stat(filepath.Join(p.WorkDir, crashDir))
+		crashed := err == nil
+		if p.CleanupWorkDir && !crashed {
+			remove(p.WorkDir)
+		} else if entries, err := ioutil.ReadDir(p.WorkDir); err == nil {
+			for _, e := range entries {
+				if e.Name() != crashDir {
+					remove(filepath.Join(p.WorkDir, e.Name()))
+				}
+			}
+		}
+	}
+	if dir := runsc.DebugLogDir(p.runtime.Flags()); dir != "" && filepath.Base(dir) == p.id {
+		remove(dir)
+	}
+	if p.UserLog != "" && strings.Contains(filepath.Base(p.UserLog), p.id) {
+		remove(p.UserLog)
+	}
+	return paths
+}
+
+// RemovedPaths returns the paths removed when the container was deleted.
+func (p *Init) RemovedPaths() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.removed
+}
@@ -0,0 +1,90 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/pkg/errors"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/cgroup"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/utils"
+)
+
+// applyCgroupV2 places the sandbox and its gofers in the cgroupfs
+// cgroupsPath of the spec on unified hosts, with the resources of the spec
+// translated to the v2 controllers. runsc only manages v1 cgroups, so
+// without it the sandbox would run unconstrained in the cgroup of the shim.
+// Subcontainers run in the cgroup of their sandbox, and systemd paths are
+// left to the scope of the sandbox.
+func (p *Init) applyCgroupV2(ctx context.Context) error {
+	if !p.Sandbox || p.unsandboxed || p.scope != nil || cgroup.DetectMode() != cgroup.Unified {
+		return nil
+	}
+	spec, err := utils.ReadSpec(p.Bundle)
+	if err != nil {
+		return errors.Wrap(err, "read oci spec")
+	}
+	if spec.Linux == nil || spec.Linux.CgroupsPath == "" || cgroup.PathDriver(spec.Linux.CgroupsPath) != cgroup.Cgroupfs {
+		return nil
+	}
+	cg, err := cgroup.NewV2(spec.Linux.CgroupsPath, spec.Linux.Resources)
+	if err != nil {
+		return errors.Wrapf(errdefs.ErrFailedPrecondition, "failed to create cgroup of the sandbox: %v", err)
+	}
+	for _, pid := range append([]int{p.pid}, goferPids(p.Bundle)...) {
+		if err := cg.Add(pid); err != nil {
+			cg.Delete()
+			return errors.Wrapf(errdefs.ErrFailedPrecondition, "failed to move the sandbox to its cgroup: %v", err)
+		}
+	}
+	p.cgroupV2 = cg
+	return nil
+}
+
+// deleteCgroupV2 removes the cgroup created by applyCgroupV2, once the
+// sandbox exited.
+func (p *Init) deleteCgroupV2(ctx context.Context) {
+	if p.cgroupV2 == nil {
+		return
+	}
+	if err := p.cgroupV2.Delete(); err != nil {
+		log.G(ctx).WithError(err).Warnf("Failed to remove cgroup %s", p.cgroupV2.Path())
+	}
+	p.cgroupV2 = nil
+}
+
+// leaveShimCgroup moves a sandbox without a cgroup of its own, and its
+// gofers, out of the cgroup of the shim, which runsc left them in.
+func (p *Init) leaveShimCgroup(ctx context.Context) {
+	if !p.Sandbox || p.LeaveShimCgroup == nil {
+		return
+	}
+	spec, err := utils.ReadSpec(p.Bundle)
+	if err != nil {
+		log.G(ctx).WithError(err).Warn("Failed to read the OCI spec")
+		return
+	}
+	if spec.Linux != nil && spec.Linux.CgroupsPath != "" {
+		return
+	}
+	if err := p.LeaveShimCgroup(append([]int{p.pid}, goferPids(p.Bundle)...)...); err != nil {
+		log.G(ctx).WithError(err).Warn("Failed to move the sandbox out of the shim cgroup")
+	}
+}
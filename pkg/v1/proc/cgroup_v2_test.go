@@ -0,0 +1,71 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestLeaveShimCgroup(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		sandbox     bool
+		cgroupsPath string
+		want        []int
+	}{
+		{name: "sandbox without cgroup", sandbox: true, want: []int{42}},
+		{name: "sandbox with cgroup", sandbox: true, cgroupsPath: "/pods/pod"},
+		{name: "subcontainer"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			bundle, err := ioutil.TempDir("", "cgroup-test-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(bundle)
+			data, err := json.Marshal(&specs.Spec{Linux: &specs.Linux{CgroupsPath: tc.cgroupsPath}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(bundle, "config.json"), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			var moved []int
+			p := &Init{
+				Bundle:  bundle,
+				Sandbox: tc.sandbox,
+				pid:     42,
+				LeaveShimCgroup: func(pids ...int) error {
+					moved = append(moved, pids...)
+					return nil
+				},
+			}
+			p.leaveShimCgroup(context.Background())
+			if !reflect.DeepEqual(moved, tc.want) {
+				t.Errorf("moved %v out of the shim cgroup, expected %v", moved, tc.want)
+			}
+		})
+	}
+}
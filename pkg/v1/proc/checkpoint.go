@@ -0,0 +1,165 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	runc "github.com/containerd/go-runc"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/checkpoint"
+	"github.com/google/gvisor-containerd-shim/pkg/version"
+)
+
+// checkpoint saves the state of the container with runsc checkpoint, then
+// stores the image in r.Path in the transferable checkpoint layout.
+func (p *Init) checkpoint(ctx context.Context, r *CheckpointConfig) error {
+	runscVersion, err := p.runtime.Version(ctx)
+	if err != nil {
+		return p.runtimeError(err, "OCI runtime version failed")
+	}
+	// runsc writes the image to the work directory, it is then compressed
+	// into r.Path.
+	if err := os.MkdirAll(p.WorkDir, 0700); err != nil {
+		return errors.Wrap(err, "create checkpoint work directory")
+	}
+	raw, err := ioutil.TempDir(p.WorkDir, "checkpoint-")
+	if err != nil {
+		return errors.Wrap(err, "create checkpoint image directory")
+	}
+	defer os.RemoveAll(raw)
+	start := time.Now()
+	if err := p.runtime.Checkpoint(ctx, p.id, &runsc.CheckpointOpts{
+		ImagePath:    raw,
+		LeaveRunning: !r.Exit,
+	}); err != nil {
+		return p.runtimeError(err, "OCI runtime checkpoint failed")
+	}
+	saved := time.Now()
+	m, err := checkpoint.Pack(raw, r.Path, checkpoint.Manifest{
+		ContainerID: p.id,
+		CreatedAt:   saved,
+		Runsc:       runscVersion,
+		Shim:        version.Version,
+		Compression: p.CheckpointCompression,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to store checkpoint")
+	}
+	log.G(ctx).WithFields(logrus.Fields{
+		"path":        r.Path,
+		"files":       len(m.Files),
+		"compression": m.Compression,
+		"checkpoint":  saved.Sub(start),
+		"store":       time.Since(saved),
+	}).Infof("Checkpointed container %q", p.id)
+	return nil
+}
+
+// unpackCheckpoint checks that the checkpoint at path can be restored by the
+// runtime of the container, and decompresses its image into the work
+// directory, where runsc restores it from on start.
+func (p *Init) unpackCheckpoint(ctx context.Context, path string) error {
+	start := time.Now()
+	runscVersion, err := p.runtime.Version(ctx)
+	if err != nil {
+		return p.runtimeError(err, "OCI runtime version failed")
+	}
+	if err := os.MkdirAll(p.WorkDir, 0700); err != nil {
+		return errors.Wrap(err, "create restore work directory")
+	}
+	raw, err := ioutil.TempDir(p.WorkDir, "restore-")
+	if err != nil {
+		return errors.Wrap(err, "create restore image directory")
+	}
+	m, err := checkpoint.Unpack(path, raw, runscVersion)
+	if err != nil {
+		os.RemoveAll(raw)
+		return errors.Wrapf(err, "failed to restore checkpoint %s", path)
+	}
+	log.G(ctx).WithFields(logrus.Fields{
+		"path":      path,
+		"container": m.ContainerID,
+		"taken":     m.CreatedAt,
+	}).Debugf("Restoring container %q from checkpoint", p.id)
+	p.restoreImage = raw
+	p.RecordPhase(PhaseCheckpointUnpack, start, time.Now())
+	return nil
+}
+
+// restore creates and starts the container from its checkpoint with runsc
+// restore, with the options it would have been created with. The image is
+// removed once restored.
+func (p *Init) restore(ctx context.Context) error {
+	opts := p.restoreOpts
+	opts.ImagePath = p.restoreImage
+	if p.stdio.Terminal {
+		socket, err := newConsoleSocket(p.WorkDir, p.id)
+		if err != nil {
+			return errors.Wrap(err, "failed to create OCI runtime console socket")
+		}
+		defer socket.Close()
+		opts.ConsoleSocket = socket
+	} else {
+		opts.IO = p.io
+	}
+	start := time.Now()
+	if err := p.runtime.Restore(ctx, p.id, p.Bundle, opts); err != nil {
+		return p.runtimeError(err, "OCI runtime restore failed")
+	}
+	p.RecordPhase(PhaseRuntimeRestore, start, time.Now())
+	if socket, ok := opts.ConsoleSocket.(*runc.Socket); ok {
+		if err := p.copyRestoredConsole(ctx, socket); err != nil {
+			return err
+		}
+	}
+	if err := p.created(ctx); err != nil {
+		return err
+	}
+	os.RemoveAll(p.restoreImage)
+	p.restoreImage = ""
+	p.restoreOpts = nil
+	return nil
+}
+
+// copyRestoredConsole copies the console runsc restore sent to socket to
+// the stdio of the container.
+func (p *Init) copyRestoredConsole(ctx context.Context, socket *runc.Socket) error {
+	ctx, cancel := context.WithTimeout(ctx, p.ioTimeout())
+	defer cancel()
+	console, err := socket.ReceiveMaster()
+	if err != nil {
+		return errors.Wrap(err, "failed to retrieve console master")
+	}
+	var copyWaitGroup sync.WaitGroup
+	console, err = p.Platform.CopyConsole(ctx, console, stdinFifo(p.stdio.Stdin), p.stdio.Stdout, p.stdio.Stderr, &p.wg, &copyWaitGroup)
+	if err != nil {
+		return errors.Wrap(err, "failed to start console copy")
+	}
+	copyWaitGroup.Wait()
+	p.console = console
+	closeWhenCopied(&p.wg, p.ioDone)
+	return nil
+}
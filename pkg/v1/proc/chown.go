@@ -0,0 +1,141 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+
+	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
+)
+
+// ChownVolumesAnnotation lists the volumes, by destination and separated by
+// commas, whose ownership is given to the user of the container before its
+// entrypoint runs. "*" selects all the writable bind mounts of directories.
+const ChownVolumesAnnotation = "dev.gvisor.chown-volumes"
+
+// PhaseVolumeChown is the ownership fixup of the volumes of the container.
+const PhaseVolumeChown = "volume-chown"
+
+// helperPath is the PATH the helpers run in the container are run with.
+const helperPath = "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// chownPlan are the volumes given to the user of the container.
+type chownPlan struct {
+	uid, gid uint32
+	volumes  []string
+}
+
+// planChown returns the volume ownership fixups requested by the spec, nil
+// if there are none.
+func planChown(spec *specs.Spec) (*chownPlan, error) {
+	v, ok := spec.Annotations[ChownVolumesAnnotation]
+	if !ok || spec.Process == nil {
+		return nil, nil
+	}
+	user := spec.Process.User
+	if user.UID == 0 && user.GID == 0 {
+		// Volumes are already owned by root.
+		return nil, nil
+	}
+	plan := &chownPlan{uid: user.UID, gid: user.GID}
+	if strings.TrimSpace(v) == "*" {
+		for _, m := range spec.Mounts {
+			if chownable(m) {
+				plan.volumes = append(plan.volumes, m.Destination)
+			}
+		}
+	} else {
+		mounts := make(map[string]specs.Mount, len(spec.Mounts))
+		for _, m := range spec.Mounts {
+			mounts[filepath.Clean(m.Destination)] = m
+		}
+		for _, d := range strings.Split(v, ",") {
+			if d = strings.TrimSpace(d); d == "" {
+				continue
+			}
+			m, ok := mounts[filepath.Clean(d)]
+			if !ok {
+				return nil, errors.Wrapf(errdefs.ErrInvalidArgument, "%s annotation names %q, which is not a mount of the container", ChownVolumesAnnotation, d)
+			}
+			if hasOption(m.Options, "ro") {
+				return nil, errors.Wrapf(errdefs.ErrInvalidArgument, "%s annotation names %q, which is read-only", ChownVolumesAnnotation, d)
+			}
+			plan.volumes = append(plan.volumes, m.Destination)
+		}
+	}
+	if len(plan.volumes) == 0 {
+		return nil, nil
+	}
+	return plan, nil
+}
+
+// chownable returns whether m is a volume selected by "*": a writable bind
+// mount of a directory outside the kernel filesystems.
+func chownable(m specs.Mount) bool {
+	if m.Type != "bind" && !hasOption(m.Options, "bind") && !hasOption(m.Options, "rbind") {
+		return false
+	}
+	if hasOption(m.Options, "ro") {
+		return false
+	}
+	for _, dir := range []string{"/proc", "/sys", "/dev"} {
+		if m.Destination == dir || strings.HasPrefix(m.Destination, dir+"/") {
+			return false
+		}
+	}
+	// Files such as /etc/hosts are bound by the runtime, not volumes.
+	fi, err := os.Stat(m.Source)
+	return err == nil && fi.IsDir()
+}
+
+func hasOption(options []string, o string) bool {
+	for _, opt := range options {
+		if opt == o {
+			return true
+		}
+	}
+	return false
+}
+
+// chownVolumes runs the chown helper in the created container, as root, to
+// give its volumes to the user of the container. runsc can exec in a
+// created container, so the fixup is done before the entrypoint runs.
+func (p *Init) chownVolumes(ctx context.Context) error {
+	start := time.Now()
+	args := append([]string{p.ChownHelper, "-R", fmt.Sprintf("%d:%d", p.chown.uid, p.chown.gid)}, p.chown.volumes...)
+	spec := specs.Process{
+		Args: args,
+		Env:  []string{helperPath},
+		Cwd:  "/",
+	}
+	log.G(ctx).WithField("volumes", p.chown.volumes).Debugf("Giving volumes of container %q to %d:%d", p.id, p.chown.uid, p.chown.gid)
+	if err := p.runtime.Exec(ctx, p.id, spec, &runsc.ExecOpts{}); err != nil {
+		return errors.Wrapf(errdefs.ErrFailedPrecondition, "failed to chown volumes %s: %v", strings.Join(p.chown.volumes, ", "), err)
+	}
+	p.RecordPhase(PhaseVolumeChown, start, time.Now())
+	return nil
+}
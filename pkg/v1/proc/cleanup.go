@@ -0,0 +1,121 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd/log"
+	runc "github.com/containerd/go-runc"
+	"golang.org/x/sys/unix"
+
+	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
+)
+
+// ShimPidFile is the name of the file in the bundle that contains the pid of
+// the shim owning the container.
+const ShimPidFile = "shim.pid"
+
+// CleanupOrphans force deletes the containers under the runsc root whose
+// bundle no longer exists or whose shim died, along with their rootfs mounts
+// and work directories. It returns the ids of the deleted containers.
+func CleanupOrphans(ctx context.Context, r *runsc.Runsc) ([]string, error) {
+	containers, err := r.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var deleted []string
+	for _, c := range containers {
+		if !isOrphan(c) {
+			continue
+		}
+		logger := log.G(ctx).WithField("id", c.ID).WithField("bundle", c.Bundle)
+		logger.Info("cleaning up orphaned sandbox")
+		if err := ForceDelete(ctx, r, c); err != nil {
+			logger.WithError(err).Warn("failed to delete orphaned sandbox")
+			continue
+		}
+		deleted = append(deleted, c.ID)
+	}
+	return deleted, nil
+}
+
+// ForceDelete force deletes the container c, then unmounts its rootfs and
+// copy-on-write mounts and removes its work directory. Failures of the
+// latter are only logged.
+func ForceDelete(ctx context.Context, r *runsc.Runsc, c *runc.Container) error {
+	if err := r.Delete(ctx, c.ID, &runsc.DeleteOpts{
+		Force: true,
+	}); err != nil && !runsc.IsNotFound(err) {
+		return err
+	}
+	if c.Bundle == "" {
+		return nil
+	}
+	logger := log.G(ctx).WithField("id", c.ID).WithField("bundle", c.Bundle)
+	if err := UnmountRootfs(filepath.Join(c.Bundle, "rootfs"), MountConfig{}); err != nil {
+		logger.WithError(err).Warn("failed to cleanup rootfs mount")
+	}
+	if err := removeCOWMounts(c.Bundle, MountConfig{}); err != nil {
+		logger.WithError(err).Warn("failed to remove copy-on-write mounts")
+	}
+	if err := removeWorkDir(c.Bundle); err != nil {
+		logger.WithError(err).Warn("failed to remove work dir")
+	}
+	return nil
+}
+
+// isOrphan returns true if the bundle of the container is gone or the shim
+// recorded in it is no longer running.
+func isOrphan(c *runc.Container) bool {
+	if c.Bundle == "" {
+		return false
+	}
+	if _, err := os.Stat(c.Bundle); os.IsNotExist(err) {
+		return true
+	}
+	pid, err := runc.ReadPidFile(filepath.Join(c.Bundle, ShimPidFile))
+	if err != nil {
+		// Without a shim pid we can't tell, leave the container alone.
+		return false
+	}
+	return unix.Kill(pid, 0) == unix.ESRCH
+}
+
+// WorkDir returns the work directory the bundle "work" link points to,
+// empty if there is none.
+func WorkDir(bundle string) (string, error) {
+	target, err := os.Readlink(filepath.Join(bundle, "work"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return target, nil
+}
+
+// removeWorkDir removes the work directory the bundle "work" link points to.
+func removeWorkDir(bundle string) error {
+	work, err := WorkDir(bundle)
+	if err != nil || work == "" {
+		return err
+	}
+	return os.RemoveAll(work)
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	units "github.com/docker/go-units"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/compat"
+)
+
+// COWMountsAnnotation lists the bind mounts of directories, by destination
+// and separated by commas, that the container can write to without changing
+// their source, e.g. read-only config directories applications insist on
+// writing to. Each is overlaid with a tmpfs holding the writes, discarded
+// when the container is deleted. A destination can be followed by =<size>
+// to size its tmpfs, e.g. "/etc/app=16m", 64m otherwise.
+const COWMountsAnnotation = "dev.gvisor.cow-mounts"
+
+// defaultCOWSize is the size of the tmpfs of a copy-on-write mount.
+const defaultCOWSize = 64 << 20
+
+// cowDir is the directory of the bundle the copy-on-write mounts are made
+// in.
+const cowDir = "cow"
+
+// setupCOWMounts overlays the mounts named by the COWMountsAnnotation of
+// the spec in bundle, and points the mounts of the spec at the overlays.
+// The overlays are removed with removeCOWMounts.
+func setupCOWMounts(bundle string, c MountConfig) (err error) {
+	spec, err := compat.ReadRawSpec(bundle)
+	if err != nil {
+		return errors.Wrap(err, "read oci spec")
+	}
+	annotations, _ := spec["annotations"].(map[string]interface{})
+	v, _ := annotations[COWMountsAnnotation].(string)
+	if strings.TrimSpace(v) == "" {
+		return nil
+	}
+	dir := filepath.Join(bundle, cowDir)
+	if _, err := os.Stat(dir); err == nil {
+		return errors.Wrapf(errdefs.ErrAlreadyExists, "copy-on-write mounts of %s", bundle)
+	}
+	mounts, _ := spec["mounts"].([]interface{})
+	index := make(map[string]map[string]interface{}, len(mounts))
+	for _, m := range mounts {
+		if mm, ok := m.(map[string]interface{}); ok {
+			d, _ := mm["destination"].(string)
+			index[path.Clean(d)] = mm
+		}
+	}
+	defer func() {
+		if err != nil {
+			removeCOWMounts(bundle, c)
+		}
+	}()
+	for i, entry := range strings.Split(v, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		dest, size, err := parseCOWMount(entry)
+		if err != nil {
+			return err
+		}
+		m, ok := index[path.Clean(dest)]
+		if !ok {
+			return errors.Wrapf(errdefs.ErrInvalidArgument, "%s annotation names %q, which is not a mount of the container", COWMountsAnnotation, dest)
+		}
+		source, _ := m["source"].(string)
+		options := stringOptions(m["options"])
+		if t, _ := m["type"].(string); t != "bind" && !hasOption(options, "bind") && !hasOption(options, "rbind") {
+			return errors.Wrapf(errdefs.ErrInvalidArgument, "%s annotation names %q, which is not a bind mount", COWMountsAnnotation, dest)
+		}
+		if fi, err := os.Stat(source); err != nil || !fi.IsDir() {
+			return errors.Wrapf(errdefs.ErrInvalidArgument, "%s annotation names %q, which is not a mount of a directory", COWMountsAnnotation, dest)
+		}
+		merged, err := mountCOW(filepath.Join(dir, strconv.Itoa(i)), source, size)
+		if err != nil {
+			return errors.Wrapf(err, "copy-on-write mount of %s", dest)
+		}
+		m["source"] = merged
+		rw := []interface{}{"rw"}
+		for _, o := range options {
+			if o != "ro" && o != "rw" {
+				rw = append(rw, o)
+			}
+		}
+		m["options"] = rw
+	}
+	return compat.WriteRawSpec(bundle, spec)
+}
+
+// parseCOWMount parses an entry of the COWMountsAnnotation.
+func parseCOWMount(entry string) (string, int64, error) {
+	parts := strings.SplitN(entry, "=", 2)
+	if len(parts) == 1 {
+		return entry, defaultCOWSize, nil
+	}
+	size, err := units.RAMInBytes(parts[1])
+	if err != nil || size <= 0 {
+		return "", 0, errors.Wrapf(errdefs.ErrInvalidArgument, "invalid size %q of %s in the %s annotation", parts[1], parts[0], COWMountsAnnotation)
+	}
+	return parts[0], size, nil
+}
+
+// mountCOW mounts a tmpfs of size at dir, and an overlay of it over source,
+// whose path it returns.
+func mountCOW(dir, source string, size int64) (string, error) {
+	if strings.ContainsAny(source, ",:") {
+		return "", errors.Wrapf(errdefs.ErrInvalidArgument, "source %q can't be the lower directory of an overlay", source)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	if err := unix.Mount("tmpfs", dir, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, fmt.Sprintf("size=%d,mode=0700", size)); err != nil {
+		return "", errors.Wrap(err, "mount tmpfs")
+	}
+	upper, work, merged := filepath.Join(dir, "upper"), filepath.Join(dir, "work"), filepath.Join(dir, "merged")
+	for _, d := range []string{upper, work, merged} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			return "", err
+		}
+	}
+	data := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", source, upper, work)
+	if err := unix.Mount("overlay", merged, "overlay", 0, data); err != nil {
+		return "", errors.Wrap(err, "mount overlay")
+	}
+	return merged, nil
+}
+
+// removeCOWMounts unmounts the copy-on-write mounts of bundle, discarding
+// what was written to them.
+func removeCOWMounts(bundle string, c MountConfig) error {
+	dir := filepath.Join(bundle, cowDir)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+	if err := UnmountRootfs(dir, c); err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+func stringOptions(v interface{}) []string {
+	l, _ := v.([]interface{})
+	out := make([]string, 0, len(l))
+	for _, o := range l {
+		if s, ok := o.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/containerd/containerd/log"
+
+	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+const (
+	// crashDir is the directory of the work directory the logs of crashed
+	// sandboxes are copied to. It is kept when the container is deleted.
+	crashDir = "crash"
+	// crashLogTail is how much of the end of each debug log is searched for
+	// a crash.
+	crashLogTail = 256 << 10
+	// maxCrashExcerpt bounds the crash excerpt published in the event.
+	maxCrashExcerpt = 4 << 10
+)
+
+// crashMarkers start the reports of runsc and sentry crashes in debug logs.
+var crashMarkers = []string{
+	"panic: ",
+	"fatal error: ",
+	"Sentry detected",
+	"unexpected signal during runtime execution",
+}
+
+// CrashReport returns a report of the crash of the sandbox, or nil if the
+// container exited normally. A crash is a panic in the runsc debug logs of
+// the container, or a sandbox that died without reporting an exit status.
+// With CollectCrashLogs, the log showing the crash is copied to the work
+// directory.
+func (p *Init) CrashReport(ctx context.Context) *runsctypes.CrashReport {
+	status := p.ExitStatus()
+	path, excerpt := p.findCrash()
+	if path == "" && status != internalErrorCode {
+		return nil
+	}
+	r := &runsctypes.CrashReport{
+		ContainerID: p.id,
+		ExitStatus:  uint32(status),
+		Log:         path,
+		Excerpt:     excerpt,
+		Timestamp:   time.Now(),
+	}
+	if p.CollectCrashLogs && path != "" && p.WorkDir != "" {
+		dst := filepath.Join(p.WorkDir, crashDir, filepath.Base(path))
+		if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+			log.G(ctx).WithError(err).Warnf("Failed to create crash log directory of container %q", p.id)
+		} else if err := copyFile(dst, path); err != nil {
+			log.G(ctx).WithError(err).Warnf("Failed to copy crash log of container %q", p.id)
+		} else {
+			r.Copy = dst
+		}
+	}
+	return r
+}
+
+// findCrash returns the debug log showing the crash of the sandbox and the
+// crash report in it, empty if none do. The logs are only searched once.
+func (p *Init) findCrash() (string, string) {
+	p.crash.once.Do(func() {
+		p.crash.path, p.crash.excerpt = findMarkers(p.debugLogs(), crashMarkers)
+	})
+	return p.crash.path, p.crash.excerpt
+}
+
+// debugLogs returns the runsc debug logs of the container.
+func (p *Init) debugLogs() []string {
+	return runsc.DebugLogFiles(p.runtime.Flags())
+}
+
+// findMarkers returns the first log showing one of markers, and the report
+// starting at the line of the marker truncated to maxCrashExcerpt.
+func findMarkers(logs []string, markers []string) (string, string) {
+	for _, path := range logs {
+		tail, err := readTail(path, crashLogTail)
+		if err != nil {
+			continue
+		}
+		for _, marker := range markers {
+			i := bytes.Index(tail, []byte(marker))
+			if i < 0 {
+				continue
+			}
+			// Start at the beginning of the line of the marker.
+			if j := bytes.LastIndexByte(tail[:i], '\n'); j >= 0 {
+				i = j + 1
+			} else {
+				i = 0
+			}
+			excerpt := tail[i:]
+			if len(excerpt) > maxCrashExcerpt {
+				excerpt = excerpt[:maxCrashExcerpt]
+			}
+			return path, string(excerpt)
+		}
+	}
+	return "", ""
+}
+
+// readTail reads up to n bytes from the end of the file at path.
+func readTail(path string, n int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if off := st.Size() - n; off > 0 {
+		if _, err := f.Seek(off, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	return ioutil.ReadAll(f)
+}
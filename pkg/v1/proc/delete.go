@@ -0,0 +1,92 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+	"strconv"
+	"syscall"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+
+	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
+)
+
+// ForceDeleteAnnotation makes Delete force delete the container right away,
+// even while it is running, "true" or "false". Without it, a container is
+// only force deleted when runsc delete fails or times out, e.g. on a wedged
+// sentry.
+const ForceDeleteAnnotation = "dev.gvisor.force-delete"
+
+// forceDeleteRunning force deletes a running or paused container. The exit
+// of a wedged sandbox may never be seen, the container is reported killed.
+func (p *Init) forceDeleteRunning(ctx context.Context) error {
+	if err := p.delete(ctx); err != nil {
+		return err
+	}
+	p.initState.SetExited(SignalExitStatus(syscall.SIGKILL))
+	return p.transition(Deleted)
+}
+
+// forceDeleteRequested returns whether the spec requests force delete.
+func forceDeleteRequested(spec *specs.Spec) (bool, error) {
+	v, ok := spec.Annotations[ForceDeleteAnnotation]
+	if !ok {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, errors.Wrapf(errdefs.ErrInvalidArgument, "invalid %s annotation %q", ForceDeleteAnnotation, v)
+	}
+	return b, nil
+}
+
+// deleteRuntime deletes the container in runsc, force deleting it if
+// requested or if runsc delete fails. It reports whether the container was
+// force deleted.
+func (p *Init) deleteRuntime(ctx context.Context) (forced bool, err error) {
+	if !p.forceDelete {
+		err := p.runtime.Delete(ctx, p.id, nil)
+		// ignore errors if a runtime has already deleted the process
+		// but we still hold metadata and pipes
+		//
+		// this is common during a checkpoint, runc will delete the container state
+		// after a checkpoint and the container will no longer exist within runc
+		if err == nil || runsc.IsNotFound(err) {
+			return false, nil
+		}
+		log.G(ctx).WithError(err).Warnf("Failed to delete container %q, force deleting it", p.id)
+	}
+	return true, p.forceDeleteRuntime()
+}
+
+// forceDeleteRuntime runs runsc delete --force, which kills the sandbox
+// without going through the sentry, and kills the gofers left behind. The
+// context of the request has usually expired by then.
+func (p *Init) forceDeleteRuntime() error {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	err := p.runtime.Delete(ctx, p.id, &runsc.DeleteOpts{Force: true})
+	if runsc.IsNotFound(err) {
+		err = nil
+	}
+	p.killGofers(ctx)
+	return p.runtimeError(err, "failed to force delete task")
+}
@@ -21,6 +21,7 @@ import (
 	"context"
 
 	"github.com/containerd/console"
+	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/runtime/proc"
 	"github.com/pkg/errors"
 )
@@ -29,19 +30,31 @@ type deletedState struct {
 }
 
 func (s *deletedState) Resize(ws console.WinSize) error {
-	return errors.Errorf("cannot resize a deleted process")
+	return errors.Wrap(errdefs.ErrFailedPrecondition, "cannot resize a deleted process")
 }
 
 func (s *deletedState) Start(ctx context.Context) error {
-	return errors.Errorf("cannot start a deleted process")
+	return errors.Wrap(errdefs.ErrFailedPrecondition, "cannot start a deleted process")
 }
 
 func (s *deletedState) Delete(ctx context.Context) error {
-	return errors.Errorf("cannot delete a deleted process")
+	return errors.Wrap(errdefs.ErrFailedPrecondition, "cannot delete a deleted process")
+}
+
+func (s *deletedState) Pause(ctx context.Context) error {
+	return errors.Wrap(errdefs.ErrFailedPrecondition, "cannot pause a deleted process")
+}
+
+func (s *deletedState) Resume(ctx context.Context) error {
+	return errors.Wrap(errdefs.ErrFailedPrecondition, "cannot resume a deleted process")
 }
 
 func (s *deletedState) Kill(ctx context.Context, sig uint32, all bool) error {
-	return errors.Errorf("cannot kill a deleted process")
+	return errors.Wrap(errdefs.ErrFailedPrecondition, "cannot kill a deleted process")
+}
+
+func (s *deletedState) Checkpoint(ctx context.Context, r *CheckpointConfig) error {
+	return errors.Wrap(errdefs.ErrFailedPrecondition, "cannot checkpoint a deleted process")
 }
 
 func (s *deletedState) SetExited(status int) {
@@ -49,5 +62,5 @@ func (s *deletedState) SetExited(status int) {
 }
 
 func (s *deletedState) Exec(ctx context.Context, path string, r *ExecConfig) (proc.Process, error) {
-	return nil, errors.Errorf("cannot exec in a deleted state")
+	return nil, errors.Wrap(errdefs.ErrFailedPrecondition, "cannot exec in a deleted state")
 }
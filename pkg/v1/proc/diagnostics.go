@@ -0,0 +1,207 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// ptraceScopeFile is the Yama ptrace scope of the host.
+const ptraceScopeFile = "/proc/sys/kernel/yama/ptrace_scope"
+
+// Diagnostics returns what debugging tools need to attach to the sandbox of
+// the container.
+func (p *Init) Diagnostics(ctx context.Context) (*runsctypes.Diagnostics, error) {
+	if !p.Sandbox || p.unsandboxed {
+		return nil, errors.Wrapf(errdefs.ErrFailedPrecondition, "container %s doesn't run a sandbox", p.id)
+	}
+	sandboxID := p.SandboxID
+	if sandboxID == "" {
+		sandboxID = p.id
+	}
+	state, err := p.runtime.State(ctx, sandboxID)
+	if err != nil {
+		return nil, p.runtimeError(err, "OCI runtime state failed")
+	}
+	config := p.runtime.Flags()
+	d := &runsctypes.Diagnostics{
+		ContainerID: p.id,
+		SandboxID:   sandboxID,
+		Platform:    config["platform"],
+		SandboxPid:  state.Pid,
+		SentryPid:   sentryPid(state.Pid),
+		GoferPids:   goferPids(p.Bundle),
+		PtraceScope: ptraceScope(),
+	}
+	if d.Platform == "" {
+		d.Platform = "ptrace"
+	}
+	commands, err := p.runtime.Commands(ctx)
+	if err != nil {
+		log.G(ctx).WithError(err).Warn("failed to list runsc commands")
+	}
+	d.StackDumps = commands["debug"]
+	d.Profiling = d.StackDumps && config["profile"] == "true"
+	d.Hints = diagnosticHints(d)
+	return d, nil
+}
+
+// debugSignals are the signals SignalSentry sends: the sentry handles them
+// for debugging, e.g. with the runsc --trace-signal flag, rather than
+// forwarding them to the container.
+var debugSignals = map[uint32]bool{
+	uint32(unix.SIGUSR1): true,
+	uint32(unix.SIGUSR2): true,
+}
+
+// signalFlags are the runsc flags registering the debug handlers of the
+// sentry, by the signal number they are set to.
+var signalFlags = []string{"trace-signal", "panic-signal"}
+
+// SignalSentry sends sig to the sentry of the container, to trigger the stack
+// dumps or panic it is configured to take on signals. The sentry kills
+// itself on signals it has no handler for, so sig must be set with one of
+// the signalFlags in the runsc config.
+func (p *Init) SignalSentry(ctx context.Context, sig uint32) (int, error) {
+	name := unix.SignalName(syscall.Signal(sig))
+	if !debugSignals[sig] {
+		return 0, errors.Wrapf(errdefs.ErrInvalidArgument, "%s is not a debug signal, only SIGUSR1 and SIGUSR2 are sent to the sentry", name)
+	}
+	config := p.runtime.Flags()
+	handled := false
+	for _, flag := range signalFlags {
+		if n, err := strconv.Atoi(config[flag]); err == nil && uint32(n) == sig {
+			handled = true
+		}
+	}
+	if !handled {
+		return 0, errors.Wrapf(errdefs.ErrFailedPrecondition, "the sentry of container %s doesn't handle %s, set --trace-signal or --panic-signal to %d in the runsc config", p.id, name, sig)
+	}
+	d, err := p.Diagnostics(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if d.SentryPid <= 0 {
+		return 0, errors.Wrapf(errdefs.ErrNotFound, "sentry of container %s", p.id)
+	}
+	if err := unix.Kill(d.SentryPid, syscall.Signal(sig)); err != nil {
+		return 0, errors.Wrapf(err, "failed to signal sentry %d", d.SentryPid)
+	}
+	log.G(ctx).Infof("Sent %s to the sentry %d of container %q", name, d.SentryPid, p.id)
+	return d.SentryPid, nil
+}
+
+// sentryPid returns the pid of the runsc boot process of the sandbox process
+// pid: the sandbox process itself, or one of its children when runsc is run
+// through a wrapper. It returns pid when no boot process is found.
+func sentryPid(pid int) int {
+	if pid <= 0 || isBoot(pid) {
+		return pid
+	}
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return pid
+	}
+	for _, e := range entries {
+		child, err := strconv.Atoi(e.Name())
+		if err != nil || parentPid(child) != pid {
+			continue
+		}
+		if isBoot(child) {
+			return child
+		}
+	}
+	return pid
+}
+
+func isBoot(pid int) bool {
+	cmdline, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "cmdline"))
+	if err != nil {
+		return false
+	}
+	for _, arg := range bytes.Split(cmdline, []byte{0}) {
+		if string(arg) == "boot" {
+			return true
+		}
+	}
+	return false
+}
+
+// parentPid returns the parent of pid, from the field after the parenthesized
+// command of /proc/<pid>/stat.
+func parentPid(pid int) int {
+	stat, err := ioutil.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return 0
+	}
+	i := bytes.LastIndexByte(stat, ')')
+	if i < 0 {
+		return 0
+	}
+	fields := strings.Fields(string(stat[i+1:]))
+	if len(fields) < 2 {
+		return 0
+	}
+	ppid, _ := strconv.Atoi(fields[1])
+	return ppid
+}
+
+func ptraceScope() int {
+	data, err := ioutil.ReadFile(ptraceScopeFile)
+	if err != nil {
+		return -1
+	}
+	scope, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return -1
+	}
+	return scope
+}
+
+func diagnosticHints(d *runsctypes.Diagnostics) []string {
+	hints := []string{
+		fmt.Sprintf("dlv attach %d", d.SentryPid),
+		fmt.Sprintf("gdb -p %d", d.SentryPid),
+	}
+	if d.PtraceScope > 0 {
+		hints = append(hints, fmt.Sprintf("ptrace_scope is %d: attach as root", d.PtraceScope))
+	}
+	if d.Platform == "ptrace" {
+		hints = append(hints, "the ptrace platform traces the sentry stubs: attach to the sentry, not to its stub children")
+	}
+	if d.StackDumps {
+		hints = append(hints, fmt.Sprintf("runsc debug --stacks %s", d.SandboxID))
+	}
+	if d.Profiling {
+		hints = append(hints, fmt.Sprintf("runsc debug --profile-cpu=<file> --duration=30s %s", d.SandboxID))
+	}
+	return hints
+}
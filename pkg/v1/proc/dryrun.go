@@ -0,0 +1,99 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+
+	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// DryRunAnnotation makes Create report how the container would be created
+// instead of creating it, "true" or "false".
+const DryRunAnnotation = "dev.gvisor.dry-run"
+
+// IsDryRun reports whether the spec requests a dry run.
+func IsDryRun(spec *specs.Spec) (bool, error) {
+	v, ok := spec.Annotations[DryRunAnnotation]
+	if !ok {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, errors.Wrapf(errdefs.ErrInvalidArgument, "invalid %s annotation %q", DryRunAnnotation, v)
+	}
+	return b, nil
+}
+
+// DryRun returns the runsc create invocation of the container, without
+// running runsc.
+func (p *Init) DryRun(r *CreateConfig) (*runsctypes.DryRun, error) {
+	opts := &runsc.CreateOpts{
+		PidFile: filepath.Join(p.Bundle, InitPidFile),
+	}
+	if r.Terminal {
+		socket, err := newConsoleSocket(p.WorkDir, p.id)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create OCI runtime console socket")
+		}
+		defer socket.Close()
+		opts.ConsoleSocket = socket
+	}
+	if p.Sandbox {
+		opts.UserLog = p.userLog(r)
+	}
+	command, err := p.runtime.CreateCommand(r.ID, r.Bundle, opts)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := r.specData()
+	if err != nil {
+		return nil, err
+	}
+	d := &runsctypes.DryRun{
+		ContainerID: r.ID,
+		Command:     command,
+		Flags:       p.runtime.Flags(),
+		Spec:        string(spec),
+		Timestamp:   time.Now(),
+	}
+	rootfs := filepath.Join(r.Bundle, "rootfs")
+	for _, m := range r.Rootfs {
+		d.Mounts = append(d.Mounts, runsctypes.DryRunMount{
+			Type:    m.Type,
+			Source:  m.Source,
+			Target:  m.Target,
+			Options: m.Options,
+			Command: MountCall{Mount: m, Target: filepath.Join(rootfs, m.Target)}.String(),
+		})
+	}
+	return d, nil
+}
+
+// DryRunError is returned by Create for a dry run, as the container isn't
+// created. It carries the runsc command line of d.
+func DryRunError(d *runsctypes.DryRun) error {
+	return errors.Wrapf(errdefs.ErrFailedPrecondition, "dry run, container %q not created: %s", d.ContainerID, strings.Join(d.Command, " "))
+}
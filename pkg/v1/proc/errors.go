@@ -0,0 +1,76 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"github.com/containerd/containerd/errdefs"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
+)
+
+// grpcCodes maps the classified runsc failures to gRPC codes, so that
+// clients can tell failures worth retrying from the ones that are not.
+var grpcCodes = map[error]codes.Code{
+	runsc.ErrContainerNotFound: codes.NotFound,
+	runsc.ErrAlreadyExists:     codes.AlreadyExists,
+	runsc.ErrInvalidArgument:   codes.InvalidArgument,
+	runsc.ErrPermissionDenied:  codes.PermissionDenied,
+	runsc.ErrOOM:               codes.ResourceExhausted,
+	runsc.ErrResourceExhausted: codes.ResourceExhausted,
+	runsc.ErrGoferUnreachable:  codes.Unavailable,
+	runsc.ErrTimeout:           codes.DeadlineExceeded,
+	runsc.ErrNotRunsc:          codes.FailedPrecondition,
+}
+
+// ToGRPC converts err to a gRPC error. Classified runsc failures get the
+// code of their class; other errors are converted by errdefs.ToGRPC.
+func ToGRPC(err error) error {
+	if err == nil {
+		return nil
+	}
+	if code, ok := grpcCodes[errors.Cause(err)]; ok {
+		return status.Error(code, err.Error())
+	}
+	return errdefs.ToGRPC(err)
+}
+
+// runtimeErr is a classified OCI runtime failure reported with the message
+// runsc logged. Its cause is the class of the failure.
+type runtimeErr struct {
+	msg  string
+	kind error
+}
+
+func (e *runtimeErr) Error() string {
+	return e.msg
+}
+
+func (e *runtimeErr) Cause() error {
+	return e.kind
+}
+
+// classify returns the class of a failed runsc command, looking at its
+// output first and then at the error runsc logged.
+func classify(err error, logged string) error {
+	if _, ok := grpcCodes[errors.Cause(err)]; ok {
+		return errors.Cause(err)
+	}
+	return runsc.Classify(logged)
+}
@@ -29,6 +29,7 @@ import (
 
 	"github.com/containerd/console"
 	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
 	"github.com/containerd/containerd/runtime/proc"
 	"github.com/containerd/fifo"
 	runc "github.com/containerd/go-runc"
@@ -54,12 +55,24 @@ type execProcess struct {
 	internalPid int
 	closers     []io.Closer
 	stdin       io.Closer
+	stdinCopied chan struct{}
+	counters    ioCounters
+	fifos       stdioFifos
 	stdio       proc.Stdio
 	path        string
 	spec        specs.Process
+	// pendingSize is the last console size requested before the console
+	// of a terminal exec was created.
+	pendingSize *console.WinSize
+	// maxRuntime is how long the process may run before deadline kills
+	// it, zero for no limit. timedOut is set once it was killed.
+	maxRuntime time.Duration
+	deadline   *time.Timer
+	timedOut   bool
 
 	parent    *Init
 	waitBlock chan struct{}
+	ioDone    chan struct{}
 }
 
 func (e *execProcess) Wait() {
@@ -70,6 +83,14 @@ func (e *execProcess) ID() string {
 	return e.id
 }
 
+// InternalPid returns the pid of the process inside the sandbox, or 0 until
+// the process is started and for processes run with runc.
+func (e *execProcess) InternalPid() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.internalPid
+}
+
 func (e *execProcess) Pid() int {
 	e.mu.Lock()
 	defer e.mu.Unlock()
@@ -117,6 +138,7 @@ func (e *execProcess) delete(ctx context.Context) error {
 		}
 		e.io.Close()
 	}
+	removeDanglingFifos(ctx, e.fifos)
 	pidfile := filepath.Join(e.path, fmt.Sprintf("%s.pid", e.id))
 	// silently ignore error
 	os.Remove(pidfile)
@@ -135,27 +157,59 @@ func (e *execProcess) Resize(ws console.WinSize) error {
 
 func (e *execProcess) resize(ws console.WinSize) error {
 	if e.console == nil {
+		// The console of a terminal exec only exists once it is started,
+		// remember the size to apply it then.
+		if e.stdio.Terminal {
+			e.pendingSize = &ws
+		}
 		return nil
 	}
 	return e.console.Resize(ws)
 }
 
-func (e *execProcess) Kill(ctx context.Context, sig uint32, _ bool) error {
+// Kill signals the exec process, and with all the processes it started
+// too.
+func (e *execProcess) Kill(ctx context.Context, sig uint32, all bool) error {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	return e.execState.Kill(ctx, sig, false)
+	return e.execState.Kill(ctx, sig, all)
 }
 
-func (e *execProcess) kill(ctx context.Context, sig uint32, _ bool) error {
+func (e *execProcess) kill(ctx context.Context, sig uint32, all bool) error {
+	sig, err := e.parent.translateSignal(ctx, sig)
+	if err != nil {
+		return err
+	}
+	// Processes without an internal pid are signaled by their host pid.
 	internalPid := e.internalPid
-	if internalPid != 0 {
+	if internalPid == 0 {
+		internalPid = e.pid
+	}
+	if internalPid == 0 {
+		return nil
+	}
+	var descendants []int
+	if all {
+		// The tree is listed before any process is signaled, since the
+		// children of a killed process are reparented.
+		if descendants, err = e.parent.descendants(ctx, internalPid); err != nil {
+			log.G(ctx).WithError(err).Warnf("Failed to list the processes of exec %q, only signaling it", e.id)
+		}
+	}
+	if err := e.parent.runtime.Kill(ctx, e.parent.id, int(sig), &runsc.KillOpts{
+		Pid: internalPid,
+	}); err != nil {
+		// If this returns error, consider the process has already stopped.
+		// TODO: Fix after signal handling is fixed.
+		return errors.Wrap(errdefs.ErrNotFound, err.Error())
+	}
+	for _, pid := range descendants {
 		if err := e.parent.runtime.Kill(ctx, e.parent.id, int(sig), &runsc.KillOpts{
-			Pid: internalPid,
+			Pid: pid,
 		}); err != nil {
-			// If this returns error, consider the process has already stopped.
-			// TODO: Fix after signal handling is fixed.
-			return errors.Wrapf(errdefs.ErrNotFound, err.Error())
+			// The process may have exited since it was listed.
+			log.G(ctx).WithError(err).Debugf("Failed to signal process %d of exec %q", pid, e.id)
 		}
 	}
 	return nil
@@ -165,6 +219,17 @@ func (e *execProcess) Stdin() io.Closer {
 	return e.stdin
 }
 
+// CloseStdin closes stdin once the buffered input reached the process.
+func (e *execProcess) CloseStdin(ctx context.Context) error {
+	e.mu.Lock()
+	stdin, copied, cons := e.stdin, e.stdinCopied, e.console
+	e.mu.Unlock()
+	if cons != nil {
+		return closeTerminalStdin(ctx, e.id, e.parent.Platform, cons, e.parent.TerminalStdinEOF, stdin, e.Kill)
+	}
+	return closeStdin(ctx, e.id, stdin, copied)
+}
+
 func (e *execProcess) Stdio() proc.Stdio {
 	return e.stdio
 }
@@ -182,12 +247,15 @@ func (e *execProcess) start(ctx context.Context) (err error) {
 		pidfile         = filepath.Join(e.path, fmt.Sprintf("%s.pid", e.id))
 		internalPidfile = filepath.Join(e.path, fmt.Sprintf("%s-internal.pid", e.id))
 	)
+	if err := checkStdin(e.stdio.Stdin, e.stdio.Terminal); err != nil {
+		return err
+	}
 	if e.stdio.Terminal {
-		if socket, err = runc.NewTempConsoleSocket(); err != nil {
+		if socket, err = newConsoleSocket(e.parent.WorkDir, e.parent.id+"-"+e.id); err != nil {
 			return errors.Wrap(err, "failed to create runc console socket")
 		}
 		defer socket.Close()
-	} else if e.stdio.IsNull() {
+	} else if hasNoIO(e.stdio) {
 		if e.io, err = runc.NewNullIO(); err != nil {
 			return errors.Wrap(err, "creating new NULL IO")
 		}
@@ -196,6 +264,11 @@ func (e *execProcess) start(ctx context.Context) (err error) {
 			return errors.Wrap(err, "failed to create runc io pipes")
 		}
 	}
+	if e.io != nil {
+		if e.io, err = withStdinFile(e.io, e.stdio.Stdin); err != nil {
+			return err
+		}
+	}
 	opts := &runsc.ExecOpts{
 		PidFile:         pidfile,
 		InternalPidFile: internalPidfile,
@@ -204,6 +277,12 @@ func (e *execProcess) start(ctx context.Context) (err error) {
 	}
 	if socket != nil {
 		opts.ConsoleSocket = socket
+		if ws := e.pendingSize; ws != nil {
+			e.spec.ConsoleSize = &specs.Box{
+				Height: uint(ws.Height),
+				Width:  uint(ws.Width),
+			}
+		}
 	}
 	eventCh := e.parent.Monitor.Subscribe()
 	defer func() {
@@ -213,10 +292,13 @@ func (e *execProcess) start(ctx context.Context) (err error) {
 		}
 	}()
 	if err := e.parent.runtime.Exec(ctx, e.parent.id, e.spec, opts); err != nil {
+		if runsc.IsTimeout(err) {
+			e.cleanupExecTimeout(internalPidfile)
+		}
 		close(e.waitBlock)
 		return e.parent.runtimeError(err, "OCI runtime exec failed")
 	}
-	if e.stdio.Stdin != "" {
+	if stdinFifo(e.stdio.Stdin) != "" {
 		sc, err := fifo.OpenFifo(context.Background(), e.stdio.Stdin, syscall.O_WRONLY|syscall.O_NONBLOCK, 0)
 		if err != nil {
 			return errors.Wrapf(err, "failed to open stdin fifo %s", e.stdio.Stdin)
@@ -225,41 +307,53 @@ func (e *execProcess) start(ctx context.Context) (err error) {
 		e.stdin = sc
 	}
 	var copyWaitGroup sync.WaitGroup
-	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, e.parent.ioTimeout())
 	defer cancel()
 	if socket != nil {
 		console, err := socket.ReceiveMaster()
 		if err != nil {
 			return errors.Wrap(err, "failed to retrieve console master")
 		}
-		if e.console, err = e.parent.Platform.CopyConsole(ctx, console, e.stdio.Stdin, e.stdio.Stdout, e.stdio.Stderr, &e.wg, &copyWaitGroup); err != nil {
+		if e.console, err = e.parent.Platform.CopyConsole(ctx, console, stdinFifo(e.stdio.Stdin), e.stdio.Stdout, e.stdio.Stderr, &e.wg, &copyWaitGroup); err != nil {
 			return errors.Wrap(err, "failed to start console copy")
 		}
-	} else if !e.stdio.IsNull() {
-		if err := copyPipes(ctx, e.io, e.stdio.Stdin, e.stdio.Stdout, e.stdio.Stderr, &e.wg, &copyWaitGroup); err != nil {
+		e.fifos = stdioFifos{stdin: stdinFifo(e.stdio.Stdin), outputs: []string{e.stdio.Stdout}}
+		if ws := e.pendingSize; ws != nil {
+			if err := e.console.Resize(*ws); err != nil {
+				log.G(ctx).WithError(err).Warnf("Failed to resize console of exec %q", e.id)
+			}
+			e.pendingSize = nil
+		}
+	} else if !hasNoIO(e.stdio) {
+		e.stdinCopied = make(chan struct{})
+		if err := copyPipes(ctx, e.io, e.stdio.Stdin, e.stdio.Stdout, e.stdio.Stderr, &e.wg, &copyWaitGroup, e.stdinCopied, &e.counters, &e.fifos, e.parent.LineBufferStderr); err != nil {
 			return errors.Wrap(err, "failed to start io pipe copy")
 		}
 	}
 	copyWaitGroup.Wait()
+	closeWhenCopied(&e.wg, e.ioDone)
 	pid, err := runc.ReadPidFile(opts.PidFile)
 	if err != nil {
 		return errors.Wrap(err, "failed to retrieve OCI runtime exec pid")
 	}
 	e.pid = pid
+	// Runtimes without pid namespaces of their own, such as runc, don't
+	// write the internal pid file.
 	internalPid, err := runc.ReadPidFile(opts.InternalPidFile)
-	if err != nil {
+	if err != nil && !os.IsNotExist(err) {
 		return errors.Wrap(err, "failed to retrieve OCI runtime exec internal pid")
 	}
 	e.internalPid = internalPid
+	e.startDeadline()
 	go func() {
 		defer e.parent.Monitor.Unsubscribe(eventCh)
 		for event := range eventCh {
 			if event.Pid == e.pid {
-				ExitCh <- Exit{
+				e.parent.Exits.Publish(Exit{
 					Timestamp: event.Timestamp,
 					ID:        e.id,
-					Status:    event.Status,
-				}
+					Status:    e.exitStatus(event.Status),
+				})
 				break
 			}
 		}
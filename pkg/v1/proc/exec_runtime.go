@@ -0,0 +1,121 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/typeurl"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/utils"
+)
+
+// ExecMaxRuntimeAnnotation is the default max runtime of the exec processes
+// of the container, as a duration, e.g. "10m". Exec processes still running
+// after it are killed, such as probes that hang or debugging sessions left
+// behind. An Exec request sets its own with runsctypes.ExecSpec.
+const ExecMaxRuntimeAnnotation = "dev.gvisor.exec-max-runtime"
+
+// ExecTimeoutExitStatus is the exit status of the exec processes killed for
+// exceeding their max runtime, the status of timeout(1), so that they can be
+// told from processes killed by a signal.
+const ExecTimeoutExitStatus = 124
+
+// readExecMaxRuntime returns the default max runtime of exec processes set
+// by the spec in bundle, zero if there is none.
+func readExecMaxRuntime(bundle string) (time.Duration, error) {
+	spec, err := utils.ReadSpec(bundle)
+	if err != nil {
+		return 0, errors.Wrap(err, "read oci spec")
+	}
+	v, ok := spec.Annotations[ExecMaxRuntimeAnnotation]
+	if !ok {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, errors.Wrapf(errdefs.ErrInvalidArgument, "invalid %s annotation %q", ExecMaxRuntimeAnnotation, v)
+	}
+	return d, nil
+}
+
+// decodeExecSpec decodes the spec of an Exec request, an OCI process or a
+// runsctypes.ExecSpec. The options of an OCI process are left unset.
+func decodeExecSpec(r *ExecConfig) (runsctypes.ExecSpec, error) {
+	var spec runsctypes.ExecSpec
+	if !typeurl.Is(r.Spec, &runsctypes.ExecSpec{}) {
+		if err := json.Unmarshal(r.Spec.Value, &spec.Process); err != nil {
+			return runsctypes.ExecSpec{}, err
+		}
+		return spec, nil
+	}
+	if err := json.Unmarshal(r.Spec.Value, &spec); err != nil {
+		return runsctypes.ExecSpec{}, err
+	}
+	if spec.MaxRuntime < 0 {
+		return runsctypes.ExecSpec{}, errors.Wrapf(errdefs.ErrInvalidArgument, "invalid max runtime %v", spec.MaxRuntime)
+	}
+	return spec, nil
+}
+
+// startDeadline arms the kill of the started exec process once its max
+// runtime elapsed.
+func (e *execProcess) startDeadline() {
+	if e.maxRuntime == 0 {
+		return
+	}
+	e.deadline = time.AfterFunc(e.maxRuntime, e.expire)
+}
+
+// expire kills the exec process, and the processes it started, for
+// exceeding its max runtime.
+func (e *execProcess) expire() {
+	ctx := context.Background()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.timedOut || e.deadline == nil {
+		// The process exited in the meantime.
+		return
+	}
+	e.timedOut = true
+	log.G(ctx).Warnf("Killing exec %q of container %q, it ran for longer than its max runtime of %v", e.id, e.parent.id, e.maxRuntime)
+	if err := e.execState.Kill(ctx, uint32(unix.SIGKILL), true); err != nil && !errdefs.IsNotFound(err) {
+		log.G(ctx).WithError(err).Errorf("Failed to kill exec %q of container %q", e.id, e.parent.id)
+	}
+}
+
+// exitStatus returns the status the exec process exited with, the
+// ExecTimeoutExitStatus once it was killed for exceeding its max runtime.
+func (e *execProcess) exitStatus(status int) int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.deadline != nil {
+		e.deadline.Stop()
+		e.deadline = nil
+	}
+	if e.timedOut {
+		return ExecTimeoutExitStatus
+	}
+	return status
+}
@@ -21,6 +21,7 @@ import (
 	"context"
 
 	"github.com/containerd/console"
+	"github.com/containerd/containerd/errdefs"
 	"github.com/pkg/errors"
 )
 
@@ -36,20 +37,6 @@ type execCreatedState struct {
 	p *execProcess
 }
 
-func (s *execCreatedState) transition(name string) error {
-	switch name {
-	case "running":
-		s.p.execState = &execRunningState{p: s.p}
-	case "stopped":
-		s.p.execState = &execStoppedState{p: s.p}
-	case "deleted":
-		s.p.execState = &deletedState{}
-	default:
-		return errors.Errorf("invalid state transition %q to %q", stateName(s), name)
-	}
-	return nil
-}
-
 func (s *execCreatedState) Resize(ws console.WinSize) error {
 	return s.p.resize(ws)
 }
@@ -58,14 +45,14 @@ func (s *execCreatedState) Start(ctx context.Context) error {
 	if err := s.p.start(ctx); err != nil {
 		return err
 	}
-	return s.transition("running")
+	return s.p.transition(Running)
 }
 
 func (s *execCreatedState) Delete(ctx context.Context) error {
 	if err := s.p.delete(ctx); err != nil {
 		return err
 	}
-	return s.transition("deleted")
+	return s.p.transition(Deleted)
 }
 
 func (s *execCreatedState) Kill(ctx context.Context, sig uint32, all bool) error {
@@ -75,7 +62,7 @@ func (s *execCreatedState) Kill(ctx context.Context, sig uint32, all bool) error
 func (s *execCreatedState) SetExited(status int) {
 	s.p.setExited(status)
 
-	if err := s.transition("stopped"); err != nil {
+	if err := s.p.transition(Stopped); err != nil {
 		panic(err)
 	}
 }
@@ -84,26 +71,16 @@ type execRunningState struct {
 	p *execProcess
 }
 
-func (s *execRunningState) transition(name string) error {
-	switch name {
-	case "stopped":
-		s.p.execState = &execStoppedState{p: s.p}
-	default:
-		return errors.Errorf("invalid state transition %q to %q", stateName(s), name)
-	}
-	return nil
-}
-
 func (s *execRunningState) Resize(ws console.WinSize) error {
 	return s.p.resize(ws)
 }
 
 func (s *execRunningState) Start(ctx context.Context) error {
-	return errors.Errorf("cannot start a running process")
+	return errors.Wrap(errdefs.ErrFailedPrecondition, "cannot start a running process")
 }
 
 func (s *execRunningState) Delete(ctx context.Context) error {
-	return errors.Errorf("cannot delete a running process")
+	return errors.Wrap(errdefs.ErrFailedPrecondition, "cannot delete a running process")
 }
 
 func (s *execRunningState) Kill(ctx context.Context, sig uint32, all bool) error {
@@ -113,7 +90,7 @@ func (s *execRunningState) Kill(ctx context.Context, sig uint32, all bool) error
 func (s *execRunningState) SetExited(status int) {
 	s.p.setExited(status)
 
-	if err := s.transition("stopped"); err != nil {
+	if err := s.p.transition(Stopped); err != nil {
 		panic(err)
 	}
 }
@@ -122,29 +99,19 @@ type execStoppedState struct {
 	p *execProcess
 }
 
-func (s *execStoppedState) transition(name string) error {
-	switch name {
-	case "deleted":
-		s.p.execState = &deletedState{}
-	default:
-		return errors.Errorf("invalid state transition %q to %q", stateName(s), name)
-	}
-	return nil
-}
-
 func (s *execStoppedState) Resize(ws console.WinSize) error {
-	return errors.Errorf("cannot resize a stopped container")
+	return errors.Wrap(errdefs.ErrFailedPrecondition, "cannot resize a stopped container")
 }
 
 func (s *execStoppedState) Start(ctx context.Context) error {
-	return errors.Errorf("cannot start a stopped process")
+	return errors.Wrap(errdefs.ErrFailedPrecondition, "cannot start a stopped process")
 }
 
 func (s *execStoppedState) Delete(ctx context.Context) error {
 	if err := s.p.delete(ctx); err != nil {
 		return err
 	}
-	return s.transition("deleted")
+	return s.p.transition(Deleted)
 }
 
 func (s *execStoppedState) Kill(ctx context.Context, sig uint32, all bool) error {
@@ -0,0 +1,260 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/containerd/console"
+	"github.com/containerd/containerd/errdefs"
+	runc "github.com/containerd/go-runc"
+	google_protobuf "github.com/gogo/protobuf/types"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+
+	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
+)
+
+// fakeExecRuntime runs exec processes the way runsc exec does with a
+// console socket: it hands the master of a new pty over the socket. The
+// exec process is the calling process.
+type fakeExecRuntime struct {
+	Runtime
+	// spec is the process spec of the last exec.
+	spec specs.Process
+	// master is the pty master handed over by the last exec.
+	master console.Console
+}
+
+func (r *fakeExecRuntime) Exec(ctx context.Context, id string, spec specs.Process, opts *runsc.ExecOpts) error {
+	r.spec = spec
+	if opts.ConsoleSocket != nil {
+		master, _, err := console.NewPty()
+		if err != nil {
+			return err
+		}
+		r.master = master
+		conn, err := net.Dial("unix", opts.ConsoleSocket.Path())
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		rights := unix.UnixRights(int(master.Fd()))
+		if _, _, err := conn.(*net.UnixConn).WriteMsgUnix([]byte(master.Name()), rights, nil); err != nil {
+			return err
+		}
+	}
+	pid := []byte(strconv.Itoa(os.Getpid()))
+	if err := ioutil.WriteFile(opts.PidFile, pid, 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(opts.InternalPidFile, pid, 0644)
+}
+
+func (r *fakeExecRuntime) Version(ctx context.Context) (string, error) {
+	return "test", nil
+}
+
+// fakePlatform uses the console as is, without copying its io.
+type fakePlatform struct{}
+
+func (fakePlatform) CopyConsole(ctx context.Context, cons console.Console, stdin, stdout, stderr string, wg, cwg *sync.WaitGroup) (console.Console, error) {
+	return cons, nil
+}
+
+func (fakePlatform) ShutdownConsole(ctx context.Context, cons console.Console) error {
+	return cons.Close()
+}
+
+func (fakePlatform) Close() error {
+	return nil
+}
+
+// fakeMonitor hands out a single exit channel, closed by close. It runs no
+// commands.
+type fakeMonitor struct {
+	runc.ProcessMonitor
+	exits chan runc.Exit
+}
+
+func (m *fakeMonitor) Subscribe() chan runc.Exit {
+	return m.exits
+}
+
+func (m *fakeMonitor) Unsubscribe(c chan runc.Exit) {}
+
+func (m *fakeMonitor) close() {
+	close(m.exits)
+}
+
+// execSpec returns the process spec of an exec the way containerd sends it.
+func execSpec(t *testing.T) *google_protobuf.Any {
+	data, err := json.Marshal(&specs.Process{
+		Args: []string{"sh"},
+		Cwd:  "/",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &google_protobuf.Any{
+		TypeUrl: "types.containerd.io/opencontainers/runtime-spec/1/Process",
+		Value:   data,
+	}
+}
+
+// newTestInit returns a container run with the fake exec runtime.
+func newTestInit(t *testing.T) (*Init, *fakeExecRuntime, func()) {
+	dir, err := ioutil.TempDir("", "exec-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime := &fakeExecRuntime{}
+	monitor := &fakeMonitor{exits: make(chan runc.Exit)}
+	p := &Init{
+		id:       "c1",
+		runtime:  runtime,
+		WorkDir:  dir,
+		Platform: fakePlatform{},
+		Monitor:  monitor,
+	}
+	return p, runtime, func() {
+		monitor.close()
+		if runtime.master != nil {
+			runtime.master.Close()
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+// newTestExec returns an exec process of a container run with the fake exec
+// runtime.
+func newTestExec(t *testing.T, terminal bool) (*execProcess, *fakeExecRuntime, func()) {
+	p, runtime, cleanup := newTestInit(t)
+	e, err := p.exec(context.Background(), p.WorkDir, &ExecConfig{
+		ID:       "e1",
+		Terminal: terminal,
+		Spec:     execSpec(t),
+	})
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	return e.(*execProcess), runtime, cleanup
+}
+
+func TestExecResizeBeforeStart(t *testing.T) {
+	e, runtime, cleanup := newTestExec(t, true)
+	defer cleanup()
+
+	ws := console.WinSize{Height: 40, Width: 120}
+	if err := e.Resize(ws); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := specs.Box{Height: 40, Width: 120}
+	if got := runtime.spec.ConsoleSize; got == nil || *got != want {
+		t.Errorf("console size of runsc exec: got %v, want %v", got, want)
+	}
+	size, err := e.console.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size.Height != ws.Height || size.Width != ws.Width {
+		t.Errorf("console size: got %+v, want %+v", size, ws)
+	}
+	if e.pendingSize != nil {
+		t.Errorf("pending size left after start: %+v", *e.pendingSize)
+	}
+}
+
+func TestExecResizeAfterStart(t *testing.T) {
+	e, runtime, cleanup := newTestExec(t, true)
+	defer cleanup()
+
+	if err := e.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if runtime.spec.ConsoleSize != nil {
+		t.Errorf("console size of runsc exec without resize: got %v, want none", runtime.spec.ConsoleSize)
+	}
+	ws := console.WinSize{Height: 25, Width: 80}
+	if err := e.Resize(ws); err != nil {
+		t.Fatal(err)
+	}
+	size, err := runtime.master.Size()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size.Height != ws.Height || size.Width != ws.Width {
+		t.Errorf("console size: got %+v, want %+v", size, ws)
+	}
+}
+
+func TestExecResizeWithoutTerminal(t *testing.T) {
+	e, runtime, cleanup := newTestExec(t, false)
+	defer cleanup()
+
+	if err := e.Resize(console.WinSize{Height: 40, Width: 120}); err != nil {
+		t.Fatal(err)
+	}
+	if e.pendingSize != nil {
+		t.Errorf("pending size without terminal: %+v", *e.pendingSize)
+	}
+	if err := e.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if runtime.spec.ConsoleSize != nil {
+		t.Errorf("console size of runsc exec without terminal: got %v, want none", runtime.spec.ConsoleSize)
+	}
+}
+
+func TestExecMounts(t *testing.T) {
+	p, _, cleanup := newTestInit(t)
+	defer cleanup()
+
+	tools := specs.Mount{Source: "/var/lib/debug/tools", Destination: "/tools", Type: "bind"}
+	_, err := p.exec(context.Background(), p.WorkDir, &ExecConfig{
+		ID:     "debug",
+		Spec:   execSpec(t),
+		Mounts: []specs.Mount{tools},
+	})
+	if !errdefs.IsNotImplemented(err) {
+		t.Fatalf("exec with mounts: got %v, want not implemented", err)
+	}
+	if !strings.Contains(err.Error(), "/tools") {
+		t.Errorf("exec with mounts error %q doesn't name /tools", err)
+	}
+
+	_, err = p.exec(context.Background(), p.WorkDir, &ExecConfig{
+		ID:     "debug",
+		Spec:   execSpec(t),
+		Mounts: []specs.Mount{{Source: "/var/lib/debug/tools", Destination: "tools"}},
+	})
+	if !errdefs.IsInvalidArgument(err) {
+		t.Errorf("exec with a relative mount: got %v, want invalid argument", err)
+	}
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"syscall"
+
+	eventstypes "github.com/containerd/containerd/api/events"
+	"github.com/containerd/containerd/runtime/proc"
+	"golang.org/x/sys/unix"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// platformMarkers show in the debug logs of a sandbox that couldn't set up
+// its platform. They are the errors runsc fails with, a mere mention of
+// /dev/kvm, e.g. in the devices of the spec, isn't one.
+var platformMarkers = []string{
+	"error opening /dev/kvm",
+	"creating platform",
+	"platform is not available",
+}
+
+// exitReason is a reason of runsctypes.ExitDetails, with its message.
+type exitReason struct {
+	reason, message string
+}
+
+// RecordFailure records reason, one of the runsctypes reasons, as the cause
+// of the exit of the container should it fail, e.g. after an OOM. The first
+// failure recorded wins.
+func (p *Init) RecordFailure(reason, message string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.failure.reason == "" {
+		p.failure = exitReason{reason, message}
+	}
+}
+
+// RecordFailures wraps publish, the publisher of the events of the
+// container, to record the failures they report, such as OOMs.
+func (p *Init) RecordFailures(publish func(interface{})) func(interface{}) {
+	return func(e interface{}) {
+		if _, ok := e.(*eventstypes.TaskOOM); ok {
+			p.RecordFailure(runsctypes.ReasonOOMKilled, "the container ran out of memory")
+		}
+		publish(e)
+	}
+}
+
+// WatchdogKill force exits the container with status for exceeding a cap of
+// the resource watchdog.
+func (p *Init) WatchdogKill(ctx context.Context, status int) error {
+	p.RecordFailure(runsctypes.ReasonWatchdogKilled, "the sandbox exceeded a resource cap of the watchdog")
+	return p.ForceExit(ctx, status)
+}
+
+// exitReason returns why the container exited: the failure recorded before
+// its exit, a failure of the sandbox found in its debug logs, or the reason
+// of its exit status. It is kept once found, so that the reason given on
+// delete is the one given on exit, even with the debug logs removed.
+func (p *Init) exitReason() exitReason {
+	p.mu.Lock()
+	if p.reason != nil {
+		defer p.mu.Unlock()
+		return *p.reason
+	}
+	p.mu.Unlock()
+	r := p.findExitReason()
+	p.mu.Lock()
+	p.reason = &r
+	p.mu.Unlock()
+	return r
+}
+
+func (p *Init) findExitReason() exitReason {
+	status := p.ExitStatus()
+	if status == 0 {
+		return exitReason{runsctypes.ReasonCompleted, ""}
+	}
+	p.mu.Lock()
+	failure := p.failure
+	p.mu.Unlock()
+	if failure.reason != "" {
+		return failure
+	}
+	if !p.Sandbox || p.unsandboxed {
+		return statusReason(status)
+	}
+	if _, excerpt := findMarkers(p.debugLogs(), platformMarkers); excerpt != "" {
+		return exitReason{runsctypes.ReasonPlatformUnavailable, firstLine(excerpt)}
+	}
+	if _, excerpt := p.findCrash(); excerpt != "" {
+		return exitReason{runsctypes.ReasonSandboxCrashed, firstLine(excerpt)}
+	}
+	if status == internalErrorCode {
+		return exitReason{runsctypes.ReasonSandboxCrashed, "sandbox died without reporting an exit status"}
+	}
+	return statusReason(status)
+}
+
+// statusReason returns the reason of a process that exited with status.
+func statusReason(status int) exitReason {
+	if status == 0 {
+		return exitReason{runsctypes.ReasonCompleted, ""}
+	}
+	disp := DecodeExitStatus(status)
+	switch {
+	case disp.Signaled && disp.Signal == syscall.SIGSYS:
+		return exitReason{runsctypes.ReasonUnsupportedSyscallPolicy, "killed by SIGSYS for a disallowed syscall"}
+	case disp.Signaled:
+		return exitReason{runsctypes.ReasonError, "killed by " + unix.SignalName(disp.Signal)}
+	default:
+		return exitReason{runsctypes.ReasonError, fmt.Sprintf("exited with code %d", disp.Code)}
+	}
+}
+
+func processReason(p proc.Process) exitReason {
+	if ip, ok := p.(*Init); ok {
+		return ip.exitReason()
+	}
+	return statusReason(p.ExitStatus())
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPlatformMarkers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "exit-reason-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, tc := range []struct {
+		name, log string
+		found     bool
+	}{
+		{
+			name: "kvm device in spec",
+			log:  "I1017 Spec: {\"linux\":{\"devices\":[{\"path\":\"/dev/kvm\"}]}}\nI1017 Exiting with status: 256\n",
+		},
+		{
+			name:  "kvm unavailable",
+			log:   "I1017 Starting sandbox\nW1017 FATAL ERROR: error opening /dev/kvm: permission denied\n",
+			found: true,
+		},
+		{
+			name:  "platform creation failed",
+			log:   "W1017 FATAL ERROR: creating platform: no such platform\n",
+			found: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(dir, "runsc.log")
+			if err := ioutil.WriteFile(path, []byte(tc.log), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, excerpt := findMarkers([]string{path}, platformMarkers)
+			if found := excerpt != ""; found != tc.found {
+				t.Errorf("found platform failure %q, expected %v", excerpt, tc.found)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+	"syscall"
+
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/containerd/runtime/proc"
+	"golang.org/x/sys/unix"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// signalOffset is added to the signal killing a process to get its exit
+// status, like shells and the containerd reaper do.
+const signalOffset = 128
+
+// SignalExitStatus returns the exit status of a process killed by sig.
+func SignalExitStatus(sig syscall.Signal) int {
+	return signalOffset + int(sig)
+}
+
+// ExitDisposition is how a process ended, decoded from its exit status.
+type ExitDisposition struct {
+	// Signaled is set for a process killed by Signal. Code is the status
+	// other processes exited with.
+	Signaled bool
+	Signal   syscall.Signal
+	Code     int
+}
+
+// DecodeExitStatus returns the disposition encoded in status. Statuses
+// above 128 are signals: a process exiting with such a status on its own
+// can't be told from one killed by the signal, as with shells.
+func DecodeExitStatus(status int) ExitDisposition {
+	if status > signalOffset && status <= signalOffset+maxSignal {
+		return ExitDisposition{Signaled: true, Signal: syscall.Signal(status - signalOffset)}
+	}
+	return ExitDisposition{Code: status}
+}
+
+// normalizeExitStatus returns status in the 128+n convention. Exit statuses
+// reported by runsc are already, but a raw wait status or the -1 of a wait
+// status that didn't exit, as reported by old releases, would be mangled
+// once sent as an unsigned exit status. The signal of the latter is lost.
+func normalizeExitStatus(ctx context.Context, id string, status int) int {
+	switch {
+	case status >= 0 && status <= 255:
+		return status
+	case status > 255:
+		ws := unix.WaitStatus(status)
+		if ws.Signaled() {
+			return SignalExitStatus(ws.Signal())
+		}
+		if ws.Exited() {
+			return ws.ExitStatus()
+		}
+	}
+	log.G(ctx).Warnf("Container %q exited with status %d, which doesn't encode an exit code or signal", id, status)
+	return internalErrorCode
+}
+
+// NewExitDetails returns the details of the exit of p, a process of the
+// container, with the reason it exited for.
+func NewExitDetails(containerID string, p proc.Process) *runsctypes.ExitDetails {
+	status := p.ExitStatus()
+	d := &runsctypes.ExitDetails{
+		ContainerID: containerID,
+		Pid:         uint32(p.Pid()),
+		ExitStatus:  uint32(status),
+		ExitedAt:    p.ExitedAt(),
+	}
+	if p.ID() != containerID {
+		d.ExecID = p.ID()
+	}
+	disp := DecodeExitStatus(status)
+	if disp.Signaled {
+		d.Signaled = true
+		d.Signal = int(disp.Signal)
+		d.SignalName = unix.SignalName(disp.Signal)
+	} else {
+		d.ExitCode = disp.Code
+	}
+	r := processReason(p)
+	d.Reason, d.Message = r.reason, r.message
+	return d
+}
+
+// NewDeleteDetails returns the details of the delete of p, a process of the
+// container, with the reason it exited for.
+func NewDeleteDetails(containerID string, p proc.Process) *runsctypes.DeleteDetails {
+	d := &runsctypes.DeleteDetails{
+		ContainerID: containerID,
+		Pid:         uint32(p.Pid()),
+		ExitStatus:  uint32(p.ExitStatus()),
+		ExitedAt:    p.ExitedAt(),
+	}
+	if p.ID() != containerID {
+		d.ExecID = p.ID()
+	}
+	r := processReason(p)
+	d.Reason, d.Message = r.reason, r.message
+	return d
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import "sync"
+
+// Exits routes exit events of containers and exec processes to subscribers.
+// Each service owns its own Exits, so several services can run in one
+// binary without seeing each other's exits.
+type Exits struct {
+	mu          sync.Mutex
+	subscribers map[chan Exit]struct{}
+}
+
+// NewExits returns an exit registry without subscribers.
+func NewExits() *Exits {
+	return &Exits{
+		subscribers: make(map[chan Exit]struct{}),
+	}
+}
+
+// Subscribe returns a channel receiving all exits published after the call.
+func (e *Exits) Subscribe() chan Exit {
+	c := make(chan Exit, bufferSize)
+	e.mu.Lock()
+	e.subscribers[c] = struct{}{}
+	e.mu.Unlock()
+	return c
+}
+
+// Unsubscribe stops delivering exits to c and closes it.
+func (e *Exits) Unsubscribe(c chan Exit) {
+	e.mu.Lock()
+	delete(e.subscribers, c)
+	e.mu.Unlock()
+	close(c)
+}
+
+// Publish delivers the exit to all subscribers. Publishing to a nil registry
+// drops the exit.
+func (e *Exits) Publish(exit Exit) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for c := range e.subscribers {
+		c <- exit
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/pkg/errors"
+)
+
+// defaultIOTimeout bounds how long the shim waits for the client to open its
+// end of the output fifos of a process.
+const defaultIOTimeout = 30 * time.Second
+
+// ioTimeout returns how long the client is given to open the fifos.
+func (p *Init) ioTimeout() time.Duration {
+	if p.IOTimeout > 0 {
+		return p.IOTimeout
+	}
+	return defaultIOTimeout
+}
+
+// fifoOpenError describes a failure to open the fifo name. A fifo the client
+// didn't open in time fails with FailedPrecondition; if no process holds it
+// open at all, it was left by a crashed client and is removed.
+func fifoOpenError(ctx context.Context, name string, err error) error {
+	if ctx.Err() != context.DeadlineExceeded {
+		return fmt.Errorf("gvisor-containerd-shim: opening %s failed: %s", name, err)
+	}
+	if n, herr := fifoHolders(name); herr == nil && n == 0 {
+		os.Remove(name)
+		return errors.Wrapf(errdefs.ErrFailedPrecondition, "fifo %s is dangling: no process has it open, its client may have crashed", name)
+	}
+	return errors.Wrapf(errdefs.ErrFailedPrecondition, "timed out waiting for the client to open fifo %s", name)
+}
+
+// fifoHolders returns the number of processes other than the shim that have
+// the fifo at path open.
+func fifoHolders(path string) (int, error) {
+	var want syscall.Stat_t
+	if err := syscall.Stat(path, &want); err != nil {
+		return 0, err
+	}
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return 0, err
+	}
+	self := os.Getpid()
+	n := 0
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil || pid == self {
+			continue
+		}
+		fdDir := filepath.Join("/proc", e.Name(), "fd")
+		fds, err := ioutil.ReadDir(fdDir)
+		if err != nil {
+			// The process exited or isn't ours to inspect.
+			continue
+		}
+		for _, fd := range fds {
+			var st syscall.Stat_t
+			if err := syscall.Stat(filepath.Join(fdDir, fd.Name()), &st); err != nil {
+				continue
+			}
+			if st.Dev == want.Dev && st.Ino == want.Ino {
+				n++
+				break
+			}
+		}
+	}
+	return n, nil
+}
+
+// stdioFifos are the stdio fifos the shim opened for a process.
+type stdioFifos struct {
+	stdin   string
+	outputs []string
+}
+
+// addOutput records the output fifo name, unless name is a URI: the fifos
+// behind ring URIs belong to the consumer of the ring.
+func (f *stdioFifos) addOutput(name string) {
+	if u, err := url.Parse(name); err == nil && u.Scheme != "" {
+		return
+	}
+	f.outputs = append(f.outputs, name)
+}
+
+// removeDanglingFifos removes the fifos the shim opened for a process once
+// the shim closed its ends, if the client went away. containerd removes the
+// fifos of the processes it deletes, but the fifos of a client that crashed
+// are left behind. The client is gone when no output fifo has a reader.
+func removeDanglingFifos(ctx context.Context, f stdioFifos) {
+	if len(f.outputs) == 0 {
+		return
+	}
+	for _, path := range f.outputs {
+		if hasReader(path) {
+			return
+		}
+	}
+	for _, path := range append(f.outputs, f.stdin) {
+		if path == "" {
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			if !os.IsNotExist(err) {
+				log.G(ctx).WithError(err).Warnf("Failed to remove dangling fifo %s", path)
+			}
+			continue
+		}
+		log.G(ctx).Debugf("Removed dangling fifo %s", path)
+	}
+}
+
+// hasReader tells whether the fifo at path is open for reading: opening it
+// for writing without blocking fails with ENXIO otherwise. A fifo already
+// removed counts as read, its client took care of it.
+func hasReader(path string) bool {
+	fd, err := syscall.Open(path, syscall.O_WRONLY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return err != syscall.ENXIO
+	}
+	syscall.Close(fd)
+	return true
+}
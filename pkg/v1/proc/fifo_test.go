@@ -0,0 +1,75 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestRemoveDanglingFifos(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fifo-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mkfifos := func() stdioFifos {
+		f := stdioFifos{stdin: filepath.Join(dir, "stdin")}
+		for _, name := range []string{"stdin", "stdout", "stderr"} {
+			if err := syscall.Mkfifo(filepath.Join(dir, name), 0600); err != nil && err != syscall.EEXIST {
+				t.Fatal(err)
+			}
+		}
+		f.addOutput(filepath.Join(dir, "stdout"))
+		f.addOutput(filepath.Join(dir, "stderr"))
+		f.addOutput("ring:///" + filepath.Join(dir, "ring"))
+		return f
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(dir, name))
+		return err == nil
+	}
+
+	// The client still reads stderr.
+	f := mkfifos()
+	if len(f.outputs) != 2 {
+		t.Fatalf("got outputs %v, expected the stdout and stderr fifos", f.outputs)
+	}
+	r, err := os.OpenFile(filepath.Join(dir, "stderr"), os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	removeDanglingFifos(context.Background(), f)
+	for _, name := range []string{"stdin", "stdout", "stderr"} {
+		if !exists(name) {
+			t.Errorf("removed %s of a client still reading", name)
+		}
+	}
+
+	// The client went away.
+	r.Close()
+	removeDanglingFifos(context.Background(), f)
+	for _, name := range []string{"stdin", "stdout", "stderr"} {
+		if exists(name) {
+			t.Errorf("left dangling %s", name)
+		}
+	}
+}
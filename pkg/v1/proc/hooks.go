@@ -0,0 +1,125 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	runc "github.com/containerd/go-runc"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+)
+
+// defaultHookTimeout bounds hooks which don't set a timeout in the spec.
+const defaultHookTimeout = 30 * time.Second
+
+// hooks are the OCI hooks of a container. gVisor doesn't run them, so the
+// shim runs them on the host. createRuntime hooks postdate the vendored
+// runtime spec and are decoded here.
+type hooks struct {
+	Prestart      []specs.Hook `json:"prestart,omitempty"`
+	CreateRuntime []specs.Hook `json:"createRuntime,omitempty"`
+	Poststart     []specs.Hook `json:"poststart,omitempty"`
+	Poststop      []specs.Hook `json:"poststop,omitempty"`
+}
+
+// decodeHooks decodes the hooks of the spec of the container, in JSON.
+func decodeHooks(specData []byte) (*hooks, error) {
+	var spec struct {
+		Hooks *hooks `json:"hooks,omitempty"`
+	}
+	if err := json.Unmarshal(specData, &spec); err != nil {
+		return nil, err
+	}
+	if spec.Hooks == nil {
+		spec.Hooks = &hooks{}
+	}
+	return spec.Hooks, nil
+}
+
+// runHooks runs hs in order with the state of the container on stdin. It
+// stops at the first failing hook.
+func (p *Init) runHooks(ctx context.Context, kind string, hs []specs.Hook, status string) error {
+	if len(hs) == 0 {
+		return nil
+	}
+	state, err := json.Marshal(&specs.State{
+		Version:     specs.Version,
+		ID:          p.id,
+		Status:      status,
+		Pid:         p.pid,
+		Bundle:      p.Bundle,
+		Annotations: p.annotations,
+	})
+	if err != nil {
+		return err
+	}
+	for i, h := range hs {
+		log.G(ctx).Debugf("Running %s hook %q for container %q", kind, h.Path, p.id)
+		if err := runHook(p.commandMonitor(), h, state); err != nil {
+			return errors.Wrapf(err, "%s hook #%d %q", kind, i, h.Path)
+		}
+	}
+	return nil
+}
+
+// runHook runs the hook through the monitor of the runtime commands, so that
+// the shim reaper doesn't race with waiting for the hook. The hook is killed once its
+// timeout expires.
+func runHook(monitor runc.ProcessMonitor, h specs.Hook, state []byte) error {
+	timeout := defaultHookTimeout
+	if h.Timeout != nil {
+		if *h.Timeout <= 0 {
+			return errors.Wrapf(errdefs.ErrInvalidArgument, "invalid hook timeout %d", *h.Timeout)
+		}
+		timeout = time.Duration(*h.Timeout) * time.Second
+	}
+	cmd := exec.Command(h.Path)
+	if len(h.Args) > 0 {
+		cmd.Args = h.Args
+	}
+	cmd.Env = h.Env
+	cmd.Stdin = bytes.NewReader(state)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	ec, err := monitor.Start(cmd)
+	if err != nil {
+		return err
+	}
+	timer := time.AfterFunc(timeout, func() {
+		cmd.Process.Kill()
+	})
+	status, err := monitor.Wait(cmd, ec)
+	if !timer.Stop() {
+		return errors.Errorf("timed out after %v", timeout)
+	}
+	if err == nil && status != 0 {
+		err = errors.Errorf("exit status %d", status)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "output %q", strings.TrimSpace(out.String()))
+	}
+	return nil
+}
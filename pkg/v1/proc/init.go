@@ -21,8 +21,8 @@ import (
 	"context"
 	"encoding/json"
 	"io"
+	"os"
 	"path/filepath"
-	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -30,14 +30,17 @@ import (
 	"github.com/containerd/console"
 	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/log"
-	"github.com/containerd/containerd/mount"
 	"github.com/containerd/containerd/runtime/proc"
 	"github.com/containerd/fifo"
 	runc "github.com/containerd/go-runc"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/pkg/errors"
 
+	"github.com/google/gvisor-containerd-shim/pkg/failpoint"
 	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/cgroup"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/checkpoint"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
 )
 
 // InitPidFile name of the file that contains the init pid
@@ -55,27 +58,161 @@ type Init struct {
 	mu sync.Mutex
 
 	waitBlock chan struct{}
+	ioDone    chan struct{}
 
 	WorkDir string
+	// CleanupWorkDir removes WorkDir when the container is deleted, instead
+	// of only its contents.
+	CleanupWorkDir bool
+	// KeepArtifacts keeps the work directory contents and the logs of the
+	// container when it is deleted, for postmortem debugging.
+	KeepArtifacts bool
+	removed       []string
+	// CollectCrashLogs copies the runsc debug log showing a crash of the
+	// sandbox to the work directory, where it is kept on Delete.
+	CollectCrashLogs bool
+	// Mounts configures how the rootfs is unmounted.
+	Mounts MountConfig
+	// MountWatchdog, if set, checks that nothing is left mounted under the
+	// bundle once the container is deleted, and retries the unmounts of
+	// the mounts leaked there.
+	MountWatchdog *MountWatchdog
+	// IOTimeout bounds how long the client is given to open its end of the
+	// output fifos of the processes. It defaults to 30s.
+	IOTimeout time.Duration
+	// LineBufferStderr copies the stderr of the init and exec processes in
+	// whole lines.
+	LineBufferStderr bool
+	// TerminalStdinEOF is what is done when the client closes the stdin of
+	// the init and exec processes with a terminal.
+	TerminalStdinEOF TerminalStdinEOF
+	// PauseOptimization lets the sandbox act as the pause process of a
+	// pod, see PauseContainerAnnotation: the workload of the pause
+	// container is not started.
+	PauseOptimization bool
+	// ShmSize is the size in bytes of the /dev/shm tmpfs of the container,
+	// zero if it isn't a sized tmpfs. It is reported in the sandbox info.
+	ShmSize int64
+	// LeaveShimCgroup, if set, moves processes out of the capped cgroup of
+	// the shim. Sandboxes without a cgroup of their own would otherwise
+	// stay in it.
+	LeaveShimCgroup func(pids ...int) error
 
 	id       string
 	Bundle   string
 	console  console.Console
 	Platform proc.Platform
 	io       runc.IO
-	runtime  *runsc.Runsc
-	status   int
-	exited   time.Time
-	pid      int
-	closers  []io.Closer
-	stdin    io.Closer
-	stdio    proc.Stdio
-	Rootfs   string
-	IoUID    int
-	IoGID    int
-	Sandbox  bool
-	UserLog  string
-	Monitor  ProcessMonitor
+	runtime  Runtime
+	// client is the command line client runtime drives.
+	client  *runsc.Runsc
+	status  int
+	exited  time.Time
+	pid     int
+	closers []io.Closer
+	stdin   io.Closer
+	// stdinCopied is closed once stdin was copied to the process.
+	stdinCopied chan struct{}
+	counters    ioCounters
+	fifos       stdioFifos
+	stdio       proc.Stdio
+	Rootfs      string
+	IoUID       int
+	IoGID       int
+	Sandbox     bool
+	// SandboxID is the id of the sandbox a subcontainer is created in.
+	SandboxID string
+	UserLog   string
+	// Monitor detects the exits of the processes of the container, and
+	// runs the runtime commands of the container. Nil leaves the commands
+	// to the package monitor of go-runsc.
+	Monitor Monitor
+	// Exits receives the exits of the init process and its exec processes.
+	Exits *Exits
+	// StateChanged, if set, is called with each state change of the init
+	// process and its exec processes, in order and with the process
+	// locked.
+	StateChanged func(StateChange)
+	// Signals translates signals sent to the init and exec processes.
+	Signals SignalMap
+	// RunHooks runs the OCI hooks of the spec on the host.
+	RunHooks bool
+	// ChownHelper is the path in the container of the chown binary that
+	// gives volumes to the user of the container, as requested by the
+	// ChownVolumesAnnotation. Empty disables the fixups.
+	ChownHelper string
+	// HoldNamespaces keeps the namespaces joined by path open until the
+	// container is deleted, so that they aren't torn down under it.
+	HoldNamespaces bool
+	// CheckpointCompression is the compression of the image files of the
+	// checkpoints of the container.
+	CheckpointCompression checkpoint.Compression
+	// RuncBinary is the runc binary the container is run with when it opts
+	// out of the sandbox with the UntrustedAnnotation. Empty ignores the
+	// annotation.
+	RuncBinary string
+	// OOMScoreOffset is added to the oomScoreAdj of the spec to get the
+	// OOM score adjustment of the sandbox and gofer processes.
+	OOMScoreOffset int
+	// UserLogToStdout forwards the user log of the sandbox to the stdout of
+	// the container when UserLog is empty.
+	UserLogToStdout bool
+	// RequestedRunscFlags are the runsc flags configured for the shim,
+	// before annotations and profiles were merged in. They are compared to
+	// the flags runsc is run with in the RunscConfig of the container.
+	RequestedRunscFlags map[string]string
+
+	hooks       *hooks
+	chown       *chownPlan
+	namespaces  []*os.File
+	annotations map[string]string
+	latency     latency
+	// forcedStatus, if set, is reported as the exit status instead of the
+	// status the process exited with.
+	forcedStatus *int
+	// restoreImage is the image the container is restored from on start.
+	restoreImage string
+	// restoreOpts are the options runsc restore creates the container
+	// with on start, in place of runsc create.
+	restoreOpts *runsc.RestoreOpts
+	// forceDelete is set by the ForceDeleteAnnotation.
+	forceDelete bool
+	// execMaxRuntime is the default max runtime of exec processes, set by
+	// the ExecMaxRuntimeAnnotation.
+	execMaxRuntime time.Duration
+	// runscConfig is the runsc configuration the container was created
+	// with.
+	runscConfig *runsctypes.RunscConfig
+	// scope is the systemd scope the shim creates for the sandbox.
+	scope *cgroup.Scope
+	// cgroupV2 is the cgroup the shim creates for the sandbox on unified
+	// hosts.
+	cgroupV2 *cgroup.V2
+	// pauseContainer is set for the pause container of a pod with
+	// PauseOptimization, and pauseStarted once it started without its
+	// workload. pauseStatus is the exit status the pause process would
+	// have, accessed atomically.
+	pauseContainer bool
+	pauseStarted   bool
+	pauseStatus    int32
+	// unsandboxed is set once the container is run with runc.
+	unsandboxed bool
+	// oomScoreAdj is the OOM score adjustment applied to the host
+	// processes of the container, if any.
+	oomScoreAdj *int
+	// quiesced is set while the container is paused by Quiesce, and thaw
+	// resumes it once the quiesce timeout expires.
+	quiesced bool
+	thaw     *time.Timer
+	// failure is the reason recorded for the failure of the container
+	// before it exits, and crash the crash found in its debug logs.
+	failure exitReason
+	// reason is why the container exited, once found.
+	reason *exitReason
+	crash  struct {
+		once          sync.Once
+		path, excerpt string
+	}
 }
 
 // NewRunsc returns a new runsc instance for a process
@@ -98,9 +235,11 @@ func New(id string, runtime *runsc.Runsc, stdio proc.Stdio) *Init {
 	p := &Init{
 		id:        id,
 		runtime:   runtime,
+		client:    runtime,
 		stdio:     stdio,
 		status:    0,
 		waitBlock: make(chan struct{}),
+		ioDone:    make(chan struct{}),
 	}
 	p.initState = &createdState{p: p}
 	return p
@@ -108,13 +247,106 @@ func New(id string, runtime *runsc.Runsc, stdio proc.Stdio) *Init {
 
 // Create the process with the provided config
 func (p *Init) Create(ctx context.Context, r *CreateConfig) (err error) {
+	if p.Monitor != nil {
+		p.client.Monitor = p.Monitor
+	}
+	specData, err := r.specData()
+	if err != nil {
+		return err
+	}
+	var spec specs.Spec
+	if err := json.Unmarshal(specData, &spec); err != nil {
+		return errors.Wrap(err, "decode oci spec")
+	}
+	if err := p.selectRuntime(ctx); err != nil {
+		return err
+	}
+	if !p.Sandbox && !p.unsandboxed {
+		if err := p.checkSandbox(ctx); err != nil {
+			return err
+		}
+	}
+	// runc runs the hooks itself.
+	if p.RunHooks && !p.unsandboxed {
+		if p.hooks, err = decodeHooks(specData); err != nil {
+			return errors.Wrap(err, "failed to read OCI hooks")
+		}
+		p.annotations = spec.Annotations
+	}
+	if p.forceDelete, err = forceDeleteRequested(&spec); err != nil {
+		return err
+	}
+	if p.execMaxRuntime, err = readExecMaxRuntime(p.Bundle); err != nil {
+		return err
+	}
+	if p.PauseOptimization && p.Sandbox && !p.unsandboxed {
+		if p.pauseContainer, err = readPauseContainer(p.Bundle); err != nil {
+			return err
+		}
+	}
+	if p.ChownHelper != "" {
+		if p.chown, err = planChown(&spec); err != nil {
+			return err
+		}
+	}
+	if r.Checkpoint != "" {
+		if err := p.unpackCheckpoint(ctx, r.Checkpoint); err != nil {
+			return err
+		}
+		defer func() {
+			if err != nil {
+				os.RemoveAll(p.restoreImage)
+				p.restoreImage = ""
+			}
+		}()
+	}
+	if err := p.prepareSystemdCgroup(ctx, &spec); err != nil {
+		return err
+	}
+	namespaces, err := openNamespaces(&spec)
+	if err != nil {
+		return err
+	}
+	if p.HoldNamespaces {
+		p.namespaces = namespaces
+		defer func() {
+			if err != nil {
+				closeFiles(p.namespaces)
+				p.namespaces = nil
+			}
+		}()
+	} else {
+		closeFiles(namespaces)
+	}
+	if dir := runsc.DebugLogDir(p.runtime.Flags()); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return errors.Wrapf(err, "failed to create debug log directory %q", dir)
+		}
+	}
+	if err := checkStdin(r.Stdin, r.Terminal); err != nil {
+		return err
+	}
+	if err := p.removeLeftover(ctx); err != nil {
+		return err
+	}
+	if err := setupCOWMounts(p.Bundle, p.Mounts); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			removeCOWMounts(p.Bundle, p.Mounts)
+		}
+	}()
 	var socket *runc.Socket
 	if r.Terminal {
-		if socket, err = runc.NewTempConsoleSocket(); err != nil {
-			return errors.Wrap(err, "failed to create OCI runtime console socket")
+		// The console of a restored container is received on start.
+		if p.restoreImage == "" {
+			if socket, err = newConsoleSocket(p.WorkDir, p.id); err != nil {
+				return errors.Wrap(err, "failed to create OCI runtime console socket")
+			}
+			defer socket.Close()
 		}
-		defer socket.Close()
-	} else if hasNoIO(r) {
+	} else if hasNoIO(p.stdio) {
 		if p.io, err = runc.NewNullIO(); err != nil {
 			return errors.Wrap(err, "creating new NULL IO")
 		}
@@ -123,9 +355,13 @@ func (p *Init) Create(ctx context.Context, r *CreateConfig) (err error) {
 			return errors.Wrap(err, "failed to create OCI runtime io pipes")
 		}
 	}
-	pidFile := filepath.Join(p.Bundle, InitPidFile)
+	if p.io != nil {
+		if p.io, err = withStdinFile(p.io, r.Stdin); err != nil {
+			return err
+		}
+	}
 	opts := &runsc.CreateOpts{
-		PidFile: pidFile,
+		PidFile: filepath.Join(p.Bundle, InitPidFile),
 	}
 	if socket != nil {
 		opts.ConsoleSocket = socket
@@ -133,12 +369,32 @@ func (p *Init) Create(ctx context.Context, r *CreateConfig) (err error) {
 	if p.Sandbox {
 		opts.IO = p.io
 		// UserLog is only useful for sandbox.
-		opts.UserLog = p.UserLog
+		if opts.UserLog, err = p.createUserLog(r); err != nil {
+			return err
+		}
+	}
+	if p.unsandboxed {
+		// runc hands the io to the container process on create.
+		opts.IO = p.io
 	}
-	if err := p.runtime.Create(ctx, r.ID, r.Bundle, opts); err != nil {
-		return p.runtimeError(err, "OCI runtime create failed")
+	if err := p.snapshotRunscConfig(opts); err != nil {
+		log.G(ctx).WithError(err).Warn("failed to record runsc config")
 	}
-	if r.Stdin != "" {
+	if p.restoreImage != "" {
+		// runsc restore creates the container itself, on start.
+		p.restoreOpts = &runsc.RestoreOpts{CreateOpts: *opts}
+	} else {
+		createStart := time.Now()
+		if err := p.runtime.Create(ctx, r.ID, r.Bundle, opts); err != nil {
+			if runsc.IsTimeout(err) {
+				p.cleanupCreateTimeout()
+			}
+			return p.runtimeError(err, "OCI runtime create failed")
+		}
+		p.RecordPhase(PhaseRuntimeCreate, createStart, time.Now())
+	}
+	ioStart := time.Now()
+	if stdinFifo(r.Stdin) != "" {
 		sc, err := fifo.OpenFifo(context.Background(), r.Stdin, syscall.O_WRONLY|syscall.O_NONBLOCK, 0)
 		if err != nil {
 			return errors.Wrapf(err, "failed to open stdin fifo %s", r.Stdin)
@@ -147,30 +403,100 @@ func (p *Init) Create(ctx context.Context, r *CreateConfig) (err error) {
 		p.closers = append(p.closers, sc)
 	}
 	var copyWaitGroup sync.WaitGroup
-	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, p.ioTimeout())
 	defer cancel()
 	if socket != nil {
 		console, err := socket.ReceiveMaster()
 		if err != nil {
 			return errors.Wrap(err, "failed to retrieve console master")
 		}
-		console, err = p.Platform.CopyConsole(ctx, console, r.Stdin, r.Stdout, r.Stderr, &p.wg, &copyWaitGroup)
+		console, err = p.Platform.CopyConsole(ctx, console, stdinFifo(r.Stdin), r.Stdout, r.Stderr, &p.wg, &copyWaitGroup)
 		if err != nil {
 			return errors.Wrap(err, "failed to start console copy")
 		}
 		p.console = console
-	} else if !hasNoIO(r) {
-		if err := copyPipes(ctx, p.io, r.Stdin, r.Stdout, r.Stderr, &p.wg, &copyWaitGroup); err != nil {
+		p.fifos = stdioFifos{stdin: stdinFifo(r.Stdin), outputs: []string{r.Stdout}}
+	} else if !hasNoIO(p.stdio) {
+		p.stdinCopied = make(chan struct{})
+		if err := copyPipes(ctx, p.io, r.Stdin, r.Stdout, r.Stderr, &p.wg, &copyWaitGroup, p.stdinCopied, &p.counters, &p.fifos, p.LineBufferStderr); err != nil {
 			return errors.Wrap(err, "failed to start io pipe copy")
 		}
 	}
 
 	copyWaitGroup.Wait()
-	pid, err := runc.ReadPidFile(pidFile)
+	p.RecordPhase(PhaseIOSetup, ioStart, time.Now())
+	if p.restoreOpts != nil {
+		if !r.Terminal {
+			closeWhenCopied(&p.wg, p.ioDone)
+		}
+		return nil
+	}
+	closeWhenCopied(&p.wg, p.ioDone)
+	return p.created(ctx)
+}
+
+// created finishes the creation of the container once runsc created it:
+// the sandbox is moved to its scope or cgroup, and the create hooks are run.
+func (p *Init) created(ctx context.Context) error {
+	pid, err := runc.ReadPidFile(filepath.Join(p.Bundle, InitPidFile))
 	if err != nil {
 		return errors.Wrap(err, "failed to retrieve OCI runtime container pid")
 	}
 	p.pid = pid
+	p.applyOOMScore(ctx)
+	if p.scope != nil {
+		if err := p.startSystemdScope(ctx); err != nil {
+			if err := p.runtime.Delete(ctx, p.id, &runsc.DeleteOpts{Force: true}); err != nil {
+				log.G(ctx).WithError(err).Errorf("Failed to delete container %q after scope failure", p.id)
+			}
+			return err
+		}
+	}
+	if err := p.applyCgroupV2(ctx); err != nil {
+		if err := p.runtime.Delete(ctx, p.id, &runsc.DeleteOpts{Force: true}); err != nil {
+			log.G(ctx).WithError(err).Errorf("Failed to delete container %q after cgroup failure", p.id)
+		}
+		return err
+	}
+	p.leaveShimCgroup(ctx)
+	if p.hooks != nil {
+		if err := p.runCreateHooks(ctx); err != nil {
+			if err := p.runtime.Delete(ctx, p.id, &runsc.DeleteOpts{Force: true}); err != nil {
+				log.G(ctx).WithError(err).Errorf("Failed to delete container %q after hook failure", p.id)
+			}
+			p.stopSystemdScope(ctx)
+			p.deleteCgroupV2(ctx)
+			return err
+		}
+	}
+	return nil
+}
+
+// runCreateHooks runs the prestart and createRuntime hooks once the sandbox
+// is created and before the container process starts.
+func (p *Init) runCreateHooks(ctx context.Context) error {
+	if err := p.runHooks(ctx, "prestart", p.hooks.Prestart, "created"); err != nil {
+		return err
+	}
+	return p.runHooks(ctx, "createRuntime", p.hooks.CreateRuntime, "created")
+}
+
+// checkSandbox ensures the sandbox a subcontainer joins is running. runsc
+// creates subcontainers inside the sandbox named by the spec annotations.
+func (p *Init) checkSandbox(ctx context.Context) error {
+	if p.SandboxID == "" {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "container %q has no sandbox id", p.id)
+	}
+	c, err := p.runtime.State(ctx, p.SandboxID)
+	if err != nil {
+		if runsc.IsNotFound(err) {
+			return errors.Wrapf(errdefs.ErrFailedPrecondition, "sandbox %q not found", p.SandboxID)
+		}
+		return p.runtimeError(err, "OCI runtime state failed")
+	}
+	if c.Status != "running" {
+		return errors.Wrapf(errdefs.ErrFailedPrecondition, "sandbox %q is %s", p.SandboxID, c.Status)
+	}
 	return nil
 }
 
@@ -207,9 +533,13 @@ func (p *Init) ExitedAt() time.Time {
 func (p *Init) Status(ctx context.Context) (string, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	if p.restoreOpts != nil {
+		// runsc restore creates the container on start.
+		return "created", nil
+	}
 	c, err := p.runtime.State(ctx, p.id)
 	if err != nil {
-		if strings.Contains(err.Error(), "does not exist") {
+		if runsc.IsNotFound(err) {
 			return "stopped", nil
 		}
 		return "", p.runtimeError(err, "OCI runtime state failed")
@@ -230,12 +560,49 @@ func (p *Init) start(context context.Context) error {
 	if !p.Sandbox {
 		cio = p.io
 	}
-	if err := p.runtime.Start(context, p.id, cio); err != nil {
-		return p.runtimeError(err, "OCI runtime start failed")
+	if p.chown != nil {
+		if err := p.chownVolumes(context); err != nil {
+			return err
+		}
+	}
+	if p.restoreImage != "" {
+		if err := p.restore(context); err != nil {
+			return err
+		}
+	} else if p.pauseContainer {
+		p.startPause(context)
+	} else {
+		start := time.Now()
+		if err := p.runtime.Start(context, p.id, cio); err != nil {
+			if runsc.IsTimeout(err) {
+				p.cleanupStartTimeout()
+			}
+			return p.runtimeError(err, "OCI runtime start failed")
+		}
+		p.RecordPhase(PhaseRuntimeStart, start, time.Now())
+	}
+	p.writeSandboxInfo(context)
+	if p.Sandbox && failpoint.Inject(failpoint.GoferDeath) != nil {
+		p.killGofers(context)
+	}
+	if p.hooks != nil {
+		if err := p.runHooks(context, "poststart", p.hooks.Poststart, "running"); err != nil {
+			log.G(context).WithError(err).Warnf("Poststart hook failed for container %q", p.id)
+		}
 	}
 	go func() {
+		if p.pauseStarted {
+			p.Exits.Publish(Exit{
+				Timestamp: time.Now(),
+				ID:        p.id,
+				Status:    p.waitPause(),
+			})
+			return
+		}
 		status, err := p.runtime.Wait(context, p.id)
-		if err != nil {
+		if err == nil {
+			status = normalizeExitStatus(context, p.id, status)
+		} else {
 			log.G(context).WithError(err).Errorf("Failed to wait for container %q", p.id)
 			// TODO(random-liu): Handle runsc kill error.
 			if err := p.killAll(context); err != nil {
@@ -243,15 +610,59 @@ func (p *Init) start(context context.Context) error {
 			}
 			status = internalErrorCode
 		}
-		ExitCh <- Exit{
+		p.Exits.Publish(Exit{
 			Timestamp: time.Now(),
 			ID:        p.id,
 			Status:    status,
-		}
+		})
 	}()
 	return nil
 }
 
+// Pause freezes the processes of the container.
+func (p *Init) Pause(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.initState.Pause(ctx)
+}
+
+func (p *Init) pause(ctx context.Context) error {
+	if err := p.runtime.Pause(ctx, p.id); err != nil {
+		return p.runtimeError(err, "OCI runtime pause failed")
+	}
+	return nil
+}
+
+// Resume unfreezes the processes of the paused container.
+func (p *Init) Resume(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.initState.Resume(ctx); err != nil {
+		return err
+	}
+	// The container is no longer frozen for the quiesce, a later pause
+	// must not be undone by its timeout.
+	p.endQuiesce()
+	return nil
+}
+
+func (p *Init) resume(ctx context.Context) error {
+	if err := p.runtime.Resume(ctx, p.id); err != nil {
+		return p.runtimeError(err, "OCI runtime resume failed")
+	}
+	return nil
+}
+
+// Checkpoint saves the state of the container to a checkpoint.
+func (p *Init) Checkpoint(ctx context.Context, r *CheckpointConfig) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.initState.Checkpoint(ctx, r)
+}
+
 // SetExited of the init process with the next status
 func (p *Init) SetExited(status int) {
 	p.mu.Lock()
@@ -263,6 +674,9 @@ func (p *Init) SetExited(status int) {
 func (p *Init) setExited(status int) {
 	p.exited = time.Now()
 	p.status = status
+	if p.forcedStatus != nil {
+		p.status = *p.forcedStatus
+	}
 	p.Platform.ShutdownConsole(context.Background(), p.console)
 	close(p.waitBlock)
 }
@@ -276,33 +690,57 @@ func (p *Init) Delete(ctx context.Context) error {
 }
 
 func (p *Init) delete(ctx context.Context) error {
-	p.killAll(ctx)
+	if !p.forceDelete {
+		// runsc delete --force kills the sandbox without the sentry.
+		p.killAll(ctx)
+	}
 	p.wg.Wait()
-	err := p.runtime.Delete(ctx, p.id, nil)
-	// ignore errors if a runtime has already deleted the process
-	// but we still hold metadata and pipes
-	//
-	// this is common during a checkpoint, runc will delete the container state
-	// after a checkpoint and the container will no longer exist within runc
+	forced, err := p.deleteRuntime(ctx)
 	if err != nil {
-		if strings.Contains(err.Error(), "does not exist") {
-			err = nil
-		} else {
-			err = p.runtimeError(err, "failed to delete task")
+		// The container can't be deleted any further, don't keep it
+		// around.
+		log.G(ctx).WithError(err).Errorf("Failed to force delete container %q", p.id)
+		err = nil
+	}
+	p.stopSystemdScope(ctx)
+	p.deleteCgroupV2(ctx)
+	if p.hooks != nil {
+		if err := p.runHooks(ctx, "poststop", p.hooks.Poststop, "stopped"); err != nil {
+			log.G(ctx).WithError(err).Warnf("Poststop hook failed for container %q", p.id)
 		}
 	}
+	closeFiles(p.namespaces)
+	p.namespaces = nil
 	if p.io != nil {
 		for _, c := range p.closers {
 			c.Close()
 		}
 		p.io.Close()
 	}
-	if err2 := mount.UnmountAll(p.Rootfs, 0); err2 != nil {
-		log.G(ctx).WithError(err2).Warn("failed to cleanup rootfs mount")
-		if err == nil {
-			err = errors.Wrap(err2, "failed rootfs umount")
+	removeDanglingFifos(ctx, p.fifos)
+	if !forced {
+		err = UnmountRootfs(p.Rootfs, p.Mounts)
+		if err != nil {
+			log.G(ctx).WithError(err).Warn("failed to cleanup rootfs mount, detaching it")
+		}
+	}
+	if forced || err != nil {
+		if err = DetachRootfs(p.Rootfs, p.Mounts); err != nil {
+			log.G(ctx).WithError(err).Warn("failed to detach rootfs mount")
+			err = errors.Wrap(err, "failed rootfs umount")
 		}
 	}
+	if cerr := removeCOWMounts(p.Bundle, p.Mounts); cerr != nil {
+		log.G(ctx).WithError(cerr).Warn("failed to remove copy-on-write mounts")
+	}
+	if p.MountWatchdog != nil {
+		// The unmounts of leaked mounts are retried, which mustn't hold
+		// up the delete, nor the process lock.
+		go p.MountWatchdog.Verify(ctx, p.Bundle)
+	}
+	if !p.KeepArtifacts {
+		p.removed = p.removeArtifacts(ctx)
+	}
 	return err
 }
 
@@ -333,6 +771,13 @@ func (p *Init) Kill(ctx context.Context, signal uint32, all bool) error {
 }
 
 func (p *Init) kill(context context.Context, signal uint32, all bool) error {
+	signal, err := p.translateSignal(context, signal)
+	if err != nil {
+		return err
+	}
+	if p.pauseStarted {
+		return p.killPause(signal)
+	}
 	var (
 		killErr error
 		backoff = 100 * time.Millisecond
@@ -341,7 +786,7 @@ func (p *Init) kill(context context.Context, signal uint32, all bool) error {
 	for start := time.Now(); time.Now().Sub(start) < timeout; {
 		c, err := p.runtime.State(context, p.id)
 		if err != nil {
-			if strings.Contains(err.Error(), "does not exist") {
+			if runsc.IsNotFound(err) {
 				return errors.Wrapf(errdefs.ErrNotFound, "no such process")
 			}
 			return p.runtimeError(err, "OCI runtime state failed")
@@ -364,6 +809,27 @@ func (p *Init) kill(context context.Context, signal uint32, all bool) error {
 	return p.runtimeError(killErr, "kill timeout")
 }
 
+// translateSignal maps sig to the signal delivered to the sandbox.
+func (p *Init) translateSignal(ctx context.Context, sig uint32) (uint32, error) {
+	to, err := p.Signals.Translate(sig)
+	if err != nil {
+		return 0, err
+	}
+	if to != sig {
+		log.G(ctx).Debugf("Translated signal %s to %s for container %q", signalName(sig), signalName(to), p.id)
+	}
+	return to, nil
+}
+
+// ForceExit kills the container and everything in its sandbox, and reports
+// status as its exit status, e.g. for sandboxes killed by the watchdog.
+func (p *Init) ForceExit(ctx context.Context, status int) error {
+	p.mu.Lock()
+	p.forcedStatus = &status
+	p.mu.Unlock()
+	return p.Kill(ctx, uint32(syscall.SIGKILL), true)
+}
+
 // KillAll processes belonging to the init process
 func (p *Init) KillAll(context context.Context) error {
 	p.mu.Lock()
@@ -387,9 +853,29 @@ func (p *Init) Stdin() io.Closer {
 	return p.stdin
 }
 
+// CloseStdin closes stdin once the buffered input reached the process.
+func (p *Init) CloseStdin(ctx context.Context) error {
+	p.mu.Lock()
+	stdin, copied, cons := p.stdin, p.stdinCopied, p.console
+	p.mu.Unlock()
+	if cons != nil {
+		return closeTerminalStdin(ctx, p.id, p.Platform, cons, p.TerminalStdinEOF, stdin, p.Kill)
+	}
+	return closeStdin(ctx, p.id, stdin, copied)
+}
+
 // Runtime returns the OCI runtime configured for the init process
 func (p *Init) Runtime() *runsc.Runsc {
-	return p.runtime
+	return p.client
+}
+
+// commandMonitor returns the monitor starting and waiting for the commands
+// the shim runs for the container.
+func (p *Init) commandMonitor() runc.ProcessMonitor {
+	if p.Monitor != nil {
+		return p.Monitor
+	}
+	return runsc.Monitor
 }
 
 // Exec returns a new child process
@@ -403,11 +889,23 @@ func (p *Init) Exec(ctx context.Context, path string, r *ExecConfig) (proc.Proce
 // exec returns a new exec'd process
 func (p *Init) exec(ctx context.Context, path string, r *ExecConfig) (proc.Process, error) {
 	// process exec request
-	var spec specs.Process
-	if err := json.Unmarshal(r.Spec.Value, &spec); err != nil {
+	es, err := decodeExecSpec(r)
+	if err != nil {
 		return nil, err
 	}
+	spec := es.Process
 	spec.Terminal = r.Terminal
+	maxRuntime := es.MaxRuntime
+	if maxRuntime == 0 {
+		maxRuntime = p.execMaxRuntime
+	}
+	if mounts := append(es.Mounts, r.Mounts...); len(mounts) > 0 {
+		// The exec is refused before it is added, it could never start
+		// with its mounts.
+		if err := p.addMounts(ctx, mounts); err != nil {
+			return nil, errors.Wrapf(err, "exec %s", r.ID)
+		}
+	}
 
 	e := &execProcess{
 		id:     r.ID,
@@ -420,7 +918,9 @@ func (p *Init) exec(ctx context.Context, path string, r *ExecConfig) (proc.Proce
 			Stderr:   r.Stderr,
 			Terminal: r.Terminal,
 		},
-		waitBlock: make(chan struct{}),
+		maxRuntime: maxRuntime,
+		waitBlock:  make(chan struct{}),
+		ioDone:     make(chan struct{}),
 	}
 	e.execState = &execCreatedState{p: e}
 	return e, nil
@@ -436,18 +936,26 @@ func (p *Init) runtimeError(rErr error, msg string) error {
 		return nil
 	}
 
-	rMsg, err := getLastRuntimeError(p.runtime)
+	rMsg, err := getLastRuntimeError(p.client)
 	switch {
 	case err != nil:
 		return errors.Wrapf(rErr, "%s: %s (%s)", msg, "unable to retrieve OCI runtime error", err.Error())
 	case rMsg == "":
 		return errors.Wrap(rErr, msg)
 	default:
-		return errors.Errorf("%s: %s", msg, rMsg)
+		kind := classify(rErr, rMsg)
+		if kind == nil {
+			return errors.Errorf("%s: %s", msg, rMsg)
+		}
+		return &runtimeErr{msg: msg + ": " + rMsg, kind: kind}
 	}
 }
 
 func (p *Init) convertStatus(status string) string {
+	if status == "created" && p.pauseStarted {
+		// The sandbox of the pause container runs in its place.
+		return "running"
+	}
 	if status == "created" && !p.Sandbox && p.status == internalErrorCode {
 		// Treat start failure state for non-root container as stopped.
 		return "stopped"
@@ -457,7 +965,7 @@ func (p *Init) convertStatus(status string) string {
 
 func withConditionalIO(c proc.Stdio) runc.IOOpt {
 	return func(o *runc.IOOption) {
-		o.OpenStdin = c.Stdin != ""
+		o.OpenStdin = stdinFifo(c.Stdin) != ""
 		o.OpenStdout = c.Stdout != ""
 		o.OpenStderr = c.Stderr != ""
 	}
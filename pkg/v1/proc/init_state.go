@@ -30,8 +30,11 @@ type initState interface {
 	Resize(console.WinSize) error
 	Start(context.Context) error
 	Delete(context.Context) error
+	Pause(context.Context) error
+	Resume(context.Context) error
 	Exec(context.Context, string, *ExecConfig) (proc.Process, error)
 	Kill(context.Context, uint32, bool) error
+	Checkpoint(context.Context, *CheckpointConfig) error
 	SetExited(int)
 }
 
@@ -39,20 +42,6 @@ type createdState struct {
 	p *Init
 }
 
-func (s *createdState) transition(name string) error {
-	switch name {
-	case "running":
-		s.p.initState = &runningState{p: s.p}
-	case "stopped":
-		s.p.initState = &stoppedState{p: s.p}
-	case "deleted":
-		s.p.initState = &deletedState{}
-	default:
-		return errors.Errorf("invalid state transition %q to %q", stateName(s), name)
-	}
-	return nil
-}
-
 func (s *createdState) Resize(ws console.WinSize) error {
 	return s.p.resize(ws)
 }
@@ -68,30 +57,42 @@ func (s *createdState) Start(ctx context.Context) error {
 		if !s.p.Sandbox {
 			s.p.io.Close()
 			s.p.setExited(internalErrorCode)
-			if err := s.transition("stopped"); err != nil {
+			if err := s.p.transition(Stopped); err != nil {
 				panic(err)
 			}
 		}
 		return err
 	}
-	return s.transition("running")
+	return s.p.transition(Running)
 }
 
 func (s *createdState) Delete(ctx context.Context) error {
 	if err := s.p.delete(ctx); err != nil {
 		return err
 	}
-	return s.transition("deleted")
+	return s.p.transition(Deleted)
+}
+
+func (s *createdState) Pause(ctx context.Context) error {
+	return errors.Wrap(errdefs.ErrFailedPrecondition, "cannot pause a created container")
+}
+
+func (s *createdState) Resume(ctx context.Context) error {
+	return errors.Wrap(errdefs.ErrFailedPrecondition, "cannot resume a created container")
 }
 
 func (s *createdState) Kill(ctx context.Context, sig uint32, all bool) error {
 	return s.p.kill(ctx, sig, all)
 }
 
+func (s *createdState) Checkpoint(ctx context.Context, r *CheckpointConfig) error {
+	return errors.Wrap(errdefs.ErrFailedPrecondition, "cannot checkpoint a created container")
+}
+
 func (s *createdState) SetExited(status int) {
 	s.p.setExited(status)
 
-	if err := s.transition("stopped"); err != nil {
+	if err := s.p.transition(Stopped); err != nil {
 		panic(err)
 	}
 }
@@ -104,36 +105,44 @@ type runningState struct {
 	p *Init
 }
 
-func (s *runningState) transition(name string) error {
-	switch name {
-	case "stopped":
-		s.p.initState = &stoppedState{p: s.p}
-	default:
-		return errors.Errorf("invalid state transition %q to %q", stateName(s), name)
-	}
-	return nil
-}
-
 func (s *runningState) Resize(ws console.WinSize) error {
 	return s.p.resize(ws)
 }
 
 func (s *runningState) Start(ctx context.Context) error {
-	return errors.Errorf("cannot start a running process")
+	return errors.Wrap(errdefs.ErrFailedPrecondition, "cannot start a running process")
 }
 
 func (s *runningState) Delete(ctx context.Context) error {
-	return errors.Errorf("cannot delete a running process")
+	if !s.p.forceDelete {
+		return errors.Wrap(errdefs.ErrFailedPrecondition, "cannot delete a running process")
+	}
+	return s.p.forceDeleteRunning(ctx)
+}
+
+func (s *runningState) Pause(ctx context.Context) error {
+	if err := s.p.pause(ctx); err != nil {
+		return err
+	}
+	return s.p.transition(Paused)
+}
+
+func (s *runningState) Resume(ctx context.Context) error {
+	return errors.Wrap(errdefs.ErrFailedPrecondition, "cannot resume a running container")
 }
 
 func (s *runningState) Kill(ctx context.Context, sig uint32, all bool) error {
 	return s.p.kill(ctx, sig, all)
 }
 
+func (s *runningState) Checkpoint(ctx context.Context, r *CheckpointConfig) error {
+	return s.p.checkpoint(ctx, r)
+}
+
 func (s *runningState) SetExited(status int) {
 	s.p.setExited(status)
 
-	if err := s.transition("stopped"); err != nil {
+	if err := s.p.transition(Stopped); err != nil {
 		panic(err)
 	}
 }
@@ -142,43 +151,95 @@ func (s *runningState) Exec(ctx context.Context, path string, r *ExecConfig) (pr
 	return s.p.exec(ctx, path, r)
 }
 
-type stoppedState struct {
+type pausedState struct {
 	p *Init
 }
 
-func (s *stoppedState) transition(name string) error {
-	switch name {
-	case "deleted":
-		s.p.initState = &deletedState{}
-	default:
-		return errors.Errorf("invalid state transition %q to %q", stateName(s), name)
+func (s *pausedState) Resize(ws console.WinSize) error {
+	return s.p.resize(ws)
+}
+
+func (s *pausedState) Start(ctx context.Context) error {
+	return errors.Wrap(errdefs.ErrFailedPrecondition, "cannot start a paused process")
+}
+
+func (s *pausedState) Delete(ctx context.Context) error {
+	if !s.p.forceDelete {
+		return errors.Wrap(errdefs.ErrFailedPrecondition, "cannot delete a paused process")
+	}
+	return s.p.forceDeleteRunning(ctx)
+}
+
+func (s *pausedState) Pause(ctx context.Context) error {
+	return errors.Wrap(errdefs.ErrFailedPrecondition, "cannot pause a paused container")
+}
+
+func (s *pausedState) Resume(ctx context.Context) error {
+	if err := s.p.resume(ctx); err != nil {
+		return err
+	}
+	return s.p.transition(Running)
+}
+
+func (s *pausedState) Kill(ctx context.Context, sig uint32, all bool) error {
+	return s.p.kill(ctx, sig, all)
+}
+
+func (s *pausedState) Checkpoint(ctx context.Context, r *CheckpointConfig) error {
+	return errors.Wrap(errdefs.ErrFailedPrecondition, "cannot checkpoint a paused container")
+}
+
+func (s *pausedState) SetExited(status int) {
+	s.p.setExited(status)
+
+	if err := s.p.transition(Stopped); err != nil {
+		panic(err)
 	}
-	return nil
+}
+
+func (s *pausedState) Exec(ctx context.Context, path string, r *ExecConfig) (proc.Process, error) {
+	return nil, errors.Wrap(errdefs.ErrFailedPrecondition, "cannot exec in a paused container")
+}
+
+type stoppedState struct {
+	p *Init
 }
 
 func (s *stoppedState) Resize(ws console.WinSize) error {
-	return errors.Errorf("cannot resize a stopped container")
+	return errors.Wrap(errdefs.ErrFailedPrecondition, "cannot resize a stopped container")
 }
 
 func (s *stoppedState) Start(ctx context.Context) error {
-	return errors.Errorf("cannot start a stopped process")
+	return errors.Wrap(errdefs.ErrFailedPrecondition, "cannot start a stopped process")
 }
 
 func (s *stoppedState) Delete(ctx context.Context) error {
 	if err := s.p.delete(ctx); err != nil {
 		return err
 	}
-	return s.transition("deleted")
+	return s.p.transition(Deleted)
+}
+
+func (s *stoppedState) Pause(ctx context.Context) error {
+	return errors.Wrap(errdefs.ErrFailedPrecondition, "cannot pause a stopped container")
+}
+
+func (s *stoppedState) Resume(ctx context.Context) error {
+	return errors.Wrap(errdefs.ErrFailedPrecondition, "cannot resume a stopped container")
 }
 
 func (s *stoppedState) Kill(ctx context.Context, sig uint32, all bool) error {
 	return errdefs.ToGRPCf(errdefs.ErrNotFound, "process %s not found", s.p.id)
 }
 
+func (s *stoppedState) Checkpoint(ctx context.Context, r *CheckpointConfig) error {
+	return errors.Wrap(errdefs.ErrFailedPrecondition, "cannot checkpoint a stopped container")
+}
+
 func (s *stoppedState) SetExited(status int) {
 	// no op
 }
 
 func (s *stoppedState) Exec(ctx context.Context, path string, r *ExecConfig) (proc.Process, error) {
-	return nil, errors.Errorf("cannot exec in a stopped state")
+	return nil, errors.Wrap(errdefs.ErrFailedPrecondition, "cannot exec in a stopped state")
 }
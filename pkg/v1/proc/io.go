@@ -18,13 +18,17 @@ limitations under the License.
 package proc
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"os"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
+	"github.com/containerd/containerd/log"
 	"github.com/containerd/fifo"
 	runc "github.com/containerd/go-runc"
 )
@@ -38,7 +42,15 @@ var bufPool = sync.Pool{
 	},
 }
 
-func copyPipes(ctx context.Context, rio runc.IO, stdin, stdout, stderr string, wg, cwg *sync.WaitGroup) error {
+// stdinFlushTimeout bounds how long closing stdin waits for the data already
+// written by the client to be copied to the process.
+const stdinFlushTimeout = 5 * time.Second
+
+// copyPipes copies the process io from and to the fifos, counting the bytes
+// copied in counters and recording the fifos opened in fifos. stdinCopied
+// is closed once stdin reached EOF and was closed on the process side.
+// lineStderr copies stderr in whole lines.
+func copyPipes(ctx context.Context, rio runc.IO, stdin, stdout, stderr string, wg, cwg *sync.WaitGroup, stdinCopied chan struct{}, counters *ioCounters, fifos *stdioFifos, lineStderr bool) error {
 	var sameFile io.WriteCloser
 	for _, i := range []struct {
 		name string
@@ -53,7 +65,10 @@ func copyPipes(ctx context.Context, rio runc.IO, stdin, stdout, stderr string, w
 					cwg.Done()
 					p := bufPool.Get().(*[]byte)
 					defer bufPool.Put(p)
-					io.CopyBuffer(wc, rio.Stdout(), *p)
+					w := &countingWriter{w: wc, n: &counters.stdout}
+					if _, err := io.CopyBuffer(w, rio.Stdout(), *p); err != nil {
+						logCopyError(ctx, stdout, err, atomic.LoadUint64(&counters.stdout))
+					}
 					wg.Done()
 					wc.Close()
 					if rc != nil {
@@ -70,7 +85,21 @@ func copyPipes(ctx context.Context, rio runc.IO, stdin, stdout, stderr string, w
 					cwg.Done()
 					p := bufPool.Get().(*[]byte)
 					defer bufPool.Put(p)
-					io.CopyBuffer(wc, rio.Stderr(), *p)
+					var w io.Writer = &countingWriter{w: wc, n: &counters.stderr}
+					var lw *lineWriter
+					if lineStderr {
+						lw = newLineWriter(w)
+						w = lw
+					}
+					_, err := io.CopyBuffer(w, rio.Stderr(), *p)
+					if lw != nil {
+						if ferr := lw.Flush(); err == nil {
+							err = ferr
+						}
+					}
+					if err != nil {
+						logCopyError(ctx, stderr, err, atomic.LoadUint64(&counters.stderr))
+					}
 					wg.Done()
 					wc.Close()
 					if rc != nil {
@@ -80,55 +109,153 @@ func copyPipes(ctx context.Context, rio runc.IO, stdin, stdout, stderr string, w
 			},
 		},
 	} {
-		ok, err := isFifo(i.name)
+		if sameFile != nil {
+			i.dest(sameFile, nil)
+			continue
+		}
+		fw, fr, err := openOutput(ctx, i.name)
 		if err != nil {
 			return err
 		}
-		var (
-			fw io.WriteCloser
-			fr io.Closer
-		)
-		if ok {
-			if fw, err = fifo.OpenFifo(ctx, i.name, syscall.O_WRONLY, 0); err != nil {
-				return fmt.Errorf("gvisor-containerd-shim: opening %s failed: %s", i.name, err)
-			}
-			if fr, err = fifo.OpenFifo(ctx, i.name, syscall.O_RDONLY, 0); err != nil {
-				return fmt.Errorf("gvisor-containerd-shim: opening %s failed: %s", i.name, err)
-			}
-		} else {
-			if sameFile != nil {
-				i.dest(sameFile, nil)
-				continue
-			}
-			if fw, err = os.OpenFile(i.name, syscall.O_WRONLY|syscall.O_APPEND, 0); err != nil {
-				return fmt.Errorf("gvisor-containerd-shim: opening %s failed: %s", i.name, err)
-			}
-			if stdout == stderr {
-				sameFile = fw
-			}
+		if fr == nil && stdout == stderr {
+			sameFile = fw
+		}
+		if fr != nil {
+			fifos.addOutput(i.name)
 		}
 		i.dest(fw, fr)
 	}
-	if stdin == "" {
+	if stdinFifo(stdin) == "" {
 		return nil
 	}
 	f, err := fifo.OpenFifo(context.Background(), stdin, syscall.O_RDONLY|syscall.O_NONBLOCK, 0)
 	if err != nil {
 		return fmt.Errorf("gvisor-containerd-shim: opening %s failed: %s", stdin, err)
 	}
+	fifos.stdin = stdin
 	cwg.Add(1)
 	go func() {
 		cwg.Done()
 		p := bufPool.Get().(*[]byte)
 		defer bufPool.Put(p)
 
-		io.CopyBuffer(rio.Stdin(), f, *p)
+		io.CopyBuffer(&countingWriter{w: rio.Stdin(), n: &counters.stdin}, f, *p)
 		rio.Stdin().Close()
 		f.Close()
+		close(stdinCopied)
 	}()
 	return nil
 }
 
+// lineFlushInterval bounds how long lineWriter holds a partial line, e.g. a
+// prompt, before writing it.
+const lineFlushInterval = time.Second
+
+// maxLineBuffer is the longest partial line lineWriter holds, longer lines
+// are written in chunks.
+const maxLineBuffer = 64 << 10
+
+// lineWriter writes the output written to it in whole lines, so that the
+// lines of processes sharing a log aren't interleaved mid-line. A partial
+// line is written once held for lineFlushInterval or once it reaches
+// maxLineBuffer.
+type lineWriter struct {
+	mu    sync.Mutex
+	w     io.Writer
+	buf   []byte
+	timer *time.Timer
+	err   error
+}
+
+func newLineWriter(w io.Writer) *lineWriter {
+	return &lineWriter{w: w}
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return 0, l.err
+	}
+	l.buf = append(l.buf, p...)
+	if i := bytes.LastIndexByte(l.buf, '\n'); i >= 0 {
+		l.flush(i + 1)
+	}
+	if len(l.buf) >= maxLineBuffer {
+		l.flush(len(l.buf))
+	}
+	if len(l.buf) > 0 && l.timer == nil {
+		l.timer = time.AfterFunc(lineFlushInterval, l.flushPartial)
+	}
+	if l.err != nil {
+		return 0, l.err
+	}
+	return len(p), nil
+}
+
+// flush writes the first n buffered bytes. The caller holds mu.
+func (l *lineWriter) flush(n int) {
+	if n == 0 || l.err != nil {
+		return
+	}
+	if _, err := l.w.Write(l.buf[:n]); err != nil {
+		l.err = err
+	}
+	l.buf = l.buf[:copy(l.buf, l.buf[n:])]
+	if len(l.buf) == 0 && l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+}
+
+func (l *lineWriter) flushPartial() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.timer = nil
+	l.flush(len(l.buf))
+}
+
+// Flush writes the partial line held, e.g. once the output reached EOF.
+func (l *lineWriter) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.flush(len(l.buf))
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+	return l.err
+}
+
+// logCopyError logs a failed copy to a fifo, which usually means that the
+// reader of the output went away and the process may block on writes.
+func logCopyError(ctx context.Context, name string, err error, copied uint64) {
+	log.G(ctx).WithError(err).WithField("bytes", copied).Warnf("Failed to copy process output to %s", name)
+}
+
+// closeStdin closes the shim's end of the stdin fifo, then waits for the
+// buffered input to be copied to the process so that it sees all the data
+// before EOF. copied is nil when stdin is not copied by the shim.
+func closeStdin(ctx context.Context, id string, stdin io.Closer, copied <-chan struct{}) error {
+	if stdin == nil {
+		return nil
+	}
+	if err := stdin.Close(); err != nil {
+		return err
+	}
+	if copied == nil {
+		return nil
+	}
+	select {
+	case <-copied:
+	case <-time.After(stdinFlushTimeout):
+		log.G(ctx).Warnf("Timed out flushing stdin of process %q", id)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
 // isFifo checks if a file is a fifo
 // if the file does not exist then it returns false
 func isFifo(path string) (bool, error) {
@@ -0,0 +1,84 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// ioCounters counts the bytes copied between a process and its fifos. The
+// fields are accessed atomically. Terminal io is copied by the platform and
+// is not counted.
+type ioCounters struct {
+	stdin  uint64
+	stdout uint64
+	stderr uint64
+}
+
+func (c *ioCounters) stats(containerID, execID string) runsctypes.IOStats {
+	return runsctypes.IOStats{
+		ContainerID: containerID,
+		ExecID:      execID,
+		Stdin:       atomic.LoadUint64(&c.stdin),
+		Stdout:      atomic.LoadUint64(&c.stdout),
+		Stderr:      atomic.LoadUint64(&c.stderr),
+	}
+}
+
+// countingWriter adds the bytes written to w to n.
+type countingWriter struct {
+	w io.Writer
+	n *uint64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	atomic.AddUint64(c.n, uint64(n))
+	return n, err
+}
+
+// IOStats returns the bytes copied from and to the init process.
+func (p *Init) IOStats() runsctypes.IOStats {
+	return p.counters.stats(p.id, "")
+}
+
+// IOStats returns the bytes copied from and to the exec process.
+func (e *execProcess) IOStats() runsctypes.IOStats {
+	return e.counters.stats(e.parent.id, e.id)
+}
+
+// closeWhenCopied closes done once the io copies added to wg are finished.
+func closeWhenCopied(wg *sync.WaitGroup, done chan struct{}) {
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+}
+
+// IODone is closed once the output of the started init process is copied.
+func (p *Init) IODone() <-chan struct{} {
+	return p.ioDone
+}
+
+// IODone is closed once the output of the started exec process is copied.
+func (e *execProcess) IODone() <-chan struct{} {
+	return e.ioDone
+}
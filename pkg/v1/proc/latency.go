@@ -0,0 +1,78 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// Phases of the creation and start of a container.
+const (
+	// PhaseRootfsMount is the mount of the container rootfs by the shim.
+	PhaseRootfsMount = "rootfs-mount"
+	// PhaseRuntimeCreate is runsc create.
+	PhaseRuntimeCreate = "runsc-create"
+	// PhaseIOSetup is the setup of the console, or of the io pipes, of the
+	// container.
+	PhaseIOSetup = "io-setup"
+	// PhaseRuntimeStart is runsc start.
+	PhaseRuntimeStart = "runsc-start"
+	// PhaseCheckpointUnpack is the check and decompression of the
+	// checkpoint a container is restored from.
+	PhaseCheckpointUnpack = "checkpoint-unpack"
+	// PhaseRuntimeRestore is runsc restore, which replaces runsc start for
+	// containers restored from a checkpoint.
+	PhaseRuntimeRestore = "runsc-restore"
+)
+
+// latency records the phases of the creation and start of a container.
+type latency struct {
+	mu     sync.Mutex
+	phases []runsctypes.LatencyPhase
+}
+
+// RecordPhase records that the phase name of the creation or start of the
+// container ran from start to end.
+func (p *Init) RecordPhase(name string, start, end time.Time) {
+	p.latency.mu.Lock()
+	defer p.latency.mu.Unlock()
+	p.latency.phases = append(p.latency.phases, runsctypes.LatencyPhase{
+		Name:     name,
+		Start:    start,
+		Duration: end.Sub(start),
+	})
+}
+
+// StartLatency returns the phases of the creation and start of the
+// container recorded so far.
+func (p *Init) StartLatency() *runsctypes.StartLatency {
+	p.latency.mu.Lock()
+	defer p.latency.mu.Unlock()
+	l := &runsctypes.StartLatency{
+		ContainerID: p.id,
+		Pid:         uint32(p.Pid()),
+		Phases:      append([]runsctypes.LatencyPhase(nil), p.latency.phases...),
+	}
+	if n := len(l.Phases); n > 0 {
+		first, last := l.Phases[0], l.Phases[n-1]
+		l.Total = last.Start.Add(last.Duration).Sub(first.Start)
+	}
+	return l
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/pkg/errors"
+
+	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
+)
+
+// removeLeftover makes Create idempotent. A Create retried after a partial
+// failure, e.g. a shim killed mid-create, finds the container left by the
+// first attempt, which runsc refuses to create again. Its io belongs to the
+// dead shim, so it can't be adopted: a leftover of the same bundle that
+// never started is deleted so that the creation starts over. Earlier
+// attempts are recognized by the pid file they leave in the bundle, which
+// spares a runsc state in the common case.
+func (p *Init) removeLeftover(ctx context.Context) error {
+	pidFile := filepath.Join(p.Bundle, InitPidFile)
+	if _, err := os.Stat(pidFile); err != nil {
+		return nil
+	}
+	c, err := p.runtime.State(ctx, p.id)
+	if err != nil {
+		// Most likely deleted, otherwise create reports the failure.
+		return nil
+	}
+	if filepath.Clean(c.Bundle) != filepath.Clean(p.Bundle) {
+		return errors.Wrapf(errdefs.ErrAlreadyExists, "container %q already exists with bundle %s", p.id, c.Bundle)
+	}
+	switch c.Status {
+	case "created", "stopped":
+	default:
+		return errors.Wrapf(errdefs.ErrAlreadyExists, "container %q already exists and is %s", p.id, c.Status)
+	}
+	log.G(ctx).Warnf("Deleting %s container %q left by an earlier create", c.Status, p.id)
+	if err := p.runtime.Delete(ctx, p.id, &runsc.DeleteOpts{Force: true}); err != nil && !runsc.IsNotFound(err) {
+		return p.runtimeError(err, "OCI runtime delete of leftover container failed")
+	}
+	if err := os.Remove(pidFile); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to remove pid file of leftover container")
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/pkg/errors"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/stats"
+)
+
+// SentryMetrics returns the metrics exported by the sentry of the sandbox of
+// the container, with runsc export-metrics. They are in the Prometheus text
+// exposition format, each sample labelled with the container id. Releases
+// of runsc without export-metrics fail with ErrNotImplemented.
+func (p *Init) SentryMetrics(ctx context.Context) ([]byte, error) {
+	commands, err := p.runtime.Commands(ctx)
+	if err != nil {
+		return nil, p.runtimeError(err, "OCI runtime help failed")
+	}
+	if !commands["export-metrics"] {
+		version, _ := p.runtime.Version(ctx)
+		return nil, errors.Wrapf(errdefs.ErrNotImplemented, "runsc %s can't export the sentry metrics", version)
+	}
+	data, err := p.runtime.ExportMetrics(ctx, p.id)
+	if err != nil {
+		return nil, p.runtimeError(err, "OCI runtime export-metrics failed")
+	}
+	return stats.LabelMetrics(data, "container_id", p.id), nil
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"github.com/containerd/containerd/errdefs"
+	runc "github.com/containerd/go-runc"
+	"github.com/pkg/errors"
+)
+
+// Monitor starts the runsc and hook commands of the shim, waits for them
+// and reports the exits of the children of the shim.
+type Monitor interface {
+	runc.ProcessMonitor
+	ProcessMonitor
+}
+
+// Monitor kinds.
+const (
+	// MonitorAuto selects the best monitor the kernel supports.
+	MonitorAuto = "auto"
+	// MonitorReaper relies on the SIGCHLD reaper of the shim.
+	MonitorReaper = "reaper"
+	// MonitorPidfd waits for the commands of the shim through pidfds.
+	MonitorPidfd = "pidfd"
+)
+
+// monitorKind is a monitor implementation.
+type monitorKind struct {
+	name string
+	// supported probes whether the kernel and reaper support the monitor.
+	supported func(reaper Monitor) bool
+	// new returns the monitor. reaper is the SIGCHLD reaper of the shim.
+	new func(reaper Monitor) Monitor
+}
+
+// monitorKinds are the monitor implementations, by order of preference for
+// MonitorAuto. The reaper is always supported and comes last.
+var monitorKinds = []monitorKind{
+	{
+		name: MonitorPidfd,
+		// Only the reaper of the shim skips the commands waited for
+		// through pidfds. The containerd reaper of the v2 shim would race
+		// to collect their exits.
+		supported: func(reaper Monitor) bool {
+			_, ok := reaper.(*Reaper)
+			return ok && pidfdSupported()
+		},
+		new: func(reaper Monitor) Monitor { return newPidfdMonitor(reaper.(*Reaper)) },
+	},
+	{
+		name:      MonitorReaper,
+		supported: func(Monitor) bool { return true },
+		new:       func(reaper Monitor) Monitor { return reaper },
+	},
+}
+
+// SelectMonitor returns the monitor of the kind, given the SIGCHLD reaper
+// of the shim. An empty kind is MonitorAuto.
+func SelectMonitor(kind string, reaper Monitor) (Monitor, error) {
+	if kind == "" {
+		kind = MonitorAuto
+	}
+	for _, k := range monitorKinds {
+		if kind != MonitorAuto && kind != k.name {
+			continue
+		}
+		if !k.supported(reaper) {
+			if kind == MonitorAuto {
+				continue
+			}
+			return nil, errors.Wrapf(errdefs.ErrFailedPrecondition, "monitor %q is not supported by the kernel or shim", kind)
+		}
+		return k.new(reaper), nil
+	}
+	return nil, errors.Wrapf(errdefs.ErrInvalidArgument, "unknown monitor %q", kind)
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+	"unsafe"
+
+	runc "github.com/containerd/go-runc"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// sysPidfdOpen is the pidfd_open syscall number, the same on all
+	// architectures.
+	sysPidfdOpen = 434
+	// pPidfd is the P_PIDFD id type of waitid.
+	pPidfd = 3
+)
+
+// siginfo child status codes.
+const (
+	cldExited = 1
+	cldKilled = 2
+	cldDumped = 3
+)
+
+func pidfdOpen(pid int) (int, error) {
+	fd, _, errno := unix.Syscall(sysPidfdOpen, uintptr(pid), 0, 0)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+// waitid waits for a child of the id type and returns its pid and exit
+// status, 128+signal for processes killed by a signal. The pid is 0 when
+// WNOHANG is set and no child exited.
+func waitid(idtype, id, options int) (int, int, error) {
+	var info [128]byte
+	for {
+		_, _, errno := unix.Syscall6(unix.SYS_WAITID, uintptr(idtype), uintptr(id), uintptr(unsafe.Pointer(&info[0])), uintptr(options), 0, 0)
+		if errno == unix.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return 0, -1, errno
+		}
+		break
+	}
+	// The si_pid and si_status fields follow si_signo, si_errno and
+	// si_code, aligned to a pointer.
+	union := 3 * 4
+	if unsafe.Sizeof(uintptr(0)) == 8 {
+		union = 4 * 4
+	}
+	code := *(*int32)(unsafe.Pointer(&info[2*4]))
+	pid := int(*(*int32)(unsafe.Pointer(&info[union])))
+	status := int(*(*int32)(unsafe.Pointer(&info[union+2*4])))
+	if pid == 0 {
+		return 0, 0, nil
+	}
+	switch code {
+	case cldExited:
+		return pid, status, nil
+	case cldKilled, cldDumped:
+		return pid, SignalExitStatus(syscall.Signal(status)), nil
+	}
+	return pid, -1, errors.Errorf("unexpected child status code %d", code)
+}
+
+// waitPidfd reaps the exited process of the pidfd and returns its exit
+// status.
+func waitPidfd(fd int, options int) (int, error) {
+	_, status, err := waitid(pPidfd, fd, options)
+	return status, err
+}
+
+// pidfdSupported probes for pidfd_open and waitid on pidfds, available
+// since Linux 5.4.
+func pidfdSupported() bool {
+	fd, err := pidfdOpen(os.Getpid())
+	if err != nil {
+		return false
+	}
+	defer unix.Close(fd)
+	// The shim isn't its own child: ECHILD when waiting on pidfds is
+	// supported, EINVAL otherwise.
+	_, err = waitPidfd(fd, unix.WEXITED|unix.WNOHANG)
+	return err == unix.ECHILD
+}
+
+// pidfdMonitor waits for the commands it starts through pidfds. Their exits
+// are collected as soon as they happen, and a pid reused after the reaper
+// collected an exit can't be mistaken for the command. The reaper skips the
+// commands tracked this way, and the exits of both are published to the
+// subscribers of the reaper.
+type pidfdMonitor struct {
+	reaper *Reaper
+}
+
+func newPidfdMonitor(reaper *Reaper) Monitor {
+	return &pidfdMonitor{reaper: reaper}
+}
+
+// Start starts the command and watches its pidfd. Commands whose pidfd
+// can't be opened are left to the reaper.
+func (m *pidfdMonitor) Start(c *exec.Cmd) (chan runc.Exit, error) {
+	ec := m.Subscribe()
+	fd, err := m.reaper.startTracked(c)
+	if err != nil {
+		m.Unsubscribe(ec)
+		return nil, err
+	}
+	if fd >= 0 {
+		go m.wait(c.Process.Pid, fd)
+	}
+	return ec, nil
+}
+
+func (m *pidfdMonitor) wait(pid, fd int) {
+	defer unix.Close(fd)
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	for {
+		if _, err := unix.Poll(fds, -1); err != unix.EINTR {
+			break
+		}
+	}
+	status, err := waitPidfd(fd, unix.WEXITED)
+	m.reaper.untrack(pid)
+	if err == nil {
+		m.reaper.publish(runc.Exit{
+			Timestamp: time.Now(),
+			Pid:       pid,
+			Status:    status,
+		})
+	}
+	// Children that exited after the command were skipped by the reaper.
+	m.reaper.Reap()
+}
+
+// Wait blocks until the command exits and returns its exit status.
+func (m *pidfdMonitor) Wait(c *exec.Cmd, ec chan runc.Exit) (int, error) {
+	return m.reaper.Wait(c, ec)
+}
+
+// Subscribe to process exit changes.
+func (m *pidfdMonitor) Subscribe() chan runc.Exit {
+	return m.reaper.Subscribe()
+}
+
+// Unsubscribe to process exit changes.
+func (m *pidfdMonitor) Unsubscribe(c chan runc.Exit) {
+	m.reaper.Unsubscribe(c)
+}
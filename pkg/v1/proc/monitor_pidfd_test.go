@@ -0,0 +1,197 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	runc "github.com/containerd/go-runc"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// zombie reports whether the child exited without being reaped.
+func zombie(pid int) bool {
+	stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	// The state follows the command name, which is in parentheses.
+	s := string(stat)
+	return strings.HasPrefix(s[strings.LastIndex(s, ")")+1:], " Z")
+}
+
+func waitZombie(t *testing.T, pid int) {
+	for i := 0; !zombie(pid); i++ {
+		if i == 500 {
+			t.Fatalf("process %d didn't exit", pid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// drain returns the exits published to ec so far.
+func drain(ec chan runc.Exit) map[int]int {
+	exits := make(map[int]int)
+	for {
+		select {
+		case e := <-ec:
+			exits[e.Pid] = e.Status
+		default:
+			return exits
+		}
+	}
+}
+
+func TestReaperSkipsTracked(t *testing.T) {
+	if !pidfdSupported() {
+		t.Skip("pidfds aren't supported by the kernel")
+	}
+	r := NewReaper()
+	ec := r.Subscribe()
+	defer r.Unsubscribe(ec)
+
+	tracked := exec.Command("sh", "-c", "exit 3")
+	fd, err := r.startTracked(tracked)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fd < 0 {
+		t.Fatal("command wasn't tracked")
+	}
+	defer unix.Close(fd)
+	untracked := exec.Command("sh", "-c", "exit 4")
+	if err := untracked.Start(); err != nil {
+		t.Fatal(err)
+	}
+	waitZombie(t, tracked.Process.Pid)
+	waitZombie(t, untracked.Process.Pid)
+
+	if err := r.Reap(); err != nil {
+		t.Fatal(err)
+	}
+	if !zombie(tracked.Process.Pid) {
+		t.Fatal("reaper collected the exit of a tracked command")
+	}
+	exits := drain(ec)
+	if _, ok := exits[tracked.Process.Pid]; ok {
+		t.Fatal("reaper published the exit of a tracked command")
+	}
+
+	status, err := waitPidfd(fd, unix.WEXITED)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != 3 {
+		t.Errorf("tracked command exited with %d, expected 3", status)
+	}
+	r.untrack(tracked.Process.Pid)
+	if err := r.Reap(); err != nil {
+		t.Fatal(err)
+	}
+	for pid, status := range drain(ec) {
+		exits[pid] = status
+	}
+	if status, ok := exits[untracked.Process.Pid]; !ok || status != 4 {
+		t.Errorf("untracked command exit: %d, %t, expected 4", status, ok)
+	}
+}
+
+func TestPidfdMonitorRace(t *testing.T) {
+	if !pidfdSupported() {
+		t.Skip("pidfds aren't supported by the kernel")
+	}
+	r := NewReaper()
+	m := newPidfdMonitor(r)
+	all := r.Subscribe()
+	defer r.Unsubscribe(all)
+
+	// Reap continuously, as if SIGCHLD kept arriving.
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				r.Reap()
+			}
+		}
+	}()
+
+	pids := make(map[int]int)
+	for i := 0; i < 50; i++ {
+		c := exec.Command("sh", "-c", fmt.Sprintf("exit %d", i))
+		ec, err := m.Start(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		status, err := m.Wait(c, ec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status != i {
+			t.Errorf("command exited with %d, expected %d", status, i)
+		}
+		pids[c.Process.Pid]++
+	}
+	close(stop)
+	wg.Wait()
+
+	// Each exit is published once, by the monitor or the reaper.
+	published := make(map[int]int)
+	for len(all) > 0 {
+		published[(<-all).Pid]++
+	}
+	for pid := range pids {
+		if published[pid] != 1 {
+			t.Errorf("exit of %d published %d times", pid, published[pid])
+		}
+	}
+}
+
+// otherReaper stands for the containerd reaper of the v2 shim.
+type otherReaper struct {
+	*Reaper
+}
+
+func TestSelectMonitor(t *testing.T) {
+	reaper := otherReaper{NewReaper()}
+	if _, err := SelectMonitor(MonitorPidfd, reaper); !errdefs.IsFailedPrecondition(errors.Cause(err)) {
+		t.Errorf("pidfd monitor with another reaper: %v, expected a failed precondition", err)
+	}
+	m, err := SelectMonitor("", reaper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m != reaper {
+		t.Errorf("auto monitor with another reaper is %T, expected the reaper", m)
+	}
+	if _, err := SelectMonitor("other", reaper); !errdefs.IsInvalidArgument(errors.Cause(err)) {
+		t.Errorf("unknown monitor: %v, expected an invalid argument", err)
+	}
+}
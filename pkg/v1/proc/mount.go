@@ -0,0 +1,243 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/google/gvisor-containerd-shim/pkg/failpoint"
+)
+
+const (
+	// defaultMountWorkers bounds the rootfs mounts done concurrently.
+	defaultMountWorkers = 4
+	// defaultUnmountRetries and defaultUnmountBackoff match the retries of
+	// containerd's mount package.
+	defaultUnmountRetries = 50
+	defaultUnmountBackoff = 50 * time.Millisecond
+	// defaultWatchdogInterval is how often leaked mounts are retried.
+	defaultWatchdogInterval = time.Minute
+)
+
+// MountConfig configures how the rootfs mounts are set up and torn down.
+type MountConfig struct {
+	// Workers bounds the mounts done concurrently. It defaults to 4.
+	Workers int
+	// UnmountRetries is the number of times a busy unmount is retried,
+	// e.g. while the gofer still holds files open. It defaults to 50.
+	UnmountRetries int
+	// UnmountBackoff is the delay between unmount retries. It defaults to
+	// 50ms.
+	UnmountBackoff time.Duration
+	// Mounter makes the mounts. It defaults to SystemMounter.
+	Mounter Mounter
+	// LogMounts logs the rootfs mounts and unmounts.
+	LogMounts bool
+	// WatchdogInterval is how often the MountWatchdog retries the mounts
+	// leaked under the bundles of deleted containers. It defaults to 1m.
+	WatchdogInterval time.Duration
+}
+
+func (c MountConfig) withDefaults() MountConfig {
+	if c.Workers <= 0 {
+		c.Workers = defaultMountWorkers
+	}
+	if c.UnmountRetries <= 0 {
+		c.UnmountRetries = defaultUnmountRetries
+	}
+	if c.UnmountBackoff <= 0 {
+		c.UnmountBackoff = defaultUnmountBackoff
+	}
+	if c.WatchdogInterval <= 0 {
+		c.WatchdogInterval = defaultWatchdogInterval
+	}
+	if c.Mounter == nil {
+		c.Mounter = SystemMounter
+	}
+	if _, ok := c.Mounter.(*DiagnosticMounter); c.LogMounts && !ok {
+		c.Mounter = &DiagnosticMounter{Mounter: c.Mounter}
+	}
+	return c
+}
+
+// FailedMount is a rootfs mount that failed.
+type FailedMount struct {
+	Mount Mount
+	Err   error
+}
+
+// MountError lists the rootfs mounts that failed.
+type MountError struct {
+	Failed []FailedMount
+}
+
+func (e *MountError) Error() string {
+	msgs := make([]string, 0, len(e.Failed))
+	for _, f := range e.Failed {
+		target := f.Mount.Target
+		if target == "" {
+			target = "/"
+		}
+		msgs = append(msgs, fmt.Sprintf("%s %s on %s: %v", f.Mount.Type, f.Mount.Source, target, f.Err))
+	}
+	return "failed to mount rootfs: " + strings.Join(msgs, "; ")
+}
+
+// MountRootfs mounts the rootfs components under rootfs. Mounts without a
+// target are stacked on rootfs itself in order. Mounts with a target are
+// then mounted under rootfs, concurrently for mounts at the same depth.
+// On error, mounts already done are left for UnmountRootfs.
+func MountRootfs(rootfs string, mounts []Mount, c MountConfig) error {
+	c = c.withDefaults()
+	levels := make(map[int][]Mount)
+	var depths []int
+	for _, m := range mounts {
+		if m.Target == "" || filepath.Clean(m.Target) == "/" {
+			if err := mountAt(c.Mounter, m, rootfs); err != nil {
+				return &MountError{Failed: []FailedMount{{Mount: m, Err: err}}}
+			}
+			continue
+		}
+		d := strings.Count(filepath.Clean("/"+m.Target), "/")
+		if _, ok := levels[d]; !ok {
+			depths = append(depths, d)
+		}
+		levels[d] = append(levels[d], m)
+	}
+	sort.Ints(depths)
+	for _, d := range depths {
+		if err := mountConcurrently(c.Mounter, rootfs, levels[d], c.Workers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func mountConcurrently(mounter Mounter, rootfs string, mounts []Mount, workers int) error {
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		sem    = make(chan struct{}, workers)
+		errs   = make([]error, len(mounts))
+		failed bool
+	)
+	for i, m := range mounts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, m Mount) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := mountAt(mounter, m, filepath.Join(rootfs, m.Target)); err != nil {
+				mu.Lock()
+				errs[i] = err
+				failed = true
+				mu.Unlock()
+			}
+		}(i, m)
+	}
+	wg.Wait()
+	if !failed {
+		return nil
+	}
+	e := &MountError{}
+	for i, err := range errs {
+		if err != nil {
+			e.Failed = append(e.Failed, FailedMount{Mount: mounts[i], Err: err})
+		}
+	}
+	return e
+}
+
+func mountAt(mounter Mounter, m Mount, target string) error {
+	if err := failpoint.Inject(failpoint.MountRootfs); err != nil {
+		return err
+	}
+	return mounter.Mount(m, target)
+}
+
+// UnmountRootfs unmounts everything mounted under rootfs, deepest first, and
+// then the stack of mounts on rootfs itself. Busy mounts are retried as
+// configured.
+func UnmountRootfs(rootfs string, c MountConfig) error {
+	c = c.withDefaults()
+	points, err := c.Mounter.Mountpoints()
+	if err != nil {
+		return err
+	}
+	var nested []string
+	prefix := filepath.Clean(rootfs) + "/"
+	for _, p := range points {
+		if strings.HasPrefix(p, prefix) {
+			nested = append(nested, p)
+		}
+	}
+	sort.Slice(nested, func(i, j int) bool {
+		return len(nested[i]) > len(nested[j])
+	})
+	for _, target := range append(nested, rootfs) {
+		if err := unmountAll(target, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DetachRootfs lazily unmounts the stack of mounts on rootfs with
+// MNT_DETACH, along with everything mounted under it. Unlike UnmountRootfs,
+// it doesn't wait for busy mounts, e.g. held by a gofer that won't exit, so
+// that the container can be deleted regardless.
+func DetachRootfs(rootfs string, c MountConfig) error {
+	c = c.withDefaults()
+	for {
+		err := c.Mounter.Detach(rootfs)
+		switch {
+		case err == nil:
+		case err == unix.EINVAL || err == unix.ENOENT:
+			return nil
+		default:
+			return errors.Wrapf(err, "failed to detach %s", rootfs)
+		}
+	}
+}
+
+// unmountAll unmounts target until it is no longer a mount point.
+func unmountAll(target string, c MountConfig) error {
+	for retries := 0; ; {
+		err := c.Mounter.Unmount(target)
+		switch {
+		case err == nil:
+			retries = 0
+		case err == unix.EINVAL || err == unix.ENOENT:
+			return nil
+		case err == unix.EBUSY && retries < c.UnmountRetries:
+			retries++
+			time.Sleep(c.UnmountBackoff)
+		default:
+			return errors.Wrapf(err, "failed to unmount %s", target)
+		}
+	}
+}
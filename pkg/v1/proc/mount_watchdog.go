@@ -0,0 +1,216 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	"github.com/sirupsen/logrus"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// maxLeakBackoff caps the delay between the retries of a leaked mount.
+const maxLeakBackoff = 30 * time.Minute
+
+// MountWatchdog checks that nothing is left mounted under the bundle of a
+// deleted container, and keeps retrying the unmounts of the mounts leaked
+// there, with backoff, so that stale overlay mounts don't accumulate on the
+// node.
+type MountWatchdog struct {
+	config MountConfig
+
+	mu    sync.Mutex
+	leaks map[string]*mountLeak
+	stats runsctypes.LeakedMounts
+}
+
+// mountLeak is a bundle with leaked mounts.
+type mountLeak struct {
+	points  []string
+	backoff time.Duration
+	next    time.Time
+}
+
+// NewMountWatchdog returns a watchdog unmounting the leaked mounts as
+// configured by c.
+func NewMountWatchdog(c MountConfig) *MountWatchdog {
+	return &MountWatchdog{
+		config: c.withDefaults(),
+		leaks:  make(map[string]*mountLeak),
+	}
+}
+
+// Verify checks that nothing is mounted under bundle once its container was
+// deleted. The mounts left are unmounted right away, and retried by Run if
+// that fails. Busy unmounts are retried as configured, so it is run in the
+// background and ctx is only used for logging.
+func (w *MountWatchdog) Verify(ctx context.Context, bundle string) {
+	points, err := w.mountsUnder(bundle)
+	if err != nil {
+		log.G(ctx).WithError(err).Warn("failed to check for leaked mounts")
+		return
+	}
+	if len(points) == 0 {
+		return
+	}
+	log.G(ctx).WithFields(logrus.Fields{
+		"bundle": bundle,
+		"mounts": points,
+	}).Warn("mounts leaked by deleted container")
+	w.mu.Lock()
+	w.stats.Detected += uint64(len(points))
+	w.leaks[bundle] = &mountLeak{points: points}
+	w.mu.Unlock()
+	w.retry(ctx, bundle)
+}
+
+// Run retries the unmounts of the leaked mounts until ctx is done.
+func (w *MountWatchdog) Run(ctx context.Context) {
+	t := time.NewTicker(w.config.WatchdogInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		w.mu.Lock()
+		var due []string
+		now := time.Now()
+		for bundle, l := range w.leaks {
+			if !now.Before(l.next) {
+				due = append(due, bundle)
+			}
+		}
+		w.mu.Unlock()
+		for _, bundle := range due {
+			w.retry(ctx, bundle)
+		}
+	}
+}
+
+// retry unmounts the mounts leaked under bundle, and backs off further
+// retries on failure.
+func (w *MountWatchdog) retry(ctx context.Context, bundle string) {
+	points, err := w.mountsUnder(bundle)
+	if err == nil {
+		for _, target := range points {
+			if err = unmountAll(target, w.config); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		points, err = w.mountsUnder(bundle)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	l, ok := w.leaks[bundle]
+	if !ok {
+		return
+	}
+	w.stats.Attempts++
+	if err == nil && len(points) == 0 {
+		w.stats.Reclaimed += uint64(len(l.points))
+		delete(w.leaks, bundle)
+		log.G(ctx).WithField("bundle", bundle).Info("unmounted leaked mounts")
+		return
+	}
+	if len(points) < len(l.points) && err == nil {
+		w.stats.Reclaimed += uint64(len(l.points) - len(points))
+		l.points = points
+	}
+	switch {
+	case l.backoff == 0:
+		l.backoff = w.config.WatchdogInterval
+	case l.backoff < maxLeakBackoff:
+		l.backoff *= 2
+		if l.backoff > maxLeakBackoff {
+			l.backoff = maxLeakBackoff
+		}
+	}
+	l.next = time.Now().Add(l.backoff)
+	log.G(ctx).WithError(err).WithFields(logrus.Fields{
+		"bundle": bundle,
+		"mounts": l.points,
+		"retry":  l.backoff,
+	}).Warn("failed to unmount leaked mounts")
+}
+
+// mountsUnder returns the mount points under dir, deepest first.
+func (w *MountWatchdog) mountsUnder(dir string) ([]string, error) {
+	all, err := w.config.Mounter.Mountpoints()
+	if err != nil {
+		return nil, err
+	}
+	var points []string
+	prefix := filepath.Clean(dir) + "/"
+	for _, p := range all {
+		if strings.HasPrefix(p, prefix) {
+			points = append(points, p)
+		}
+	}
+	sort.SliceStable(points, func(i, j int) bool {
+		return len(points[i]) > len(points[j])
+	})
+	return points, nil
+}
+
+// Stats returns the mounts still leaked and counts of the leaks. A nil
+// watchdog reports none.
+func (w *MountWatchdog) Stats() runsctypes.LeakedMounts {
+	if w == nil {
+		return runsctypes.LeakedMounts{}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	s := w.stats
+	s.Mounts = nil
+	for _, l := range w.leaks {
+		s.Mounts = append(s.Mounts, l.points...)
+	}
+	sort.Strings(s.Mounts)
+	return s
+}
+
+// Metrics returns the leaked mount metrics in the Prometheus text exposition
+// format.
+func (w *MountWatchdog) Metrics() []byte {
+	s := w.Stats()
+	var b bytes.Buffer
+	for _, m := range []struct {
+		name, typ, help string
+		value           uint64
+	}{
+		{"gvisor_shim_leaked_mounts", "gauge", "Mounts left under the bundles of deleted containers.", uint64(len(s.Mounts))},
+		{"gvisor_shim_leaked_mounts_detected_total", "counter", "Leaked mounts found when containers were deleted.", s.Detected},
+		{"gvisor_shim_leaked_mounts_reclaimed_total", "counter", "Leaked mounts later unmounted.", s.Reclaimed},
+	} {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", m.name, m.help, m.name, m.typ, m.name, m.value)
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMountWatchdogVerify(t *testing.T) {
+	f := newFakeMounter()
+	f.Mount(Mount{Type: "overlay"}, "/bundle/rootfs")
+	f.Mount(Mount{Type: "bind"}, "/bundle/rootfs/data")
+	f.Mount(Mount{Type: "bind"}, "/other/rootfs")
+	f.busy["/bundle/rootfs"] = 10
+	w := NewMountWatchdog(MountConfig{Mounter: f, UnmountRetries: 2, UnmountBackoff: 1})
+
+	w.Verify(context.Background(), "/bundle")
+	s := w.Stats()
+	if s.Detected != 2 || s.Reclaimed != 0 {
+		t.Errorf("detected %d and reclaimed %d mounts, expected 2 and 0", s.Detected, s.Reclaimed)
+	}
+	if len(s.Mounts) != 2 {
+		t.Errorf("got leaked mounts %v, expected the mounts of the bundle", s.Mounts)
+	}
+
+	// The leak is reclaimed once the mount is no longer busy.
+	f.busy["/bundle/rootfs"] = 0
+	w.retry(context.Background(), "/bundle")
+	s = w.Stats()
+	if len(s.Mounts) != 0 || s.Reclaimed != 2 {
+		t.Errorf("got leaked mounts %v and %d reclaimed, expected none left", s.Mounts, s.Reclaimed)
+	}
+	if points, _ := f.Mountpoints(); len(points) != 1 || points[0] != "/other/rootfs" {
+		t.Errorf("got mounts %v, expected only the other bundle", points)
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/containerd/mount"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/sys/unix"
+)
+
+// Mounter makes the mounts of the container rootfs. SystemMounter makes
+// them with the mount syscalls, and tests replace it with a fake.
+type Mounter interface {
+	// Mount mounts m on target.
+	Mount(m Mount, target string) error
+	// Unmount unmounts the top mount of target. It returns EINVAL when
+	// target is not a mount point, and EBUSY when the mount is in use.
+	Unmount(target string) error
+	// Detach lazily unmounts the top mount of target and the mounts under
+	// it with MNT_DETACH: they are detached right away and cleaned up once
+	// no longer in use. It returns EINVAL when target is not a mount point.
+	Detach(target string) error
+	// Mountpoints returns the mount points of the mount namespace of the
+	// shim.
+	Mountpoints() ([]string, error)
+}
+
+// SystemMounter mounts with the mount syscalls, through the containerd mount
+// package.
+var SystemMounter Mounter = systemMounter{}
+
+type systemMounter struct{}
+
+func (systemMounter) Mount(m Mount, target string) error {
+	mm := &mount.Mount{
+		Type:    m.Type,
+		Source:  m.Source,
+		Options: m.Options,
+	}
+	return mm.Mount(target)
+}
+
+func (systemMounter) Unmount(target string) error {
+	return unix.Unmount(target, 0)
+}
+
+func (systemMounter) Detach(target string) error {
+	return unix.Unmount(target, unix.MNT_DETACH)
+}
+
+func (systemMounter) Mountpoints() ([]string, error) {
+	infos, err := mount.Self()
+	if err != nil {
+		return nil, err
+	}
+	points := make([]string, 0, len(infos))
+	for _, info := range infos {
+		points = append(points, info.Mountpoint)
+	}
+	return points, nil
+}
+
+// DiagnosticMounter logs the rootfs mounts and unmounts, with the options
+// handed to the containerd mount package, which translates them to flags
+// and data. In DryRun, the mounts are only logged and recorded: nothing is
+// mounted or unmounted.
+type DiagnosticMounter struct {
+	// Mounter makes the mounts, SystemMounter if nil.
+	Mounter Mounter
+	DryRun  bool
+
+	mu    sync.Mutex
+	calls []MountCall
+}
+
+// Mount logs the mount of m on target, then mounts it unless in DryRun.
+func (d *DiagnosticMounter) Mount(m Mount, target string) error {
+	c := MountCall{Mount: m, Target: target}
+	d.mu.Lock()
+	d.calls = append(d.calls, c)
+	d.mu.Unlock()
+	log.L.WithFields(logrus.Fields{
+		"target":  target,
+		"dry-run": d.DryRun,
+	}).Infof("rootfs mount: %s", c)
+	if d.DryRun {
+		return nil
+	}
+	return d.mounter().Mount(m, target)
+}
+
+// Unmount logs the unmount of target, then unmounts it unless in DryRun.
+func (d *DiagnosticMounter) Unmount(target string) error {
+	if d.DryRun {
+		// Nothing was mounted.
+		return unix.EINVAL
+	}
+	err := d.mounter().Unmount(target)
+	log.L.WithError(err).Debugf("rootfs unmount: umount2(%q, 0)", target)
+	return err
+}
+
+// Detach logs the lazy unmount of target, then detaches it unless in DryRun.
+func (d *DiagnosticMounter) Detach(target string) error {
+	if d.DryRun {
+		return unix.EINVAL
+	}
+	err := d.mounter().Detach(target)
+	log.L.WithError(err).Debugf("rootfs unmount: umount2(%q, MNT_DETACH)", target)
+	return err
+}
+
+// Mountpoints returns the mount points of Mounter, none in DryRun.
+func (d *DiagnosticMounter) Mountpoints() ([]string, error) {
+	if d.DryRun {
+		return nil, nil
+	}
+	return d.mounter().Mountpoints()
+}
+
+// Calls returns the mounts logged so far.
+func (d *DiagnosticMounter) Calls() []MountCall {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]MountCall(nil), d.calls...)
+}
+
+func (d *DiagnosticMounter) mounter() Mounter {
+	if d.Mounter == nil {
+		return SystemMounter
+	}
+	return d.Mounter
+}
+
+// MountCall is a rootfs component mounted on Target.
+type MountCall struct {
+	Mount  Mount
+	Target string
+}
+
+// String formats c as a mount command line.
+func (c MountCall) String() string {
+	s := "mount"
+	if c.Mount.Type != "" {
+		s += " -t " + c.Mount.Type
+	}
+	if len(c.Mount.Options) > 0 {
+		s += " -o " + strings.Join(c.Mount.Options, ",")
+	}
+	return fmt.Sprintf("%s %s %s", s, c.Mount.Source, c.Target)
+}
@@ -0,0 +1,222 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// fakeMounter keeps a table of stacked mounts instead of mounting.
+type fakeMounter struct {
+	mu     sync.Mutex
+	mounts map[string][]Mount
+	order  []string
+	// busy is the number of times the unmount of a target fails with
+	// EBUSY before it succeeds.
+	busy     map[string]int
+	unmounts int
+}
+
+func newFakeMounter() *fakeMounter {
+	return &fakeMounter{
+		mounts: make(map[string][]Mount),
+		busy:   make(map[string]int),
+	}
+}
+
+func (f *fakeMounter) Mount(m Mount, target string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.mounts[target] = append(f.mounts[target], m)
+	f.order = append(f.order, target)
+	return nil
+}
+
+func (f *fakeMounter) Unmount(target string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.unmounts++
+	stack := f.mounts[target]
+	if len(stack) == 0 {
+		return unix.EINVAL
+	}
+	if f.busy[target] > 0 {
+		f.busy[target]--
+		return unix.EBUSY
+	}
+	if len(stack) == 1 {
+		delete(f.mounts, target)
+	} else {
+		f.mounts[target] = stack[:len(stack)-1]
+	}
+	return nil
+}
+
+func (f *fakeMounter) Detach(target string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.mounts[target]) == 0 {
+		return unix.EINVAL
+	}
+	for t := range f.mounts {
+		if t == target || strings.HasPrefix(t, target+"/") {
+			delete(f.mounts, t)
+		}
+	}
+	return nil
+}
+
+func (f *fakeMounter) Mountpoints() ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var points []string
+	for t, stack := range f.mounts {
+		for range stack {
+			points = append(points, t)
+		}
+	}
+	sort.Strings(points)
+	return points, nil
+}
+
+func testMounts() []Mount {
+	return []Mount{
+		{Type: "overlay", Source: "overlay", Options: []string{"lowerdir=/l1:/l2", "upperdir=/u", "workdir=/w"}},
+		{Type: "bind", Source: "/data", Target: "/var/lib/data", Options: []string{"rbind", "ro"}},
+		{Type: "tmpfs", Source: "tmpfs", Target: "/tmp"},
+		{Type: "bind", Source: "/cache", Target: "/var/cache", Options: []string{"rbind"}},
+	}
+}
+
+func TestMountRootfs(t *testing.T) {
+	rootfs := "/bundle/rootfs"
+	f := newFakeMounter()
+	if err := MountRootfs(rootfs, testMounts(), MountConfig{Mounter: f}); err != nil {
+		t.Fatal(err)
+	}
+	if f.order[0] != rootfs {
+		t.Errorf("first mount on %s, expected the rootfs", f.order[0])
+	}
+	// Parents are mounted before the mounts under them.
+	tmp := indexOf(f.order, filepath.Join(rootfs, "tmp"))
+	for _, target := range []string{"var/lib/data", "var/cache"} {
+		if i := indexOf(f.order, filepath.Join(rootfs, target)); i < tmp {
+			t.Errorf("%s mounted at %d, before /tmp at %d", target, i, tmp)
+		}
+	}
+	if len(f.order) != 4 {
+		t.Errorf("made %d mounts, expected 4", len(f.order))
+	}
+}
+
+func TestUnmountRootfs(t *testing.T) {
+	rootfs := "/bundle/rootfs"
+	f := newFakeMounter()
+	if err := MountRootfs(rootfs, testMounts(), MountConfig{Mounter: f}); err != nil {
+		t.Fatal(err)
+	}
+	// Stack a second mount on the rootfs, and hold a nested mount busy.
+	f.Mount(Mount{Type: "bind", Source: "/other"}, rootfs)
+	f.busy[filepath.Join(rootfs, "var/lib/data")] = 2
+	c := MountConfig{Mounter: f, UnmountRetries: 3, UnmountBackoff: 1}
+	if err := UnmountRootfs(rootfs, c); err != nil {
+		t.Fatal(err)
+	}
+	if points, _ := f.Mountpoints(); len(points) != 0 {
+		t.Errorf("mounts left: %v", points)
+	}
+}
+
+func TestUnmountRootfsBusy(t *testing.T) {
+	rootfs := "/bundle/rootfs"
+	f := newFakeMounter()
+	if err := MountRootfs(rootfs, testMounts(), MountConfig{Mounter: f}); err != nil {
+		t.Fatal(err)
+	}
+	f.busy[filepath.Join(rootfs, "tmp")] = 10
+	c := MountConfig{Mounter: f, UnmountRetries: 3, UnmountBackoff: 1}
+	err := UnmountRootfs(rootfs, c)
+	if err == nil {
+		t.Fatal("unmounted a mount busy past the retries")
+	}
+	if !strings.Contains(err.Error(), "tmp") {
+		t.Errorf("error %q doesn't name the busy mount", err)
+	}
+	if err := DetachRootfs(rootfs, c); err != nil {
+		t.Fatal(err)
+	}
+	if points, _ := f.Mountpoints(); len(points) != 0 {
+		t.Errorf("mounts left after detach: %v", points)
+	}
+}
+
+func TestDiagnosticMounter(t *testing.T) {
+	rootfs := "/bundle/rootfs"
+	f := newFakeMounter()
+	d := &DiagnosticMounter{Mounter: f}
+	if err := MountRootfs(rootfs, testMounts()[:2], MountConfig{Mounter: d}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []MountCall{
+		{Mount: testMounts()[0], Target: rootfs},
+		{Mount: testMounts()[1], Target: filepath.Join(rootfs, "var/lib/data")},
+	}
+	if calls := d.Calls(); !reflect.DeepEqual(calls, expected) {
+		t.Errorf("got calls %v, expected %v", calls, expected)
+	}
+	if len(f.order) != 2 {
+		t.Errorf("made %d mounts, expected 2", len(f.order))
+	}
+	if s := expected[1].String(); s != "mount -t bind -o rbind,ro /data /bundle/rootfs/var/lib/data" {
+		t.Errorf("unexpected command line %q", s)
+	}
+}
+
+func TestDiagnosticMounterDryRun(t *testing.T) {
+	rootfs := "/bundle/rootfs"
+	f := newFakeMounter()
+	d := &DiagnosticMounter{Mounter: f, DryRun: true}
+	c := MountConfig{Mounter: d}
+	if err := MountRootfs(rootfs, testMounts(), c); err != nil {
+		t.Fatal(err)
+	}
+	if err := UnmountRootfs(rootfs, c); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Calls()) != 4 {
+		t.Errorf("logged %d mounts, expected 4", len(d.Calls()))
+	}
+	if len(f.order) != 0 || f.unmounts != 0 {
+		t.Errorf("dry run made %d mounts and %d unmounts", len(f.order), f.unmounts)
+	}
+}
+
+func indexOf(s []string, v string) int {
+	for i, e := range s {
+		if e == v {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"os"
+
+	"github.com/containerd/containerd/errdefs"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// nsGetNSType is the NS_GET_NSTYPE ioctl, which returns the CLONE_NEW* flag
+// of a namespace fd. It requires Linux 4.11.
+const nsGetNSType = 0xb703
+
+// namespaceTypes are the clone flags of the namespace types of the spec.
+var namespaceTypes = map[specs.LinuxNamespaceType]int{
+	specs.PIDNamespace:     unix.CLONE_NEWPID,
+	specs.NetworkNamespace: unix.CLONE_NEWNET,
+	specs.MountNamespace:   unix.CLONE_NEWNS,
+	specs.IPCNamespace:     unix.CLONE_NEWIPC,
+	specs.UTSNamespace:     unix.CLONE_NEWUTS,
+	specs.UserNamespace:    unix.CLONE_NEWUSER,
+	specs.CgroupNamespace:  unix.CLONE_NEWCGROUP,
+}
+
+// openNamespaces opens the namespaces the spec joins by path, such as the
+// network namespace prepared by CNI, and checks that each is a namespace of
+// the type it is joined as. The caller closes the files.
+func openNamespaces(spec *specs.Spec) ([]*os.File, error) {
+	if spec.Linux == nil {
+		return nil, nil
+	}
+	var files []*os.File
+	for _, ns := range spec.Linux.Namespaces {
+		if ns.Path == "" {
+			continue
+		}
+		f, err := openNamespace(ns)
+		if err != nil {
+			closeFiles(files)
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+func openNamespace(ns specs.LinuxNamespace) (*os.File, error) {
+	want, ok := namespaceTypes[ns.Type]
+	if !ok {
+		return nil, errors.Wrapf(errdefs.ErrInvalidArgument, "unknown namespace type %q", ns.Type)
+	}
+	f, err := os.Open(ns.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.Wrapf(errdefs.ErrFailedPrecondition, "%s namespace %s doesn't exist", ns.Type, ns.Path)
+		}
+		return nil, errors.Wrapf(err, "failed to open %s namespace %s", ns.Type, ns.Path)
+	}
+	var fs unix.Statfs_t
+	if err := unix.Fstatfs(int(f.Fd()), &fs); err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "failed to stat %s namespace %s", ns.Type, ns.Path)
+	}
+	if fs.Type != unix.NSFS_MAGIC && fs.Type != unix.PROC_SUPER_MAGIC {
+		f.Close()
+		return nil, errors.Wrapf(errdefs.ErrFailedPrecondition, "%s namespace %s is not a namespace, it may have been torn down", ns.Type, ns.Path)
+	}
+	// The type is the return value of the ioctl.
+	r, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), nsGetNSType, 0)
+	if errno != 0 {
+		// Kernels older than 4.11 can't tell the type.
+		return f, nil
+	}
+	if got := int(r); got != want {
+		f.Close()
+		return nil, errors.Wrapf(errdefs.ErrFailedPrecondition, "%s namespace %s is a %s namespace", ns.Type, ns.Path, namespaceType(got))
+	}
+	return f, nil
+}
+
+func namespaceType(flag int) string {
+	for t, f := range namespaceTypes {
+		if f == flag {
+			return string(t)
+		}
+	}
+	return "unknown"
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
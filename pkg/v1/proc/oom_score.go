@@ -0,0 +1,89 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/containerd/sys"
+	"github.com/pkg/errors"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/utils"
+)
+
+// OOM score adjustment bounds of the kernel.
+const (
+	minOOMScoreAdj = -1000
+	maxOOMScoreAdj = 1000
+)
+
+// oomScoreAdj returns the OOM score adjustment of the host processes of the
+// container: the oomScoreAdj of the spec in bundle plus offset, clamped to
+// the kernel bounds. ok is false if neither sets one.
+func oomScoreAdj(bundle string, offset int) (score int, ok bool, err error) {
+	spec, err := utils.ReadSpec(bundle)
+	if err != nil {
+		return 0, false, errors.Wrap(err, "read oci spec")
+	}
+	if spec.Process != nil && spec.Process.OOMScoreAdj != nil {
+		score, ok = *spec.Process.OOMScoreAdj, true
+	}
+	if offset != 0 {
+		score, ok = score+offset, true
+	}
+	if score < minOOMScoreAdj {
+		score = minOOMScoreAdj
+	}
+	if score > maxOOMScoreAdj {
+		score = maxOOMScoreAdj
+	}
+	return score, ok, nil
+}
+
+// applyOOMScore sets the OOM score adjustment of the created container on
+// its host processes, so that the OOM killer of the node picks sandboxes the
+// way it would pick their containers run natively: the sandbox process for
+// the sandbox container, and the gofers of each container. The processes
+// inside the sandbox only count towards the sandbox. Failures are logged,
+// the container runs with the score it inherited from the shim.
+func (p *Init) applyOOMScore(ctx context.Context) {
+	if p.unsandboxed {
+		// runc applies the score of the spec itself.
+		return
+	}
+	score, ok, err := oomScoreAdj(p.Bundle, p.OOMScoreOffset)
+	if err != nil {
+		log.G(ctx).WithError(err).Warnf("Failed to read the OOM score adjustment of container %q", p.id)
+		return
+	}
+	if !ok {
+		return
+	}
+	pids := goferPids(p.Bundle)
+	if p.Sandbox {
+		pids = append([]int{p.pid}, pids...)
+	}
+	for _, pid := range pids {
+		if err := sys.SetOOMScore(pid, score); err != nil {
+			log.G(ctx).WithError(err).Warnf("Failed to set the OOM score adjustment of process %d of container %q to %d", pid, p.id, score)
+			return
+		}
+	}
+	p.oomScoreAdj = &score
+	log.G(ctx).Debugf("Set the OOM score adjustment of processes %v of container %q to %d", pids, p.id, score)
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/cri/pkg/annotations"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/utils"
+)
+
+// PauseContainerAnnotation overrides the detection of the pause container of
+// a pod, "true" or "false". Without it, the sandbox container of a CRI pod
+// whose command is a pause binary, e.g. /pause, is the pause container.
+const PauseContainerAnnotation = "dev.gvisor.pause-container"
+
+// readPauseContainer returns whether the spec in bundle is the pause
+// container of a pod.
+func readPauseContainer(bundle string) (bool, error) {
+	spec, err := utils.ReadSpec(bundle)
+	if err != nil {
+		return false, errors.Wrap(err, "read oci spec")
+	}
+	if v, ok := spec.Annotations[PauseContainerAnnotation]; ok {
+		pause, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, errors.Wrapf(errdefs.ErrInvalidArgument, "invalid %s annotation %q", PauseContainerAnnotation, v)
+		}
+		return pause, nil
+	}
+	return isPauseCommand(spec), nil
+}
+
+// isPauseCommand returns whether spec is the sandbox container of a CRI pod
+// running a pause binary.
+func isPauseCommand(spec *specs.Spec) bool {
+	if spec.Annotations[annotations.ContainerType] != annotations.ContainerTypeSandbox || spec.Process == nil {
+		return false
+	}
+	args := spec.Process.Args
+	return len(args) == 1 && filepath.Base(args[0]) == "pause"
+}
+
+// startPause starts the pause container without running its workload. The
+// sandbox, booted when the container was created, holds the namespaces of
+// the pod on its own, which saves the memory of the pause process and of
+// its image files in the sentry.
+func (p *Init) startPause(ctx context.Context) {
+	log.G(ctx).Debugf("Not starting the workload of pause container %q, its sandbox acts as the pause process", p.id)
+	p.pauseStarted = true
+}
+
+// killPause delivers signal the way the pause process handles it: SIGINT
+// and SIGTERM make it exit with 0, SIGKILL kills it, and other signals are
+// ignored. The pause process is the sandbox, which is killed.
+func (p *Init) killPause(signal uint32) error {
+	var status int32
+	switch syscall.Signal(signal) {
+	case unix.SIGINT, unix.SIGTERM:
+		status = 0
+	case unix.SIGKILL:
+		status = int32(SignalExitStatus(unix.SIGKILL))
+	default:
+		return nil
+	}
+	atomic.StoreInt32(&p.pauseStatus, status)
+	if err := unix.Kill(p.pid, unix.SIGKILL); err != nil {
+		if err == unix.ESRCH {
+			return errors.Wrapf(errdefs.ErrNotFound, "no such process")
+		}
+		return errors.Wrapf(err, "failed to kill sandbox of pause container %q", p.id)
+	}
+	return nil
+}
+
+// waitPause waits for the sandbox of the pause container to exit and
+// returns the exit status of the pause process. runsc can't wait for a
+// container that was never started, the sandbox process is watched like
+// the gofers instead.
+func (p *Init) waitPause() int {
+	watchPid(context.Background(), p.pid, func(int) {})
+	return int(atomic.LoadInt32(&p.pauseStatus))
+}
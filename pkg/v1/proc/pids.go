@@ -0,0 +1,213 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/containerd/runtime/linux/runctypes"
+	rproc "github.com/containerd/containerd/runtime/proc"
+	runc "github.com/containerd/go-runc"
+	"github.com/containerd/typeurl"
+	"github.com/pkg/errors"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// PsEntry is a sandbox-internal process listed by runsc ps.
+type PsEntry struct {
+	Pid       uint32
+	ParentPid uint32
+	Command   string
+}
+
+// ParsePs returns the processes of a runsc ps table, without duplicates.
+func ParsePs(top *runc.TopResults) ([]PsEntry, error) {
+	pidCol, ppidCol, cmdCol := -1, -1, -1
+	for i, h := range top.Headers {
+		switch h {
+		case "PID":
+			pidCol = i
+		case "PPID":
+			ppidCol = i
+		case "CMD":
+			cmdCol = i
+		}
+	}
+	if pidCol < 0 {
+		return nil, errors.New("runsc ps output has no PID column")
+	}
+	seen := make(map[uint32]bool)
+	var entries []PsEntry
+	for _, row := range top.Processes {
+		pid, err := strconv.ParseUint(row[pidCol], 10, 32)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid pid %q in runsc ps output", row[pidCol])
+		}
+		if seen[uint32(pid)] {
+			continue
+		}
+		seen[uint32(pid)] = true
+		e := PsEntry{Pid: uint32(pid)}
+		if ppidCol >= 0 {
+			if ppid, err := strconv.ParseUint(row[ppidCol], 10, 32); err == nil {
+				e.ParentPid = uint32(ppid)
+			}
+		}
+		if cmdCol >= 0 {
+			e.Command = row[cmdCol]
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// ProcessDetails returns the details of the sandbox-internal processes
+// reported by runsc ps, merged with the running processes of the shim that
+// runsc didn't list, e.g. exec processes that just started. The details
+// include the process of the shim each process descends from, so that
+// children of exec processes are attributed to their exec.
+func ProcessDetails(ctx context.Context, entries []PsEntry, processes []rproc.Process) []*runsctypes.ProcessDetails {
+	listed := make(map[uint32]bool, len(entries))
+	for _, e := range entries {
+		listed[e.Pid] = true
+	}
+	for _, p := range processes {
+		ip, ok := p.(InternalPider)
+		if !ok || ip.InternalPid() <= 0 || listed[uint32(ip.InternalPid())] {
+			continue
+		}
+		if status, err := p.Status(ctx); err != nil || status != "running" {
+			continue
+		}
+		entries = append(entries, PsEntry{Pid: uint32(ip.InternalPid())})
+		listed[uint32(ip.InternalPid())] = true
+	}
+	parents := make(map[uint32]uint32, len(entries))
+	for _, e := range entries {
+		parents[e.Pid] = e.ParentPid
+	}
+	details := make([]*runsctypes.ProcessDetails, 0, len(entries))
+	for _, e := range entries {
+		d := &runsctypes.ProcessDetails{
+			InternalPid: e.Pid,
+			ParentPid:   e.ParentPid,
+			Command:     e.Command,
+		}
+		if p := lookupPid(processes, int(e.Pid)); p != nil {
+			d.ExecID = p.ID()
+			d.HostPid = uint32(p.Pid())
+		} else if p := ancestor(processes, parents, e.ParentPid); p != nil {
+			d.ExecID = p.ID()
+		}
+		details = append(details, d)
+	}
+	return details
+}
+
+// ProcessInfos returns the process infos of the task API for details. Like
+// the runc shims, the processes of the shim carry a runctypes.ProcessDetails
+// with their exec id, which clients of the task API decode; the runsc
+// details are served by the Pids RPC of the runsc service.
+func ProcessInfos(details []*runsctypes.ProcessDetails) ([]*task.ProcessInfo, error) {
+	infos := make([]*task.ProcessInfo, 0, len(details))
+	for _, d := range details {
+		info := &task.ProcessInfo{Pid: d.InternalPid}
+		if d.HostPid != 0 {
+			a, err := typeurl.MarshalAny(&runctypes.ProcessDetails{
+				ExecID: d.ExecID,
+			})
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to marshal process %d info", d.InternalPid)
+			}
+			info.Info = a
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// ancestor returns the closest process of the shim among pid and its
+// ancestors.
+func ancestor(processes []rproc.Process, parents map[uint32]uint32, pid uint32) rproc.Process {
+	visited := make(map[uint32]bool)
+	for pid != 0 && !visited[pid] {
+		if p := lookupPid(processes, int(pid)); p != nil {
+			return p
+		}
+		visited[pid] = true
+		pid = parents[pid]
+	}
+	return nil
+}
+
+// lookupPid returns the process whose internal pid is pid. Processes that
+// don't know their internal pid, such as those run with runc whose ps lists
+// host pids, are matched by host pid.
+func lookupPid(processes []rproc.Process, pid int) rproc.Process {
+	for _, p := range processes {
+		if ip, ok := p.(InternalPider); ok && ip.InternalPid() > 0 {
+			if ip.InternalPid() == pid {
+				return p
+			}
+			continue
+		}
+		if p.Pid() == pid {
+			return p
+		}
+	}
+	return nil
+}
+
+// descendants returns the sandbox-internal processes descending from pid,
+// deepest first.
+func (p *Init) descendants(ctx context.Context, pid int) ([]int, error) {
+	top, err := p.runtime.Top(ctx, p.id)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ParsePs(top)
+	if err != nil {
+		return nil, err
+	}
+	children := make(map[uint32][]uint32)
+	for _, e := range entries {
+		if e.Pid != e.ParentPid {
+			children[e.ParentPid] = append(children[e.ParentPid], e.Pid)
+		}
+	}
+	var out []int
+	queue := children[uint32(pid)]
+	seen := map[uint32]bool{uint32(pid): true}
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		if seen[c] {
+			continue
+		}
+		seen[c] = true
+		out = append(out, int(c))
+		queue = append(queue, children[c]...)
+	}
+	// Breadth first order reversed puts children before their parents.
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out, nil
+}
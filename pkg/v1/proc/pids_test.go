@@ -0,0 +1,63 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"testing"
+
+	"github.com/containerd/containerd/runtime/linux/runctypes"
+	"github.com/containerd/typeurl"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+func TestProcessInfos(t *testing.T) {
+	infos, err := ProcessInfos([]*runsctypes.ProcessDetails{
+		{ExecID: "exec", HostPid: 100, InternalPid: 5},
+		{ExecID: "exec", InternalPid: 6, ParentPid: 5},
+		{InternalPid: 7},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 3 {
+		t.Fatalf("got %d infos, expected 3", len(infos))
+	}
+	for i, pid := range []uint32{5, 6, 7} {
+		if infos[i].Pid != pid {
+			t.Errorf("info %d has pid %d, expected %d", i, infos[i].Pid, pid)
+		}
+	}
+	// Clients of the task API decode the info of the processes of the
+	// shim as the runc process details.
+	v, err := typeurl.UnmarshalAny(infos[0].Info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, ok := v.(*runctypes.ProcessDetails)
+	if !ok {
+		t.Fatalf("info is a %T, expected runctypes.ProcessDetails", v)
+	}
+	if d.ExecID != "exec" {
+		t.Errorf("info has exec id %q, expected %q", d.ExecID, "exec")
+	}
+	for _, info := range infos[1:] {
+		if info.Info != nil {
+			t.Errorf("process %d that isn't a process of the shim has an info", info.Pid)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"strconv"
+
+	"github.com/containerd/containerd/errdefs"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/utils"
+)
+
+// loopbacks are the addresses port forwards connect to in a network
+// namespace of the host, IPv4 first, so that dual-stack and IPv6 only
+// servers are reached.
+var loopbacks = []string{"127.0.0.1", "::1"}
+
+// PortForward opens a connection to port inside the container, for the
+// port forwards of the CRI. The host network namespace of a sandbox using
+// the network stack of gVisor, the default, has nothing listening, so the
+// connection is made by runsc port-forward into the sandbox. Containers
+// using the host network stack, or run with runc, are connected to in the
+// network namespace of the pod.
+func (p *Init) PortForward(ctx context.Context, port int) (io.ReadWriteCloser, error) {
+	if port <= 0 || port > 65535 {
+		return nil, errors.Wrapf(errdefs.ErrInvalidArgument, "invalid port %d", port)
+	}
+	p.mu.Lock()
+	exited, pid := !p.exited.IsZero(), p.pid
+	p.mu.Unlock()
+	if exited {
+		return nil, errors.Wrapf(errdefs.ErrFailedPrecondition, "container %s has exited", p.id)
+	}
+	network := p.runtime.Flags()["network"]
+	if p.unsandboxed {
+		network = "host"
+	}
+	switch network {
+	case "", "sandbox":
+		return p.forwardToSandbox(ctx, port)
+	case "host":
+		return p.forwardInNetns(pid, port)
+	}
+	return nil, errors.Wrapf(errdefs.ErrFailedPrecondition, "container %s has no network, with network %s", p.id, network)
+}
+
+// forwardToSandbox connects to port inside the sandbox with runsc
+// port-forward.
+func (p *Init) forwardToSandbox(ctx context.Context, port int) (io.ReadWriteCloser, error) {
+	commands, err := p.runtime.Commands(ctx)
+	if err != nil {
+		return nil, p.runtimeError(err, "OCI runtime help failed")
+	}
+	if !commands["port-forward"] {
+		version, _ := p.runtime.Version(ctx)
+		return nil, errors.Wrapf(errdefs.ErrNotImplemented, "runsc %s can't forward ports into the sandbox", version)
+	}
+	conn, err := p.runtime.PortForward(ctx, p.id, port)
+	if err != nil {
+		return nil, p.runtimeError(err, "OCI runtime port-forward failed")
+	}
+	return conn, nil
+}
+
+// forwardInNetns connects to port on the loopback addresses of the network
+// namespace of the container.
+func (p *Init) forwardInNetns(pid, port int) (io.ReadWriteCloser, error) {
+	path, err := netnsPath(p.Bundle, pid)
+	if err != nil {
+		return nil, err
+	}
+	var errs []string
+	for _, addr := range loopbacks {
+		conn, err := dialInNetns(path, net.JoinHostPort(addr, strconv.Itoa(port)))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return nil, errors.Wrapf(errdefs.ErrUnavailable, "failed to connect to port %d of container %s: %v", port, p.id, errs)
+}
+
+// netnsPath returns the network namespace the spec in bundle joins, or the
+// one of the process pid if the runtime created it.
+func netnsPath(bundle string, pid int) (string, error) {
+	spec, err := utils.ReadSpec(bundle)
+	if err != nil {
+		return "", errors.Wrap(err, "read oci spec")
+	}
+	if spec.Linux != nil {
+		for _, ns := range spec.Linux.Namespaces {
+			if ns.Type == specs.NetworkNamespace && ns.Path != "" {
+				return ns.Path, nil
+			}
+		}
+	}
+	return fmt.Sprintf("/proc/%d/ns/net", pid), nil
+}
+
+// dialInNetns dials addr over TCP from the network namespace at path. The
+// thread is switched to the namespace for the dial only; a thread that
+// can't switch back is left locked, so that it exits with the goroutine.
+func dialInNetns(path, addr string) (net.Conn, error) {
+	target, err := unix.Open(path, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open network namespace %s", path)
+	}
+	defer unix.Close(target)
+	runtime.LockOSThread()
+	origin, err := unix.Open("/proc/thread-self/ns/net", unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return nil, errors.Wrap(err, "failed to open network namespace of the shim")
+	}
+	defer unix.Close(origin)
+	if err := unix.Setns(target, unix.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		return nil, errors.Wrapf(err, "failed to enter network namespace %s", path)
+	}
+	conn, err := net.Dial("tcp", addr)
+	if err := unix.Setns(origin, unix.CLONE_NEWNET); err != nil {
+		if conn != nil {
+			conn.Close()
+		}
+		return nil, errors.Wrap(err, "failed to restore network namespace of the shim")
+	}
+	runtime.UnlockOSThread()
+	return conn, err
+}
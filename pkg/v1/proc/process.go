@@ -17,23 +17,5 @@ limitations under the License.
 
 package proc
 
-import (
-	"github.com/pkg/errors"
-)
-
 // RunscRoot is the path to the root runsc state directory
 const RunscRoot = "/run/containerd/runsc"
-
-func stateName(v interface{}) string {
-	switch v.(type) {
-	case *runningState, *execRunningState:
-		return "running"
-	case *createdState, *execCreatedState:
-		return "created"
-	case *deletedState:
-		return "deleted"
-	case *stoppedState:
-		return "stopped"
-	}
-	panic(errors.Errorf("invalid state %v", v))
-}
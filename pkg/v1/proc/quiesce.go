@@ -0,0 +1,143 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+
+	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// DefaultQuiesceTimeout is how long a container stays quiesced when no
+// timeout is given, so that a backup agent that dies doesn't leave the pod
+// frozen.
+const DefaultQuiesceTimeout = 5 * time.Minute
+
+// syncTimeout bounds the sync run in the container before it is paused.
+const syncTimeout = 30 * time.Second
+
+// Quiesce freezes the container for a crash-consistent snapshot of its
+// volumes. The sentry caches file data, so sync is first run in the
+// container to write it back to the host files; the container is then
+// paused until Unquiesce, or until timeout expires. The volumes can be
+// snapshotted on the host in between. A failed sync, e.g. in an image
+// without sync, is reported rather than fatal: the snapshot is then only
+// as consistent as a crash of the pod.
+func (p *Init) Quiesce(ctx context.Context, timeout time.Duration) (*runsctypes.Quiesced, error) {
+	if timeout <= 0 {
+		timeout = DefaultQuiesceTimeout
+	}
+	q := &runsctypes.Quiesced{ContainerID: p.id}
+	if err := p.sync(ctx); err != nil {
+		log.G(ctx).WithError(err).Warnf("Failed to sync container %q before quiescing it", p.id)
+		q.SyncError = err.Error()
+	} else {
+		q.Synced = true
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.quiesced {
+		return nil, errors.Wrapf(errdefs.ErrFailedPrecondition, "container %s is already quiesced", p.id)
+	}
+	if _, ok := p.initState.(*runningState); !ok {
+		return nil, errors.Wrapf(errdefs.ErrFailedPrecondition, "container %s must be running to be quiesced", p.id)
+	}
+	if err := p.initState.Pause(ctx); err != nil {
+		return nil, err
+	}
+	p.quiesced = true
+	q.QuiescedAt = time.Now()
+	q.ThawAt = q.QuiescedAt.Add(timeout)
+	var thaw *time.Timer
+	thaw = time.AfterFunc(timeout, func() {
+		p.thawExpired(log.WithLogger(context.Background(), log.G(ctx)), thaw, timeout)
+	})
+	p.thaw = thaw
+	return q, nil
+}
+
+// Unquiesce resumes the container paused by Quiesce. A container resumed
+// since, e.g. by Resume, is left running.
+func (p *Init) Unquiesce(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.quiesced {
+		return errors.Wrapf(errdefs.ErrFailedPrecondition, "container %s is not quiesced", p.id)
+	}
+	if _, ok := p.initState.(*pausedState); ok {
+		if err := p.initState.Resume(ctx); err != nil {
+			return err
+		}
+	}
+	p.endQuiesce()
+	return nil
+}
+
+// thawExpired resumes the container once the quiesce timed out. The timer
+// may fire while Unquiesce or Resume ends the quiesce, so it only resumes
+// the container if its quiesce is still the one in progress: a container
+// paused by the user since is left paused.
+func (p *Init) thawExpired(ctx context.Context, thaw *time.Timer, timeout time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.quiesced || p.thaw != thaw {
+		return
+	}
+	log.G(ctx).Warnf("Quiesce of container %q timed out after %v, resuming it", p.id, timeout)
+	if _, ok := p.initState.(*pausedState); ok {
+		if err := p.initState.Resume(ctx); err != nil {
+			log.G(ctx).WithError(err).Errorf("Failed to resume quiesced container %q", p.id)
+			return
+		}
+	}
+	p.endQuiesce()
+}
+
+// endQuiesce cancels the thaw of the quiesce in progress, if any. The
+// caller holds mu.
+func (p *Init) endQuiesce() {
+	if !p.quiesced {
+		return
+	}
+	p.thaw.Stop()
+	p.thaw = nil
+	p.quiesced = false
+}
+
+// sync runs sync in the container, writing the file data cached by the
+// sentry back to the host.
+func (p *Init) sync(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, syncTimeout)
+	defer cancel()
+	spec := specs.Process{
+		Args: []string{"sync"},
+		Env:  []string{helperPath},
+		Cwd:  "/",
+	}
+	if err := p.runtime.Exec(ctx, p.id, spec, &runsc.ExecOpts{}); err != nil {
+		return p.runtimeError(err, "OCI runtime exec of sync failed")
+	}
+	return nil
+}
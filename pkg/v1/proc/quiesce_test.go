@@ -0,0 +1,51 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/runtime/proc"
+)
+
+func TestThawExpiredStaleTimer(t *testing.T) {
+	p := New("test", nil, proc.Stdio{})
+	p.initState = &pausedState{p: p}
+	current := time.NewTimer(time.Hour)
+	defer current.Stop()
+	p.quiesced = true
+	p.thaw = current
+
+	// The timer of an earlier quiesce, ended by Resume, fires while the
+	// container is quiesced again: the container stays paused.
+	stale := time.NewTimer(time.Hour)
+	stale.Stop()
+	p.thawExpired(context.Background(), stale, time.Minute)
+	if _, ok := p.initState.(*pausedState); !ok || !p.quiesced || p.thaw != current {
+		t.Fatalf("stale thaw resumed the container, state %T, quiesced %v", p.initState, p.quiesced)
+	}
+
+	p.endQuiesce()
+	if p.quiesced || p.thaw != nil {
+		t.Errorf("quiesce not ended")
+	}
+	if current.Stop() {
+		t.Errorf("thaw of the ended quiesce still armed")
+	}
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"os/exec"
+	"sync"
+	"time"
+
+	runc "github.com/containerd/go-runc"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// pAll is the P_ALL id type of waitid.
+	pAll = 0
+	// exitBufferSize matches the subscription buffer of the containerd
+	// reaper.
+	exitBufferSize = 2048
+)
+
+// DefaultReaper is the SIGCHLD reaper of the v1 shim.
+var DefaultReaper = NewReaper()
+
+// Reaper reaps the children of the shim when it receives SIGCHLD and
+// reports their exits to subscribers, like the containerd reaper. Unlike
+// it, the reaper leaves alone the children a pidfd monitor waits for, so
+// that the two never race to collect the same exit.
+type Reaper struct {
+	mu          sync.Mutex
+	subscribers map[chan runc.Exit]struct{}
+
+	// reapMu serializes reaping with the start of tracked commands, so that
+	// a command can't exit and be reaped before it is tracked.
+	reapMu sync.Mutex
+	// tracked are the pids of the children waited for through pidfds.
+	tracked map[int]struct{}
+}
+
+// NewReaper returns a reaper without subscribers.
+func NewReaper() *Reaper {
+	return &Reaper{
+		subscribers: make(map[chan runc.Exit]struct{}),
+		tracked:     make(map[int]struct{}),
+	}
+}
+
+// Reap should be called when the shim receives SIGCHLD. It reaps the exited
+// children that aren't tracked and publishes their exits. Exited children
+// are found in the order they were started: reaping stops at the first
+// tracked one, and resumes once its pidfd monitor collected it.
+func (r *Reaper) Reap() error {
+	r.reapMu.Lock()
+	defer r.reapMu.Unlock()
+	now := time.Now()
+	var (
+		exits []runc.Exit
+		err   error
+	)
+	for {
+		// Peek at the next exited child without reaping it.
+		pid, _, werr := waitid(pAll, 0, unix.WEXITED|unix.WNOHANG|unix.WNOWAIT)
+		if werr != nil {
+			if werr != unix.ECHILD {
+				err = werr
+			}
+			break
+		}
+		if pid == 0 {
+			break
+		}
+		if _, ok := r.tracked[pid]; ok {
+			break
+		}
+		var ws unix.WaitStatus
+		if _, werr := unix.Wait4(pid, &ws, unix.WNOHANG, nil); werr != nil {
+			err = werr
+			break
+		}
+		status := ws.ExitStatus()
+		if ws.Signaled() {
+			status = SignalExitStatus(ws.Signal())
+		}
+		exits = append(exits, runc.Exit{
+			Timestamp: now,
+			Pid:       pid,
+			Status:    status,
+		})
+	}
+	r.publish(exits...)
+	return err
+}
+
+// startTracked starts the command and tracks it if its pidfd can be opened.
+// It returns the pidfd, or -1 for commands left to the reaper.
+func (r *Reaper) startTracked(c *exec.Cmd) (int, error) {
+	r.reapMu.Lock()
+	defer r.reapMu.Unlock()
+	if err := c.Start(); err != nil {
+		return -1, err
+	}
+	fd, err := pidfdOpen(c.Process.Pid)
+	if err != nil {
+		return -1, nil
+	}
+	r.tracked[c.Process.Pid] = struct{}{}
+	return fd, nil
+}
+
+// untrack leaves the pid to the reaper again, once its exit was collected.
+func (r *Reaper) untrack(pid int) {
+	r.reapMu.Lock()
+	delete(r.tracked, pid)
+	r.reapMu.Unlock()
+}
+
+// Start starts the command and registers the process with the reaper.
+func (r *Reaper) Start(c *exec.Cmd) (chan runc.Exit, error) {
+	ec := r.Subscribe()
+	if err := c.Start(); err != nil {
+		r.Unsubscribe(ec)
+		return nil, err
+	}
+	return ec, nil
+}
+
+// Wait blocks until the command exits and returns its exit status.
+func (r *Reaper) Wait(c *exec.Cmd, ec chan runc.Exit) (int, error) {
+	for e := range ec {
+		if e.Pid == c.Process.Pid {
+			// make sure we flush all IO
+			c.Wait()
+			r.Unsubscribe(ec)
+			return e.Status, nil
+		}
+	}
+	return -1, errors.New("no such process")
+}
+
+// Subscribe to process exit changes.
+func (r *Reaper) Subscribe() chan runc.Exit {
+	c := make(chan runc.Exit, exitBufferSize)
+	r.mu.Lock()
+	r.subscribers[c] = struct{}{}
+	r.mu.Unlock()
+	return c
+}
+
+// Unsubscribe to process exit changes.
+func (r *Reaper) Unsubscribe(c chan runc.Exit) {
+	r.mu.Lock()
+	if _, ok := r.subscribers[c]; ok {
+		delete(r.subscribers, c)
+		close(c)
+	}
+	r.mu.Unlock()
+}
+
+func (r *Reaper) publish(exits ...runc.Exit) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for c := range r.subscribers {
+		for _, e := range exits {
+			c <- e
+		}
+	}
+}
@@ -0,0 +1,239 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	runc "github.com/containerd/go-runc"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+
+	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/cgroup"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/utils"
+)
+
+// UntrustedAnnotation set to "false" runs the container with runc instead of
+// in a sandbox, on shims configured with a runc binary, so that pods of mixed
+// trust levels can be run on nodes registering a single runtime handler. It
+// is set on the pod sandbox and each of its containers.
+const UntrustedAnnotation = "dev.gvisor.untrusted"
+
+// RuncRoot is the root of the state of the containers run with runc, the one
+// of the containerd runc shims.
+const RuncRoot = "/run/containerd/runc"
+
+// readUntrusted returns whether the spec in bundle keeps the container in a
+// sandbox, the default.
+func readUntrusted(bundle string) (bool, error) {
+	spec, err := utils.ReadSpec(bundle)
+	if err != nil {
+		return false, errors.Wrap(err, "read oci spec")
+	}
+	v, ok := spec.Annotations[UntrustedAnnotation]
+	if !ok {
+		return true, nil
+	}
+	untrusted, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, errors.Wrapf(errdefs.ErrInvalidArgument, "invalid %s annotation %q", UntrustedAnnotation, v)
+	}
+	return untrusted, nil
+}
+
+// selectRuntime switches the container to runc if its spec opts out of the
+// sandbox and the shim has a runc binary.
+func (p *Init) selectRuntime(ctx context.Context) error {
+	untrusted, err := readUntrusted(p.Bundle)
+	if err != nil || untrusted {
+		return err
+	}
+	if p.RuncBinary == "" {
+		log.G(ctx).Warnf("Container %q opts out of the sandbox, but the shim has no runc binary: running it with runsc", p.id)
+		return nil
+	}
+	p.client = newRuncClient(p.client, p.RuncBinary)
+	p.runtime = &runcRuntime{
+		Runsc:   p.client,
+		monitor: p.Monitor,
+		waits:   make(map[string]runcWait),
+	}
+	p.unsandboxed = true
+	log.G(ctx).Infof("Running container %q with %s, outside of a sandbox", p.id, p.RuncBinary)
+	return nil
+}
+
+// Unsandboxed returns whether the container is run with runc.
+func (p *Init) Unsandboxed() bool {
+	return p.unsandboxed
+}
+
+// newRuncClient returns a client running binary like c runs runsc. The runsc
+// flags are dropped, except for the systemd cgroup one runc shares.
+func newRuncClient(c *runsc.Runsc, binary string) *runsc.Runsc {
+	config := make(map[string]string)
+	if v, ok := c.Flags()[cgroup.SystemdFlag]; ok {
+		config[cgroup.SystemdFlag] = v
+	}
+	return &runsc.Runsc{
+		Command:      binary,
+		PdeathSignal: c.PdeathSignal,
+		Setpgid:      c.Setpgid,
+		Root:         filepath.Join(RuncRoot, filepath.Base(c.Root)),
+		Log:          c.Log,
+		LogFormat:    c.LogFormat,
+		Config:       config,
+		Timeouts:     c.Timeouts,
+		Retries:      c.Retries,
+		Monitor:      c.Monitor,
+	}
+}
+
+// runcRuntime runs containers with runc, whose command line mostly matches
+// the one of runsc. runc has no wait command, so the exit of the container
+// process, a child of the shim, is taken from the monitor instead. The
+// features of runsc alone fail with ErrNotImplemented.
+type runcRuntime struct {
+	*runsc.Runsc
+	monitor ProcessMonitor
+
+	mu    sync.Mutex
+	waits map[string]runcWait
+}
+
+// runcWait is the exit subscription of a started container.
+type runcWait struct {
+	pid   int
+	exits chan runc.Exit
+}
+
+func (r *runcRuntime) Create(ctx context.Context, id, bundle string, opts *runsc.CreateOpts) error {
+	o := *opts
+	o.UserLog = ""
+	return r.Runsc.Create(ctx, id, bundle, &o)
+}
+
+// Start starts the container, subscribed to its exit before it can exit.
+// The io was handed to the container on create.
+func (r *runcRuntime) Start(ctx context.Context, id string, _ runc.IO) error {
+	c, err := r.Runsc.State(ctx, id)
+	if err != nil {
+		return err
+	}
+	exits := r.monitor.Subscribe()
+	if err := r.Runsc.Start(ctx, id, nil); err != nil {
+		r.monitor.Unsubscribe(exits)
+		return err
+	}
+	r.mu.Lock()
+	r.waits[id] = runcWait{pid: c.Pid, exits: exits}
+	r.mu.Unlock()
+	return nil
+}
+
+// Wait returns the exit status of the container started by Start.
+func (r *runcRuntime) Wait(ctx context.Context, id string) (int, error) {
+	r.mu.Lock()
+	w, ok := r.waits[id]
+	delete(r.waits, id)
+	r.mu.Unlock()
+	if !ok {
+		return 0, errors.Wrapf(errdefs.ErrFailedPrecondition, "container %s wasn't started", id)
+	}
+	defer r.monitor.Unsubscribe(w.exits)
+	for e := range w.exits {
+		if e.Pid == w.pid {
+			return e.Status, nil
+		}
+	}
+	return 0, errors.Errorf("exits of container %s closed", id)
+}
+
+// Exec execs the process. runc reports the host pid of the process only,
+// so the internal pid file is left unset: runc commands, such as ps and
+// kill, take host pids.
+func (r *runcRuntime) Exec(ctx context.Context, id string, spec specs.Process, opts *runsc.ExecOpts) error {
+	o := *opts
+	o.InternalPidFile = ""
+	return r.Runsc.Exec(ctx, id, spec, &o)
+}
+
+// Kill signals the container, or the process with the pid of opts directly:
+// runc can only signal the container.
+func (r *runcRuntime) Kill(ctx context.Context, id string, sig int, opts *runsc.KillOpts) error {
+	if opts == nil || opts.Pid == 0 {
+		return r.Runsc.Kill(ctx, id, sig, opts)
+	}
+	return syscall.Kill(opts.Pid, syscall.Signal(sig))
+}
+
+func (r *runcRuntime) Checkpoint(ctx context.Context, id string, opts *runsc.CheckpointOpts) error {
+	return errors.Wrap(errdefs.ErrNotImplemented, "containers run with runc can't be checkpointed")
+}
+
+func (r *runcRuntime) Restore(ctx context.Context, id, bundle string, opts *runsc.RestoreOpts) error {
+	return errors.Wrap(errdefs.ErrNotImplemented, "containers run with runc can't be restored")
+}
+
+func (r *runcRuntime) PortForward(ctx context.Context, id string, port int) (net.Conn, error) {
+	return nil, errors.Wrap(errdefs.ErrNotImplemented, "runc has no port-forward command")
+}
+
+func (r *runcRuntime) ExportMetrics(ctx context.Context, id string) ([]byte, error) {
+	return nil, errors.Wrap(errdefs.ErrNotImplemented, "runc has no sandbox metrics")
+}
+
+// Version returns the version reported by runc --version.
+func (r *runcRuntime) Version(ctx context.Context) (string, error) {
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, r.Command, "--version")
+	cmd.Stdout = &out
+	monitor := r.Runsc.Monitor
+	if monitor == nil {
+		monitor = runsc.Monitor
+	}
+	ec, err := monitor.Start(cmd)
+	if err != nil {
+		return "", err
+	}
+	if status, err := monitor.Wait(cmd, ec); err != nil || status != 0 {
+		return "", errors.Errorf("%s --version failed with status %d: %v", r.Command, status, err)
+	}
+	line := strings.SplitN(strings.TrimSpace(out.String()), "\n", 2)[0]
+	return strings.TrimSpace(strings.TrimPrefix(line, "runc version")), nil
+}
+
+// Commands returns no commands: the optional ones detected are runsc ones.
+func (r *runcRuntime) Commands(ctx context.Context) (map[string]bool, error) {
+	return map[string]bool{}, nil
+}
+
+// GlobalFlags returns the global flags of runc the shim checks for.
+func (r *runcRuntime) GlobalFlags(ctx context.Context) (map[string]bool, error) {
+	return map[string]bool{cgroup.SystemdFlag: true}, nil
+}
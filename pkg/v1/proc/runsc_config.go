@@ -0,0 +1,138 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/pkg/errors"
+
+	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// RunscConfigFile is the name of the file in the bundle that holds the
+// runsc configuration the container was created with. It is written before
+// runsc create runs, so that it is there when the create fails too.
+const RunscConfigFile = "runsc-config.json"
+
+// snapshotRunscConfig records the runsc configuration the container is
+// about to be created with, opts being the options of runsc create.
+func (p *Init) snapshotRunscConfig(opts *runsc.CreateOpts) error {
+	command, err := p.runtime.CreateCommand(p.id, p.Bundle, opts)
+	if err != nil {
+		return err
+	}
+	flags := copyFlags(p.runtime.Flags())
+	requested := copyFlags(p.RequestedRunscFlags)
+	c := &runsctypes.RunscConfig{
+		ContainerID: p.id,
+		SandboxID:   p.SandboxID,
+		Runtime:     command[0],
+		Requested:   requested,
+		Flags:       flags,
+		Changed:     changedFlags(requested, flags),
+		Command:     command,
+		CreatedAt:   time.Now(),
+	}
+	for _, arg := range command[1:] {
+		if strings.HasPrefix(arg, "--root=") {
+			c.Root = strings.TrimPrefix(arg, "--root=")
+		}
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(p.Bundle, RunscConfigFile), data, 0644); err != nil {
+		return errors.Wrap(err, "write runsc config")
+	}
+	p.runscConfig = c
+	return nil
+}
+
+// RunscConfig returns the runsc configuration the container was created
+// with.
+func (p *Init) RunscConfig() (*runsctypes.RunscConfig, error) {
+	p.mu.Lock()
+	c := p.runscConfig
+	p.mu.Unlock()
+	if c != nil {
+		return c, nil
+	}
+	return ReadRunscConfig(p.Bundle)
+}
+
+// ReadRunscConfig reads the runsc configuration stored in the bundle.
+func ReadRunscConfig(bundle string) (*runsctypes.RunscConfig, error) {
+	data, err := ioutil.ReadFile(filepath.Join(bundle, RunscConfigFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.Wrap(errdefs.ErrNotFound, "container has no runsc config")
+		}
+		return nil, err
+	}
+	var c runsctypes.RunscConfig
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, errors.Wrap(err, "decode runsc config")
+	}
+	return &c, nil
+}
+
+// RequestedFlags returns the runsc flags of the shim configuration as
+// requested for the container id of namespace, to be set as the
+// RequestedRunscFlags of the container. Its log path is filled in like the
+// one of the flags it is created with, so that only actual changes are
+// reported.
+func RequestedFlags(namespace, id string, config map[string]string) map[string]string {
+	requested := copyFlags(config)
+	runsc.FormatLogPath(namespace, id, requested)
+	return requested
+}
+
+func copyFlags(flags map[string]string) map[string]string {
+	c := make(map[string]string, len(flags))
+	for k, v := range flags {
+		c[k] = v
+	}
+	return c
+}
+
+// changedFlags returns the sorted flags whose value differs between from
+// and to, including those only set in one of them.
+func changedFlags(from, to map[string]string) []string {
+	var changed []string
+	for k, v := range to {
+		if old, ok := from[k]; !ok || old != v {
+			changed = append(changed, k)
+		}
+	}
+	for k := range from {
+		if _, ok := to[k]; !ok {
+			changed = append(changed, k)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
@@ -0,0 +1,40 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"testing"
+
+	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
+)
+
+func TestRequestedFlagsLogPath(t *testing.T) {
+	config := map[string]string{
+		"debug":     "true",
+		"debug-log": "/var/log/runsc/%NAMESPACE%/",
+	}
+	requested := RequestedFlags("k8s.io", "abc", config)
+	if config["debug-log"] != "/var/log/runsc/%NAMESPACE%/" {
+		t.Errorf("shim configuration was changed to %q", config["debug-log"])
+	}
+	// The flags the container is created with.
+	flags := copyFlags(config)
+	runsc.FormatLogPath("k8s.io", "abc", flags)
+	if changed := changedFlags(requested, flags); len(changed) != 0 {
+		t.Errorf("flags %v changed, expected none", changed)
+	}
+}
@@ -0,0 +1,44 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	rproc "github.com/containerd/containerd/runtime/proc"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// RunscState returns the runsc specific state of p, a process of the
+// container id.
+func RunscState(id string, p rproc.Process) *runsctypes.State {
+	state := &runsctypes.State{
+		ID:  id,
+		Pid: uint32(p.Pid()),
+	}
+	if p.ID() != id {
+		state.ExecID = p.ID()
+	}
+	if ip, ok := p.(InternalPider); ok && ip.InternalPid() > 0 {
+		state.InternalPid = uint32(ip.InternalPid())
+	}
+	if init, ok := p.(*Init); ok {
+		state.OOMScoreAdj = init.oomScoreAdj
+		state.ShmSize = init.ShmSize
+		state.CgroupMode, state.CgroupDriver, state.CgroupPath = init.cgroupInfo(init.Pid())
+	}
+	return state
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+	"net"
+
+	runc "github.com/containerd/go-runc"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+
+	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
+)
+
+// Runtime is the OCI runtime the init and exec processes are run with: runsc,
+// or runc for the containers that opt out of the sandbox. It follows the
+// command line of runsc.
+type Runtime interface {
+	Create(ctx context.Context, id, bundle string, opts *runsc.CreateOpts) error
+	CreateCommand(id, bundle string, opts *runsc.CreateOpts) ([]string, error)
+	Start(ctx context.Context, id string, cio runc.IO) error
+	Wait(ctx context.Context, id string) (int, error)
+	State(ctx context.Context, id string) (*runc.Container, error)
+	Exec(ctx context.Context, id string, spec specs.Process, opts *runsc.ExecOpts) error
+	Kill(ctx context.Context, id string, sig int, opts *runsc.KillOpts) error
+	Delete(ctx context.Context, id string, opts *runsc.DeleteOpts) error
+	Pause(ctx context.Context, id string) error
+	Resume(ctx context.Context, id string) error
+	Checkpoint(ctx context.Context, id string, opts *runsc.CheckpointOpts) error
+	Restore(ctx context.Context, id, bundle string, opts *runsc.RestoreOpts) error
+	Top(ctx context.Context, id string) (*runc.TopResults, error)
+	PortForward(ctx context.Context, id string, port int) (net.Conn, error)
+	ExportMetrics(ctx context.Context, id string) ([]byte, error)
+
+	// Version, Commands and GlobalFlags describe the runtime binary.
+	Version(ctx context.Context) (string, error)
+	Commands(ctx context.Context) (map[string]bool, error)
+	GlobalFlags(ctx context.Context) (map[string]bool, error)
+
+	// Flags and SetConfig are the global flags of the commands.
+	Flags() map[string]string
+	SetConfig(config map[string]string)
+}
@@ -0,0 +1,254 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	"golang.org/x/sys/unix"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/cgroup"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// SandboxInfoFile is the name of the file in the bundle that describes how
+// the container is sandboxed. The vendored State API has no room for
+// extensions, so tooling reads the sandbox details from the bundle instead.
+const SandboxInfoFile = "sandbox-info.json"
+
+// SandboxInfo returns the gVisor specific details of the container.
+func (p *Init) SandboxInfo(ctx context.Context) (*runsctypes.SandboxInfo, error) {
+	config := p.runtime.Flags()
+	info := &runsctypes.SandboxInfo{
+		ContainerID: p.id,
+		SandboxID:   p.SandboxID,
+		Platform:    config["platform"],
+		Network:     config["network"],
+		Overlay:     config["overlay"] == "true",
+		ShmSize:     p.ShmSize,
+		OOMScoreAdj: p.oomScoreAdj,
+	}
+	if info.Platform == "" {
+		info.Platform = "ptrace"
+	}
+	if info.Network == "" {
+		info.Network = "sandbox"
+	}
+	version, err := p.runtime.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+	info.Version = version
+	sandboxID := p.SandboxID
+	if sandboxID == "" {
+		sandboxID = p.id
+		info.SandboxID = p.id
+	}
+	state, err := p.runtime.State(ctx, sandboxID)
+	if err != nil {
+		return nil, p.runtimeError(err, "OCI runtime state failed")
+	}
+	info.SandboxPid = state.Pid
+	info.GoferPids = goferPids(p.Bundle)
+	info.CgroupMode, info.CgroupDriver, info.CgroupPath = p.cgroupInfo(state.Pid)
+	return info, nil
+}
+
+// cgroupInfo returns the cgroup mode of the host, the cgroup driver of the
+// container and the cgroup of pid in the unified hierarchy, empty on v1
+// hosts.
+func (p *Init) cgroupInfo(pid int) (mode, driver, path string) {
+	mode = string(cgroup.DetectMode())
+	driver = string(cgroup.Cgroupfs)
+	if p.runtime.Flags()[cgroup.SystemdFlag] == "true" || p.scope != nil {
+		driver = string(cgroup.Systemd)
+	}
+	if mode != string(cgroup.Legacy) && pid > 0 {
+		path, _ = cgroup.PidPath(pid)
+	}
+	return mode, driver, path
+}
+
+// writeSandboxInfo stores the sandbox details in the bundle. Failures are
+// only logged as the details are informational.
+func (p *Init) writeSandboxInfo(ctx context.Context) {
+	info, err := p.SandboxInfo(ctx)
+	if err != nil {
+		log.G(ctx).WithError(err).Warnf("Failed to get sandbox info of container %q", p.id)
+		return
+	}
+	data, err := json.Marshal(info)
+	if err != nil {
+		log.G(ctx).WithError(err).Warnf("Failed to marshal sandbox info of container %q", p.id)
+		return
+	}
+	if err := ioutil.WriteFile(filepath.Join(p.Bundle, SandboxInfoFile), data, 0644); err != nil {
+		log.G(ctx).WithError(err).Warnf("Failed to write sandbox info of container %q", p.id)
+	}
+}
+
+// ReadSandboxInfo reads the sandbox details stored in the bundle.
+func ReadSandboxInfo(bundle string) (*runsctypes.SandboxInfo, error) {
+	data, err := ioutil.ReadFile(filepath.Join(bundle, SandboxInfoFile))
+	if err != nil {
+		return nil, err
+	}
+	var info runsctypes.SandboxInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// goferPids returns the pids of the runsc gofer processes serving bundle.
+// runsc doesn't report gofer pids, so they are found by their command line.
+func goferPids(bundle string) []int {
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	var pids []int
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		cmdline, err := ioutil.ReadFile(filepath.Join("/proc", e.Name(), "cmdline"))
+		if err != nil {
+			continue
+		}
+		if isGofer(bytes.Split(cmdline, []byte{0}), bundle) {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// killGofers kills the gofers of the sandbox, simulating their death.
+func (p *Init) killGofers(ctx context.Context) {
+	for _, pid := range goferPids(p.Bundle) {
+		if err := unix.Kill(pid, unix.SIGKILL); err != nil {
+			log.G(ctx).WithError(err).Warnf("Failed to kill gofer %d", pid)
+		}
+	}
+}
+
+// goferPollInterval is how often gofers are checked when their exit can't be
+// watched through a pidfd. Polling starts at minPollInterval and backs off
+// up to it.
+const (
+	goferPollInterval = time.Second
+	minPollInterval   = 10 * time.Millisecond
+)
+
+// WatchGofers calls fn with the pid of each gofer of the sandbox that exits
+// before ctx is done. The gofers aren't children of the shim, so they are
+// watched through pidfds, or polled on kernels without pidfd_open.
+func (p *Init) WatchGofers(ctx context.Context, fn func(pid int)) {
+	for _, pid := range goferPids(p.Bundle) {
+		go watchPid(ctx, pid, fn)
+	}
+}
+
+// watchPid calls fn once pid exited, unless ctx is done first. It waits on
+// a pidfd of pid, which becomes readable on exit, and polls pid with
+// backoff when pidfds aren't supported.
+func watchPid(ctx context.Context, pid int, fn func(pid int)) {
+	fd, err := pidfdOpen(pid)
+	if err == nil {
+		defer unix.Close(fd)
+	}
+	interval := minPollInterval
+	for {
+		if err == nil {
+			fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+			if n, _ := unix.Poll(fds, int(goferPollInterval/time.Millisecond)); n > 0 {
+				break
+			}
+		} else {
+			if unix.Kill(pid, 0) == unix.ESRCH {
+				break
+			}
+			time.Sleep(interval)
+			if interval *= 2; interval > goferPollInterval {
+				interval = goferPollInterval
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+	if ctx.Err() == nil {
+		fn(pid)
+	}
+}
+
+func isGofer(args [][]byte, bundle string) bool {
+	var gofer, match bool
+	for i, arg := range args {
+		a := string(arg)
+		switch {
+		case a == "gofer":
+			gofer = true
+		case a == "--bundle="+bundle, a == "-bundle="+bundle:
+			match = true
+		case (a == "--bundle" || a == "-bundle") && i+1 < len(args) && string(args[i+1]) == bundle:
+			match = true
+		}
+	}
+	return gofer && match && bundle != ""
+}
+
+// SandboxStatus returns the status of the pod sandbox backed by p, its
+// pause container, created at created.
+func (p *Init) SandboxStatus(ctx context.Context, created time.Time) (*runsctypes.SandboxStatus, error) {
+	status := &runsctypes.SandboxStatus{
+		SandboxID: p.ID(),
+		Pid:       p.Pid(),
+		State:     runsctypes.SandboxNotReady,
+		CreatedAt: created,
+		ExitedAt:  p.ExitedAt(),
+	}
+	st, err := p.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if st == "running" {
+		status.State = runsctypes.SandboxReady
+		if status.Info, err = p.SandboxInfo(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
+
+// Exited returns a channel closed once p exits.
+func (p *Init) Exited() <-chan struct{} {
+	exited := make(chan struct{})
+	go func() {
+		p.Wait()
+		close(exited)
+	}()
+	return exited
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestWatchPid(t *testing.T) {
+	cmd := exec.Command("sleep", "0.1")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	go cmd.Wait()
+	exited := make(chan int, 1)
+	go watchPid(context.Background(), cmd.Process.Pid, func(pid int) {
+		exited <- pid
+	})
+	select {
+	case pid := <-exited:
+		if pid != cmd.Process.Pid {
+			t.Errorf("got exit of %d, expected %d", pid, cmd.Process.Pid)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("exit not reported")
+	}
+}
+
+func TestWatchPidCanceled(t *testing.T) {
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		watchPid(ctx, cmd.Process.Pid, func(int) {
+			t.Error("exit reported for a running process")
+		})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watch not canceled")
+	}
+}
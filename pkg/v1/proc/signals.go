@@ -0,0 +1,106 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// maxSignal is the largest signal number the sandbox can deliver.
+const maxSignal = 64
+
+// rejectSignal is the SignalMap value of signals that are never delivered.
+const rejectSignal = "reject"
+
+// SignalMap translates signals before they are sent to the sandbox. A signal
+// mapped to 0 is rejected.
+type SignalMap map[uint32]uint32
+
+// ParseSignalMap parses a signal translation table, e.g.
+// {"SIGPWR": "SIGTERM", "SIGWINCH": "reject"}. Signals are given by name,
+// with or without the SIG prefix, or by number.
+func ParseSignalMap(m map[string]string) (SignalMap, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	out := make(SignalMap, len(m))
+	for from, to := range m {
+		f, err := ParseSignal(from)
+		if err != nil {
+			return nil, err
+		}
+		if to == rejectSignal {
+			out[f] = 0
+			continue
+		}
+		t, err := ParseSignal(to)
+		if err != nil {
+			return nil, err
+		}
+		out[f] = t
+	}
+	return out, nil
+}
+
+// ParseSignal returns the number of the named signal.
+func ParseSignal(s string) (uint32, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n <= 0 || n > maxSignal {
+			return 0, errors.Errorf("invalid signal %q", s)
+		}
+		return uint32(n), nil
+	}
+	name := strings.ToUpper(s)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	for n := 1; n <= maxSignal; n++ {
+		if unix.SignalName(syscall.Signal(n)) == name {
+			return uint32(n), nil
+		}
+	}
+	return 0, errors.Errorf("unknown signal %q", s)
+}
+
+// Translate returns the signal to deliver to the sandbox for sig, or an
+// invalid argument error if the signal can't be delivered.
+func (m SignalMap) Translate(sig uint32) (uint32, error) {
+	if sig == 0 || sig > maxSignal {
+		return 0, errors.Wrapf(errdefs.ErrInvalidArgument, "signal %d is not supported by the sandbox", sig)
+	}
+	to, ok := m[sig]
+	if !ok {
+		return sig, nil
+	}
+	if to == 0 {
+		return 0, errors.Wrapf(errdefs.ErrInvalidArgument, "signal %s is rejected by the shim configuration", signalName(sig))
+	}
+	return to, nil
+}
+
+func signalName(sig uint32) string {
+	if name := unix.SignalName(syscall.Signal(sig)); name != "" {
+		return name
+	}
+	return strconv.Itoa(int(sig))
+}
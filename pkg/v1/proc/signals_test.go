@@ -0,0 +1,92 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseSignalMap(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		in    map[string]string
+		want  SignalMap
+		valid bool
+	}{
+		{name: "empty", valid: true},
+		{
+			name:  "names and numbers",
+			in:    map[string]string{"SIGPWR": "term", "10": "SIGUSR2", "winch": "reject"},
+			want:  SignalMap{uint32(unix.SIGPWR): uint32(unix.SIGTERM), uint32(unix.SIGUSR1): uint32(unix.SIGUSR2), uint32(unix.SIGWINCH): 0},
+			valid: true,
+		},
+		{name: "unknown signal", in: map[string]string{"SIGFOO": "SIGTERM"}},
+		{name: "unknown target", in: map[string]string{"SIGPWR": "SIGFOO"}},
+		{name: "out of range", in: map[string]string{"65": "SIGTERM"}},
+		{name: "reject as source", in: map[string]string{"reject": "SIGTERM"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseSignalMap(tc.in)
+			if !tc.valid {
+				if err == nil {
+					t.Fatalf("got %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	m := SignalMap{uint32(unix.SIGPWR): uint32(unix.SIGTERM), uint32(unix.SIGWINCH): 0}
+	for _, tc := range []struct {
+		sig, want uint32
+		valid     bool
+	}{
+		{sig: uint32(unix.SIGTERM), want: uint32(unix.SIGTERM), valid: true},
+		{sig: uint32(unix.SIGPWR), want: uint32(unix.SIGTERM), valid: true},
+		{sig: maxSignal, want: maxSignal, valid: true},
+		{sig: uint32(unix.SIGWINCH)},
+		{sig: 0},
+		{sig: maxSignal + 1},
+	} {
+		got, err := m.Translate(tc.sig)
+		if !tc.valid {
+			if !errdefs.IsInvalidArgument(err) {
+				t.Errorf("Translate(%d): got %d, %v, want an invalid argument error", tc.sig, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("Translate(%d): got %d, %v, want %d", tc.sig, got, err, tc.want)
+		}
+	}
+	// Without a map, signals are delivered as sent.
+	if got, err := SignalMap(nil).Translate(uint32(unix.SIGPWR)); err != nil || got != uint32(unix.SIGPWR) {
+		t.Errorf("Translate without map: got %d, %v, want %d", got, err, unix.SIGPWR)
+	}
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+	"time"
+
+	"github.com/containerd/containerd/api/types/task"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/pkg/errors"
+)
+
+// State is a state of the lifecycle of an init or exec process.
+type State string
+
+// The states of a process. Exec processes are never paused on their own,
+// they are frozen with their container.
+const (
+	Created State = "created"
+	Running State = "running"
+	Paused  State = "paused"
+	Stopped State = "stopped"
+	Deleted State = "deleted"
+)
+
+// transitions are the legal transitions from each state. A running process
+// is only deleted once stopped, even when force deleted.
+var transitions = map[State][]State{
+	Created: {Running, Stopped, Deleted},
+	Running: {Paused, Stopped},
+	Paused:  {Running, Stopped},
+	Stopped: {Deleted},
+}
+
+// checkTransition returns a FailedPrecondition error if a process can't go
+// from the from to the to state.
+func checkTransition(from, to State) error {
+	for _, s := range transitions[from] {
+		if s == to {
+			return nil
+		}
+	}
+	return errors.Wrapf(errdefs.ErrFailedPrecondition, "invalid state transition %q to %q", from, to)
+}
+
+// StateChange is a transition of a process from one state to another.
+type StateChange struct {
+	// ID is the id of the container.
+	ID string
+	// ExecID is the id of the exec process, empty for the init process.
+	ExecID    string
+	From      State
+	To        State
+	Timestamp time.Time
+}
+
+// stateOf returns the state of the state implementation v.
+func stateOf(v interface{}) State {
+	switch v.(type) {
+	case *createdState, *execCreatedState:
+		return Created
+	case *runningState, *execRunningState:
+		return Running
+	case *pausedState:
+		return Paused
+	case *stoppedState, *execStoppedState:
+		return Stopped
+	case *deletedState:
+		return Deleted
+	}
+	panic(errors.Errorf("invalid state %v", v))
+}
+
+// transition moves the init process to the to state and reports the change
+// to StateChanged.
+func (p *Init) transition(to State) error {
+	from := stateOf(p.initState)
+	if err := checkTransition(from, to); err != nil {
+		return err
+	}
+	switch to {
+	case Running:
+		p.initState = &runningState{p: p}
+	case Paused:
+		p.initState = &pausedState{p: p}
+	case Stopped:
+		p.initState = &stoppedState{p: p}
+	case Deleted:
+		p.initState = &deletedState{}
+	}
+	p.stateChanged("", from, to)
+	return nil
+}
+
+// transition moves the exec process to the to state and reports the change
+// to the StateChanged of its container.
+func (e *execProcess) transition(to State) error {
+	from := stateOf(e.execState)
+	if to == Paused {
+		return errors.Wrapf(errdefs.ErrFailedPrecondition, "invalid state transition %q to %q", from, to)
+	}
+	if err := checkTransition(from, to); err != nil {
+		return err
+	}
+	switch to {
+	case Running:
+		e.execState = &execRunningState{p: e}
+	case Stopped:
+		e.execState = &execStoppedState{p: e}
+	case Deleted:
+		e.execState = &deletedState{}
+	}
+	e.parent.stateChanged(e.id, from, to)
+	return nil
+}
+
+func (p *Init) stateChanged(execID string, from, to State) {
+	if p.StateChanged == nil {
+		return
+	}
+	p.StateChanged(StateChange{
+		ID:        p.id,
+		ExecID:    execID,
+		From:      from,
+		To:        to,
+		Timestamp: time.Now(),
+	})
+}
+
+// TaskStatus maps the status of the process id, as reported by its Status
+// method, to the status of the task API. The status of the init process
+// comes from runsc, so statuses the API has no equivalent for are logged
+// and reported unknown.
+func TaskStatus(ctx context.Context, id, status string) task.Status {
+	switch status {
+	case "creating", "created":
+		// containerd has no creating status, the process isn't running
+		// yet either way.
+		return task.StatusCreated
+	case "running":
+		return task.StatusRunning
+	case "pausing":
+		return task.StatusPausing
+	case "paused":
+		return task.StatusPaused
+	case "stopped":
+		return task.StatusStopped
+	}
+	log.G(ctx).WithField("id", id).WithField("status", status).Warn("unknown process status")
+	return task.StatusUnknown
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"net/url"
+	"os"
+	"os/exec"
+
+	"github.com/containerd/containerd/errdefs"
+	runc "github.com/containerd/go-runc"
+	"github.com/pkg/errors"
+)
+
+// stdinFile returns the path of the input file of a file:// stdin URI.
+func stdinFile(stdin string) (string, bool) {
+	u, err := url.Parse(stdin)
+	if err != nil || u.Scheme != "file" {
+		return "", false
+	}
+	return u.Path, true
+}
+
+// stdinFifo returns the fifo stdin is copied from, "" when the process has
+// no stdin or reads /dev/null or a file: then no fifo or pipe is opened for
+// it.
+func stdinFifo(stdin string) string {
+	if stdin == os.DevNull {
+		return ""
+	}
+	if _, ok := stdinFile(stdin); ok {
+		return ""
+	}
+	return stdin
+}
+
+// checkStdin checks that stdin can be used for a process with a terminal,
+// whose input is copied from a fifo to the console.
+func checkStdin(stdin string, terminal bool) error {
+	if _, ok := stdinFile(stdin); ok && terminal {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "stdin file %s can't be used with a terminal", stdin)
+	}
+	return nil
+}
+
+// stdinFileIO gives the input file to the process as its stdin, so that
+// batch processes are fed without a fifo, a pipe or a copy by the shim.
+type stdinFileIO struct {
+	runc.IO
+	f *os.File
+}
+
+// withStdinFile opens the input file of a file:// stdin and adds it to rio,
+// rio is returned as is for other stdins.
+func withStdinFile(rio runc.IO, stdin string) (runc.IO, error) {
+	path, ok := stdinFile(stdin)
+	if !ok {
+		return rio, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open stdin file %s", path)
+	}
+	return &stdinFileIO{IO: rio, f: f}, nil
+}
+
+func (s *stdinFileIO) Set(cmd *exec.Cmd) {
+	s.IO.Set(cmd)
+	cmd.Stdin = s.f
+}
+
+func (s *stdinFileIO) CloseAfterStart() error {
+	// The process holds its own descriptor of the file once started.
+	s.f.Close()
+	if c, ok := s.IO.(runc.StartCloser); ok {
+		return c.CloseAfterStart()
+	}
+	return nil
+}
+
+func (s *stdinFileIO) Close() error {
+	s.f.Close()
+	return s.IO.Close()
+}
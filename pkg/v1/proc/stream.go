@@ -0,0 +1,101 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/fifo"
+	"github.com/pkg/errors"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/ringio"
+)
+
+// openOutput opens the destination of a process output. name is the path of
+// a fifo or file, or a ring:///path URI: the output is then written to the
+// shared memory ring buffer set up at path by its consumer, see package
+// ringio. Consumers without ring buffers create a fifo at path instead, which
+// the output then goes to, or to the destination given with ?fallback=<fifo>.
+//
+// Other URIs, containerd's stream:// ones included, are rejected rather than
+// mistaken for file paths.
+//
+// The reader end of a fifo is returned too, to keep the fifo open until the
+// output is copied; it is nil for other destinations.
+func openOutput(ctx context.Context, name string) (io.WriteCloser, io.Closer, error) {
+	if u, err := url.Parse(name); err == nil && u.Scheme != "" {
+		if u.Scheme != "ring" {
+			return nil, nil, errors.Wrapf(errdefs.ErrInvalidArgument, "unsupported io URI %s", name)
+		}
+		return openRing(ctx, u)
+	}
+	ok, err := isFifo(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		f, err := os.OpenFile(name, syscall.O_WRONLY|syscall.O_APPEND, 0)
+		if err != nil {
+			return nil, nil, fmt.Errorf("gvisor-containerd-shim: opening %s failed: %s", name, err)
+		}
+		return f, nil, nil
+	}
+	fw, err := fifo.OpenFifo(ctx, name, syscall.O_WRONLY, 0)
+	if err != nil {
+		return nil, nil, fifoOpenError(ctx, name, err)
+	}
+	fr, err := fifo.OpenFifo(ctx, name, syscall.O_RDONLY, 0)
+	if err != nil {
+		fw.Close()
+		return nil, nil, fifoOpenError(ctx, name, err)
+	}
+	return fw, fr, nil
+}
+
+// ringOpenTimeout bounds waiting for the consumer of a ring buffer to set it
+// up.
+const ringOpenTimeout = 2 * time.Second
+
+// openRing opens the ring buffer of a ring URI, or falls back to a fifo: the
+// one given with ?fallback=, or the one at the path of the ring.
+func openRing(ctx context.Context, u *url.URL) (io.WriteCloser, io.Closer, error) {
+	w, err := ringio.Open(u.Path, ringOpenTimeout)
+	if err == nil {
+		return w, nil, nil
+	}
+	fallback := u.Query().Get("fallback")
+	if fallback == "" {
+		if ok, _ := isFifo(u.Path); !ok {
+			return nil, nil, errors.Wrapf(errdefs.ErrUnavailable, "opening ring buffer %s: %v", u.Path, err)
+		}
+		fallback = u.Path
+	}
+	if strings.HasPrefix(fallback, "ring:") {
+		return nil, nil, errors.Wrapf(errdefs.ErrInvalidArgument, "fallback of ring buffer %s is a ring buffer", u.Path)
+	}
+	log.G(ctx).WithError(err).Warnf("Writing output to %s instead of ring buffer %s", fallback, u.Path)
+	return openOutput(ctx, fallback)
+}
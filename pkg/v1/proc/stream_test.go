@@ -0,0 +1,124 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/containerd/fifo"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/ringio"
+)
+
+func TestOpenRing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stream-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+
+	path := filepath.Join(dir, "ring")
+	r, err := ringio.Create(path, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	w, closer, err := openOutput(ctx, "ring://"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if closer != nil {
+		t.Errorf("ring buffer returned a reader to close")
+	}
+	if _, err := w.Write([]byte("ring")); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "ring" {
+		t.Errorf("read %q from ring buffer, expected %q", got, "ring")
+	}
+}
+
+func TestOpenRingFallback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stream-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+
+	// A consumer without ring buffers creates a fifo at the ring path, or
+	// at the fallback.
+	atRing := filepath.Join(dir, "ring")
+	fallback := filepath.Join(dir, "fallback")
+	for name, uri := range map[string]string{
+		atRing:   "ring://" + atRing,
+		fallback: "ring://" + filepath.Join(dir, "missing") + "?fallback=" + fallback,
+	} {
+		if err := syscall.Mkfifo(name, 0600); err != nil {
+			t.Fatal(err)
+		}
+		rd, err := fifo.OpenFifo(ctx, name, syscall.O_RDONLY|syscall.O_NONBLOCK, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, closer, err := openOutput(ctx, uri)
+		if err != nil {
+			rd.Close()
+			t.Fatalf("openOutput(%s) failed: %v", uri, err)
+		}
+		if _, err := w.Write([]byte("fifo")); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+		closer.Close()
+		got, err := ioutil.ReadAll(rd)
+		rd.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "fifo" {
+			t.Errorf("read %q from fifo of %s, expected %q", got, uri, "fifo")
+		}
+	}
+}
+
+func TestOpenRingUnavailable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stream-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(path, make([]byte, 8192), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := openOutput(context.Background(), "ring://"+path); err == nil {
+		t.Fatal("openOutput of a ring that isn't set up succeeded without a fifo to fall back to")
+	}
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// syscallLogChunk bounds how much of each debug log is scanned per interval.
+const syscallLogChunk = 1 << 20
+
+// The warnings the sentry logs for the syscalls it doesn't implement, in
+// compatibility mode. Unknown syscalls are logged by number.
+var (
+	unsupportedSyscall = regexp.MustCompile(`(?i)unsupported syscall:?\s+([a-z_][a-z0-9_]*)`)
+	unknownSyscall     = regexp.MustCompile(`(?i)unknown syscall:?\s+(\d+)`)
+)
+
+// WatchUnsupportedSyscalls scans the runsc debug logs of the sandbox for the
+// syscalls the application attempted that gVisor doesn't implement, and
+// calls fn every interval with those logged in the interval, if any. The
+// sentry logs them in the logs of the sandbox, so only the sandbox
+// container is watched. The logs are scanned once more when ctx is done.
+func (p *Init) WatchUnsupportedSyscalls(ctx context.Context, interval time.Duration, fn func(*runsctypes.UnsupportedSyscalls)) {
+	if !p.Sandbox || p.unsandboxed {
+		return
+	}
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		offsets := make(map[string]int64)
+		since := time.Now()
+		scan := func(until time.Time) {
+			counts := make(map[string]uint64)
+			for _, path := range p.debugLogs() {
+				offsets[path] = scanSyscallLog(path, offsets[path], counts)
+			}
+			if len(counts) > 0 {
+				fn(&runsctypes.UnsupportedSyscalls{
+					ContainerID: p.id,
+					Syscalls:    syscallAttempts(counts),
+					Since:       since,
+					Until:       until,
+				})
+			}
+			since = until
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				scan(time.Now())
+				return
+			case now := <-t.C:
+				scan(now)
+			}
+		}
+	}()
+}
+
+// scanSyscallLog counts the unsupported syscalls logged in the log at path
+// from off, and returns the offset to continue from. Only whole lines are
+// scanned; a partial line is scanned once complete. A log that shrank was
+// rotated or truncated and is scanned from the start.
+func scanSyscallLog(path string, off int64, counts map[string]uint64) int64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return off
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return off
+	}
+	if st.Size() < off {
+		off = 0
+	}
+	if _, err := f.Seek(off, io.SeekStart); err != nil {
+		return off
+	}
+	buf, err := ioutil.ReadAll(io.LimitReader(f, syscallLogChunk))
+	if err != nil {
+		return off
+	}
+	end := bytes.LastIndexByte(buf, '\n') + 1
+	if end == 0 && len(buf) == syscallLogChunk {
+		// A line longer than a chunk is skipped.
+		end = len(buf)
+	}
+	for _, line := range bytes.Split(buf[:end], []byte("\n")) {
+		if m := unsupportedSyscall.FindSubmatch(line); m != nil {
+			counts[string(m[1])]++
+		} else if m := unknownSyscall.FindSubmatch(line); m != nil {
+			counts[string(m[1])]++
+		}
+	}
+	return off + int64(end)
+}
+
+// syscallAttempts returns counts sorted by decreasing count, then by name.
+func syscallAttempts(counts map[string]uint64) []runsctypes.SyscallAttempt {
+	attempts := make([]runsctypes.SyscallAttempt, 0, len(counts))
+	for name, n := range counts {
+		attempts = append(attempts, runsctypes.SyscallAttempt{Name: name, Count: n})
+	}
+	sort.Slice(attempts, func(i, j int) bool {
+		if attempts[i].Count != attempts[j].Count {
+			return attempts[i].Count > attempts[j].Count
+		}
+		return attempts[i].Name < attempts[j].Name
+	})
+	return attempts
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/cgroup"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/compat"
+)
+
+// prepareSystemdCgroup handles a systemd cgroupsPath, which gets the
+// systemd-cgroup flag, for runsc releases that don't know the flag: the
+// flag is dropped and the cgroupsPath is rewritten to the cgroupfs path of
+// the scope, which the shim creates once the sandbox exists. Without the
+// scope, the sandbox would be missing from the cgroup accounting of
+// kubelet.
+func (p *Init) prepareSystemdCgroup(ctx context.Context, spec *specs.Spec) error {
+	config := p.runtime.Flags()
+	if config[cgroup.SystemdFlag] != "true" {
+		return nil
+	}
+	flags, err := p.runtime.GlobalFlags(ctx)
+	if err == nil && flags[cgroup.SystemdFlag] {
+		return nil
+	}
+	if spec.Linux == nil {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "%s requires a systemd cgroups path", cgroup.SystemdFlag)
+	}
+	scope, err := cgroup.ParseSystemdPath(spec.Linux.CgroupsPath)
+	if err != nil {
+		return err
+	}
+	if err := setCgroupsPath(p.Bundle, scope.Path()); err != nil {
+		return errors.Wrap(err, "failed to rewrite cgroups path")
+	}
+	without := make(map[string]string, len(config))
+	for k, v := range config {
+		if k != cgroup.SystemdFlag {
+			without[k] = v
+		}
+	}
+	p.runtime.SetConfig(without)
+	log.G(ctx).WithField("scope", scope.Unit).Debugf("runsc doesn't support %s, the shim creates the scope of container %q", cgroup.SystemdFlag, p.id)
+	if p.Sandbox {
+		p.scope = scope
+	}
+	return nil
+}
+
+// setCgroupsPath replaces the cgroupsPath of the spec in bundle, which runsc
+// reads.
+func setCgroupsPath(bundle, cgroupsPath string) error {
+	spec, err := compat.ReadRawSpec(bundle)
+	if err != nil {
+		return err
+	}
+	linux, _ := spec["linux"].(map[string]interface{})
+	if linux == nil {
+		linux = make(map[string]interface{})
+		spec["linux"] = linux
+	}
+	linux["cgroupsPath"] = cgroupsPath
+	return compat.WriteRawSpec(bundle, spec)
+}
+
+// startSystemdScope moves the sandbox and its gofers to the scope prepared
+// by prepareSystemdCgroup.
+func (p *Init) startSystemdScope(ctx context.Context) error {
+	pids := append([]int{p.pid}, goferPids(p.Bundle)...)
+	if err := p.scope.Start(pids); err != nil {
+		return errors.Wrapf(errdefs.ErrFailedPrecondition, "failed to create systemd scope of the sandbox: %v", err)
+	}
+	return nil
+}
+
+// stopSystemdScope stops the scope of the sandbox, if the shim created one.
+func (p *Init) stopSystemdScope(ctx context.Context) {
+	if p.scope == nil {
+		return
+	}
+	if err := p.scope.Stop(); err != nil {
+		log.G(ctx).WithError(err).Warnf("Failed to stop systemd scope %s", p.scope.Unit)
+	}
+	p.scope = nil
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+	"io"
+
+	"github.com/containerd/console"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/containerd/runtime/proc"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// TerminalStdinEOF is what is done when the client closes the stdin of a
+// process with a terminal.
+type TerminalStdinEOF string
+
+const (
+	// StdinKeepOpen leaves the terminal as is: the process isn't told, as
+	// with docker attach detaching from a container with a tty. It is the
+	// default.
+	StdinKeepOpen TerminalStdinEOF = "keep-open"
+	// StdinClose writes the end of file character to the terminal once the
+	// input reached it, as a user typing ^D.
+	StdinClose TerminalStdinEOF = "close"
+	// StdinHangup also sends SIGHUP to the process, as when the terminal
+	// of a session hangs up.
+	StdinHangup TerminalStdinEOF = "hangup"
+)
+
+// Validate checks that the behavior is known.
+func (e TerminalStdinEOF) Validate() error {
+	switch e {
+	case "", StdinKeepOpen, StdinClose, StdinHangup:
+		return nil
+	}
+	return errors.Errorf("unknown terminal stdin eof %q, expected keep-open, close or hangup", e)
+}
+
+// consoleStdinCloser is implemented by platforms that can end the input of
+// a console, see platform.CloseConsoleStdin.
+type consoleStdinCloser interface {
+	CloseConsoleStdin(ctx context.Context, cons console.Console, eof bool) (<-chan struct{}, error)
+}
+
+// closeTerminalStdin closes the stdin of the process id with the console
+// cons, as configured by eof. kill signals the process.
+func closeTerminalStdin(ctx context.Context, id string, platform proc.Platform, cons console.Console, eof TerminalStdinEOF, stdin io.Closer, kill func(context.Context, uint32, bool) error) error {
+	if eof == "" || eof == StdinKeepOpen {
+		return closeStdin(ctx, id, stdin, nil)
+	}
+	closer, ok := platform.(consoleStdinCloser)
+	if !ok {
+		log.G(ctx).Warnf("Console of process %q can't end its input, leaving it open", id)
+		return closeStdin(ctx, id, stdin, nil)
+	}
+	copied, err := closer.CloseConsoleStdin(ctx, cons, true)
+	if err != nil {
+		return errors.Wrap(err, "failed to end console input")
+	}
+	if err := closeStdin(ctx, id, stdin, copied); err != nil {
+		return err
+	}
+	if eof != StdinHangup {
+		return nil
+	}
+	if err := kill(ctx, uint32(unix.SIGHUP), false); err != nil && !errdefs.IsNotFound(err) {
+		return errors.Wrap(err, "failed to hang up the process")
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+
+	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// threadsTimeout bounds the exec listing the threads of the sandbox.
+const threadsTimeout = 10 * time.Second
+
+// AddThreads sets the threads of the processes in details. runsc ps doesn't
+// report threads, so they are listed from the procfs of the sandbox by ls
+// run in the container, which requires the image to have ls. The threads of
+// containers run with runc are read from the procfs of the host.
+func (p *Init) AddThreads(ctx context.Context, details []*runsctypes.ProcessDetails) error {
+	if len(details) == 0 {
+		return nil
+	}
+	pids := make([]uint32, 0, len(details))
+	for _, d := range details {
+		pids = append(pids, d.InternalPid)
+	}
+	var threads map[uint32][]uint32
+	if p.unsandboxed {
+		threads = hostThreads("/proc", pids)
+	} else {
+		var err error
+		if threads, err = p.sandboxThreads(ctx, pids); err != nil {
+			return err
+		}
+	}
+	for _, d := range details {
+		d.Threads = threads[d.InternalPid]
+	}
+	return nil
+}
+
+// sandboxThreads lists the threads of pids with a single ls of their task
+// directories in the procfs of the sandbox.
+func (p *Init) sandboxThreads(ctx context.Context, pids []uint32) (map[uint32][]uint32, error) {
+	ctx, cancel := context.WithTimeout(ctx, threadsTimeout)
+	defer cancel()
+	args := []string{"ls", "-1"}
+	for _, pid := range pids {
+		args = append(args, taskDir("/proc", pid))
+	}
+	spec := specs.Process{
+		Args: args,
+		Env:  []string{helperPath},
+		Cwd:  "/",
+	}
+	out := &outputIO{}
+	err := p.runtime.Exec(ctx, p.id, spec, &runsc.ExecOpts{IO: out})
+	// ls fails on the processes that exited since they were listed, but
+	// still lists the others.
+	threads := parseTasks(&out.stdout, pids)
+	if err != nil && len(threads) == 0 {
+		return nil, p.runtimeError(err, "OCI runtime exec of ls failed")
+	}
+	return threads, nil
+}
+
+// hostThreads lists the threads of pids from the procfs mounted at proc.
+// Processes that exited are skipped.
+func hostThreads(proc string, pids []uint32) map[uint32][]uint32 {
+	threads := make(map[uint32][]uint32, len(pids))
+	for _, pid := range pids {
+		entries, err := ioutil.ReadDir(taskDir(proc, pid))
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if tid, err := strconv.ParseUint(e.Name(), 10, 32); err == nil {
+				threads[pid] = append(threads[pid], uint32(tid))
+			}
+		}
+	}
+	return threads
+}
+
+func taskDir(proc string, pid uint32) string {
+	return filepath.Join(proc, strconv.FormatUint(uint64(pid), 10), "task")
+}
+
+// parseTasks parses the output of ls -1 listing the task directories of
+// pids. ls heads the entries of each directory with "<dir>:", except when
+// it lists a single one.
+func parseTasks(r io.Reader, pids []uint32) map[uint32][]uint32 {
+	dirs := make(map[string]uint32, len(pids))
+	for _, pid := range pids {
+		dirs[taskDir("/proc", pid)+":"] = pid
+	}
+	var (
+		threads = make(map[uint32][]uint32)
+		pid     uint32
+		ok      = len(pids) == 1
+	)
+	if ok {
+		pid = pids[0]
+	}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if p, isDir := dirs[line]; isDir {
+			pid, ok = p, true
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if tid, err := strconv.ParseUint(line, 10, 32); err == nil {
+			threads[pid] = append(threads[pid], uint32(tid))
+		}
+	}
+	return threads
+}
+
+// outputIO captures the stdout of a runsc command.
+type outputIO struct {
+	stdout bytes.Buffer
+}
+
+func (o *outputIO) Close() error          { return nil }
+func (o *outputIO) Stdin() io.WriteCloser { return nil }
+func (o *outputIO) Stdout() io.ReadCloser { return nil }
+func (o *outputIO) Stderr() io.ReadCloser { return nil }
+func (o *outputIO) Set(cmd *exec.Cmd)     { cmd.Stdout = &o.stdout }
@@ -0,0 +1,81 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTasks(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		out  string
+		pids []uint32
+		want map[uint32][]uint32
+	}{
+		{
+			name: "single",
+			out:  "1\n3\n",
+			pids: []uint32{1},
+			want: map[uint32][]uint32{1: {1, 3}},
+		},
+		{
+			name: "several",
+			out:  "/proc/1/task:\n1\n3\n\n/proc/7/task:\n7\n",
+			pids: []uint32{1, 7},
+			want: map[uint32][]uint32{1: {1, 3}, 7: {7}},
+		},
+		{
+			// ls reports the exited process on stderr and lists the
+			// others.
+			name: "exited",
+			out:  "/proc/7/task:\n7\n8\n",
+			pids: []uint32{1, 7},
+			want: map[uint32][]uint32{7: {7, 8}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := parseTasks(strings.NewReader(tc.out), tc.pids)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHostThreads(t *testing.T) {
+	dir, err := ioutil.TempDir("", "threads-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, tid := range []string{"10", "11", "12"} {
+		if err := os.MkdirAll(filepath.Join(dir, "10", "task", tid), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := hostThreads(dir, []uint32{10, 20})
+	want := map[uint32][]uint32{10: {10, 11, 12}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
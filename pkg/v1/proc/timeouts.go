@@ -0,0 +1,103 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	runc "github.com/containerd/go-runc"
+
+	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
+)
+
+// cleanupTimeout bounds the cleanup of a process whose setup timed out. The
+// context of the request has usually expired by then.
+const cleanupTimeout = 30 * time.Second
+
+// Timeouts bound the runsc commands setting up the processes of a container,
+// which can hang, e.g. on KVM issues. A zero timeout keeps the
+// runsc.FallbackTimeouts one. Commands that time out fail with runsc.ErrTimeout.
+type Timeouts struct {
+	Create time.Duration
+	Start  time.Duration
+	Exec   time.Duration
+	// Delete bounds runsc delete, after which the container is force
+	// deleted. Zero keeps the runsc.DefaultTimeouts one.
+	Delete time.Duration
+	// IO bounds the wait for the client to open the output fifos of a
+	// process, see Init.IOTimeout. It isn't a runsc command.
+	IO time.Duration
+}
+
+// Apply sets the configured timeouts on r.
+func (t Timeouts) Apply(r *runsc.Runsc) {
+	timeouts := make(map[string]time.Duration, len(r.Timeouts)+4)
+	for k, v := range r.Timeouts {
+		timeouts[k] = v
+	}
+	for name, d := range map[string]time.Duration{
+		"create": t.Create,
+		"start":  t.Start,
+		"exec":   t.Exec,
+		"delete": t.Delete,
+	} {
+		if d > 0 {
+			timeouts[name] = d
+		}
+	}
+	r.Timeouts = timeouts
+}
+
+// cleanupCreateTimeout deletes what runsc left behind of a container whose
+// creation timed out.
+func (p *Init) cleanupCreateTimeout() {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	if err := p.runtime.Delete(ctx, p.id, &runsc.DeleteOpts{Force: true}); err != nil && !runsc.IsNotFound(err) {
+		log.G(ctx).WithError(err).Errorf("Failed to delete container %q after create timeout", p.id)
+	}
+}
+
+// cleanupStartTimeout kills a container whose start timed out, so that it
+// can be deleted.
+func (p *Init) cleanupStartTimeout() {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	if err := p.runtime.Kill(ctx, p.id, int(syscall.SIGKILL), &runsc.KillOpts{All: true}); err != nil && !runsc.IsNotFound(err) {
+		log.G(ctx).WithError(err).Errorf("Failed to kill container %q after start timeout", p.id)
+	}
+}
+
+// cleanupExecTimeout kills an exec process whose start timed out, if runsc
+// got as far as starting it.
+func (e *execProcess) cleanupExecTimeout(internalPidfile string) {
+	if e.io != nil {
+		e.io.Close()
+	}
+	pid, err := runc.ReadPidFile(internalPidfile)
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	if err := e.parent.runtime.Kill(ctx, e.parent.id, int(syscall.SIGKILL), &runsc.KillOpts{Pid: pid}); err != nil && !runsc.IsNotFound(err) {
+		log.G(ctx).WithError(err).Errorf("Failed to kill exec %q after start timeout", e.id)
+	}
+}
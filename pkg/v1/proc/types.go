@@ -18,11 +18,18 @@ limitations under the License.
 package proc
 
 import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
 	"time"
 
 	google_protobuf "github.com/gogo/protobuf/types"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
 
 	runc "github.com/containerd/go-runc"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
 )
 
 // Mount holds filesystem mount configuration
@@ -44,6 +51,33 @@ type CreateConfig struct {
 	Stdout   string
 	Stderr   string
 	Options  *google_protobuf.Any
+	// Checkpoint is the checkpoint the container is restored from when it
+	// starts, if any.
+	Checkpoint string
+	// Spec is the spec of the container in JSON, as changed for gVisor by
+	// the shim. The config.json of the bundle is read when it is nil.
+	Spec []byte
+}
+
+// specData returns the spec of the container in JSON: Spec, or else the
+// config.json of the bundle.
+func (c *CreateConfig) specData() ([]byte, error) {
+	if c.Spec != nil {
+		return c.Spec, nil
+	}
+	data, err := ioutil.ReadFile(filepath.Join(c.Bundle, "config.json"))
+	if err != nil {
+		return nil, errors.Wrap(err, "read oci spec")
+	}
+	return data, nil
+}
+
+// CheckpointConfig holds task checkpoint configuration
+type CheckpointConfig struct {
+	// Path is the directory the checkpoint is stored in.
+	Path string
+	// Exit stops the container once its state is saved.
+	Exit bool
 }
 
 // ExecConfig holds exec creation configuration
@@ -54,6 +88,9 @@ type ExecConfig struct {
 	Stdout   string
 	Stderr   string
 	Spec     *google_protobuf.Any
+	// Mounts are the mounts the exec process needs in the container, along
+	// with the mounts of a runsctypes.ExecSpec.
+	Mounts []specs.Mount
 }
 
 // Exit is the type of exit events
@@ -63,6 +100,28 @@ type Exit struct {
 	Status    int
 }
 
+// StdinCloser is implemented by processes that flush stdin before closing it.
+type StdinCloser interface {
+	CloseStdin(context.Context) error
+}
+
+// IOCounter is implemented by processes counting the bytes of their io.
+type IOCounter interface {
+	IOStats() runsctypes.IOStats
+}
+
+// IOWaiter is implemented by processes reporting when their output is fully
+// copied, which can be after they exit when their children keep it open.
+type IOWaiter interface {
+	IODone() <-chan struct{}
+}
+
+// InternalPider is implemented by processes knowing their pid inside the
+// sandbox, which differs from the host pid of the runsc helper.
+type InternalPider interface {
+	InternalPid() int
+}
+
 // ProcessMonitor monitors process exit changes
 type ProcessMonitor interface {
 	// Subscribe to process exit changes
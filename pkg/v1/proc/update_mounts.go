@@ -0,0 +1,62 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+)
+
+// UpdateMounts is asked to add mounts to the running container, e.g. the
+// subPath and CSI mounts created after it started. The sandbox has its own
+// view of the filesystem, so mounts made on the host after the container
+// started are not propagated into it, and runsc can't add mounts to a
+// running sandbox. Instead of leaving the container with a stale view of
+// its volumes, the update fails with ErrNotImplemented naming the
+// destinations that won't be visible until the container is recreated.
+func (p *Init) UpdateMounts(ctx context.Context, mounts []specs.Mount) error {
+	p.mu.Lock()
+	exited := !p.exited.IsZero()
+	p.mu.Unlock()
+	if exited {
+		return errors.Wrapf(errdefs.ErrFailedPrecondition, "container %s has exited", p.id)
+	}
+	return p.addMounts(ctx, mounts)
+}
+
+// addMounts checks mounts to add to the running container, for Update or
+// for an exec process such as an ephemeral debug container, and fails with
+// ErrNotImplemented naming their destinations.
+func (p *Init) addMounts(ctx context.Context, mounts []specs.Mount) error {
+	if len(mounts) == 0 {
+		return errors.Wrap(errdefs.ErrInvalidArgument, "no mounts to add")
+	}
+	var dests []string
+	for i, m := range mounts {
+		if m.Source == "" || !filepath.IsAbs(m.Destination) {
+			return errors.Wrapf(errdefs.ErrInvalidArgument, "mount %d needs a source and an absolute destination", i)
+		}
+		dests = append(dests, m.Destination)
+	}
+	version, _ := p.runtime.Version(ctx)
+	return errors.Wrapf(errdefs.ErrNotImplemented, "runsc %s can't add mounts to a running sandbox: %s will only be visible once the container is recreated", version, strings.Join(dests, ", "))
+}
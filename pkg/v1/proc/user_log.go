@@ -0,0 +1,53 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// userLog returns the user log of the sandbox created with r: UserLog, or
+// with UserLogToStdout the stdout fifo of the container, which runsc then
+// writes the log to next to the output of the application.
+func (p *Init) userLog(r *CreateConfig) string {
+	if p.UserLog != "" || !p.UserLogToStdout || r.Terminal {
+		return p.UserLog
+	}
+	// Only fifos can be opened by runsc, not the binary and file URIs of
+	// the v2 shims.
+	if filepath.IsAbs(r.Stdout) {
+		return r.Stdout
+	}
+	return ""
+}
+
+// createUserLog returns the user log of the sandbox created with r, after
+// creating the directory of UserLog, which may be named after the
+// container.
+func (p *Init) createUserLog(r *CreateConfig) (string, error) {
+	path := p.userLog(r)
+	if path == "" || path != p.UserLog {
+		return path, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", errors.Wrapf(err, "failed to create directory of user log %s", path)
+	}
+	return path, nil
+}
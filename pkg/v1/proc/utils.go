@@ -24,6 +24,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/containerd/containerd/runtime/proc"
+
 	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
 )
 
@@ -32,10 +34,6 @@ const (
 	bufferSize        = 32
 )
 
-// ExitCh is the exit events channel for containers and exec processes
-// inside the sandbox.
-var ExitCh = make(chan Exit, bufferSize)
-
 // TODO(random-liu): This can be a utility.
 
 // TODO(mlaventure): move to runc package?
@@ -89,6 +87,8 @@ func copyFile(to, from string) error {
 	return err
 }
 
-func hasNoIO(r *CreateConfig) bool {
-	return r.Stdin == "" && r.Stdout == "" && r.Stderr == ""
+// hasNoIO returns whether the process has no io copied by the shim. A
+// stdin file is given to the process directly.
+func hasNoIO(stdio proc.Stdio) bool {
+	return stdinFifo(stdio.Stdin) == "" && stdio.Stdout == "" && stdio.Stderr == ""
 }
@@ -0,0 +1,73 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package proc
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
+)
+
+// verifiedBinary is a runtime binary that identified itself as runsc.
+type verifiedBinary struct {
+	// mtime is the modification time the binary was checked at.
+	mtime   time.Time
+	version string
+}
+
+// verified caches the verified runtime binaries, keyed by path.
+var verified = struct {
+	sync.Mutex
+	binaries map[string]verifiedBinary
+}{binaries: make(map[string]verifiedBinary)}
+
+// VerifyRuntime checks that the runtime binary of r is runsc, using the
+// fingerprint of its --version output, and returns its version. A binary is
+// checked again when it changes on disk. ErrNotRunsc is returned for other
+// binaries.
+func VerifyRuntime(ctx context.Context, r *runsc.Runsc) (string, error) {
+	command := r.Command
+	if command == "" {
+		command = runsc.DefaultCommand
+	}
+	path, err := exec.LookPath(command)
+	if err != nil {
+		return "", err
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	verified.Lock()
+	b, ok := verified.binaries[path]
+	verified.Unlock()
+	if ok && b.mtime.Equal(st.ModTime()) {
+		return b.version, nil
+	}
+	version, err := r.Version(ctx)
+	if err != nil {
+		return "", err
+	}
+	verified.Lock()
+	verified.binaries[path] = verifiedBinary{mtime: st.ModTime(), version: version}
+	verified.Unlock()
+	return version, nil
+}
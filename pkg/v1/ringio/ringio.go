@@ -0,0 +1,321 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package ringio moves the output of a process through a ring buffer in
+// shared memory instead of a fifo, for workloads writing more than the 32KB
+// copies through fifos keep up with. The consumer, e.g. a containerd
+// logging binary, creates the ring with Create and marks it ready; the shim
+// opens it with Open and writes the output to it. Both sides wait on
+// futexes in the shared memory, so the ring needs no other channel.
+//
+// The ring is a file, e.g. under /dev/shm, laid out as:
+//
+//	0    magic uint32, version uint32, size uint64 (of the data, a power of 2)
+//	16   consumer pid uint32, ready uint32, producer pid uint32
+//	64   write offset uint64, write sequence uint32, closed uint32
+//	128  read offset uint64, read sequence uint32
+//	4096 data
+//
+// The offsets only grow; the data between the read and the write offsets,
+// modulo size, is pending. The producer bumps the write sequence after each
+// write and the consumer the read sequence after each read, waking the
+// other side.
+package ringio
+
+import (
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	magic   = 0x62727667 // "gvrb"
+	version = 1
+
+	offMagic       = 0
+	offVersion     = 4
+	offSize        = 8
+	offConsumerPid = 16
+	offReady       = 20
+	offProducerPid = 24
+	offWrite       = 64
+	offWriteSeq    = 72
+	offClosed      = 76
+	offRead        = 128
+	offReadSeq     = 136
+
+	// headerSize is the offset of the data.
+	headerSize = 4096
+)
+
+// waitInterval bounds each futex wait, after which the other side is
+// checked for liveness.
+const waitInterval = 100 * time.Millisecond
+
+// openPollInterval is how often Open checks for the ring to be ready.
+const openPollInterval = 10 * time.Millisecond
+
+// ErrIncompatible is returned by Open for a file that isn't a ring of a
+// version the shim implements.
+var ErrIncompatible = errors.New("incompatible ring buffer")
+
+// ring is the shared memory of a ring buffer.
+type ring struct {
+	f    *os.File
+	mem  []byte
+	data []byte
+	size uint64
+}
+
+func (r *ring) u32(off int) *uint32 {
+	return (*uint32)(unsafe.Pointer(&r.mem[off]))
+}
+
+func (r *ring) u64(off int) *uint64 {
+	return (*uint64)(unsafe.Pointer(&r.mem[off]))
+}
+
+// mapRing maps the ring file f of size bytes of data.
+func mapRing(f *os.File, size uint64) (*ring, error) {
+	mem, err := unix.Mmap(int(f.Fd()), 0, headerSize+int(size), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to map ring buffer %s", f.Name())
+	}
+	return &ring{f: f, mem: mem, data: mem[headerSize:], size: size}, nil
+}
+
+func (r *ring) unmap() error {
+	err := unix.Munmap(r.mem)
+	if cerr := r.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// wait waits for the sequence at off to move from seq, or for
+// waitInterval.
+func (r *ring) wait(off int, seq uint32) {
+	ts := unix.NsecToTimespec(int64(waitInterval))
+	unix.Syscall6(unix.SYS_FUTEX, uintptr(unsafe.Pointer(r.u32(off))), futexWait, uintptr(seq), uintptr(unsafe.Pointer(&ts)), 0, 0)
+}
+
+// bump moves the sequence at off and wakes the other side.
+func (r *ring) bump(off int) {
+	atomic.AddUint32(r.u32(off), 1)
+	unix.Syscall6(unix.SYS_FUTEX, uintptr(unsafe.Pointer(r.u32(off))), futexWake, 1, 0, 0, 0)
+}
+
+// alive returns whether the process whose pid is at off is running.
+func (r *ring) alive(off int) bool {
+	pid := atomic.LoadUint32(r.u32(off))
+	return pid == 0 || unix.Kill(int(pid), 0) != unix.ESRCH
+}
+
+// The futex operations, shared between processes.
+const (
+	futexWait = 0
+	futexWake = 1
+)
+
+// Reader is the consumer end of a ring buffer.
+type Reader struct {
+	*ring
+}
+
+// Create creates the ring buffer file at path, with size bytes of data,
+// and marks it ready for the producer. size must be a power of two of at
+// least a page.
+func Create(path string, size int) (*Reader, error) {
+	if size < headerSize || size&(size-1) != 0 {
+		return nil, errors.Errorf("invalid ring buffer size %d, it must be a power of two of at least %d", size, headerSize)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(int64(headerSize + size)); err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	r, err := mapRing(f, uint64(size))
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	*r.u32(offVersion) = version
+	*r.u64(offSize) = uint64(size)
+	*r.u32(offConsumerPid) = uint32(os.Getpid())
+	atomic.StoreUint32(r.u32(offMagic), magic)
+	atomic.StoreUint32(r.u32(offReady), 1)
+	return &Reader{ring: r}, nil
+}
+
+// Read reads the pending data, waiting for some. It returns io.EOF once the
+// producer closed the ring, or exited, and the data was read.
+func (r *Reader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for {
+		seq := atomic.LoadUint32(r.u32(offWriteSeq))
+		closed := atomic.LoadUint32(r.u32(offClosed)) == 1
+		w, rd := atomic.LoadUint64(r.u64(offWrite)), atomic.LoadUint64(r.u64(offRead))
+		if w != rd {
+			n := int(w - rd)
+			if n > len(p) {
+				n = len(p)
+			}
+			start := rd & (r.size - 1)
+			c := copy(p[:n], r.data[start:])
+			copy(p[c:n], r.data)
+			atomic.StoreUint64(r.u64(offRead), rd+uint64(n))
+			r.bump(offReadSeq)
+			return n, nil
+		}
+		if closed || !r.alive(offProducerPid) {
+			return 0, io.EOF
+		}
+		r.wait(offWriteSeq, seq)
+	}
+}
+
+// Close unmaps the ring. The file is left for the caller to remove.
+func (r *Reader) Close() error {
+	return r.unmap()
+}
+
+// Writer is the producer end of a ring buffer. Writes are serialized, so
+// stdout and stderr may share a ring.
+type Writer struct {
+	*ring
+	mu     sync.Mutex
+	closed bool
+}
+
+// Open opens the ring buffer at path for writing, waiting up to timeout for
+// its consumer to create it and mark it ready. It returns ErrIncompatible
+// for a file that isn't a ring of this version, such as the fifo created by
+// a consumer without ring buffers.
+func Open(path string, timeout time.Duration) (*Writer, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		w, err := open(path)
+		if err == nil || errors.Cause(err) == ErrIncompatible || time.Now().After(deadline) {
+			return w, err
+		}
+		time.Sleep(openPollInterval)
+	}
+}
+
+// open opens the ring at path, failing if it isn't ready yet.
+func open(path string) (*Writer, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !st.Mode().IsRegular() {
+		return nil, errors.Wrapf(ErrIncompatible, "%s is not a regular file", path)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	var header [headerSize]byte
+	if _, err := io.ReadFull(f, header[:]); err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "failed to read header of ring buffer %s", path)
+	}
+	m := *(*uint32)(unsafe.Pointer(&header[offMagic]))
+	if m == 0 {
+		f.Close()
+		return nil, errors.Errorf("ring buffer %s is not initialized", path)
+	}
+	if m != magic || *(*uint32)(unsafe.Pointer(&header[offVersion])) != version {
+		f.Close()
+		return nil, errors.Wrapf(ErrIncompatible, "%s", path)
+	}
+	size := *(*uint64)(unsafe.Pointer(&header[offSize]))
+	if size < headerSize || size&(size-1) != 0 {
+		f.Close()
+		return nil, errors.Wrapf(ErrIncompatible, "%s has an invalid size %d", path, size)
+	}
+	r, err := mapRing(f, size)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if atomic.LoadUint32(r.u32(offReady)) != 1 {
+		r.unmap()
+		return nil, errors.Errorf("ring buffer %s is not ready", path)
+	}
+	atomic.StoreUint32(r.u32(offProducerPid), uint32(os.Getpid()))
+	return &Writer{ring: r}, nil
+}
+
+// Write writes p to the ring, waiting for the consumer to make room. It
+// fails with EPIPE once the consumer exited.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+	n := 0
+	for n < len(p) {
+		seq := atomic.LoadUint32(w.u32(offReadSeq))
+		wr, rd := atomic.LoadUint64(w.u64(offWrite)), atomic.LoadUint64(w.u64(offRead))
+		free := int(w.size - (wr - rd))
+		if free == 0 {
+			if !w.alive(offConsumerPid) {
+				return n, unix.EPIPE
+			}
+			w.wait(offReadSeq, seq)
+			continue
+		}
+		if free > len(p)-n {
+			free = len(p) - n
+		}
+		start := wr & (w.size - 1)
+		c := copy(w.data[start:], p[n:n+free])
+		copy(w.data, p[n+c:n+free])
+		atomic.StoreUint64(w.u64(offWrite), wr+uint64(free))
+		w.bump(offWriteSeq)
+		n += free
+	}
+	return n, nil
+}
+
+// Close marks the end of the output, which the consumer reads as io.EOF,
+// and unmaps the ring. Closing a closed Writer does nothing.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	atomic.StoreUint32(w.u32(offClosed), 1)
+	w.bump(offWriteSeq)
+	return w.unmap()
+}
@@ -0,0 +1,275 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ringio
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// newRing creates a ring of size bytes in a temporary directory and opens
+// its writer.
+func newRing(t *testing.T, size int) (*Reader, *Writer, string) {
+	dir, err := ioutil.TempDir("", "ringio-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "ring")
+	r, err := Create(path, size)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	w, err := Open(path, time.Second)
+	if err != nil {
+		r.Close()
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return r, w, dir
+}
+
+func TestCreateInvalidSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ringio-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, size := range []int{0, 1024, headerSize + 1, 3 * headerSize} {
+		if _, err := Create(filepath.Join(dir, fmt.Sprint(size)), size); err == nil {
+			t.Errorf("Create with size %d succeeded", size)
+		}
+	}
+}
+
+func TestWraparound(t *testing.T) {
+	r, w, dir := newRing(t, headerSize)
+	defer os.RemoveAll(dir)
+	defer r.Close()
+
+	// Odd write sizes end writes and reads at every offset of the data,
+	// wrapping around it many times.
+	var want bytes.Buffer
+	for i := 0; want.Len() < 64*headerSize; i++ {
+		want.Write(bytes.Repeat([]byte{byte(i)}, 1+i%(2*headerSize)))
+	}
+	go func() {
+		p := want.Bytes()
+		for i := 0; len(p) > 0; i++ {
+			n := 1 + (i*997)%(3*headerSize)
+			if n > len(p) {
+				n = len(p)
+			}
+			if _, err := w.Write(p[:n]); err != nil {
+				t.Errorf("write failed: %v", err)
+				break
+			}
+			p = p[n:]
+		}
+		w.Close()
+	}()
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want.Bytes()) {
+		t.Fatalf("read %d bytes that differ from the %d written", len(got), want.Len())
+	}
+}
+
+func TestConcurrentWriters(t *testing.T) {
+	r, w, dir := newRing(t, headerSize)
+	defer os.RemoveAll(dir)
+	defer r.Close()
+
+	const (
+		writers = 8
+		records = 500
+	)
+	// Each record is written with a single Write, and must not be
+	// interleaved with the records of other writers.
+	record := func(writer, i int) []byte {
+		return []byte(fmt.Sprintf("%d:%d:%s\n", writer, i, bytes.Repeat([]byte{'a' + byte(writer)}, i%700)))
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(writer int) {
+			defer wg.Done()
+			for i := 0; i < records; i++ {
+				if _, err := w.Write(record(writer, i)); err != nil {
+					t.Errorf("write failed: %v", err)
+					return
+				}
+			}
+		}(i)
+	}
+	go func() {
+		wg.Wait()
+		w.Close()
+	}()
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := make([]int, writers)
+	for _, line := range bytes.SplitAfter(got, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		var writer, i int
+		if _, err := fmt.Sscanf(string(line), "%d:%d:", &writer, &i); err != nil || writer < 0 || writer >= writers {
+			t.Fatalf("corrupted record %q", line)
+		}
+		if i != next[writer] || !bytes.Equal(line, record(writer, i)) {
+			t.Fatalf("record %d of writer %d is %q, expected record %d", i, writer, line, next[writer])
+		}
+		next[writer]++
+	}
+	for writer, n := range next {
+		if n != records {
+			t.Errorf("read %d records of writer %d, expected %d", n, writer, records)
+		}
+	}
+}
+
+func TestCloseDrains(t *testing.T) {
+	r, w, dir := newRing(t, headerSize)
+	defer os.RemoveAll(dir)
+	defer r.Close()
+
+	if _, err := w.Write([]byte("pending")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Errorf("second close failed: %v", err)
+	}
+	if _, err := w.Write([]byte("late")); err != os.ErrClosed {
+		t.Errorf("write after close returned %v, expected %v", err, os.ErrClosed)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "pending" {
+		t.Errorf("read %q after close, expected %q", got, "pending")
+	}
+}
+
+// deadPid returns the pid of a process that exited.
+func deadPid(t *testing.T) uint32 {
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("failed to run true: %v", err)
+	}
+	return uint32(cmd.Process.Pid)
+}
+
+func TestWriteConsumerExited(t *testing.T) {
+	r, w, dir := newRing(t, headerSize)
+	defer os.RemoveAll(dir)
+	defer r.Close()
+	defer w.Close()
+
+	atomic.StoreUint32(r.u32(offConsumerPid), deadPid(t))
+	done := make(chan error, 1)
+	go func() {
+		_, err := w.Write(make([]byte, 2*headerSize))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != unix.EPIPE {
+			t.Errorf("write to a ring of an exited consumer returned %v, expected EPIPE", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("write to a ring of an exited consumer didn't fail")
+	}
+}
+
+func TestReadProducerExited(t *testing.T) {
+	r, w, dir := newRing(t, headerSize)
+	defer os.RemoveAll(dir)
+	defer r.Close()
+	defer w.Close()
+
+	if _, err := w.Write([]byte("last")); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreUint32(r.u32(offProducerPid), deadPid(t))
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "last" {
+		t.Errorf("read %q, expected %q", got, "last")
+	}
+}
+
+func TestOpenIncompatible(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ringio-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, bytes.Repeat([]byte{0xff}, 2*headerSize), 0600); err != nil {
+		t.Fatal(err)
+	}
+	fifo := filepath.Join(dir, "fifo")
+	if err := syscall.Mkfifo(fifo, 0600); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{file, fifo} {
+		start := time.Now()
+		_, err := Open(path, 5*time.Second)
+		if errors.Cause(err) != ErrIncompatible {
+			t.Errorf("Open(%s) returned %v, expected %v", path, err, ErrIncompatible)
+		}
+		if d := time.Since(start); d > time.Second {
+			t.Errorf("Open(%s) waited %v for an incompatible file", path, d)
+		}
+	}
+}
+
+func TestOpenTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ringio-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := Open(filepath.Join(dir, "missing"), 50*time.Millisecond); err == nil {
+		t.Fatal("Open of a missing ring succeeded")
+	}
+}
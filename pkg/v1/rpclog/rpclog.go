@@ -0,0 +1,222 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package rpclog keeps the recent RPCs of a shim in a ring buffer, so that
+// when a shim wedges operators can see what it was doing without debug
+// logging. The buffer is dumped to a file in the work directory on SIGQUIT
+// and when an RPC panics, e.g.
+//
+//	2024-05-13T10:00:00.000000000Z  12.5s  Delete  c1  in-flight
+//
+// The panic of an RPC is recovered into an Internal error, rather than
+// killing the shim along with the io of its containers. Each RPC is also
+// logged at debug level with its duration.
+package rpclog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultSize is the number of RPCs kept by default.
+const DefaultSize = 128
+
+// DumpFile is the name of the file the RPCs are dumped to.
+const DumpFile = "rpcs.log"
+
+// Call is an RPC served by the shim.
+type Call struct {
+	Method string
+	// ID is the id of the process the RPC is about, if any.
+	ID      string
+	Started time.Time
+	// Ended is zero while the RPC is in flight.
+	Ended time.Time
+	// Result is "ok", the error returned or the panic of the RPC.
+	Result string
+
+	ring *Log
+}
+
+// Log is a ring buffer of the recent RPCs. A nil Log records nothing.
+type Log struct {
+	// dir is where the RPCs are dumped when an RPC panics.
+	dir string
+
+	mu    sync.Mutex
+	calls []*Call
+	next  int
+	// panics counts the RPCs that panicked.
+	panics uint64
+}
+
+// New returns a Log keeping the last size RPCs, DefaultSize if zero, and
+// dumping them to dir when an RPC panics.
+func New(size int, dir string) *Log {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	return &Log{dir: dir, calls: make([]*Call, 0, size)}
+}
+
+// Start records the start of an RPC. The returned call is ended with End.
+func (l *Log) Start(method, id string) *Call {
+	c := &Call{Method: method, ID: id, Started: time.Now(), ring: l}
+	if l == nil {
+		return c
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.calls) < cap(l.calls) {
+		l.calls = append(l.calls, c)
+	} else {
+		l.calls[l.next] = c
+		l.next = (l.next + 1) % len(l.calls)
+	}
+	return c
+}
+
+// End records the result of the RPC, err being the error it returns. It
+// must be deferred directly, e.g.
+//
+//	defer rpcs.Start("Create", r.ID).End(&err)
+//
+// so that a panic of the RPC is recovered too: the stack of the panic is
+// logged, the log is dumped to its directory, and the RPC returns an
+// Internal error. The panic resumes if err is nil.
+func (c *Call) End(err *error) {
+	r := recover()
+	l := c.ring
+	if l != nil {
+		l.mu.Lock()
+	}
+	c.Ended = time.Now()
+	switch {
+	case r != nil:
+		c.Result = fmt.Sprintf("panic: %v", r)
+	case err != nil && *err != nil:
+		c.Result = (*err).Error()
+	default:
+		c.Result = "ok"
+	}
+	if l != nil {
+		if r != nil {
+			l.panics++
+		}
+		l.mu.Unlock()
+	}
+	log.L.WithFields(logrus.Fields{
+		"method":   c.Method,
+		"id":       c.ID,
+		"duration": c.Ended.Sub(c.Started),
+		"result":   c.Result,
+	}).Debug("rpc")
+	if r != nil {
+		log.L.WithField("stack", string(debug.Stack())).Errorf("%s panicked: %v", c.Method, r)
+		if l != nil {
+			if path, err := l.Dump(l.dir); err != nil {
+				log.L.WithError(err).Error("Failed to dump rpcs")
+			} else {
+				log.L.Errorf("%s panicked, recent rpcs dumped to %s", c.Method, path)
+			}
+		}
+		if err == nil {
+			panic(r)
+		}
+		*err = status.Errorf(codes.Internal, "%s panicked: %v", c.Method, r)
+	}
+}
+
+// Panics returns the number of RPCs that panicked.
+func (l *Log) Panics() uint64 {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.panics
+}
+
+// Metrics returns the count of the RPCs that panicked in the Prometheus text
+// exposition format.
+func (l *Log) Metrics() []byte {
+	return []byte(fmt.Sprintf("# HELP gvisor_shim_rpc_panics_total RPCs of the shim that panicked.\n# TYPE gvisor_shim_rpc_panics_total counter\ngvisor_shim_rpc_panics_total %d\n", l.Panics()))
+}
+
+// Calls returns the recorded RPCs, oldest first.
+func (l *Log) Calls() []Call {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	out := make([]Call, 0, len(l.calls))
+	for i := range l.calls {
+		c := *l.calls[(l.next+i)%len(l.calls)]
+		c.ring = nil
+		out = append(out, c)
+	}
+	return out
+}
+
+// Write writes the recorded RPCs to w, one per line, oldest first: the
+// start time, the duration, the method, the process id and the result.
+func (l *Log) Write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	now := time.Now()
+	for _, c := range l.Calls() {
+		result := c.Result
+		end := c.Ended
+		if end.IsZero() {
+			result, end = "in-flight", now
+		}
+		fmt.Fprintf(tw, "%s\t%v\t%s\t%s\t%s\n", c.Started.UTC().Format(time.RFC3339Nano), end.Sub(c.Started).Round(time.Millisecond), c.Method, c.ID, result)
+	}
+	return tw.Flush()
+}
+
+// Dump writes the recorded RPCs to DumpFile in dir, replacing a previous
+// dump, and returns its path.
+func (l *Log) Dump(dir string) (string, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# rpcs of shim %d at %s\n", os.Getpid(), time.Now().UTC().Format(time.RFC3339Nano))
+	if err := l.Write(&buf); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, DumpFile)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runscapi
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/audit"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// Audit returns next validating the requests it is sent and recording the
+// calls changing the container in the shim log, as the task service of the
+// shims is, to be registered in place of next.
+func Audit(next Service) Service {
+	return &auditingService{Service: next}
+}
+
+// auditingService validates and audits the RPCs served by Service.
+type auditingService struct {
+	Service
+}
+
+func (s *auditingService) RunscState(ctx context.Context, r *runsctypes.StateRequest) (*runsctypes.State, error) {
+	if err := audit.CheckExecID(r.ExecID); err != nil {
+		return nil, err
+	}
+	return s.Service.RunscState(ctx, r)
+}
+
+func (s *auditingService) WaitAsync(ctx context.Context, r *runsctypes.WaitAsyncRequest) error {
+	if err := audit.CheckExecID(r.ExecID); err != nil {
+		return err
+	}
+	return s.Service.WaitAsync(ctx, r)
+}
+
+func (s *auditingService) StopSandbox(ctx context.Context, r *runsctypes.StopSandboxRequest) (err error) {
+	defer audit.Record(ctx, "StopSandbox", logrus.Fields{"timeout": r.Timeout}, &err)
+	return s.Service.StopSandbox(ctx, r)
+}
+
+func (s *auditingService) SignalSentry(ctx context.Context, r *runsctypes.SignalSentryRequest) (err error) {
+	defer audit.Record(ctx, "SignalSentry", logrus.Fields{"signal": r.Signal}, &err)
+	return s.Service.SignalSentry(ctx, r)
+}
+
+func (s *auditingService) PortForward(ctx context.Context, r *runsctypes.PortForwardRequest) (_ *runsctypes.PortForwarded, err error) {
+	defer audit.Record(ctx, "PortForward", logrus.Fields{"port": r.Port}, &err)
+	return s.Service.PortForward(ctx, r)
+}
+
+func (s *auditingService) Quiesce(ctx context.Context, r *runsctypes.QuiesceRequest) (_ *runsctypes.Quiesced, err error) {
+	defer audit.Record(ctx, "Quiesce", logrus.Fields{"timeout": r.Timeout}, &err)
+	return s.Service.Quiesce(ctx, r)
+}
+
+func (s *auditingService) Unquiesce(ctx context.Context) (err error) {
+	defer audit.Record(ctx, "Unquiesce", nil, &err)
+	return s.Service.Unquiesce(ctx)
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runscapi
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// recordingService records the calls that reach it. Methods not overridden
+// panic, the audit tests only send the ones below.
+type recordingService struct {
+	Service
+	calls []string
+}
+
+func (s *recordingService) RunscState(ctx context.Context, r *runsctypes.StateRequest) (*runsctypes.State, error) {
+	s.calls = append(s.calls, "RunscState")
+	return &runsctypes.State{}, nil
+}
+
+func (s *recordingService) Quiesce(ctx context.Context, r *runsctypes.QuiesceRequest) (*runsctypes.Quiesced, error) {
+	s.calls = append(s.calls, "Quiesce")
+	return &runsctypes.Quiesced{}, nil
+}
+
+func (s *recordingService) StopSandbox(ctx context.Context, r *runsctypes.StopSandboxRequest) error {
+	s.calls = append(s.calls, "StopSandbox")
+	return nil
+}
+
+func (s *recordingService) SignalSentry(ctx context.Context, r *runsctypes.SignalSentryRequest) error {
+	s.calls = append(s.calls, "SignalSentry")
+	return nil
+}
+
+func (s *recordingService) WaitAsync(ctx context.Context, r *runsctypes.WaitAsyncRequest) error {
+	s.calls = append(s.calls, "WaitAsync")
+	return nil
+}
+
+func (s *recordingService) PortForward(ctx context.Context, r *runsctypes.PortForwardRequest) (*runsctypes.PortForwarded, error) {
+	s.calls = append(s.calls, "PortForward")
+	return &runsctypes.PortForwarded{}, nil
+}
+
+func TestAudit(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		call  func(context.Context, Service) error
+		valid bool
+	}{
+		{
+			name: "state of the container",
+			call: func(ctx context.Context, s Service) error {
+				_, err := s.RunscState(ctx, &runsctypes.StateRequest{})
+				return err
+			},
+			valid: true,
+		},
+		{
+			name: "state of an exec",
+			call: func(ctx context.Context, s Service) error {
+				_, err := s.RunscState(ctx, &runsctypes.StateRequest{ExecID: "e1"})
+				return err
+			},
+			valid: true,
+		},
+		{
+			name: "state with invalid exec id",
+			call: func(ctx context.Context, s Service) error {
+				_, err := s.RunscState(ctx, &runsctypes.StateRequest{ExecID: "../e1"})
+				return err
+			},
+		},
+		{
+			name: "wait for an exec",
+			call: func(ctx context.Context, s Service) error {
+				return s.WaitAsync(ctx, &runsctypes.WaitAsyncRequest{ExecID: "e1", Token: "t1"})
+			},
+			valid: true,
+		},
+		{
+			name: "wait with invalid exec id",
+			call: func(ctx context.Context, s Service) error {
+				return s.WaitAsync(ctx, &runsctypes.WaitAsyncRequest{ExecID: "../e1"})
+			},
+		},
+		{
+			name: "stop sandbox",
+			call: func(ctx context.Context, s Service) error {
+				return s.StopSandbox(ctx, &runsctypes.StopSandboxRequest{})
+			},
+			valid: true,
+		},
+		{
+			name: "signal sentry",
+			call: func(ctx context.Context, s Service) error {
+				return s.SignalSentry(ctx, &runsctypes.SignalSentryRequest{Signal: 10})
+			},
+			valid: true,
+		},
+		{
+			name: "port forward",
+			call: func(ctx context.Context, s Service) error {
+				_, err := s.PortForward(ctx, &runsctypes.PortForwardRequest{Port: 8080})
+				return err
+			},
+			valid: true,
+		},
+		{
+			name: "quiesce",
+			call: func(ctx context.Context, s Service) error {
+				_, err := s.Quiesce(ctx, &runsctypes.QuiesceRequest{})
+				return err
+			},
+			valid: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			next := &recordingService{}
+			err := tc.call(context.Background(), Audit(next))
+			if tc.valid {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(next.calls) != 1 {
+					t.Fatalf("calls reaching the service: got %v, want one", next.calls)
+				}
+				return
+			}
+			if !errdefs.IsInvalidArgument(err) {
+				t.Fatalf("got %v, want an invalid argument error", err)
+			}
+			if len(next.calls) != 0 {
+				t.Fatalf("rejected request reached the service: %v", next.calls)
+			}
+		})
+	}
+}
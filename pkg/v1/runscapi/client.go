@@ -0,0 +1,139 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runscapi
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/containerd/ttrpc"
+	"github.com/containerd/typeurl"
+	ptypes "github.com/gogo/protobuf/types"
+	"github.com/pkg/errors"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+	"github.com/google/gvisor-containerd-shim/pkg/version"
+)
+
+// Client is a client of the runsc service. Errors are returned as sent by
+// the shim, in gRPC form.
+type Client struct {
+	client *ttrpc.Client
+}
+
+// NewClient returns a client of the runsc service served on the connection
+// of client.
+func NewClient(client *ttrpc.Client) *Client {
+	return &Client{client: client}
+}
+
+// Version calls the Version RPC.
+func (c *Client) Version(ctx context.Context) (*version.Info, error) {
+	var info version.Info
+	if err := c.call(ctx, "Version", nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// State calls the State RPC.
+func (c *Client) State(ctx context.Context, req *runsctypes.StateRequest) (*runsctypes.State, error) {
+	var state runsctypes.State
+	if err := c.call(ctx, "State", req, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// Pids calls the Pids RPC.
+func (c *Client) Pids(ctx context.Context, req *runsctypes.PidsRequest) (*runsctypes.ProcessList, error) {
+	var list runsctypes.ProcessList
+	if err := c.call(ctx, "Pids", req, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// Config calls the Config RPC.
+func (c *Client) Config(ctx context.Context) (*runsctypes.RunscConfig, error) {
+	var config runsctypes.RunscConfig
+	if err := c.call(ctx, "Config", nil, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// WaitAsync calls the WaitAsync RPC.
+func (c *Client) WaitAsync(ctx context.Context, req *runsctypes.WaitAsyncRequest) error {
+	return c.call(ctx, "WaitAsync", req, nil)
+}
+
+// StopSandbox calls the StopSandbox RPC.
+func (c *Client) StopSandbox(ctx context.Context, req *runsctypes.StopSandboxRequest) error {
+	return c.call(ctx, "StopSandbox", req, nil)
+}
+
+// SignalSentry calls the SignalSentry RPC.
+func (c *Client) SignalSentry(ctx context.Context, req *runsctypes.SignalSentryRequest) error {
+	return c.call(ctx, "SignalSentry", req, nil)
+}
+
+// PortForward calls the PortForward RPC.
+func (c *Client) PortForward(ctx context.Context, req *runsctypes.PortForwardRequest) (*runsctypes.PortForwarded, error) {
+	var pf runsctypes.PortForwarded
+	if err := c.call(ctx, "PortForward", req, &pf); err != nil {
+		return nil, err
+	}
+	return &pf, nil
+}
+
+// Quiesce calls the Quiesce RPC.
+func (c *Client) Quiesce(ctx context.Context, req *runsctypes.QuiesceRequest) (*runsctypes.Quiesced, error) {
+	var quiesced runsctypes.Quiesced
+	if err := c.call(ctx, "Quiesce", req, &quiesced); err != nil {
+		return nil, err
+	}
+	return &quiesced, nil
+}
+
+// Unquiesce calls the Unquiesce RPC.
+func (c *Client) Unquiesce(ctx context.Context) error {
+	return c.call(ctx, "Unquiesce", nil, nil)
+}
+
+// call calls method with req, nil for none, and decodes its response into
+// resp, nil to ignore it.
+func (c *Client) call(ctx context.Context, method string, req, resp interface{}) error {
+	in := &ptypes.Any{}
+	if req != nil {
+		var err error
+		if in, err = typeurl.MarshalAny(req); err != nil {
+			return err
+		}
+	}
+	var out ptypes.Any
+	if err := c.client.Call(ctx, ServiceName, method, in, &out); err != nil {
+		return err
+	}
+	if resp == nil {
+		return nil
+	}
+	if !typeurl.Is(&out, resp) {
+		return errors.Errorf("unexpected %s response type %s", method, out.TypeUrl)
+	}
+	return json.Unmarshal(out.Value, resp)
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package runscapi is the ttrpc service of the runsc specific RPCs of the
+// shims, for what the containerd shim APIs have no room for. The v1 shim
+// serves it on its shim socket, next to the shim API, and the v2 shim on the
+// address of its task socket followed by SocketSuffix.
+//
+// Requests and responses are protobuf Anys holding runsctypes, so that RPCs
+// can be added without generated code.
+package runscapi
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/ttrpc"
+	"github.com/containerd/typeurl"
+	ptypes "github.com/gogo/protobuf/types"
+	"github.com/pkg/errors"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+	"github.com/google/gvisor-containerd-shim/pkg/version"
+)
+
+// ServiceName is the ttrpc name of the runsc service.
+const ServiceName = "io.containerd.runsc.v1.Runsc"
+
+// SocketSuffix is appended to the task socket address of the v2 shim to get
+// the address of its runsc service.
+const SocketSuffix = ".runsc"
+
+func init() {
+	typeurl.Register(&version.Info{}, "io.containerd.runsc.v1", "Version")
+}
+
+// Service is the runsc service implemented by the shims.
+type Service interface {
+	// Version returns the build of the shim and the runsc releases it is
+	// tested with.
+	Version(ctx context.Context) (*version.Info, error)
+	// RunscState returns the runsc specific state of a process, which the
+	// State RPC of the task API has no room for.
+	RunscState(ctx context.Context, req *runsctypes.StateRequest) (*runsctypes.State, error)
+	// RunscPids lists the processes of the container with their runsc
+	// details, which the task API infos don't carry.
+	RunscPids(ctx context.Context, req *runsctypes.PidsRequest) (*runsctypes.ProcessList, error)
+	// RunscConfig returns the runsc configuration the container was
+	// created with.
+	RunscConfig(ctx context.Context) (*runsctypes.RunscConfig, error)
+	// WaitAsync returns right away, and publishes a WaitResult event
+	// carrying the token of req once the process of req exits.
+	WaitAsync(ctx context.Context, req *runsctypes.WaitAsyncRequest) error
+	// StopSandbox stops the pause container of a sandbox, and with it the
+	// containers of the pod.
+	StopSandbox(ctx context.Context, req *runsctypes.StopSandboxRequest) error
+	// SignalSentry sends the debug signal of req to the sentry of the
+	// container.
+	SignalSentry(ctx context.Context, req *runsctypes.SignalSentryRequest) error
+	// PortForward opens a connection to the port of req in the container,
+	// served with ServeStream.
+	PortForward(ctx context.Context, req *runsctypes.PortForwardRequest) (*runsctypes.PortForwarded, error)
+	// Quiesce freezes the container for a backup of its volumes, until
+	// Unquiesce or until the timeout of req expires.
+	Quiesce(ctx context.Context, req *runsctypes.QuiesceRequest) (*runsctypes.Quiesced, error)
+	// Unquiesce resumes the container frozen by Quiesce.
+	Unquiesce(ctx context.Context) error
+}
+
+// Register registers s as the runsc service of server.
+func Register(server *ttrpc.Server, s Service) {
+	server.Register(ServiceName, map[string]ttrpc.Method{
+		"Version": method(func(ctx context.Context, req *ptypes.Any) (interface{}, error) {
+			return s.Version(ctx)
+		}),
+		"State": method(func(ctx context.Context, req *ptypes.Any) (interface{}, error) {
+			var r runsctypes.StateRequest
+			if err := unmarshalRequest(req, &r); err != nil {
+				return nil, err
+			}
+			return s.RunscState(ctx, &r)
+		}),
+		"Pids": method(func(ctx context.Context, req *ptypes.Any) (interface{}, error) {
+			var r runsctypes.PidsRequest
+			if err := unmarshalRequest(req, &r); err != nil {
+				return nil, err
+			}
+			return s.RunscPids(ctx, &r)
+		}),
+		"Config": method(func(ctx context.Context, req *ptypes.Any) (interface{}, error) {
+			return s.RunscConfig(ctx)
+		}),
+		"WaitAsync": method(func(ctx context.Context, req *ptypes.Any) (interface{}, error) {
+			var r runsctypes.WaitAsyncRequest
+			if err := unmarshalRequest(req, &r); err != nil {
+				return nil, err
+			}
+			return nil, s.WaitAsync(ctx, &r)
+		}),
+		"StopSandbox": method(func(ctx context.Context, req *ptypes.Any) (interface{}, error) {
+			var r runsctypes.StopSandboxRequest
+			if err := unmarshalRequest(req, &r); err != nil {
+				return nil, err
+			}
+			return nil, s.StopSandbox(ctx, &r)
+		}),
+		"SignalSentry": method(func(ctx context.Context, req *ptypes.Any) (interface{}, error) {
+			var r runsctypes.SignalSentryRequest
+			if err := unmarshalRequest(req, &r); err != nil {
+				return nil, err
+			}
+			return nil, s.SignalSentry(ctx, &r)
+		}),
+		"PortForward": method(func(ctx context.Context, req *ptypes.Any) (interface{}, error) {
+			var r runsctypes.PortForwardRequest
+			if err := unmarshalRequest(req, &r); err != nil {
+				return nil, err
+			}
+			return s.PortForward(ctx, &r)
+		}),
+		"Quiesce": method(func(ctx context.Context, req *ptypes.Any) (interface{}, error) {
+			var r runsctypes.QuiesceRequest
+			if err := unmarshalRequest(req, &r); err != nil {
+				return nil, err
+			}
+			return s.Quiesce(ctx, &r)
+		}),
+		"Unquiesce": method(func(ctx context.Context, req *ptypes.Any) (interface{}, error) {
+			return nil, s.Unquiesce(ctx)
+		}),
+	})
+}
+
+// unmarshalRequest decodes req into v, leaving v empty for empty requests.
+func unmarshalRequest(req *ptypes.Any, v interface{}) error {
+	if req.TypeUrl == "" {
+		return nil
+	}
+	if !typeurl.Is(req, v) {
+		return errors.Wrapf(errdefs.ErrInvalidArgument, "unexpected request type %s", req.TypeUrl)
+	}
+	return json.Unmarshal(req.Value, v)
+}
+
+// method adapts fn to a ttrpc method taking and returning an Any.
+func method(fn func(context.Context, *ptypes.Any) (interface{}, error)) ttrpc.Method {
+	return func(ctx context.Context, unmarshal func(interface{}) error) (interface{}, error) {
+		var req ptypes.Any
+		if err := unmarshal(&req); err != nil {
+			return nil, err
+		}
+		resp, err := fn(ctx, &req)
+		if err != nil {
+			return nil, errdefs.ToGRPC(err)
+		}
+		if resp == nil {
+			return &ptypes.Any{}, nil
+		}
+		any, err := typeurl.MarshalAny(resp)
+		if err != nil {
+			return nil, errdefs.ToGRPC(errors.Wrap(err, "failed to marshal response"))
+		}
+		return any, nil
+	}
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runscapi
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	"github.com/pkg/errors"
+)
+
+// StreamAcceptTimeout is how long a stream served by ServeStream waits for
+// the client of the RPC to connect.
+const StreamAcceptTimeout = 10 * time.Second
+
+// ServeStream serves stream on a one-shot unix socket, and returns its
+// address for the client of an RPC to dial: the vendored ttrpc has no
+// streams. The socket is in a directory only its owner can open, accepts a
+// single connection, and is removed once it is accepted or after
+// StreamAcceptTimeout. stream is spliced to the connection until either end
+// closes it, and is closed with it.
+func ServeStream(ctx context.Context, stream io.ReadWriteCloser) (string, error) {
+	dir, err := ioutil.TempDir("", "runsc-stream")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create stream directory")
+	}
+	address := filepath.Join(dir, "stream.sock")
+	l, err := net.Listen("unix", address)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", errors.Wrap(err, "failed to listen for stream")
+	}
+	l.(*net.UnixListener).SetDeadline(time.Now().Add(StreamAcceptTimeout))
+	logger := log.G(ctx)
+	go func() {
+		defer stream.Close()
+		conn, err := l.Accept()
+		l.Close()
+		os.RemoveAll(dir)
+		if err != nil {
+			logger.WithError(err).Warn("stream not connected")
+			return
+		}
+		defer conn.Close()
+		go func() {
+			io.Copy(stream, conn)
+			// Pass the end of the request on, the response may follow.
+			if cw, ok := stream.(interface{ CloseWrite() error }); ok {
+				cw.CloseWrite()
+			} else {
+				stream.Close()
+			}
+		}()
+		io.Copy(conn, stream)
+	}()
+	return address, nil
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runscapi
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServeStream(t *testing.T) {
+	stream, port := net.Pipe()
+	defer port.Close()
+	address, err := ServeStream(context.Background(), stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("unix", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	go conn.Write([]byte("ping"))
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(port, b); err != nil || string(b) != "ping" {
+		t.Fatalf("read from the stream: got %q, %v, want %q", b, err, "ping")
+	}
+	go port.Write([]byte("pong"))
+	if _, err := io.ReadFull(conn, b); err != nil || string(b) != "pong" {
+		t.Fatalf("read from the connection: got %q, %v, want %q", b, err, "pong")
+	}
+
+	if _, err := os.Stat(filepath.Dir(address)); !os.IsNotExist(err) {
+		t.Fatalf("stream directory left after the connection was accepted: %v", err)
+	}
+	if c, err := net.Dial("unix", address); err == nil {
+		c.Close()
+		t.Fatal("stream accepted a second connection")
+	}
+
+	// Closing the connection closes the stream.
+	conn.Close()
+	if _, err := port.Read(b); err != io.EOF {
+		t.Fatalf("read from the closed stream: got %v, want EOF", err)
+	}
+}
@@ -0,0 +1,635 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package runsctypes contains gVisor specific types that are exchanged with
+// containerd, either as events or as typed extensions of API responses.
+// Types are registered with typeurl and encoded as JSON.
+package runsctypes
+
+import (
+	"time"
+
+	"github.com/containerd/typeurl"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// typePrefix is the typeurl prefix of all runsc types.
+const typePrefix = "io.containerd.runsc.v1"
+
+const (
+	// MemoryThresholdEventTopic for sandbox memory threshold crossings.
+	MemoryThresholdEventTopic = "/tasks/runsc/memory-threshold"
+	// RuntimeMismatchEventTopic for runtime binaries that are not runsc.
+	RuntimeMismatchEventTopic = "/tasks/runsc/runtime-mismatch"
+	// ArtifactsRemovedEventTopic for files removed when a container is
+	// deleted.
+	ArtifactsRemovedEventTopic = "/tasks/runsc/artifacts-removed"
+	// SeccompRewrittenEventTopic for seccomp profiles rewritten for gVisor.
+	SeccompRewrittenEventTopic = "/tasks/runsc/seccomp-rewritten"
+	// WatchdogKillEventTopic for sandboxes killed by the resource watchdog.
+	WatchdogKillEventTopic = "/tasks/runsc/watchdog-kill"
+	// CrashReportEventTopic for sandboxes that crashed.
+	CrashReportEventTopic = "/tasks/runsc/crash"
+	// DryRunEventTopic for containers created in dry run mode.
+	DryRunEventTopic = "/tasks/runsc/dry-run"
+	// IOClosedEventTopic for processes whose output is fully copied. It is
+	// only streamed to local subscribers.
+	IOClosedEventTopic = "/tasks/runsc/io-closed"
+	// GoferExitedEventTopic for gofers that exit while their sandbox runs.
+	// It is only streamed to local subscribers.
+	GoferExitedEventTopic = "/tasks/runsc/gofer-exited"
+	// WaitResultEventTopic for the exits of processes waited for with
+	// WaitAsync.
+	WaitResultEventTopic = "/tasks/runsc/wait-result"
+	// StartLatencyEventTopic for the creation and start times of started
+	// containers.
+	StartLatencyEventTopic = "/tasks/runsc/start-latency"
+	// ExitDetailsEventTopic for how processes ended, following their
+	// TaskExit.
+	ExitDetailsEventTopic = "/tasks/runsc/exit-details"
+	// DeleteDetailsEventTopic for why deleted processes exited, following
+	// their TaskDelete.
+	DeleteDetailsEventTopic = "/tasks/runsc/delete-details"
+	// StateChangedEventTopic for the state changes of processes. It is
+	// only streamed to local subscribers.
+	StateChangedEventTopic = "/tasks/runsc/state-changed"
+	// UnsupportedSyscallsEventTopic for the syscalls the application
+	// attempted that the sandbox doesn't implement.
+	UnsupportedSyscallsEventTopic = "/tasks/runsc/unsupported-syscalls"
+)
+
+func init() {
+	typeurl.Register(&MemoryThreshold{}, typePrefix, "MemoryThreshold")
+	typeurl.Register(&SandboxInfo{}, typePrefix, "SandboxInfo")
+	typeurl.Register(&IOStats{}, typePrefix, "IOStats")
+	typeurl.Register(&RuntimeMismatch{}, typePrefix, "RuntimeMismatch")
+	typeurl.Register(&ArtifactsRemoved{}, typePrefix, "ArtifactsRemoved")
+	typeurl.Register(&SandboxStatus{}, typePrefix, "SandboxStatus")
+	typeurl.Register(&SeccompRewritten{}, typePrefix, "SeccompRewritten")
+	typeurl.Register(&WatchdogKill{}, typePrefix, "WatchdogKill")
+	typeurl.Register(&CrashReport{}, typePrefix, "CrashReport")
+	typeurl.Register(&ProcessDetails{}, typePrefix, "ProcessDetails")
+	typeurl.Register(&ProcessList{}, typePrefix, "ProcessList")
+	typeurl.Register(&PidsRequest{}, typePrefix, "PidsRequest")
+	typeurl.Register(&StateRequest{}, typePrefix, "StateRequest")
+	typeurl.Register(&State{}, typePrefix, "State")
+	typeurl.Register(&RunscConfig{}, typePrefix, "RunscConfig")
+	typeurl.Register(&StopSandboxRequest{}, typePrefix, "StopSandboxRequest")
+	typeurl.Register(&SignalSentryRequest{}, typePrefix, "SignalSentryRequest")
+	typeurl.Register(&WaitAsyncRequest{}, typePrefix, "WaitAsyncRequest")
+	typeurl.Register(&PortForwardRequest{}, typePrefix, "PortForwardRequest")
+	typeurl.Register(&PortForwarded{}, typePrefix, "PortForwarded")
+	typeurl.Register(&QuiesceRequest{}, typePrefix, "QuiesceRequest")
+	typeurl.Register(&Quiesced{}, typePrefix, "Quiesced")
+	typeurl.Register(&DryRun{}, typePrefix, "DryRun")
+	typeurl.Register(&IOClosed{}, typePrefix, "IOClosed")
+	typeurl.Register(&GoferExited{}, typePrefix, "GoferExited")
+	typeurl.Register(&WaitResult{}, typePrefix, "WaitResult")
+	typeurl.Register(&UpdateMounts{}, typePrefix, "UpdateMounts")
+	typeurl.Register(&StartLatency{}, typePrefix, "StartLatency")
+	typeurl.Register(&StateChanged{}, typePrefix, "StateChanged")
+	typeurl.Register(&ExecSpec{}, typePrefix, "ExecSpec")
+	typeurl.Register(&ExitDetails{}, typePrefix, "ExitDetails")
+	typeurl.Register(&DeleteDetails{}, typePrefix, "DeleteDetails")
+	typeurl.Register(&UnsupportedSyscalls{}, typePrefix, "UnsupportedSyscalls")
+}
+
+// MemoryThreshold is published when the sandbox memory usage crosses the
+// configured fraction of its limit, in either direction.
+type MemoryThreshold struct {
+	ContainerID string    `json:"container_id"`
+	Usage       uint64    `json:"usage"`
+	Limit       uint64    `json:"limit"`
+	Threshold   float64   `json:"threshold"`
+	Exceeded    bool      `json:"exceeded"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// SandboxInfo describes how a container is sandboxed by gVisor.
+type SandboxInfo struct {
+	ContainerID string `json:"container_id"`
+	// SandboxID is the id of the sandbox the container runs in.
+	SandboxID string `json:"sandbox_id"`
+	// Platform is the runsc platform in use, e.g. "ptrace" or "kvm".
+	Platform string `json:"platform"`
+	// Network is the runsc network mode, e.g. "sandbox" or "host".
+	Network string `json:"network"`
+	// Overlay is true if the root filesystem is backed by an overlay.
+	Overlay bool `json:"overlay"`
+	// ShmSize is the size in bytes of the /dev/shm tmpfs of the
+	// container, zero if it isn't a sized tmpfs.
+	ShmSize int64 `json:"shm_size,omitempty"`
+	// Version is the runsc version reported by the runsc binary.
+	Version string `json:"version"`
+	// SandboxPid is the pid of the sandbox process on the host.
+	SandboxPid int `json:"sandbox_pid"`
+	// GoferPids are the pids of the gofer processes serving the sandbox.
+	GoferPids []int `json:"gofer_pids,omitempty"`
+	// OOMScoreAdj is the OOM score adjustment set on the sandbox and gofer
+	// processes, nil if they kept the one of the shim.
+	OOMScoreAdj *int `json:"oom_score_adj,omitempty"`
+	// CgroupMode is the cgroup hierarchy of the host: "v1", "hybrid" or
+	// "v2".
+	CgroupMode string `json:"cgroup_mode"`
+	// CgroupDriver is how runsc creates the cgroups of the sandbox,
+	// "cgroupfs" or "systemd".
+	CgroupDriver string `json:"cgroup_driver"`
+	// CgroupPath is the cgroup of the sandbox process in the unified
+	// hierarchy, empty on v1 hosts.
+	CgroupPath string `json:"cgroup_path,omitempty"`
+}
+
+// IOStats counts the bytes copied between a process and its io fifos.
+type IOStats struct {
+	ContainerID string `json:"container_id"`
+	// ExecID is the id of the exec process, empty for the init process.
+	ExecID string `json:"exec_id,omitempty"`
+	Stdin  uint64 `json:"stdin"`
+	Stdout uint64 `json:"stdout"`
+	Stderr uint64 `json:"stderr"`
+}
+
+// LeakedMounts reports the mounts left under the bundles of deleted
+// containers.
+type LeakedMounts struct {
+	// Mounts are the mount points still leaked, deepest first.
+	Mounts []string `json:"mounts,omitempty"`
+	// Detected counts the leaked mounts found on delete.
+	Detected uint64 `json:"detected"`
+	// Reclaimed counts the leaked mounts later unmounted.
+	Reclaimed uint64 `json:"reclaimed"`
+	// Attempts counts the unmounts of leaked mounts retried.
+	Attempts uint64 `json:"attempts"`
+}
+
+// RuntimeMismatch is published when the configured runtime binary doesn't
+// identify itself as runsc.
+type RuntimeMismatch struct {
+	ContainerID string `json:"container_id"`
+	// Runtime is the configured runtime binary.
+	Runtime string `json:"runtime"`
+	// Reason describes why the binary was not recognized.
+	Reason string `json:"reason"`
+	// Strict is true if Create was refused because of the mismatch.
+	Strict    bool      `json:"strict"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ArtifactsRemoved lists the files removed when a container was deleted. The
+// Delete response has no room for extensions, so they are published.
+type ArtifactsRemoved struct {
+	ContainerID string    `json:"container_id"`
+	Paths       []string  `json:"paths"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// SeccompRewritten is published when the seccomp profile of a container was
+// rewritten into one the sentry implements.
+type SeccompRewritten struct {
+	ContainerID string `json:"container_id"`
+	// Changes describe each field that was rewritten or removed.
+	Changes   []string  `json:"changes"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// WatchdogKill is published when the resource watchdog killed a sandbox that
+// exceeded a hard cap for longer than the grace period.
+type WatchdogKill struct {
+	ContainerID string `json:"container_id"`
+	// Resource is the capped resource, "memory" or "pids".
+	Resource      string    `json:"resource"`
+	Usage         uint64    `json:"usage"`
+	Limit         uint64    `json:"limit"`
+	ExceededSince time.Time `json:"exceeded_since"`
+	// ExitStatus is the exit status reported in the TaskExit event.
+	ExitStatus uint32    `json:"exit_status"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// CrashReport is published after the TaskExit of a sandbox that crashed. The
+// TaskExit event has no room for extensions, so the report follows it.
+type CrashReport struct {
+	ContainerID string `json:"container_id"`
+	ExitStatus  uint32 `json:"exit_status"`
+	// Log is the runsc debug log showing the crash, if any.
+	Log string `json:"log,omitempty"`
+	// Excerpt is the beginning of the crash report in Log, truncated to
+	// 4KiB.
+	Excerpt string `json:"excerpt,omitempty"`
+	// Copy is the copy of Log collected in the work directory, if enabled.
+	Copy      string    `json:"copy,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ExitDetails is published after each TaskExit with how the process ended.
+// The exit status follows the shell convention, 128+n for a process killed
+// by signal n, which the TaskExit event has no room to tell from a process
+// that exited with the same status.
+type ExitDetails struct {
+	ContainerID string `json:"container_id"`
+	ExecID      string `json:"exec_id,omitempty"`
+	Pid         uint32 `json:"pid"`
+	ExitStatus  uint32 `json:"exit_status"`
+	// Signaled is set for processes killed by Signal, named SignalName,
+	// e.g. "SIGKILL". ExitCode is the status other processes exited with.
+	Signaled   bool      `json:"signaled"`
+	Signal     int       `json:"signal,omitempty"`
+	SignalName string    `json:"signal_name,omitempty"`
+	ExitCode   int       `json:"exit_code"`
+	ExitedAt   time.Time `json:"exited_at"`
+	// Reason is a machine-readable reason for the exit, one of the Reason
+	// constants, and Message describes it.
+	Reason  string `json:"reason"`
+	Message string `json:"message,omitempty"`
+}
+
+// DeleteDetails is published after each TaskDelete with the reason the
+// process exited for, which the TaskDelete event has no room for, so that
+// consumers of the deletes don't have to match them with ExitDetails.
+type DeleteDetails struct {
+	ContainerID string    `json:"container_id"`
+	ExecID      string    `json:"exec_id,omitempty"`
+	Pid         uint32    `json:"pid"`
+	ExitStatus  uint32    `json:"exit_status"`
+	ExitedAt    time.Time `json:"exited_at"`
+	// Reason is one of the Reason constants, and Message describes it.
+	Reason  string `json:"reason"`
+	Message string `json:"message,omitempty"`
+}
+
+// Reasons of ExitDetails and DeleteDetails. They follow the reasons kubelet reports for the
+// containers it terminates, with the gVisor failures added.
+const (
+	// ReasonCompleted is a process that exited with status 0.
+	ReasonCompleted = "Completed"
+	// ReasonError is a process that failed for another reason.
+	ReasonError = "Error"
+	// ReasonOOMKilled is a container that exited after running out of
+	// memory.
+	ReasonOOMKilled = "OOMKilled"
+	// ReasonWatchdogKilled is a sandbox killed by the resource watchdog.
+	ReasonWatchdogKilled = "GVisorWatchdogKilled"
+	// ReasonGoferCrashed is a sandbox that exited after one of its gofers
+	// died.
+	ReasonGoferCrashed = "GoferCrashed"
+	// ReasonSandboxCrashed is a sandbox whose sentry or runsc crashed.
+	ReasonSandboxCrashed = "GVisorSandboxCrashed"
+	// ReasonPlatformUnavailable is a sandbox that failed to set up its
+	// platform, e.g. without access to /dev/kvm.
+	ReasonPlatformUnavailable = "GVisorPlatformUnavailable"
+	// ReasonUnsupportedSyscallPolicy is a process killed with SIGSYS for a
+	// syscall its seccomp policy or gVisor doesn't allow.
+	ReasonUnsupportedSyscallPolicy = "UnsupportedSyscallPolicy"
+)
+
+// UnsupportedSyscalls summarizes the syscalls the application attempted
+// that gVisor doesn't implement, as logged by runsc between Since and Until.
+// It is published periodically while any are logged, so that users can tell
+// why an application misbehaves in the sandbox.
+type UnsupportedSyscalls struct {
+	ContainerID string           `json:"container_id"`
+	Syscalls    []SyscallAttempt `json:"syscalls"`
+	Since       time.Time        `json:"since"`
+	Until       time.Time        `json:"until"`
+}
+
+// SyscallAttempt counts the attempts of a syscall. Name is the name of the
+// syscall, or its number for syscalls unknown to gVisor.
+type SyscallAttempt struct {
+	Name  string `json:"name"`
+	Count uint64 `json:"count"`
+}
+
+// ProcessDetails describes a process listed by the Pids RPC of the runsc
+// service. InternalPid is the pid inside the sandbox, which ListPids lists.
+// ExecID is the process of the shim the listed process is, or descends
+// from. HostPid is the pid of the runsc process on the host that the task
+// API reports for a process of the shim. Threads are the thread ids of the
+// process, only listed on request.
+type ProcessDetails struct {
+	ExecID      string   `json:"exec_id,omitempty"`
+	HostPid     uint32   `json:"host_pid,omitempty"`
+	InternalPid uint32   `json:"internal_pid"`
+	ParentPid   uint32   `json:"parent_pid,omitempty"`
+	Command     string   `json:"command,omitempty"`
+	Threads     []uint32 `json:"threads,omitempty"`
+}
+
+// PidsRequest is the request of the Pids RPC of the runsc service. Threads
+// lists the threads of each process too, at the cost of an exec in the
+// sandbox.
+type PidsRequest struct {
+	Threads bool `json:"threads,omitempty"`
+}
+
+// ProcessList is the response of the Pids RPC of the runsc service.
+type ProcessList struct {
+	Processes []*ProcessDetails `json:"processes"`
+}
+
+// StateRequest is the request of the State RPC of the runsc service. An
+// empty ExecID selects the container itself.
+type StateRequest struct {
+	ExecID string `json:"exec_id,omitempty"`
+}
+
+// State is the runsc specific state of a process, served by the State RPC
+// of the runsc service as the extension of the State RPC of the task API.
+type State struct {
+	ID     string `json:"id"`
+	ExecID string `json:"exec_id,omitempty"`
+	// Pid is the host pid the task API reports.
+	Pid uint32 `json:"pid"`
+	// InternalPid is the pid of the process inside the sandbox, 0 while
+	// unknown.
+	InternalPid uint32 `json:"internal_pid,omitempty"`
+	// OOMScoreAdj is the OOM score adjustment set on the sandbox and gofer
+	// processes of the container, nil if they kept the one of the shim or
+	// for exec processes.
+	OOMScoreAdj *int `json:"oom_score_adj,omitempty"`
+	// ShmSize is the size in bytes of the /dev/shm tmpfs of the container,
+	// zero if it isn't a sized tmpfs or for exec processes.
+	ShmSize int64 `json:"shm_size,omitempty"`
+	// CgroupMode is the cgroup hierarchy of the host: "v1", "hybrid" or
+	// "v2". Empty for exec processes, like the fields below.
+	CgroupMode string `json:"cgroup_mode,omitempty"`
+	// CgroupDriver is how the cgroups of the container are created,
+	// "cgroupfs" or "systemd".
+	CgroupDriver string `json:"cgroup_driver,omitempty"`
+	// CgroupPath is the cgroup of the container in the unified hierarchy,
+	// empty on v1 hosts.
+	CgroupPath string `json:"cgroup_path,omitempty"`
+}
+
+// DryRun is the runsc invocation a container would have been created with.
+type DryRun struct {
+	ContainerID string `json:"container_id"`
+	// Command is the runsc create command line.
+	Command []string `json:"command"`
+	// Flags are the runsc flags after the pod annotations were applied.
+	Flags map[string]string `json:"flags,omitempty"`
+	// Mounts are the rootfs mounts.
+	Mounts []DryRunMount `json:"mounts,omitempty"`
+	// Spec is the OCI spec passed to runsc, after it was adapted for gVisor.
+	Spec      string    `json:"spec"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// DryRunMount is a rootfs mount of a dry run.
+type DryRunMount struct {
+	Type    string   `json:"type"`
+	Source  string   `json:"source"`
+	Target  string   `json:"target,omitempty"`
+	Options []string `json:"options,omitempty"`
+	// Command is the mount command line the shim would run.
+	Command string `json:"command,omitempty"`
+}
+
+// Sandbox states, named after the states of the containerd sandbox API.
+const (
+	SandboxReady    = "SANDBOX_READY"
+	SandboxNotReady = "SANDBOX_NOTREADY"
+)
+
+// SandboxStatus describes a pod sandbox, backed by its pause container.
+type SandboxStatus struct {
+	SandboxID string `json:"sandbox_id"`
+	// Pid is the pid of the pause container.
+	Pid int `json:"pid"`
+	// State is SandboxReady while the pause container runs.
+	State     string       `json:"state"`
+	Info      *SandboxInfo `json:"info,omitempty"`
+	CreatedAt time.Time    `json:"created_at"`
+	ExitedAt  time.Time    `json:"exited_at,omitempty"`
+}
+
+// StopSandboxRequest is the request of the StopSandbox RPC of the runsc
+// service. Timeout is how long the pause container is given to exit after
+// SIGTERM, zero kills everything in the sandbox right away.
+type StopSandboxRequest struct {
+	Timeout time.Duration `json:"timeout_ns,omitempty"`
+}
+
+// SignalSentryRequest is the request of the SignalSentry RPC of the runsc
+// service. Signal is the number of the debug signal, SIGUSR1 or SIGUSR2.
+type SignalSentryRequest struct {
+	Signal uint32 `json:"signal"`
+}
+
+// PortForwardRequest is the request of the PortForward RPC of the runsc
+// service, for the port forwards of the CRI.
+type PortForwardRequest struct {
+	Port int `json:"port"`
+}
+
+// PortForwarded is the response of the PortForward RPC. Address is the
+// unix socket to dial for the connection to the port, accepting a single
+// connection.
+type PortForwarded struct {
+	Address string `json:"address"`
+}
+
+// QuiesceRequest is the request of the Quiesce RPC of the runsc service.
+// A zero Timeout selects the default of the shim.
+type QuiesceRequest struct {
+	Timeout time.Duration `json:"timeout_ns,omitempty"`
+}
+
+// Quiesced describes a container frozen for a backup of its volumes.
+type Quiesced struct {
+	ContainerID string `json:"container_id"`
+	// Synced is set if the filesystems of the sandbox were synced before
+	// the container was paused, and SyncError is why they weren't.
+	Synced    bool   `json:"synced"`
+	SyncError string `json:"sync_error,omitempty"`
+	// QuiescedAt is when the container was paused, and ThawAt when it is
+	// resumed if Unquiesce isn't called before.
+	QuiescedAt time.Time `json:"quiesced_at"`
+	ThawAt     time.Time `json:"thaw_at"`
+}
+
+// Diagnostics describes how to attach debugging tools, such as dlv or gdb,
+// to the sandbox of a container.
+type Diagnostics struct {
+	ContainerID string `json:"container_id"`
+	SandboxID   string `json:"sandbox_id"`
+	// Platform is the runsc platform in use, e.g. "ptrace" or "kvm".
+	Platform string `json:"platform"`
+	// SandboxPid is the pid of the sandbox process on the host, and
+	// SentryPid the pid of the sentry, the runsc boot process.
+	SandboxPid int   `json:"sandbox_pid"`
+	SentryPid  int   `json:"sentry_pid"`
+	GoferPids  []int `json:"gofer_pids,omitempty"`
+	// PtraceScope is the Yama ptrace scope of the host, -1 if Yama isn't
+	// enabled. Debuggers can only attach to the sentry as root above 0.
+	PtraceScope int `json:"ptrace_scope"`
+	// StackDumps is set if runsc debug --stacks can dump the stacks of
+	// the sentry, and Profiling if runsc debug can profile it.
+	StackDumps bool `json:"stack_dumps"`
+	Profiling  bool `json:"profiling"`
+	// Hints are commands to attach tools to the sandbox.
+	Hints []string `json:"hints,omitempty"`
+}
+
+// RunscConfig is the runsc configuration a container was created with, to
+// compare what was requested with what actually ran.
+type RunscConfig struct {
+	ContainerID string `json:"container_id"`
+	SandboxID   string `json:"sandbox_id,omitempty"`
+	// Runtime is the binary the container was created with, and Root its
+	// state directory.
+	Runtime string `json:"runtime"`
+	Root    string `json:"root"`
+	// Requested are the runsc flags of the shim configuration, before the
+	// performance profile, file access, device, cgroup and admission
+	// settings of the container were applied.
+	Requested map[string]string `json:"requested"`
+	// Flags are the runsc flags the container was created with.
+	Flags map[string]string `json:"flags"`
+	// Changed are the flags added, changed or removed from the requested
+	// ones.
+	Changed []string `json:"changed,omitempty"`
+	// Command is the runsc create command line.
+	Command   []string  `json:"command"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// IOClosed is streamed once the stdout and stderr of a process are fully
+// copied, i.e. after the process and its children closed them.
+type IOClosed struct {
+	ContainerID string    `json:"container_id"`
+	ExecID      string    `json:"exec_id,omitempty"`
+	Stdout      uint64    `json:"stdout"`
+	Stderr      uint64    `json:"stderr"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// GoferExited is streamed when a gofer of the sandbox exits while the
+// sandbox is running, which breaks file access of its containers.
+type GoferExited struct {
+	ContainerID string    `json:"container_id"`
+	Pid         int       `json:"pid"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// WaitAsyncRequest is the request of the WaitAsync RPC of the runsc
+// service, for the process ExecID, the container itself if empty.
+type WaitAsyncRequest struct {
+	ExecID string `json:"exec_id,omitempty"`
+	Token  string `json:"token,omitempty"`
+}
+
+// WaitResult is published when a process waited for with WaitAsync exits,
+// after its TaskExit event. Token is the token of the wait request, so that
+// clients can match results to their requests.
+type WaitResult struct {
+	ContainerID string    `json:"container_id"`
+	ExecID      string    `json:"exec_id,omitempty"`
+	Token       string    `json:"token,omitempty"`
+	ExitStatus  uint32    `json:"exit_status"`
+	ExitedAt    time.Time `json:"exited_at"`
+}
+
+// UpdateMounts is passed as the resources of an Update request to add mounts
+// to a running container, e.g. the subPath and CSI mounts created after it
+// started. runsc can't add them, so the update fails with Unimplemented
+// naming their destinations.
+type UpdateMounts struct {
+	Mounts []specs.Mount `json:"mounts"`
+}
+
+// StartLatency is published once a container started, with the time spent
+// in each phase of its creation and start, to show where the cold start
+// time of a sandbox goes.
+type StartLatency struct {
+	ContainerID string `json:"container_id"`
+	Pid         uint32 `json:"pid"`
+	// Phases are in the order they ran: rootfs-mount, runsc-create,
+	// io-setup and runsc-start.
+	Phases []LatencyPhase `json:"phases"`
+	// Total is the time from the start of the first phase to the end of
+	// the last.
+	Total time.Duration `json:"total_ns"`
+}
+
+// LatencyPhase is a phase of the creation or start of a container.
+type LatencyPhase struct {
+	Name     string        `json:"name"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// StateChanged is streamed on each state change of the init process or an
+// exec process, e.g. from running to paused, in the order they happen.
+type StateChanged struct {
+	ContainerID string    `json:"container_id"`
+	ExecID      string    `json:"exec_id,omitempty"`
+	From        string    `json:"from"`
+	To          string    `json:"to"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// ExecSpec is passed as the spec of an Exec request in place of the OCI
+// process to set options of the exec process. The process fields are
+// inlined, so the spec still decodes as an OCI process.
+type ExecSpec struct {
+	specs.Process
+	// MaxRuntime is how long the exec process may run before the shim
+	// kills it, overriding the default of the container. Zero keeps the
+	// default.
+	MaxRuntime time.Duration `json:"max_runtime_ns,omitempty"`
+	// Mounts are the mounts the exec process needs in the container, e.g.
+	// the volume of the tools of an ephemeral debug container. runsc can't
+	// add them to a running sandbox, so execs with mounts are refused.
+	Mounts []specs.Mount `json:"mounts,omitempty"`
+}
+
+// Topic returns the event topic for runsc specific events.
+func Topic(e interface{}) (string, bool) {
+	switch e.(type) {
+	case *MemoryThreshold:
+		return MemoryThresholdEventTopic, true
+	case *RuntimeMismatch:
+		return RuntimeMismatchEventTopic, true
+	case *ArtifactsRemoved:
+		return ArtifactsRemovedEventTopic, true
+	case *SeccompRewritten:
+		return SeccompRewrittenEventTopic, true
+	case *WatchdogKill:
+		return WatchdogKillEventTopic, true
+	case *CrashReport:
+		return CrashReportEventTopic, true
+	case *DryRun:
+		return DryRunEventTopic, true
+	case *IOClosed:
+		return IOClosedEventTopic, true
+	case *GoferExited:
+		return GoferExitedEventTopic, true
+	case *WaitResult:
+		return WaitResultEventTopic, true
+	case *ExitDetails:
+		return ExitDetailsEventTopic, true
+	case *DeleteDetails:
+		return DeleteDetailsEventTopic, true
+	case *StartLatency:
+		return StartLatencyEventTopic, true
+	case *StateChanged:
+		return StateChangedEventTopic, true
+	case *UnsupportedSyscalls:
+		return UnsupportedSyscallsEventTopic, true
+	}
+	return "", false
+}
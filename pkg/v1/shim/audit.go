@@ -0,0 +1,156 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/errdefs"
+	shimapi "github.com/containerd/containerd/runtime/v1/shim/v1"
+	ptypes "github.com/gogo/protobuf/types"
+	"github.com/sirupsen/logrus"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/audit"
+)
+
+// Audit returns next validating the ids and paths of the requests it is
+// sent and recording the calls changing the container in the shim log, to
+// be registered on the ttrpc server in place of next.
+func Audit(next shimapi.ShimService) shimapi.ShimService {
+	return &auditingService{ShimService: next}
+}
+
+// auditingService validates and audits the RPCs served by ShimService.
+type auditingService struct {
+	shimapi.ShimService
+}
+
+func (s *auditingService) State(ctx context.Context, r *shimapi.StateRequest) (*shimapi.StateResponse, error) {
+	if err := audit.CheckExecID(r.ID); err != nil {
+		return nil, errdefs.ToGRPC(err)
+	}
+	return s.ShimService.State(ctx, r)
+}
+
+func (s *auditingService) Create(ctx context.Context, r *shimapi.CreateTaskRequest) (_ *shimapi.CreateTaskResponse, err error) {
+	defer audit.Record(ctx, "Create", logrus.Fields{"id": r.ID, "bundle": r.Bundle, "checkpoint": r.Checkpoint}, &err)
+	if err := audit.CheckID("container", r.ID); err != nil {
+		return nil, errdefs.ToGRPC(err)
+	}
+	if err := audit.CheckPath("bundle", r.Bundle); err != nil {
+		return nil, errdefs.ToGRPC(err)
+	}
+	if r.Checkpoint != "" {
+		if err := audit.CheckPath("checkpoint", r.Checkpoint); err != nil {
+			return nil, errdefs.ToGRPC(err)
+		}
+	}
+	return s.ShimService.Create(ctx, r)
+}
+
+func (s *auditingService) Start(ctx context.Context, r *shimapi.StartRequest) (_ *shimapi.StartResponse, err error) {
+	defer audit.Record(ctx, "Start", logrus.Fields{"id": r.ID}, &err)
+	if err := audit.CheckExecID(r.ID); err != nil {
+		return nil, errdefs.ToGRPC(err)
+	}
+	return s.ShimService.Start(ctx, r)
+}
+
+func (s *auditingService) Delete(ctx context.Context, r *ptypes.Empty) (_ *shimapi.DeleteResponse, err error) {
+	defer audit.Record(ctx, "Delete", nil, &err)
+	return s.ShimService.Delete(ctx, r)
+}
+
+func (s *auditingService) DeleteProcess(ctx context.Context, r *shimapi.DeleteProcessRequest) (_ *shimapi.DeleteResponse, err error) {
+	defer audit.Record(ctx, "DeleteProcess", logrus.Fields{"id": r.ID}, &err)
+	if err := audit.CheckExecID(r.ID); err != nil {
+		return nil, errdefs.ToGRPC(err)
+	}
+	return s.ShimService.DeleteProcess(ctx, r)
+}
+
+func (s *auditingService) ListPids(ctx context.Context, r *shimapi.ListPidsRequest) (*shimapi.ListPidsResponse, error) {
+	if err := audit.CheckExecID(r.ID); err != nil {
+		return nil, errdefs.ToGRPC(err)
+	}
+	return s.ShimService.ListPids(ctx, r)
+}
+
+func (s *auditingService) Pause(ctx context.Context, r *ptypes.Empty) (_ *ptypes.Empty, err error) {
+	defer audit.Record(ctx, "Pause", nil, &err)
+	return s.ShimService.Pause(ctx, r)
+}
+
+func (s *auditingService) Resume(ctx context.Context, r *ptypes.Empty) (_ *ptypes.Empty, err error) {
+	defer audit.Record(ctx, "Resume", nil, &err)
+	return s.ShimService.Resume(ctx, r)
+}
+
+func (s *auditingService) Checkpoint(ctx context.Context, r *shimapi.CheckpointTaskRequest) (_ *ptypes.Empty, err error) {
+	defer audit.Record(ctx, "Checkpoint", logrus.Fields{"path": r.Path}, &err)
+	if err := audit.CheckPath("checkpoint", r.Path); err != nil {
+		return nil, errdefs.ToGRPC(err)
+	}
+	return s.ShimService.Checkpoint(ctx, r)
+}
+
+func (s *auditingService) Kill(ctx context.Context, r *shimapi.KillRequest) (_ *ptypes.Empty, err error) {
+	defer audit.Record(ctx, "Kill", logrus.Fields{"id": r.ID, "signal": r.Signal, "all": r.All}, &err)
+	if err := audit.CheckExecID(r.ID); err != nil {
+		return nil, errdefs.ToGRPC(err)
+	}
+	return s.ShimService.Kill(ctx, r)
+}
+
+func (s *auditingService) Exec(ctx context.Context, r *shimapi.ExecProcessRequest) (_ *ptypes.Empty, err error) {
+	defer audit.Record(ctx, "Exec", logrus.Fields{"id": r.ID, "terminal": r.Terminal}, &err)
+	if err := audit.CheckID("exec", r.ID); err != nil {
+		return nil, errdefs.ToGRPC(err)
+	}
+	if err := audit.CheckExecSpec(r.Spec); err != nil {
+		return nil, errdefs.ToGRPC(err)
+	}
+	return s.ShimService.Exec(ctx, r)
+}
+
+func (s *auditingService) ResizePty(ctx context.Context, r *shimapi.ResizePtyRequest) (_ *ptypes.Empty, err error) {
+	defer audit.Record(ctx, "ResizePty", logrus.Fields{"id": r.ID, "width": r.Width, "height": r.Height}, &err)
+	if err := audit.CheckExecID(r.ID); err != nil {
+		return nil, errdefs.ToGRPC(err)
+	}
+	return s.ShimService.ResizePty(ctx, r)
+}
+
+func (s *auditingService) CloseIO(ctx context.Context, r *shimapi.CloseIORequest) (_ *ptypes.Empty, err error) {
+	defer audit.Record(ctx, "CloseIO", logrus.Fields{"id": r.ID, "stdin": r.Stdin}, &err)
+	if err := audit.CheckExecID(r.ID); err != nil {
+		return nil, errdefs.ToGRPC(err)
+	}
+	return s.ShimService.CloseIO(ctx, r)
+}
+
+func (s *auditingService) Update(ctx context.Context, r *shimapi.UpdateTaskRequest) (_ *ptypes.Empty, err error) {
+	defer audit.Record(ctx, "Update", nil, &err)
+	return s.ShimService.Update(ctx, r)
+}
+
+func (s *auditingService) Wait(ctx context.Context, r *shimapi.WaitRequest) (*shimapi.WaitResponse, error) {
+	if err := audit.CheckExecID(r.ID); err != nil {
+		return nil, errdefs.ToGRPC(err)
+	}
+	return s.ShimService.Wait(ctx, r)
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"context"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	shimapi "github.com/containerd/containerd/runtime/v1/shim/v1"
+	"github.com/containerd/typeurl"
+	ptypes "github.com/gogo/protobuf/types"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// recordingShim records the calls that reach it. Methods not overridden
+// panic, the audit tests only send the ones below.
+type recordingShim struct {
+	shimapi.ShimService
+	calls []string
+}
+
+func (s *recordingShim) Create(ctx context.Context, r *shimapi.CreateTaskRequest) (*shimapi.CreateTaskResponse, error) {
+	s.calls = append(s.calls, "Create")
+	return &shimapi.CreateTaskResponse{}, nil
+}
+
+func (s *recordingShim) Exec(ctx context.Context, r *shimapi.ExecProcessRequest) (*ptypes.Empty, error) {
+	s.calls = append(s.calls, "Exec")
+	return &ptypes.Empty{}, nil
+}
+
+func (s *recordingShim) Kill(ctx context.Context, r *shimapi.KillRequest) (*ptypes.Empty, error) {
+	s.calls = append(s.calls, "Kill")
+	return &ptypes.Empty{}, nil
+}
+
+func (s *recordingShim) Checkpoint(ctx context.Context, r *shimapi.CheckpointTaskRequest) (*ptypes.Empty, error) {
+	s.calls = append(s.calls, "Checkpoint")
+	return &ptypes.Empty{}, nil
+}
+
+func TestAudit(t *testing.T) {
+	process, err := typeurl.MarshalAny(&specs.Process{Args: []string{"true"}, Cwd: "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	relativeCwd, err := typeurl.MarshalAny(&specs.Process{Args: []string{"true"}, Cwd: "tmp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name  string
+		call  func(context.Context, shimapi.ShimService) error
+		valid bool
+	}{
+		{
+			name: "create",
+			call: func(ctx context.Context, s shimapi.ShimService) error {
+				_, err := s.Create(ctx, &shimapi.CreateTaskRequest{ID: "c1", Bundle: "/run/c1"})
+				return err
+			},
+			valid: true,
+		},
+		{
+			name: "create with invalid id",
+			call: func(ctx context.Context, s shimapi.ShimService) error {
+				_, err := s.Create(ctx, &shimapi.CreateTaskRequest{ID: "../c1", Bundle: "/run/c1"})
+				return err
+			},
+		},
+		{
+			name: "create with relative bundle",
+			call: func(ctx context.Context, s shimapi.ShimService) error {
+				_, err := s.Create(ctx, &shimapi.CreateTaskRequest{ID: "c1", Bundle: "run/c1"})
+				return err
+			},
+		},
+		{
+			name: "create with climbing checkpoint",
+			call: func(ctx context.Context, s shimapi.ShimService) error {
+				_, err := s.Create(ctx, &shimapi.CreateTaskRequest{ID: "c1", Bundle: "/run/c1", Checkpoint: "/var/../etc"})
+				return err
+			},
+		},
+		{
+			name: "exec",
+			call: func(ctx context.Context, s shimapi.ShimService) error {
+				_, err := s.Exec(ctx, &shimapi.ExecProcessRequest{ID: "e1", Spec: process})
+				return err
+			},
+			valid: true,
+		},
+		{
+			name: "exec of the init process",
+			call: func(ctx context.Context, s shimapi.ShimService) error {
+				_, err := s.Exec(ctx, &shimapi.ExecProcessRequest{Spec: process})
+				return err
+			},
+		},
+		{
+			name: "exec with relative cwd",
+			call: func(ctx context.Context, s shimapi.ShimService) error {
+				_, err := s.Exec(ctx, &shimapi.ExecProcessRequest{ID: "e1", Spec: relativeCwd})
+				return err
+			},
+		},
+		{
+			name: "kill",
+			call: func(ctx context.Context, s shimapi.ShimService) error {
+				_, err := s.Kill(ctx, &shimapi.KillRequest{Signal: 9})
+				return err
+			},
+			valid: true,
+		},
+		{
+			name: "kill with invalid exec id",
+			call: func(ctx context.Context, s shimapi.ShimService) error {
+				_, err := s.Kill(ctx, &shimapi.KillRequest{ID: "e 1", Signal: 9})
+				return err
+			},
+		},
+		{
+			name: "checkpoint with relative path",
+			call: func(ctx context.Context, s shimapi.ShimService) error {
+				_, err := s.Checkpoint(ctx, &shimapi.CheckpointTaskRequest{Path: "checkpoints/c1"})
+				return err
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			next := &recordingShim{}
+			err := tc.call(context.Background(), Audit(next))
+			if tc.valid {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(next.calls) != 1 {
+					t.Fatalf("calls reaching the service: got %v, want one", next.calls)
+				}
+				return
+			}
+			if !errdefs.IsInvalidArgument(errdefs.FromGRPC(err)) {
+				t.Fatalf("got %v, want an invalid argument error", err)
+			}
+			if len(next.calls) != 0 {
+				t.Fatalf("rejected request reached the service: %v", next.calls)
+			}
+		})
+	}
+}
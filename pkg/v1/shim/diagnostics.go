@@ -0,0 +1,72 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/debug"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/proc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// Diagnostics returns what debugging tools need to attach to the sandbox of
+// the container.
+func (s *Service) Diagnostics(ctx context.Context) (*runsctypes.Diagnostics, error) {
+	p, err := s.getInitProcess()
+	if err != nil {
+		return nil, err
+	}
+	return p.(*proc.Init).Diagnostics(ctx)
+}
+
+// SignalSentry sends the debug signal of req to the sentry of the container,
+// served by the runsc service.
+func (s *Service) SignalSentry(ctx context.Context, req *runsctypes.SignalSentryRequest) error {
+	p, err := s.getInitProcess()
+	if err != nil {
+		return err
+	}
+	_, err = p.(*proc.Init).SignalSentry(ctx, req.Signal)
+	return err
+}
+
+// RunscConfig returns the runsc configuration the container was created
+// with.
+func (s *Service) RunscConfig(ctx context.Context) (*runsctypes.RunscConfig, error) {
+	p, err := s.getInitProcess()
+	if err != nil {
+		return nil, err
+	}
+	return p.(*proc.Init).RunscConfig()
+}
+
+// RunscConfigHandler serves RunscConfig on the debug socket at GET
+// /debug/runsc-config.
+func (s *Service) RunscConfigHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := s.RunscConfig(r.Context())
+		if err != nil {
+			debug.Error(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c)
+	})
+}
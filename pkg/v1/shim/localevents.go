@@ -0,0 +1,99 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	rproc "github.com/containerd/containerd/runtime/proc"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/proc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// publishLocal streams an internal lifecycle event to local subscribers
+// only, as containerd has no use for it.
+func (s *Service) publishLocal(e interface{}) {
+	s.config.LocalEvents.Publish(getTopic(s.context, e), e)
+}
+
+// stateChanged streams the state changes of the processes of the container.
+func (s *Service) stateChanged(c proc.StateChange) {
+	s.publishLocal(&runsctypes.StateChanged{
+		ContainerID: c.ID,
+		ExecID:      c.ExecID,
+		From:        string(c.From),
+		To:          string(c.To),
+		Timestamp:   c.Timestamp,
+	})
+}
+
+// watchIO streams an IOClosed event once the output of the started process
+// is copied.
+func (s *Service) watchIO(p rproc.Process) {
+	w, ok := p.(proc.IOWaiter)
+	if s.config.LocalEvents == nil || !ok {
+		return
+	}
+	go func() {
+		<-w.IODone()
+		e := &runsctypes.IOClosed{
+			ContainerID: s.id,
+			Timestamp:   time.Now(),
+		}
+		if c, ok := p.(proc.IOCounter); ok {
+			stats := c.IOStats()
+			e.ExecID, e.Stdout, e.Stderr = stats.ExecID, stats.Stdout, stats.Stderr
+		}
+		s.publishLocal(e)
+	}()
+}
+
+// startGoferWatch streams GoferExited events for the gofers of the started
+// sandbox until it exits, and records their death as the failure of the
+// sandbox.
+func (s *Service) startGoferWatch(p *proc.Init) {
+	if !p.Sandbox {
+		return
+	}
+	ctx, cancel := context.WithCancel(s.context)
+	s.mu.Lock()
+	s.stopGoferWatch = cancel
+	s.mu.Unlock()
+	p.WatchGofers(ctx, func(pid int) {
+		log.G(ctx).Warnf("Gofer %d of sandbox %q exited", pid, p.ID())
+		p.RecordFailure(runsctypes.ReasonGoferCrashed, fmt.Sprintf("gofer %d exited", pid))
+		s.publishLocal(&runsctypes.GoferExited{
+			ContainerID: p.ID(),
+			Pid:         pid,
+			Timestamp:   time.Now(),
+		})
+	})
+}
+
+// stopGoferWatching stops watching the gofers of the sandbox.
+func (s *Service) stopGoferWatching() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopGoferWatch != nil {
+		s.stopGoferWatch()
+		s.stopGoferWatch = nil
+	}
+}
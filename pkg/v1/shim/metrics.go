@@ -0,0 +1,53 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"net/http"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/proc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/stats"
+)
+
+// SentryMetricsHandler serves the sentry metrics of the container in the
+// Prometheus text format, for scrapers to merge with the metrics of the
+// other shims, followed by the leaked mount and rpc panic metrics of the
+// shim. Only the latter are served with a runsc without export-metrics.
+func (s *Service) SentryMetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := s.getInitProcess()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		data, err := p.(*proc.Init).SentryMetrics(r.Context())
+		if errdefs.IsNotImplemented(err) {
+			// The metrics of the shim are still served.
+			log.G(r.Context()).WithError(err).Debug("no sentry metrics")
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", stats.MetricsContentType)
+		w.Write(data)
+		w.Write(s.mountWatchdog.Metrics())
+		w.Write(s.rpcs.Metrics())
+	})
+}
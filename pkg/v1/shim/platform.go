@@ -18,81 +18,11 @@ limitations under the License.
 package shim
 
 import (
-	"context"
-	"io"
-	"sync"
-	"syscall"
-
-	"github.com/containerd/console"
-	"github.com/containerd/fifo"
+	"github.com/containerd/containerd/runtime/proc"
 	"github.com/pkg/errors"
-)
-
-type linuxPlatform struct {
-	epoller *console.Epoller
-}
-
-func (p *linuxPlatform) CopyConsole(ctx context.Context, console console.Console, stdin, stdout, stderr string, wg, cwg *sync.WaitGroup) (console.Console, error) {
-	if p.epoller == nil {
-		return nil, errors.New("uninitialized epoller")
-	}
-
-	epollConsole, err := p.epoller.Add(console)
-	if err != nil {
-		return nil, err
-	}
-
-	if stdin != "" {
-		in, err := fifo.OpenFifo(ctx, stdin, syscall.O_RDONLY, 0)
-		if err != nil {
-			return nil, err
-		}
-		cwg.Add(1)
-		go func() {
-			cwg.Done()
-			p := bufPool.Get().(*[]byte)
-			defer bufPool.Put(p)
-			io.CopyBuffer(epollConsole, in, *p)
-		}()
-	}
-
-	outw, err := fifo.OpenFifo(ctx, stdout, syscall.O_WRONLY, 0)
-	if err != nil {
-		return nil, err
-	}
-	outr, err := fifo.OpenFifo(ctx, stdout, syscall.O_RDONLY, 0)
-	if err != nil {
-		return nil, err
-	}
-	wg.Add(1)
-	cwg.Add(1)
-	go func() {
-		cwg.Done()
-		p := bufPool.Get().(*[]byte)
-		defer bufPool.Put(p)
-		io.CopyBuffer(outw, epollConsole, *p)
-		epollConsole.Close()
-		outr.Close()
-		outw.Close()
-		wg.Done()
-	}()
-	return epollConsole, nil
-}
-
-func (p *linuxPlatform) ShutdownConsole(ctx context.Context, cons console.Console) error {
-	if p.epoller == nil {
-		return errors.New("uninitialized epoller")
-	}
-	epollConsole, ok := cons.(*console.EpollConsole)
-	if !ok {
-		return errors.Errorf("expected EpollConsole, got %#v", cons)
-	}
-	return epollConsole.Shutdown(p.epoller.CloseConsole)
-}
 
-func (p *linuxPlatform) Close() error {
-	return p.epoller.Close()
-}
+	"github.com/google/gvisor-containerd-shim/pkg/v1/platform"
+)
 
 // initialize a single epoll fd to manage our consoles. `initPlatform` should
 // only be called once.
@@ -100,13 +30,20 @@ func (s *Service) initPlatform() error {
 	if s.platform != nil {
 		return nil
 	}
-	epoller, err := console.NewEpoller()
+	p, err := NewPlatform()
 	if err != nil {
-		return errors.Wrap(err, "failed to initialize epoller")
+		return err
 	}
-	s.platform = &linuxPlatform{
-		epoller: epoller,
-	}
-	go epoller.Wait()
+	s.platform = p
 	return nil
 }
+
+// NewPlatform returns the console handling of the shim, backed by its own
+// epoll fd. It is used by callers running processes outside of a Service.
+func NewPlatform() (proc.Platform, error) {
+	p, err := platform.New()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialize console platform")
+	}
+	return p, nil
+}
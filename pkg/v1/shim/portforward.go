@@ -0,0 +1,46 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"context"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/proc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runscapi"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// PortForward opens a connection to the port of req in the container, for
+// the port forwards of the CRI: in netstack mode the ports of the sandbox
+// can't be reached from the network namespace of the pod. The connection
+// is served on the socket of the response.
+func (s *Service) PortForward(ctx context.Context, req *runsctypes.PortForwardRequest) (*runsctypes.PortForwarded, error) {
+	p, err := s.getInitProcess()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := p.(*proc.Init).PortForward(ctx, req.Port)
+	if err != nil {
+		return nil, err
+	}
+	address, err := runscapi.ServeStream(ctx, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &runsctypes.PortForwarded{Address: address}, nil
+}
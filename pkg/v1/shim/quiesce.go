@@ -0,0 +1,43 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"context"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/proc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// Quiesce freezes the container for a backup of its volumes, until
+// Unquiesce or until the timeout of req expires.
+func (s *Service) Quiesce(ctx context.Context, req *runsctypes.QuiesceRequest) (*runsctypes.Quiesced, error) {
+	p, err := s.getInitProcess()
+	if err != nil {
+		return nil, err
+	}
+	return p.(*proc.Init).Quiesce(ctx, req.Timeout)
+}
+
+// Unquiesce resumes the container frozen by Quiesce.
+func (s *Service) Unquiesce(ctx context.Context) error {
+	p, err := s.getInitProcess()
+	if err != nil {
+		return err
+	}
+	return p.(*proc.Init).Unquiesce(ctx)
+}
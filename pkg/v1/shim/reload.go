@@ -0,0 +1,88 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"time"
+
+	"github.com/containerd/containerd/log"
+	"github.com/sirupsen/logrus"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/proc"
+)
+
+// ReloadableRunscFlags are the runsc flags that can be changed while the
+// container runs: the debug logging of the runsc commands.
+var ReloadableRunscFlags = []string{"debug", "debug-log", "debug-log-format"}
+
+// Reloadable are the settings of the service that can be changed while the
+// container runs, e.g. when the shim config is reloaded.
+type Reloadable struct {
+	// RunscDebugFlags are the ReloadableRunscFlags set. They apply to the
+	// runsc commands run after the reload: the sandbox keeps logging where
+	// it was started to.
+	RunscDebugFlags map[string]string
+	// StatsInterval is the interval of the resource usage sampling, the
+	// sampler of a running container is restarted when it changes.
+	StatsInterval time.Duration
+	Teardown      TeardownConfig
+}
+
+// Reload applies the settings r to the service and its container.
+func (s *Service) Reload(r Reloadable) error {
+	if err := r.Teardown.Validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.config.Teardown = r.Teardown
+	s.config.RunscConfig = withReloadedFlags(s.config.RunscConfig, r.RunscDebugFlags)
+	resample := s.config.Stats.Interval != r.StatsInterval
+	s.config.Stats.Interval = r.StatsInterval
+	p, _ := s.processes[s.id].(*proc.Init)
+	s.mu.Unlock()
+	if p == nil {
+		return nil
+	}
+	if !p.Unsandboxed() {
+		p.Runtime().SetConfig(withReloadedFlags(p.Runtime().Flags(), r.RunscDebugFlags))
+	}
+	if status, err := p.Status(s.context); resample && err == nil && status == "running" {
+		s.stopSampling()
+		s.startSampler(p)
+	}
+	log.G(s.context).WithFields(logrus.Fields{
+		"stats_interval": r.StatsInterval,
+		"teardown":       r.Teardown.Policy,
+	}).Info("reloaded shim settings")
+	return nil
+}
+
+// withReloadedFlags returns a copy of config with the reloadable flags
+// replaced by the ones of flags.
+func withReloadedFlags(config, flags map[string]string) map[string]string {
+	out := make(map[string]string, len(config))
+	for k, v := range config {
+		out[k] = v
+	}
+	for _, k := range ReloadableRunscFlags {
+		delete(out, k)
+		if v, ok := flags[k]; ok {
+			out[k] = v
+		}
+	}
+	return out
+}
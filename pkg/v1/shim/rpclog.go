@@ -0,0 +1,120 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"context"
+
+	shimapi "github.com/containerd/containerd/runtime/v1/shim/v1"
+	ptypes "github.com/gogo/protobuf/types"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/rpclog"
+)
+
+// RecordRPCs returns s recording the RPCs it serves in rpcs, to be
+// registered on the ttrpc server in place of s. The RPCs that panic return
+// an Internal error, counted in the metrics of s.
+func (s *Service) RecordRPCs(rpcs *rpclog.Log) shimapi.ShimService {
+	s.rpcs = rpcs
+	return &recordingService{Service: s, rpcs: rpcs}
+}
+
+// recordingService records the RPCs served by Service.
+type recordingService struct {
+	*Service
+	rpcs *rpclog.Log
+}
+
+func (s *recordingService) State(ctx context.Context, r *shimapi.StateRequest) (_ *shimapi.StateResponse, err error) {
+	defer s.rpcs.Start("State", r.ID).End(&err)
+	return s.Service.State(ctx, r)
+}
+
+func (s *recordingService) Create(ctx context.Context, r *shimapi.CreateTaskRequest) (_ *shimapi.CreateTaskResponse, err error) {
+	defer s.rpcs.Start("Create", r.ID).End(&err)
+	return s.Service.Create(ctx, r)
+}
+
+func (s *recordingService) Start(ctx context.Context, r *shimapi.StartRequest) (_ *shimapi.StartResponse, err error) {
+	defer s.rpcs.Start("Start", r.ID).End(&err)
+	return s.Service.Start(ctx, r)
+}
+
+func (s *recordingService) Delete(ctx context.Context, r *ptypes.Empty) (_ *shimapi.DeleteResponse, err error) {
+	defer s.rpcs.Start("Delete", "").End(&err)
+	return s.Service.Delete(ctx, r)
+}
+
+func (s *recordingService) DeleteProcess(ctx context.Context, r *shimapi.DeleteProcessRequest) (_ *shimapi.DeleteResponse, err error) {
+	defer s.rpcs.Start("DeleteProcess", r.ID).End(&err)
+	return s.Service.DeleteProcess(ctx, r)
+}
+
+func (s *recordingService) ListPids(ctx context.Context, r *shimapi.ListPidsRequest) (_ *shimapi.ListPidsResponse, err error) {
+	defer s.rpcs.Start("ListPids", r.ID).End(&err)
+	return s.Service.ListPids(ctx, r)
+}
+
+func (s *recordingService) Pause(ctx context.Context, r *ptypes.Empty) (_ *ptypes.Empty, err error) {
+	defer s.rpcs.Start("Pause", "").End(&err)
+	return s.Service.Pause(ctx, r)
+}
+
+func (s *recordingService) Resume(ctx context.Context, r *ptypes.Empty) (_ *ptypes.Empty, err error) {
+	defer s.rpcs.Start("Resume", "").End(&err)
+	return s.Service.Resume(ctx, r)
+}
+
+func (s *recordingService) Checkpoint(ctx context.Context, r *shimapi.CheckpointTaskRequest) (_ *ptypes.Empty, err error) {
+	defer s.rpcs.Start("Checkpoint", "").End(&err)
+	return s.Service.Checkpoint(ctx, r)
+}
+
+func (s *recordingService) Kill(ctx context.Context, r *shimapi.KillRequest) (_ *ptypes.Empty, err error) {
+	defer s.rpcs.Start("Kill", r.ID).End(&err)
+	return s.Service.Kill(ctx, r)
+}
+
+func (s *recordingService) Exec(ctx context.Context, r *shimapi.ExecProcessRequest) (_ *ptypes.Empty, err error) {
+	defer s.rpcs.Start("Exec", r.ID).End(&err)
+	return s.Service.Exec(ctx, r)
+}
+
+func (s *recordingService) ResizePty(ctx context.Context, r *shimapi.ResizePtyRequest) (_ *ptypes.Empty, err error) {
+	defer s.rpcs.Start("ResizePty", r.ID).End(&err)
+	return s.Service.ResizePty(ctx, r)
+}
+
+func (s *recordingService) CloseIO(ctx context.Context, r *shimapi.CloseIORequest) (_ *ptypes.Empty, err error) {
+	defer s.rpcs.Start("CloseIO", r.ID).End(&err)
+	return s.Service.CloseIO(ctx, r)
+}
+
+func (s *recordingService) ShimInfo(ctx context.Context, r *ptypes.Empty) (_ *shimapi.ShimInfoResponse, err error) {
+	defer s.rpcs.Start("ShimInfo", "").End(&err)
+	return s.Service.ShimInfo(ctx, r)
+}
+
+func (s *recordingService) Update(ctx context.Context, r *shimapi.UpdateTaskRequest) (_ *ptypes.Empty, err error) {
+	defer s.rpcs.Start("Update", "").End(&err)
+	return s.Service.Update(ctx, r)
+}
+
+func (s *recordingService) Wait(ctx context.Context, r *shimapi.WaitRequest) (_ *shimapi.WaitResponse, err error) {
+	defer s.rpcs.Start("Wait", r.ID).End(&err)
+	return s.Service.Wait(ctx, r)
+}
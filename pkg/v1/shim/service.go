@@ -22,20 +22,19 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/containerd/console"
 	eventstypes "github.com/containerd/containerd/api/events"
-	"github.com/containerd/containerd/api/types/task"
 	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/events"
 	"github.com/containerd/containerd/log"
-	"github.com/containerd/containerd/mount"
 	"github.com/containerd/containerd/namespaces"
 	"github.com/containerd/containerd/runtime"
 	"github.com/containerd/containerd/runtime/linux/runctypes"
 	rproc "github.com/containerd/containerd/runtime/proc"
-	"github.com/containerd/containerd/runtime/v1/shim"
 	shimapi "github.com/containerd/containerd/runtime/v1/shim/v1"
 	"github.com/containerd/typeurl"
 	ptypes "github.com/gogo/protobuf/types"
@@ -44,28 +43,118 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
+	"github.com/google/gvisor-containerd-shim/pkg/failpoint"
 	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
+	"github.com/google/gvisor-containerd-shim/pkg/tracing"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/admission"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/cgroup"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/checkpoint"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/compat"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/devices"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/eventq"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/limits"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/localevents"
 	"github.com/google/gvisor-containerd-shim/pkg/v1/proc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/rpclog"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/stats"
 	"github.com/google/gvisor-containerd-shim/pkg/v1/utils"
+	shimversion "github.com/google/gvisor-containerd-shim/pkg/version"
 )
 
-var (
-	empty   = &ptypes.Empty{}
-	bufPool = sync.Pool{
-		New: func() interface{} {
-			buffer := make([]byte, 32<<10)
-			return &buffer
-		},
-	}
-)
+var empty = &ptypes.Empty{}
 
 // Config contains shim specific configuration
 type Config struct {
-	Path        string
-	Namespace   string
-	WorkDir     string
+	Path      string
+	Namespace string
+	WorkDir   string
+	// WorkRoot, when set, replaces WorkDir with a per container directory
+	// laid out as <WorkRoot>/<namespace>/<id>.
+	WorkRoot    string
 	RuntimeRoot string
 	RunscConfig map[string]string
+	// UncheckedRunscFlags are flags of RunscConfig that are not validated.
+	UncheckedRunscFlags []string
+	// Stats configures sampling of the sandbox resource usage.
+	Stats stats.Config
+	// SyscallWarningsInterval is how often the debug logs of the sandbox
+	// are scanned for unsupported syscalls. Zero disables it.
+	SyscallWarningsInterval time.Duration
+	// Events configures the queue of events waiting to be published.
+	Events eventq.Config
+	// Signals translates signals before they are sent to the sandbox.
+	Signals proc.SignalMap
+	// RunHooks runs the OCI hooks of the spec on the host.
+	RunHooks bool
+	// ChownHelper is the chown binary run in containers to give them their
+	// volumes. Empty disables the fixups.
+	ChownHelper string
+	// RuncBinary is the runc binary of the containers that opt out of the
+	// sandbox. Empty keeps them in a sandbox.
+	RuncBinary string
+	// OOMScoreOffset is added to the oomScoreAdj of the spec for the
+	// sandbox and gofer processes.
+	OOMScoreOffset int
+	// UserLogToStdout forwards the user log of a sandbox to its stdout when
+	// no path is configured.
+	UserLogToStdout bool
+	// HoldNamespaces keeps the namespaces joined by path open for the
+	// lifetime of the container.
+	HoldNamespaces bool
+	// CheckpointCompression is the compression of checkpoint images.
+	CheckpointCompression checkpoint.Compression
+	// PerfProfile selects the runsc flags of the performance profile of
+	// sandboxes.
+	PerfProfile utils.PerfProfile
+	// FileAccess configures how the sandbox caches files.
+	FileAccess utils.FileAccess
+	// Strict refuses to create containers with a runtime binary that is
+	// not runsc.
+	Strict bool
+	// StrictSeccomp refuses to create containers with a seccomp profile
+	// gVisor doesn't implement instead of rewriting it.
+	StrictSeccomp bool
+	// KeepArtifacts keeps the work directory contents and logs of deleted
+	// containers.
+	KeepArtifacts bool
+	// CollectCrashLogs copies the debug log of crashed sandboxes to the
+	// work directory.
+	CollectCrashLogs bool
+	// LineBufferStderr copies the stderr of processes in whole lines.
+	LineBufferStderr bool
+	// TerminalStdinEOF is what is done when the client closes the stdin of
+	// a process with a terminal.
+	TerminalStdinEOF proc.TerminalStdinEOF
+	// PauseOptimization doesn't run the workload of pause containers.
+	PauseOptimization bool
+	// ShmSize is the default size in bytes of the /dev/shm tmpfs of
+	// containers, zero to keep the /dev/shm of their spec.
+	ShmSize int64
+	// Limits are the limits applied to the shim, which sandboxes without a
+	// cgroup of their own are exempted from.
+	Limits *limits.Limits
+	// Mounts configures how rootfs mounts are set up and torn down.
+	Mounts proc.MountConfig
+	// Timeouts bound the runsc commands setting up processes.
+	Timeouts proc.Timeouts
+	// RunscLimits bound the runsc commands of the shims of the namespace.
+	RunscLimits runsc.Limits
+	// Teardown configures how the container is stopped when the shim is
+	// terminated.
+	Teardown TeardownConfig
+	// Runtimes overrides the runsc binary and RuntimeRoot per namespace
+	// and runtime handler.
+	Runtimes utils.Runtimes
+	// Monitor detects the exits of runsc processes. It defaults to the
+	// SIGCHLD reaper.
+	Monitor proc.Monitor
+	// LocalEvents, when set, streams the events of the shim and its
+	// internal lifecycle events to local subscribers.
+	LocalEvents *localevents.Server
+	// Webhook, when set, is also posted the events forwarded to
+	// containerd.
+	Webhook *localevents.Webhook
 }
 
 // NewService returns a new shim service that can be used via GRPC
@@ -73,24 +162,36 @@ func NewService(config Config, publisher events.Publisher) (*Service, error) {
 	if config.Namespace == "" {
 		return nil, fmt.Errorf("shim namespace cannot be empty")
 	}
+	if config.Monitor == nil {
+		config.Monitor = proc.DefaultReaper
+	}
 	ctx := namespaces.WithNamespace(context.Background(), config.Namespace)
 	ctx = log.WithLogger(ctx, logrus.WithFields(logrus.Fields{
 		"namespace": config.Namespace,
 		"path":      config.Path,
 		"pid":       os.Getpid(),
 	}))
+	if err := runsc.ValidateConfig(config.RunscConfig, config.UncheckedRunscFlags); err != nil {
+		log.G(ctx).WithError(err).Warn("runsc config may be invalid")
+	}
 	s := &Service{
-		config:    config,
-		context:   ctx,
-		processes: make(map[string]rproc.Process),
-		events:    make(chan interface{}, 128),
-		ec:        proc.ExitCh,
+		config:        config,
+		context:       ctx,
+		processes:     make(map[string]rproc.Process),
+		waiters:       make(map[string][]string),
+		events:        eventq.New(config.Events),
+		exits:         proc.NewExits(),
+		forwarded:     make(chan struct{}),
+		mountWatchdog: proc.NewMountWatchdog(config.Mounts),
 	}
+	s.ec = s.exits.Subscribe()
 	go s.processExits()
 	if err := s.initPlatform(); err != nil {
 		return nil, errors.Wrap(err, "failed to initialized platform behavior")
 	}
 	go s.forward(publisher)
+	go s.cleanupOrphans()
+	go s.mountWatchdog.Run(ctx)
 	return s, nil
 }
 
@@ -101,17 +202,39 @@ type Service struct {
 	config    Config
 	context   context.Context
 	processes map[string]rproc.Process
-	events    chan interface{}
+	events    *eventq.Queue
 	platform  rproc.Platform
 	ec        chan proc.Exit
+	exits     *proc.Exits
+	// forwarded is closed once the events are all forwarded after the
+	// queue is closed.
+	forwarded chan struct{}
 
 	// Filled by Create()
 	id     string
 	bundle string
+	// created is when the container was created, reported as the creation
+	// time of the sandbox.
+	created time.Time
+
+	// stopSampler stops the sandbox resource usage sampler.
+	stopSampler func()
+	// stopGoferWatch stops watching the gofers of the sandbox.
+	stopGoferWatch func()
+	// stopSyscallWatch stops watching for unsupported syscalls.
+	stopSyscallWatch func()
+	// waiters are the tokens of the WaitAsync requests by process id.
+	waiters map[string][]string
+	// mountWatchdog retries the mounts leaked by the deleted container.
+	mountWatchdog *proc.MountWatchdog
+	// rpcs are the recent rpcs, set by RecordRPCs.
+	rpcs *rpclog.Log
 }
 
 // Create a new initial process and container with the underlying OCI runtime
 func (s *Service) Create(ctx context.Context, r *shimapi.CreateTaskRequest) (_ *shimapi.CreateTaskResponse, err error) {
+	ctx, span := s.startSpan(ctx, "Create", r.ID, r.ID)
+	defer func() { span.End(err) }()
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -126,50 +249,168 @@ func (s *Service) Create(ctx context.Context, r *shimapi.CreateTaskRequest) (_ *
 	}
 
 	config := &proc.CreateConfig{
-		ID:       r.ID,
-		Bundle:   r.Bundle,
-		Runtime:  r.Runtime,
-		Rootfs:   mounts,
-		Terminal: r.Terminal,
-		Stdin:    r.Stdin,
-		Stdout:   r.Stdout,
-		Stderr:   r.Stderr,
-		Options:  r.Options,
+		ID:         r.ID,
+		Bundle:     r.Bundle,
+		Runtime:    r.Runtime,
+		Rootfs:     mounts,
+		Terminal:   r.Terminal,
+		Stdin:      r.Stdin,
+		Stdout:     r.Stdout,
+		Stderr:     r.Stderr,
+		Options:    r.Options,
+		Checkpoint: r.Checkpoint,
+	}
+	rawSpec, err := compat.ReadRawSpec(r.Bundle)
+	if err != nil {
+		return nil, errors.Wrap(err, "read oci spec")
+	}
+	seccompChanges, err := compat.TranslateSeccomp(rawSpec, s.config.StrictSeccomp)
+	if err != nil {
+		return nil, proc.ToGRPC(err)
+	}
+	shmSize, shmChanged, err := compat.ConfigureShm(rawSpec, s.config.ShmSize)
+	if err != nil {
+		return nil, proc.ToGRPC(err)
+	}
+	specData, spec, err := compat.ParseRawSpec(rawSpec)
+	if err != nil {
+		return nil, errors.Wrap(err, "read oci spec")
+	}
+	if err := compat.Validate(ctx, spec); err != nil {
+		return nil, proc.ToGRPC(err)
+	}
+	dryRun, err := proc.IsDryRun(spec)
+	if err != nil {
+		return nil, proc.ToGRPC(err)
+	}
+	config.Spec = specData
+	// A dry run leaves the bundle as is, it reports config.Spec.
+	if !dryRun && (len(seccompChanges) > 0 || shmChanged) {
+		restoreSpec, err2 := compat.RewriteSpec(r.Bundle, specData)
+		if err2 != nil {
+			return nil, errors.Wrap(err2, "rewrite oci spec")
+		}
+		defer func() {
+			if err != nil {
+				if err2 := restoreSpec(); err2 != nil {
+					log.G(ctx).WithError(err2).Warn("Failed to restore oci spec")
+				}
+			}
+		}()
+		if len(seccompChanges) > 0 {
+			s.seccompRewritten(ctx, r.ID, seccompChanges)
+		}
+	}
+	var runtimeRoot string
+	config.Runtime, runtimeRoot = s.config.Runtimes.Resolve(s.config.Namespace, spec, r.Runtime, s.config.RuntimeRoot)
+	if config.Runtime != r.Runtime || runtimeRoot != s.config.RuntimeRoot {
+		log.G(ctx).WithFields(logrus.Fields{
+			"runtime": config.Runtime,
+			"root":    runtimeRoot,
+		}).Info("Using runtime override")
+	}
+	runscConfig, err := utils.ProfileFlags(s.config.RunscConfig, r.Bundle, spec, s.config.PerfProfile)
+	if err != nil {
+		return nil, proc.ToGRPC(err)
+	}
+	if runscConfig, err = utils.FileAccessFlags(runscConfig, spec, s.config.FileAccess); err != nil {
+		return nil, proc.ToGRPC(err)
 	}
+	if runscConfig, err = devices.Configure(runscConfig, spec, utils.IsSandbox(spec)); err != nil {
+		return nil, proc.ToGRPC(err)
+	}
+	runscConfig = cgroup.Configure(runscConfig, spec)
+	admit := &admission.CreateRequest{
+		Namespace:  s.config.Namespace,
+		ID:         r.ID,
+		Bundle:     r.Bundle,
+		Spec:       spec,
+		RunscFlags: runscConfig,
+	}
+	if err := admission.AdmitCreate(ctx, admit); err != nil {
+		return nil, proc.ToGRPC(err)
+	}
+	runscConfig = admit.RunscFlags
 	rootfs := filepath.Join(r.Bundle, "rootfs")
+	mountConfig := s.config.Mounts
+	if dryRun {
+		// The mounts of a dry run are only logged.
+		mountConfig.Mounter = &proc.DiagnosticMounter{Mounter: mountConfig.Mounter, DryRun: true}
+	}
 	defer func() {
 		if err != nil {
-			if err2 := mount.UnmountAll(rootfs, 0); err2 != nil {
+			if err2 := proc.UnmountRootfs(rootfs, mountConfig); err2 != nil {
 				log.G(ctx).WithError(err2).Warn("Failed to cleanup rootfs mount")
 			}
 		}
 	}()
-	for _, rm := range mounts {
-		m := &mount.Mount{
-			Type:    rm.Type,
-			Source:  rm.Source,
-			Options: rm.Options,
-		}
-		if err := m.Mount(rootfs); err != nil {
-			return nil, errors.Wrapf(err, "failed to mount rootfs component %v", m)
+	_, mountSpan := tracing.Start(ctx, "mount rootfs")
+	mountStart := time.Now()
+	err = proc.MountRootfs(rootfs, mounts, mountConfig)
+	mountEnd := time.Now()
+	mountSpan.End(err)
+	if err != nil {
+		return nil, err
+	}
+	workDir := s.config.WorkDir
+	if s.config.WorkRoot != "" {
+		workDir = utils.WorkDir(s.config.WorkRoot, s.config.Namespace, r.ID)
+		if err := utils.PrepareWorkDir(workDir); err != nil {
+			return nil, proc.ToGRPC(err)
 		}
 	}
 	process, err := newInit(
 		ctx,
 		s.config.Path,
-		s.config.WorkDir,
-		s.config.RuntimeRoot,
+		workDir,
+		runtimeRoot,
 		s.config.Namespace,
-		s.config.RunscConfig,
+		runscConfig,
 		s.platform,
 		config,
 	)
+	if err != nil {
+		return nil, proc.ToGRPC(err)
+	}
+	process.RecordPhase(proc.PhaseRootfsMount, mountStart, mountEnd)
+	process.Exits = s.exits
+	process.Monitor = s.config.Monitor
+	process.Signals = s.config.Signals
+	process.CleanupWorkDir = s.config.WorkRoot != ""
+	process.RunHooks = s.config.RunHooks
+	process.ChownHelper = s.config.ChownHelper
+	process.RuncBinary = s.config.RuncBinary
+	process.OOMScoreOffset = s.config.OOMScoreOffset
+	process.UserLogToStdout = s.config.UserLogToStdout
+	process.RequestedRunscFlags = proc.RequestedFlags(s.config.Namespace, r.ID, s.config.RunscConfig)
+	process.HoldNamespaces = s.config.HoldNamespaces
+	process.CheckpointCompression = s.config.CheckpointCompression
+	process.KeepArtifacts = s.config.KeepArtifacts
+	process.Mounts = s.config.Mounts
+	process.MountWatchdog = s.mountWatchdog
+	process.CollectCrashLogs = s.config.CollectCrashLogs
+	process.IOTimeout = s.config.Timeouts.IO
+	process.LineBufferStderr = s.config.LineBufferStderr
+	process.TerminalStdinEOF = s.config.TerminalStdinEOF
+	process.PauseOptimization = s.config.PauseOptimization
+	process.ShmSize = shmSize
+	process.LeaveShimCgroup = s.config.Limits.Exempt
+	process.StateChanged = s.stateChanged
+	s.config.Timeouts.Apply(process.Runtime())
+	s.config.RunscLimits.Apply(process.Runtime())
+	if err := s.verifyRuntime(ctx, r.ID, process); err != nil {
+		return nil, proc.ToGRPC(err)
+	}
+	if dryRun {
+		return nil, proc.ToGRPC(s.dryRun(ctx, process, config))
+	}
 	if err := process.Create(ctx, config); err != nil {
-		return nil, errdefs.ToGRPC(err)
+		return nil, proc.ToGRPC(err)
 	}
 	// save the main task id and bundle to the shim for additional requests
 	s.id = r.ID
 	s.bundle = r.Bundle
+	s.created = time.Now()
 	pid := process.Pid()
 	s.processes[r.ID] = process
 	return &shimapi.CreateTaskResponse{
@@ -178,14 +419,23 @@ func (s *Service) Create(ctx context.Context, r *shimapi.CreateTaskRequest) (_ *
 }
 
 // Start a process
-func (s *Service) Start(ctx context.Context, r *shimapi.StartRequest) (*shimapi.StartResponse, error) {
+func (s *Service) Start(ctx context.Context, r *shimapi.StartRequest) (_ *shimapi.StartResponse, err error) {
+	ctx, span := s.startSpan(ctx, "Start", s.id, r.ID)
+	defer func() { span.End(err) }()
 	p, err := s.getExecProcess(r.ID)
 	if err != nil {
 		return nil, err
 	}
 	if err := p.Start(ctx); err != nil {
-		return nil, err
+		return nil, proc.ToGRPC(err)
+	}
+	if ip, ok := p.(*proc.Init); ok {
+		s.startSampler(ip)
+		s.startGoferWatch(ip)
+		s.startSyscallWatch(ip)
+		s.publish(ip.StartLatency())
 	}
+	s.watchIO(p)
 	return &shimapi.StartResponse{
 		ID:  p.ID(),
 		Pid: uint32(p.Pid()),
@@ -193,7 +443,9 @@ func (s *Service) Start(ctx context.Context, r *shimapi.StartRequest) (*shimapi.
 }
 
 // Delete the initial process and container
-func (s *Service) Delete(ctx context.Context, r *ptypes.Empty) (*shimapi.DeleteResponse, error) {
+func (s *Service) Delete(ctx context.Context, r *ptypes.Empty) (_ *shimapi.DeleteResponse, err error) {
+	ctx, span := s.startSpan(ctx, "Delete", s.id, s.id)
+	defer func() { span.End(err) }()
 	p, err := s.getInitProcess()
 	if err != nil {
 		return nil, err
@@ -205,6 +457,8 @@ func (s *Service) Delete(ctx context.Context, r *ptypes.Empty) (*shimapi.DeleteR
 	delete(s.processes, s.id)
 	s.mu.Unlock()
 	s.platform.Close()
+	s.publishRemoved(p)
+	s.publish(proc.NewDeleteDetails(s.id, p))
 	return &shimapi.DeleteResponse{
 		ExitStatus: uint32(p.ExitStatus()),
 		ExitedAt:   p.ExitedAt(),
@@ -213,7 +467,9 @@ func (s *Service) Delete(ctx context.Context, r *ptypes.Empty) (*shimapi.DeleteR
 }
 
 // DeleteProcess deletes an exec'd process
-func (s *Service) DeleteProcess(ctx context.Context, r *shimapi.DeleteProcessRequest) (*shimapi.DeleteResponse, error) {
+func (s *Service) DeleteProcess(ctx context.Context, r *shimapi.DeleteProcessRequest) (_ *shimapi.DeleteResponse, err error) {
+	ctx, span := s.startSpan(ctx, "Delete", s.id, r.ID)
+	defer func() { span.End(err) }()
 	if r.ID == s.id {
 		return nil, status.Errorf(codes.InvalidArgument, "cannot delete init process with DeleteProcess")
 	}
@@ -227,6 +483,7 @@ func (s *Service) DeleteProcess(ctx context.Context, r *shimapi.DeleteProcessReq
 	s.mu.Lock()
 	delete(s.processes, r.ID)
 	s.mu.Unlock()
+	s.publish(proc.NewDeleteDetails(s.id, p))
 	return &shimapi.DeleteResponse{
 		ExitStatus: uint32(p.ExitStatus()),
 		ExitedAt:   p.ExitedAt(),
@@ -235,7 +492,9 @@ func (s *Service) DeleteProcess(ctx context.Context, r *shimapi.DeleteProcessReq
 }
 
 // Exec an additional process inside the container
-func (s *Service) Exec(ctx context.Context, r *shimapi.ExecProcessRequest) (*ptypes.Empty, error) {
+func (s *Service) Exec(ctx context.Context, r *shimapi.ExecProcessRequest) (_ *ptypes.Empty, err error) {
+	ctx, span := s.startSpan(ctx, "Exec", s.id, r.ID)
+	defer func() { span.End(err) }()
 	s.mu.Lock()
 
 	if p := s.processes[r.ID]; p != nil {
@@ -248,6 +507,20 @@ func (s *Service) Exec(ctx context.Context, r *shimapi.ExecProcessRequest) (*pty
 	if p == nil {
 		return nil, errdefs.ToGRPCf(errdefs.ErrFailedPrecondition, "container must be created")
 	}
+	if admission.Enabled() {
+		spec, err := admission.ExecProcess(r.Spec)
+		if err != nil {
+			return nil, proc.ToGRPC(err)
+		}
+		if err := admission.AdmitExec(ctx, &admission.ExecRequest{
+			Namespace:   s.config.Namespace,
+			ContainerID: s.id,
+			ExecID:      r.ID,
+			Process:     spec,
+		}); err != nil {
+			return nil, proc.ToGRPC(err)
+		}
+	}
 
 	process, err := p.(*proc.Init).Exec(ctx, s.config.Path, &proc.ExecConfig{
 		ID:       r.ID,
@@ -258,7 +531,7 @@ func (s *Service) Exec(ctx context.Context, r *shimapi.ExecProcessRequest) (*pty
 		Spec:     r.Spec,
 	})
 	if err != nil {
-		return nil, errdefs.ToGRPC(err)
+		return nil, proc.ToGRPC(err)
 	}
 	s.mu.Lock()
 	s.processes[r.ID] = process
@@ -280,7 +553,7 @@ func (s *Service) ResizePty(ctx context.Context, r *shimapi.ResizePtyRequest) (*
 		return nil, err
 	}
 	if err := p.Resize(ws); err != nil {
-		return nil, errdefs.ToGRPC(err)
+		return nil, proc.ToGRPC(err)
 	}
 	return empty, nil
 }
@@ -295,21 +568,12 @@ func (s *Service) State(ctx context.Context, r *shimapi.StateRequest) (*shimapi.
 	if err != nil {
 		return nil, err
 	}
-	status := task.StatusUnknown
-	switch st {
-	case "created":
-		status = task.StatusCreated
-	case "running":
-		status = task.StatusRunning
-	case "stopped":
-		status = task.StatusStopped
-	}
 	sio := p.Stdio()
 	return &shimapi.StateResponse{
 		ID:         p.ID(),
 		Bundle:     s.bundle,
 		Pid:        uint32(p.Pid()),
-		Status:     status,
+		Status:     proc.TaskStatus(ctx, p.ID(), st),
 		Stdin:      sio.Stdin,
 		Stdout:     sio.Stdout,
 		Stderr:     sio.Stderr,
@@ -321,23 +585,39 @@ func (s *Service) State(ctx context.Context, r *shimapi.StateRequest) (*shimapi.
 
 // Pause the container
 func (s *Service) Pause(ctx context.Context, r *ptypes.Empty) (*ptypes.Empty, error) {
-	return empty, errdefs.ToGRPC(errdefs.ErrNotImplemented)
+	p, err := s.getInitProcess()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.(*proc.Init).Pause(ctx); err != nil {
+		return nil, proc.ToGRPC(err)
+	}
+	return empty, nil
 }
 
 // Resume the container
 func (s *Service) Resume(ctx context.Context, r *ptypes.Empty) (*ptypes.Empty, error) {
-	return empty, errdefs.ToGRPC(errdefs.ErrNotImplemented)
+	p, err := s.getInitProcess()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.(*proc.Init).Resume(ctx); err != nil {
+		return nil, proc.ToGRPC(err)
+	}
+	return empty, nil
 }
 
 // Kill a process with the provided signal
-func (s *Service) Kill(ctx context.Context, r *shimapi.KillRequest) (*ptypes.Empty, error) {
+func (s *Service) Kill(ctx context.Context, r *shimapi.KillRequest) (_ *ptypes.Empty, err error) {
+	ctx, span := s.startSpan(ctx, "Kill", s.id, r.ID, tracing.Attr("signal", strconv.Itoa(int(r.Signal))))
+	defer func() { span.End(err) }()
 	if r.ID == "" {
 		p, err := s.getInitProcess()
 		if err != nil {
 			return nil, err
 		}
 		if err := p.Kill(ctx, r.Signal, r.All); err != nil {
-			return nil, errdefs.ToGRPC(err)
+			return nil, proc.ToGRPC(err)
 		}
 		return empty, nil
 	}
@@ -347,36 +627,20 @@ func (s *Service) Kill(ctx context.Context, r *shimapi.KillRequest) (*ptypes.Emp
 		return nil, err
 	}
 	if err := p.Kill(ctx, r.Signal, r.All); err != nil {
-		return nil, errdefs.ToGRPC(err)
+		return nil, proc.ToGRPC(err)
 	}
 	return empty, nil
 }
 
 // ListPids returns all pids inside the container
 func (s *Service) ListPids(ctx context.Context, r *shimapi.ListPidsRequest) (*shimapi.ListPidsResponse, error) {
-	pids, err := s.getContainerPids(ctx, r.ID)
+	entries, err := s.getContainerProcesses(ctx, r.ID)
 	if err != nil {
-		return nil, errdefs.ToGRPC(err)
+		return nil, proc.ToGRPC(err)
 	}
-	var processes []*task.ProcessInfo
-	for _, pid := range pids {
-		pInfo := task.ProcessInfo{
-			Pid: pid,
-		}
-		for _, p := range s.processes {
-			if p.Pid() == int(pid) {
-				d := &runctypes.ProcessDetails{
-					ExecID: p.ID(),
-				}
-				a, err := typeurl.MarshalAny(d)
-				if err != nil {
-					return nil, errors.Wrapf(err, "failed to marshal process %d info", pid)
-				}
-				pInfo.Info = a
-				break
-			}
-		}
-		processes = append(processes, &pInfo)
+	processes, err := proc.ProcessInfos(proc.ProcessDetails(ctx, entries, s.allProcesses()))
+	if err != nil {
+		return nil, err
 	}
 	return &shimapi.ListPidsResponse{
 		Processes: processes,
@@ -389,7 +653,14 @@ func (s *Service) CloseIO(ctx context.Context, r *shimapi.CloseIORequest) (*ptyp
 	if err != nil {
 		return nil, err
 	}
-	if stdin := p.Stdin(); stdin != nil {
+	if !r.Stdin {
+		return empty, nil
+	}
+	if c, ok := p.(proc.StdinCloser); ok {
+		if err := c.CloseStdin(ctx); err != nil {
+			return nil, errors.Wrap(err, "close stdin")
+		}
+	} else if stdin := p.Stdin(); stdin != nil {
 		if err := stdin.Close(); err != nil {
 			return nil, errors.Wrap(err, "close stdin")
 		}
@@ -399,7 +670,29 @@ func (s *Service) CloseIO(ctx context.Context, r *shimapi.CloseIORequest) (*ptyp
 
 // Checkpoint the container
 func (s *Service) Checkpoint(ctx context.Context, r *shimapi.CheckpointTaskRequest) (*ptypes.Empty, error) {
-	return empty, errdefs.ToGRPC(errdefs.ErrNotImplemented)
+	p, err := s.getInitProcess()
+	if err != nil {
+		return nil, err
+	}
+	var options runctypes.CheckpointOptions
+	if r.Options != nil {
+		v, err := typeurl.UnmarshalAny(r.Options)
+		if err != nil {
+			return nil, proc.ToGRPC(errors.Wrap(errdefs.ErrInvalidArgument, err.Error()))
+		}
+		o, ok := v.(*runctypes.CheckpointOptions)
+		if !ok {
+			return nil, errdefs.ToGRPCf(errdefs.ErrInvalidArgument, "unsupported checkpoint options %T", v)
+		}
+		options = *o
+	}
+	if err := p.(*proc.Init).Checkpoint(ctx, &proc.CheckpointConfig{
+		Path: r.Path,
+		Exit: options.Exit,
+	}); err != nil {
+		return nil, proc.ToGRPC(err)
+	}
+	return empty, nil
 }
 
 // ShimInfo returns shim information such as the shim's pid
@@ -409,9 +702,71 @@ func (s *Service) ShimInfo(ctx context.Context, r *ptypes.Empty) (*shimapi.ShimI
 	}, nil
 }
 
+// Version returns the build of the shim, served by the runsc service.
+func (s *Service) Version(ctx context.Context) (*shimversion.Info, error) {
+	info := shimversion.Get()
+	return &info, nil
+}
+
+// RunscState returns the runsc specific state of a process, served by the
+// runsc service.
+func (s *Service) RunscState(ctx context.Context, r *runsctypes.StateRequest) (*runsctypes.State, error) {
+	p, err := s.getInitProcess()
+	if err != nil {
+		return nil, err
+	}
+	id := p.ID()
+	if r.ExecID != "" {
+		if p, err = s.getExecProcess(r.ExecID); err != nil {
+			return nil, err
+		}
+	}
+	return proc.RunscState(id, p), nil
+}
+
+// RunscPids lists the processes of the container with their runsc details,
+// served by the runsc service.
+func (s *Service) RunscPids(ctx context.Context, r *runsctypes.PidsRequest) (*runsctypes.ProcessList, error) {
+	p, err := s.getInitProcess()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := s.getContainerProcesses(ctx, p.ID())
+	if err != nil {
+		return nil, err
+	}
+	details := proc.ProcessDetails(ctx, entries, s.allProcesses())
+	if r.Threads {
+		if err := p.(*proc.Init).AddThreads(ctx, details); err != nil {
+			return nil, proc.ToGRPC(err)
+		}
+	}
+	return &runsctypes.ProcessList{
+		Processes: details,
+	}, nil
+}
+
 // Update a running container
 func (s *Service) Update(ctx context.Context, r *shimapi.UpdateTaskRequest) (*ptypes.Empty, error) {
-	return empty, errdefs.ToGRPC(errdefs.ErrNotImplemented)
+	// gVisor doesn't update resources, only mount updates are checked to
+	// name the mounts that can't be added.
+	if r.Resources == nil || !typeurl.Is(r.Resources, &runsctypes.UpdateMounts{}) {
+		return empty, proc.ToGRPC(errdefs.ErrNotImplemented)
+	}
+	v, err := typeurl.UnmarshalAny(r.Resources)
+	if err != nil {
+		return nil, proc.ToGRPC(errors.Wrap(errdefs.ErrInvalidArgument, err.Error()))
+	}
+	s.mu.Lock()
+	p := s.processes[s.id]
+	s.mu.Unlock()
+	if p == nil {
+		return nil, errdefs.ToGRPCf(errdefs.ErrFailedPrecondition, "container must be created")
+	}
+	if err := p.(*proc.Init).UpdateMounts(ctx, v.(*runsctypes.UpdateMounts).Mounts); err != nil {
+		return nil, proc.ToGRPC(err)
+	}
+	return empty, nil
 }
 
 // Wait for a process to exit
@@ -445,51 +800,129 @@ func (s *Service) allProcesses() []rproc.Process {
 	return res
 }
 
+// checkProcesses routes the exit e to its process. Exits carry the id of
+// the process, so they are dispatched with a lookup instead of a scan of
+// the processes, which matters with many exec processes.
 func (s *Service) checkProcesses(e proc.Exit) {
-	for _, p := range s.allProcesses() {
-		if p.ID() == e.ID {
-			if ip, ok := p.(*proc.Init); ok {
-				// Ensure all children are killed
-				if err := ip.KillAll(s.context); err != nil {
-					log.G(s.context).WithError(err).WithField("id", ip.ID()).
-						Error("failed to kill init's children")
-				}
-			}
-			p.SetExited(e.Status)
-			s.events <- &eventstypes.TaskExit{
-				ContainerID: s.id,
-				ID:          p.ID(),
-				Pid:         uint32(p.Pid()),
-				ExitStatus:  uint32(e.Status),
-				ExitedAt:    p.ExitedAt(),
-			}
-			return
+	s.mu.Lock()
+	p := s.processes[e.ID]
+	s.mu.Unlock()
+	if p == nil {
+		return
+	}
+	if ip, ok := p.(*proc.Init); ok {
+		// Ensure all children are killed
+		if err := ip.KillAll(s.context); err != nil {
+			log.G(s.context).WithError(err).WithField("id", ip.ID()).
+				Error("failed to kill init's children")
+		}
+		s.stopSampling()
+		s.stopGoferWatching()
+		s.stopSyscallWatching()
+	}
+	p.SetExited(e.Status)
+	s.publish(&eventstypes.TaskExit{
+		ContainerID: s.id,
+		ID:          p.ID(),
+		Pid:         uint32(p.Pid()),
+		ExitStatus:  uint32(p.ExitStatus()),
+		ExitedAt:    p.ExitedAt(),
+	})
+	s.publish(proc.NewExitDetails(s.id, p))
+	if ip, ok := p.(*proc.Init); ok {
+		if r := ip.CrashReport(s.context); r != nil {
+			s.publish(r)
 		}
 	}
+	s.publishWaitResults(p)
 }
 
-func (s *Service) getContainerPids(ctx context.Context, id string) ([]uint32, error) {
+func (s *Service) getContainerProcesses(ctx context.Context, id string) ([]proc.PsEntry, error) {
 	p, err := s.getInitProcess()
 	if err != nil {
 		return nil, err
 	}
 
-	ps, err := p.(*proc.Init).Runtime().Ps(ctx, id)
+	top, err := p.(*proc.Init).Runtime().Top(ctx, id)
 	if err != nil {
 		return nil, err
 	}
-	pids := make([]uint32, 0, len(ps))
-	for _, pid := range ps {
-		pids = append(pids, uint32(pid))
+	return proc.ParsePs(top)
+}
+
+// publish queues the event for forwarding to containerd, and streams it to
+// local subscribers.
+func (s *Service) publish(e interface{}) {
+	s.config.LocalEvents.Publish(getTopic(s.context, e), e)
+	if !s.events.Push(e) {
+		log.G(s.context).WithField("dropped", s.events.Dropped()).Warnf("dropped %T event, event queue is full", e)
 	}
-	return pids, nil
 }
 
+// forward publishes the queued events to containerd, and to the webhook
+// if any.
 func (s *Service) forward(publisher events.Publisher) {
-	for e := range s.events {
-		if err := publisher.Publish(s.context, getTopic(s.context, e), e); err != nil {
+	defer close(s.forwarded)
+	for {
+		e, ok := s.events.Pop()
+		if !ok {
+			return
+		}
+		topic := getTopic(s.context, e)
+		err := failpoint.Inject(failpoint.PublishEvent)
+		if err == nil {
+			err = publisher.Publish(s.context, topic, e)
+		}
+		if err != nil {
 			log.G(s.context).WithError(err).Error("post event")
 		}
+		s.config.Webhook.Publish(topic, e)
+	}
+}
+
+// cleanupOrphans removes sandboxes leaked in the namespace by shims that
+// died, e.g. after a node crash.
+func (s *Service) cleanupOrphans() {
+	r := proc.NewRunsc(s.config.RuntimeRoot, s.config.Path, s.config.Namespace, "", nil)
+	deleted, err := proc.CleanupOrphans(s.context, r)
+	if err != nil {
+		log.G(s.context).WithError(err).Warn("failed to cleanup orphaned sandboxes")
+		return
+	}
+	if len(deleted) > 0 {
+		log.G(s.context).WithField("ids", deleted).Info("cleaned up orphaned sandboxes")
+	}
+}
+
+// startSampler starts sampling the resource usage of the sandbox and
+// publishing memory threshold events.
+func (s *Service) startSampler(p *proc.Init) {
+	s.mu.Lock()
+	config := s.config.Stats
+	s.mu.Unlock()
+	if !config.Enabled() {
+		return
+	}
+	ctx, cancel := context.WithCancel(s.context)
+	sampler := stats.NewSampler(p.ID(), p.Pid(), p.Runtime(), config, p.RecordFailures(s.publish))
+	if p.Sandbox {
+		// Subcontainers share the host cgroup of their sandbox, whose
+		// shim enforces the watchdog caps.
+		sampler.SetKiller(p.WatchdogKill)
+	}
+	s.mu.Lock()
+	s.stopSampler = cancel
+	s.mu.Unlock()
+	go sampler.Run(ctx)
+}
+
+// stopSampling stops the sandbox resource usage sampler.
+func (s *Service) stopSampling() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopSampler != nil {
+		s.stopSampler()
+		s.stopSampler = nil
 	}
 }
 
@@ -532,6 +965,9 @@ func getTopic(ctx context.Context, e interface{}) string {
 	case *eventstypes.TaskExecStarted:
 		return runtime.TaskExecStartedEventTopic
 	default:
+		if topic, ok := runsctypes.Topic(e); ok {
+			return topic
+		}
 		logrus.Warnf("no topic for type %#v", e)
 	}
 	return runtime.TaskUnknownTopic
@@ -551,7 +987,7 @@ func newInit(ctx context.Context, path, workDir, runtimeRoot, namespace string,
 	if err != nil {
 		return nil, errors.Wrap(err, "read oci spec")
 	}
-	userLog := runsc.FormatLogPath(r.ID, config)
+	userLog := runsc.FormatLogPath(namespace, r.ID, config)
 	rootfs := filepath.Join(path, "rootfs")
 	runtime := proc.NewRunsc(runtimeRoot, path, namespace, r.Runtime, config)
 	p := proc.New(r.ID, runtime, rproc.Stdio{
@@ -567,7 +1003,126 @@ func newInit(ctx context.Context, path, workDir, runtimeRoot, namespace string,
 	p.IoUID = int(options.IoUid)
 	p.IoGID = int(options.IoGid)
 	p.Sandbox = utils.IsSandbox(spec)
+	p.SandboxID = utils.SandboxID(spec)
 	p.UserLog = userLog
-	p.Monitor = shim.Default
 	return p, nil
 }
+
+// LeakedMounts reports the mounts left under the bundle of the deleted
+// container.
+func (s *Service) LeakedMounts() runsctypes.LeakedMounts {
+	return s.mountWatchdog.Stats()
+}
+
+// IOStats returns the io byte counters of the container and its exec
+// processes.
+func (s *Service) IOStats() []runsctypes.IOStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []runsctypes.IOStats
+	for _, p := range s.processes {
+		if c, ok := p.(proc.IOCounter); ok {
+			out = append(out, c.IOStats())
+		}
+	}
+	return out
+}
+
+// StartLatency returns the creation and start phases of the container
+// recorded so far, nil before the container is created.
+func (s *Service) StartLatency() *runsctypes.StartLatency {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ip, ok := s.processes[s.id].(*proc.Init); ok {
+		return ip.StartLatency()
+	}
+	return nil
+}
+
+// RunscQueue returns the time the runsc commands of the container waited for
+// the runsc limits, nil before the container is created.
+func (s *Service) RunscQueue() []runsc.QueueStat {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ip, ok := s.processes[s.id].(*proc.Init); ok {
+		return ip.Runtime().Limiter.Stats()
+	}
+	return nil
+}
+
+// dryRun publishes how the container would be created instead of creating
+// it, and returns the error Create fails with.
+func (s *Service) dryRun(ctx context.Context, p *proc.Init, r *proc.CreateConfig) error {
+	if p.CleanupWorkDir {
+		defer os.RemoveAll(p.WorkDir)
+	}
+	d, err := p.DryRun(r)
+	if err != nil {
+		return err
+	}
+	log.G(ctx).WithField("command", d.Command).Info("Dry run of container")
+	s.publish(d)
+	return proc.DryRunError(d)
+}
+
+// verifyRuntime checks that the runtime binary of p is runsc and supports the
+// file access flags in use. A mismatch is published as an event, and fails
+// Create in strict mode.
+func (s *Service) verifyRuntime(ctx context.Context, id string, p *proc.Init) error {
+	version, err := proc.VerifyRuntime(ctx, p.Runtime())
+	if err == nil {
+		if reason := shimversion.CheckRunsc(version); reason != "" {
+			log.G(ctx).WithField("runsc", version).Warn(reason)
+		}
+		return utils.CheckFlagReleases(p.Runtime().Flags(), version)
+	}
+	s.publish(&runsctypes.RuntimeMismatch{
+		ContainerID: id,
+		Runtime:     p.Runtime().Command,
+		Reason:      err.Error(),
+		Strict:      s.config.Strict,
+		Timestamp:   time.Now(),
+	})
+	if s.config.Strict {
+		return errors.Wrapf(errdefs.ErrFailedPrecondition, "runtime verification failed: %v", err)
+	}
+	log.G(ctx).WithError(err).Warn("Runtime binary is not verified as runsc")
+	return nil
+}
+
+// publishRemoved publishes the files removed when the container was deleted.
+func (s *Service) publishRemoved(p rproc.Process) {
+	i, ok := p.(*proc.Init)
+	if !ok || len(i.RemovedPaths()) == 0 {
+		return
+	}
+	s.publish(&runsctypes.ArtifactsRemoved{
+		ContainerID: i.ID(),
+		Paths:       i.RemovedPaths(),
+		Timestamp:   time.Now(),
+	})
+}
+
+// seccompRewritten logs and publishes the changes made to the seccomp
+// profile of the container for gVisor.
+func (s *Service) seccompRewritten(ctx context.Context, id string, changes []string) {
+	log.G(ctx).WithField("changes", changes).Warnf("Rewrote seccomp profile of container %q for gVisor", id)
+	s.publish(&runsctypes.SeccompRewritten{
+		ContainerID: id,
+		Changes:     changes,
+		Timestamp:   time.Now(),
+	})
+}
+
+// startSpan starts the span of a request about the exec process execID of
+// the container, or the container itself when execID is the container id.
+func (s *Service) startSpan(ctx context.Context, name, containerID, execID string, attrs ...tracing.Attribute) (context.Context, *tracing.Span) {
+	attrs = append(attrs,
+		tracing.Attr(tracing.ContainerIDKey, containerID),
+		tracing.Attr(tracing.NamespaceKey, s.config.Namespace),
+	)
+	if execID != "" && execID != containerID {
+		attrs = append(attrs, tracing.Attr("exec.id", execID))
+	}
+	return tracing.Start(ctx, "shim."+name, attrs...)
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"context"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/log"
+	rproc "github.com/containerd/containerd/runtime/proc"
+	shimapi "github.com/containerd/containerd/runtime/v1/shim/v1"
+	ptypes "github.com/gogo/protobuf/types"
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// TeardownPolicy is how the processes of the shim are stopped when the shim
+// is terminated.
+type TeardownPolicy string
+
+const (
+	// TeardownKill kills the processes right away.
+	TeardownKill TeardownPolicy = "kill"
+	// TeardownWait gives the processes the teardown timeout to exit before
+	// killing them.
+	TeardownWait TeardownPolicy = "wait"
+)
+
+// teardownExitTimeout bounds waiting for killed processes to exit and for the
+// final events to be published.
+const teardownExitTimeout = 10 * time.Second
+
+// TeardownConfig configures the teardown of the shim.
+type TeardownConfig struct {
+	// Policy defaults to TeardownKill.
+	Policy TeardownPolicy
+	// Timeout is how long TeardownWait waits for the processes to exit.
+	Timeout time.Duration
+}
+
+// Validate checks the teardown config.
+func (c TeardownConfig) Validate() error {
+	switch c.Policy {
+	case "", TeardownKill, TeardownWait:
+	default:
+		return errors.Errorf("unknown teardown policy %q", c.Policy)
+	}
+	if c.Policy == TeardownWait && c.Timeout <= 0 {
+		return errors.New("teardown timeout must be positive with the wait policy")
+	}
+	return nil
+}
+
+// Teardown stops the container of the shim following the teardown policy,
+// deletes it and publishes the remaining events, including the final
+// TaskExit events. The service can't be used afterwards.
+func (s *Service) Teardown(ctx context.Context) error {
+	var err error
+	if p, _ := s.getInitProcess(); p != nil {
+		err = s.stopInit(ctx, p)
+		if _, derr := s.Delete(ctx, &ptypes.Empty{}); derr != nil && err == nil {
+			err = errors.Wrap(derr, "delete container")
+		}
+	} else {
+		s.platform.Close()
+	}
+	s.events.Close()
+	select {
+	case <-s.forwarded:
+	case <-time.After(teardownExitTimeout):
+		if err == nil {
+			err = errors.New("timed out publishing the remaining events")
+		}
+	}
+	return err
+}
+
+// stopInit waits for or kills the init process and its exec processes.
+func (s *Service) stopInit(ctx context.Context, p rproc.Process) error {
+	s.mu.Lock()
+	teardown := s.config.Teardown
+	s.mu.Unlock()
+	if teardown.Policy == TeardownWait {
+		if waitExit(p, teardown.Timeout) {
+			return nil
+		}
+		log.G(ctx).Warnf("Container did not exit within %v, killing it", teardown.Timeout)
+	}
+	if _, err := s.Kill(ctx, &shimapi.KillRequest{
+		Signal: uint32(unix.SIGKILL),
+		All:    true,
+	}); err != nil && !errdefs.IsNotFound(errdefs.FromGRPC(err)) {
+		log.G(ctx).WithError(err).Warn("Failed to kill container")
+	}
+	if !waitExit(p, teardownExitTimeout) {
+		return errors.Errorf("container did not exit within %v of being killed", teardownExitTimeout)
+	}
+	return nil
+}
+
+// waitExit waits up to timeout for p to exit, and reports whether it did.
+// Processes that were never started don't exit and are left to Delete.
+func waitExit(p rproc.Process, timeout time.Duration) bool {
+	if status, err := p.Status(context.Background()); err == nil && status == "created" {
+		return true
+	}
+	exited := make(chan struct{})
+	go func() {
+		p.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
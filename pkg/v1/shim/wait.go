@@ -0,0 +1,76 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shim
+
+import (
+	"context"
+
+	rproc "github.com/containerd/containerd/runtime/proc"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// WaitAsync registers interest in the exit of the process of req, the
+// container when its exec id is empty, and returns right away. A WaitResult
+// event carrying the token of req is published once the process exits, or
+// right away if it already did, so that clients don't hold a Wait request
+// per process.
+func (s *Service) WaitAsync(ctx context.Context, req *runsctypes.WaitAsyncRequest) error {
+	id, token := req.ExecID, req.Token
+	if id == "" {
+		id = s.id
+	}
+	p, err := s.getExecProcess(id)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	exited := !p.ExitedAt().IsZero()
+	if !exited {
+		s.waiters[id] = append(s.waiters[id], token)
+	}
+	s.mu.Unlock()
+	if exited {
+		s.publish(s.waitResult(p, token))
+	}
+	return nil
+}
+
+// publishWaitResults publishes the results of the WaitAsync requests for
+// the exited process p.
+func (s *Service) publishWaitResults(p rproc.Process) {
+	s.mu.Lock()
+	tokens := s.waiters[p.ID()]
+	delete(s.waiters, p.ID())
+	s.mu.Unlock()
+	for _, token := range tokens {
+		s.publish(s.waitResult(p, token))
+	}
+}
+
+func (s *Service) waitResult(p rproc.Process, token string) *runsctypes.WaitResult {
+	r := &runsctypes.WaitResult{
+		ContainerID: s.id,
+		Token:       token,
+		ExitStatus:  uint32(p.ExitStatus()),
+		ExitedAt:    p.ExitedAt(),
+	}
+	if p.ID() != s.id {
+		r.ExecID = p.ID()
+	}
+	return r
+}
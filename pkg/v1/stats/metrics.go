@@ -0,0 +1,98 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stats
+
+import (
+	"github.com/containerd/cgroups"
+	runc "github.com/containerd/go-runc"
+)
+
+// ToMetrics converts the stats reported by runsc to cgroup metrics.
+func ToMetrics(s *runc.Stats) *cgroups.Metrics {
+	return &cgroups.Metrics{
+		Hugetlb: toHugetlb(s.Hugetlb),
+		Pids: &cgroups.PidsStat{
+			Current: s.Pids.Current,
+			Limit:   s.Pids.Limit,
+		},
+		CPU: &cgroups.CPUStat{
+			Usage: &cgroups.CPUUsage{
+				Total:  s.Cpu.Usage.Total,
+				Kernel: s.Cpu.Usage.Kernel,
+				User:   s.Cpu.Usage.User,
+				PerCPU: s.Cpu.Usage.Percpu,
+			},
+			Throttling: &cgroups.Throttle{
+				Periods:          s.Cpu.Throttling.Periods,
+				ThrottledPeriods: s.Cpu.Throttling.ThrottledPeriods,
+				ThrottledTime:    s.Cpu.Throttling.ThrottledTime,
+			},
+		},
+		Memory: &cgroups.MemoryStat{
+			Cache:     s.Memory.Cache,
+			Usage:     toMemoryEntry(s.Memory.Usage),
+			Swap:      toMemoryEntry(s.Memory.Swap),
+			Kernel:    toMemoryEntry(s.Memory.Kernel),
+			KernelTCP: toMemoryEntry(s.Memory.KernelTCP),
+		},
+		Blkio: &cgroups.BlkIOStat{
+			IoServiceBytesRecursive: toBlkio(s.Blkio.IoServiceBytesRecursive),
+			IoServicedRecursive:     toBlkio(s.Blkio.IoServicedRecursive),
+			IoQueuedRecursive:       toBlkio(s.Blkio.IoQueuedRecursive),
+			IoServiceTimeRecursive:  toBlkio(s.Blkio.IoServiceTimeRecursive),
+			IoWaitTimeRecursive:     toBlkio(s.Blkio.IoWaitTimeRecursive),
+			IoMergedRecursive:       toBlkio(s.Blkio.IoMergedRecursive),
+			IoTimeRecursive:         toBlkio(s.Blkio.IoTimeRecursive),
+			SectorsRecursive:        toBlkio(s.Blkio.SectorsRecursive),
+		},
+	}
+}
+
+func toMemoryEntry(e runc.MemoryEntry) *cgroups.MemoryEntry {
+	return &cgroups.MemoryEntry{
+		Limit:   e.Limit,
+		Usage:   e.Usage,
+		Max:     e.Max,
+		Failcnt: e.Failcnt,
+	}
+}
+
+func toHugetlb(h map[string]runc.Hugetlb) []*cgroups.HugetlbStat {
+	var out []*cgroups.HugetlbStat
+	for pagesize, e := range h {
+		out = append(out, &cgroups.HugetlbStat{
+			Usage:    e.Usage,
+			Max:      e.Max,
+			Failcnt:  e.Failcnt,
+			Pagesize: pagesize,
+		})
+	}
+	return out
+}
+
+func toBlkio(entries []runc.BlkioEntry) []*cgroups.BlkIOEntry {
+	var out []*cgroups.BlkIOEntry
+	for _, e := range entries {
+		out = append(out, &cgroups.BlkIOEntry{
+			Op:    e.Op,
+			Major: e.Major,
+			Minor: e.Minor,
+			Value: e.Value,
+		})
+	}
+	return out
+}
@@ -0,0 +1,257 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package stats samples the resource usage of a sandbox.
+package stats
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/containerd/cgroups"
+	"github.com/containerd/containerd/log"
+	runc "github.com/containerd/go-runc"
+
+	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/cgroup"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+const (
+	// DefaultInterval is the sampling interval used when none is configured.
+	DefaultInterval = 10 * time.Second
+	// DefaultMemoryThreshold is the fraction of the memory limit above which
+	// a MemoryThreshold event is published.
+	DefaultMemoryThreshold = 0.9
+)
+
+// Config is the sampler configuration.
+type Config struct {
+	// Interval between two samples. Zero selects DefaultInterval, a
+	// negative interval disables sampling.
+	Interval time.Duration
+	// MemoryThreshold is the fraction of the memory limit that triggers
+	// a MemoryThreshold event. Zero selects DefaultMemoryThreshold.
+	MemoryThreshold float64
+	// Watchdog configures the hard caps enforced on the sandbox.
+	Watchdog WatchdogConfig
+	// Stream reads the stats from a long running `runsc events` instead
+	// of running `runsc events --stats` for every sample. The stream also
+	// reports OOMs of the sandbox.
+	Stream bool
+}
+
+// Enabled returns whether sampling is enabled.
+func (c Config) Enabled() bool {
+	return c.Interval >= 0
+}
+
+// Sample is the resource usage of a sandbox at a point in time.
+type Sample struct {
+	Timestamp time.Time
+	// Stats are the container stats reported by `runsc events --stats`.
+	Stats *runc.Stats
+	// Host are the metrics of the host cgroup the sandbox runs in.
+	Host *cgroups.Metrics
+}
+
+// Metrics returns the sample as cgroup metrics, preferring the stats
+// reported by runsc over the host cgroup.
+func (s *Sample) Metrics() *cgroups.Metrics {
+	if s.Stats != nil {
+		return ToMetrics(s.Stats)
+	}
+	return s.Host
+}
+
+// memory returns the memory usage and limit of the sample.
+func (s *Sample) memory() (usage, limit uint64) {
+	if s.Stats != nil {
+		usage = s.Stats.Memory.Usage.Usage
+		limit = s.Stats.Memory.Usage.Limit
+	}
+	if s.Host != nil && s.Host.Memory != nil && s.Host.Memory.Usage != nil {
+		if usage == 0 {
+			usage = s.Host.Memory.Usage.Usage
+		}
+		if limit == 0 {
+			limit = s.Host.Memory.Usage.Limit
+		}
+	}
+	return usage, limit
+}
+
+// Sampler periodically samples the resource usage of a sandbox, caches the
+// latest sample, publishes memory threshold crossings and enforces the
+// watchdog caps.
+type Sampler struct {
+	id      string
+	pid     int
+	runtime *runsc.Runsc
+	config  Config
+	publish func(interface{})
+
+	mu       sync.Mutex
+	last     *Sample
+	cgroup   hostCgroup
+	exceeded bool
+
+	// killer, exceededSince and killed are the state of the watchdog.
+	killer        Killer
+	exceededSince time.Time
+	killed        bool
+}
+
+// NewSampler returns a sampler for the container id whose sandbox process is
+// pid. Events are handed to publish.
+func NewSampler(id string, pid int, runtime *runsc.Runsc, config Config, publish func(interface{})) *Sampler {
+	if config.Interval == 0 {
+		config.Interval = DefaultInterval
+	}
+	if config.MemoryThreshold == 0 {
+		config.MemoryThreshold = DefaultMemoryThreshold
+	}
+	if config.Watchdog.Grace == 0 {
+		config.Watchdog.Grace = DefaultWatchdogGrace
+	}
+	return &Sampler{
+		id:      id,
+		pid:     pid,
+		runtime: runtime,
+		config:  config,
+		publish: publish,
+	}
+}
+
+// Run samples until the context is cancelled.
+func (s *Sampler) Run(ctx context.Context) {
+	if s.config.Stream {
+		s.stream(ctx)
+		return
+	}
+	t := time.NewTicker(s.config.Interval)
+	defer t.Stop()
+	for {
+		s.Sample(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Latest returns the most recent sample, or nil if none was taken yet.
+func (s *Sampler) Latest() *Sample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
+
+// Sample takes a new sample and caches it.
+func (s *Sampler) Sample(ctx context.Context) *Sample {
+	stats, err := s.runtime.Stats(ctx, s.id)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		log.G(ctx).WithError(err).WithField("id", s.id).Debug("failed to get runsc stats")
+	}
+	return s.record(ctx, stats)
+}
+
+// record completes the runsc stats with the host cgroup metrics, caches the
+// sample and checks it against the thresholds and caps.
+func (s *Sampler) record(ctx context.Context, stats *runc.Stats) *Sample {
+	sample := &Sample{
+		Timestamp: time.Now(),
+		Stats:     stats,
+	}
+	if cg := s.hostCgroup(); cg != nil {
+		host, err := cg.Stat(cgroups.IgnoreNotExist)
+		if err != nil {
+			log.G(ctx).WithError(err).WithField("id", s.id).Debug("failed to get host cgroup stats")
+		}
+		sample.Host = host
+	}
+	if sample.Stats == nil && sample.Host == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	s.last = sample
+	s.mu.Unlock()
+	s.checkMemory(sample)
+	s.checkWatchdog(ctx, sample)
+	return sample
+}
+
+// hostCgroup is the cgroup of the sandbox, of either hierarchy.
+type hostCgroup interface {
+	Stat(...cgroups.ErrorHandler) (*cgroups.Metrics, error)
+}
+
+func (s *Sampler) hostCgroup() hostCgroup {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cgroup == nil && s.pid > 0 {
+		cg, err := loadCgroup(s.pid)
+		if err != nil {
+			return nil
+		}
+		s.cgroup = cg
+	}
+	return s.cgroup
+}
+
+// loadCgroup loads the cgroup of pid, from the unified hierarchy on hosts
+// without the v1 controllers.
+func loadCgroup(pid int) (hostCgroup, error) {
+	if cgroup.DetectMode() == cgroup.Unified {
+		path, err := cgroup.PidPath(pid)
+		if err != nil {
+			return nil, err
+		}
+		return cgroup.LoadV2(path)
+	}
+	return cgroups.Load(cgroups.V1, cgroups.PidPath(pid))
+}
+
+func (s *Sampler) checkMemory(sample *Sample) {
+	usage, limit := sample.memory()
+	// Unlimited cgroups report a huge limit, ignore those.
+	if limit == 0 || limit >= 1<<62 {
+		return
+	}
+	exceeded := float64(usage) >= s.config.MemoryThreshold*float64(limit)
+
+	s.mu.Lock()
+	changed := exceeded != s.exceeded
+	s.exceeded = exceeded
+	s.mu.Unlock()
+	if !changed || s.publish == nil {
+		return
+	}
+	s.publish(&runsctypes.MemoryThreshold{
+		ContainerID: s.id,
+		Usage:       usage,
+		Limit:       limit,
+		Threshold:   s.config.MemoryThreshold,
+		Exceeded:    exceeded,
+		Timestamp:   sample.Timestamp,
+	})
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stats
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+)
+
+// MetricsContentType is the content type of the Prometheus text exposition
+// format.
+const MetricsContentType = "text/plain; version=0.0.4"
+
+// labelEscaper escapes label values in the text exposition format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// LabelMetrics adds the label name="value" to each sample of metrics in the
+// Prometheus text exposition format, e.g. to tell the sentry metrics of the
+// containers served by a shim apart. Comments, such as HELP and TYPE, are
+// kept as is.
+func LabelMetrics(metrics []byte, name, value string) []byte {
+	label := name + `="` + labelEscaper.Replace(value) + `"`
+	var out bytes.Buffer
+	s := bufio.NewScanner(bytes.NewReader(metrics))
+	s.Buffer(make([]byte, 0, 64<<10), 1<<20)
+	for s.Scan() {
+		line := s.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			out.WriteString(line)
+			out.WriteByte('\n')
+			continue
+		}
+		// The metric name ends at the labels or the value.
+		i := strings.IndexAny(trimmed, "{ \t")
+		switch {
+		case i < 0:
+			// A sample without a value, keep it for the scraper to
+			// reject.
+			out.WriteString(trimmed)
+		case trimmed[i] == '{':
+			out.WriteString(trimmed[:i+1])
+			out.WriteString(label)
+			if rest := trimmed[i+1:]; !strings.HasPrefix(strings.TrimSpace(rest), "}") {
+				out.WriteByte(',')
+			}
+			out.WriteString(trimmed[i+1:])
+		default:
+			out.WriteString(trimmed[:i])
+			out.WriteString("{" + label + "}")
+			out.WriteString(trimmed[i:])
+		}
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
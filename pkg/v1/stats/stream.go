@@ -0,0 +1,94 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stats
+
+import (
+	"context"
+	"time"
+
+	eventstypes "github.com/containerd/containerd/api/events"
+	"github.com/containerd/containerd/log"
+)
+
+const (
+	// minRestartDelay is the delay before the events stream is restarted
+	// after it died, doubled on every restart that yields no event.
+	minRestartDelay = time.Second
+	// maxRestartDelay bounds the delay between restarts of the stream.
+	maxRestartDelay = 30 * time.Second
+)
+
+// stream samples the stats reported by `runsc events --interval` until the
+// context is cancelled, restarting the stream whenever it dies, e.g. when
+// runsc crashed.
+func (s *Sampler) stream(ctx context.Context) {
+	interval := s.config.Interval
+	// runsc takes the interval in whole seconds.
+	if interval < time.Second {
+		interval = time.Second
+	}
+	delay := minRestartDelay
+	for {
+		if s.streamOnce(ctx, interval) {
+			delay = minRestartDelay
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.G(ctx).WithField("id", s.id).Debugf("runsc events stream ended, restarting in %s", delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxRestartDelay {
+			delay = maxRestartDelay
+		}
+	}
+}
+
+// streamOnce runs a stream until it ends, and returns whether it reported
+// any event.
+func (s *Sampler) streamOnce(ctx context.Context, interval time.Duration) bool {
+	events, err := s.runtime.Events(ctx, s.id, interval)
+	if err != nil {
+		log.G(ctx).WithError(err).WithField("id", s.id).Warn("failed to start runsc events")
+		return false
+	}
+	received := false
+	for e := range events {
+		switch e.Type {
+		case "stats":
+			if e.Stats != nil {
+				received = true
+				s.record(ctx, e.Stats)
+			}
+		case "oom":
+			received = true
+			if s.publish != nil {
+				s.publish(&eventstypes.TaskOOM{
+					ContainerID: s.id,
+				})
+			}
+		case "error":
+			if ctx.Err() == nil {
+				log.G(ctx).WithError(e.Err).WithField("id", s.id).Debug("failed to decode runsc event")
+			}
+		}
+	}
+	return received
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stats
+
+import (
+	"context"
+	"time"
+
+	"github.com/containerd/containerd/log"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+const (
+	// DefaultWatchdogGrace is how long a cap may be exceeded before the
+	// sandbox is killed when no grace period is configured.
+	DefaultWatchdogGrace = 30 * time.Second
+	// WatchdogExitStatus is the exit status reported for sandboxes killed by
+	// the watchdog. It differs from the 137 of other SIGKILLs, such as OOM
+	// kills, so that watchdog kills can be told apart.
+	WatchdogExitStatus = 250
+)
+
+// WatchdogConfig configures the hard caps enforced on the host cgroup of a
+// sandbox. The watchdog runs on the samples of the sampler, so it is off
+// when sampling is disabled.
+type WatchdogConfig struct {
+	// MemoryLimit is the cap on the memory usage in bytes. Zero disables it.
+	MemoryLimit uint64
+	// PidsLimit is the cap on the number of host tasks. Zero disables it.
+	PidsLimit uint64
+	// Grace is how long a cap may be exceeded before the sandbox is killed.
+	// Zero selects DefaultWatchdogGrace.
+	Grace time.Duration
+}
+
+// Enabled returns whether any cap is configured.
+func (c WatchdogConfig) Enabled() bool {
+	return c.MemoryLimit > 0 || c.PidsLimit > 0
+}
+
+// Killer kills the sandbox on behalf of the watchdog, reporting status as
+// its exit status.
+type Killer func(ctx context.Context, status int) error
+
+// SetKiller sets the function called to kill a sandbox exceeding its caps.
+// The watchdog is inactive until a killer is set.
+func (s *Sampler) SetKiller(k Killer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.killer = k
+}
+
+// hostUsage returns the memory usage and task count of the sample, preferring
+// the host cgroup over the stats reported by runsc.
+func (s *Sample) hostUsage() (memory, pids uint64) {
+	if h := s.Host; h != nil {
+		if h.Memory != nil && h.Memory.Usage != nil {
+			memory = h.Memory.Usage.Usage
+		}
+		if h.Pids != nil {
+			pids = h.Pids.Current
+		}
+	}
+	if st := s.Stats; st != nil {
+		if memory == 0 {
+			memory = st.Memory.Usage.Usage
+		}
+		if pids == 0 {
+			pids = st.Pids.Current
+		}
+	}
+	return memory, pids
+}
+
+// checkWatchdog kills the sandbox once a cap has been exceeded for longer
+// than the grace period.
+func (s *Sampler) checkWatchdog(ctx context.Context, sample *Sample) {
+	c := s.config.Watchdog
+	if !c.Enabled() {
+		return
+	}
+	memory, pids := sample.hostUsage()
+	var resource string
+	var usage, limit uint64
+	switch {
+	case c.MemoryLimit > 0 && memory > c.MemoryLimit:
+		resource, usage, limit = "memory", memory, c.MemoryLimit
+	case c.PidsLimit > 0 && pids > c.PidsLimit:
+		resource, usage, limit = "pids", pids, c.PidsLimit
+	}
+
+	s.mu.Lock()
+	if resource == "" {
+		s.exceededSince = time.Time{}
+		s.mu.Unlock()
+		return
+	}
+	if s.exceededSince.IsZero() {
+		s.exceededSince = sample.Timestamp
+	}
+	since, killer := s.exceededSince, s.killer
+	kill := !s.killed && killer != nil && sample.Timestamp.Sub(since) >= c.Grace
+	if kill {
+		s.killed = true
+	}
+	s.mu.Unlock()
+	if !kill {
+		return
+	}
+
+	log.G(ctx).WithField("id", s.id).Warnf("Killing sandbox, %s usage %d exceeded cap %d since %v", resource, usage, limit, since)
+	if err := killer(ctx, WatchdogExitStatus); err != nil {
+		log.G(ctx).WithError(err).WithField("id", s.id).Error("watchdog failed to kill sandbox")
+		s.mu.Lock()
+		s.killed = false
+		s.mu.Unlock()
+		return
+	}
+	if s.publish != nil {
+		s.publish(&runsctypes.WatchdogKill{
+			ContainerID:   s.id,
+			Resource:      resource,
+			Usage:         usage,
+			Limit:         limit,
+			ExceededSince: since,
+			ExitStatus:    WatchdogExitStatus,
+			Timestamp:     time.Now(),
+		})
+	}
+}
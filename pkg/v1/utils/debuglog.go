@@ -0,0 +1,87 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+
+	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
+)
+
+// CollectDebugLogs writes a gzipped tarball of the runsc debug logs of the
+// container id of namespace to w. config is the runsc config the container
+// was created with, before its variables were filled in.
+func CollectDebugLogs(w io.Writer, namespace, id string, config map[string]string) error {
+	dir, files := runsc.ContainerDebugLogs(namespace, id, config)
+	if dir == "" {
+		return errors.New("runsc debug-log is not configured")
+	}
+	// Only the logs are archived, not the rest of their directory.
+	return WriteTarGz(w, dir, files)
+}
+
+// WriteTarGz writes a gzipped tarball of the regular files among files to
+// w, named relative to dir.
+func WriteTarGz(w io.Writer, dir string, files []string) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	for _, path := range files {
+		if err := writeTarFile(tw, dir, path); err != nil {
+			return errors.Wrapf(err, "failed to archive %q", path)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func writeTarFile(tw *tar.Writer, dir, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	name, err := filepath.Rel(dir, path)
+	if err != nil {
+		return err
+	}
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	// The log may still be written to, only copy what the header claims.
+	_, err = io.CopyN(tw, f, hdr.Size)
+	return err
+}
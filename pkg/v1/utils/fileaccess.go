@@ -0,0 +1,155 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+)
+
+const (
+	// FileAccessAnnotation overrides the file access of the root filesystem
+	// for a pod, either "exclusive" or "shared".
+	FileAccessAnnotation = "dev.gvisor.file-access"
+	// FileAccessMountsAnnotation overrides the file access of the mounts of
+	// a pod, either "exclusive" or "shared".
+	FileAccessMountsAnnotation = "dev.gvisor.file-access-mounts"
+	// DirectFSAnnotation overrides whether the sandbox of a pod uses
+	// directfs, "true" or "false".
+	DirectFSAnnotation = "dev.gvisor.directfs"
+	// LisafsAnnotation overrides whether the sandbox of a pod uses lisafs,
+	// "true" or "false".
+	LisafsAnnotation = "dev.gvisor.lisafs"
+	// MountHintPrefix prefixes the gVisor mount hint annotations, which
+	// describe volumes shared between the containers of a pod, e.g.
+	// dev.gvisor.spec.mount.<name>.share.
+	MountHintPrefix = "dev.gvisor.spec.mount."
+)
+
+// FileAccess configures how the sandbox caches files. Files of "exclusive"
+// filesystems are cached aggressively; "shared" filesystems are revalidated
+// so that changes made outside the sandbox are seen.
+type FileAccess struct {
+	// Root is the file access of the root filesystem.
+	Root string
+	// Mounts is the file access of the mounts, such as emptyDir and other
+	// shared volumes.
+	Mounts string
+	// DirectFS lets the sentry access the container filesystem directly
+	// instead of through the gofer. Nil keeps the runsc default.
+	DirectFS *bool
+	// Lisafs selects the lisafs protocol between the sentry and the gofer.
+	// Nil keeps the runsc default.
+	Lisafs *bool
+}
+
+// flagReleases are the first runsc releases supporting the file access
+// flags.
+var flagReleases = map[string]string{
+	"lisafs":   "20220228",
+	"directfs": "20230306",
+}
+
+// Validate checks the file access values.
+func (f FileAccess) Validate() error {
+	if err := validateFileAccess(f.Root); err != nil {
+		return err
+	}
+	return validateFileAccess(f.Mounts)
+}
+
+func validateFileAccess(v string) error {
+	switch v {
+	case "", "exclusive", "shared":
+		return nil
+	}
+	return errors.Wrapf(errdefs.ErrInvalidArgument, "invalid file access %q", v)
+}
+
+// FileAccessFlags returns the runsc flags of config with the file access
+// flags set. Pod annotations take precedence over f, and are only honored
+// on the sandbox container as the flags apply to the whole sandbox.
+func FileAccessFlags(config map[string]string, spec *specs.Spec, f FileAccess) (map[string]string, error) {
+	if IsSandbox(spec) {
+		if v, ok := spec.Annotations[FileAccessAnnotation]; ok {
+			f.Root = v
+		}
+		if v, ok := spec.Annotations[FileAccessMountsAnnotation]; ok {
+			f.Mounts = v
+		}
+		for _, a := range []struct {
+			key string
+			v   **bool
+		}{
+			{DirectFSAnnotation, &f.DirectFS},
+			{LisafsAnnotation, &f.Lisafs},
+		} {
+			s, ok := spec.Annotations[a.key]
+			if !ok {
+				continue
+			}
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return nil, errors.Wrapf(errdefs.ErrInvalidArgument, "invalid %s annotation %q", a.key, s)
+			}
+			*a.v = &b
+		}
+	}
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	if f.Root == "" && f.Mounts == "" && f.DirectFS == nil && f.Lisafs == nil {
+		return config, nil
+	}
+	flags := make(map[string]string, len(config)+2)
+	for k, v := range config {
+		flags[k] = v
+	}
+	if f.Root != "" {
+		flags["file-access"] = f.Root
+	}
+	if f.Mounts != "" {
+		flags["file-access-mounts"] = f.Mounts
+	}
+	if f.DirectFS != nil {
+		flags["directfs"] = strconv.FormatBool(*f.DirectFS)
+	}
+	if f.Lisafs != nil {
+		flags["lisafs"] = strconv.FormatBool(*f.Lisafs)
+	}
+	return flags, nil
+}
+
+// CheckFlagReleases checks that the runsc release reporting version supports
+// the file access flags set in flags. Versions other than releases, such as
+// development builds, are not checked.
+func CheckFlagReleases(flags map[string]string, version string) error {
+	if !strings.HasPrefix(version, "release-") {
+		return nil
+	}
+	release := strings.SplitN(strings.TrimPrefix(version, "release-"), ".", 2)[0]
+	for flag, first := range flagReleases {
+		if _, ok := flags[flag]; ok && release < first {
+			return errors.Wrapf(errdefs.ErrFailedPrecondition, "runsc %s doesn't support --%s, first released in release-%s", version, flag, first)
+		}
+	}
+	return nil
+}
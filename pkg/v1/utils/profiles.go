@@ -0,0 +1,148 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/containerd/containerd/errdefs"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+
+	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
+)
+
+// PerfProfileAnnotation selects the performance profile of the sandbox of a
+// pod, e.g. "throughput".
+const PerfProfileAnnotation = "dev.gvisor.perf-profile"
+
+// PerfProfiles are the built-in performance profiles, curated sets of runsc
+// flags. None of them gives the sandbox more access to the host.
+var PerfProfiles = map[string]map[string]string{
+	// throughput spreads the network stack over more channels and
+	// batches packets, for services moving a lot of data.
+	"throughput": {
+		"num-network-channels": "4",
+		"gso":                  "true",
+		"software-gso":         "true",
+		"buffer-pooling":       "true",
+		"overlay2":             "root:memory",
+	},
+	// latency avoids batching packets, which trades throughput for
+	// shorter round trips, and keeps the root overlay in memory.
+	"latency": {
+		"num-network-channels": "1",
+		"gso":                  "false",
+		"software-gso":         "false",
+		"overlay2":             "root:memory",
+	},
+	// dense lowers the footprint of each sandbox, for nodes packed with
+	// small pods: the root overlay is backed by a file in the rootfs
+	// instead of memory.
+	"dense": {
+		"num-network-channels": "1",
+		"buffer-pooling":       "true",
+		"overlay2":             "root:self",
+		"host-uds":             "none",
+	},
+}
+
+// PerfProfile configures the performance profiles of sandboxes.
+type PerfProfile struct {
+	// Default is the profile of the sandboxes not selecting one with the
+	// PerfProfileAnnotation. Empty applies no profile.
+	Default string
+	// Profiles are added to the built-in profiles, replacing those of the
+	// same name.
+	Profiles map[string]map[string]string
+}
+
+// CheckFlags checks the flags of the configured profiles with
+// runsc.ValidateConfig, except those in unchecked. Like for the runsc
+// config, the error is only worth a warning.
+func (p PerfProfile) CheckFlags(unchecked []string) error {
+	for name, flags := range p.Profiles {
+		if err := runsc.ValidateConfig(flags, unchecked); err != nil {
+			return errors.Wrapf(err, "performance profile %q", name)
+		}
+	}
+	return nil
+}
+
+// Validate checks that the default profile exists.
+func (p PerfProfile) Validate() error {
+	if p.Default == "" {
+		return nil
+	}
+	_, err := p.flags(p.Default)
+	return err
+}
+
+// flags returns the runsc flags of the profile name.
+func (p PerfProfile) flags(name string) (map[string]string, error) {
+	if flags, ok := p.Profiles[name]; ok {
+		return flags, nil
+	}
+	if flags, ok := PerfProfiles[name]; ok {
+		return flags, nil
+	}
+	names := make([]string, 0, len(PerfProfiles)+len(p.Profiles))
+	for n := range PerfProfiles {
+		names = append(names, n)
+	}
+	for n := range p.Profiles {
+		if _, ok := PerfProfiles[n]; !ok {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	return nil, errors.Wrapf(errdefs.ErrInvalidArgument, "unknown performance profile %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+// ProfileFlags returns the runsc flags of config with the flags of the
+// performance profile of the container in bundle set, which take
+// precedence. The PerfProfileAnnotation takes precedence over p. As the
+// flags apply to the whole sandbox, subcontainers get the profile annotated
+// on their sandbox container. If its spec can't be read, they fall back to
+// their own annotation, which CRI copies from the pod for the
+// pod_annotations of the runtime.
+func ProfileFlags(config map[string]string, bundle string, spec *specs.Spec, p PerfProfile) (map[string]string, error) {
+	name := p.Default
+	annotated, err := SandboxSpec(bundle, spec)
+	if err != nil {
+		annotated = spec
+	}
+	if v, ok := annotated.Annotations[PerfProfileAnnotation]; ok {
+		name = v
+	}
+	if name == "" {
+		return config, nil
+	}
+	profile, err := p.flags(name)
+	if err != nil {
+		return nil, err
+	}
+	flags := make(map[string]string, len(config)+len(profile))
+	for k, v := range config {
+		flags[k] = v
+	}
+	for k, v := range profile {
+		flags[k] = v
+	}
+	return flags, nil
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containerd/cri/pkg/annotations"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func writeSpec(t *testing.T, bundle string, spec *specs.Spec) {
+	if err := os.MkdirAll(bundle, 0755); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(bundle, "config.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProfileFlagsSubcontainer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profiles-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeSpec(t, filepath.Join(dir, "pod"), &specs.Spec{
+		Annotations: map[string]string{
+			annotations.ContainerType: annotations.ContainerTypeSandbox,
+			PerfProfileAnnotation:     "throughput",
+		},
+	})
+	for _, tc := range []struct {
+		name    string
+		sandbox string
+		own     string
+		want    string
+	}{
+		{name: "pod profile", sandbox: "pod", want: "throughput"},
+		{name: "pod profile over own", sandbox: "pod", own: "latency", want: "throughput"},
+		{name: "own without sandbox bundle", sandbox: "gone", own: "latency", want: "latency"},
+		{name: "default", sandbox: "gone"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			spec := &specs.Spec{
+				Annotations: map[string]string{
+					annotations.ContainerType: annotations.ContainerTypeContainer,
+					annotations.SandboxID:     tc.sandbox,
+				},
+			}
+			if tc.own != "" {
+				spec.Annotations[PerfProfileAnnotation] = tc.own
+			}
+			flags, err := ProfileFlags(map[string]string{"debug": "true"}, filepath.Join(dir, "ctr"), spec, PerfProfile{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]string{"debug": "true"}
+			for k, v := range PerfProfiles[tc.want] {
+				want[k] = v
+			}
+			if len(flags) != len(want) {
+				t.Fatalf("flags %v, expected %v", flags, want)
+			}
+			for k, v := range want {
+				if flags[k] != v {
+					t.Errorf("flag %s is %q, expected %q", k, flags[k], v)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// RuntimeHandlerAnnotation is the runtime handler of the pod, i.e. the
+// handler of its Kubernetes RuntimeClass.
+const RuntimeHandlerAnnotation = "io.kubernetes.cri.runtime-handler"
+
+// Runtime overrides the runsc binary and root directory of some containers,
+// e.g. to run a canary release of gVisor next to the stable one.
+type Runtime struct {
+	// Binary is the runsc binary. Empty keeps the default binary.
+	Binary string `toml:"binary"`
+	// Root is the runsc root directory. Empty keeps the default root.
+	Root string `toml:"root"`
+}
+
+// Runtimes maps namespaces and runtime handlers to runtime overrides.
+type Runtimes struct {
+	// Namespaces are the overrides of containerd namespaces.
+	Namespaces map[string]Runtime
+	// Handlers are the overrides of runtime handlers. They take precedence
+	// over the namespace overrides.
+	Handlers map[string]Runtime
+}
+
+// Resolve returns the runsc binary and root of a container of namespace with
+// spec, given the default binary and root.
+func (r Runtimes) Resolve(namespace string, spec *specs.Spec, binary, root string) (string, string) {
+	apply := func(o Runtime) {
+		if o.Binary != "" {
+			binary = o.Binary
+		}
+		if o.Root != "" {
+			root = o.Root
+		}
+	}
+	if o, ok := r.Namespaces[namespace]; ok {
+		apply(o)
+	}
+	if spec != nil {
+		if h, ok := spec.Annotations[RuntimeHandlerAnnotation]; ok {
+			if o, ok := r.Handlers[h]; ok {
+				apply(o)
+			}
+		}
+	}
+	return binary, root
+}
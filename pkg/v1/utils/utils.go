@@ -48,3 +48,23 @@ func IsSandbox(spec *specs.Spec) bool {
 	t, ok := spec.Annotations[annotations.ContainerType]
 	return !ok || t == annotations.ContainerTypeSandbox
 }
+
+// SandboxID returns the id of the sandbox a container joins, or an empty
+// string if the container is a sandbox container.
+func SandboxID(spec *specs.Spec) string {
+	if IsSandbox(spec) {
+		return ""
+	}
+	return spec.Annotations[annotations.SandboxID]
+}
+
+// SandboxSpec returns the spec of the sandbox container of the container in
+// bundle, found next to it as containerd keeps the bundles of a namespace
+// in one directory. The spec of a sandbox container is its own.
+func SandboxSpec(bundle string, spec *specs.Spec) (*specs.Spec, error) {
+	id := SandboxID(spec)
+	if id == "" {
+		return spec, nil
+	}
+	return ReadSpec(filepath.Join(filepath.Dir(bundle), id))
+}
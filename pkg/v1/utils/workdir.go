@@ -0,0 +1,65 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// workDirPerm lets other users traverse, but not list, work directories.
+const workDirPerm = 0711
+
+// WorkDir returns the work directory of a container under root. Work
+// directories are grouped per namespace so that tenants don't share state.
+func WorkDir(root, namespace, id string) string {
+	return filepath.Join(root, namespace, id)
+}
+
+// PrepareWorkDir creates the work directory of a container and its
+// namespace directory, enforcing 0711 permissions on both.
+func PrepareWorkDir(dir string) error {
+	for _, d := range []string{filepath.Dir(dir), dir} {
+		if err := os.MkdirAll(d, workDirPerm); err != nil {
+			return errors.Wrapf(err, "create work directory %q", d)
+		}
+		if err := os.Chmod(d, workDirPerm); err != nil {
+			return errors.Wrapf(err, "chmod work directory %q", d)
+		}
+	}
+	return nil
+}
+
+// DiskUsage returns the number of bytes used by the regular files in dir.
+func DiskUsage(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
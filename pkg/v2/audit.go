@@ -0,0 +1,194 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/errdefs"
+	taskAPI "github.com/containerd/containerd/runtime/v2/task"
+	ptypes "github.com/gogo/protobuf/types"
+	"github.com/sirupsen/logrus"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/audit"
+)
+
+// auditingService validates the ids and paths of the requests sent to
+// recordingService, and records the calls changing the task in the shim
+// log.
+type auditingService struct {
+	*recordingService
+}
+
+// checkIDs checks the container id and the exec id of a request.
+func checkIDs(id, execID string) error {
+	if err := audit.CheckID("container", id); err != nil {
+		return errdefs.ToGRPC(err)
+	}
+	if err := audit.CheckExecID(execID); err != nil {
+		return errdefs.ToGRPC(err)
+	}
+	return nil
+}
+
+func (s *auditingService) State(ctx context.Context, r *taskAPI.StateRequest) (*taskAPI.StateResponse, error) {
+	if err := checkIDs(r.ID, r.ExecID); err != nil {
+		return nil, err
+	}
+	return s.recordingService.State(ctx, r)
+}
+
+func (s *auditingService) Create(ctx context.Context, r *taskAPI.CreateTaskRequest) (_ *taskAPI.CreateTaskResponse, err error) {
+	defer audit.Record(ctx, "Create", logrus.Fields{"id": r.ID, "bundle": r.Bundle, "checkpoint": r.Checkpoint}, &err)
+	if err := checkIDs(r.ID, ""); err != nil {
+		return nil, err
+	}
+	if err := audit.CheckPath("bundle", r.Bundle); err != nil {
+		return nil, errdefs.ToGRPC(err)
+	}
+	if r.Checkpoint != "" {
+		if err := audit.CheckPath("checkpoint", r.Checkpoint); err != nil {
+			return nil, errdefs.ToGRPC(err)
+		}
+	}
+	return s.recordingService.Create(ctx, r)
+}
+
+func (s *auditingService) Start(ctx context.Context, r *taskAPI.StartRequest) (_ *taskAPI.StartResponse, err error) {
+	defer audit.Record(ctx, "Start", logrus.Fields{"id": r.ID, "exec_id": r.ExecID}, &err)
+	if err := checkIDs(r.ID, r.ExecID); err != nil {
+		return nil, err
+	}
+	return s.recordingService.Start(ctx, r)
+}
+
+func (s *auditingService) Delete(ctx context.Context, r *taskAPI.DeleteRequest) (_ *taskAPI.DeleteResponse, err error) {
+	defer audit.Record(ctx, "Delete", logrus.Fields{"id": r.ID, "exec_id": r.ExecID}, &err)
+	if err := checkIDs(r.ID, r.ExecID); err != nil {
+		return nil, err
+	}
+	return s.recordingService.Delete(ctx, r)
+}
+
+func (s *auditingService) Pids(ctx context.Context, r *taskAPI.PidsRequest) (*taskAPI.PidsResponse, error) {
+	if err := checkIDs(r.ID, ""); err != nil {
+		return nil, err
+	}
+	return s.recordingService.Pids(ctx, r)
+}
+
+func (s *auditingService) Pause(ctx context.Context, r *taskAPI.PauseRequest) (_ *ptypes.Empty, err error) {
+	defer audit.Record(ctx, "Pause", logrus.Fields{"id": r.ID}, &err)
+	if err := checkIDs(r.ID, ""); err != nil {
+		return nil, err
+	}
+	return s.recordingService.Pause(ctx, r)
+}
+
+func (s *auditingService) Resume(ctx context.Context, r *taskAPI.ResumeRequest) (_ *ptypes.Empty, err error) {
+	defer audit.Record(ctx, "Resume", logrus.Fields{"id": r.ID}, &err)
+	if err := checkIDs(r.ID, ""); err != nil {
+		return nil, err
+	}
+	return s.recordingService.Resume(ctx, r)
+}
+
+func (s *auditingService) Checkpoint(ctx context.Context, r *taskAPI.CheckpointTaskRequest) (_ *ptypes.Empty, err error) {
+	defer audit.Record(ctx, "Checkpoint", logrus.Fields{"id": r.ID, "path": r.Path}, &err)
+	if err := checkIDs(r.ID, ""); err != nil {
+		return nil, err
+	}
+	if err := audit.CheckPath("checkpoint", r.Path); err != nil {
+		return nil, errdefs.ToGRPC(err)
+	}
+	return s.recordingService.Checkpoint(ctx, r)
+}
+
+func (s *auditingService) Kill(ctx context.Context, r *taskAPI.KillRequest) (_ *ptypes.Empty, err error) {
+	defer audit.Record(ctx, "Kill", logrus.Fields{"id": r.ID, "exec_id": r.ExecID, "signal": r.Signal, "all": r.All}, &err)
+	if err := checkIDs(r.ID, r.ExecID); err != nil {
+		return nil, err
+	}
+	return s.recordingService.Kill(ctx, r)
+}
+
+func (s *auditingService) Exec(ctx context.Context, r *taskAPI.ExecProcessRequest) (_ *ptypes.Empty, err error) {
+	defer audit.Record(ctx, "Exec", logrus.Fields{"id": r.ID, "exec_id": r.ExecID, "terminal": r.Terminal}, &err)
+	if err := checkIDs(r.ID, ""); err != nil {
+		return nil, err
+	}
+	if err := audit.CheckID("exec", r.ExecID); err != nil {
+		return nil, errdefs.ToGRPC(err)
+	}
+	if err := audit.CheckExecSpec(r.Spec); err != nil {
+		return nil, errdefs.ToGRPC(err)
+	}
+	return s.recordingService.Exec(ctx, r)
+}
+
+func (s *auditingService) ResizePty(ctx context.Context, r *taskAPI.ResizePtyRequest) (_ *ptypes.Empty, err error) {
+	defer audit.Record(ctx, "ResizePty", logrus.Fields{"id": r.ID, "exec_id": r.ExecID, "width": r.Width, "height": r.Height}, &err)
+	if err := checkIDs(r.ID, r.ExecID); err != nil {
+		return nil, err
+	}
+	return s.recordingService.ResizePty(ctx, r)
+}
+
+func (s *auditingService) CloseIO(ctx context.Context, r *taskAPI.CloseIORequest) (_ *ptypes.Empty, err error) {
+	defer audit.Record(ctx, "CloseIO", logrus.Fields{"id": r.ID, "exec_id": r.ExecID, "stdin": r.Stdin}, &err)
+	if err := checkIDs(r.ID, r.ExecID); err != nil {
+		return nil, err
+	}
+	return s.recordingService.CloseIO(ctx, r)
+}
+
+func (s *auditingService) Update(ctx context.Context, r *taskAPI.UpdateTaskRequest) (_ *ptypes.Empty, err error) {
+	defer audit.Record(ctx, "Update", logrus.Fields{"id": r.ID}, &err)
+	if err := checkIDs(r.ID, ""); err != nil {
+		return nil, err
+	}
+	return s.recordingService.Update(ctx, r)
+}
+
+func (s *auditingService) Wait(ctx context.Context, r *taskAPI.WaitRequest) (*taskAPI.WaitResponse, error) {
+	if err := checkIDs(r.ID, r.ExecID); err != nil {
+		return nil, err
+	}
+	return s.recordingService.Wait(ctx, r)
+}
+
+func (s *auditingService) Stats(ctx context.Context, r *taskAPI.StatsRequest) (*taskAPI.StatsResponse, error) {
+	if err := checkIDs(r.ID, ""); err != nil {
+		return nil, err
+	}
+	return s.recordingService.Stats(ctx, r)
+}
+
+func (s *auditingService) Connect(ctx context.Context, r *taskAPI.ConnectRequest) (*taskAPI.ConnectResponse, error) {
+	if err := checkIDs(r.ID, ""); err != nil {
+		return nil, err
+	}
+	return s.recordingService.Connect(ctx, r)
+}
+
+func (s *auditingService) Shutdown(ctx context.Context, r *taskAPI.ShutdownRequest) (_ *ptypes.Empty, err error) {
+	defer audit.Record(ctx, "Shutdown", logrus.Fields{"id": r.ID, "now": r.Now}, &err)
+	if err := checkIDs(r.ID, ""); err != nil {
+		return nil, err
+	}
+	return s.recordingService.Shutdown(ctx, r)
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd/log"
+	"github.com/containerd/containerd/runtime/v2/shim"
+)
+
+// cleanupFile records in the bundle the files of the container that Cleanup
+// removes once the shim is gone.
+const cleanupFile = "cleanup.json"
+
+// cleanupState is the content of cleanupFile.
+type cleanupState struct {
+	// WorkDir is the work directory of the container.
+	WorkDir string `json:"work_dir"`
+	// OwnWorkDir is set when the work directory is created by the shim
+	// under work_root, and is removed entirely.
+	OwnWorkDir bool `json:"own_work_dir,omitempty"`
+	// DebugSocket is the debug socket of the shim, if enabled.
+	DebugSocket string `json:"debug_socket,omitempty"`
+	// EventsSocket is the local events socket of the shim, if enabled.
+	EventsSocket string `json:"events_socket,omitempty"`
+}
+
+func writeCleanupState(bundle string, st cleanupState) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(bundle, cleanupFile), data, 0600)
+}
+
+// readCleanupState returns the cleanup state recorded in bundle. Bundles of
+// containers created by older shims have none and get the defaults.
+func readCleanupState(bundle string) (cleanupState, error) {
+	st := cleanupState{
+		WorkDir: filepath.Join(bundle, "work"),
+	}
+	data, err := ioutil.ReadFile(filepath.Join(bundle, cleanupFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return st, nil
+		}
+		return st, err
+	}
+	return st, json.Unmarshal(data, &st)
+}
+
+// removeLeftovers removes the sockets and work directory left behind by the
+// shim of the container in bundle.
+func removeLeftovers(ctx context.Context, bundle string) {
+	st, err := readCleanupState(bundle)
+	if err != nil {
+		log.G(ctx).WithError(err).Warn("failed to read cleanup state")
+		return
+	}
+	if st.DebugSocket != "" {
+		if err := os.Remove(st.DebugSocket); err != nil && !os.IsNotExist(err) {
+			log.G(ctx).WithError(err).Warn("failed to remove debug socket")
+		}
+	}
+	if st.EventsSocket != "" {
+		if err := os.Remove(st.EventsSocket); err != nil && !os.IsNotExist(err) {
+			log.G(ctx).WithError(err).Warn("failed to remove events socket")
+		}
+	}
+	if st.OwnWorkDir {
+		if err := os.RemoveAll(st.WorkDir); err != nil {
+			log.G(ctx).WithError(err).Warn("failed to remove work directory")
+		}
+		return
+	}
+	sockets, _ := filepath.Glob(filepath.Join(st.WorkDir, "*.sock"))
+	for _, path := range sockets {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.G(ctx).WithError(err).Warnf("failed to remove socket %q", path)
+		}
+	}
+}
+
+// bundlePath returns the bundle of the shim, given with -bundle or the
+// working directory containerd starts the shim in.
+func bundlePath(ctx context.Context) (string, error) {
+	if opts, ok := ctx.Value(shim.OptsKey{}).(shim.Opts); ok && opts.BundlePath != "" {
+		return opts.BundlePath, nil
+	}
+	return os.Getwd()
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/debug"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// Diagnostics returns what debugging tools need to attach to the sandbox of
+// the task.
+func (s *service) Diagnostics(ctx context.Context) (*runsctypes.Diagnostics, error) {
+	p, err := s.initProcess()
+	if err != nil {
+		return nil, err
+	}
+	return p.Diagnostics(ctx)
+}
+
+// SignalSentry sends the debug signal of req to the sentry of the task,
+// served by the runsc service.
+func (s *service) SignalSentry(ctx context.Context, req *runsctypes.SignalSentryRequest) error {
+	p, err := s.initProcess()
+	if err != nil {
+		return err
+	}
+	_, err = p.SignalSentry(ctx, req.Signal)
+	return err
+}
+
+// RunscConfig returns the runsc configuration the task was created with.
+func (s *service) RunscConfig(ctx context.Context) (*runsctypes.RunscConfig, error) {
+	p, err := s.initProcess()
+	if err != nil {
+		return nil, err
+	}
+	return p.RunscConfig()
+}
+
+func (s *service) runscConfigHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := s.RunscConfig(r.Context())
+		if err != nil {
+			debug.Error(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c)
+	})
+}
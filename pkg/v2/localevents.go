@@ -0,0 +1,140 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/containerd/containerd/log"
+	rproc "github.com/containerd/containerd/runtime/proc"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/localevents"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/proc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// startEventsServer streams the events of the shim to local subscribers on
+// path.
+func (s *service) startEventsServer(ctx context.Context, path string) {
+	es, err := localevents.NewServer(path)
+	if err != nil {
+		log.G(ctx).WithError(err).Warn("failed to start events server")
+		return
+	}
+	es.Serve(s.context)
+	s.eventsMu.Lock()
+	s.localEvents = es
+	s.eventsMu.Unlock()
+}
+
+// eventsServer returns the local events server, nil when disabled.
+func (s *service) eventsServer() *localevents.Server {
+	s.eventsMu.Lock()
+	defer s.eventsMu.Unlock()
+	return s.localEvents
+}
+
+// startWebhook posts the events forwarded to containerd to target.
+func (s *service) startWebhook(ctx context.Context, target string) {
+	w, err := localevents.NewWebhook(target)
+	if err != nil {
+		log.G(ctx).WithError(err).Warn("failed to start events webhook")
+		return
+	}
+	s.eventsMu.Lock()
+	s.webhook = w
+	s.eventsMu.Unlock()
+}
+
+// eventsWebhook returns the events webhook, nil when disabled.
+func (s *service) eventsWebhook() *localevents.Webhook {
+	s.eventsMu.Lock()
+	defer s.eventsMu.Unlock()
+	return s.webhook
+}
+
+// publishLocal streams the event to local subscribers. Internal lifecycle
+// events are only published this way, as containerd has no use for them.
+func (s *service) publishLocal(e interface{}) {
+	s.eventsServer().Publish(getTopic(e), e)
+}
+
+// stateChanged streams the state changes of the processes of the container.
+func (s *service) stateChanged(c proc.StateChange) {
+	s.publishLocal(&runsctypes.StateChanged{
+		ContainerID: c.ID,
+		ExecID:      c.ExecID,
+		From:        string(c.From),
+		To:          string(c.To),
+		Timestamp:   c.Timestamp,
+	})
+}
+
+// watchIO streams an IOClosed event once the output of the started process
+// is copied.
+func (s *service) watchIO(p rproc.Process) {
+	w, ok := p.(proc.IOWaiter)
+	if s.eventsServer() == nil || !ok {
+		return
+	}
+	go func() {
+		<-w.IODone()
+		e := &runsctypes.IOClosed{
+			ContainerID: s.id,
+			Timestamp:   time.Now(),
+		}
+		if c, ok := p.(proc.IOCounter); ok {
+			stats := c.IOStats()
+			e.ExecID, e.Stdout, e.Stderr = stats.ExecID, stats.Stdout, stats.Stderr
+		}
+		s.publishLocal(e)
+	}()
+}
+
+// startGoferWatch streams GoferExited events for the gofers of the started
+// sandbox until it exits, and records their death as the failure of the
+// sandbox.
+func (s *service) startGoferWatch(p *proc.Init) {
+	if !p.Sandbox {
+		return
+	}
+	ctx, cancel := context.WithCancel(s.context)
+	s.mu.Lock()
+	s.stopGoferWatch = cancel
+	s.mu.Unlock()
+	p.WatchGofers(ctx, func(pid int) {
+		log.G(ctx).Warnf("Gofer %d of sandbox %q exited", pid, p.ID())
+		p.RecordFailure(runsctypes.ReasonGoferCrashed, fmt.Sprintf("gofer %d exited", pid))
+		s.publishLocal(&runsctypes.GoferExited{
+			ContainerID: p.ID(),
+			Pid:         pid,
+			Timestamp:   time.Now(),
+		})
+	})
+}
+
+// stopGoferWatching stops watching the gofers of the sandbox.
+func (s *service) stopGoferWatching() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopGoferWatch != nil {
+		s.stopGoferWatch()
+		s.stopGoferWatch = nil
+	}
+}
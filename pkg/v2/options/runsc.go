@@ -0,0 +1,146 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package options
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/containerd/typeurl"
+)
+
+func init() {
+	// Clients creating tasks through the containerd API can pass the
+	// options directly instead of a config file.
+	typeurl.Register(&Options{}, OptionType)
+}
+
+// RunscOptions are the typed runsc flags, set in the [runsc] table of the
+// config file. They supersede the runsc_config map, which is kept for
+// flags without a typed option.
+type RunscOptions struct {
+	// Platform is the platform of the sandbox: "ptrace", "kvm" or
+	// "systrap".
+	Platform string `toml:"platform"`
+	// Network is the network stack of the sandbox: "sandbox", "host" or
+	// "none".
+	Network string `toml:"network"`
+	// NetRaw enables raw sockets in the sandbox.
+	NetRaw *bool `toml:"net_raw"`
+	// Overlay backs the root filesystem with an overlay kept in memory.
+	Overlay *bool `toml:"overlay"`
+	// Debug enables runsc debug logging.
+	Debug *bool `toml:"debug"`
+	// DebugLog is the path of the runsc debug logs. %ID% is replaced with
+	// the container id, and a path ending with "/" is a directory of per
+	// command logs.
+	DebugLog string `toml:"debug_log"`
+	// DebugLogFormat is the format of the debug logs: "text", "json" or
+	// "json-k8s".
+	DebugLogFormat string `toml:"debug_log_format"`
+	// UserLog is the path of the log of the application, e.g. its kernel
+	// messages. %ID% is replaced with the container id, %NAMESPACE% with
+	// its namespace and %DATE% with the date it is created on, and the
+	// directory is created if needed.
+	UserLog string `toml:"user_log"`
+	// PanicLog is the path of the log of sentry panics.
+	PanicLog string `toml:"panic_log"`
+	// Strace logs the syscalls of the application.
+	Strace *bool `toml:"strace"`
+	// StraceSyscalls restricts Strace to these syscalls.
+	StraceSyscalls []string `toml:"strace_syscalls"`
+	// WatchdogAction is what the sentry watchdog does about stuck tasks:
+	// "log" or "panic".
+	WatchdogAction string `toml:"watchdog_action"`
+}
+
+// typedFlag is a runsc flag of a typed option.
+type typedFlag struct {
+	name  string
+	set   bool
+	value string
+}
+
+func stringFlag(name, v string) typedFlag {
+	return typedFlag{name: name, set: v != "", value: v}
+}
+
+func boolFlag(name string, v *bool) typedFlag {
+	if v == nil {
+		return typedFlag{name: name}
+	}
+	return typedFlag{name: name, set: true, value: strconv.FormatBool(*v)}
+}
+
+func (o *RunscOptions) typedFlags() []typedFlag {
+	return []typedFlag{
+		stringFlag("platform", o.Platform),
+		stringFlag("network", o.Network),
+		boolFlag("net-raw", o.NetRaw),
+		boolFlag("overlay", o.Overlay),
+		boolFlag("debug", o.Debug),
+		stringFlag("debug-log", o.DebugLog),
+		stringFlag("debug-log-format", o.DebugLogFormat),
+		stringFlag("user-log", o.UserLog),
+		stringFlag("panic-log", o.PanicLog),
+		boolFlag("strace", o.Strace),
+		stringFlag("strace-syscalls", strings.Join(o.StraceSyscalls, ",")),
+		stringFlag("watchdog-action", o.WatchdogAction),
+	}
+}
+
+// Flags returns the runsc flags of the options that are set.
+func (o *RunscOptions) Flags() map[string]string {
+	flags := make(map[string]string)
+	for _, f := range o.typedFlags() {
+		if f.set {
+			flags[f.name] = f.value
+		}
+	}
+	return flags
+}
+
+// RunscFlags returns the runsc flags of the options: the typed flags of
+// Runsc, which take precedence over the runsc_config map. It also returns
+// the runsc_config keys that have a typed option, which are deprecated.
+func (o *Options) RunscFlags() (map[string]string, []string) {
+	typed := o.Runsc.Flags()
+	flags := make(map[string]string, len(o.RunscConfig)+len(typed))
+	var deprecated []string
+	for k, v := range o.RunscConfig {
+		if isTyped(k) {
+			deprecated = append(deprecated, k)
+		}
+		flags[k] = v
+	}
+	for k, v := range typed {
+		flags[k] = v
+	}
+	sort.Strings(deprecated)
+	return flags, deprecated
+}
+
+// isTyped returns whether a runsc flag has a typed option.
+func isTyped(flag string) bool {
+	for _, f := range (&RunscOptions{}).typedFlags() {
+		if f.name == flag {
+			return true
+		}
+	}
+	return false
+}
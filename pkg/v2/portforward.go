@@ -0,0 +1,51 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/pkg/errors"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/proc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runscapi"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// PortForward opens a connection to the port of req in the task, for the
+// port forwards of the CRI. The connection is served on the socket of the
+// response.
+func (s *service) PortForward(ctx context.Context, req *runsctypes.PortForwardRequest) (*runsctypes.PortForwarded, error) {
+	p, err := s.getProcess("")
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, errors.Wrap(errdefs.ErrFailedPrecondition, "task not created")
+	}
+	conn, err := p.(*proc.Init).PortForward(ctx, req.Port)
+	if err != nil {
+		return nil, err
+	}
+	address, err := runscapi.ServeStream(ctx, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &runsctypes.PortForwarded{Address: address}, nil
+}
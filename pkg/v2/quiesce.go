@@ -0,0 +1,57 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/pkg/errors"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/proc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// initProcess returns the container of the shim.
+func (s *service) initProcess() (*proc.Init, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.task.(*proc.Init)
+	if !ok {
+		return nil, errors.Wrap(errdefs.ErrFailedPrecondition, "container must be created")
+	}
+	return p, nil
+}
+
+// Quiesce freezes the task for a backup of its volumes, until Unquiesce or
+// until the timeout of req expires.
+func (s *service) Quiesce(ctx context.Context, req *runsctypes.QuiesceRequest) (*runsctypes.Quiesced, error) {
+	p, err := s.initProcess()
+	if err != nil {
+		return nil, err
+	}
+	return p.Quiesce(ctx, req.Timeout)
+}
+
+// Unquiesce resumes the task frozen by Quiesce.
+func (s *service) Unquiesce(ctx context.Context) error {
+	p, err := s.initProcess()
+	if err != nil {
+		return err
+	}
+	return p.Unquiesce(ctx)
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+	"os"
+	"os/signal"
+
+	"github.com/containerd/containerd/log"
+	taskAPI "github.com/containerd/containerd/runtime/v2/task"
+	ptypes "github.com/gogo/protobuf/types"
+	"golang.org/x/sys/unix"
+)
+
+// dumpRPCsOnQuit dumps the recent rpcs to dir, the bundle the shim runs
+// in, on SIGQUIT instead of killing the shim.
+func (s *service) dumpRPCsOnQuit(dir string) {
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, unix.SIGQUIT)
+	go func() {
+		for range quit {
+			if path, err := s.rpcs.Dump(dir); err != nil {
+				log.G(s.context).WithError(err).Error("failed to dump rpcs")
+			} else {
+				log.G(s.context).Infof("dumped recent rpcs to %s", path)
+			}
+		}
+	}()
+}
+
+// recordingService records the RPCs served by service in its rpcs. The id
+// recorded is the exec id of the request, empty for the container.
+type recordingService struct {
+	*service
+}
+
+func (s *recordingService) State(ctx context.Context, r *taskAPI.StateRequest) (_ *taskAPI.StateResponse, err error) {
+	defer s.rpcs.Start("State", r.ExecID).End(&err)
+	return s.service.State(ctx, r)
+}
+
+func (s *recordingService) Create(ctx context.Context, r *taskAPI.CreateTaskRequest) (_ *taskAPI.CreateTaskResponse, err error) {
+	defer s.rpcs.Start("Create", r.ID).End(&err)
+	return s.service.Create(ctx, r)
+}
+
+func (s *recordingService) Start(ctx context.Context, r *taskAPI.StartRequest) (_ *taskAPI.StartResponse, err error) {
+	defer s.rpcs.Start("Start", r.ExecID).End(&err)
+	return s.service.Start(ctx, r)
+}
+
+func (s *recordingService) Delete(ctx context.Context, r *taskAPI.DeleteRequest) (_ *taskAPI.DeleteResponse, err error) {
+	defer s.rpcs.Start("Delete", r.ExecID).End(&err)
+	return s.service.Delete(ctx, r)
+}
+
+func (s *recordingService) Pids(ctx context.Context, r *taskAPI.PidsRequest) (_ *taskAPI.PidsResponse, err error) {
+	defer s.rpcs.Start("Pids", "").End(&err)
+	return s.service.Pids(ctx, r)
+}
+
+func (s *recordingService) Pause(ctx context.Context, r *taskAPI.PauseRequest) (_ *ptypes.Empty, err error) {
+	defer s.rpcs.Start("Pause", "").End(&err)
+	return s.service.Pause(ctx, r)
+}
+
+func (s *recordingService) Resume(ctx context.Context, r *taskAPI.ResumeRequest) (_ *ptypes.Empty, err error) {
+	defer s.rpcs.Start("Resume", "").End(&err)
+	return s.service.Resume(ctx, r)
+}
+
+func (s *recordingService) Checkpoint(ctx context.Context, r *taskAPI.CheckpointTaskRequest) (_ *ptypes.Empty, err error) {
+	defer s.rpcs.Start("Checkpoint", "").End(&err)
+	return s.service.Checkpoint(ctx, r)
+}
+
+func (s *recordingService) Kill(ctx context.Context, r *taskAPI.KillRequest) (_ *ptypes.Empty, err error) {
+	defer s.rpcs.Start("Kill", r.ExecID).End(&err)
+	return s.service.Kill(ctx, r)
+}
+
+func (s *recordingService) Exec(ctx context.Context, r *taskAPI.ExecProcessRequest) (_ *ptypes.Empty, err error) {
+	defer s.rpcs.Start("Exec", r.ExecID).End(&err)
+	return s.service.Exec(ctx, r)
+}
+
+func (s *recordingService) ResizePty(ctx context.Context, r *taskAPI.ResizePtyRequest) (_ *ptypes.Empty, err error) {
+	defer s.rpcs.Start("ResizePty", r.ExecID).End(&err)
+	return s.service.ResizePty(ctx, r)
+}
+
+func (s *recordingService) CloseIO(ctx context.Context, r *taskAPI.CloseIORequest) (_ *ptypes.Empty, err error) {
+	defer s.rpcs.Start("CloseIO", r.ExecID).End(&err)
+	return s.service.CloseIO(ctx, r)
+}
+
+func (s *recordingService) Update(ctx context.Context, r *taskAPI.UpdateTaskRequest) (_ *ptypes.Empty, err error) {
+	defer s.rpcs.Start("Update", "").End(&err)
+	return s.service.Update(ctx, r)
+}
+
+func (s *recordingService) Wait(ctx context.Context, r *taskAPI.WaitRequest) (_ *taskAPI.WaitResponse, err error) {
+	defer s.rpcs.Start("Wait", r.ExecID).End(&err)
+	return s.service.Wait(ctx, r)
+}
+
+func (s *recordingService) Stats(ctx context.Context, r *taskAPI.StatsRequest) (_ *taskAPI.StatsResponse, err error) {
+	defer s.rpcs.Start("Stats", "").End(&err)
+	return s.service.Stats(ctx, r)
+}
+
+func (s *recordingService) Connect(ctx context.Context, r *taskAPI.ConnectRequest) (_ *taskAPI.ConnectResponse, err error) {
+	defer s.rpcs.Start("Connect", "").End(&err)
+	return s.service.Connect(ctx, r)
+}
+
+func (s *recordingService) Shutdown(ctx context.Context, r *taskAPI.ShutdownRequest) (_ *ptypes.Empty, err error) {
+	defer s.rpcs.Start("Shutdown", "").End(&err)
+	return s.service.Shutdown(ctx, r)
+}
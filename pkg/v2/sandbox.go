@@ -0,0 +1,87 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+	"syscall"
+	"time"
+
+	"github.com/containerd/containerd/errdefs"
+	"github.com/pkg/errors"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/proc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// sandboxProcess returns the pause container of the shim and when it was
+// created. The shims of application containers have no sandbox of their
+// own.
+func (s *service) sandboxProcess() (*proc.Init, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.task.(*proc.Init)
+	if !ok {
+		return nil, time.Time{}, errors.Wrap(errdefs.ErrFailedPrecondition, "sandbox must be created")
+	}
+	if !p.Sandbox {
+		return nil, time.Time{}, errors.Wrapf(errdefs.ErrFailedPrecondition, "container %q is not a sandbox", p.ID())
+	}
+	return p, s.created, nil
+}
+
+// SandboxStatus returns the status of the sandbox.
+func (s *service) SandboxStatus(ctx context.Context) (*runsctypes.SandboxStatus, error) {
+	p, created, err := s.sandboxProcess()
+	if err != nil {
+		return nil, err
+	}
+	return p.SandboxStatus(ctx, created)
+}
+
+// StopSandbox stops the pause container, and with it the containers of the
+// pod, served by the runsc service. The pause container is given the
+// timeout of req to exit after SIGTERM before everything in the sandbox is
+// killed.
+func (s *service) StopSandbox(ctx context.Context, req *runsctypes.StopSandboxRequest) error {
+	p, _, err := s.sandboxProcess()
+	if err != nil {
+		return err
+	}
+	if timeout := req.Timeout; timeout > 0 {
+		if err := p.Kill(ctx, uint32(syscall.SIGTERM), false); err != nil {
+			if errdefs.IsNotFound(err) {
+				return nil
+			}
+			return err
+		}
+		select {
+		case <-p.Exited():
+			return nil
+		case <-time.After(timeout):
+		}
+	}
+	if err := p.Kill(ctx, uint32(syscall.SIGKILL), true); err != nil && !errdefs.IsNotFound(err) {
+		return err
+	}
+	select {
+	case <-p.Exited():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"syscall"
 	"time"
@@ -33,17 +34,16 @@ import (
 	"github.com/containerd/cgroups"
 	"github.com/containerd/console"
 	eventstypes "github.com/containerd/containerd/api/events"
-	"github.com/containerd/containerd/api/types/task"
 	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/events"
 	"github.com/containerd/containerd/log"
-	"github.com/containerd/containerd/mount"
 	"github.com/containerd/containerd/namespaces"
 	"github.com/containerd/containerd/runtime"
 	"github.com/containerd/containerd/runtime/linux/runctypes"
 	rproc "github.com/containerd/containerd/runtime/proc"
 	"github.com/containerd/containerd/runtime/v2/shim"
 	taskAPI "github.com/containerd/containerd/runtime/v2/task"
+	"github.com/containerd/ttrpc"
 	runtimeoptions "github.com/containerd/cri/pkg/api/runtimeoptions/v1"
 	"github.com/containerd/typeurl"
 	ptypes "github.com/gogo/protobuf/types"
@@ -51,21 +51,29 @@ import (
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sys/unix"
 
+	"github.com/google/gvisor-containerd-shim/pkg/failpoint"
 	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
+	"github.com/google/gvisor-containerd-shim/pkg/tracing"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/admission"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/cgroup"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/checkpoint"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/compat"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/devices"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/eventq"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/limits"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/localevents"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/debug"
 	"github.com/google/gvisor-containerd-shim/pkg/v1/proc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/rpclog"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runscapi"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/stats"
 	"github.com/google/gvisor-containerd-shim/pkg/v1/utils"
 	"github.com/google/gvisor-containerd-shim/pkg/v2/options"
+	shimversion "github.com/google/gvisor-containerd-shim/pkg/version"
 )
 
-var (
-	empty   = &ptypes.Empty{}
-	bufPool = sync.Pool{
-		New: func() interface{} {
-			buffer := make([]byte, 32<<10)
-			return &buffer
-		},
-	}
-)
+var empty = &ptypes.Empty{}
 
 var _ = (taskAPI.TaskService)(&service{})
 
@@ -76,14 +84,22 @@ const configFile = "config.toml"
 // New returns a new shim service that can be used via GRPC
 func New(ctx context.Context, id string, publisher events.Publisher) (shim.Shim, error) {
 	ctx, cancel := context.WithCancel(ctx)
+	dir, err := os.Getwd()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
 	s := &service{
 		id:        id,
 		context:   ctx,
 		processes: make(map[string]rproc.Process),
-		events:    make(chan interface{}, 128),
-		ec:        proc.ExitCh,
+		waiters:   make(map[string][]string),
+		events:    eventq.New(eventq.Config{}),
+		exits:     proc.NewExits(),
 		cancel:    cancel,
+		rpcs:      rpclog.New(rpclog.DefaultSize, dir),
 	}
+	s.ec = s.exits.Subscribe()
 	go s.processExits()
 	runsc.Monitor = shim.Default
 	if err := s.initPlatform(); err != nil {
@@ -91,7 +107,8 @@ func New(ctx context.Context, id string, publisher events.Publisher) (shim.Shim,
 		return nil, errors.Wrap(err, "failed to initialized platform behavior")
 	}
 	go s.forward(publisher)
-	return s, nil
+	s.dumpRPCsOnQuit(dir)
+	return &auditingService{&recordingService{service: s}}, nil
 }
 
 // service is the shim implementation of a remote shim over GRPC
@@ -101,13 +118,51 @@ type service struct {
 	context   context.Context
 	task      rproc.Process
 	processes map[string]rproc.Process
-	events    chan interface{}
+	events    *eventq.Queue
 	platform  rproc.Platform
 	ec        chan proc.Exit
+	exits     *proc.Exits
+
+	// runscServer serves the runsc service, started on Create.
+	runscServer *ttrpc.Server
+	// debugServer serves profiling endpoints when enabled in the options.
+	debugServer *debug.Server
+	// localEvents streams the events of the shim to local subscribers
+	// when enabled in the options. It is guarded by eventsMu, as events are
+	// published with mu held.
+	eventsMu    sync.Mutex
+	localEvents *localevents.Server
+	// webhook is also posted the events forwarded to containerd when
+	// enabled in the options. It is guarded by eventsMu.
+	webhook *localevents.Webhook
 
 	id     string
 	bundle string
 	cancel func()
+
+	// opts are the runtime options decoded in Create.
+	opts options.Options
+	// sampler samples the sandbox resource usage once the task started.
+	sampler     *stats.Sampler
+	stopSampler func()
+	// stopGoferWatch stops watching the gofers of the sandbox.
+	stopGoferWatch func()
+	// stopSyscallWatch stops watching for unsupported syscalls.
+	stopSyscallWatch func()
+	// waiters are the tokens of the WaitAsync requests by process id.
+	waiters map[string][]string
+	// cleanupOnce guards the orphaned sandbox cleanup run on first Create.
+	cleanupOnce sync.Once
+	// created is when the container was created, reported as the creation
+	// time of the sandbox.
+	created time.Time
+	// limits are the limits of the shim itself, applied on first Create.
+	limits *limits.Limits
+	// rpcs are the recent rpcs, dumped on SIGQUIT and when an rpc panics.
+	rpcs *rpclog.Log
+	// mountWatchdog retries the mounts leaked by deleted containers,
+	// started on first Create.
+	mountWatchdog *proc.MountWatchdog
 }
 
 func newCommand(ctx context.Context, containerdBinary, containerdAddress string) (*exec.Cmd, error) {
@@ -169,7 +224,7 @@ func (s *service) StartShim(ctx context.Context, id, containerdBinary, container
 	}()
 	// make sure to wait after start
 	go cmd.Wait()
-	if err := shim.WritePidFile("shim.pid", cmd.Process.Pid); err != nil {
+	if err := shim.WritePidFile(proc.ShimPidFile, cmd.Process.Pid); err != nil {
 		return "", err
 	}
 	if err := shim.WriteAddress("address", address); err != nil {
@@ -181,8 +236,12 @@ func (s *service) StartShim(ctx context.Context, id, containerdBinary, container
 	return address, nil
 }
 
+// Cleanup removes the container of a shim that is gone: it force deletes
+// the runsc container, unmounts its rootfs and removes the sockets and work
+// directory the shim left behind. containerd runs it as the delete command
+// of the shim binary.
 func (s *service) Cleanup(ctx context.Context) (*taskAPI.DeleteResponse, error) {
-	path, err := os.Getwd()
+	path, err := bundlePath(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -190,35 +249,58 @@ func (s *service) Cleanup(ctx context.Context) (*taskAPI.DeleteResponse, error)
 	if err != nil {
 		return nil, err
 	}
-	runtime, err := s.readRuntime(path)
+	runtime, root, err := s.readRuntime(path)
 	if err != nil {
-		return nil, err
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		// The shim died before the container was created.
+		logrus.WithError(err).Warn("no runtime recorded in bundle, using the default runsc")
 	}
-	r := proc.NewRunsc(proc.RunscRoot, path, ns, runtime, nil)
+	r := proc.NewRunsc(root, path, ns, runtime, nil)
 	if err := r.Delete(ctx, s.id, &runsc.DeleteOpts{
 		Force: true,
 	}); err != nil {
 		logrus.WithError(err).Warn("failed to remove runc container")
 	}
-	if err := mount.UnmountAll(filepath.Join(path, "rootfs"), 0); err != nil {
+	if err := proc.UnmountRootfs(filepath.Join(path, "rootfs"), proc.MountConfig{}); err != nil {
 		logrus.WithError(err).Warn("failed to cleanup rootfs mount")
 	}
+	removeLeftovers(ctx, path)
+	// The shim died, others in the namespace may have too.
+	if _, err := proc.CleanupOrphans(ctx, r); err != nil {
+		logrus.WithError(err).Warn("failed to cleanup orphaned sandboxes")
+	}
 	return &taskAPI.DeleteResponse{
 		ExitedAt:   time.Now(),
-		ExitStatus: 128 + uint32(unix.SIGKILL),
+		ExitStatus: uint32(proc.SignalExitStatus(unix.SIGKILL)),
 	}, nil
 }
 
-func (s *service) readRuntime(path string) (string, error) {
+// readRuntime returns the runsc binary and root directory recorded in the
+// bundle at path. The root is empty when the default root is used.
+func (s *service) readRuntime(path string) (string, string, error) {
 	data, err := ioutil.ReadFile(filepath.Join(path, "runtime"))
 	if err != nil {
-		return "", err
+		return "", "", err
+	}
+	root, err := ioutil.ReadFile(filepath.Join(path, "runtime_root"))
+	if err != nil && !os.IsNotExist(err) {
+		return "", "", err
 	}
-	return string(data), nil
+	return string(data), string(root), nil
 }
 
-func (s *service) writeRuntime(path, runtime string) error {
-	return ioutil.WriteFile(filepath.Join(path, "runtime"), []byte(runtime), 0600)
+// writeRuntime records the runsc binary and root directory in the bundle at
+// path, for Cleanup to find the container once the shim is gone.
+func (s *service) writeRuntime(path, runtime, root string) error {
+	if err := ioutil.WriteFile(filepath.Join(path, "runtime"), []byte(runtime), 0600); err != nil {
+		return err
+	}
+	if root == "" {
+		return nil
+	}
+	return ioutil.WriteFile(filepath.Join(path, "runtime_root"), []byte(root), 0600)
 }
 
 // Create a new initial process and container with the underlying OCI runtime
@@ -261,6 +343,8 @@ func (s *service) Create(ctx context.Context, r *taskAPI.CreateTaskRequest) (_ *
 				return nil, errors.Errorf("unsupported runtimeoptions %q", o.TypeUrl)
 			}
 			path = o.ConfigPath
+		case *options.Options: // typed options of API clients
+			opts = *o
 		default:
 			return nil, errors.Errorf("unsupported option type")
 		}
@@ -270,6 +354,32 @@ func (s *service) Create(ctx context.Context, r *taskAPI.CreateTaskRequest) (_ *
 			}
 		}
 	}
+	var deprecated []string
+	if opts.RunscConfig, deprecated = opts.RunscFlags(); len(deprecated) > 0 {
+		log.G(ctx).WithField("flags", deprecated).Warn("runsc_config flags with a typed option in the [runsc] table are deprecated")
+	}
+	if err := runsc.ValidateConfig(opts.RunscConfig, opts.UncheckedRunscFlags); err != nil {
+		log.G(ctx).WithError(err).Warn("runsc_config may be invalid")
+	}
+	// Tracing is configured by the options, so the span starts once they
+	// are decoded.
+	if err := tracing.Configure(tracing.Config{Endpoint: opts.OTLPEndpoint}); err != nil {
+		return nil, proc.ToGRPC(errors.Wrap(errdefs.ErrInvalidArgument, err.Error()))
+	}
+	ctx, span := startSpan(ctx, "Create", r.ID, "")
+	defer func() { span.End(err) }()
+	if s.limits == nil {
+		shimLimits, err := limits.Config{
+			CgroupParent: opts.ShimCgroupParent,
+			MemoryLimit:  opts.ShimMemoryLimit,
+			CPUs:         opts.ShimCPUs,
+			NoFile:       opts.ShimNoFile,
+		}.Apply()
+		if err != nil {
+			return nil, proc.ToGRPC(errors.Wrap(errdefs.ErrFailedPrecondition, "failed to apply shim limits: "+err.Error()))
+		}
+		s.limits = shimLimits
+	}
 
 	var mounts []proc.Mount
 	for _, m := range r.Rootfs {
@@ -281,56 +391,263 @@ func (s *service) Create(ctx context.Context, r *taskAPI.CreateTaskRequest) (_ *
 		})
 	}
 	config := &proc.CreateConfig{
-		ID:       r.ID,
-		Bundle:   r.Bundle,
-		Runtime:  opts.BinaryName,
-		Rootfs:   mounts,
-		Terminal: r.Terminal,
-		Stdin:    r.Stdin,
-		Stdout:   r.Stdout,
-		Stderr:   r.Stderr,
-		Options:  r.Options,
+		ID:         r.ID,
+		Bundle:     r.Bundle,
+		Runtime:    opts.BinaryName,
+		Rootfs:     mounts,
+		Terminal:   r.Terminal,
+		Stdin:      r.Stdin,
+		Stdout:     r.Stdout,
+		Stderr:     r.Stderr,
+		Options:    r.Options,
+		Checkpoint: r.Checkpoint,
+	}
+	var shmSize int64
+	if opts.ShmSize != "" {
+		if shmSize, err = compat.ParseShmSize(opts.ShmSize); err != nil {
+			return nil, proc.ToGRPC(errors.Wrap(err, "invalid shm_size"))
+		}
+	}
+	rawSpec, err := compat.ReadRawSpec(r.Bundle)
+	if err != nil {
+		return nil, errors.Wrap(err, "read oci spec")
+	}
+	seccompChanges, err := compat.TranslateSeccomp(rawSpec, opts.StrictSeccomp)
+	if err != nil {
+		return nil, proc.ToGRPC(err)
+	}
+	shmSize, shmChanged, err := compat.ConfigureShm(rawSpec, shmSize)
+	if err != nil {
+		return nil, proc.ToGRPC(err)
+	}
+	specData, spec, err := compat.ParseRawSpec(rawSpec)
+	if err != nil {
+		return nil, errors.Wrap(err, "read oci spec")
+	}
+	if err := compat.Validate(ctx, spec); err != nil {
+		return nil, proc.ToGRPC(err)
+	}
+	dryRun, err := proc.IsDryRun(spec)
+	if err != nil {
+		return nil, proc.ToGRPC(err)
+	}
+	config.Spec = specData
+	// A dry run leaves the bundle as is, it reports config.Spec.
+	if !dryRun && (len(seccompChanges) > 0 || shmChanged) {
+		restoreSpec, err2 := compat.RewriteSpec(r.Bundle, specData)
+		if err2 != nil {
+			return nil, errors.Wrap(err2, "rewrite oci spec")
+		}
+		defer func() {
+			if err != nil {
+				if err2 := restoreSpec(); err2 != nil {
+					log.G(ctx).WithError(err2).Warn("Failed to restore oci spec")
+				}
+			}
+		}()
+		if len(seccompChanges) > 0 {
+			s.seccompRewritten(ctx, r.ID, seccompChanges)
+		}
 	}
-	if err := s.writeRuntime(r.Bundle, opts.BinaryName); err != nil {
+	runtimes := utils.Runtimes{
+		Namespaces: opts.NamespaceRuntimes,
+		Handlers:   opts.HandlerRuntimes,
+	}
+	binary, root := runtimes.Resolve(ns, spec, opts.BinaryName, opts.Root)
+	if binary != opts.BinaryName || root != opts.Root {
+		log.G(ctx).WithFields(logrus.Fields{
+			"runtime": binary,
+			"root":    root,
+		}).Info("Using runtime override")
+	}
+	opts.BinaryName, opts.Root = binary, root
+	config.Runtime = opts.BinaryName
+	if err := s.writeRuntime(r.Bundle, opts.BinaryName, opts.Root); err != nil {
 		return nil, err
 	}
+	perfProfile := utils.PerfProfile{
+		Default:  opts.PerfProfile,
+		Profiles: opts.PerfProfiles,
+	}
+	if err := perfProfile.Validate(); err != nil {
+		return nil, proc.ToGRPC(errors.Wrap(errdefs.ErrInvalidArgument, err.Error()))
+	}
+	if err := perfProfile.CheckFlags(opts.UncheckedRunscFlags); err != nil {
+		log.G(ctx).WithError(err).Warn("perf_profiles may be invalid")
+	}
+	requestedFlags := opts.RunscConfig
+	if opts.RunscConfig, err = utils.ProfileFlags(opts.RunscConfig, r.Bundle, spec, perfProfile); err != nil {
+		return nil, proc.ToGRPC(err)
+	}
+	fileAccess := utils.FileAccess{
+		Root:     opts.FileAccess,
+		Mounts:   opts.FileAccessMounts,
+		DirectFS: opts.DirectFS,
+		Lisafs:   opts.Lisafs,
+	}
+	if opts.RunscConfig, err = utils.FileAccessFlags(opts.RunscConfig, spec, fileAccess); err != nil {
+		return nil, proc.ToGRPC(err)
+	}
+	if opts.RunscConfig, err = devices.Configure(opts.RunscConfig, spec, utils.IsSandbox(spec)); err != nil {
+		return nil, proc.ToGRPC(err)
+	}
+	opts.RunscConfig = cgroup.Configure(opts.RunscConfig, spec)
+	admit := &admission.CreateRequest{
+		Namespace:  ns,
+		ID:         r.ID,
+		Bundle:     r.Bundle,
+		Spec:       spec,
+		RunscFlags: opts.RunscConfig,
+	}
+	if err := admission.AdmitCreate(ctx, admit); err != nil {
+		return nil, proc.ToGRPC(err)
+	}
+	opts.RunscConfig = admit.RunscFlags
 	rootfs := filepath.Join(r.Bundle, "rootfs")
+	rootfsMounts := mountConfig(&opts)
+	if dryRun {
+		// The mounts of a dry run are only logged.
+		rootfsMounts.Mounter = &proc.DiagnosticMounter{DryRun: true}
+	}
 	defer func() {
 		if err != nil {
-			if err2 := mount.UnmountAll(rootfs, 0); err2 != nil {
+			if err2 := proc.UnmountRootfs(rootfs, rootfsMounts); err2 != nil {
 				logrus.WithError(err2).Warn("failed to cleanup rootfs mount")
 			}
 		}
 	}()
-	for _, rm := range mounts {
-		m := &mount.Mount{
-			Type:    rm.Type,
-			Source:  rm.Source,
-			Options: rm.Options,
-		}
-		if err := m.Mount(rootfs); err != nil {
-			return nil, errors.Wrapf(err, "failed to mount rootfs component %v", m)
+	_, mountSpan := tracing.Start(ctx, "mount rootfs")
+	mountStart := time.Now()
+	err = proc.MountRootfs(rootfs, mounts, rootfsMounts)
+	mountEnd := time.Now()
+	mountSpan.End(err)
+	if err != nil {
+		return nil, err
+	}
+	workDir := filepath.Join(r.Bundle, "work")
+	if opts.WorkRoot != "" {
+		workDir = utils.WorkDir(opts.WorkRoot, ns, r.ID)
+		if err := utils.PrepareWorkDir(workDir); err != nil {
+			return nil, proc.ToGRPC(err)
 		}
 	}
+	cleanup := cleanupState{
+		WorkDir:    workDir,
+		OwnWorkDir: opts.WorkRoot != "",
+	}
+	if opts.DebugSocketDir != "" {
+		cleanup.DebugSocket = debug.SocketPath(opts.DebugSocketDir, ns, r.ID)
+	}
+	if opts.EventsSocketDir != "" {
+		cleanup.EventsSocket = localevents.SocketPath(opts.EventsSocketDir, ns, r.ID)
+	}
+	if err := writeCleanupState(r.Bundle, cleanup); err != nil {
+		return nil, errors.Wrap(err, "write cleanup state")
+	}
 	process, err := newInit(
 		ctx,
 		r.Bundle,
-		filepath.Join(r.Bundle, "work"),
+		workDir,
 		ns,
 		s.platform,
 		config,
 		&opts,
 	)
 	if err != nil {
-		return nil, errdefs.ToGRPC(err)
+		return nil, proc.ToGRPC(err)
+	}
+	process.RecordPhase(proc.PhaseRootfsMount, mountStart, mountEnd)
+	process.Exits = s.exits
+	monitor, err := proc.SelectMonitor(opts.Monitor, shim.Default)
+	if err != nil {
+		return nil, proc.ToGRPC(err)
+	}
+	process.Monitor = monitor
+	process.CleanupWorkDir = opts.WorkRoot != ""
+	process.RunHooks = opts.RunHooks
+	process.ChownHelper = opts.ChownHelper
+	process.RuncBinary = opts.RuncBinary
+	process.OOMScoreOffset = opts.OOMScoreOffset
+	process.UserLogToStdout = opts.UserLogToStdout
+	process.RequestedRunscFlags = proc.RequestedFlags(ns, r.ID, requestedFlags)
+	process.HoldNamespaces = opts.HoldNamespaces
+	if opts.CheckpointCompression != "" {
+		process.CheckpointCompression = checkpoint.Compression(opts.CheckpointCompression)
+		if err := process.CheckpointCompression.Validate(); err != nil {
+			return nil, proc.ToGRPC(errors.Wrap(errdefs.ErrInvalidArgument, err.Error()))
+		}
+	}
+	process.KeepArtifacts = opts.KeepArtifacts
+	process.Mounts = mountConfig(&opts)
+	if s.mountWatchdog == nil {
+		s.mountWatchdog = proc.NewMountWatchdog(process.Mounts)
+		go s.mountWatchdog.Run(s.context)
+	}
+	process.MountWatchdog = s.mountWatchdog
+	process.CollectCrashLogs = opts.CollectCrashLogs
+	process.IOTimeout = opts.IOTimeout.Duration
+	process.LineBufferStderr = opts.LineBufferStderr
+	process.TerminalStdinEOF = proc.TerminalStdinEOF(opts.TerminalStdinEOF)
+	if err := process.TerminalStdinEOF.Validate(); err != nil {
+		return nil, proc.ToGRPC(errors.Wrap(errdefs.ErrInvalidArgument, err.Error()))
+	}
+	process.PauseOptimization = opts.PauseOptimization
+	process.ShmSize = shmSize
+	process.LeaveShimCgroup = s.limits.Exempt
+	process.StateChanged = s.stateChanged
+	proc.Timeouts{
+		Create: opts.CreateTimeout.Duration,
+		Start:  opts.StartTimeout.Duration,
+		Exec:   opts.ExecTimeout.Duration,
+		Delete: opts.DeleteTimeout.Duration,
+	}.Apply(process.Runtime())
+	limits := runsc.Limits{
+		Concurrency: opts.RunscConcurrency,
+		Rate:        opts.RunscRate,
+	}
+	if err := limits.Validate(); err != nil {
+		return nil, proc.ToGRPC(errors.Wrap(errdefs.ErrInvalidArgument, err.Error()))
+	}
+	limits.Apply(process.Runtime())
+	if err := s.verifyRuntime(ctx, r.ID, process, opts.Strict); err != nil {
+		return nil, proc.ToGRPC(err)
+	}
+	queue := eventq.Config{
+		Size:   opts.EventQueueSize,
+		Policy: eventq.Policy(opts.EventOverflowPolicy),
+	}
+	if err := queue.Validate(); err != nil {
+		return nil, proc.ToGRPC(errors.Wrap(errdefs.ErrInvalidArgument, err.Error()))
+	}
+	s.events.SetConfig(queue)
+	if process.Signals, err = proc.ParseSignalMap(opts.SignalMap); err != nil {
+		return nil, proc.ToGRPC(errors.Wrapf(errdefs.ErrInvalidArgument, "signal_map: %v", err))
+	}
+	if dryRun {
+		return nil, proc.ToGRPC(s.dryRun(ctx, process, config))
 	}
 	if err := process.Create(ctx, config); err != nil {
-		return nil, errdefs.ToGRPC(err)
+		return nil, proc.ToGRPC(err)
 	}
 	// save the main task id and bundle to the shim for additional requests
 	s.id = r.ID
 	s.bundle = r.Bundle
 	s.task = process
+	s.opts = opts
+	s.created = time.Now()
+	s.cleanupOnce.Do(func() {
+		go s.cleanupOrphans(ns, r.Bundle)
+	})
+	s.startRunscServer(ctx, r.ID)
+	if opts.DebugSocketDir != "" {
+		s.startDebugServer(ctx, debug.SocketPath(opts.DebugSocketDir, ns, r.ID))
+	}
+	if opts.EventsSocketDir != "" {
+		s.startEventsServer(ctx, localevents.SocketPath(opts.EventsSocketDir, ns, r.ID))
+	}
+	if opts.EventsWebhook != "" {
+		s.startWebhook(ctx, opts.EventsWebhook)
+	}
 	return &taskAPI.CreateTaskResponse{
 		Pid: uint32(process.Pid()),
 	}, nil
@@ -338,21 +655,32 @@ func (s *service) Create(ctx context.Context, r *taskAPI.CreateTaskRequest) (_ *
 }
 
 // Start a process
-func (s *service) Start(ctx context.Context, r *taskAPI.StartRequest) (*taskAPI.StartResponse, error) {
+func (s *service) Start(ctx context.Context, r *taskAPI.StartRequest) (_ *taskAPI.StartResponse, err error) {
+	ctx, span := startSpan(ctx, "Start", r.ID, r.ExecID)
+	defer func() { span.End(err) }()
 	p, err := s.getProcess(r.ExecID)
 	if err != nil {
 		return nil, err
 	}
 	if err := p.Start(ctx); err != nil {
-		return nil, err
+		return nil, proc.ToGRPC(err)
 	}
+	if r.ExecID == "" {
+		s.startSampler(p.(*proc.Init))
+		s.startGoferWatch(p.(*proc.Init))
+		s.startSyscallWatch(p.(*proc.Init))
+		s.publish(p.(*proc.Init).StartLatency())
+	}
+	s.watchIO(p)
 	return &taskAPI.StartResponse{
 		Pid: uint32(p.Pid()),
 	}, nil
 }
 
 // Delete the initial process and container
-func (s *service) Delete(ctx context.Context, r *taskAPI.DeleteRequest) (*taskAPI.DeleteResponse, error) {
+func (s *service) Delete(ctx context.Context, r *taskAPI.DeleteRequest) (_ *taskAPI.DeleteResponse, err error) {
+	ctx, span := startSpan(ctx, "Delete", r.ID, r.ExecID)
+	defer func() { span.End(err) }()
 	p, err := s.getProcess(r.ExecID)
 	if err != nil {
 		return nil, err
@@ -372,6 +700,10 @@ func (s *service) Delete(ctx context.Context, r *taskAPI.DeleteRequest) (*taskAP
 	if isTask && s.platform != nil {
 		s.platform.Close()
 	}
+	if isTask {
+		s.publishRemoved(p)
+	}
+	s.publish(proc.NewDeleteDetails(s.id, p))
 	return &taskAPI.DeleteResponse{
 		ExitStatus: uint32(p.ExitStatus()),
 		ExitedAt:   p.ExitedAt(),
@@ -380,7 +712,9 @@ func (s *service) Delete(ctx context.Context, r *taskAPI.DeleteRequest) (*taskAP
 }
 
 // Exec an additional process inside the container
-func (s *service) Exec(ctx context.Context, r *taskAPI.ExecProcessRequest) (*ptypes.Empty, error) {
+func (s *service) Exec(ctx context.Context, r *taskAPI.ExecProcessRequest) (_ *ptypes.Empty, err error) {
+	ctx, span := startSpan(ctx, "Exec", r.ID, r.ExecID)
+	defer func() { span.End(err) }()
 	s.mu.Lock()
 	p := s.processes[r.ExecID]
 	s.mu.Unlock()
@@ -391,6 +725,21 @@ func (s *service) Exec(ctx context.Context, r *taskAPI.ExecProcessRequest) (*pty
 	if p == nil {
 		return nil, errdefs.ToGRPCf(errdefs.ErrFailedPrecondition, "container must be created")
 	}
+	if admission.Enabled() {
+		ns, _ := namespaces.Namespace(ctx)
+		spec, err := admission.ExecProcess(r.Spec)
+		if err != nil {
+			return nil, proc.ToGRPC(err)
+		}
+		if err := admission.AdmitExec(ctx, &admission.ExecRequest{
+			Namespace:   ns,
+			ContainerID: r.ID,
+			ExecID:      r.ExecID,
+			Process:     spec,
+		}); err != nil {
+			return nil, proc.ToGRPC(err)
+		}
+	}
 	process, err := p.(*proc.Init).Exec(ctx, s.bundle, &proc.ExecConfig{
 		ID:       r.ExecID,
 		Terminal: r.Terminal,
@@ -400,7 +749,7 @@ func (s *service) Exec(ctx context.Context, r *taskAPI.ExecProcessRequest) (*pty
 		Spec:     r.Spec,
 	})
 	if err != nil {
-		return nil, errdefs.ToGRPC(err)
+		return nil, proc.ToGRPC(err)
 	}
 	s.mu.Lock()
 	s.processes[r.ExecID] = process
@@ -419,7 +768,7 @@ func (s *service) ResizePty(ctx context.Context, r *taskAPI.ResizePtyRequest) (*
 		Height: uint16(r.Height),
 	}
 	if err := p.Resize(ws); err != nil {
-		return nil, errdefs.ToGRPC(err)
+		return nil, proc.ToGRPC(err)
 	}
 	return empty, nil
 }
@@ -434,21 +783,12 @@ func (s *service) State(ctx context.Context, r *taskAPI.StateRequest) (*taskAPI.
 	if err != nil {
 		return nil, err
 	}
-	status := task.StatusUnknown
-	switch st {
-	case "created":
-		status = task.StatusCreated
-	case "running":
-		status = task.StatusRunning
-	case "stopped":
-		status = task.StatusStopped
-	}
 	sio := p.Stdio()
 	return &taskAPI.StateResponse{
 		ID:         p.ID(),
 		Bundle:     s.bundle,
 		Pid:        uint32(p.Pid()),
-		Status:     status,
+		Status:     proc.TaskStatus(ctx, p.ID(), st),
 		Stdin:      sio.Stdin,
 		Stdout:     sio.Stdout,
 		Stderr:     sio.Stderr,
@@ -460,16 +800,42 @@ func (s *service) State(ctx context.Context, r *taskAPI.StateRequest) (*taskAPI.
 
 // Pause the container
 func (s *service) Pause(ctx context.Context, r *taskAPI.PauseRequest) (*ptypes.Empty, error) {
-	return empty, errdefs.ToGRPC(errdefs.ErrNotImplemented)
+	s.mu.Lock()
+	p := s.task
+	s.mu.Unlock()
+	if p == nil {
+		return nil, errdefs.ToGRPCf(errdefs.ErrFailedPrecondition, "container must be created")
+	}
+	if err := p.(*proc.Init).Pause(ctx); err != nil {
+		return nil, proc.ToGRPC(err)
+	}
+	s.publish(&eventstypes.TaskPaused{
+		ContainerID: p.ID(),
+	})
+	return empty, nil
 }
 
 // Resume the container
 func (s *service) Resume(ctx context.Context, r *taskAPI.ResumeRequest) (*ptypes.Empty, error) {
-	return empty, errdefs.ToGRPC(errdefs.ErrNotImplemented)
+	s.mu.Lock()
+	p := s.task
+	s.mu.Unlock()
+	if p == nil {
+		return nil, errdefs.ToGRPCf(errdefs.ErrFailedPrecondition, "container must be created")
+	}
+	if err := p.(*proc.Init).Resume(ctx); err != nil {
+		return nil, proc.ToGRPC(err)
+	}
+	s.publish(&eventstypes.TaskResumed{
+		ContainerID: p.ID(),
+	})
+	return empty, nil
 }
 
 // Kill a process with the provided signal
-func (s *service) Kill(ctx context.Context, r *taskAPI.KillRequest) (*ptypes.Empty, error) {
+func (s *service) Kill(ctx context.Context, r *taskAPI.KillRequest) (_ *ptypes.Empty, err error) {
+	ctx, span := startSpan(ctx, "Kill", r.ID, r.ExecID, tracing.Attr("signal", strconv.Itoa(int(r.Signal))))
+	defer func() { span.End(err) }()
 	p, err := s.getProcess(r.ExecID)
 	if err != nil {
 		return nil, err
@@ -478,36 +844,20 @@ func (s *service) Kill(ctx context.Context, r *taskAPI.KillRequest) (*ptypes.Emp
 		return nil, errdefs.ToGRPCf(errdefs.ErrFailedPrecondition, "container must be created")
 	}
 	if err := p.Kill(ctx, r.Signal, r.All); err != nil {
-		return nil, errdefs.ToGRPC(err)
+		return nil, proc.ToGRPC(err)
 	}
 	return empty, nil
 }
 
 // Pids returns all pids inside the container
 func (s *service) Pids(ctx context.Context, r *taskAPI.PidsRequest) (*taskAPI.PidsResponse, error) {
-	pids, err := s.getContainerPids(ctx, r.ID)
+	entries, err := s.getContainerProcesses(ctx, r.ID)
 	if err != nil {
-		return nil, errdefs.ToGRPC(err)
+		return nil, proc.ToGRPC(err)
 	}
-	var processes []*task.ProcessInfo
-	for _, pid := range pids {
-		pInfo := task.ProcessInfo{
-			Pid: pid,
-		}
-		for _, p := range s.processes {
-			if p.Pid() == int(pid) {
-				d := &runctypes.ProcessDetails{
-					ExecID: p.ID(),
-				}
-				a, err := typeurl.MarshalAny(d)
-				if err != nil {
-					return nil, errors.Wrapf(err, "failed to marshal process %d info", pid)
-				}
-				pInfo.Info = a
-				break
-			}
-		}
-		processes = append(processes, &pInfo)
+	processes, err := proc.ProcessInfos(proc.ProcessDetails(ctx, entries, s.allProcesses()))
+	if err != nil {
+		return nil, err
 	}
 	return &taskAPI.PidsResponse{
 		Processes: processes,
@@ -520,7 +870,14 @@ func (s *service) CloseIO(ctx context.Context, r *taskAPI.CloseIORequest) (*ptyp
 	if err != nil {
 		return nil, err
 	}
-	if stdin := p.Stdin(); stdin != nil {
+	if !r.Stdin {
+		return empty, nil
+	}
+	if c, ok := p.(proc.StdinCloser); ok {
+		if err := c.CloseStdin(ctx); err != nil {
+			return nil, errors.Wrap(err, "close stdin")
+		}
+	} else if stdin := p.Stdin(); stdin != nil {
 		if err := stdin.Close(); err != nil {
 			return nil, errors.Wrap(err, "close stdin")
 		}
@@ -530,7 +887,31 @@ func (s *service) CloseIO(ctx context.Context, r *taskAPI.CloseIORequest) (*ptyp
 
 // Checkpoint the container
 func (s *service) Checkpoint(ctx context.Context, r *taskAPI.CheckpointTaskRequest) (*ptypes.Empty, error) {
-	return empty, errdefs.ToGRPC(errdefs.ErrNotImplemented)
+	s.mu.Lock()
+	p := s.task
+	s.mu.Unlock()
+	if p == nil {
+		return nil, errdefs.ToGRPCf(errdefs.ErrFailedPrecondition, "container must be created")
+	}
+	var options runctypes.CheckpointOptions
+	if r.Options != nil {
+		v, err := typeurl.UnmarshalAny(r.Options)
+		if err != nil {
+			return nil, proc.ToGRPC(errors.Wrap(errdefs.ErrInvalidArgument, err.Error()))
+		}
+		o, ok := v.(*runctypes.CheckpointOptions)
+		if !ok {
+			return nil, errdefs.ToGRPCf(errdefs.ErrInvalidArgument, "unsupported checkpoint options %T", v)
+		}
+		options = *o
+	}
+	if err := p.(*proc.Init).Checkpoint(ctx, &proc.CheckpointConfig{
+		Path: r.Path,
+		Exit: options.Exit,
+	}); err != nil {
+		return nil, proc.ToGRPC(err)
+	}
+	return empty, nil
 }
 
 // Connect returns shim information such as the shim's pid
@@ -547,13 +928,39 @@ func (s *service) Connect(ctx context.Context, r *taskAPI.ConnectRequest) (*task
 
 func (s *service) Shutdown(ctx context.Context, r *taskAPI.ShutdownRequest) (*ptypes.Empty, error) {
 	s.cancel()
+	if s.runscServer != nil {
+		s.runscServer.Close()
+	}
+	if s.debugServer != nil {
+		s.debugServer.Close()
+	}
+	if es := s.eventsServer(); es != nil {
+		es.Close()
+	}
+	s.eventsWebhook().Close()
+	fctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	tracing.Flush(fctx)
+	cancel()
+	s.limits.Release()
 	os.Exit(0)
 	return empty, nil
 }
 
 func (s *service) Stats(ctx context.Context, r *taskAPI.StatsRequest) (*taskAPI.StatsResponse, error) {
-	// TODO(random-liu): Use `runsc events --stats`.
-	data, err := typeurl.MarshalAny(&cgroups.Metrics{})
+	metrics := &cgroups.Metrics{}
+	s.mu.Lock()
+	sampler := s.sampler
+	s.mu.Unlock()
+	if sampler != nil {
+		sample := sampler.Latest()
+		if sample == nil {
+			sample = sampler.Sample(ctx)
+		}
+		if sample != nil {
+			metrics = sample.Metrics()
+		}
+	}
+	data, err := typeurl.MarshalAny(metrics)
 	if err != nil {
 		return nil, err
 	}
@@ -564,7 +971,25 @@ func (s *service) Stats(ctx context.Context, r *taskAPI.StatsRequest) (*taskAPI.
 
 // Update a running container
 func (s *service) Update(ctx context.Context, r *taskAPI.UpdateTaskRequest) (*ptypes.Empty, error) {
-	return empty, errdefs.ToGRPC(errdefs.ErrNotImplemented)
+	// gVisor doesn't update resources, only mount updates are checked to
+	// name the mounts that can't be added.
+	if r.Resources == nil || !typeurl.Is(r.Resources, &runsctypes.UpdateMounts{}) {
+		return empty, proc.ToGRPC(errdefs.ErrNotImplemented)
+	}
+	v, err := typeurl.UnmarshalAny(r.Resources)
+	if err != nil {
+		return nil, proc.ToGRPC(errors.Wrap(errdefs.ErrInvalidArgument, err.Error()))
+	}
+	s.mu.Lock()
+	p := s.task
+	s.mu.Unlock()
+	if p == nil {
+		return nil, errdefs.ToGRPCf(errdefs.ErrFailedPrecondition, "container must be created")
+	}
+	if err := p.(*proc.Init).UpdateMounts(ctx, v.(*runsctypes.UpdateMounts).Mounts); err != nil {
+		return nil, proc.ToGRPC(err)
+	}
+	return empty, nil
 }
 
 // Wait for a process to exit
@@ -590,29 +1015,53 @@ func (s *service) processExits() {
 	}
 }
 
+// checkProcesses routes the exit e to its process, looked up by id.
 func (s *service) checkProcesses(e proc.Exit) {
 	// TODO(random-liu): Add `shouldKillAll` logic if container pid
 	// namespace is supported.
-	for _, p := range s.allProcesses() {
-		if p.ID() == e.ID {
-			if ip, ok := p.(*proc.Init); ok {
-				// Ensure all children are killed
-				if err := ip.KillAll(s.context); err != nil {
-					log.G(s.context).WithError(err).WithField("id", ip.ID()).
-						Error("failed to kill init's children")
-				}
-			}
-			p.SetExited(e.Status)
-			s.events <- &eventstypes.TaskExit{
-				ContainerID: s.id,
-				ID:          p.ID(),
-				Pid:         uint32(p.Pid()),
-				ExitStatus:  uint32(e.Status),
-				ExitedAt:    p.ExitedAt(),
-			}
-			return
+	p := s.exitedProcess(e.ID)
+	if p == nil {
+		return
+	}
+	if ip, ok := p.(*proc.Init); ok {
+		// Ensure all children are killed
+		if err := ip.KillAll(s.context); err != nil {
+			log.G(s.context).WithError(err).WithField("id", ip.ID()).
+				Error("failed to kill init's children")
+		}
+		s.stopSampling()
+		s.stopGoferWatching()
+		s.stopSyscallWatching()
+	}
+	p.SetExited(e.Status)
+	s.publish(&eventstypes.TaskExit{
+		ContainerID: s.id,
+		ID:          p.ID(),
+		Pid:         uint32(p.Pid()),
+		ExitStatus:  uint32(p.ExitStatus()),
+		ExitedAt:    p.ExitedAt(),
+	})
+	s.publish(proc.NewExitDetails(s.id, p))
+	if ip, ok := p.(*proc.Init); ok {
+		if r := ip.CrashReport(s.context); r != nil {
+			s.publish(r)
 		}
 	}
+	s.publishWaitResults(p)
+}
+
+// exitedProcess returns the process with the id of an exit: an exec process,
+// or the task.
+func (s *service) exitedProcess(id string) rproc.Process {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.processes[id]; ok {
+		return p
+	}
+	if s.task != nil && s.task.ID() == id {
+		return s.task
+	}
+	return nil
 }
 
 func (s *service) allProcesses() (o []rproc.Process) {
@@ -627,32 +1076,264 @@ func (s *service) allProcesses() (o []rproc.Process) {
 	return o
 }
 
-func (s *service) getContainerPids(ctx context.Context, id string) ([]uint32, error) {
+func (s *service) getContainerProcesses(ctx context.Context, id string) ([]proc.PsEntry, error) {
 	s.mu.Lock()
 	p := s.task
 	s.mu.Unlock()
 	if p == nil {
 		return nil, errors.Wrapf(errdefs.ErrFailedPrecondition, "container must be created")
 	}
-	ps, err := p.(*proc.Init).Runtime().Ps(ctx, id)
+	top, err := p.(*proc.Init).Runtime().Top(ctx, id)
 	if err != nil {
 		return nil, err
 	}
-	pids := make([]uint32, 0, len(ps))
-	for _, pid := range ps {
-		pids = append(pids, uint32(pid))
+	return proc.ParsePs(top)
+}
+
+// publish queues the event for forwarding to containerd, and streams it to
+// local subscribers.
+func (s *service) publish(e interface{}) {
+	s.publishLocal(e)
+	if !s.events.Push(e) {
+		log.G(s.context).WithField("dropped", s.events.Dropped()).Warnf("dropped %T event, event queue is full", e)
 	}
-	return pids, nil
 }
 
+// forward publishes the queued events to containerd, and to the webhook
+// if any.
 func (s *service) forward(publisher events.Publisher) {
-	for e := range s.events {
-		ctx, cancel := context.WithTimeout(s.context, 5*time.Second)
-		err := publisher.Publish(ctx, getTopic(e), e)
-		cancel()
+	for {
+		e, ok := s.events.Pop()
+		if !ok {
+			return
+		}
+		topic := getTopic(e)
+		err := failpoint.Inject(failpoint.PublishEvent)
+		if err == nil {
+			ctx, cancel := context.WithTimeout(s.context, 5*time.Second)
+			err = publisher.Publish(ctx, topic, e)
+			cancel()
+		}
 		if err != nil {
 			logrus.WithError(err).Error("post event")
 		}
+		s.eventsWebhook().Publish(topic, e)
+	}
+}
+
+// startRunscServer serves the runsc service of the shim next to its task
+// socket, at the task socket address followed by runscapi.SocketSuffix.
+func (s *service) startRunscServer(ctx context.Context, id string) {
+	address, err := shim.SocketAddress(ctx, id)
+	if err != nil {
+		log.G(ctx).WithError(err).Warn("failed to start runsc server")
+		return
+	}
+	l, err := shim.NewSocket(address + runscapi.SocketSuffix)
+	if err != nil {
+		log.G(ctx).WithError(err).Warn("failed to start runsc server")
+		return
+	}
+	server, err := ttrpc.NewServer(ttrpc.WithServerHandshaker(ttrpc.UnixSocketRequireSameUser()))
+	if err != nil {
+		l.Close()
+		log.G(ctx).WithError(err).Warn("failed to start runsc server")
+		return
+	}
+	runscapi.Register(server, runscapi.Audit(s))
+	go func() {
+		if err := server.Serve(s.context, l); err != nil && err != ttrpc.ErrServerClosed {
+			log.G(ctx).WithError(err).Warn("runsc server failed")
+		}
+	}()
+	s.runscServer = server
+}
+
+// Version returns the build of the shim, served by the runsc service.
+func (s *service) Version(ctx context.Context) (*shimversion.Info, error) {
+	info := shimversion.Get()
+	return &info, nil
+}
+
+// RunscState returns the runsc specific state of a process, served by the
+// runsc service.
+func (s *service) RunscState(ctx context.Context, r *runsctypes.StateRequest) (*runsctypes.State, error) {
+	s.mu.Lock()
+	id := s.id
+	s.mu.Unlock()
+	p, err := s.getProcess(r.ExecID)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, errors.Wrap(errdefs.ErrFailedPrecondition, "container must be created")
+	}
+	return proc.RunscState(id, p), nil
+}
+
+// RunscPids lists the processes of the container with their runsc details,
+// served by the runsc service.
+func (s *service) RunscPids(ctx context.Context, r *runsctypes.PidsRequest) (*runsctypes.ProcessList, error) {
+	s.mu.Lock()
+	id := s.id
+	s.mu.Unlock()
+	entries, err := s.getContainerProcesses(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	details := proc.ProcessDetails(ctx, entries, s.allProcesses())
+	if r.Threads {
+		p, err := s.getProcess("")
+		if err != nil {
+			return nil, err
+		}
+		if p == nil {
+			return nil, errors.Wrap(errdefs.ErrFailedPrecondition, "container must be created")
+		}
+		if err := p.(*proc.Init).AddThreads(ctx, details); err != nil {
+			return nil, proc.ToGRPC(err)
+		}
+	}
+	return &runsctypes.ProcessList{
+		Processes: details,
+	}, nil
+}
+
+// startDebugServer serves the debug endpoints of the shim on path.
+func (s *service) startDebugServer(ctx context.Context, path string) {
+	ds, err := debug.NewServer(path)
+	if err != nil {
+		log.G(ctx).WithError(err).Warn("failed to start debug server")
+		return
+	}
+	ds.Handle("/debug/io", debug.JSONHandler(func() interface{} {
+		return s.IOStats()
+	}))
+	ds.HandleSandbox(s)
+	ds.Handle("/debug/latency", debug.JSONHandler(func() interface{} {
+		return s.StartLatency()
+	}))
+	ds.HandleDiagnostics(s)
+	ds.Handle("/debug/runsc-config", s.runscConfigHandler())
+	ds.Handle("/debug/leaked-mounts", debug.JSONHandler(func() interface{} {
+		return s.LeakedMounts()
+	}))
+	ds.Handle("/debug/runsc-queue", debug.JSONHandler(func() interface{} {
+		return s.RunscQueue()
+	}))
+	ds.Handle("/debug/version", debug.JSONHandler(func() interface{} {
+		return shimversion.Get()
+	}))
+	ds.Handle("/debug/rpcs", debug.JSONHandler(func() interface{} {
+		return s.rpcs.Calls()
+	}))
+	if s.opts.SentryMetrics {
+		ds.Handle("/metrics", s.sentryMetricsHandler())
+	}
+	if failpoint.Enabled {
+		ds.Handle("/debug/failpoints/", failpoint.Handler())
+	}
+	ds.Serve(s.context)
+	s.debugServer = ds
+}
+
+// LeakedMounts reports the mounts left under the bundle of the deleted
+// task.
+func (s *service) LeakedMounts() runsctypes.LeakedMounts {
+	s.mu.Lock()
+	w := s.mountWatchdog
+	s.mu.Unlock()
+	return w.Stats()
+}
+
+// IOStats returns the io byte counters of the task and its exec processes.
+func (s *service) IOStats() []runsctypes.IOStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []runsctypes.IOStats
+	if c, ok := s.task.(proc.IOCounter); ok {
+		out = append(out, c.IOStats())
+	}
+	for _, p := range s.processes {
+		if c, ok := p.(proc.IOCounter); ok {
+			out = append(out, c.IOStats())
+		}
+	}
+	return out
+}
+
+// RunscQueue returns the time the runsc commands of the task waited for the
+// runsc limits.
+func (s *service) RunscQueue() []runsc.QueueStat {
+	p, err := s.initProcess()
+	if err != nil {
+		return nil
+	}
+	return p.Runtime().Limiter.Stats()
+}
+
+// StartLatency returns the creation and start phases of the task recorded
+// so far, nil before the task is created.
+func (s *service) StartLatency() *runsctypes.StartLatency {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ip, ok := s.task.(*proc.Init); ok {
+		return ip.StartLatency()
+	}
+	return nil
+}
+
+// cleanupOrphans removes sandboxes leaked in the namespace by shims that
+// died, e.g. after a node crash.
+func (s *service) cleanupOrphans(ns, bundle string) {
+	ctx := namespaces.WithNamespace(s.context, ns)
+	r := proc.NewRunsc(s.opts.Root, bundle, ns, s.opts.BinaryName, nil)
+	deleted, err := proc.CleanupOrphans(ctx, r)
+	if err != nil {
+		log.G(ctx).WithError(err).Warn("failed to cleanup orphaned sandboxes")
+		return
+	}
+	if len(deleted) > 0 {
+		log.G(ctx).WithField("ids", deleted).Info("cleaned up orphaned sandboxes")
+	}
+}
+
+// startSampler starts sampling the resource usage of the sandbox.
+func (s *service) startSampler(p *proc.Init) {
+	config := stats.Config{
+		Interval:        s.opts.StatsInterval.Duration,
+		MemoryThreshold: s.opts.MemoryThreshold,
+		Stream:          s.opts.StatsStream,
+		Watchdog: stats.WatchdogConfig{
+			MemoryLimit: s.opts.WatchdogMemoryLimit,
+			PidsLimit:   s.opts.WatchdogPidsLimit,
+			Grace:       s.opts.WatchdogGrace.Duration,
+		},
+	}
+	if !config.Enabled() {
+		return
+	}
+	ctx, cancel := context.WithCancel(s.context)
+	sampler := stats.NewSampler(p.ID(), p.Pid(), p.Runtime(), config, p.RecordFailures(s.publish))
+	if p.Sandbox {
+		// Subcontainers share the host cgroup of their sandbox, whose
+		// shim enforces the watchdog caps.
+		sampler.SetKiller(p.WatchdogKill)
+	}
+	s.mu.Lock()
+	s.sampler = sampler
+	s.stopSampler = cancel
+	s.mu.Unlock()
+	go sampler.Run(ctx)
+}
+
+// stopSampling stops the sampler. The last sample stays cached.
+func (s *service) stopSampling() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopSampler != nil {
+		s.stopSampler()
+		s.stopSampler = nil
 	}
 }
 
@@ -685,7 +1366,14 @@ func getTopic(e interface{}) string {
 		return runtime.TaskExecAddedEventTopic
 	case *eventstypes.TaskExecStarted:
 		return runtime.TaskExecStartedEventTopic
+	case *eventstypes.TaskPaused:
+		return runtime.TaskPausedEventTopic
+	case *eventstypes.TaskResumed:
+		return runtime.TaskResumedEventTopic
 	default:
+		if topic, ok := runsctypes.Topic(e); ok {
+			return topic
+		}
 		logrus.Warnf("no topic for type %#v", e)
 	}
 	return runtime.TaskUnknownTopic
@@ -696,7 +1384,7 @@ func newInit(ctx context.Context, path, workDir, namespace string, platform rpro
 	if err != nil {
 		return nil, errors.Wrap(err, "read oci spec")
 	}
-	userLog := runsc.FormatLogPath(r.ID, options.RunscConfig)
+	userLog := runsc.FormatLogPath(namespace, r.ID, options.RunscConfig)
 	rootfs := filepath.Join(path, "rootfs")
 	runtime := proc.NewRunsc(options.Root, path, namespace, options.BinaryName, options.RunscConfig)
 	p := proc.New(r.ID, runtime, rproc.Stdio{
@@ -712,7 +1400,96 @@ func newInit(ctx context.Context, path, workDir, namespace string, platform rpro
 	p.IoUID = int(options.IoUid)
 	p.IoGID = int(options.IoGid)
 	p.Sandbox = utils.IsSandbox(spec)
+	p.SandboxID = utils.SandboxID(spec)
 	p.UserLog = userLog
-	p.Monitor = shim.Default
 	return p, nil
 }
+
+// dryRun publishes how the container would be created instead of creating
+// it, and returns the error Create fails with.
+func (s *service) dryRun(ctx context.Context, p *proc.Init, r *proc.CreateConfig) error {
+	if p.CleanupWorkDir {
+		defer os.RemoveAll(p.WorkDir)
+	}
+	d, err := p.DryRun(r)
+	if err != nil {
+		return err
+	}
+	log.G(ctx).WithField("command", d.Command).Info("Dry run of container")
+	s.publish(d)
+	return proc.DryRunError(d)
+}
+
+// verifyRuntime checks that the runtime binary of p is runsc and supports the
+// file access flags in use. A mismatch is published as an event, and fails
+// Create in strict mode.
+func (s *service) verifyRuntime(ctx context.Context, id string, p *proc.Init, strict bool) error {
+	version, err := proc.VerifyRuntime(ctx, p.Runtime())
+	if err == nil {
+		if reason := shimversion.CheckRunsc(version); reason != "" {
+			log.G(ctx).WithField("runsc", version).Warn(reason)
+		}
+		return utils.CheckFlagReleases(p.Runtime().Flags(), version)
+	}
+	s.publish(&runsctypes.RuntimeMismatch{
+		ContainerID: id,
+		Runtime:     p.Runtime().Command,
+		Reason:      err.Error(),
+		Strict:      strict,
+		Timestamp:   time.Now(),
+	})
+	if strict {
+		return errors.Wrapf(errdefs.ErrFailedPrecondition, "runtime verification failed: %v", err)
+	}
+	log.G(ctx).WithError(err).Warn("runtime binary is not verified as runsc")
+	return nil
+}
+
+// publishRemoved publishes the files removed when the container was deleted.
+func (s *service) publishRemoved(p rproc.Process) {
+	i, ok := p.(*proc.Init)
+	if !ok || len(i.RemovedPaths()) == 0 {
+		return
+	}
+	s.publish(&runsctypes.ArtifactsRemoved{
+		ContainerID: i.ID(),
+		Paths:       i.RemovedPaths(),
+		Timestamp:   time.Now(),
+	})
+}
+
+// mountConfig returns the rootfs mount configuration of the options.
+func mountConfig(opts *options.Options) proc.MountConfig {
+	return proc.MountConfig{
+		Workers:          opts.MountWorkers,
+		UnmountRetries:   opts.UnmountRetries,
+		UnmountBackoff:   opts.UnmountBackoff.Duration,
+		LogMounts:        opts.LogMounts,
+		WatchdogInterval: opts.MountWatchdogInterval.Duration,
+	}
+}
+
+// seccompRewritten logs and publishes the changes made to the seccomp
+// profile of the container for gVisor.
+func (s *service) seccompRewritten(ctx context.Context, id string, changes []string) {
+	log.G(ctx).WithField("changes", changes).Warnf("Rewrote seccomp profile of container %q for gVisor", id)
+	s.publish(&runsctypes.SeccompRewritten{
+		ContainerID: id,
+		Changes:     changes,
+		Timestamp:   time.Now(),
+	})
+}
+
+// startSpan starts the span of a request about the exec process execID of
+// the container, or the container itself when execID is empty.
+func startSpan(ctx context.Context, name, containerID, execID string, attrs ...tracing.Attribute) (context.Context, *tracing.Span) {
+	ns, _ := namespaces.Namespace(ctx)
+	attrs = append(attrs,
+		tracing.Attr(tracing.ContainerIDKey, containerID),
+		tracing.Attr(tracing.NamespaceKey, ns),
+	)
+	if execID != "" {
+		attrs = append(attrs, tracing.Attr("exec.id", execID))
+	}
+	return tracing.Start(ctx, "shim."+name, attrs...)
+}
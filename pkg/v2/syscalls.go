@@ -0,0 +1,50 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+
+	"github.com/google/gvisor-containerd-shim/pkg/v1/proc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// startSyscallWatch publishes the unsupported syscalls logged by the
+// started sandbox until it exits.
+func (s *service) startSyscallWatch(p *proc.Init) {
+	interval := s.opts.SyscallWarningsInterval.Duration
+	if interval <= 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(s.context)
+	s.mu.Lock()
+	s.stopSyscallWatch = cancel
+	s.mu.Unlock()
+	p.WatchUnsupportedSyscalls(ctx, interval, func(e *runsctypes.UnsupportedSyscalls) {
+		s.publish(e)
+	})
+}
+
+// stopSyscallWatching stops watching for unsupported syscalls.
+func (s *service) stopSyscallWatching() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopSyscallWatch != nil {
+		s.stopSyscallWatch()
+		s.stopSyscallWatch = nil
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containerd/containerd/errdefs"
+	rproc "github.com/containerd/containerd/runtime/proc"
+	taskAPI "github.com/containerd/containerd/runtime/v2/task"
+	"github.com/containerd/typeurl"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+
+	runsc "github.com/google/gvisor-containerd-shim/pkg/go-runsc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/proc"
+	"github.com/google/gvisor-containerd-shim/pkg/v1/runsctypes"
+)
+
+// newVersionRunsc writes a runsc stand-in to dir that only reports its
+// version.
+func newVersionRunsc(t *testing.T, dir string) *runsc.Runsc {
+	path := filepath.Join(dir, "runsc")
+	script := "#!/bin/sh\necho runsc version test\n"
+	if err := ioutil.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return &runsc.Runsc{Command: path}
+}
+
+func TestUpdateMounts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "update-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	s := &service{id: "c1", processes: make(map[string]rproc.Process)}
+	update := func(mounts ...specs.Mount) error {
+		resources, err := typeurl.MarshalAny(&runsctypes.UpdateMounts{Mounts: mounts})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = s.Update(ctx, &taskAPI.UpdateTaskRequest{Resources: resources})
+		return errdefs.FromGRPC(err)
+	}
+	data := specs.Mount{Source: "/var/lib/kubelet/pods/p/volumes/data", Destination: "/data"}
+	logs := specs.Mount{Source: "/var/log/pods/p", Destination: "/logs"}
+
+	if _, err := s.Update(ctx, &taskAPI.UpdateTaskRequest{}); !errdefs.IsNotImplemented(errdefs.FromGRPC(err)) {
+		t.Errorf("resource update: got %v, want not implemented", err)
+	}
+	if err := update(data); !errdefs.IsFailedPrecondition(err) {
+		t.Errorf("update before create: got %v, want failed precondition", err)
+	}
+
+	s.task = proc.New("c1", newVersionRunsc(t, dir), rproc.Stdio{})
+	err = update(data, logs)
+	if !errdefs.IsNotImplemented(err) {
+		t.Fatalf("mount update: got %v, want not implemented", err)
+	}
+	for _, dest := range []string{"/data", "/logs"} {
+		if !strings.Contains(err.Error(), dest) {
+			t.Errorf("mount update error %q doesn't name %s", err, dest)
+		}
+	}
+	if err := update(specs.Mount{Source: "/src", Destination: "relative"}); !errdefs.IsInvalidArgument(err) {
+		t.Errorf("relative destination: got %v, want invalid argument", err)
+	}
+}
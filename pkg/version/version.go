@@ -0,0 +1,106 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package version describes the build of the shim and the runsc releases it
+// is tested with, so that node tooling can check a shim against the runsc
+// installed next to it.
+//
+// The build is stamped by the Makefile, e.g.
+//
+//	go build -ldflags "-X github.com/google/gvisor-containerd-shim/pkg/version.Revision=$(git rev-parse HEAD)"
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// Build information, set at link time.
+var (
+	// Version is the release of the shim.
+	Version = "unknown"
+	// Revision is the git commit the shim is built from.
+	Revision = "unknown"
+	// BuildDate is when the shim was built, in RFC 3339.
+	BuildDate = "unknown"
+)
+
+// ShimAPIs are the containerd shim APIs implemented by the shim binaries:
+// the v1 API of gvisor-containerd-shim and the v2 task API of
+// containerd-shim-runsc-v1.
+var ShimAPIs = []string{
+	"io.containerd.runtime.v1.linux",
+	"io.containerd.runsc.v1",
+}
+
+// The runsc releases the shim is tested with, by date: the releases CI runs
+// the shim with, see .travis.yml. Releases outside the range may work but
+// haven't been tested.
+const (
+	MinRunscRelease = "20181207"
+	MaxRunscRelease = "20181207"
+)
+
+// RunscRange is the range of runsc releases the shim is tested with.
+type RunscRange struct {
+	Min string `json:"min"`
+	Max string `json:"max"`
+}
+
+// Info describes the build of the shim.
+type Info struct {
+	Version   string     `json:"version"`
+	Revision  string     `json:"revision"`
+	BuildDate string     `json:"build_date"`
+	GoVersion string     `json:"go_version"`
+	ShimAPIs  []string   `json:"shim_apis"`
+	Runsc     RunscRange `json:"runsc"`
+}
+
+// Get returns the build information of the shim.
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Revision:  Revision,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		ShimAPIs:  append([]string(nil), ShimAPIs...),
+		Runsc: RunscRange{
+			Min: "release-" + MinRunscRelease,
+			Max: "release-" + MaxRunscRelease,
+		},
+	}
+}
+
+// CheckRunsc checks the runsc version, as reported by runsc --version,
+// against the tested range, and returns why the release is untested, or ""
+// for releases in the range. Releases outside the range may still work, so
+// this is only worth a warning. Versions other than releases, such as
+// development builds, are not checked.
+func CheckRunsc(v string) string {
+	if !strings.HasPrefix(v, "release-") {
+		return ""
+	}
+	release := strings.SplitN(strings.TrimPrefix(v, "release-"), ".", 2)[0]
+	switch {
+	case release < MinRunscRelease:
+		return fmt.Sprintf("runsc %s is older than release-%s, the oldest release the shim is tested with", v, MinRunscRelease)
+	case release > MaxRunscRelease:
+		return fmt.Sprintf("runsc %s is newer than release-%s, the newest release the shim is tested with", v, MaxRunscRelease)
+	}
+	return ""
+}